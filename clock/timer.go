@@ -1,6 +1,9 @@
 package clock
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 // Timer represents an event source triggered at a particular time.  It is the analog of time.Timer.
 type Timer interface {
@@ -22,3 +25,79 @@ func (st systemTimer) C() <-chan time.Time {
 func WrapTimer(t *time.Timer) Timer {
 	return systemTimer{t}
 }
+
+// AfterFunc waits for the duration to elapse on the given clock and then calls f in its own goroutine.  It is the
+// analog of time.AfterFunc, and the returned Timer's C method returns nil.  For System(), this is time.AfterFunc and
+// nothing waits while the timer is pending.  Any other clock has a goroutine wait on a Timer from its NewTimer, which
+// lets a fake clock drive the call.
+func AfterFunc(c Interface, d time.Duration, f func()) Timer {
+	if _, ok := c.(systemClock); ok {
+		return afterFuncTimer{time.AfterFunc(d, f)}
+	}
+
+	ft := &funcTimer{clock: c, f: f}
+	ft.arm(d)
+	return ft
+}
+
+// afterFuncTimer is a time.Timer created by time.AfterFunc, which has no channel
+type afterFuncTimer struct {
+	*time.Timer
+}
+
+func (aft afterFuncTimer) C() <-chan time.Time {
+	return nil
+}
+
+// funcTimer implements AfterFunc for clocks other than the system clock.  Each arming creates a new Timer with a
+// goroutine that waits on it, and stopping releases that goroutine.
+type funcTimer struct {
+	clock Interface
+	f     func()
+
+	lock  sync.Mutex
+	timer Timer
+	stop  chan struct{}
+}
+
+// arm must be called with the lock held, except during construction
+func (ft *funcTimer) arm(d time.Duration) {
+	ft.timer, ft.stop = ft.clock.NewTimer(d), make(chan struct{})
+	go func(timer Timer, stop <-chan struct{}) {
+		select {
+		case <-timer.C():
+			ft.f()
+		case <-stop:
+		}
+	}(ft.timer, ft.stop)
+}
+
+// disarm releases the goroutine waiting on the current Timer, if any.  It must be called with the lock held.
+func (ft *funcTimer) disarm() bool {
+	if ft.stop == nil {
+		return false
+	}
+
+	close(ft.stop)
+	ft.stop = nil
+	return ft.timer.Stop()
+}
+
+func (ft *funcTimer) C() <-chan time.Time {
+	return nil
+}
+
+func (ft *funcTimer) Reset(d time.Duration) bool {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	active := ft.disarm()
+	ft.arm(d)
+	return active
+}
+
+func (ft *funcTimer) Stop() bool {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	return ft.disarm()
+}
@@ -0,0 +1,35 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/clock"
+	"github.com/Comcast/webpa-common/logging"
+)
+
+// sampleActivity observes the time since each device's last activity on every tick, and exits
+// once this manager is closed
+func (m *manager) sampleActivity(ticker clock.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.lifecycle.closed:
+			return
+		case <-ticker.C():
+			m.observeActivity()
+		}
+	}
+}
+
+// observeActivity takes a single sample for the LastActivityHistogram.  The registry's lock is only
+// held while the devices are copied, so a large registry does not stall connects and disconnects.
+func (m *manager) observeActivity() {
+	var (
+		now     = m.now()
+		devices = m.devices.snapshot()
+	)
+
+	for _, d := range devices {
+		m.measures.LastActivity.Observe(now.Sub(d.statistics.LastActivity()).Seconds())
+	}
+
+	m.debugLog.Log(logging.MessageKey(), "sampled device activity", "count", len(devices))
+}
@@ -0,0 +1,121 @@
+package device
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/clock/clocktest"
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHistogram is a metrics.Histogram that retains every observation
+type recordingHistogram struct {
+	lock         sync.Mutex
+	observations []float64
+}
+
+func (rh *recordingHistogram) With(...string) metrics.Histogram {
+	return rh
+}
+
+func (rh *recordingHistogram) Observe(value float64) {
+	rh.lock.Lock()
+	rh.observations = append(rh.observations, value)
+	rh.lock.Unlock()
+}
+
+func (rh *recordingHistogram) values() []float64 {
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	return append([]float64(nil), rh.observations...)
+}
+
+func testManagerObserveActivity(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		now       = time.Now()
+		histogram = new(recordingHistogram)
+
+		m       = NewManager(&Options{DuplicatePolicy: DuplicateAllowBoth, Now: func() time.Time { return now }}).(*manager)
+		current = func() time.Time { return now }
+
+		quiet     = newDevice(deviceOptions{ID: testDeviceIDs[0], ConnectedAt: now.Add(-100 * time.Second), Now: current})
+		duplicate = newDevice(deviceOptions{ID: testDeviceIDs[0], ConnectedAt: now.Add(-90 * time.Second), Now: current})
+		active    = newDevice(deviceOptions{ID: testDeviceIDs[1], ConnectedAt: now.Add(-time.Hour), Now: current})
+	)
+
+	m.measures.LastActivity = histogram
+	for _, d := range []*device{quiet, duplicate, active} {
+		require.NoError(m.devices.add(d))
+	}
+
+	// a message in either direction counts as activity
+	now = now.Add(-5 * time.Second)
+	active.statistics.AddMessagesReceived(1)
+	now = now.Add(5 * time.Second)
+
+	m.observeActivity()
+	assert.ElementsMatch([]float64{100, 90, 5}, histogram.values())
+
+	now = now.Add(10 * time.Second)
+	active.statistics.AddMessagesSent(1)
+	now = now.Add(2 * time.Second)
+
+	m.observeActivity()
+	assert.ElementsMatch([]float64{100, 90, 5, 112, 102, 2}, histogram.values())
+}
+
+func testManagerSampleActivity(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		fakeClock = newManagerClock(DefaultRateWindow)
+		ticker    = new(clocktest.MockTicker)
+		ticks     = make(chan time.Time)
+		stopped   = make(chan struct{})
+		histogram = new(recordingHistogram)
+	)
+
+	fakeClock.OnNewTicker(time.Minute, ticker).Once()
+	ticker.OnC((<-chan time.Time)(ticks))
+	ticker.OnStop().Once().Run(func(mock.Arguments) { close(stopped) })
+
+	m := NewManager(&Options{Clock: fakeClock, Now: time.Now, ActivitySampleInterval: time.Minute}).(*manager)
+	m.measures.LastActivity = histogram
+	m.devices.add(newDevice(deviceOptions{ID: testDeviceIDs[0], Now: m.now}))
+
+	ticks <- time.Now()
+	ticks <- time.Now()
+	for len(histogram.values()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the sampler exits, stopping its ticker, when the manager is closed
+	m.Close()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the activity sampler did not stop its ticker")
+	}
+
+	fakeClock.AssertExpectations(t)
+	ticker.AssertExpectations(t)
+}
+
+func testManagerSampleActivityDisabled(t *testing.T) {
+	fakeClock := newManagerClock(DefaultRateWindow)
+	NewManager(&Options{Clock: fakeClock, Now: time.Now})
+
+	// without an interval, the only ticker created is the one that refreshes throughput gauges
+	fakeClock.AssertNumberOfCalls(t, "NewTicker", 1)
+}
+
+func TestManagerActivity(t *testing.T) {
+	t.Run("Observe", testManagerObserveActivity)
+	t.Run("Sample", testManagerSampleActivity)
+	t.Run("Disabled", testManagerSampleActivityDisabled)
+}
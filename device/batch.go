@@ -0,0 +1,120 @@
+package device
+
+import (
+	"time"
+
+	"github.com/Comcast/webpa-common/xmetrics"
+)
+
+// metricsBatch buffers the contributions of a single pump goroutine to metrics shared by every device, such as
+// manager-wide throughput and counters, and periodically flushes them.  This replaces a synchronized update on the
+// shared metric per frame with one per flush interval per pump.
+//
+// A metricsBatch is owned by exactly one goroutine and is not safe for concurrent use.  Buffered deltas are flushed
+// when the pump records a delta after the interval has elapsed since the previous flush, and when the pump exits.
+// Consequently, a shared metric lags by at most one interval's worth of each active pump's deltas, while deltas
+// buffered by a pump that has gone quiet are held until its next frame or until it exits.
+//
+// A nil *metricsBatch is valid, and updates metrics immediately.
+type metricsBatch struct {
+	interval  time.Duration
+	now       func() time.Time
+	nextFlush time.Time
+
+	adders []*batchedAdder
+	rates  []*batchedRate
+}
+
+// newMetricsBatch creates a metricsBatch with the given flush interval.  If the interval is nonpositive,
+// batching is disabled and this function returns nil.
+func newMetricsBatch(interval time.Duration, now func() time.Time) *metricsBatch {
+	if interval <= 0 {
+		return nil
+	}
+
+	return &metricsBatch{
+		interval:  interval,
+		now:       now,
+		nextFlush: now().Add(interval),
+	}
+}
+
+// adder returns an xmetrics.Adder whose deltas are buffered in this batch before being added to a
+func (mb *metricsBatch) adder(a xmetrics.Adder) xmetrics.Adder {
+	if mb == nil {
+		return a
+	}
+
+	ba := &batchedAdder{batch: mb, adder: a}
+	mb.adders = append(mb.adders, ba)
+	return ba
+}
+
+// rate returns a Rate whose units are buffered in this batch before being added to r
+func (mb *metricsBatch) rate(r Rate) Rate {
+	if mb == nil {
+		return r
+	}
+
+	br := &batchedRate{batch: mb, rate: r}
+	mb.rates = append(mb.rates, br)
+	return br
+}
+
+// recorded is invoked each time a delta is buffered, and flushes this batch if the interval has elapsed
+func (mb *metricsBatch) recorded() {
+	if current := mb.now(); !current.Before(mb.nextFlush) {
+		mb.flush()
+		mb.nextFlush = current.Add(mb.interval)
+	}
+}
+
+// flush applies all buffered deltas to their shared metrics
+func (mb *metricsBatch) flush() {
+	if mb == nil {
+		return
+	}
+
+	for _, ba := range mb.adders {
+		if ba.pending != 0.0 {
+			ba.adder.Add(ba.pending)
+			ba.pending = 0.0
+		}
+	}
+
+	for _, br := range mb.rates {
+		if br.pending != 0 {
+			br.rate.Add(br.pending)
+			br.pending = 0
+		}
+	}
+}
+
+// batchedAdder is an xmetrics.Adder that buffers deltas within a metricsBatch
+type batchedAdder struct {
+	batch   *metricsBatch
+	adder   xmetrics.Adder
+	pending float64
+}
+
+func (ba *batchedAdder) Add(delta float64) {
+	ba.pending += delta
+	ba.batch.recorded()
+}
+
+// batchedRate is a Rate that buffers units within a metricsBatch.  Its Value is that of the shared Rate,
+// and so does not reflect any buffered units.
+type batchedRate struct {
+	batch   *metricsBatch
+	rate    Rate
+	pending int
+}
+
+func (br *batchedRate) Add(n int) {
+	br.pending += n
+	br.batch.recorded()
+}
+
+func (br *batchedRate) Value() float64 {
+	return br.rate.Value()
+}
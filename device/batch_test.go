@@ -0,0 +1,138 @@
+package device
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRate is a Rate that records each Add, for verifying when batched units are flushed
+type countingRate struct {
+	adds []int
+}
+
+func (cr *countingRate) Add(n int) {
+	cr.adds = append(cr.adds, n)
+}
+
+func (cr *countingRate) Value() float64 {
+	return 123.0
+}
+
+func testMetricsBatchDisabled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		counter = xmetricstest.NewCounter("test")
+		rate    = new(countingRate)
+	)
+
+	for _, interval := range []time.Duration{0, -1} {
+		mb := newMetricsBatch(interval, time.Now)
+		assert.Nil(mb)
+		assert.True(counter == mb.adder(counter))
+		assert.True(rate == mb.rate(rate))
+		mb.flush()
+	}
+}
+
+func testMetricsBatchInterval(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		now     = time.Now()
+		counter = xmetricstest.NewCounter("test")
+		rate    = new(countingRate)
+
+		mb = newMetricsBatch(time.Second, func() time.Time { return now })
+		a  = mb.adder(counter)
+		r  = mb.rate(rate)
+	)
+
+	a.Add(1.0)
+	a.Add(2.0)
+	r.Add(100)
+	r.Add(50)
+	assert.Zero(counter.(xmetrics.Valuer).Value())
+	assert.Empty(rate.adds)
+	assert.Equal(123.0, r.Value())
+
+	// once the interval elapses, the next delta flushes everything buffered
+	now = now.Add(time.Second)
+	a.Add(3.0)
+	assert.Equal(6.0, counter.(xmetrics.Valuer).Value())
+	assert.Equal([]int{150}, rate.adds)
+
+	now = now.Add(500 * time.Millisecond)
+	r.Add(25)
+	assert.Equal([]int{150}, rate.adds)
+
+	mb.flush()
+	assert.Equal(6.0, counter.(xmetrics.Valuer).Value())
+	assert.Equal([]int{150, 25}, rate.adds)
+
+	// flushing with nothing buffered does not touch the shared metrics
+	mb.flush()
+	assert.Equal([]int{150, 25}, rate.adds)
+}
+
+func TestMetricsBatch(t *testing.T) {
+	t.Run("Disabled", testMetricsBatchDisabled)
+	t.Run("Interval", testMetricsBatchInterval)
+}
+
+func TestManagerMetricsFlushInterval(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		p       = xmetricstest.NewProvider(nil, Metrics)
+
+		m = NewManager(&Options{
+			MetricsProvider:      p,
+			MetricsFlushInterval: time.Hour,
+		})
+
+		id      = testDeviceIDs[0]
+		counter = p.NewCounter(RequestResponseCounter).(xmetrics.Valuer)
+		pumps   = p.NewGauge(PumpGoroutinesGauge).(xmetrics.Valuer)
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	_, err = lp.Connect(httptest.NewRecorder(), WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	require.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		response := httptest.NewRecorder()
+		lp.ServeHTTP(
+			response,
+			WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", bytes.NewReader(wrp.MustEncode(
+				&wrp.Message{
+					Type:            wrp.SimpleRequestResponseMessageType,
+					Source:          string(id),
+					Destination:     "dns:test",
+					TransactionUUID: "unsolicited",
+				},
+				wrp.Msgpack,
+			)))),
+		)
+
+		require.Equal(http.StatusAccepted, response.Code)
+	}
+
+	// nothing is flushed until the read pump exits
+	assert.Zero(counter.Value())
+	assert.Equal(1, m.DisconnectAll())
+	for i := 0; i < 100 && pumps.Value() > 0.0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(3.0, counter.Value())
+}
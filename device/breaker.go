@@ -0,0 +1,155 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Comcast/webpa-common/xmetrics"
+)
+
+// DefaultCircuitBreakerCooldown is the period a device's circuit stays open when
+// Options.CircuitBreakerCooldown is unset
+const DefaultCircuitBreakerCooldown = 30 * time.Second
+
+// CircuitState describes the state of a device's circuit breaker, which guards transactions routed to that device
+type CircuitState int
+
+const (
+	// CircuitClosed is the normal state, in which transactions are routed to the device
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen indicates that the device has timed out too many consecutive transactions.  Transactions
+	// routed to the device fail fast with ErrorDeviceCircuitOpen until the cooldown elapses.
+	CircuitOpen
+
+	// CircuitHalfOpen indicates that the cooldown has elapsed, and that a single probe transaction is
+	// allowed through.  A response closes the circuit, while another timeout opens it again.
+	CircuitHalfOpen
+
+	InvalidCircuitStateString = "!!INVALID CIRCUIT STATE!!"
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return InvalidCircuitStateString
+	}
+}
+
+// circuitBreaker tracks consecutive transaction timeouts for a single device.  A nil circuitBreaker
+// is disabled:  it allows everything and its state is always CircuitClosed.
+type circuitBreaker struct {
+	lock      sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	opened   xmetrics.Incrementer
+	rejected xmetrics.Incrementer
+}
+
+// newCircuitBreaker creates a circuitBreaker that opens after threshold consecutive timeouts.
+// If threshold is nonpositive, this function returns nil.
+func newCircuitBreaker(threshold int, cooldown time.Duration, now func() time.Time, measures Measures) *circuitBreaker {
+	if threshold < 1 {
+		return nil
+	}
+
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       now,
+		opened:    measures.CircuitOpened,
+		rejected:  measures.CircuitRejected,
+	}
+}
+
+// currentState returns the state of this breaker, transitioning from open to half-open if the cooldown has
+// elapsed.  This method must be invoked under the lock.
+func (cb *circuitBreaker) currentState() CircuitState {
+	if cb.state == CircuitOpen && !cb.now().Before(cb.openedAt.Add(cb.cooldown)) {
+		cb.state = CircuitHalfOpen
+		cb.probing = false
+	}
+
+	return cb.state
+}
+
+func (cb *circuitBreaker) circuitState() CircuitState {
+	if cb == nil {
+		return CircuitClosed
+	}
+
+	defer cb.lock.Unlock()
+	cb.lock.Lock()
+	return cb.currentState()
+}
+
+// allow determines if a transaction may proceed, returning ErrorDeviceCircuitOpen if not.  When half-open,
+// only one transaction at a time is allowed through as a probe.
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+
+	cb.lock.Lock()
+	switch cb.currentState() {
+	case CircuitClosed:
+		cb.lock.Unlock()
+		return nil
+
+	case CircuitHalfOpen:
+		if !cb.probing {
+			cb.probing = true
+			cb.lock.Unlock()
+			return nil
+		}
+	}
+
+	cb.lock.Unlock()
+	cb.rejected.Inc()
+	return ErrorDeviceCircuitOpen
+}
+
+// record updates this breaker with the result of an allowed transaction.  Only timeouts count as failures,
+// as other errors such as a closed device say nothing about whether the device is responsive.
+func (cb *circuitBreaker) record(err error) {
+	if cb == nil {
+		return
+	}
+
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch {
+	case err == nil:
+		cb.state = CircuitClosed
+		cb.failures = 0
+		cb.probing = false
+
+	case err == context.DeadlineExceeded:
+		cb.failures++
+		if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+			cb.state = CircuitOpen
+			cb.openedAt = cb.now()
+			cb.probing = false
+			cb.opened.Inc()
+		}
+
+	default:
+		// an inconclusive probe allows another probe
+		cb.probing = false
+	}
+}
@@ -0,0 +1,143 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitStateString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("closed", CircuitClosed.String())
+	assert.Equal("open", CircuitOpen.String())
+	assert.Equal("half-open", CircuitHalfOpen.String())
+	assert.Equal(InvalidCircuitStateString, CircuitState(-1).String())
+}
+
+func testCircuitBreakerDisabled(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cb     = newCircuitBreaker(0, time.Minute, time.Now, NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	)
+
+	assert.Nil(cb)
+	assert.NoError(cb.allow())
+	cb.record(context.DeadlineExceeded)
+	assert.Equal(CircuitClosed, cb.circuitState())
+}
+
+func testCircuitBreakerLifecycle(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		provider = xmetricstest.NewProvider(nil, Metrics)
+		now      = time.Now()
+		cb       = newCircuitBreaker(2, time.Minute, func() time.Time { return now }, NewMeasures(provider))
+	)
+
+	// a success between timeouts resets the count
+	assert.NoError(cb.allow())
+	cb.record(context.DeadlineExceeded)
+	cb.record(nil)
+	cb.record(context.DeadlineExceeded)
+	assert.Equal(CircuitClosed, cb.circuitState())
+
+	// other errors do not count as failures
+	cb.record(errors.New("expected"))
+	assert.Equal(CircuitClosed, cb.circuitState())
+
+	cb.record(context.DeadlineExceeded)
+	assert.Equal(CircuitOpen, cb.circuitState())
+	assert.Equal(ErrorDeviceCircuitOpen, cb.allow())
+	provider.Assert(t, CircuitOpenedCounter)(xmetricstest.Value(1.0))
+	provider.Assert(t, CircuitRejectedCounter)(xmetricstest.Value(1.0))
+
+	// after the cooldown, only one probe is allowed at a time
+	now = now.Add(time.Minute)
+	assert.Equal(CircuitHalfOpen, cb.circuitState())
+	assert.NoError(cb.allow())
+	assert.Equal(ErrorDeviceCircuitOpen, cb.allow())
+
+	// an inconclusive probe allows another probe
+	cb.record(ErrorDeviceClosed)
+	assert.Equal(CircuitHalfOpen, cb.circuitState())
+	assert.NoError(cb.allow())
+
+	// a failed probe opens the circuit again, restarting the cooldown
+	cb.record(context.DeadlineExceeded)
+	assert.Equal(CircuitOpen, cb.circuitState())
+	provider.Assert(t, CircuitOpenedCounter)(xmetricstest.Value(2.0))
+	now = now.Add(time.Minute - time.Second)
+	assert.Equal(ErrorDeviceCircuitOpen, cb.allow())
+
+	// a successful probe closes the circuit
+	now = now.Add(time.Second)
+	assert.NoError(cb.allow())
+	cb.record(nil)
+	assert.Equal(CircuitClosed, cb.circuitState())
+	assert.NoError(cb.allow())
+	assert.NoError(cb.allow())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("Disabled", testCircuitBreakerDisabled)
+	t.Run("Lifecycle", testCircuitBreakerLifecycle)
+}
+
+func TestManagerCircuitBreaker(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		now     = time.Now()
+
+		m = NewManager(&Options{
+			CircuitBreakerThreshold: 2,
+			CircuitBreakerCooldown:  time.Minute,
+			Now:                     func() time.Time { return now },
+		}).(*manager)
+
+		route = func(transactionUUID string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err := m.Route((&Request{
+				Message: &wrp.Message{
+					Type:            wrp.SimpleRequestResponseMessageType,
+					Source:          "dns:test",
+					Destination:     string(testDeviceIDs[0]),
+					TransactionUUID: transactionUUID,
+				},
+			}).WithContext(ctx))
+
+			return err
+		}
+	)
+
+	// without pumps, nothing is ever written, so every transaction times out
+	d, cvy, err := m.newDevice(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "http://localhost.com", nil)))
+	require.NoError(err)
+	require.NoError(m.register(d, cvy))
+
+	assert.Equal(context.DeadlineExceeded, route("1"))
+	assert.Equal(CircuitClosed, d.CircuitState())
+	assert.Equal(context.DeadlineExceeded, route("2"))
+	assert.Equal(CircuitOpen, d.CircuitState())
+
+	// transactions fail fast, but the device stays connected
+	assert.Equal(ErrorDeviceCircuitOpen, route("3"))
+	assert.False(d.Closed())
+
+	now = now.Add(time.Minute)
+	assert.Equal(CircuitHalfOpen, d.CircuitState())
+	assert.Equal(context.DeadlineExceeded, route("4"))
+	assert.Equal(CircuitOpen, d.CircuitState())
+
+	m.DisconnectAll()
+}
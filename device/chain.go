@@ -0,0 +1,173 @@
+package device
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// chainLocks serializes the transactions within each chain routed to a single device.  A chain is held from
+// the time one of its requests is routed until that request's transaction completes or fails, so a chain never
+// has more than one transaction outstanding.  Waiters are granted the chain in the order they arrived.
+//
+// The zero value is ready to use.
+type chainLocks struct {
+	lock   sync.Mutex
+	chains map[string]*chainLock
+}
+
+// chainLock is the state of a single held chain.  Each waiter is signaled by closing its channel, at which
+// point it holds the chain.
+type chainLock struct {
+	waiters []chan struct{}
+}
+
+// acquire blocks until the given chain is held by the caller or the context is done.  On success, the
+// returned function must be invoked exactly once to release the chain.
+func (cl *chainLocks) acquire(ctx context.Context, chainID string) (func(), error) {
+	cl.lock.Lock()
+	if cl.chains == nil {
+		cl.chains = make(map[string]*chainLock)
+	}
+
+	held, ok := cl.chains[chainID]
+	if !ok {
+		cl.chains[chainID] = new(chainLock)
+		cl.lock.Unlock()
+		return func() { cl.release(chainID) }, nil
+	}
+
+	turn := make(chan struct{})
+	held.waiters = append(held.waiters, turn)
+	cl.lock.Unlock()
+
+	select {
+	case <-turn:
+		return func() { cl.release(chainID) }, nil
+
+	case <-ctx.Done():
+		cl.lock.Lock()
+		defer cl.lock.Unlock()
+
+		select {
+		case <-turn:
+			// the chain was handed to this waiter just as it gave up, so pass it along
+			cl.releaseLocked(chainID)
+
+		default:
+			for i, waiter := range held.waiters {
+				if waiter == turn {
+					held.waiters = append(held.waiters[:i], held.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+func (cl *chainLocks) release(chainID string) {
+	cl.lock.Lock()
+	cl.releaseLocked(chainID)
+	cl.lock.Unlock()
+}
+
+// releaseLocked hands the chain to its next waiter, or forgets the chain when nobody is waiting.  The
+// caller must hold the lock.
+func (cl *chainLocks) releaseLocked(chainID string) {
+	held := cl.chains[chainID]
+	if len(held.waiters) == 0 {
+		delete(cl.chains, chainID)
+		return
+	}
+
+	next := held.waiters[0]
+	held.waiters = held.waiters[1:]
+	close(next)
+}
+
+// chainOf returns the chain ID of a request, which is empty unless the request carries a *wrp.Message
+// that is part of a chain
+func chainOf(request *Request) string {
+	if message, ok := request.Message.(*wrp.Message); ok && message.IsChainPart() {
+		return message.ChainID
+	}
+
+	return ""
+}
+
+// Chain sends a sequence of requests to a single device as one chain, in order.  Each request must hold a
+// *wrp.Message with a transaction UUID, and every request must be destined for the same device as the first.
+// The given chainID is set on each message, replacing any chain ID it had, and any precomputed Contents are
+// discarded so that every message is encoded with its chain ID.
+//
+// The whole sequence holds the chain, so requests routed with the same chain ID through Route or RouteToSession
+// wait until the sequence is finished.  A request is not written until the previous request's response has
+// arrived, and the sequence stops at the first failure.  The returned responses are those received before any
+// error, in the order of the requests.  The given context bounds the wait for the chain, while each request's
+// own context governs its transaction.
+//
+// All the requests go to the device session chosen for the first request.  If that session disconnects, the
+// rest of the sequence fails rather than continuing on a new session.
+func (m *manager) Chain(ctx context.Context, chainID string, requests ...*Request) ([]*Response, error) {
+	if len(chainID) == 0 || len(requests) == 0 {
+		return nil, ErrorInvalidChain
+	}
+
+	var destination ID
+	for i, request := range requests {
+		message, ok := request.Message.(*wrp.Message)
+		if !ok || !message.IsTransactionPart() {
+			return nil, ErrorInvalidChain
+		}
+
+		id, err := request.ID()
+		if err != nil {
+			return nil, err
+		}
+
+		if id, err = m.idNormalizer(id); err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			destination = id
+		} else if id != destination {
+			return nil, ErrorInvalidChain
+		}
+	}
+
+	d, ok := m.devices.route(destination)
+	if !ok {
+		return nil, ErrorDeviceNotFound
+	}
+
+	release, err := d.chains.acquire(ctx, chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+	responses := make([]*Response, 0, len(requests))
+	for _, request := range requests {
+		var (
+			chained        = *request
+			chainedMessage = *request.Message.(*wrp.Message)
+		)
+
+		chainedMessage.ChainID = chainID
+		chained.Message = &chainedMessage
+		chained.Contents = nil
+
+		response, err := m.routeUnchained(d, &chained)
+		if err != nil {
+			return responses, err
+		}
+
+		responses = append(responses, response)
+	}
+
+	return responses, nil
+}
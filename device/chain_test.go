@@ -0,0 +1,258 @@
+package device
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testChainLocksOrder(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		locks   chainLocks
+		order   = make(chan int, 3)
+	)
+
+	release, err := locks.acquire(context.Background(), "chain")
+	require.NoError(err)
+
+	// a different chain is independent
+	other, err := locks.acquire(context.Background(), "other")
+	require.NoError(err)
+	other()
+
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			release, err := locks.acquire(context.Background(), "chain")
+			if err == nil {
+				order <- i
+				release()
+			}
+		}(i)
+
+		// wait for each waiter to queue, so that the expected order is known
+		for {
+			locks.lock.Lock()
+			waiting := len(locks.chains["chain"].waiters)
+			locks.lock.Unlock()
+			if waiting > i {
+				break
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	assert.Empty(order)
+	release()
+	for i := 0; i < 3; i++ {
+		assert.Equal(i, <-order)
+	}
+
+	locks.lock.Lock()
+	assert.Empty(locks.chains)
+	locks.lock.Unlock()
+}
+
+func testChainLocksCancel(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		locks       chainLocks
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	)
+
+	defer cancel()
+	release, err := locks.acquire(context.Background(), "chain")
+	require.NoError(err)
+
+	abandoned, err := locks.acquire(ctx, "chain")
+	assert.Nil(abandoned)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	// the waiter that gave up is not handed the chain
+	release()
+	release, err = locks.acquire(context.Background(), "chain")
+	require.NoError(err)
+	release()
+
+	locks.lock.Lock()
+	assert.Empty(locks.chains)
+	locks.lock.Unlock()
+}
+
+func TestChainLocks(t *testing.T) {
+	t.Run("Order", testChainLocksOrder)
+	t.Run("Cancel", testChainLocksCancel)
+}
+
+func testManagerChainSuccess(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		options = &Options{
+			Logger: logging.NewTestLogger(nil, t),
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the simulated device answers each request, echoing its chain ID
+	received := make(chan wrp.Message, 3)
+	go func() {
+		for {
+			_, data, err := connection.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var message wrp.Message
+			if wrp.NewDecoderBytes(data, wrp.Msgpack).Decode(&message) != nil {
+				return
+			}
+
+			received <- message
+			connection.WriteMessage(
+				websocket.BinaryMessage,
+				wrp.MustEncode(
+					&wrp.Message{
+						Type:            wrp.SimpleRequestResponseMessageType,
+						Source:          message.Destination,
+						Destination:     message.Source,
+						TransactionUUID: message.TransactionUUID,
+						ChainID:         message.ChainID,
+						Payload:         message.Payload,
+					},
+					wrp.Msgpack,
+				),
+			)
+		}
+	}()
+
+	var requests []*Request
+	for i := 0; i < 3; i++ {
+		message := &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "config-sync",
+			Destination:     string(testDeviceIDs[0]),
+			TransactionUUID: "transaction-" + strconv.Itoa(i),
+			Payload:         []byte(strconv.Itoa(i)),
+		}
+
+		request := &Request{Message: message, Format: wrp.Msgpack}
+		require.NoError(wrp.NewEncoderBytes(&request.Contents, wrp.Msgpack).Encode(message))
+		requests = append(requests, request)
+	}
+
+	responses, err := manager.Chain(context.Background(), "config-sync", requests...)
+	require.NoError(err)
+	require.Len(responses, 3)
+	for i, response := range responses {
+		assert.Equal("transaction-"+strconv.Itoa(i), response.Message.TransactionUUID)
+		assert.Equal("config-sync", response.Message.ChainID)
+
+		actual := <-received
+		assert.Equal("transaction-"+strconv.Itoa(i), actual.TransactionUUID)
+		assert.Equal("config-sync", actual.ChainID)
+	}
+
+	// the caller's messages are unmodified
+	for _, request := range requests {
+		assert.Empty(request.Message.(*wrp.Message).ChainID)
+	}
+}
+
+func testManagerChainInvalid(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		m      = NewManager(nil)
+
+		transaction = func(destination ID) *Request {
+			return &Request{
+				Message: &wrp.Message{
+					Type:            wrp.SimpleRequestResponseMessageType,
+					Destination:     string(destination),
+					TransactionUUID: "1234",
+				},
+			}
+		}
+	)
+
+	_, err := m.Chain(context.Background(), "", transaction(testDeviceIDs[0]))
+	assert.Equal(ErrorInvalidChain, err)
+
+	_, err = m.Chain(context.Background(), "chain")
+	assert.Equal(ErrorInvalidChain, err)
+
+	_, err = m.Chain(context.Background(), "chain", &Request{Message: &wrp.SimpleEvent{Destination: string(testDeviceIDs[0])}})
+	assert.Equal(ErrorInvalidChain, err)
+
+	_, err = m.Chain(context.Background(), "chain", transaction(testDeviceIDs[0]), transaction(testDeviceIDs[1]))
+	assert.Equal(ErrorInvalidChain, err)
+
+	_, err = m.Chain(context.Background(), "chain", transaction(testDeviceIDs[0]))
+	assert.Equal(ErrorDeviceNotFound, err)
+}
+
+func testManagerChainRouteWaits(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		m, d, c = startTestPumps(t, nil)
+
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	)
+
+	defer cancel()
+
+	release, err := d.chains.acquire(context.Background(), "chain")
+	require.NoError(err)
+	defer release()
+
+	// while the chain is held, a request in that chain is never written
+	response, err := m.Route(
+		(&Request{
+			Message: &wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Destination:     string(d.ID()),
+				TransactionUUID: "1234",
+				ChainID:         "chain",
+			},
+		}).WithContext(ctx),
+	)
+
+	assert.Nil(response)
+	assert.Equal(context.DeadlineExceeded, err)
+	select {
+	case <-c.outbound:
+		assert.Fail("a request was written while its chain was held")
+	default:
+	}
+
+	m.DisconnectAll()
+}
+
+func TestManagerChain(t *testing.T) {
+	t.Run("Success", testManagerChainSuccess)
+	t.Run("Invalid", testManagerChainInvalid)
+	t.Run("RouteWaits", testManagerChainRouteWaits)
+}
@@ -0,0 +1,131 @@
+package device
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Comcast/webpa-common/xmetrics"
+)
+
+// DefaultChannelListenerBufferSize is the default capacity of a ChannelListener's buffer
+const DefaultChannelListenerBufferSize = 100
+
+// ChannelListenerOptions configures a ChannelListener
+type ChannelListenerOptions struct {
+	// BufferSize is the capacity of the channel that holds events.  If not supplied,
+	// DefaultChannelListenerBufferSize is used.
+	BufferSize int
+
+	// Overflow determines what happens to an event that arrives when the buffer is full.  If not supplied,
+	// OverflowBlock is used.
+	Overflow OverflowPolicy
+
+	// Timeout limits how long OverflowBlock waits for room in the buffer, after which the event is dropped.
+	// If nonpositive, OverflowBlock waits indefinitely, which stalls whatever goroutine dispatched the event.
+	// This field is ignored by the other policies.
+	Timeout time.Duration
+
+	// Dropped is an optional metric that is incremented for each dropped event, in addition to the count
+	// reported by ChannelListener.Dropped
+	Dropped xmetrics.Incrementer
+}
+
+// ChannelListener delivers events to a buffered channel, so that they can be consumed on another goroutine.
+// Each event in the channel is a copy, which the consumer may retain.  Register the OnEvent method as a Listener.
+//
+// When the buffer is full, the configured OverflowPolicy decides which event, if any, is lost.  Every lost event
+// is counted, so consumers can tell when they are falling behind rather than silently missing events.
+type ChannelListener struct {
+	events   chan *Event
+	overflow OverflowPolicy
+	timeout  time.Duration
+	metric   xmetrics.Incrementer
+	dropped  uint64
+}
+
+// NewChannelListener creates a ChannelListener from a set of options, which may be nil
+func NewChannelListener(o *ChannelListenerOptions) *ChannelListener {
+	cl := &ChannelListener{
+		overflow: OverflowBlock,
+	}
+
+	size := DefaultChannelListenerBufferSize
+	if o != nil {
+		if o.BufferSize > 0 {
+			size = o.BufferSize
+		}
+
+		if len(o.Overflow) > 0 {
+			cl.overflow = o.Overflow
+		}
+
+		cl.timeout = o.Timeout
+		cl.metric = o.Dropped
+	}
+
+	cl.events = make(chan *Event, size)
+	return cl
+}
+
+// Events returns the channel to which events are delivered.  This channel is never closed.
+func (cl *ChannelListener) Events() <-chan *Event {
+	return cl.events
+}
+
+// Dropped returns the total number of events lost because the buffer was full
+func (cl *ChannelListener) Dropped() uint64 {
+	return atomic.LoadUint64(&cl.dropped)
+}
+
+// OnEvent is the Listener that buffers a copy of each event, honoring the overflow policy
+func (cl *ChannelListener) OnEvent(e *Event) {
+	c := copyEvent(e)
+	select {
+	case cl.events <- c:
+		return
+	default:
+	}
+
+	switch cl.overflow {
+	case OverflowDropNewest:
+		cl.drop()
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-cl.events:
+				cl.drop()
+			default:
+				// the consumer made room in the meantime
+			}
+
+			select {
+			case cl.events <- c:
+				return
+			default:
+			}
+		}
+
+	default:
+		if cl.timeout <= 0 {
+			cl.events <- c
+			return
+		}
+
+		timer := time.NewTimer(cl.timeout)
+		defer timer.Stop()
+
+		select {
+		case cl.events <- c:
+		case <-timer.C:
+			cl.drop()
+		}
+	}
+}
+
+func (cl *ChannelListener) drop() {
+	atomic.AddUint64(&cl.dropped, 1)
+	if cl.metric != nil {
+		cl.metric.Inc()
+	}
+}
@@ -0,0 +1,145 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fillChannelListener sends events with distinct contents to a ChannelListener, returning them in order
+func fillChannelListener(cl *ChannelListener, count int) []*Event {
+	events := make([]*Event, count)
+	for i := range events {
+		events[i] = &Event{Type: MessageReceived, Contents: []byte{byte(i)}}
+		cl.OnEvent(events[i])
+	}
+
+	return events
+}
+
+// receivedContents drains a ChannelListener's buffer, returning the contents of each event
+func receivedContents(cl *ChannelListener) []byte {
+	var contents []byte
+	for {
+		select {
+		case e := <-cl.Events():
+			contents = append(contents, e.Contents...)
+		default:
+			return contents
+		}
+	}
+}
+
+func testChannelListenerDefaults(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		cl      = NewChannelListener(nil)
+		e       = &Event{Type: MessageReceived, Contents: []byte("contents")}
+	)
+
+	assert.Equal(DefaultChannelListenerBufferSize, cap(cl.events))
+	assert.Equal(OverflowBlock, cl.overflow)
+
+	cl.OnEvent(e)
+	received := <-cl.Events()
+	require.NotNil(received)
+
+	// each buffered event is a copy that survives the listener invocation
+	assert.False(received == e)
+	e.Contents[0] = 'X'
+	assert.Equal("contents", string(received.Contents))
+	assert.Zero(cl.Dropped())
+}
+
+func testChannelListenerDropNewest(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		provider = xmetricstest.NewProvider(nil, Metrics)
+		cl       = NewChannelListener(&ChannelListenerOptions{
+			BufferSize: 3,
+			Overflow:   OverflowDropNewest,
+			Dropped:    NewMeasures(provider).DroppedEvents,
+		})
+	)
+
+	fillChannelListener(cl, 5)
+	assert.Equal(uint64(2), cl.Dropped())
+	provider.Assert(t, DroppedEventCounter)(xmetricstest.Value(2.0))
+	assert.Equal([]byte{0, 1, 2}, receivedContents(cl))
+}
+
+func testChannelListenerDropOldest(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cl     = NewChannelListener(&ChannelListenerOptions{BufferSize: 3, Overflow: OverflowDropOldest})
+	)
+
+	fillChannelListener(cl, 5)
+	assert.Equal(uint64(2), cl.Dropped())
+	assert.Equal([]byte{2, 3, 4}, receivedContents(cl))
+}
+
+func testChannelListenerBlockTimeout(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cl     = NewChannelListener(&ChannelListenerOptions{BufferSize: 2, Timeout: 20 * time.Millisecond})
+	)
+
+	fillChannelListener(cl, 2)
+	start := time.Now()
+	cl.OnEvent(&Event{Type: MessageReceived, Contents: []byte{2}})
+	assert.True(time.Since(start) >= 20*time.Millisecond, "the listener should block until the timeout")
+	assert.Equal(uint64(1), cl.Dropped())
+
+	// a consumer that makes room before the timeout loses nothing
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-cl.Events()
+	}()
+
+	cl.OnEvent(&Event{Type: MessageReceived, Contents: []byte{3}})
+	assert.Equal(uint64(1), cl.Dropped())
+	assert.Equal([]byte{1, 3}, receivedContents(cl))
+}
+
+func testChannelListenerBlock(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		cl       = NewChannelListener(&ChannelListenerOptions{BufferSize: 1})
+		returned = make(chan struct{})
+	)
+
+	fillChannelListener(cl, 1)
+	go func() {
+		cl.OnEvent(&Event{Type: MessageReceived, Contents: []byte{1}})
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+		assert.Fail("the listener should block without a timeout")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Equal(byte(0), (<-cl.Events()).Contents[0])
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the listener never unblocked")
+	}
+
+	assert.Zero(cl.Dropped())
+	assert.Equal([]byte{1}, receivedContents(cl))
+}
+
+func TestChannelListener(t *testing.T) {
+	t.Run("Defaults", testChannelListenerDefaults)
+	t.Run("DropNewest", testChannelListenerDropNewest)
+	t.Run("DropOldest", testChannelListenerDropOldest)
+	t.Run("BlockTimeout", testChannelListenerBlockTimeout)
+	t.Run("Block", testChannelListenerBlock)
+}
@@ -0,0 +1,93 @@
+package device
+
+import (
+	"net"
+
+	"github.com/gorilla/websocket"
+)
+
+// Application close codes sent to devices that the server disconnects.  These are in the 4000-4999 range that
+// RFC 6455 reserves for applications, and let a device's reconnect logic adapt to why it was disconnected.
+const (
+	// CloseCodeDisconnected indicates that the device was explicitly disconnected, e.g. by an administrator.
+	// The device may reconnect with its usual backoff.
+	CloseCodeDisconnected = 4000
+
+	// CloseCodeReplaced indicates that a newer connection with the same device ID replaced this one.  The device
+	// should not reconnect immediately, as another connection is already using its identity.
+	CloseCodeReplaced = 4001
+
+	// CloseCodeDuplicateRejected indicates that the connection was refused because a connection with the same
+	// device ID already exists
+	CloseCodeDuplicateRejected = 4002
+
+	// CloseCodeOverCapacity indicates that the server reached its device limit or the device's partner quota.
+	// The device should back off harder than usual before reconnecting.
+	CloseCodeOverCapacity = 4003
+
+	// CloseCodeDrained indicates that the device was shed by a drain job to reduce this server's load.  Like
+	// CloseCodeOverCapacity, the device should back off harder than usual, preferably reconnecting elsewhere.
+	CloseCodeDrained = 4004
+
+	// CloseCodeIdle indicates that the device sent nothing within the idle period.  The device may reconnect
+	// immediately.
+	CloseCodeIdle = 4005
+
+	// CloseCodeShutdown indicates that the server is shutting down, so the device should reconnect elsewhere
+	CloseCodeShutdown = 4006
+
+	// CloseCodeError indicates that the connection failed on the server, e.g. due to a write error
+	CloseCodeError = 4007
+
+	// CloseCodeFlood indicates that the device sent messages faster than the server allows for too long.  The device
+	// should back off harder than usual, as reconnecting does not reset its rate.
+	CloseCodeFlood = 4008
+)
+
+// CloseReason is the code and text of the websocket close frame sent to a device that the server disconnects
+type CloseReason struct {
+	// Code is the websocket close code, normally one of the CloseCode constants
+	Code int
+
+	// Text is a short, human-readable description of why the device was disconnected
+	Text string
+}
+
+// The reasons used for each way that the server disconnects devices
+var (
+	CloseDisconnected      = CloseReason{CloseCodeDisconnected, "disconnected by the server"}
+	CloseReplaced          = CloseReason{CloseCodeReplaced, "replaced by a newer connection"}
+	CloseDuplicateRejected = CloseReason{CloseCodeDuplicateRejected, "a connection with this device ID already exists"}
+	CloseOverCapacity      = CloseReason{CloseCodeOverCapacity, "server over capacity"}
+	CloseDrained           = CloseReason{CloseCodeDrained, "drained from the server"}
+	CloseIdle              = CloseReason{CloseCodeIdle, "idle period expired"}
+	CloseShutdown          = CloseReason{CloseCodeShutdown, "server shutting down"}
+	CloseError             = CloseReason{CloseCodeError, "connection error"}
+	CloseFlood             = CloseReason{CloseCodeFlood, "inbound message rate exceeded"}
+
+	// ClosePartner is sent to devices disconnected via DisconnectPartner
+	ClosePartner = CloseReason{PartnerCloseCode, PartnerCloseText}
+)
+
+// resumable tests if a session closed for this reason may be resumed by the device reconnecting.  Only the reasons
+// a flaky link can produce qualify, as every other reason is a deliberate decision by the server.
+func (cr CloseReason) resumable() bool {
+	return cr == CloseError || cr == CloseIdle || cr == CloseReplaced
+}
+
+// frame returns the payload of the close frame for this reason
+func (cr CloseReason) frame() []byte {
+	return websocket.FormatCloseMessage(cr.Code, cr.Text)
+}
+
+// pumpCloseReason returns the reason sent to a device whose pumps exited with the given error.  Both network
+// timeouts and the ErrorDeadlineExceeded of long-poll connections mean that the device went idle.
+func pumpCloseReason(pumpError error) CloseReason {
+	if pumpError == ErrorDeadlineExceeded {
+		return CloseIdle
+	} else if netError, ok := pumpError.(net.Error); ok && netError.Timeout() {
+		return CloseIdle
+	}
+
+	return CloseError
+}
@@ -0,0 +1,142 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// timeoutError is a net.Error that reports a timeout, like the error returned when a read deadline expires
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestPumpCloseReason(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(CloseIdle, pumpCloseReason(timeoutError{}))
+	assert.Equal(CloseIdle, pumpCloseReason(ErrorDeadlineExceeded))
+	assert.Equal(CloseError, pumpCloseReason(errors.New("expected")))
+	assert.Equal(CloseError, pumpCloseReason(nil))
+}
+
+// awaitCloseError reads from a device connection until it fails, returning the close frame the server sent
+func awaitCloseError(t *testing.T, connection *websocket.Conn) *websocket.CloseError {
+	for {
+		if _, _, err := connection.ReadMessage(); err != nil {
+			closeError, ok := err.(*websocket.CloseError)
+			require.True(t, ok, "expected a close frame, got %v", err)
+			return closeError
+		}
+	}
+}
+
+func testCloseReasonDisconnect(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{Logger: logging.NewTestLogger(nil, t)})
+	)
+
+	defer server.Close()
+
+	connections := make([]*websocket.Conn, 2)
+	for i := range connections {
+		connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[i]), connectURL, nil)
+		require.NoError(err)
+		defer connection.Close()
+		connections[i] = connection
+	}
+
+	for manager.Len() < len(connections) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.True(manager.Disconnect(testDeviceIDs[0]))
+	closeError := awaitCloseError(t, connections[0])
+	assert.Equal(CloseCodeDisconnected, closeError.Code)
+	assert.Equal(CloseDisconnected.Text, closeError.Text)
+
+	require.True(manager.DisconnectWithReason(testDeviceIDs[1], CloseDrained))
+	closeError = awaitCloseError(t, connections[1])
+	assert.Equal(CloseCodeDrained, closeError.Code)
+	assert.Equal(CloseDrained.Text, closeError.Text)
+}
+
+func testCloseReasonReplaced(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{
+			Logger:          logging.NewTestLogger(nil, t),
+			DuplicatePolicy: DuplicateReplace,
+		})
+	)
+
+	defer server.Close()
+
+	first, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer first.Close()
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer second.Close()
+
+	closeError := awaitCloseError(t, first)
+	assert.Equal(CloseCodeReplaced, closeError.Code)
+	assert.Equal(CloseReplaced.Text, closeError.Text)
+}
+
+func testCloseReasonIdle(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		_, server, connectURL = startWebsocketServer(&Options{
+			Logger:     logging.NewTestLogger(nil, t),
+			IdlePeriod: 100 * time.Millisecond,
+			PingPeriod: 50 * time.Millisecond,
+		})
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+
+	// the idle period starts with the first pong, after which the device falls silent
+	answered := false
+	connection.SetPingHandler(func(data string) error {
+		if answered {
+			return nil
+		}
+
+		answered = true
+		return connection.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	closeError := awaitCloseError(t, connection)
+	assert.Equal(CloseCodeIdle, closeError.Code)
+	assert.Equal(CloseIdle.Text, closeError.Text)
+}
+
+func TestCloseReason(t *testing.T) {
+	t.Run("Disconnect", testCloseReasonDisconnect)
+	t.Run("Replaced", testCloseReasonReplaced)
+	t.Run("Idle", testCloseReasonIdle)
+}
@@ -0,0 +1,246 @@
+package device
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// BatchLimitHeader is the HTTP header a device sends at connect time to opt in to receiving wrp.Batch frames.
+	// Its value is the maximum number of messages the device accepts in a single batch.  When a Manager honors the
+	// header, the negotiated limit, which never exceeds Options.MaxBatchMessages, is echoed in the connect response.
+	BatchLimitHeader = "X-Xmidt-Batch-Limit"
+
+	// DefaultMaxBatchBytes is the default upper bound on the total size of the messages in a single batch
+	DefaultMaxBatchBytes = 64 * 1024
+
+	// DefaultMaxInboundBatchMessages is the default upper bound on the number of messages in a single batch
+	// read from a device
+	DefaultMaxInboundBatchMessages = 100
+)
+
+// newBatchLimit determines the number of messages that may be batched together for the given connect request.
+// A result of zero means messages are never batched, which is the case when the device sends no BatchLimitHeader
+// or when maxMessages is less than 2.  An error is returned if the header is not a positive integer.
+func newBatchLimit(request *http.Request, maxMessages int) (int, error) {
+	value := request.Header.Get(BatchLimitHeader)
+	if maxMessages < 2 || len(value) == 0 {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, ErrorInvalidBatchLimit
+	}
+
+	if limit > maxMessages {
+		limit = maxMessages
+	}
+
+	if limit < 2 {
+		return 0, nil
+	}
+
+	return limit, nil
+}
+
+// withBatchLimit returns a response header that informs the device of its negotiated batch limit.  The
+// given header is not modified.
+func withBatchLimit(d *device, responseHeader http.Header) http.Header {
+	if d.batchLimit < 2 {
+		return responseHeader
+	}
+
+	header := make(http.Header, len(responseHeader)+1)
+	for name, values := range responseHeader {
+		header[name] = values
+	}
+
+	header.Set(BatchLimitHeader, strconv.Itoa(d.batchLimit))
+	return header
+}
+
+// batchable tests if an envelope may be written as part of a batch.  Transactions are always written in their
+// own frames, so that each can be answered independently, and urgent messages never wait behind others.
+func batchable(e *envelope) bool {
+	_, transactional := e.request.Transactional()
+	return !transactional && !e.urgent
+}
+
+// envelopeContents returns the frame for a WRP envelope, encoding the message as msgpack only if the caller did
+// not already supply msgpack contents.  The contents of a SendRaw request are returned as is, whatever their format.
+func envelopeContents(encoder wrp.Encoder, e *envelope) (contents []byte, err error) {
+	if len(e.request.Contents) > 0 {
+		if _, raw := e.request.Message.(*rawHeader); raw || e.request.Format == wrp.Msgpack {
+			return e.request.Contents, nil
+		}
+	}
+
+	encoder.ResetBytes(&contents)
+	err = encoder.Encode(e.request.Message)
+	encoder.ResetBytes(nil)
+	return
+}
+
+// writeCoalesced writes the given envelope along with any compatible envelopes already queued behind it.  Messages
+// are never delayed in the hope of filling a batch, so a quiet device sees exactly the same frames it would without
+// batching.  Order is always preserved:  an envelope that cannot join a batch ends it, and is written afterward.
+func (m *manager) writeCoalesced(d *device, w WriteCloser, encoder wrp.Encoder, e *envelope) error {
+	for e != nil {
+		batch, contents, next := m.gatherBatch(d, encoder, e)
+		if len(batch) == 0 {
+			if next == nil {
+				// everything gathered had expired
+				return nil
+			}
+
+			// next cannot be batched, or could not be encoded, so it is handled exactly as it would be without batching
+			return m.writeQueued(d, w, encoder, next)
+		}
+
+		if err := m.writeBatch(d, w, encoder, batch, contents); err != nil {
+			if next != nil {
+				m.failUndeliverable(d, next, err)
+			}
+
+			return err
+		}
+
+		e = next
+	}
+
+	return nil
+}
+
+// gatherBatch collects the envelopes for a single batch, starting with first and continuing with envelopes taken
+// from the device's queue.  Gathering stops when the queue is empty or when the batch limit, MaxBatchBytes, or the
+// device's remaining credits are reached.  Expired envelopes are failed as they are encountered.  The returned next
+// envelope, if not nil, was taken from the queue but does not belong in the batch.
+func (m *manager) gatherBatch(d *device, encoder wrp.Encoder, first *envelope) (batch []*envelope, contents [][]byte, next *envelope) {
+	var size int
+	for candidate := first; candidate != nil; {
+		candidate.serviced = m.now()
+		if candidate.request.expired(candidate.serviced) {
+			m.expireEnvelope(d, candidate)
+		} else {
+			if !batchable(candidate) {
+				return batch, contents, candidate
+			}
+
+			frame, err := envelopeContents(encoder, candidate)
+			if err != nil || (len(batch) > 0 && size+len(frame) > m.maxBatchBytes) {
+				return batch, contents, candidate
+			}
+
+			batch = append(batch, candidate)
+			contents = append(contents, frame)
+			size += len(frame)
+		}
+
+		candidate = nil
+		if len(batch) < d.batchLimit && (d.credits == nil || int64(len(batch)) < d.credits.remaining()) {
+			select {
+			case candidate = <-d.messages:
+			default:
+			}
+		}
+	}
+
+	return
+}
+
+// writeBatch writes gathered envelopes as a single frame, then completes and dispatches each envelope individually.
+// A lone envelope is written as an ordinary frame rather than as a batch of one.
+func (m *manager) writeBatch(d *device, w WriteCloser, encoder wrp.Encoder, batch []*envelope, contents [][]byte) (err error) {
+	frame := contents[0]
+	if len(batch) > 1 {
+		frame = nil
+		encoder.ResetBytes(&frame)
+		err = encoder.Encode(&wrp.Batch{Messages: contents})
+		encoder.ResetBytes(nil)
+	}
+
+	if err == nil {
+		m.measures.OutboundMessageSize.Observe(float64(len(frame)))
+		err = writeFrame(d, w, frame)
+	}
+
+	for _, e := range batch {
+		d.dequeued(e)
+		d.credits.spend()
+		event := Event{
+			Device:   d,
+			Message:  e.request.Message,
+			Format:   e.request.Format,
+			Contents: e.request.Contents,
+			Error:    err,
+		}
+
+		if err != nil {
+			m.observeQueueTime(e, QueueFailedOutcome)
+			e.complete <- err
+			event.Type = MessageFailed
+		} else {
+			m.observeQueueTime(e, QueueSentOutcome)
+			event.Type = MessageSent
+		}
+
+		close(e.complete)
+		m.deliveries.delivered(e.request, err)
+		m.dispatch(&event)
+	}
+
+	return
+}
+
+// writeFrame writes a single binary frame to a device, converting any panic within the connection into an error
+func writeFrame(d *device, w Writer, frame []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+			d.errorLog.Log(logging.MessageKey(), "recovered from panic while writing a batch", "frameLength", len(frame), logging.ErrorKey(), err)
+		}
+	}()
+
+	return w.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// readBatch unpacks a wrp.Batch frame read from a device, processing each contained message in order exactly as
+// though it had arrived in its own frame, so each is dispatched and completes its transaction individually.  A
+// batch that is nested within another batch, or that holds more than the configured maximum messages, is skipped
+// in its entirety.
+func (m *manager) readBatch(d *device, decoder wrp.Decoder, sampler *logSampler, requestResponse xmetrics.Adder, data []byte, batched bool) {
+	if batched {
+		m.skipMalformed(d, "skipping nested WRP batch", ErrorNestedBatch)
+		return
+	}
+
+	messages, err := decodeBatch(d, decoder, data, m.maxInboundBatchMessages)
+	if err != nil {
+		m.skipMalformed(d, "skipping malformed WRP batch", err)
+		return
+	}
+
+	for _, contents := range messages {
+		m.readMessage(d, decoder, sampler, requestResponse, contents, true)
+	}
+}
+
+// decodeBatch is the analog of decodeFrame for wrp.Batch frames
+func decodeBatch(d *device, decoder wrp.Decoder, data []byte, maxMessages int) (messages [][]byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+			d.errorLog.Log(logging.MessageKey(), "recovered from panic while decoding a batch", "frameLength", len(data), logging.ErrorKey(), err)
+		}
+	}()
+
+	decoder.ResetBytes(data)
+	defer decoder.ResetBytes(nil)
+	return wrp.DecodeBatch(decoder, maxMessages)
+}
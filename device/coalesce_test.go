@@ -0,0 +1,449 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBatchLimit(t *testing.T) {
+	for _, record := range []struct {
+		header        string
+		maxMessages   int
+		expectedLimit int
+		expectedError error
+	}{
+		{"", 8, 0, nil},
+		{"4", 0, 0, nil},
+		{"4", 1, 0, nil},
+		{"4", 8, 4, nil},
+		{"16", 8, 8, nil},
+		{"1", 8, 0, nil},
+		{"0", 8, 0, ErrorInvalidBatchLimit},
+		{"-3", 8, 0, ErrorInvalidBatchLimit},
+		{"garbage", 8, 0, ErrorInvalidBatchLimit},
+	} {
+		t.Run(record.header+"/"+strconv.Itoa(record.maxMessages), func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				request = httptest.NewRequest("GET", "/", nil)
+			)
+
+			if len(record.header) > 0 {
+				request.Header.Set(BatchLimitHeader, record.header)
+			}
+
+			limit, err := newBatchLimit(request, record.maxMessages)
+			assert.Equal(record.expectedLimit, limit)
+			assert.Equal(record.expectedError, err)
+		})
+	}
+}
+
+func testBatchLimitNegotiated(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		m        = NewManager(&Options{MaxBatchMessages: 8})
+		request  = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil))
+		response = httptest.NewRecorder()
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(BatchLimitHeader, "32")
+	d, err := lp.Connect(response, request, http.Header{"X-Test": []string{"true"}})
+	require.NoError(err)
+	assert.Equal(8, d.(*device).batchLimit)
+	assert.Equal("8", response.HeaderMap.Get(BatchLimitHeader))
+	assert.Equal("true", response.HeaderMap.Get("X-Test"))
+
+	m.DisconnectAll()
+}
+
+func testBatchLimitInvalid(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		m        = NewManager(&Options{MaxBatchMessages: 8})
+		request  = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil))
+		response = httptest.NewRecorder()
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(BatchLimitHeader, "garbage")
+	d, err := lp.Connect(response, request, nil)
+	assert.Nil(d)
+	assert.Equal(ErrorInvalidBatchLimit, err)
+	assert.Equal(http.StatusBadRequest, response.Code)
+	assert.Zero(m.Len())
+}
+
+func testBatchLimitIgnored(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		m        = NewManager(nil)
+		request  = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil))
+		response = httptest.NewRecorder()
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(BatchLimitHeader, "8")
+	d, err := lp.Connect(response, request, nil)
+	require.NoError(err)
+	assert.Zero(d.(*device).batchLimit)
+	assert.Empty(response.HeaderMap.Get(BatchLimitHeader))
+
+	m.DisconnectAll()
+}
+
+func TestBatchLimit(t *testing.T) {
+	t.Run("Negotiated", testBatchLimitNegotiated)
+	t.Run("Invalid", testBatchLimitInvalid)
+	t.Run("Ignored", testBatchLimitIgnored)
+}
+
+// queueMessages sends a simple event for each payload, in order, returning the channel that receives
+// each send's result.  This function returns once every message is queued.
+func queueMessages(d *device, payloads ...string) <-chan error {
+	sendErrors := make(chan error, len(payloads))
+	for _, payload := range payloads {
+		pending := d.Pending()
+		go func(payload string) {
+			_, err := d.Send(&Request{
+				Message: &wrp.Message{
+					Type:        wrp.SimpleEventMessageType,
+					Destination: string(d.ID()),
+					Payload:     []byte(payload),
+				},
+			})
+
+			sendErrors <- err
+		}(payload)
+
+		for d.Pending() <= pending {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	return sendErrors
+}
+
+// readPayloads decodes a frame written to a device into the payloads it carries, which is more than
+// one payload only if the frame is a batch
+func readPayloads(t *testing.T, frame []byte) []string {
+	var (
+		require = require.New(t)
+		generic wrp.Message
+	)
+
+	require.NoError(wrp.NewDecoderBytes(frame, wrp.Msgpack).Decode(&generic))
+	if generic.Type != wrp.BatchMessageType {
+		return []string{string(generic.Payload)}
+	}
+
+	var (
+		batch    wrp.Batch
+		payloads []string
+	)
+
+	require.NoError(wrp.NewDecoderBytes(frame, wrp.Msgpack).Decode(&batch))
+	for _, element := range batch.Messages {
+		var message wrp.Message
+		require.NoError(wrp.NewDecoderBytes(element, wrp.Msgpack).Decode(&message))
+		payloads = append(payloads, string(message.Payload))
+	}
+
+	return payloads
+}
+
+// startBatchingPumps starts the pumps for a device that negotiated the given batch limit, after messages
+// have been queued by the supplied function
+func startBatchingPumps(t *testing.T, o *Options, batchLimit int, queue func(*device)) (*manager, *device, *longPollConnection) {
+	m, d, c := registerTestDevice(t, o)
+	d.batchLimit = batchLimit
+	queue(d)
+	m.startPumps(d, c, func() error { return nil })
+	return m, d, c
+}
+
+func testWriteCoalescedBatch(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		sendErrors <-chan error
+		sent       = make(chan *Event, 10)
+
+		m, _, c = startBatchingPumps(
+			t,
+			&Options{
+				MaxBatchMessages: 8,
+				Listeners: []Listener{
+					func(e *Event) {
+						if e.Type == MessageSent {
+							sent <- e
+						}
+					},
+				},
+			},
+			8,
+			func(d *device) { sendErrors = queueMessages(d, "first", "second", "third") },
+		)
+	)
+
+	assert.Equal([]string{"first", "second", "third"}, readPayloads(t, <-c.outbound))
+	for i := 0; i < 3; i++ {
+		assert.NoError(<-sendErrors)
+		assert.Equal(wrp.SimpleEventMessageType, (<-sent).Message.MessageType())
+	}
+
+	m.DisconnectAll()
+}
+
+func testWriteCoalescedTransaction(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		ctx, cancel = context.WithCancel(context.Background())
+
+		m, _, c = startBatchingPumps(
+			t,
+			&Options{MaxBatchMessages: 8},
+			8,
+			func(d *device) {
+				queueMessages(d, "first")
+				go d.Send(
+					(&Request{
+						Message: &wrp.Message{
+							Type:            wrp.SimpleRequestResponseMessageType,
+							TransactionUUID: "transaction",
+							Payload:         []byte("transaction"),
+						},
+					}).WithContext(ctx),
+				)
+
+				for d.Pending() < 2 {
+					time.Sleep(time.Millisecond)
+				}
+
+				queueMessages(d, "second", "third")
+			},
+		)
+	)
+
+	defer cancel()
+
+	// the transaction is written on its own, and messages are never reordered around it
+	assert.Equal([]string{"first"}, readPayloads(t, <-c.outbound))
+	assert.Equal([]string{"transaction"}, readPayloads(t, <-c.outbound))
+	assert.Equal([]string{"second", "third"}, readPayloads(t, <-c.outbound))
+
+	m.DisconnectAll()
+}
+
+func testWriteCoalescedCredits(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		m, d, c = startBatchingPumps(
+			t,
+			&Options{MaxBatchMessages: 8},
+			8,
+			func(d *device) {
+				d.credits = &creditWindow{available: 2, granted: make(chan struct{}, 1)}
+				queueMessages(d, "first", "second", "third")
+			},
+		)
+	)
+
+	// a batch never holds more messages than the device has credits for
+	assert.Equal([]string{"first", "second"}, readPayloads(t, <-c.outbound))
+	for d.credits.remaining() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	d.credits.grant(5)
+	assert.Equal([]string{"third"}, readPayloads(t, <-c.outbound))
+
+	m.DisconnectAll()
+}
+
+func testWriteCoalescedSizeBound(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		one      = wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(testDeviceIDs[0]), Payload: []byte("first")}, wrp.Msgpack)
+		maxBytes = 2*len(one) + 1
+
+		m, _, c = startBatchingPumps(
+			t,
+			&Options{MaxBatchMessages: 8, MaxBatchBytes: maxBytes},
+			8,
+			func(d *device) { queueMessages(d, "first", "second", "third", "fourth", "fifth") },
+		)
+	)
+
+	// each batch is cut off at the byte bound, after which a new batch begins
+	assert.Equal([]string{"first", "second"}, readPayloads(t, <-c.outbound))
+	assert.Equal([]string{"third", "fourth"}, readPayloads(t, <-c.outbound))
+	assert.Equal([]string{"fifth"}, readPayloads(t, <-c.outbound))
+
+	m.DisconnectAll()
+}
+
+func testWriteCoalescedWriteFailure(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected write error")
+
+		m, d, _ = registerTestDevice(t, &Options{MaxBatchMessages: 8})
+	)
+
+	d.batchLimit = 8
+	sendErrors := queueMessages(d, "first", "second")
+	m.startPumps(d, failingWriteConnection{newLongPollConnection(m.now), expectedError}, func() error { return nil })
+
+	// every message in a failed batch fails
+	assert.Equal(expectedError, <-sendErrors)
+	assert.Equal(expectedError, <-sendErrors)
+}
+
+func TestWriteCoalesced(t *testing.T) {
+	t.Run("Batch", testWriteCoalescedBatch)
+	t.Run("Transaction", testWriteCoalescedTransaction)
+	t.Run("Credits", testWriteCoalescedCredits)
+	t.Run("SizeBound", testWriteCoalescedSizeBound)
+	t.Run("WriteFailure", testWriteCoalescedWriteFailure)
+}
+
+// startReadingPumps starts the pumps for a device whose inbound messages are reported on the returned channel
+func startReadingPumps(t *testing.T, o *Options) (*manager, *device, *longPollConnection, <-chan *Event) {
+	events := make(chan *Event, 10)
+	o.Listeners = []Listener{
+		func(e *Event) {
+			if e.Type == MessageReceived || e.Type == TransactionComplete {
+				events <- e
+			}
+		},
+	}
+
+	m, d, c := startBatchingPumps(t, o, 0, func(*device) {})
+	return m, d, c, events
+}
+
+func inboundEvent(destination string) []byte {
+	return wrp.MustEncode(&wrp.SimpleEvent{Source: string(testDeviceIDs[0]), Destination: destination}, wrp.Msgpack)
+}
+
+func inboundBatch(messages ...[]byte) []byte {
+	return wrp.MustEncode(&wrp.Batch{Messages: messages}, wrp.Msgpack)
+}
+
+func testReadBatchSingle(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		m, _, c, events = startReadingPumps(t, &Options{})
+		single          = inboundEvent("event:single")
+		element         = inboundEvent("event:element")
+	)
+
+	defer m.DisconnectAll()
+
+	// an ordinary frame and a batch of one are indistinguishable to listeners
+	for _, frame := range [][]byte{single, inboundBatch(element)} {
+		c.inbound <- frame
+	}
+
+	for _, expected := range [][]byte{single, element} {
+		e := <-events
+		assert.Equal(MessageReceived, e.Type)
+		assert.Equal(expected, e.Contents)
+	}
+}
+
+func testReadBatchMultiple(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		require         = require.New(t)
+		m, d, c, events = startReadingPumps(t, &Options{})
+		response        = wrp.MustEncode(
+			&wrp.SimpleRequestResponse{
+				Source:          string(testDeviceIDs[0]),
+				Destination:     "dns:test",
+				TransactionUUID: "batched",
+			},
+			wrp.Msgpack,
+		)
+	)
+
+	defer m.DisconnectAll()
+	responses, err := d.transactions.Register("batched")
+	require.NoError(err)
+
+	// each message is dispatched individually, in order, and transactions complete per message
+	c.inbound <- inboundBatch(inboundEvent("event:first"), response, inboundEvent("event:last"))
+	first, transaction, last := <-events, <-events, <-events
+	assert.Equal(MessageReceived, first.Type)
+	assert.Equal("event:first", first.Message.(*wrp.Message).Destination)
+	assert.Equal(TransactionComplete, transaction.Type)
+	assert.Equal(response, transaction.Contents)
+	assert.Equal(MessageReceived, last.Type)
+	assert.Equal("event:last", last.Message.(*wrp.Message).Destination)
+
+	completed := <-responses
+	require.NotNil(completed)
+	assert.Equal("batched", completed.Message.TransactionUUID)
+	assert.Equal(response, completed.Contents)
+}
+
+func testReadBatchOversized(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		p               = xmetricstest.NewProvider(nil, Metrics)
+		m, _, c, events = startReadingPumps(t, &Options{MetricsProvider: p, MaxInboundBatchMessages: 2})
+	)
+
+	defer m.DisconnectAll()
+
+	// the whole batch is skipped, and reading continues with the next frame
+	c.inbound <- inboundBatch(inboundEvent("event:first"), inboundEvent("event:second"), inboundEvent("event:third"))
+	c.inbound <- inboundBatch(inboundEvent("event:after"), inboundEvent("event:limit"))
+	assert.Equal("event:after", (<-events).Message.(*wrp.Message).Destination)
+	assert.Equal("event:limit", (<-events).Message.(*wrp.Message).Destination)
+	p.Assert(t, MalformedMessageCounter, MalformedReasonLabel, OversizedBatchReason)(xmetricstest.Value(1.0))
+}
+
+func testReadBatchNested(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		p               = xmetricstest.NewProvider(nil, Metrics)
+		m, _, c, events = startReadingPumps(t, &Options{MetricsProvider: p})
+	)
+
+	defer m.DisconnectAll()
+
+	c.inbound <- inboundBatch(inboundBatch(inboundEvent("event:nested")), inboundEvent("event:outer"))
+	c.inbound <- inboundEvent("event:after")
+	assert.Equal("event:outer", (<-events).Message.(*wrp.Message).Destination)
+	assert.Equal("event:after", (<-events).Message.(*wrp.Message).Destination)
+	p.Assert(t, MalformedMessageCounter, MalformedReasonLabel, OtherMalformedReason)(xmetricstest.Value(1.0))
+}
+
+func TestReadBatch(t *testing.T) {
+	t.Run("Single", testReadBatchSingle)
+	t.Run("Multiple", testReadBatchMultiple)
+	t.Run("Oversized", testReadBatchOversized)
+	t.Run("Nested", testReadBatchNested)
+}
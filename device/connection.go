@@ -90,9 +90,18 @@ func NewPinger(w Writer, pings xmetrics.Incrementer, data []byte, deadline func(
 // SetPongHandler establishes an instrumented pong handler for the given connection that enforces
 // the given read timeout.
 func SetPongHandler(r Reader, pongs xmetrics.Incrementer, deadline func() time.Time) {
-	r.SetPongHandler(func(_ string) error {
+	setPongHandler(r, pongs, deadline, nil)
+}
+
+// setPongHandler is SetPongHandler with an optional function that observes the application data of each pong
+func setPongHandler(r Reader, pongs xmetrics.Incrementer, deadline func() time.Time, observe func(string)) {
+	r.SetPongHandler(func(data string) error {
 		// increment up front, as this function is only called when a pong is actually received
 		pongs.Inc()
+		if observe != nil {
+			observe(data)
+		}
+
 		return r.SetReadDeadline(deadline())
 	})
 }
@@ -100,6 +109,7 @@ func SetPongHandler(r Reader, pongs xmetrics.Incrementer, deadline func() time.T
 type instrumentedReader struct {
 	ReadCloser
 	statistics Statistics
+	rates      []Rate
 }
 
 func (ir *instrumentedReader) ReadMessage() (int, []byte, error) {
@@ -107,18 +117,24 @@ func (ir *instrumentedReader) ReadMessage() (int, []byte, error) {
 	if err == nil {
 		ir.statistics.AddBytesReceived(len(data))
 		ir.statistics.AddMessagesReceived(1)
+		for _, r := range ir.rates {
+			r.Add(len(data))
+		}
 	}
 
 	return messageType, data, err
 }
 
-func InstrumentReader(r ReadCloser, s Statistics) ReadCloser {
-	return &instrumentedReader{r, s}
+// InstrumentReader decorates a ReadCloser so that the bytes and messages read are tracked
+// by the given Statistics.  The byte count of each message is also fed to any supplied rates.
+func InstrumentReader(r ReadCloser, s Statistics, rates ...Rate) ReadCloser {
+	return &instrumentedReader{r, s, rates}
 }
 
 type instrumentedWriter struct {
 	WriteCloser
 	statistics Statistics
+	rates      []Rate
 }
 
 func (iw *instrumentedWriter) WriteMessage(messageType int, data []byte) error {
@@ -129,6 +145,10 @@ func (iw *instrumentedWriter) WriteMessage(messageType int, data []byte) error {
 
 	iw.statistics.AddBytesSent(len(data))
 	iw.statistics.AddMessagesSent(1)
+	for _, r := range iw.rates {
+		r.Add(len(data))
+	}
+
 	return nil
 }
 
@@ -144,6 +164,8 @@ func (iw *instrumentedWriter) WritePreparedMessage(pm *websocket.PreparedMessage
 	return nil
 }
 
-func InstrumentWriter(w WriteCloser, s Statistics) WriteCloser {
-	return &instrumentedWriter{w, s}
+// InstrumentWriter decorates a WriteCloser so that the bytes and messages written are tracked
+// by the given Statistics.  The byte count of each message is also fed to any supplied rates.
+func InstrumentWriter(w WriteCloser, s Statistics, rates ...Rate) WriteCloser {
+	return &instrumentedWriter{w, s, rates}
 }
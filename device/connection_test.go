@@ -199,7 +199,8 @@ func TestInstrumentReader(t *testing.T) {
 			statistics         = NewStatistics(nil, time.Now())
 			reader             = new(mockConnectionReader)
 			expectedData       = []byte{1, 2, 3, 4, 5, 6}
-			instrumentedReader = InstrumentReader(reader, statistics)
+			rate               = NewRate(time.Hour, nil)
+			instrumentedReader = InstrumentReader(reader, statistics, rate)
 		)
 
 		require.NotNil(instrumentedReader)
@@ -211,6 +212,7 @@ func TestInstrumentReader(t *testing.T) {
 		assert.NoError(actualError)
 		assert.Equal(len(expectedData), statistics.BytesReceived())
 		assert.Equal(1, statistics.MessagesReceived())
+		assert.True(rate.Value() > 0.0)
 
 		reader.AssertExpectations(t)
 	})
@@ -250,7 +252,8 @@ func TestInstrumentWriter(t *testing.T) {
 				statistics         = NewStatistics(nil, time.Now())
 				writer             = new(mockConnectionWriter)
 				expectedData       = []byte{43, 3, 74, 111, 89}
-				instrumentedWriter = InstrumentWriter(writer, statistics)
+				rate               = NewRate(time.Hour, nil)
+				instrumentedWriter = InstrumentWriter(writer, statistics, rate)
 			)
 
 			require.NotNil(instrumentedWriter)
@@ -259,6 +262,7 @@ func TestInstrumentWriter(t *testing.T) {
 			assert.NoError(instrumentedWriter.WriteMessage(websocket.BinaryMessage, expectedData))
 			assert.Equal(len(expectedData), statistics.BytesSent())
 			assert.Equal(1, statistics.MessagesSent())
+			assert.True(rate.Value() > 0.0)
 
 			writer.AssertExpectations(t)
 		})
@@ -0,0 +1,99 @@
+package device
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// CreditWindowHeader is the HTTP header a device sends at connect time to opt in to credit-based flow control.
+// Its value is the number of messages the device can initially accept.  When a Manager honors the header, it
+// is echoed in the connect response, after which the device replenishes its window with wrp.CreditGrant messages.
+const CreditWindowHeader = "X-Xmidt-Credit-Window"
+
+// creditWindow tracks the number of messages a device has agreed to accept under credit-based flow control.
+// Credits are granted by the read pump and spent by the write pump.  A nil creditWindow means the device
+// did not negotiate flow control, and imposes no limit.
+type creditWindow struct {
+	available int64
+	granted   chan struct{}
+}
+
+// newCreditWindow produces a creditWindow for the given connect request.  If the request carries no
+// CreditWindowHeader, this function returns nil.  An error is returned if the header is not a positive integer.
+func newCreditWindow(request *http.Request) (*creditWindow, error) {
+	value := request.Header.Get(CreditWindowHeader)
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	initial, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || initial < 1 {
+		return nil, ErrorInvalidCreditWindow
+	}
+
+	return &creditWindow{
+		available: initial,
+		granted:   make(chan struct{}, 1),
+	}, nil
+}
+
+// remaining returns the number of messages that may currently be written
+func (cw *creditWindow) remaining() int64 {
+	if cw == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&cw.available)
+}
+
+// ready tests if a message may be written, which is always true if flow control was not negotiated
+func (cw *creditWindow) ready() bool {
+	return cw == nil || atomic.LoadInt64(&cw.available) > 0
+}
+
+// spend consumes a single credit.  This method must only be called by the write pump, after ready returns true.
+func (cw *creditWindow) spend() {
+	if cw != nil {
+		atomic.AddInt64(&cw.available, -1)
+	}
+}
+
+// grant adds the given number of credits, waking the write pump if it is waiting.  Nonpositive grants are ignored.
+func (cw *creditWindow) grant(credits int64) {
+	if cw == nil || credits < 1 {
+		return
+	}
+
+	atomic.AddInt64(&cw.available, credits)
+	select {
+	case cw.granted <- struct{}{}:
+	default:
+	}
+}
+
+// replenished returns the channel signalled when credits are granted.  For a nil creditWindow, the
+// returned channel is nil and so never signals.
+func (cw *creditWindow) replenished() <-chan struct{} {
+	if cw == nil {
+		return nil
+	}
+
+	return cw.granted
+}
+
+// withCreditWindow returns a response header that informs the device its credit window was honored.  The
+// given header is not modified.
+func withCreditWindow(d *device, responseHeader http.Header) http.Header {
+	if d.credits == nil {
+		return responseHeader
+	}
+
+	header := make(http.Header, len(responseHeader)+1)
+	for name, values := range responseHeader {
+		header[name] = values
+	}
+
+	header.Set(CreditWindowHeader, strconv.FormatInt(d.credits.remaining(), 10))
+	return header
+}
@@ -0,0 +1,237 @@
+package device
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCreditWindowNil(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	cw, err := newCreditWindow(httptest.NewRequest("GET", "http://localhost.com", nil))
+	require.NoError(err)
+	require.Nil(cw)
+
+	assert.True(cw.ready())
+	assert.Zero(cw.remaining())
+	assert.Nil(cw.replenished())
+	cw.spend()
+	cw.grant(10)
+	assert.True(cw.ready())
+}
+
+func testCreditWindowInvalid(t *testing.T) {
+	for _, value := range []string{"0", "-1", "garbage", "1.5"} {
+		request := httptest.NewRequest("GET", "http://localhost.com", nil)
+		request.Header.Set(CreditWindowHeader, value)
+
+		cw, err := newCreditWindow(request)
+		assert.Nil(t, cw, value)
+		assert.Equal(t, ErrorInvalidCreditWindow, err, value)
+	}
+}
+
+func testCreditWindowSpendGrant(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		request = httptest.NewRequest("GET", "http://localhost.com", nil)
+	)
+
+	request.Header.Set(CreditWindowHeader, "2")
+	cw, err := newCreditWindow(request)
+	require.NoError(err)
+	require.NotNil(cw)
+
+	assert.Equal(int64(2), cw.remaining())
+	cw.spend()
+	assert.True(cw.ready())
+	cw.spend()
+	assert.False(cw.ready())
+
+	cw.grant(0)
+	cw.grant(-5)
+	assert.False(cw.ready())
+
+	// multiple grants coalesce into a single wakeup
+	cw.grant(1)
+	cw.grant(2)
+	assert.Equal(int64(3), cw.remaining())
+	assert.True(cw.ready())
+
+	select {
+	case <-cw.replenished():
+	default:
+		assert.Fail("a grant did not signal the write pump")
+	}
+
+	select {
+	case <-cw.replenished():
+		assert.Fail("grants should coalesce")
+	default:
+	}
+}
+
+func TestCreditWindow(t *testing.T) {
+	t.Run("Nil", testCreditWindowNil)
+	t.Run("Invalid", testCreditWindowInvalid)
+	t.Run("SpendGrant", testCreditWindowSpendGrant)
+}
+
+func testManagerCreditFlowControlDisabled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(nil)
+		request = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil))
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(CreditWindowHeader, "garbage")
+	response := httptest.NewRecorder()
+	d, err := lp.Connect(response, request, nil)
+	require.NoError(err)
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Empty(response.HeaderMap.Get(CreditWindowHeader))
+	assert.Nil(d.(*device).credits)
+
+	m.DisconnectAll()
+}
+
+func testManagerCreditFlowControlInvalid(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(&Options{CreditFlowControl: true})
+		request = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil))
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(CreditWindowHeader, "0")
+	response := httptest.NewRecorder()
+	d, err := lp.Connect(response, request, nil)
+	assert.Nil(d)
+	assert.Equal(ErrorInvalidCreditWindow, err)
+	assert.Equal(http.StatusBadRequest, response.Code)
+	assert.Zero(m.Len())
+}
+
+func testManagerCreditFlowControlWindow(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan *Event, 10)
+
+		m = NewManager(&Options{
+			CreditFlowControl: true,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived {
+						received <- e
+					}
+				},
+			},
+		})
+
+		id      = testDeviceIDs[0]
+		request = WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil))
+	)
+
+	lp, err := NewLongPollConnector(m, 20*time.Millisecond)
+	require.NoError(err)
+
+	request.Header.Set(CreditWindowHeader, "1")
+	response := httptest.NewRecorder()
+	d, err := lp.Connect(response, request, nil)
+	require.NoError(err)
+	assert.Equal("1", response.HeaderMap.Get(CreditWindowHeader))
+
+	poll := func() *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		lp.ServeHTTP(response, WithIDRequest(id, httptest.NewRequest("GET", "http://localhost.com", nil)))
+		return response
+	}
+
+	routeErrors := make(chan error, 2)
+	for _, payload := range []string{"first", "second"} {
+		go func(payload string) {
+			_, err := m.Route(&Request{
+				Message: &wrp.Message{
+					Type:        wrp.SimpleEventMessageType,
+					Destination: string(id),
+					Payload:     []byte(payload),
+				},
+			})
+
+			routeErrors <- err
+		}(payload)
+	}
+
+	var first *httptest.ResponseRecorder
+	for first = poll(); first.Code == http.StatusNoContent; first = poll() {
+	}
+
+	require.Equal(http.StatusOK, first.Code)
+	assert.NoError(<-routeErrors)
+
+	// with no credits remaining, the other message stays queued
+	for d.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(http.StatusNoContent, poll().Code)
+	assert.Equal(1, d.Pending())
+
+	grant := httptest.NewRecorder()
+	lp.ServeHTTP(
+		grant,
+		WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", bytes.NewReader(wrp.MustEncode(&wrp.CreditGrant{Credits: 1}, wrp.Msgpack)))),
+	)
+
+	require.Equal(http.StatusAccepted, grant.Code)
+
+	var second *httptest.ResponseRecorder
+	for second = poll(); second.Code == http.StatusNoContent; second = poll() {
+	}
+
+	require.Equal(http.StatusOK, second.Code)
+	assert.NoError(<-routeErrors)
+
+	var payloads []string
+	for _, r := range []*httptest.ResponseRecorder{first, second} {
+		var actual wrp.Message
+		require.NoError(wrp.NewDecoderBytes(r.Body.Bytes(), wrp.Msgpack).Decode(&actual))
+		payloads = append(payloads, string(actual.Payload))
+	}
+
+	assert.ElementsMatch([]string{"first", "second"}, payloads)
+
+	// credit grants are never dispatched to listeners
+	select {
+	case e := <-received:
+		assert.Fail("unexpected event", "%v", e.Message)
+	default:
+	}
+
+	m.DisconnectAll()
+}
+
+func TestManagerCreditFlowControl(t *testing.T) {
+	t.Run("Disabled", testManagerCreditFlowControlDisabled)
+	t.Run("Invalid", testManagerCreditFlowControlInvalid)
+	t.Run("Window", testManagerCreditFlowControlWindow)
+}
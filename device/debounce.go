@@ -0,0 +1,153 @@
+package device
+
+import (
+	"sync"
+	"time"
+)
+
+// debounced is the state of a single device ID within a debouncer
+type debounced struct {
+	// reported is Connect if the device's connection was the last state forwarded, and Disconnect otherwise
+	reported EventType
+
+	// sessions holds the session IDs currently connected under the device ID.  A replaced connection's Disconnect
+	// may arrive after its replacement's Connect, so the state of an ID is only Disconnect once this is empty.
+	sessions map[string]bool
+
+	// connect and disconnect are the latest unsettled events of each type, and generation identifies the timer
+	// that settles them
+	connect    *Event
+	disconnect *Event
+	generation uint64
+	timer      *time.Timer
+}
+
+// state returns the net state of this ID, which is Connect if any of its sessions is connected
+func (entry *debounced) state() EventType {
+	if len(entry.sessions) > 0 {
+		return Connect
+	}
+
+	return Disconnect
+}
+
+// debouncer is the Listener middleware returned by DebounceListener
+type debouncer struct {
+	window time.Duration
+	inner  Listener
+
+	lock    sync.Mutex
+	devices map[ID]*debounced
+}
+
+// DebounceListener returns a Listener that smooths rapid connect/disconnect flapping before events reach inner.
+// Connect and Disconnect events for a device are held until that device has been quiet for the given window, and
+// only a net change in the device's state is forwarded.  For example, a Disconnect quickly followed by a Connect is
+// suppressed altogether, while a Connect, Disconnect, and Connect within the window are forwarded as one Connect.
+// A device is connected while any of its sessions is, so a connection replaced under DuplicateReplace is not a
+// change in state regardless of the order in which the replacement's Connect and the original's Disconnect arrive.
+//
+// Consequently, Connect and Disconnect events are delayed by at least the window, and are delivered to inner on
+// a separate goroutine.  All other events are forwarded immediately, so they may reach inner before the Connect of
+// the device they belong to.  inner must therefore be safe for concurrent use.  Forwarded events are copies, so
+// inner may use them as it would any other event.
+//
+// State is kept only for devices that are connected or have unsettled events, so disconnected devices are never
+// retained.  If window is nonpositive, inner is returned as is.
+func DebounceListener(window time.Duration, inner Listener) Listener {
+	if window <= 0 {
+		return inner
+	}
+
+	return newDebouncer(window, inner).onEvent
+}
+
+func newDebouncer(window time.Duration, inner Listener) *debouncer {
+	return &debouncer{
+		window:  window,
+		inner:   inner,
+		devices: make(map[ID]*debounced),
+	}
+}
+
+func (db *debouncer) onEvent(e *Event) {
+	if e.Type != Connect && e.Type != Disconnect {
+		db.inner(e)
+		return
+	}
+
+	var (
+		id        = e.Device.ID()
+		sessionID = e.Device.SessionID()
+		pending   = copyEvent(e)
+	)
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	entry, ok := db.devices[id]
+	if !ok {
+		entry = &debounced{reported: Disconnect, sessions: make(map[string]bool, 1)}
+		db.devices[id] = entry
+	}
+
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+
+	if e.Type == Connect {
+		entry.sessions[sessionID] = true
+		entry.connect = pending
+	} else {
+		delete(entry.sessions, sessionID)
+		entry.disconnect = pending
+	}
+
+	entry.generation++
+	generation := entry.generation
+	entry.timer = time.AfterFunc(db.window, func() { db.settle(id, generation) })
+}
+
+// settle forwards the latest event of a device's net state once its window has passed, provided that state
+// differs from the device's reported state.  A timer that was superseded by a later event does nothing.
+func (db *debouncer) settle(id ID, generation uint64) {
+	db.lock.Lock()
+	entry, ok := db.devices[id]
+	if !ok || entry.generation != generation {
+		db.lock.Unlock()
+		return
+	}
+
+	var (
+		state = entry.state()
+		e     = entry.disconnect
+	)
+
+	if state == Connect {
+		e = entry.connect
+	}
+
+	forward := state != entry.reported && e != nil
+	entry.reported = state
+	entry.connect = nil
+	entry.disconnect = nil
+	entry.timer = nil
+	if state == Disconnect {
+		delete(db.devices, id)
+	}
+
+	db.lock.Unlock()
+	if forward {
+		db.inner(e)
+	}
+}
+
+// copyEvent makes a copy of an event that is safe to hold beyond the listener invocation
+func copyEvent(e *Event) *Event {
+	c := *e
+	if len(e.Contents) > 0 {
+		c.Contents = append([]byte(nil), e.Contents...)
+	}
+
+	return &c
+}
@@ -0,0 +1,211 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDebounceWindow = 50 * time.Millisecond
+
+// debounceFixture is a debouncer whose inner listener records the events it receives
+type debounceFixture struct {
+	*debouncer
+	forwarded chan *Event
+	d         *device
+}
+
+func newDebounceFixture() *debounceFixture {
+	forwarded := make(chan *Event, 10)
+	return &debounceFixture{
+		debouncer: newDebouncer(testDebounceWindow, func(e *Event) { forwarded <- e }),
+		forwarded: forwarded,
+		d:         newDevice(deviceOptions{ID: testDeviceIDs[0]}),
+	}
+}
+
+func (df *debounceFixture) send(types ...EventType) {
+	for _, et := range types {
+		df.onEvent(&Event{Type: et, Device: df.d})
+	}
+}
+
+// next returns the next forwarded event, or nil if none arrives well after the window has passed
+func (df *debounceFixture) next() *Event {
+	select {
+	case e := <-df.forwarded:
+		return e
+	case <-time.After(4 * testDebounceWindow):
+		return nil
+	}
+}
+
+func (df *debounceFixture) tracked() int {
+	df.lock.Lock()
+	defer df.lock.Unlock()
+	return len(df.devices)
+}
+
+func testDebounceListenerDisabled(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		count  = 0
+		inner  = func(*Event) { count++ }
+	)
+
+	for _, window := range []time.Duration{0, -1} {
+		DebounceListener(window, inner)(&Event{Type: Connect})
+	}
+
+	assert.Equal(2, count)
+}
+
+func testDebounceListenerSettled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		df      = newDebounceFixture()
+		start   = time.Now()
+	)
+
+	df.send(Connect)
+	e := df.next()
+	require.NotNil(e)
+	assert.Equal(Connect, e.Type)
+	assert.True(time.Since(start) >= testDebounceWindow)
+	assert.Equal(1, df.tracked())
+
+	df.send(Disconnect)
+	e = df.next()
+	require.NotNil(e)
+	assert.Equal(Disconnect, e.Type)
+
+	// nothing is retained for a disconnected device
+	assert.Zero(df.tracked())
+}
+
+func testDebounceListenerFlap(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		df      = newDebounceFixture()
+	)
+
+	df.send(Connect)
+	require.NotNil(df.next())
+
+	// a disconnect quickly followed by a reconnect is not a change in state
+	df.send(Disconnect, Connect, Disconnect, Connect)
+	assert.Nil(df.next())
+	assert.Equal(1, df.tracked())
+
+	df.send(Disconnect, Connect, Disconnect)
+	e := df.next()
+	require.NotNil(e)
+	assert.Equal(Disconnect, e.Type)
+	assert.Nil(df.next())
+	assert.Zero(df.tracked())
+}
+
+func testDebounceListenerReplacement(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		df          = newDebounceFixture()
+		replacement = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+	)
+
+	df.send(Connect)
+	require.NotNil(df.next())
+
+	// the replaced session's disconnect arrives last, yet the device is still connected
+	df.onEvent(&Event{Type: Connect, Device: replacement})
+	df.send(Disconnect)
+	assert.Nil(df.next())
+	assert.Equal(1, df.tracked())
+
+	// the replacement's disconnect is a real change in state
+	df.onEvent(&Event{Type: Disconnect, Device: replacement})
+	e := df.next()
+	require.NotNil(e)
+	assert.Equal(Disconnect, e.Type)
+	assert.Equal(replacement, e.Device)
+	assert.Zero(df.tracked())
+
+	// in the other order, the replacement's connect arrives last
+	df.send(Connect)
+	require.NotNil(df.next())
+
+	df.send(Disconnect)
+	df.onEvent(&Event{Type: Connect, Device: replacement})
+	assert.Nil(df.next())
+	assert.Equal(1, df.tracked())
+}
+
+func testDebounceListenerBriefConnection(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		df     = newDebounceFixture()
+	)
+
+	df.send(Connect, Disconnect)
+	assert.Nil(df.next())
+	assert.Zero(df.tracked())
+}
+
+func testDebounceListenerOtherEvents(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		df      = newDebounceFixture()
+		event   = &Event{Type: MessageReceived, Device: df.d}
+	)
+
+	df.send(Connect)
+	df.onEvent(event)
+
+	// events other than Connect and Disconnect are neither delayed nor copied
+	select {
+	case e := <-df.forwarded:
+		assert.True(e == event)
+	default:
+		assert.Fail("MessageReceived was not forwarded immediately")
+	}
+
+	e := df.next()
+	require.NotNil(e)
+	assert.Equal(Connect, e.Type)
+}
+
+func testDebounceListenerCopies(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		df      = newDebounceFixture()
+		event   = &Event{Type: Connect, Device: df.d, Contents: []byte("contents")}
+	)
+
+	df.onEvent(event)
+
+	// the infrastructure is free to reuse events once a listener returns
+	event.Type = MessageSent
+	event.Contents[0] = 'X'
+
+	e := df.next()
+	require.NotNil(e)
+	assert.False(e == event)
+	assert.Equal(Connect, e.Type)
+	assert.Equal([]byte("contents"), e.Contents)
+}
+
+func TestDebounceListener(t *testing.T) {
+	t.Run("Disabled", testDebounceListenerDisabled)
+	t.Run("Settled", testDebounceListenerSettled)
+	t.Run("Flap", testDebounceListenerFlap)
+	t.Run("Replacement", testDebounceListenerReplacement)
+	t.Run("BriefConnection", testDebounceListenerBriefConnection)
+	t.Run("OtherEvents", testDebounceListenerOtherEvents)
+	t.Run("Copies", testDebounceListenerCopies)
+}
@@ -0,0 +1,85 @@
+package device
+
+import "sync"
+
+// deliveryQueue runs Request.OnDelivered callbacks on a single goroutine, separate from any write pump.
+// The queue is unbounded, so enqueuing never blocks a write pump regardless of how slow callbacks are.
+// Callbacks are invoked sequentially, in the order they were enqueued.  A callback that blocks stalls every
+// later callback, and the queue grows by one entry per write until it returns, so callbacks must not block.
+//
+// The goroutine is started lazily, when a callback is enqueued.  Once the queue is closed, the goroutine exits
+// as soon as nothing is pending.  Callbacks enqueued after close, such as for messages failed by write pumps
+// that are still exiting, start a goroutine of their own that likewise exits once they have run.
+type deliveryQueue struct {
+	lock    sync.Mutex
+	pending []func()
+	running bool
+	closed  bool
+	signal  chan struct{}
+}
+
+func newDeliveryQueue() *deliveryQueue {
+	return &deliveryQueue{
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// enqueue schedules the given function for execution on this queue's goroutine
+func (dq *deliveryQueue) enqueue(f func()) {
+	dq.lock.Lock()
+	dq.pending = append(dq.pending, f)
+	if !dq.running {
+		dq.running = true
+		go dq.run()
+	}
+
+	dq.lock.Unlock()
+	dq.wake()
+}
+
+// close allows this queue's goroutine to exit once nothing is pending
+func (dq *deliveryQueue) close() {
+	dq.lock.Lock()
+	dq.closed = true
+	dq.lock.Unlock()
+	dq.wake()
+}
+
+func (dq *deliveryQueue) wake() {
+	select {
+	case dq.signal <- struct{}{}:
+	default:
+		// the goroutine has already been signaled, and will pick up any pending functions
+	}
+}
+
+func (dq *deliveryQueue) run() {
+	for {
+		dq.lock.Lock()
+		batch := dq.pending
+		dq.pending = nil
+		if len(batch) == 0 && dq.closed {
+			dq.running = false
+			dq.lock.Unlock()
+			return
+		}
+
+		dq.lock.Unlock()
+		if len(batch) == 0 {
+			<-dq.signal
+			continue
+		}
+
+		for _, f := range batch {
+			f()
+		}
+	}
+}
+
+// delivered schedules the request's OnDelivered callback, if any, with the outcome of writing the request
+func (dq *deliveryQueue) delivered(request *Request, err error) {
+	if request.OnDelivered != nil {
+		callback := request.OnDelivered
+		dq.enqueue(func() { callback(err) })
+	}
+}
@@ -0,0 +1,152 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDeliveryQueueOrder(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dq     = newDeliveryQueue()
+		result = make(chan int, 100)
+	)
+
+	for i := 0; i < 100; i++ {
+		i := i
+		dq.enqueue(func() { result <- i })
+	}
+
+	for i := 0; i < 100; i++ {
+		select {
+		case actual := <-result:
+			assert.Equal(i, actual)
+		case <-time.After(5 * time.Second):
+			assert.Fail("callbacks were not invoked")
+			return
+		}
+	}
+}
+
+func testDeliveryQueueSlowCallback(t *testing.T) {
+	var (
+		require = require.New(t)
+		dq      = newDeliveryQueue()
+		block   = make(chan struct{})
+		done    = make(chan struct{})
+	)
+
+	dq.enqueue(func() { <-block })
+
+	// enqueuing must not block behind a slow callback
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			dq.enqueue(func() {})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail("enqueue blocked behind a slow callback")
+	}
+
+	close(block)
+}
+
+func testDeliveryQueueDelivered(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		dq          = newDeliveryQueue()
+		expectedErr = errors.New("expected")
+		result      = make(chan error, 1)
+	)
+
+	// no callback is a noop
+	dq.delivered(new(Request), expectedErr)
+
+	dq.delivered(&Request{OnDelivered: func(err error) { result <- err }}, expectedErr)
+	select {
+	case actual := <-result:
+		assert.Equal(expectedErr, actual)
+	case <-time.After(5 * time.Second):
+		assert.Fail("the callback was not invoked")
+	}
+}
+
+// deliveryQueueRunning tests if a delivery queue's goroutine is running
+func deliveryQueueRunning(dq *deliveryQueue) bool {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	return dq.running
+}
+
+func testDeliveryQueueClose(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		dq      = newDeliveryQueue()
+		block   = make(chan struct{})
+		result  = make(chan int, 2)
+	)
+
+	dq.enqueue(func() { <-block })
+	dq.enqueue(func() { result <- 1 })
+	require.True(deliveryQueueRunning(dq))
+
+	// closing does not abandon pending callbacks
+	dq.close()
+	close(block)
+	assert.Equal(1, <-result)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for deliveryQueueRunning(dq) {
+		require.True(time.Now().Before(deadline), "the goroutine did not exit after close")
+		time.Sleep(time.Millisecond)
+	}
+
+	// a callback enqueued after close still runs
+	dq.enqueue(func() { result <- 2 })
+	select {
+	case actual := <-result:
+		assert.Equal(2, actual)
+	case <-time.After(5 * time.Second):
+		assert.Fail("a callback enqueued after close was not invoked")
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for deliveryQueueRunning(dq) {
+		require.True(time.Now().Before(deadline), "the goroutine did not exit after running late callbacks")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testManagerCloseStopsDeliveries(t *testing.T) {
+	var (
+		m      = NewManager(&Options{}).(*manager)
+		result = make(chan error, 1)
+	)
+
+	m.deliveries.delivered(&Request{OnDelivered: func(err error) { result <- err }}, nil)
+	assert.NoError(t, <-result)
+
+	m.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for deliveryQueueRunning(m.deliveries) {
+		require.True(t, time.Now().Before(deadline), "closing the manager did not stop its delivery goroutine")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeliveryQueue(t *testing.T) {
+	t.Run("Order", testDeliveryQueueOrder)
+	t.Run("SlowCallback", testDeliveryQueueSlowCallback)
+	t.Run("Delivered", testDeliveryQueueDelivered)
+	t.Run("Close", testDeliveryQueueClose)
+	t.Run("ManagerClose", testManagerCloseStopsDeliveries)
+}
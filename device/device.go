@@ -2,16 +2,21 @@ package device
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/Comcast/webpa-common/convey"
 	"github.com/Comcast/webpa-common/convey/conveymetric"
 
 	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
 )
 
 const (
@@ -25,6 +30,17 @@ const (
 type envelope struct {
 	request  *Request
 	complete chan<- error
+
+	// urgent indicates that this envelope was sent via the urgent queue, and so is not subject to flow control
+	urgent bool
+
+	// size is the number of bytes reserved for this envelope while it is queued
+	size int64
+
+	// enqueued is when this envelope was offered to a queue, and serviced is when the write pump took it from the
+	// queue.  Their difference is observed by the QueueTimeHistogram.
+	enqueued time.Time
+	serviced time.Time
 }
 
 // Interface is the core type for this package.  It provides
@@ -57,9 +73,21 @@ type Interface interface {
 	// but we don't want to turn away duped devices.
 	ID() ID
 
+	// SessionID returns the identifier of this particular connection, which is unique even among
+	// duplicate sessions sharing the same ID.  A reconnecting device always receives a new SessionID.
+	SessionID() string
+
 	// Pending returns the count of pending messages for this device
 	Pending() int
 
+	// PendingTransactions returns the count of transactions sent to this device which
+	// are still awaiting a response
+	PendingTransactions() int
+
+	// CircuitState returns the state of this device's circuit breaker, which is always
+	// CircuitClosed unless a Manager was configured with Options.CircuitBreakerThreshold
+	CircuitState() CircuitState
+
 	// Closed tests if this device is closed.  When this method returns true,
 	// any attempt to send messages to this device will result in an error.
 	//
@@ -82,6 +110,12 @@ type Interface interface {
 	// Statistics returns the current, tracked Statistics instance for this device
 	Statistics() Statistics
 
+	// ReadRate returns the moving average, in bytes per second, of the data read from this device
+	ReadRate() float64
+
+	// WriteRate returns the moving average, in bytes per second, of the data written to this device
+	WriteRate() float64
+
 	// Convey returns a read-only view of the device convey information
 	Convey() convey.Interface
 
@@ -99,23 +133,62 @@ type Interface interface {
 	Trust() Trust
 }
 
+// DefaultMaxDebugTransactions is the upper limit on the number of transaction keys returned
+// by TransactionDebugger.PendingTransactionKeys
+const DefaultMaxDebugTransactions = 100
+
+// TransactionDebugger is an optional, diagnostic interface implemented by the devices a Manager creates.
+// It is deliberately not part of Interface:  listing transaction keys holds the device's transaction lock,
+// so it should only be used when investigating a specific device, e.g. one whose responses never arrive.
+type TransactionDebugger interface {
+	// PendingTransactionKeys returns up to max keys of the transactions awaiting a response.  If max is
+	// nonpositive or exceeds DefaultMaxDebugTransactions, DefaultMaxDebugTransactions is used instead.
+	PendingTransactionKeys(max int) []string
+}
+
 // device is the internal Interface implementation.  This type holds the internal
 // metadata exposed publicly, and provides some internal data structures for housekeeping.
 type device struct {
-	id ID
+	id        ID
+	sessionID string
 
 	errorLog log.Logger
 	infoLog  log.Logger
 	debugLog log.Logger
 
 	statistics Statistics
+	readRate   Rate
+	writeRate  Rate
+	now        func() time.Time
 
 	state int32
 
+	// closeReason is written once, just before shutdown is closed, and may be read by anything that has
+	// observed shutdown closing
+	closeReason CloseReason
+
 	shutdown     chan struct{}
+	closeFrames  chan []byte
+	events       *eventQueue
 	messages     chan *envelope
+	urgent       chan *envelope
 	transactions *Transactions
 
+	// chains orders the transactions within each chain routed to this device
+	chains chainLocks
+
+	// probes is nil for long-poll devices.  Otherwise, it holds the on-demand pings requested via Manager.Ping.
+	probes *probes
+
+	// pump is nil unless Options.SharedPumps is set, in which case the write pump must be woken whenever
+	// it is given work
+	pump *sharedPump
+
+	// queueLock guards queuesClosed.  Senders hold the read lock while enqueueing, which lets the write pump
+	// close both message queues before its final drain.
+	queueLock    sync.RWMutex
+	queuesClosed bool
+
 	c             convey.Interface
 	compliance    convey.Compliance
 	conveyClosure conveymetric.Closure
@@ -123,6 +196,69 @@ type device struct {
 	partnerIDs  []string
 	satClientID string
 
+	// partner is the tenant this device is counted against for quota purposes
+	partner string
+
+	// sourceIP is the address the device's connect request originated from, or UnknownSourceIP
+	sourceIP string
+
+	// onClose, if set, is invoked exactly once when this device is closed
+	onClose func()
+
+	// resumeToken is issued to this device at connect, and is empty unless the session may be resumed.
+	// resumeFrom is the token the device presented from a previous session, if any.
+	resumeToken string
+	resumeFrom  string
+
+	// park, if set, is invoked as this device closes.  It returns true if the device's pending transactions
+	// must be left open, because they were handed over to or are being held for a resuming session.
+	park func(CloseReason) bool
+
+	// resumed is set once a newer session has taken over this device's transactions, and is guarded by the
+	// registry's lock
+	resumed bool
+
+	// breaker guards transactions routed to this device, and is nil when disabled
+	breaker *circuitBreaker
+
+	// transactionOutcomes counts the outcome of each transaction sent to this device, and is nil when not measured
+	transactionOutcomes metrics.Counter
+
+	// outbound accounts for the bytes queued for this device, along with those of every other device of the
+	// same manager, and is nil when not accounted
+	outbound *outboundBytes
+
+	// credits limits the messages written to this device, and is nil unless the device negotiated flow control
+	credits *creditWindow
+
+	// batchLimit is the maximum number of messages written to this device in a single wrp.Batch frame.  Values
+	// below 2 mean the device did not negotiate batching.
+	batchLimit int
+
+	// allowedTypes is the set of message types this device may send, as determined by the MessageTypePolicy.
+	// A nil set allows every type.
+	allowedTypes messageTypeSet
+
+	// tap is the *frameTap, if any, that receives a copy of each inbound frame.  It is accessed atomically.
+	tap unsafe.Pointer
+
+	// passthrough indicates that this device exchanges raw frames rather than WRP messages
+	passthrough bool
+
+	// protocol is the layout of this device's inbound WRP messages
+	protocol wrp.ProtocolVersion
+
+	// format is the encoding of this device's inbound WRP messages, which is also the format of the contents
+	// of each Event and Response produced from them
+	format wrp.Format
+
+	// departing is closed once the device announces that it intends to disconnect, after which
+	// departure holds the device's announcement
+	departing   chan struct{}
+	departOnce  sync.Once
+	departure   *wrp.Disconnect
+	departBytes []byte
+
 	trust Trust
 }
 
@@ -132,16 +268,27 @@ type deviceOptions struct {
 	Compliance  convey.Compliance
 	PartnerIDs  []string
 	SatClientID string
+	Partner     string
+	SourceIP    string
 	Trust       Trust
 	QueueSize   int
+	RateWindow  time.Duration
 	ConnectedAt time.Time
+	Now         func() time.Time
 	Logger      log.Logger
+
+	// MaxPendingTransactions bounds the device's pending transactions, and is unbounded if nonpositive
+	MaxPendingTransactions int
 }
 
 // newDevice is an internal factory function for devices
 func newDevice(o deviceOptions) *device {
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+
 	if o.ConnectedAt.IsZero() {
-		o.ConnectedAt = time.Now()
+		o.ConnectedAt = o.Now()
 	}
 
 	if o.Logger == nil {
@@ -157,18 +304,27 @@ func newDevice(o deviceOptions) *device {
 
 	return &device{
 		id:           o.ID,
+		sessionID:    newSessionID(),
 		errorLog:     logging.Error(o.Logger, "id", o.ID),
 		infoLog:      logging.Info(o.Logger, "id", o.ID),
 		debugLog:     logging.Debug(o.Logger, "id", o.ID),
-		statistics:   NewStatistics(nil, o.ConnectedAt),
+		statistics:   NewStatistics(o.Now, o.ConnectedAt),
+		readRate:     NewRate(o.RateWindow, o.Now),
+		writeRate:    NewRate(o.RateWindow, o.Now),
+		now:          o.Now,
 		c:            o.C,
 		compliance:   o.Compliance,
 		state:        stateOpen,
 		shutdown:     make(chan struct{}),
+		closeFrames:  make(chan []byte, 1),
+		departing:    make(chan struct{}),
 		messages:     make(chan *envelope, o.QueueSize),
-		transactions: NewTransactions(),
+		urgent:       make(chan *envelope, urgentQueueSize),
+		transactions: NewBoundedTransactions(o.MaxPendingTransactions),
 		partnerIDs:   partnerIDs,
 		satClientID:  o.SatClientID,
+		partner:      o.Partner,
+		sourceIP:     o.SourceIP,
 		trust:        o.Trust,
 	}
 }
@@ -191,10 +347,33 @@ func (d *device) MarshalJSON() ([]byte, error) {
 	return output.Bytes(), err
 }
 
-func (d *device) requestClose() error {
+// requestCodedClose asks the write pump to send the device a close frame with the given code and text.
+// The device itself is not closed by this method, which allows in-flight transactions to complete.
+// While a previous request is pending, further requests are ignored and this method returns false.
+func (d *device) requestCodedClose(reason CloseReason) bool {
+	select {
+	case d.closeFrames <- reason.frame():
+		d.pump.wake()
+		return true
+	default:
+		return false
+	}
+}
+
+// requestClose closes this device, which causes the write pump to send the device a close frame with the given
+// reason before closing the connection.  Only the first reason is used.
+func (d *device) requestClose(reason CloseReason) error {
 	if atomic.CompareAndSwapInt32(&d.state, stateOpen, stateClosed) {
+		d.closeReason = reason
 		close(d.shutdown)
-		d.transactions.Close()
+		d.pump.wake()
+		if d.park == nil || !d.park(reason) {
+			d.transactions.Close()
+		}
+
+		if d.onClose != nil {
+			d.onClose()
+		}
 	}
 
 	return nil
@@ -204,14 +383,82 @@ func (d *device) ID() ID {
 	return d.id
 }
 
+func (d *device) SessionID() string {
+	return d.sessionID
+}
+
 func (d *device) Pending() int {
 	return len(d.messages)
 }
 
+func (d *device) PendingTransactions() int {
+	return d.transactions.Len()
+}
+
+func (d *device) CircuitState() CircuitState {
+	return d.breaker.circuitState()
+}
+
+func (d *device) PendingTransactionKeys(max int) []string {
+	if max <= 0 || max > DefaultMaxDebugTransactions {
+		max = DefaultMaxDebugTransactions
+	}
+
+	return d.transactions.SampleKeys(max)
+}
+
 func (d *device) Closed() bool {
 	return atomic.LoadInt32(&d.state) != stateOpen
 }
 
+// enqueue places an envelope on one of this device's write queues, blocking until there is room, the context is
+// cancelled, or the device is closed.  Once closeQueues has been called, this method always returns ErrorDeviceClosed.
+func (d *device) enqueue(ctx context.Context, queue chan<- *envelope, e *envelope) error {
+	d.queueLock.RLock()
+	defer d.queueLock.RUnlock()
+	if d.queuesClosed {
+		return ErrorDeviceClosed
+	}
+
+	e.size = requestSize(e.request)
+	if err := d.outbound.reserve(e.size); err != nil {
+		return err
+	}
+
+	// stamped before the send, as the write pump owns the envelope once it is queued.  time blocked on a full
+	// queue is included, which is also backpressure.
+	e.enqueued = d.now()
+
+	// the write pump is woken first in case the queue is full, and again once the envelope is queued
+	d.pump.wake()
+	select {
+	case <-ctx.Done():
+		d.outbound.release(e.size)
+		return ctx.Err()
+	case <-d.shutdown:
+		d.outbound.release(e.size)
+		return ErrorDeviceClosed
+	case queue <- e:
+		d.pump.wake()
+		return nil
+	}
+}
+
+// dequeued releases the bytes reserved for an envelope taken from one of this device's write queues.  It must
+// be called exactly once for each such envelope, whatever its outcome.
+func (d *device) dequeued(e *envelope) {
+	d.outbound.release(e.size)
+}
+
+// closeQueues prevents any further envelopes from being enqueued.  It must only be called after the device is
+// closed, so that senders blocked on a full queue are released.  When this method returns, every envelope that
+// will ever be enqueued is already on a queue, so a drain that follows cannot miss one.
+func (d *device) closeQueues() {
+	d.queueLock.Lock()
+	d.queuesClosed = true
+	d.queueLock.Unlock()
+}
+
 // sendRequest attempts to enqueue the given request for the write pump that is
 // servicing this device.  This method honors the request context's cancellation semantics.
 //
@@ -222,18 +469,14 @@ func (d *device) sendRequest(request *Request) error {
 		done     = request.Context().Done()
 		complete = make(chan error, 1)
 		envelope = &envelope{
-			request,
-			complete,
+			request:  request,
+			complete: complete,
 		}
 	)
 
 	// attempt to enqueue the message
-	select {
-	case <-done:
-		return request.Context().Err()
-	case <-d.shutdown:
-		return ErrorDeviceClosed
-	case d.messages <- envelope:
+	if err := d.enqueue(request.Context(), d.messages, envelope); err != nil {
+		return err
 	}
 
 	// once enqueued, wait until the context is cancelled
@@ -250,25 +493,49 @@ func (d *device) sendRequest(request *Request) error {
 
 // awaitResponse waits for the read pump to acquire a response that corresponds to the
 // request's transaction key.  The result channel will receive the response from the
-// read pump.
-func (d *device) awaitResponse(request *Request, result <-chan *Response) (*Response, error) {
+// read pump.  The outcome of the wait is recorded in the device's transaction metrics.
+func (d *device) awaitResponse(request *Request, result <-chan *Response) (response *Response, err error) {
+	var (
+		resumable = len(d.resumeToken) > 0
+		shutdown  = d.shutdown
+	)
+
+	if resumable {
+		// a resumable session's transactions outlive its connection, and are closed if the session is not resumed
+		shutdown = nil
+	}
+
 	select {
 	case <-request.Context().Done():
-		return nil, request.Context().Err()
-	case <-d.shutdown:
-		return nil, ErrorDeviceClosed
-	case response := <-result:
-		if response == nil {
-			return nil, ErrorTransactionCancelled
+		err = request.Context().Err()
+	case <-shutdown:
+		err = ErrorDeviceClosed
+	case response = <-result:
+		if response == nil && resumable && d.Closed() {
+			err = ErrorDeviceClosed
+		} else if response == nil {
+			err = ErrorTransactionCancelled
 		}
-
-		return response, nil
 	}
+
+	recordTransaction(d.transactionOutcomes, transactionOutcome(err))
+	return
 }
 
 func (d *device) Send(request *Request) (*Response, error) {
 	if d.Closed() {
 		return nil, ErrorDeviceClosed
+	} else if _, _, departing := d.departed(); departing {
+		return nil, ErrorDeviceDisconnecting
+	}
+
+	if d.passthrough {
+		return nil, d.sendPassthrough(request)
+	}
+
+	request, err := request.prepareAck()
+	if err != nil {
+		return nil, err
 	}
 
 	var (
@@ -277,7 +544,6 @@ func (d *device) Send(request *Request) (*Response, error) {
 	)
 
 	if transactional {
-		var err error
 		if result, err = d.transactions.Register(transactionKey); err != nil {
 			// if a transaction key cannot be registered, we don't want to proceed.
 			// this indicates some larger problem, most often a duplicate transaction key.
@@ -304,6 +570,14 @@ func (d *device) Statistics() Statistics {
 	return d.statistics
 }
 
+func (d *device) ReadRate() float64 {
+	return d.readRate.Value()
+}
+
+func (d *device) WriteRate() float64 {
+	return d.writeRate.Value()
+}
+
 func (d *device) Convey() convey.Interface {
 	return d.c
 }
@@ -94,9 +94,9 @@ func TestDevice(t *testing.T) {
 		cancel()
 
 		assert.False(device.Closed())
-		device.requestClose()
+		device.requestClose(CloseDisconnected)
 		assert.True(device.Closed())
-		device.requestClose()
+		device.requestClose(CloseDisconnected)
 		assert.True(device.Closed())
 
 		response, err := device.Send(&Request{Message: testMessage})
@@ -104,3 +104,91 @@ func TestDevice(t *testing.T) {
 		assert.Error(err)
 	}
 }
+
+func TestDeviceNow(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		expectedNow = time.Now().Add(-time.Hour).UTC()
+		device      = newDevice(deviceOptions{
+			ID:     ID("test"),
+			Logger: logging.NewTestLogger(nil, t),
+			Now:    func() time.Time { return expectedNow },
+		})
+	)
+
+	assert.Equal(expectedNow, device.Statistics().ConnectedAt())
+	assert.Zero(device.Statistics().UpTime())
+}
+
+var _ TransactionDebugger = (*device)(nil)
+
+func TestDevicePendingTransactions(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		device  = newDevice(deviceOptions{
+			ID:     ID("test"),
+			Logger: logging.NewTestLogger(nil, t),
+		})
+	)
+
+	assert.Zero(device.PendingTransactions())
+	assert.Empty(device.PendingTransactionKeys(0))
+
+	for i := 0; i < DefaultMaxDebugTransactions+10; i++ {
+		_, err := device.transactions.Register(fmt.Sprintf("transaction-%d", i))
+		require.NoError(err)
+	}
+
+	assert.Equal(DefaultMaxDebugTransactions+10, device.PendingTransactions())
+	assert.Len(device.PendingTransactionKeys(5), 5)
+	assert.Len(device.PendingTransactionKeys(0), DefaultMaxDebugTransactions)
+	assert.Len(device.PendingTransactionKeys(DefaultMaxDebugTransactions+10), DefaultMaxDebugTransactions)
+}
+
+func TestDeviceMaxPendingTransactions(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		ctx, cancel = context.WithCancel(context.Background())
+		sendErrors  = make(chan error, 3)
+		device      = newDevice(deviceOptions{
+			ID:                     ID("test"),
+			MaxPendingTransactions: 3,
+			Logger:                 logging.NewTestLogger(nil, t),
+		})
+	)
+
+	defer cancel()
+	request := func(transactionKey string) *Request {
+		return (&Request{
+			Message: &wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				TransactionUUID: transactionKey,
+			},
+		}).WithContext(ctx)
+	}
+
+	// nothing services this device, so each transaction stays pending until cancelled
+	for i := 0; i < 3; i++ {
+		go func(transactionKey string) {
+			_, err := device.Send(request(transactionKey))
+			sendErrors <- err
+		}(fmt.Sprintf("transaction-%d", i))
+	}
+
+	for device.PendingTransactions() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	response, err := device.Send(request("rejected"))
+	assert.Nil(response)
+	assert.Equal(ErrorTooManyTransactions, err)
+	assert.Equal(3, device.PendingTransactions())
+
+	cancel()
+	for i := 0; i < 3; i++ {
+		assert.Error(<-sendErrors)
+	}
+
+	assert.Zero(device.PendingTransactions())
+}
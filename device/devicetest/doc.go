@@ -0,0 +1,8 @@
+/*
+Package devicetest provides in-memory infrastructure for testing code that uses a device.Manager.
+
+A Server hosts a real device.Manager behind an HTTP server whose connections are buffered, in-memory
+pipes.  Devices connect over genuine websockets and are serviced by the usual read and write pumps,
+but no network is involved.
+*/
+package devicetest
@@ -0,0 +1,55 @@
+package devicetest
+
+import (
+	"fmt"
+
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+func ExampleServer() {
+	server := NewServer(nil)
+	defer server.Close()
+
+	_, c, err := server.Connect(device.ID("mac:112233445566"), nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// the device answers a single request, echoing the payload
+	go func() {
+		request, err := ReadMessage(c)
+		if err != nil {
+			return
+		}
+
+		WriteMessage(c, &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          request.Destination,
+			Destination:     request.Source,
+			TransactionUUID: request.TransactionUUID,
+			Payload:         request.Payload,
+		})
+	}()
+
+	response, err := server.Manager().Route(&device.Request{
+		Message: &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "dns:example.com",
+			Destination:     "mac:112233445566",
+			TransactionUUID: "example-transaction",
+			Payload:         []byte("hello"),
+		},
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(response.Message.TransactionUUID, string(response.Message.Payload))
+
+	// Output:
+	// example-transaction hello
+}
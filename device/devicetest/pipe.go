@@ -0,0 +1,216 @@
+package devicetest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var errListenerClosed = errors.New("The pipe listener has been closed")
+
+// timeoutError is returned when a read deadline expires.  It implements net.Error, as
+// callers such as the websocket library expect.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// pipeAddr is the net.Addr for both ends of an in-memory connection
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeBuffer is one direction of an in-memory connection.  Unlike net.Pipe, writes never wait for a
+// reader, which mirrors the buffering of a real network connection.  That matters to the pumps: for example,
+// a Route to a device must be able to complete its write before the device reads the message.
+type pipeBuffer struct {
+	lock   sync.Mutex
+	data   bytes.Buffer
+	closed bool
+	notify chan struct{}
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// signal wakes up any waiting reader
+func (pb *pipeBuffer) signal() {
+	select {
+	case pb.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (pb *pipeBuffer) write(p []byte) (int, error) {
+	pb.lock.Lock()
+	if pb.closed {
+		pb.lock.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+
+	n, err := pb.data.Write(p)
+	pb.lock.Unlock()
+	pb.signal()
+	return n, err
+}
+
+// read blocks until data is available, this buffer is closed, or the deadline function's current value passes
+func (pb *pipeBuffer) read(p []byte, deadline func() time.Time) (int, error) {
+	for {
+		pb.lock.Lock()
+		if pb.data.Len() > 0 {
+			n, err := pb.data.Read(p)
+			pb.lock.Unlock()
+			return n, err
+		}
+
+		closed := pb.closed
+		pb.lock.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+
+		d := deadline()
+		if d.IsZero() {
+			<-pb.notify
+			continue
+		}
+
+		remaining := time.Until(d)
+		if remaining <= 0 {
+			return 0, timeoutError{}
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-pb.notify:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+func (pb *pipeBuffer) close() {
+	pb.lock.Lock()
+	pb.closed = true
+	pb.lock.Unlock()
+	pb.signal()
+}
+
+// pipeConn is one end of an in-memory, buffered connection
+type pipeConn struct {
+	in  *pipeBuffer
+	out *pipeBuffer
+
+	lock         sync.Mutex
+	readDeadline time.Time
+}
+
+// newPipe creates both ends of an in-memory connection
+func newPipe() (net.Conn, net.Conn) {
+	var (
+		a = newPipeBuffer()
+		b = newPipeBuffer()
+	)
+
+	return &pipeConn{in: a, out: b}, &pipeConn{in: b, out: a}
+}
+
+func (pc *pipeConn) deadline() time.Time {
+	pc.lock.Lock()
+	d := pc.readDeadline
+	pc.lock.Unlock()
+	return d
+}
+
+func (pc *pipeConn) Read(p []byte) (int, error) {
+	return pc.in.read(p, pc.deadline)
+}
+
+func (pc *pipeConn) Write(p []byte) (int, error) {
+	return pc.out.write(p)
+}
+
+// Close closes both directions, so that each end observes EOF
+func (pc *pipeConn) Close() error {
+	pc.in.close()
+	pc.out.close()
+	return nil
+}
+
+func (pc *pipeConn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (pc *pipeConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+func (pc *pipeConn) SetDeadline(t time.Time) error {
+	return pc.SetReadDeadline(t)
+}
+
+func (pc *pipeConn) SetReadDeadline(t time.Time) error {
+	pc.lock.Lock()
+	pc.readDeadline = t
+	pc.lock.Unlock()
+
+	// wake up any reader so that it observes the new deadline
+	pc.in.signal()
+	return nil
+}
+
+// SetWriteDeadline does nothing, as writes never block
+func (pc *pipeConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// pipeListener is a net.Listener whose connections are created in memory
+type pipeListener struct {
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (pl *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-pl.conns:
+		return c, nil
+	case <-pl.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (pl *pipeListener) Close() error {
+	pl.closeOnce.Do(func() { close(pl.closed) })
+	return nil
+}
+
+func (pl *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// dial creates a new in-memory connection, handing the server end to Accept.  The network and
+// address are ignored, which allows this method to be used as a websocket.Dialer's NetDial.
+func (pl *pipeListener) dial(network, address string) (net.Conn, error) {
+	server, client := newPipe()
+	select {
+	case pl.conns <- server:
+		return client, nil
+	case <-pl.closed:
+		server.Close()
+		client.Close()
+		return nil, errListenerClosed
+	}
+}
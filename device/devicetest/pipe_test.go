@@ -0,0 +1,98 @@
+package devicetest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPipeBuffered(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		require        = require.New(t)
+		server, client = newPipe()
+		buffer         = make([]byte, 16)
+	)
+
+	// writes complete without a reader
+	n, err := server.Write([]byte("hello"))
+	require.NoError(err)
+	assert.Equal(5, n)
+
+	n, err = client.Read(buffer)
+	require.NoError(err)
+	assert.Equal("hello", string(buffer[:n]))
+
+	require.NoError(client.Close())
+	_, err = server.Read(buffer)
+	assert.Equal(io.EOF, err)
+	_, err = server.Write([]byte("hello"))
+	assert.Error(err)
+}
+
+func testPipeReadDeadline(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		require        = require.New(t)
+		server, client = newPipe()
+		buffer         = make([]byte, 16)
+	)
+
+	require.NoError(client.SetReadDeadline(time.Now().Add(10 * time.Millisecond)))
+	_, err := client.Read(buffer)
+	require.Error(err)
+	netError, ok := err.(net.Error)
+	require.True(ok)
+	assert.True(netError.Timeout())
+
+	// clearing the deadline allows reads to block until data arrives
+	require.NoError(client.SetReadDeadline(time.Time{}))
+	go server.Write([]byte("data"))
+	n, err := client.Read(buffer)
+	require.NoError(err)
+	assert.Equal("data", string(buffer[:n]))
+}
+
+func testPipeListener(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		listener = newPipeListener()
+		accepted = make(chan net.Conn, 1)
+	)
+
+	assert.Equal("pipe", listener.Addr().Network())
+
+	go func() {
+		c, err := listener.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	client, err := listener.dial("tcp", "ignored")
+	require.NoError(err)
+	server := <-accepted
+
+	client.Write([]byte("ping"))
+	buffer := make([]byte, 4)
+	_, err = io.ReadFull(server, buffer)
+	require.NoError(err)
+	assert.Equal("ping", string(buffer))
+
+	require.NoError(listener.Close())
+	_, err = listener.Accept()
+	assert.Error(err)
+	_, err = listener.dial("tcp", "ignored")
+	assert.Error(err)
+}
+
+func TestPipe(t *testing.T) {
+	t.Run("Buffered", testPipeBuffered)
+	t.Run("ReadDeadline", testPipeReadDeadline)
+	t.Run("Listener", testPipeListener)
+}
@@ -0,0 +1,168 @@
+package devicetest
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"github.com/justinas/alice"
+)
+
+const (
+	// ConnectURL is the URL devices dial.  Since connections are in memory, the host is never resolved.
+	ConnectURL = "ws://devicetest/api/v2/device"
+
+	// DefaultConnectTimeout is how long Connect waits for a device to be registered with the Manager
+	DefaultConnectTimeout = 5 * time.Second
+)
+
+var errConnectTimeout = errors.New("The device was not registered before the connect timeout")
+
+// Server hosts a device.Manager to which in-memory devices can connect.  Devices are full websocket
+// clients, and the Manager services them with its usual read and write pumps.
+type Server struct {
+	manager  device.Manager
+	listener *pipeListener
+	server   *http.Server
+	dialer   device.Dialer
+
+	lock    sync.Mutex
+	waiters map[device.ID][]chan device.Interface
+}
+
+// NewServer starts a Server hosting a Manager created from the given options, which may be nil.
+// The options are copied rather than modified.  Callers must Close the returned Server.
+func NewServer(o *device.Options) *Server {
+	var options device.Options
+	if o != nil {
+		options = *o
+	}
+
+	s := &Server{
+		listener: newPipeListener(),
+		waiters:  make(map[device.ID][]chan device.Interface),
+	}
+
+	options.Listeners = append(append([]device.Listener{}, options.Listeners...), s.onEvent)
+	s.manager = device.NewManager(&options)
+	s.dialer = device.NewDialer(device.DialerOptions{
+		WSDialer: &websocket.Dialer{NetDial: s.listener.dial},
+	})
+
+	s.server = &http.Server{
+		Handler: alice.New(device.Timeout(&options), device.UseID.FromHeader).Then(
+			&device.ConnectHandler{
+				Logger:    options.Logger,
+				Connector: s.manager,
+			},
+		),
+	}
+
+	go s.server.Serve(s.listener)
+	return s
+}
+
+// onEvent is the listener which notifies Connect when a device has been registered
+func (s *Server) onEvent(e *device.Event) {
+	if e.Type != device.Connect {
+		return
+	}
+
+	id := e.Device.ID()
+	s.lock.Lock()
+	waiters := s.waiters[id]
+	delete(s.waiters, id)
+	s.lock.Unlock()
+
+	for _, w := range waiters {
+		w <- e.Device
+	}
+}
+
+// Manager returns the device.Manager hosted by this Server
+func (s *Server) Manager() device.Manager {
+	return s.manager
+}
+
+// Connect dials a device with the given ID, returning both the Manager's view of the device and the
+// device's end of the websocket.  This method returns once the device has been registered, so it can be
+// immediately routed to.  The extra header, which is optional, is passed along with the connection request.
+func (s *Server) Connect(id device.ID, extra http.Header) (device.Interface, *websocket.Conn, error) {
+	registered := make(chan device.Interface, 1)
+	s.lock.Lock()
+	s.waiters[id] = append(s.waiters[id], registered)
+	s.lock.Unlock()
+
+	c, _, err := s.dialer.DialDevice(string(id), ConnectURL, extra)
+	if err != nil {
+		s.removeWaiter(id, registered)
+		return nil, nil, err
+	}
+
+	timer := time.NewTimer(DefaultConnectTimeout)
+	defer timer.Stop()
+
+	select {
+	case d := <-registered:
+		return d, c, nil
+	case <-timer.C:
+		s.removeWaiter(id, registered)
+		c.Close()
+		return nil, nil, errConnectTimeout
+	}
+}
+
+func (s *Server) removeWaiter(id device.ID, registered chan device.Interface) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	waiters := s.waiters[id]
+	for i, w := range waiters {
+		if w == registered {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) > 0 {
+		s.waiters[id] = waiters
+	} else {
+		delete(s.waiters, id)
+	}
+}
+
+// Close disconnects all devices and shuts down this Server
+func (s *Server) Close() error {
+	s.manager.DisconnectAll()
+	return s.server.Close()
+}
+
+// ReadMessage reads the next WRP message sent to a device.  Frames are expected to be Msgpack,
+// which is what a Manager always sends.
+func ReadMessage(c *websocket.Conn) (*wrp.Message, error) {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	message := new(wrp.Message)
+	if err := wrp.NewDecoderBytes(data, wrp.Msgpack).Decode(message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// WriteMessage sends a WRP message from a device to the Manager, encoded as Msgpack
+func WriteMessage(c *websocket.Conn, message *wrp.Message) error {
+	var data []byte
+	if err := wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(message); err != nil {
+		return err
+	}
+
+	return c.WriteMessage(websocket.BinaryMessage, data)
+}
@@ -0,0 +1,109 @@
+package devicetest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testServerEvents(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		events  = make(chan *device.Event, 10)
+
+		server = NewServer(&device.Options{
+			Listeners: []device.Listener{
+				func(e *device.Event) {
+					if e.Type == device.MessageReceived || e.Type == device.Disconnect {
+						events <- e
+					}
+				},
+			},
+		})
+	)
+
+	defer server.Close()
+
+	d, c, err := server.Connect(device.ID("mac:112233445566"), http.Header{device.ConveyHeader: []string{"eyJmb28iOiJiYXIifQ=="}})
+	require.NoError(err)
+	require.NotNil(d)
+	assert.Equal(device.ID("mac:112233445566"), d.ID())
+	assert.Equal(1, server.Manager().Len())
+	value, ok := d.Convey().Get("foo")
+	assert.True(ok)
+	assert.Equal("bar", value)
+
+	require.NoError(WriteMessage(c, &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:112233445566", Destination: "event:test"}))
+
+	select {
+	case e := <-events:
+		assert.Equal(device.MessageReceived, e.Type)
+		assert.Equal("event:test", e.Message.(*wrp.Message).Destination)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no MessageReceived event")
+	}
+
+	assert.True(server.Manager().Disconnect(d.ID()))
+	select {
+	case e := <-events:
+		assert.Equal(device.Disconnect, e.Type)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no Disconnect event")
+	}
+
+	_, err = ReadMessage(c)
+	assert.Error(err)
+}
+
+func testServerRoute(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		server  = NewServer(nil)
+	)
+
+	defer server.Close()
+
+	_, c, err := server.Connect(device.ID("mac:112233445566"), nil)
+	require.NoError(err)
+
+	_, err = server.Manager().Route(&device.Request{
+		Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "dns:test", Destination: "mac:112233445566", Payload: []byte("event")},
+	})
+
+	require.NoError(err)
+	message, err := ReadMessage(c)
+	require.NoError(err)
+	assert.Equal([]byte("event"), message.Payload)
+
+	_, err = server.Manager().Route(&device.Request{
+		Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "dns:test", Destination: "mac:665544332211"},
+	})
+
+	assert.Equal(device.ErrorDeviceNotFound, err)
+}
+
+func testServerClosed(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		server = NewServer(nil)
+	)
+
+	assert.NoError(server.Close())
+	d, c, err := server.Connect(device.ID("mac:112233445566"), nil)
+	assert.Nil(d)
+	assert.Nil(c)
+	assert.Error(err)
+}
+
+func TestServer(t *testing.T) {
+	t.Run("Events", testServerEvents)
+	t.Run("Route", testServerRoute)
+	t.Run("Closed", testServerClosed)
+}
@@ -0,0 +1,49 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// DeviceInitiatedReason is the Event.Reason of a Disconnect event for a device that announced its disconnection
+// with a wrp.Disconnect message, e.g. before a planned sleep.  Such disconnections are expected, and should not
+// be counted as failures.
+const DeviceInitiatedReason = "device-initiated"
+
+// requestDeviceDisconnect records a device's announcement that it intends to disconnect, and asks the write pump
+// to finish writing any queued messages and then close the connection.  Sends made after this method is called
+// fail with ErrorDeviceDisconnecting.  Only the first announcement is recorded.
+func (d *device) requestDeviceDisconnect(message *wrp.Disconnect, contents []byte) {
+	d.departOnce.Do(func() {
+		d.departure = message
+		d.departBytes = contents
+		close(d.departing)
+		d.pump.wake()
+	})
+}
+
+// departed returns the device's disconnect announcement, if one was made
+func (d *device) departed() (*wrp.Disconnect, []byte, bool) {
+	select {
+	case <-d.departing:
+		return d.departure, d.departBytes, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// disconnectEvent creates the Disconnect event for a device, which reflects any announcement the device made
+func disconnectEvent(d *device) *Event {
+	event := &Event{
+		Type:   Disconnect,
+		Device: d,
+	}
+
+	if message, contents, ok := d.departed(); ok {
+		event.Reason = DeviceInitiatedReason
+		event.Message = message
+		event.Format = d.format
+		event.Contents = contents
+	}
+
+	return event
+}
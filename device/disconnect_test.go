@@ -0,0 +1,114 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceDisconnectAnnouncement(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(deviceOptions{ID: ID("test")})
+
+		first  = &wrp.Disconnect{Reason: wrp.DisconnectReasonSleep}
+		second = &wrp.Disconnect{Reason: wrp.DisconnectReasonShutdown}
+	)
+
+	_, _, ok := d.departed()
+	assert.False(ok)
+
+	event := disconnectEvent(d)
+	assert.Equal(Disconnect, event.Type)
+	assert.Empty(event.Reason)
+	assert.Nil(event.Message)
+
+	d.requestDeviceDisconnect(first, []byte("first"))
+	d.requestDeviceDisconnect(second, []byte("second"))
+
+	message, contents, ok := d.departed()
+	assert.True(ok)
+	assert.True(first == message)
+	assert.Equal("first", string(contents))
+
+	event = disconnectEvent(d)
+	assert.Equal(DeviceInitiatedReason, event.Reason)
+	assert.True(first == event.Message)
+	assert.Equal(wrp.Msgpack, event.Format)
+	assert.Equal("first", string(event.Contents))
+
+	response, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType}})
+	assert.Nil(response)
+	assert.Equal(ErrorDeviceDisconnecting, err)
+	assert.False(d.Closed())
+}
+
+func TestManagerDeviceInitiatedDisconnect(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		disconnects = make(chan *Event, 1)
+
+		m, d, c = registerTestDevice(t, &Options{
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == Disconnect {
+						disconnects <- e
+					}
+				},
+			},
+		})
+	)
+
+	// queue messages before the pumps start, so that they are still queued when the device disconnects
+	sendErrors := make(chan error, 2)
+	for _, payload := range []string{"first", "second"} {
+		go func(payload string) {
+			_, err := d.Send(&Request{
+				Message: &wrp.Message{
+					Type:        wrp.SimpleEventMessageType,
+					Destination: string(d.ID()),
+					Payload:     []byte(payload),
+				},
+			})
+
+			sendErrors <- err
+		}(payload)
+	}
+
+	for d.Pending() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	m.startPumps(d, c, func() error { return nil })
+	c.inbound <- wrp.MustEncode(&wrp.Disconnect{Reason: wrp.DisconnectReasonSleep}, wrp.Msgpack)
+
+	// the queued messages are still written before the connection closes
+	var payloads []string
+	for len(payloads) < 2 {
+		var actual wrp.Message
+		require.NoError(wrp.NewDecoderBytes(<-c.outbound, wrp.Msgpack).Decode(&actual))
+		payloads = append(payloads, string(actual.Payload))
+	}
+
+	assert.ElementsMatch([]string{"first", "second"}, payloads)
+	assert.NoError(<-sendErrors)
+	assert.NoError(<-sendErrors)
+
+	select {
+	case e := <-disconnects:
+		assert.Equal(DeviceInitiatedReason, e.Reason)
+		require.IsType(new(wrp.Disconnect), e.Message)
+		assert.Equal(wrp.DisconnectReasonSleep, e.Message.(*wrp.Disconnect).Reason)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no Disconnect event was dispatched")
+	}
+
+	assert.True(d.Closed())
+
+	_, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType}})
+	assert.Equal(ErrorDeviceClosed, err)
+}
@@ -0,0 +1,236 @@
+package device
+
+import (
+	"sync"
+
+	"github.com/Comcast/webpa-common/xmetrics"
+)
+
+// DispatchMode determines how a Manager delivers events to its listeners
+type DispatchMode string
+
+const (
+	// DispatchInline delivers each event to listeners on the goroutine that produced it, typically
+	// a device's read or write pump.  This is the default.  It has the least overhead, but a slow
+	// listener stalls the pumps of every device.
+	DispatchInline DispatchMode = "inline"
+
+	// DispatchAsync delivers each device's events to listeners on a dedicated goroutine for that device,
+	// through a bounded buffer.  Events for a given device are delivered in the order they occurred, but
+	// a slow listener only delays the devices whose events it is processing.  Events for different devices
+	// may be delivered concurrently, so listeners must be safe for concurrent use.
+	DispatchAsync DispatchMode = "async"
+)
+
+// OverflowPolicy determines what happens when a device's event buffer is full under DispatchAsync.  It is
+// also used by ChannelListener.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock blocks the goroutine producing the event until there is room in the buffer.  No events
+	// are lost, but a slow listener can stall that device's pumps.  This is the default.
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowDropOldest discards the oldest buffered event to make room for the new one.  The device's
+	// pumps are never stalled, but listeners may miss events.  Each dropped event is counted by the
+	// DroppedEventCounter metric.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowDropNewest discards the new event, leaving the buffered events as they are.  Like
+	// OverflowDropOldest, the device's pumps are never stalled and each dropped event is counted.
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+)
+
+const (
+	// DefaultEventBufferSize is the default capacity of each device's event buffer under DispatchAsync
+	DefaultEventBufferSize = 100
+)
+
+// EventPool recycles Events so that code which buffers events for delivery on another goroutine can isolate each
+// event from the code that produced it without allocating.  The zero value is ready to use, and an EventPool is safe
+// for concurrent use.
+//
+// Ownership of a pooled Event is exclusive:  the caller of Get owns the Event until it calls Put, after which the
+// Event must not be used in any way.  A Manager created with Options.PoolEvents uses a single EventPool under
+// DispatchAsync, copying each event it queues into a pooled Event.  The manager owns that copy, lending it to each
+// Listener for the duration of its call, and returns it to the pool once every Listener has returned.  As with any
+// Listener, one that needs an event afterwards must copy it, as ChannelListener does.
+type EventPool struct {
+	pool sync.Pool
+}
+
+// Get returns an Event for the exclusive use of the caller.  All of its fields are unset.
+func (ep *EventPool) Get() *Event {
+	if e, ok := ep.pool.Get().(*Event); ok {
+		return e
+	}
+
+	return new(Event)
+}
+
+// Put clears an Event and returns it to the pool.  Neither the caller nor anything the Event was lent to may
+// use the Event after this method is called.  A nil Event is ignored.
+func (ep *EventPool) Put(e *Event) {
+	if e != nil {
+		*e = Event{}
+		ep.pool.Put(e)
+	}
+}
+
+// newManagerEventPool returns the EventPool shared by all of a manager's event queues.  If events are not pooled,
+// this function returns nil.
+func newManagerEventPool(o *Options) *EventPool {
+	if o.poolEvents() && o.dispatchMode() == DispatchAsync {
+		return new(EventPool)
+	}
+
+	return nil
+}
+
+// queuedEvent is an event awaiting delivery.  If delivered is set, it is closed once all listeners
+// have received the event.
+type queuedEvent struct {
+	event     *Event
+	delivered chan struct{}
+}
+
+// eventQueue delivers a single device's events on a dedicated goroutine.  The goroutine is started
+// when the first event is enqueued, and exits once the queue is closed and all buffered events
+// have been delivered.
+type eventQueue struct {
+	events   chan queuedEvent
+	overflow OverflowPolicy
+
+	// first, if set by enqueueFirst, is delivered before any buffered event and is never dropped
+	first *queuedEvent
+
+	dropped  xmetrics.Incrementer
+	dispatch func(*Event)
+
+	// pool, if set, holds the copies of enqueued events, each of which is returned once delivered or dropped
+	pool *EventPool
+
+	start sync.Once
+	stop  sync.Once
+}
+
+func newEventQueue(size int, overflow OverflowPolicy, dropped xmetrics.Incrementer, dispatch func(*Event)) *eventQueue {
+	return &eventQueue{
+		events:   make(chan queuedEvent, size),
+		overflow: overflow,
+		dropped:  dropped,
+		dispatch: dispatch,
+	}
+}
+
+func (eq *eventQueue) run() {
+	if eq.first != nil {
+		eq.deliver(*eq.first)
+	}
+
+	for qe := range eq.events {
+		eq.deliver(qe)
+	}
+}
+
+func (eq *eventQueue) deliver(qe queuedEvent) {
+	eq.dispatch(qe.event)
+	eq.release(qe)
+	if qe.delivered != nil {
+		close(qe.delivered)
+	}
+}
+
+// own returns the event to queue in place of the given one.  With a pool, this is a pooled copy, so that the
+// producer may reuse its event as soon as it has been enqueued.
+func (eq *eventQueue) own(e *Event) *Event {
+	if eq.pool == nil {
+		return e
+	}
+
+	c := eq.pool.Get()
+	*c = *e
+	return c
+}
+
+// release returns a queued event to the pool, if there is one, once it has been delivered or dropped
+func (eq *eventQueue) release(qe queuedEvent) {
+	if eq.pool != nil {
+		eq.pool.Put(qe.event)
+	}
+}
+
+// enqueue buffers an event for delivery, honoring the overflow policy.  This method must
+// not be called after close.
+func (eq *eventQueue) enqueue(e *Event) {
+	eq.push(queuedEvent{event: eq.own(e)})
+}
+
+// enqueueAck is like enqueue, but returns a channel that is closed once the event has been delivered.
+// If the event is dropped due to overflow, the returned channel is never closed.
+func (eq *eventQueue) enqueueAck(e *Event) <-chan struct{} {
+	delivered := make(chan struct{})
+	eq.push(queuedEvent{event: eq.own(e), delivered: delivered})
+	return delivered
+}
+
+// enqueueFirst is like enqueueAck, but the event is held outside the buffer so that it is always delivered
+// before any other event and is never dropped due to overflow.  This is how a device's Connect event is
+// queued.  If other events were already enqueued, this method falls back to enqueueAck.
+func (eq *eventQueue) enqueueFirst(e *Event) <-chan struct{} {
+	var (
+		qe    = queuedEvent{event: eq.own(e), delivered: make(chan struct{})}
+		first = false
+	)
+
+	eq.start.Do(func() {
+		first = true
+		eq.first = &qe
+		go eq.run()
+	})
+
+	if !first {
+		eq.push(qe)
+	}
+
+	return qe.delivered
+}
+
+func (eq *eventQueue) push(qe queuedEvent) {
+	eq.start.Do(func() { go eq.run() })
+
+	switch eq.overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case eq.events <- qe:
+				return
+			default:
+			}
+
+			select {
+			case dropped := <-eq.events:
+				eq.release(dropped)
+				eq.dropped.Inc()
+			default:
+				// the dispatch goroutine made room in the meantime
+			}
+		}
+
+	case OverflowDropNewest:
+		select {
+		case eq.events <- qe:
+		default:
+			eq.release(qe)
+			eq.dropped.Inc()
+		}
+
+	default:
+		eq.events <- qe
+	}
+}
+
+// close signals that no more events will be enqueued.  Buffered events are still delivered.
+func (eq *eventQueue) close() {
+	eq.stop.Do(func() { close(eq.events) })
+}
@@ -0,0 +1,529 @@
+package device
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEventQueueOrder(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		delivered = make(chan *Event, 50)
+		eq        = newEventQueue(5, OverflowBlock, NewMeasures(xmetricstest.NewProvider(nil)).DroppedEvents, func(e *Event) { delivered <- e })
+		expected  = make([]*Event, 50)
+	)
+
+	for i := range expected {
+		expected[i] = &Event{Type: MessageReceived}
+		eq.enqueue(expected[i])
+	}
+
+	eq.close()
+	for i := range expected {
+		select {
+		case actual := <-delivered:
+			assert.True(expected[i] == actual, "event %d was delivered out of order", i)
+		case <-time.After(5 * time.Second):
+			assert.Fail("events were not delivered")
+			return
+		}
+	}
+}
+
+func testEventQueueDropOldest(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		provider  = xmetricstest.NewProvider(nil, Metrics)
+		measures  = NewMeasures(provider)
+		block     = make(chan struct{})
+		delivered = make(chan *Event, 10)
+
+		eq = newEventQueue(2, OverflowDropOldest, measures.DroppedEvents, func(e *Event) {
+			<-block
+			delivered <- e
+		})
+
+		first = &Event{Type: Connect}
+		last  = &Event{Type: Disconnect}
+	)
+
+	// the first event occupies the dispatch goroutine, which we wait for
+	eq.enqueue(first)
+	for len(eq.events) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// these fill up then overflow the buffer, which must never block
+	for i := 0; i < 5; i++ {
+		eq.enqueue(&Event{Type: MessageReceived})
+	}
+
+	eq.enqueue(last)
+	provider.Assert(t, DroppedEventCounter)(xmetricstest.Value(4.0))
+
+	close(block)
+	eq.close()
+
+	assert.True(first == <-delivered)
+	assert.Equal(MessageReceived, (<-delivered).Type)
+	assert.True(last == <-delivered)
+}
+
+func testEventQueueDropNewest(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		provider  = xmetricstest.NewProvider(nil, Metrics)
+		block     = make(chan struct{})
+		delivered = make(chan *Event, 10)
+
+		eq = newEventQueue(2, OverflowDropNewest, NewMeasures(provider).DroppedEvents, func(e *Event) {
+			<-block
+			delivered <- e
+		})
+
+		first    = &Event{Type: Connect}
+		buffered = []*Event{{Type: MessageReceived}, {Type: MessageSent}}
+	)
+
+	eq.enqueue(first)
+	for len(eq.events) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, e := range buffered {
+		eq.enqueue(e)
+	}
+
+	// once the buffer is full, new events are dropped without blocking
+	for i := 0; i < 3; i++ {
+		eq.enqueue(&Event{Type: Disconnect})
+	}
+
+	provider.Assert(t, DroppedEventCounter)(xmetricstest.Value(3.0))
+
+	close(block)
+	eq.close()
+
+	assert.True(first == <-delivered)
+	assert.True(buffered[0] == <-delivered)
+	assert.True(buffered[1] == <-delivered)
+}
+
+func testEventQueueAck(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		block  = make(chan struct{})
+		eq     = newEventQueue(5, OverflowBlock, NewMeasures(xmetricstest.NewProvider(nil)).DroppedEvents, func(*Event) { <-block })
+	)
+
+	delivered := eq.enqueueAck(&Event{Type: Connect})
+	select {
+	case <-delivered:
+		assert.Fail("the event was acknowledged before it was delivered")
+	default:
+	}
+
+	close(block)
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the event was not acknowledged")
+	}
+
+	eq.close()
+}
+
+func testEventQueueFirst(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		provider  = xmetricstest.NewProvider(nil, Metrics)
+		block     = make(chan struct{})
+		delivered = make(chan *Event, 10)
+
+		eq = newEventQueue(1, OverflowDropOldest, NewMeasures(provider).DroppedEvents, func(e *Event) {
+			<-block
+			delivered <- e
+		})
+
+		first = &Event{Type: Connect}
+		last  = &Event{Type: MessageReceived}
+	)
+
+	// overflow drops buffered events, but never the first event
+	acknowledged := eq.enqueueFirst(first)
+	for i := 0; i < 5; i++ {
+		eq.enqueue(&Event{Type: MessageReceived})
+	}
+
+	eq.enqueue(last)
+	provider.Assert(t, DroppedEventCounter)(xmetricstest.Value(5.0))
+
+	close(block)
+	eq.close()
+
+	assert.True(first == <-delivered)
+	assert.True(last == <-delivered)
+	select {
+	case <-acknowledged:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the first event was not acknowledged")
+	}
+
+	// once events have been enqueued, enqueueFirst has the same ordering as enqueueAck
+	var (
+		ordered = make(chan *Event, 2)
+		late    = newEventQueue(2, OverflowBlock, NewMeasures(provider).DroppedEvents, func(e *Event) { ordered <- e })
+	)
+
+	late.enqueue(last)
+	<-late.enqueueFirst(first)
+	late.close()
+	assert.True(last == <-ordered)
+	assert.True(first == <-ordered)
+}
+
+func testManagerDispatchConnectFirst(t *testing.T, o *Options) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan EventType, 10)
+	)
+
+	o.Listeners = []Listener{
+		func(e *Event) {
+			switch e.Type {
+			case Connect:
+				// a slow Connect listener gives the read pump every opportunity to get ahead
+				time.Sleep(50 * time.Millisecond)
+				received <- e.Type
+			case MessageReceived:
+				received <- e.Type
+			}
+		},
+	}
+
+	manager, server, connectURL := startWebsocketServer(o)
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+
+	// the message is written immediately after the upgrade completes
+	require.NoError(connection.WriteMessage(
+		websocket.BinaryMessage,
+		wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: string(testDeviceIDs[0]), Destination: "event:test"}, wrp.Msgpack),
+	))
+
+	for _, expected := range []EventType{Connect, MessageReceived} {
+		select {
+		case actual := <-received:
+			assert.Equal(expected, actual)
+		case <-time.After(5 * time.Second):
+			assert.Fail("no event was dispatched", "expected %s", expected)
+			return
+		}
+	}
+
+	manager.DisconnectAll()
+}
+
+func testManagerDispatchAsync(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		block     = make(chan struct{})
+		delivered = make(chan ID, 10)
+
+		m = NewManager(&Options{
+			DispatchMode: DispatchAsync,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Device.ID() == testDeviceIDs[0] {
+						<-block
+					}
+
+					delivered <- e.Device.ID()
+				},
+			},
+		}).(*manager)
+	)
+
+	slow, _, err := m.newDevice(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "http://localhost.com", nil)))
+	require.NoError(err)
+	require.NotNil(slow.events)
+
+	fast, _, err := m.newDevice(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[1], httptest.NewRequest("GET", "http://localhost.com", nil)))
+	require.NoError(err)
+	require.NotNil(fast.events)
+
+	// a listener blocked on one device must not delay another device's events
+	m.dispatch(&Event{Type: Connect, Device: slow})
+	m.dispatch(&Event{Type: Connect, Device: fast})
+
+	select {
+	case id := <-delivered:
+		assert.Equal(testDeviceIDs[1], id)
+	case <-time.After(5 * time.Second):
+		assert.Fail("the fast device's event was not delivered")
+	}
+
+	close(block)
+	assert.Equal(testDeviceIDs[0], <-delivered)
+	slow.events.close()
+	fast.events.close()
+}
+
+func testManagerDispatchPooled(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		listener = NewChannelListener(nil)
+		pooled   = make(chan *Event, 10)
+
+		m = NewManager(&Options{
+			DispatchMode: DispatchAsync,
+			PoolEvents:   true,
+			Listeners: []Listener{
+				listener.OnEvent,
+				func(e *Event) { pooled <- e },
+			},
+		}).(*manager)
+	)
+
+	require.NotNil(m.eventPool)
+	d, _, err := m.newDevice(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "http://localhost.com", nil)))
+	require.NoError(err)
+	require.True(d.events.pool == m.eventPool)
+
+	produced := &Event{Type: MessageReceived, Device: d, Contents: []byte("contents")}
+	m.dispatch(produced)
+	d.events.close()
+
+	// listeners are lent a copy, which a ChannelListener copies again before it is recycled
+	assert.False(produced == <-pooled)
+	copied := <-listener.Events()
+	assert.Equal(MessageReceived, copied.Type)
+	assert.Equal("contents", string(copied.Contents))
+
+	assert.Nil(NewManager(&Options{PoolEvents: true}).(*manager).eventPool)
+}
+
+func testManagerConnectAck(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		finished = make(chan struct{})
+
+		m = NewManager(&Options{
+			DispatchMode:      DispatchAsync,
+			ConnectAckTimeout: 5 * time.Second,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == Connect {
+						time.Sleep(50 * time.Millisecond)
+						close(finished)
+					}
+				},
+			},
+		})
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	_, err = lp.Connect(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	require.NoError(err)
+
+	select {
+	case <-finished:
+	default:
+		assert.Fail("Connect returned before the Connect event was dispatched")
+	}
+
+	m.DisconnectAll()
+}
+
+func testManagerConnectAckTimeout(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		block   = make(chan struct{})
+
+		m = NewManager(&Options{
+			DispatchMode:      DispatchAsync,
+			ConnectAckTimeout: 50 * time.Millisecond,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == Connect {
+						<-block
+					}
+				},
+			},
+		})
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	// a listener that never finishes delays, but does not fail, the connection
+	d, err := lp.Connect(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	require.NoError(err)
+	assert.NotNil(d)
+	assert.Equal(1, m.Len())
+
+	close(block)
+	m.DisconnectAll()
+}
+
+func testManagerDispatchInline(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		delivered = 0
+
+		m = NewManager(&Options{
+			Listeners: []Listener{
+				func(e *Event) { delivered++ },
+			},
+		}).(*manager)
+	)
+
+	d, _, err := m.newDevice(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "http://localhost.com", nil)))
+	require.NoError(err)
+	assert.Nil(d.events)
+
+	m.dispatch(&Event{Type: Connect, Device: d})
+	assert.Equal(1, delivered)
+}
+
+func testManagerListenerTimeout(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		p         = xmetricstest.NewProvider(nil, Metrics)
+		block     = make(chan struct{})
+		stuck     = make(chan *Event, 1)
+		delivered = make(chan EventType, 10)
+
+		m = NewManager(&Options{
+			MetricsProvider: p,
+			ListenerTimeout: 50 * time.Millisecond,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived {
+						stuck <- e
+						<-block
+					}
+				},
+				func(e *Event) { delivered <- e.Type },
+			},
+		}).(*manager)
+	)
+
+	defer close(block)
+	d, _, err := m.newDevice(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "http://localhost.com", nil)))
+	require.NoError(err)
+
+	// listeners that return in time are not counted
+	m.dispatch(&Event{Type: Connect, Device: d})
+	assert.Equal(Connect, <-delivered)
+	p.Assert(t, ListenerTimeoutCounter)(xmetricstest.Value(0.0))
+
+	// a stuck listener is abandoned with its own copy of the event, and the next listener still runs
+	produced := &Event{Type: MessageReceived, Device: d}
+	m.dispatch(produced)
+	assert.Equal(MessageReceived, <-delivered)
+	assert.False(produced == <-stuck)
+	p.Assert(t, ListenerTimeoutCounter)(xmetricstest.Value(1.0))
+}
+
+func TestEventPool(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		pool   EventPool
+	)
+
+	e := pool.Get()
+	assert.Equal(Event{}, *e)
+
+	e.Type = MessageReceived
+	e.Contents = []byte("contents")
+	pool.Put(e)
+	assert.Equal(Event{}, *e)
+
+	assert.Equal(Event{}, *pool.Get())
+	pool.Put(nil)
+}
+
+func testEventQueuePool(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		provider  = xmetricstest.NewProvider(nil, Metrics)
+		block     = make(chan struct{})
+		delivered = make(chan Event, 10)
+
+		eq = newEventQueue(1, OverflowDropNewest, NewMeasures(provider).DroppedEvents, func(e *Event) {
+			<-block
+			delivered <- *e
+		})
+
+		produced = &Event{Type: Connect}
+	)
+
+	eq.pool = new(EventPool)
+
+	// the producer may reuse its event as soon as it has been enqueued
+	acknowledged := eq.enqueueFirst(produced)
+	produced.Type = MessageReceived
+	eq.enqueue(produced)
+	produced.Type = MessageSent
+	eq.enqueue(produced)
+	produced.Type = Disconnect
+
+	provider.Assert(t, DroppedEventCounter)(xmetricstest.Value(1.0))
+
+	close(block)
+	eq.close()
+
+	assert.Equal(Connect, (<-delivered).Type)
+	assert.Equal(MessageReceived, (<-delivered).Type)
+	select {
+	case <-acknowledged:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the first event was not acknowledged")
+	}
+}
+
+func TestEventQueue(t *testing.T) {
+	t.Run("Order", testEventQueueOrder)
+	t.Run("DropOldest", testEventQueueDropOldest)
+	t.Run("DropNewest", testEventQueueDropNewest)
+	t.Run("Ack", testEventQueueAck)
+	t.Run("First", testEventQueueFirst)
+	t.Run("Pool", testEventQueuePool)
+}
+
+func TestManagerDispatch(t *testing.T) {
+	t.Run("Async", testManagerDispatchAsync)
+	t.Run("Inline", testManagerDispatchInline)
+	t.Run("Pooled", testManagerDispatchPooled)
+	t.Run("ConnectAck", testManagerConnectAck)
+	t.Run("ConnectAckTimeout", testManagerConnectAckTimeout)
+	t.Run("ListenerTimeout", testManagerListenerTimeout)
+
+	t.Run("ConnectFirst", func(t *testing.T) {
+		t.Run("Inline", func(t *testing.T) { testManagerDispatchConnectFirst(t, &Options{}) })
+		t.Run("Async", func(t *testing.T) {
+			testManagerDispatchConnectFirst(t, &Options{DispatchMode: DispatchAsync, EventBufferSize: 1, EventOverflow: OverflowDropOldest})
+		})
+	})
+}
@@ -272,7 +272,7 @@ func (dr *drainer) nextBatch(jc jobContext, batch chan device.ID) (more bool, vi
 		for finished := false; more && !finished; {
 			select {
 			case id := <-batch:
-				if dr.connector.Disconnect(id) {
+				if dr.connector.DisconnectWithReason(id, device.CloseDrained) {
 					drained++
 				}
 			case <-jc.cancel:
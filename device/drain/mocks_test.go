@@ -1,10 +1,14 @@
 package drain
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/Comcast/webpa-common/device"
+	"github.com/Comcast/webpa-common/wrp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -66,16 +70,56 @@ func (sm *stubManager) Disconnect(id device.ID) bool {
 	return false
 }
 
+func (sm *stubManager) DisconnectWithReason(id device.ID, reason device.CloseReason) bool {
+	sm.assert.Equal(device.CloseDrained, reason)
+	return sm.Disconnect(id)
+}
+
 func (sm *stubManager) DisconnectIf(func(device.ID) bool) int {
 	sm.assert.Fail("DisconnectIf is not supported")
 	return -1
 }
 
+func (sm *stubManager) Close() error {
+	sm.assert.Fail("Close is not supported")
+	return nil
+}
+
+func (sm *stubManager) Closed() <-chan struct{} {
+	sm.assert.Fail("Closed is not supported")
+	return nil
+}
+
+func (sm *stubManager) Ping(device.ID, time.Duration) (time.Duration, error) {
+	sm.assert.Fail("Ping is not supported")
+	return 0, nil
+}
+
+func (sm *stubManager) Stats() device.ManagerStats {
+	sm.assert.Fail("Stats is not supported")
+	return device.ManagerStats{}
+}
+
+func (sm *stubManager) Tap(device.ID, io.Writer) (func(), error) {
+	sm.assert.Fail("Tap is not supported")
+	return nil, nil
+}
+
+func (sm *stubManager) DisconnectIfAsync(func(device.ID) bool) int {
+	sm.assert.Fail("DisconnectIfAsync is not supported")
+	return -1
+}
+
 func (sm *stubManager) DisconnectAll() int {
 	sm.assert.Fail("DisconnectAll is not supported")
 	return -1
 }
 
+func (sm *stubManager) DisconnectPartner(string, time.Duration) int {
+	sm.assert.Fail("DisconnectPartner is not supported")
+	return -1
+}
+
 func (sm *stubManager) Len() int {
 	return len(sm.devices)
 }
@@ -110,6 +154,31 @@ func (sm *stubManager) Route(*device.Request) (*device.Response, error) {
 	return nil, nil
 }
 
+func (sm *stubManager) RouteToSession(string, *device.Request) (*device.Response, error) {
+	sm.assert.Fail("RouteToSession is not supported")
+	return nil, nil
+}
+
+func (sm *stubManager) SendUrgent(device.ID, *wrp.Message) error {
+	sm.assert.Fail("SendUrgent is not supported")
+	return nil
+}
+
+func (sm *stubManager) SendRaw(device.ID, []byte, wrp.Format) (*device.Response, error) {
+	sm.assert.Fail("SendRaw is not supported")
+	return nil, nil
+}
+
+func (sm *stubManager) Chain(context.Context, string, ...*device.Request) ([]*device.Response, error) {
+	sm.assert.Fail("Chain is not supported")
+	return nil, nil
+}
+
+func (sm *stubManager) RouteStream(*device.Request) (<-chan *device.Response, func(), error) {
+	sm.assert.Fail("RouteStream is not supported")
+	return nil, nil, nil
+}
+
 func generateManager(assert *assert.Assertions, count uint64) *stubManager {
 	sm := &stubManager{
 		assert:          assert,
@@ -2,6 +2,7 @@ package device
 
 import (
 	"errors"
+	"strconv"
 )
 
 var (
@@ -9,6 +10,8 @@ var (
 	ErrorMissingSecureContext         = errors.New("Missing security information in request context")
 	ErrorMissingDeviceNameHeader      = errors.New("Missing device name header")
 	ErrorMissingDeviceNameVar         = errors.New("Missing device name path variable")
+	ErrorMissingDeviceNameQuery       = errors.New("Missing device name query parameter")
+	ErrorMissingDeviceNameCertificate = errors.New("Missing client certificate for the device name")
 	ErrorMissingPathVars              = errors.New("Missing URI path variables")
 	ErrorInvalidDeviceName            = errors.New("Invalid device name")
 	ErrorDeviceNotFound               = errors.New("The device does not exist")
@@ -24,4 +27,70 @@ var (
 	ErrorDeviceClosed                 = errors.New("That device has been closed")
 	ErrorTransactionsClosed           = errors.New("Transactions are closed for that device")
 	ErrorTransactionsAlreadyClosed    = errors.New("That Transactions is already closed")
+	ErrorAckNotSupported              = errors.New("Acknowledgements require a *wrp.Message")
+	ErrorPartnerQuotaReached          = errors.New("The device quota for that partner has been reached")
+	ErrorUnsupportedManager           = errors.New("That manager was not created by NewManager")
+	ErrorConnectionClosed             = errors.New("That connection has been closed")
+	ErrorDeadlineExceeded             = errors.New("The connection deadline has been exceeded")
+	ErrorIPLimitReached               = errors.New("The connection limit for that source IP has been reached")
+	ErrorDeviceCircuitOpen            = errors.New("That device has timed out too many transactions, and is temporarily unavailable")
+	ErrorInvalidCreditWindow          = errors.New("The credit window must be a positive integer")
+	ErrorRequestExpired               = errors.New("The request expired before it could be delivered")
+	ErrorMissingPassthroughContents   = errors.New("Requests to passthrough devices require Contents")
+	ErrorDeviceDisconnecting          = errors.New("That device is disconnecting")
+	ErrorInvalidProtocolVersion       = errors.New("Invalid WRP protocol version")
+	ErrorInvalidBatchLimit            = errors.New("The batch limit must be a positive integer")
+	ErrorManagerClosed                = errors.New("The manager has been closed")
+	ErrorSessionNotFound              = errors.New("That session is not connected")
+	ErrorTooManyTransactions          = errors.New("That device has too many pending transactions")
+	ErrorInvalidMessage               = errors.New("Invalid WRP message")
+	ErrorInvalidChain                 = errors.New("A chain requires a chain ID and transactional WRP messages for a single device")
+	ErrorMissingContents              = errors.New("Raw sends require contents")
+	ErrorMemoryPressure               = errors.New("Too many bytes are queued for devices")
+	ErrorInvalidQueryHint             = errors.New("Invalid query hint")
+	ErrorPingUnsupported              = errors.New("Long-poll devices cannot be pinged")
+	ErrorPingTimeout                  = errors.New("The device did not answer the ping in time")
+	ErrorStreamOverflow               = errors.New("The stream consumer fell behind, so the stream was ended")
+	ErrorStreamUnsupported            = errors.New("Passthrough devices cannot stream responses")
+	ErrorFrameTooLarge                = errors.New("The frame exceeds the maximum stream frame size")
+	ErrorPreparedMessageUnsupported   = errors.New("Stream connections cannot write prepared messages")
+	ErrorNestedBatch                  = errors.New("WRP batches cannot be nested")
+	ErrorDeviceTapped                 = errors.New("That device already has a tap")
+	ErrorInvalidSource                = errors.New("Invalid WRP source locator")
 )
+
+// InvalidMessageError is returned by Route and RouteToSession when Options.ValidateMessages is set and a
+// request's message fails validation.  The Cause of this error is always ErrorInvalidMessage.
+type InvalidMessageError struct {
+	// Err is the validation failure, which is usually a *wrp.FieldError naming the offending field
+	Err error
+}
+
+func (e *InvalidMessageError) Error() string {
+	return ErrorInvalidMessage.Error() + ": " + e.Err.Error()
+}
+
+// Cause returns ErrorInvalidMessage, which allows callers to detect validation failures in general
+func (e *InvalidMessageError) Cause() error {
+	return ErrorInvalidMessage
+}
+
+// InvalidSourceError is returned by Route, RouteToSession, and RouteStream when Options.SourcePolicy is strict
+// and a request's message has a Source that is not a valid WRP locator.  The Cause of this error is always
+// ErrorInvalidSource.
+type InvalidSourceError struct {
+	// Source is the offending Source value
+	Source string
+
+	// Err is the parse failure, either wrp.ErrInvalidLocator or wrp.ErrUnsupportedScheme
+	Err error
+}
+
+func (e *InvalidSourceError) Error() string {
+	return ErrorInvalidSource.Error() + " " + strconv.Quote(e.Source) + ": " + e.Err.Error()
+}
+
+// Cause returns ErrorInvalidSource, which allows callers to detect source failures in general
+func (e *InvalidSourceError) Cause() error {
+	return ErrorInvalidSource
+}
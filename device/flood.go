@@ -0,0 +1,68 @@
+package device
+
+import "time"
+
+// DefaultInboundRateWindow is the default period over which a device's inbound frame rate must exceed
+// Options.MaxInboundRate before the device is disconnected for flooding
+const DefaultInboundRateWindow time.Duration = 10 * time.Second
+
+// floodGuard is a token bucket that detects a device sustaining an inbound frame rate above a threshold.  The
+// bucket holds enough tokens for the window's worth of frames at the threshold, and refills at the threshold rate,
+// so short bursts are absorbed while a sustained flood eventually empties it.  A floodGuard is used only by a
+// device's read pump, so it is not safe for concurrent use.
+type floodGuard struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+// newFloodGuard creates a floodGuard for the given maximum rate, in frames per second.  If rate is nonpositive,
+// this function returns nil, which never detects a flood.
+func newFloodGuard(rate float64, window time.Duration, now func() time.Time) *floodGuard {
+	if rate <= 0 {
+		return nil
+	}
+
+	if window <= 0 {
+		window = DefaultInboundRateWindow
+	}
+
+	capacity := rate * window.Seconds()
+	if capacity < 1.0 {
+		capacity = 1.0
+	}
+
+	return &floodGuard{
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+		last:     now(),
+		now:      now,
+	}
+}
+
+// allow records a single inbound frame, returning false if the device has exceeded its rate for the whole window
+func (fg *floodGuard) allow() bool {
+	if fg == nil {
+		return true
+	}
+
+	current := fg.now()
+	if elapsed := current.Sub(fg.last).Seconds(); elapsed > 0 {
+		fg.tokens += elapsed * fg.rate
+		if fg.tokens > fg.capacity {
+			fg.tokens = fg.capacity
+		}
+
+		fg.last = current
+	}
+
+	if fg.tokens < 1.0 {
+		return false
+	}
+
+	fg.tokens--
+	return true
+}
@@ -0,0 +1,115 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFloodGuardDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	fg := newFloodGuard(0.0, time.Second, time.Now)
+	assert.Nil(fg)
+	for i := 0; i < 1000; i++ {
+		assert.True(fg.allow())
+	}
+}
+
+func testFloodGuardSustained(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		current = time.Now()
+		fg      = newFloodGuard(10.0, 2*time.Second, func() time.Time { return current })
+	)
+
+	// a burst of the whole window's worth of frames is absorbed
+	for i := 0; i < 20; i++ {
+		assert.True(fg.allow())
+	}
+
+	assert.False(fg.allow())
+
+	// frames at the maximum rate are always allowed
+	for i := 0; i < 100; i++ {
+		current = current.Add(100 * time.Millisecond)
+		assert.True(fg.allow())
+	}
+
+	// an idle device does not accumulate more than one window
+	current = current.Add(time.Hour)
+	for i := 0; i < 20; i++ {
+		assert.True(fg.allow())
+	}
+
+	assert.False(fg.allow())
+}
+
+func testFloodGuardMinimumCapacity(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		current = time.Now()
+		fg      = newFloodGuard(0.5, time.Second, func() time.Time { return current })
+	)
+
+	assert.True(fg.allow())
+	assert.False(fg.allow())
+
+	current = current.Add(2 * time.Second)
+	assert.True(fg.allow())
+}
+
+func TestFloodGuard(t *testing.T) {
+	t.Run("Disabled", testFloodGuardDisabled)
+	t.Run("Sustained", testFloodGuardSustained)
+	t.Run("MinimumCapacity", testFloodGuardMinimumCapacity)
+}
+
+func TestManagerFloodDisconnect(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		p       = xmetricstest.NewProvider(nil, Metrics)
+
+		manager, server, connectURL = startWebsocketServer(&Options{
+			MetricsProvider:   p,
+			MaxInboundRate:    1.0,
+			InboundRateWindow: 5 * time.Second,
+		})
+
+		event = wrp.MustEncode(
+			&wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      string(testDeviceIDs[0]),
+				Destination: "event:flood",
+			},
+			wrp.Msgpack,
+		)
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+	awaitLen(manager, 1)
+
+	// the window absorbs a burst, but not a flood
+	for i := 0; i < 10; i++ {
+		if err := connection.WriteMessage(websocket.BinaryMessage, event); err != nil {
+			break
+		}
+	}
+
+	closeError := awaitCloseError(t, connection)
+	assert.Equal(CloseCodeFlood, closeError.Code)
+	assert.Equal(CloseFlood.Text, closeError.Text)
+
+	awaitLen(manager, 0)
+	p.Assert(t, FloodDisconnectCounter)(xmetricstest.Value(1.0))
+}
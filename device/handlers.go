@@ -53,33 +53,10 @@ var UseID = struct {
 }{
 	F: useID,
 
-	FromHeader: useID(
-		func(request *http.Request) (ID, error) {
-			deviceName := request.Header.Get(DeviceNameHeader)
-			if len(deviceName) == 0 {
-				return invalidID, ErrorMissingDeviceNameHeader
-			}
-
-			return ParseID(deviceName)
-		},
-	),
+	FromHeader: useID(IDFromHeader),
 
 	FromPath: func(variableName string) func(http.Handler) http.Handler {
-		return useID(
-			func(request *http.Request) (ID, error) {
-				vars := mux.Vars(request)
-				if vars == nil {
-					return invalidID, ErrorMissingPathVars
-				}
-
-				deviceName := vars[variableName]
-				if len(deviceName) == 0 {
-					return invalidID, ErrorMissingDeviceNameVar
-				}
-
-				return ParseID(deviceName)
-			},
-		)
+		return useID(IDFromPath(variableName))
 	},
 }
 
@@ -182,6 +159,10 @@ func (mh *MessageHandler) ServeHTTP(httpResponse http.ResponseWriter, httpReques
 			code = http.StatusBadRequest
 		}
 
+		if _, ok := err.(*InvalidMessageError); ok {
+			code = http.StatusBadRequest
+		}
+
 		mh.logger().Log(level.Key(), level.ErrorValue(), logging.MessageKey(), "Could not process device request", logging.ErrorKey(), err, "code", code)
 		httpResponse.Header().Set("X-Xmidt-Message-Error", err.Error())
 		xhttp.WriteErrorf(
@@ -0,0 +1,138 @@
+package device
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// ProtocolVersionHeader is the HTTP header a device may send at connect time to select the layout of its
+	// messages, with the same values as ProtocolVersionConveyKey.  It is only honored when Options.AllowLegacyProtocol
+	// is set, and the convey data takes precedence when both are present.
+	ProtocolVersionHeader = "X-Xmidt-Wrp-Version"
+
+	// MaxQueryHintLength is the longest query hint value accepted, which is enough for typical convey data
+	MaxQueryHintLength = 4096
+)
+
+// QueryHintPrecedence determines which value is used when a connect request supplies both a header and the
+// query parameter that stands in for it
+type QueryHintPrecedence string
+
+const (
+	// HeaderPrecedence uses the header and ignores the query parameter.  This is the default.
+	HeaderPrecedence QueryHintPrecedence = "header"
+
+	// QueryPrecedence uses the query parameter, replacing the header
+	QueryPrecedence QueryHintPrecedence = "query"
+)
+
+// DefaultQueryParameters returns the mapping of query parameters onto connect headers used when QueryHints
+// has no Parameters.  A new map is returned by each call.
+func DefaultQueryParameters() map[string]string {
+	return map[string]string{
+		"convey":        ConveyHeader,
+		"wrp-version":   ProtocolVersionHeader,
+		"batch-limit":   BatchLimitHeader,
+		"credit-window": CreditWindowHeader,
+	}
+}
+
+// QueryHints allows devices that cannot set custom headers to supply them as query parameters of the connect
+// URL, e.g. ?wrp-version=v1&batch-limit=8.  Each hint is treated exactly as the header it stands in for,
+// including its validation, and the request seen by the upgrader is not modified.
+//
+// Query values are percent-decoded and trimmed of surrounding whitespace.  A connect request is rejected with
+// ErrorInvalidQueryHint if a hint is repeated, is longer than MaxQueryHintLength, or holds anything other than
+// printable ASCII.  An empty hint is ignored.
+type QueryHints struct {
+	// Parameters maps query parameter names onto the headers they stand in for.  If empty,
+	// DefaultQueryParameters is used.
+	Parameters map[string]string
+
+	// Precedence determines which value wins when both a header and its query parameter are present.
+	// If unset, HeaderPrecedence is used.
+	Precedence QueryHintPrecedence
+}
+
+func (qh *QueryHints) parameters() map[string]string {
+	if len(qh.Parameters) > 0 {
+		return qh.Parameters
+	}
+
+	return DefaultQueryParameters()
+}
+
+func (qh *QueryHints) precedence() QueryHintPrecedence {
+	if len(qh.Precedence) > 0 {
+		return qh.Precedence
+	}
+
+	return HeaderPrecedence
+}
+
+// apply returns the given request with the hints in its query applied as headers.  If no header changes, the
+// original request is returned.  Otherwise, the result is a shallow copy with its own header.  A nil *QueryHints
+// applies nothing.
+func (qh *QueryHints) apply(request *http.Request) (*http.Request, error) {
+	if qh == nil || len(request.URL.RawQuery) == 0 {
+		return request, nil
+	}
+
+	var (
+		query      = request.URL.Query()
+		precedence = qh.precedence()
+		header     http.Header
+	)
+
+	for parameter, name := range qh.parameters() {
+		values := query[parameter]
+		if len(values) == 0 {
+			continue
+		} else if len(values) > 1 {
+			return nil, ErrorInvalidQueryHint
+		}
+
+		value, err := sanitizeQueryHint(values[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if len(value) == 0 || (precedence == HeaderPrecedence && len(request.Header.Get(name)) > 0) {
+			continue
+		}
+
+		if header == nil {
+			header = make(http.Header, len(request.Header)+1)
+			for k, v := range request.Header {
+				header[k] = v
+			}
+		}
+
+		header.Set(name, value)
+	}
+
+	if header == nil {
+		return request, nil
+	}
+
+	hinted := *request
+	hinted.Header = header
+	return &hinted, nil
+}
+
+// sanitizeQueryHint trims a query hint and verifies that it is safe to use as a header value
+func sanitizeQueryHint(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) > MaxQueryHintLength {
+		return "", ErrorInvalidQueryHint
+	}
+
+	for i := 0; i < len(value); i++ {
+		if value[i] < ' ' || value[i] > '~' {
+			return "", ErrorInvalidQueryHint
+		}
+	}
+
+	return value, nil
+}
@@ -0,0 +1,180 @@
+package device
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testQueryHintsNil(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = httptest.NewRequest("GET", "/?batch-limit=8", nil)
+		hints   *QueryHints
+	)
+
+	actual, err := hints.apply(request)
+	assert.NoError(err)
+	assert.True(request == actual)
+}
+
+func testQueryHintsPrecedence(t *testing.T) {
+	testData := []struct {
+		precedence QueryHintPrecedence
+		header     string
+		query      string
+		expected   string
+	}{
+		{"", "4", "", "4"},
+		{"", "", "8", "8"},
+		{"", "4", "8", "4"},
+		{HeaderPrecedence, "4", "8", "4"},
+		{QueryPrecedence, "4", "8", "8"},
+		{QueryPrecedence, "4", "", "4"},
+		{QueryPrecedence, "", "8", "8"},
+
+		// an empty hint never replaces a header
+		{QueryPrecedence, "4", "%20", "4"},
+	}
+
+	for _, record := range testData {
+		t.Run(string(record.precedence)+"/"+record.header+"/"+record.query, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				hints   = &QueryHints{Precedence: record.precedence}
+				target  = "/"
+			)
+
+			if len(record.query) > 0 {
+				target += "?batch-limit=" + record.query
+			}
+
+			request := httptest.NewRequest("GET", target, nil)
+			if len(record.header) > 0 {
+				request.Header.Set(BatchLimitHeader, record.header)
+			}
+
+			actual, err := hints.apply(request)
+			require.NoError(err)
+			assert.Equal(record.expected, actual.Header.Get(BatchLimitHeader))
+
+			// the original request is never modified
+			assert.Equal(record.header, request.Header.Get(BatchLimitHeader))
+		})
+	}
+}
+
+func testQueryHintsParameters(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		hints   = &QueryHints{Parameters: map[string]string{"proto": ProtocolVersionHeader}}
+		request = httptest.NewRequest("GET", "/?proto=v1&batch-limit=8", nil)
+	)
+
+	request.Header.Set("X-Other", "value")
+	actual, err := hints.apply(request)
+	require.NoError(err)
+	assert.Equal("v1", actual.Header.Get(ProtocolVersionHeader))
+	assert.Equal("value", actual.Header.Get("X-Other"))
+
+	// parameters not in the mapping are ignored
+	assert.Empty(actual.Header.Get(BatchLimitHeader))
+}
+
+func testQueryHintsInvalid(t *testing.T) {
+	for _, query := range []string{
+		"batch-limit=4&batch-limit=8",
+		"convey=" + strings.Repeat("a", MaxQueryHintLength+1),
+		"wrp-version=v1%0d%0aX-Injected:%20true",
+		"wrp-version=caf%C3%A9",
+	} {
+		t.Run(query[:10], func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				hints   = new(QueryHints)
+				request = httptest.NewRequest("GET", "/?"+query, nil)
+			)
+
+			actual, err := hints.apply(request)
+			assert.Nil(actual)
+			assert.Equal(ErrorInvalidQueryHint, err)
+		})
+	}
+}
+
+func TestQueryHints(t *testing.T) {
+	t.Run("Nil", testQueryHintsNil)
+	t.Run("Precedence", testQueryHintsPrecedence)
+	t.Run("Parameters", testQueryHintsParameters)
+	t.Run("Invalid", testQueryHintsInvalid)
+}
+
+func testManagerQueryHintsConnect(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		m        = NewManager(&Options{AllowLegacyProtocol: true, MaxBatchMessages: 8, QueryHints: new(QueryHints)})
+		request  = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com/?wrp-version=v1&batch-limit=4", nil))
+		response = httptest.NewRecorder()
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	d, err := lp.Connect(response, request, nil)
+	require.NoError(err)
+	assert.Equal(wrp.ProtocolV1, d.(*device).protocol)
+	assert.Equal(4, d.(*device).batchLimit)
+
+	m.DisconnectAll()
+}
+
+func testManagerQueryHintsRejected(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		m        = NewManager(&Options{QueryHints: new(QueryHints)})
+		request  = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com/?convey=%00", nil))
+		response = httptest.NewRecorder()
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	d, err := lp.Connect(response, request, nil)
+	assert.Nil(d)
+	assert.Equal(ErrorInvalidQueryHint, err)
+	assert.Equal(http.StatusBadRequest, response.Code)
+	assert.Zero(m.Len())
+}
+
+func testManagerQueryHintsDisabled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(&Options{MaxBatchMessages: 8})
+		request = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com/?batch-limit=4", nil))
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	d, err := lp.Connect(httptest.NewRecorder(), request, nil)
+	require.NoError(err)
+	assert.Zero(d.(*device).batchLimit)
+
+	m.DisconnectAll()
+}
+
+func TestManagerQueryHints(t *testing.T) {
+	t.Run("Connect", testManagerQueryHintsConnect)
+	t.Run("Rejected", testManagerQueryHintsRejected)
+	t.Run("Disabled", testManagerQueryHintsDisabled)
+}
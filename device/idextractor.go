@@ -0,0 +1,170 @@
+package device
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// IDExtractor is the strategy a Manager uses to establish the identity of a connecting device from its
+// connect request.  Options.IDExtractor configures the strategy, which defaults to IDFromContext.
+type IDExtractor interface {
+	// ExtractID returns the ID of the device making the given request.  An error rejects the request.  Errors
+	// that implement StatusCode() int determine the response code, ErrorMissingDeviceNameContext results in a
+	// 500, and all other errors result in a 400.
+	ExtractID(*http.Request) (ID, error)
+}
+
+// ExtractID allows any IDFromRequest strategy to be used as an IDExtractor
+func (f IDFromRequest) ExtractID(request *http.Request) (ID, error) {
+	return f(request)
+}
+
+// CertificateIDError is returned by the IDFromCertificate strategy when a device's client certificate does
+// not establish its identity.  The connection is rejected with a 403.
+type CertificateIDError struct {
+	// Err is the error returned by the function that derives IDs from client certificates
+	Err error
+}
+
+func (e *CertificateIDError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the error from the function that derives IDs from client certificates
+func (e *CertificateIDError) Cause() error {
+	return e.Err
+}
+
+// StatusCode returns http.StatusForbidden, as the device presented a certificate that cannot be trusted for its identity
+func (e *CertificateIDError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// IDFromContext extracts a device ID stored in the request context, typically by one of the UseID constructors
+// or by an authentication layer.  This is the default IDExtractor.
+func IDFromContext(request *http.Request) (ID, error) {
+	if id, ok := GetID(request.Context()); ok {
+		return id, nil
+	}
+
+	return invalidID, ErrorMissingDeviceNameContext
+}
+
+// IDFromHeader parses a device ID from the DeviceNameHeader
+func IDFromHeader(request *http.Request) (ID, error) {
+	deviceName := request.Header.Get(DeviceNameHeader)
+	if len(deviceName) == 0 {
+		return invalidID, ErrorMissingDeviceNameHeader
+	}
+
+	return ParseID(deviceName)
+}
+
+// IDFromPath returns a strategy that parses a device ID from the given gorilla/mux path variable
+func IDFromPath(variableName string) IDFromRequest {
+	return func(request *http.Request) (ID, error) {
+		vars := mux.Vars(request)
+		if vars == nil {
+			return invalidID, ErrorMissingPathVars
+		}
+
+		deviceName := vars[variableName]
+		if len(deviceName) == 0 {
+			return invalidID, ErrorMissingDeviceNameVar
+		}
+
+		return ParseID(deviceName)
+	}
+}
+
+// IDFromQuery returns a strategy that parses a device ID from the given query parameter
+func IDFromQuery(parameter string) IDFromRequest {
+	return func(request *http.Request) (ID, error) {
+		deviceName := request.URL.Query().Get(parameter)
+		if len(deviceName) == 0 {
+			return invalidID, ErrorMissingDeviceNameQuery
+		}
+
+		return ParseID(deviceName)
+	}
+}
+
+// IDFromCertificate returns a strategy that derives a device ID from the TLS connection state of a request,
+// typically from the CN or a SAN of the client certificate presented under mutual TLS.  Requests that did not
+// arrive over TLS have no ID, and any error from the supplied function is returned as a *CertificateIDError.
+func IDFromCertificate(f func(*tls.ConnectionState) (ID, error)) IDFromRequest {
+	return func(request *http.Request) (ID, error) {
+		if request.TLS == nil {
+			return invalidID, ErrorMissingDeviceNameCertificate
+		}
+
+		id, err := f(request.TLS)
+		if err != nil {
+			return invalidID, &CertificateIDError{Err: err}
+		}
+
+		return id, nil
+	}
+}
+
+// missingID tests if an error from an IDExtractor indicates that a request simply did not carry an ID
+func missingID(err error) bool {
+	switch err {
+	case ErrorMissingDeviceNameContext,
+		ErrorMissingDeviceNameHeader,
+		ErrorMissingDeviceNameVar,
+		ErrorMissingPathVars,
+		ErrorMissingDeviceNameQuery,
+		ErrorMissingDeviceNameCertificate:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// firstID is the IDExtractor returned by FirstID
+type firstID []IDExtractor
+
+func (f firstID) ExtractID(request *http.Request) (ID, error) {
+	var firstErr error
+	for _, e := range f {
+		id, err := e.ExtractID(request)
+		if err == nil {
+			return id, nil
+		} else if !missingID(err) {
+			return invalidID, err
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr == nil {
+		firstErr = ErrorMissingDeviceNameContext
+	}
+
+	return invalidID, firstErr
+}
+
+// FirstID returns an IDExtractor that consults each of the given extractors in order, returning the first ID found.
+// An extractor that finds no ID, such as IDFromHeader for a request without the header, is skipped.  Any other error
+// rejects the request immediately.  If no extractor finds an ID, the error from the first extractor is returned.
+func FirstID(extractors ...IDExtractor) IDExtractor {
+	return firstID(append([]IDExtractor(nil), extractors...))
+}
+
+// idExtractionStatus returns the HTTP status code for an error returned by an IDExtractor
+func idExtractionStatus(err error) int {
+	if coder, ok := err.(interface {
+		StatusCode() int
+	}); ok {
+		return coder.StatusCode()
+	} else if err == ErrorMissingDeviceNameContext {
+		// nothing upstream of the connect handler established the ID, which is a server configuration problem
+		return http.StatusInternalServerError
+	}
+
+	return http.StatusBadRequest
+}
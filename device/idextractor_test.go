@@ -0,0 +1,198 @@
+package device
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Comcast/webpa-common/xhttp"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testIDExtractorContext(t *testing.T) {
+	assert := assert.New(t)
+
+	id, err := IDFromContext(WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "/", nil)))
+	assert.Equal(testDeviceIDs[0], id)
+	assert.NoError(err)
+
+	_, err = IDFromContext(httptest.NewRequest("GET", "/", nil))
+	assert.Equal(ErrorMissingDeviceNameContext, err)
+}
+
+func testIDExtractorHeader(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = httptest.NewRequest("GET", "/", nil)
+	)
+
+	_, err := IDFromHeader(request)
+	assert.Equal(ErrorMissingDeviceNameHeader, err)
+
+	request.Header.Set(DeviceNameHeader, "MAC:11:22:33:44:55:66")
+	id, err := IDFromHeader(request)
+	assert.Equal(ID("mac:112233445566"), id)
+	assert.NoError(err)
+
+	request.Header.Set(DeviceNameHeader, "this is not a device name")
+	_, err = IDFromHeader(request)
+	assert.Equal(ErrorInvalidDeviceName, err)
+}
+
+func testIDExtractorPath(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		extractor = IDFromPath("deviceID")
+		request   = httptest.NewRequest("GET", "/", nil)
+	)
+
+	_, err := extractor(request)
+	assert.Equal(ErrorMissingPathVars, err)
+
+	_, err = extractor(mux.SetURLVars(request, map[string]string{"other": "value"}))
+	assert.Equal(ErrorMissingDeviceNameVar, err)
+
+	id, err := extractor(mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"}))
+	assert.Equal(ID("mac:112233445566"), id)
+	assert.NoError(err)
+}
+
+func testIDExtractorQuery(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		extractor = IDFromQuery("device")
+	)
+
+	_, err := extractor(httptest.NewRequest("GET", "/?other=mac:112233445566", nil))
+	assert.Equal(ErrorMissingDeviceNameQuery, err)
+
+	id, err := extractor(httptest.NewRequest("GET", "/?device=mac:112233445566", nil))
+	assert.Equal(ID("mac:112233445566"), id)
+	assert.NoError(err)
+}
+
+func testIDExtractorCertificate(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected certificate error")
+		extractor     = IDFromCertificate(func(state *tls.ConnectionState) (ID, error) {
+			if state.ServerName == "bad.example.com" {
+				return invalidID, expectedError
+			}
+
+			return ID("mac:112233445566"), nil
+		})
+	)
+
+	_, err := extractor(httptest.NewRequest("GET", "http://localhost.com", nil))
+	assert.Equal(ErrorMissingDeviceNameCertificate, err)
+
+	id, err := extractor(httptest.NewRequest("GET", "https://localhost.com", nil))
+	assert.Equal(ID("mac:112233445566"), id)
+	assert.NoError(err)
+
+	request := httptest.NewRequest("GET", "https://localhost.com", nil)
+	request.TLS.ServerName = "bad.example.com"
+	_, err = extractor(request)
+	assert.Equal(&CertificateIDError{Err: expectedError}, err)
+	assert.Equal(expectedError.Error(), err.Error())
+	assert.Equal(expectedError, err.(*CertificateIDError).Cause())
+}
+
+func testIDExtractorFirst(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		extractor = FirstID(IDFromQuery("device"), IDFromRequest(IDFromHeader))
+	)
+
+	// the first extractor that finds an ID wins
+	request := httptest.NewRequest("GET", "/?device=mac:112233445566", nil)
+	request.Header.Set(DeviceNameHeader, "mac:665544332211")
+	id, err := extractor.ExtractID(request)
+	assert.Equal(ID("mac:112233445566"), id)
+	assert.NoError(err)
+
+	// extractors that find no ID are skipped
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(DeviceNameHeader, "mac:665544332211")
+	id, err = extractor.ExtractID(request)
+	assert.Equal(ID("mac:665544332211"), id)
+	assert.NoError(err)
+
+	// any other error rejects the request
+	request = httptest.NewRequest("GET", "/?device=invalid", nil)
+	request.Header.Set(DeviceNameHeader, "mac:665544332211")
+	_, err = extractor.ExtractID(request)
+	assert.Equal(ErrorInvalidDeviceName, err)
+
+	_, err = extractor.ExtractID(httptest.NewRequest("GET", "/", nil))
+	assert.Equal(ErrorMissingDeviceNameQuery, err)
+
+	_, err = FirstID().ExtractID(httptest.NewRequest("GET", "/", nil))
+	assert.Equal(ErrorMissingDeviceNameContext, err)
+}
+
+func testIDExtractorStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(http.StatusInternalServerError, idExtractionStatus(ErrorMissingDeviceNameContext))
+	assert.Equal(http.StatusBadRequest, idExtractionStatus(ErrorMissingDeviceNameHeader))
+	assert.Equal(http.StatusBadRequest, idExtractionStatus(ErrorInvalidDeviceName))
+	assert.Equal(http.StatusForbidden, idExtractionStatus(&CertificateIDError{Err: errors.New("expected")}))
+	assert.Equal(http.StatusUnauthorized, idExtractionStatus(&xhttp.Error{Code: http.StatusUnauthorized}))
+}
+
+func TestIDExtractor(t *testing.T) {
+	t.Run("Context", testIDExtractorContext)
+	t.Run("Header", testIDExtractorHeader)
+	t.Run("Path", testIDExtractorPath)
+	t.Run("Query", testIDExtractorQuery)
+	t.Run("Certificate", testIDExtractorCertificate)
+	t.Run("First", testIDExtractorFirst)
+	t.Run("Status", testIDExtractorStatus)
+}
+
+func TestManagerIDExtractor(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		m = NewManager(&Options{
+			IDExtractor: IDFromQuery("device"),
+		})
+
+		connect = func(request *http.Request) (*httptest.ResponseRecorder, Interface, error) {
+			response := httptest.NewRecorder()
+			lp, err := NewLongPollConnector(m, 0)
+			require.NoError(err)
+
+			d, err := lp.Connect(response, request, nil)
+			return response, d, err
+		}
+	)
+
+	defer m.DisconnectAll()
+
+	response, d, err := connect(httptest.NewRequest("POST", "/?device=mac:112233445566", nil))
+	require.NoError(err)
+	assert.Equal(ID("mac:112233445566"), d.ID())
+	assert.Equal(http.StatusOK, response.Code)
+
+	// the configured extractor replaces the context lookup
+	response, d, err = connect(WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "/", nil)))
+	assert.Nil(d)
+	assert.Equal(ErrorMissingDeviceNameQuery, err)
+	assert.Equal(http.StatusBadRequest, response.Code)
+	assert.Equal("application/json", response.Header().Get("Content-Type"))
+
+	response, d, err = connect(httptest.NewRequest("POST", "/?device=invalid", nil))
+	assert.Nil(d)
+	assert.Equal(ErrorInvalidDeviceName, err)
+	assert.Equal(http.StatusBadRequest, response.Code)
+
+	assert.Equal(1, m.Len())
+}
@@ -0,0 +1,109 @@
+package device
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIPLimitRetryAfter is the Retry-After hint sent to a connection refused because its
+	// source IP reached Options.MaxConnectionsPerIP
+	DefaultIPLimitRetryAfter = time.Minute
+
+	// UnknownSourceIP is the bucket for connections whose source IP cannot be determined.  Such connections
+	// share a single per-IP limit rather than bypassing it.
+	UnknownSourceIP = "unknown"
+)
+
+// sourceIP determines the IP address a connection request originated from.  When forwardedHeader is set and
+// present on the request, its first element is used, as is the convention for X-Forwarded-For.  Otherwise,
+// the request's RemoteAddr is used.  UnknownSourceIP is returned when the relevant value is not an IP address.
+func sourceIP(request *http.Request, forwardedHeader string) string {
+	host := ""
+	if len(forwardedHeader) > 0 {
+		if value := request.Header.Get(forwardedHeader); len(value) > 0 {
+			host = strings.TrimSpace(strings.Split(value, ",")[0])
+		}
+	}
+
+	if len(host) == 0 {
+		var err error
+		if host, _, err = net.SplitHostPort(request.RemoteAddr); err != nil {
+			host = request.RemoteAddr
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+
+	return UnknownSourceIP
+}
+
+// ipLimiter tracks the number of connections per source IP.  A nil ipLimiter imposes no limit.
+type ipLimiter struct {
+	lock   sync.Mutex
+	limit  int
+	counts map[string]int
+}
+
+// newIPLimiter creates an ipLimiter for the given limit, returning nil if limit is nonpositive
+func newIPLimiter(limit int) *ipLimiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &ipLimiter{
+		limit:  limit,
+		counts: make(map[string]int),
+	}
+}
+
+// acquire attempts to count a new connection against the given IP, returning false if
+// that IP has already reached its limit
+func (l *ipLimiter) acquire(ip string) bool {
+	if l == nil {
+		return true
+	}
+
+	defer l.lock.Unlock()
+	l.lock.Lock()
+
+	if l.counts[ip] >= l.limit {
+		return false
+	}
+
+	l.counts[ip]++
+	return true
+}
+
+// release lowers the connection count for the given IP, cleaning up the entry when it reaches zero
+func (l *ipLimiter) release(ip string) {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+	if count := l.counts[ip]; count > 1 {
+		l.counts[ip] = count - 1
+	} else {
+		delete(l.counts, ip)
+	}
+
+	l.lock.Unlock()
+}
+
+// count returns the number of connections currently counted against the given IP
+func (l *ipLimiter) count(ip string) int {
+	if l == nil {
+		return 0
+	}
+
+	l.lock.Lock()
+	c := l.counts[ip]
+	l.lock.Unlock()
+	return c
+}
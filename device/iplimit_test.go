@@ -0,0 +1,125 @@
+package device
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceIP(t *testing.T) {
+	testData := []struct {
+		remoteAddr      string
+		forwardedHeader string
+		forwarded       string
+		expected        string
+	}{
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"10.0.0.1", "", "", "10.0.0.1"},
+		{"[::1]:1234", "", "", "::1"},
+		{"garbage", "", "", UnknownSourceIP},
+		{"", "", "", UnknownSourceIP},
+		{"10.0.0.1:1234", "X-Forwarded-For", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "192.168.1.1, 10.0.0.1", "192.168.1.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", " 192.168.1.1 ", "192.168.1.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "not an ip", UnknownSourceIP},
+		{"10.0.0.1:1234", "", "192.168.1.1", "10.0.0.1"},
+	}
+
+	for _, record := range testData {
+		request := httptest.NewRequest("GET", "http://localhost.com", nil)
+		request.RemoteAddr = record.remoteAddr
+		if len(record.forwarded) > 0 {
+			request.Header.Set("X-Forwarded-For", record.forwarded)
+		}
+
+		assert.Equal(t, record.expected, sourceIP(request, record.forwardedHeader), "%#v", record)
+	}
+}
+
+func testIPLimiterNil(t *testing.T) {
+	assert := assert.New(t)
+	l := newIPLimiter(0)
+
+	assert.Nil(l)
+	assert.True(l.acquire("10.0.0.1"))
+	l.release("10.0.0.1")
+	assert.Zero(l.count("10.0.0.1"))
+}
+
+func testIPLimiterLimit(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		l      = newIPLimiter(2)
+	)
+
+	assert.True(l.acquire("10.0.0.1"))
+	assert.True(l.acquire("10.0.0.1"))
+	assert.False(l.acquire("10.0.0.1"))
+	assert.True(l.acquire("10.0.0.2"))
+	assert.Equal(2, l.count("10.0.0.1"))
+
+	l.release("10.0.0.1")
+	assert.Equal(1, l.count("10.0.0.1"))
+	assert.True(l.acquire("10.0.0.1"))
+
+	l.release("10.0.0.1")
+	l.release("10.0.0.1")
+	assert.Zero(l.count("10.0.0.1"))
+	_, ok := l.counts["10.0.0.1"]
+	assert.False(ok)
+}
+
+func TestIPLimiter(t *testing.T) {
+	t.Run("Nil", testIPLimiterNil)
+	t.Run("Limit", testIPLimiterLimit)
+}
+
+func TestManagerMaxConnectionsPerIP(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		m = NewManager(&Options{
+			MaxConnectionsPerIP: 1,
+			ForwardedHeader:     "X-Forwarded-For",
+		})
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	connect := func(id ID, forwarded string) (*httptest.ResponseRecorder, error) {
+		request := WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil))
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.Header.Set("X-Forwarded-For", forwarded)
+
+		response := httptest.NewRecorder()
+		_, err := lp.Connect(response, request, nil)
+		return response, err
+	}
+
+	response, err := connect(testDeviceIDs[0], "192.168.1.1")
+	require.NoError(err)
+	assert.Equal(http.StatusOK, response.Code)
+
+	response, err = connect(testDeviceIDs[1], "192.168.1.1")
+	assert.Equal(ErrorIPLimitReached, err)
+	assert.Equal(http.StatusTooManyRequests, response.Code)
+	assert.Equal("60", response.HeaderMap.Get("Retry-After"))
+
+	// a different forwarded address is a different bucket
+	response, err = connect(testDeviceIDs[2], "192.168.1.2")
+	require.NoError(err)
+	assert.Equal(http.StatusOK, response.Code)
+
+	// disconnecting frees up the slot
+	assert.True(m.Disconnect(testDeviceIDs[0]))
+	response, err = connect(testDeviceIDs[1], "192.168.1.1")
+	require.NoError(err)
+	assert.Equal(http.StatusOK, response.Code)
+
+	m.DisconnectAll()
+}
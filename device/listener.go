@@ -36,6 +36,11 @@ const (
 	// was no waiting transaction
 	TransactionBroken
 
+	// Heartbeat is dispatched periodically for each connected device when Options.HeartbeatPeriod is set,
+	// regardless of whether the device is sending or receiving messages.  The event's Statistics field holds
+	// a snapshot of the device's statistics at the time of the heartbeat.
+	Heartbeat
+
 	InvalidEventString string = "!!INVALID DEVICE EVENT TYPE!!"
 )
 
@@ -60,6 +65,8 @@ func (et EventType) String() string {
 		return "TransactionComplete"
 	case TransactionBroken:
 		return "TransactionBroken"
+	case Heartbeat:
+		return "Heartbeat"
 	default:
 		return InvalidEventString
 	}
@@ -99,6 +106,14 @@ type Event struct {
 	// for MessageFailed events when there was an actual error.  For MessageFailed events that indicate a
 	// device was disconnected with enqueued messages, this field will be nil.
 	Error error
+
+	// Reason explains a Disconnect event, and is empty for other events.  It is DeviceInitiatedReason when the
+	// device announced its disconnection, in which case Message is the device's *wrp.Disconnect.  Otherwise it
+	// is empty, and the disconnection can be assumed to be unplanned from the device's point of view.
+	Reason string
+
+	// Statistics is a snapshot of the device's statistics.  It is only set for Heartbeat events.
+	Statistics StatisticsSnapshot
 }
 
 // Listener is an event sink.  Listeners should never modify events and should never
@@ -0,0 +1,357 @@
+package device
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xhttp"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultPollTimeout is the default length of time a long-poll GET is held open
+	// waiting for an outbound message.
+	DefaultPollTimeout = 30 * time.Second
+)
+
+// LongPollConnector is a Connector which services devices over HTTP long-polling rather than websockets.
+// This is intended as a fallback for devices on networks that block websockets.
+//
+// A device connects through this type's Connect method, typically via a ConnectHandler.  Afterward, the
+// device POSTs each inbound WRP message as a Msgpack request body and issues GET requests that are held
+// until an outbound message is available or the poll timeout elapses.  Each of these requests must carry
+// the device ID in its context, just as with websocket connections.
+//
+// Devices connected through this type are registered with the same Manager used to create it, so routing,
+// visiting, disconnection, and events behave identically regardless of transport.  Since there are no
+// pings over long-poll, each POST or GET extends the device's idle period instead.
+type LongPollConnector struct {
+	manager     *manager
+	pollTimeout time.Duration
+
+	lock     sync.RWMutex
+	sessions map[ID]*longPollConnection
+}
+
+// NewLongPollConnector creates a LongPollConnector that shares devices with the given Manager, which must
+// have been created with NewManager.  If pollTimeout is nonpositive, DefaultPollTimeout is used.
+func NewLongPollConnector(m Manager, pollTimeout time.Duration) (*LongPollConnector, error) {
+	mgr, ok := m.(*manager)
+	if !ok {
+		return nil, ErrorUnsupportedManager
+	}
+
+	if pollTimeout < 1 {
+		pollTimeout = DefaultPollTimeout
+	}
+
+	return &LongPollConnector{
+		manager:     mgr,
+		pollTimeout: pollTimeout,
+		sessions:    make(map[ID]*longPollConnection),
+	}, nil
+}
+
+// Connect establishes a long-poll session for the device identified by the request's context.  The
+// response is completed with a 200 status on success, and the given response header is included.
+func (lp *LongPollConnector) Connect(response http.ResponseWriter, request *http.Request, responseHeader http.Header) (Interface, error) {
+	m := lp.manager
+	m.debugLog.Log(logging.MessageKey(), "long-poll device connect", "url", request.URL)
+	d, cvy, err := m.newDevice(response, request)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newLongPollConnection(m.now)
+	c.SetReadDeadline(m.readDeadline())
+	if err := m.register(d, cvy); err != nil {
+		c.Close()
+
+		status := http.StatusServiceUnavailable
+		if err == ErrorDuplicateDevice {
+			status = http.StatusConflict
+		}
+
+		xhttp.WriteError(response, status, err)
+		return nil, err
+	}
+
+	lp.lock.Lock()
+	lp.sessions[d.ID()] = c
+	lp.lock.Unlock()
+
+	c.onClose = func() {
+		lp.lock.Lock()
+		if lp.sessions[d.ID()] == c {
+			delete(lp.sessions, d.ID())
+		}
+
+		lp.lock.Unlock()
+	}
+
+	// there is no ping over long-poll, as each request from the device extends the read deadline
+	m.startPumps(d, c, func() error { return nil })
+
+	for name, values := range withBatchLimit(d, withCreditWindow(d, responseHeader)) {
+		for _, value := range values {
+			response.Header().Add(name, value)
+		}
+	}
+
+	response.WriteHeader(http.StatusOK)
+	return d, nil
+}
+
+func (lp *LongPollConnector) Disconnect(id ID) bool {
+	return lp.manager.Disconnect(id)
+}
+
+func (lp *LongPollConnector) DisconnectWithReason(id ID, reason CloseReason) bool {
+	return lp.manager.DisconnectWithReason(id, reason)
+}
+
+func (lp *LongPollConnector) DisconnectIf(filter func(ID) bool) int {
+	return lp.manager.DisconnectIf(filter)
+}
+
+func (lp *LongPollConnector) DisconnectIfAsync(filter func(ID) bool) int {
+	return lp.manager.DisconnectIfAsync(filter)
+}
+
+func (lp *LongPollConnector) DisconnectAll() int {
+	return lp.manager.DisconnectAll()
+}
+
+func (lp *LongPollConnector) DisconnectPartner(partner string, grace time.Duration) int {
+	return lp.manager.DisconnectPartner(partner, grace)
+}
+
+// session returns the current long-poll connection for the device identified by the request, as established by
+// the manager's IDExtractor.
+// Any error returned by this method has already been written to the response.
+func (lp *LongPollConnector) session(response http.ResponseWriter, request *http.Request) (*longPollConnection, bool) {
+	id, err := lp.manager.extractID(response, request)
+	if err != nil {
+		return nil, false
+	}
+
+	lp.lock.RLock()
+	c, ok := lp.sessions[id]
+	lp.lock.RUnlock()
+
+	if !ok {
+		// the device must connect again to establish a new session
+		xhttp.WriteError(response, http.StatusNotFound, ErrorDeviceNotFound)
+		return nil, false
+	}
+
+	return c, true
+}
+
+// ServeHTTP services the long-poll requests for an already connected device.  A POST delivers a single
+// Msgpack WRP message from the device, while a GET waits for the next message addressed to the device.
+// A GET that times out without a message results in a 204 status.
+func (lp *LongPollConnector) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		response.Header().Set("Allow", "GET, POST")
+		xhttp.WriteErrorf(response, http.StatusMethodNotAllowed, "Method %s is not supported for long-poll", request.Method)
+		return
+	}
+
+	c, ok := lp.session(response, request)
+	if !ok {
+		return
+	}
+
+	c.SetReadDeadline(lp.manager.readDeadline())
+	if request.Method == http.MethodPost {
+		lp.receive(c, response, request)
+	} else {
+		lp.poll(c, response, request)
+	}
+}
+
+func (lp *LongPollConnector) receive(c *longPollConnection, response http.ResponseWriter, request *http.Request) {
+	data, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		xhttp.WriteError(response, http.StatusBadRequest, err)
+		return
+	}
+
+	select {
+	case c.inbound <- data:
+		response.WriteHeader(http.StatusAccepted)
+	case <-c.closed:
+		xhttp.WriteError(response, http.StatusGone, ErrorConnectionClosed)
+	case <-request.Context().Done():
+	}
+}
+
+func (lp *LongPollConnector) poll(c *longPollConnection, response http.ResponseWriter, request *http.Request) {
+	timer := time.NewTimer(lp.pollTimeout)
+	defer timer.Stop()
+
+	select {
+	case data := <-c.outbound:
+		response.Header().Set("Content-Type", wrp.Msgpack.ContentType())
+		response.WriteHeader(http.StatusOK)
+		response.Write(data)
+	case <-timer.C:
+		response.WriteHeader(http.StatusNoContent)
+	case <-c.closed:
+		xhttp.WriteError(response, http.StatusGone, ErrorConnectionClosed)
+	case <-request.Context().Done():
+	}
+}
+
+// longPollConnection adapts HTTP long-polling to the Connection interface, which allows
+// the manager's pumps to be used unchanged.  Frames are exchanged with the HTTP handlers
+// via unbuffered channels.
+type longPollConnection struct {
+	now      func() time.Time
+	inbound  chan []byte
+	outbound chan []byte
+	closed   chan struct{}
+
+	closeOnce sync.Once
+	onClose   func()
+
+	lock          sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newLongPollConnection(now func() time.Time) *longPollConnection {
+	return &longPollConnection{
+		now:      now,
+		inbound:  make(chan []byte),
+		outbound: make(chan []byte),
+		closed:   make(chan struct{}),
+	}
+}
+
+// wait blocks until the given operation completes, the connection is closed, or the deadline
+// returned by the given closure passes.  The deadline is reexamined each time it expires, since
+// device activity may have extended it in the meantime.
+func (c *longPollConnection) wait(deadline func() time.Time, operation func(<-chan time.Time) bool) error {
+	for {
+		var (
+			expired <-chan time.Time
+			timer   *time.Timer
+		)
+
+		if d := deadline(); !d.IsZero() {
+			timer = time.NewTimer(d.Sub(c.now()))
+			expired = timer.C
+		}
+
+		done := operation(expired)
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-c.closed:
+			return ErrorConnectionClosed
+		default:
+		}
+
+		if d := deadline(); !d.IsZero() && !c.now().Before(d) {
+			return ErrorDeadlineExceeded
+		}
+	}
+}
+
+func (c *longPollConnection) getReadDeadline() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.readDeadline
+}
+
+func (c *longPollConnection) getWriteDeadline() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.writeDeadline
+}
+
+func (c *longPollConnection) ReadMessage() (int, []byte, error) {
+	var data []byte
+	err := c.wait(c.getReadDeadline, func(expired <-chan time.Time) bool {
+		select {
+		case data = <-c.inbound:
+			return true
+		case <-c.closed:
+		case <-expired:
+		}
+
+		return false
+	})
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return websocket.BinaryMessage, data, nil
+}
+
+func (c *longPollConnection) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.readDeadline = t
+	c.lock.Unlock()
+	return nil
+}
+
+// SetPongHandler does nothing, as there are no pongs over long-poll
+func (c *longPollConnection) SetPongHandler(func(string) error) {
+}
+
+func (c *longPollConnection) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.CloseMessage {
+		// close frames have no meaning over long-poll, as the device learns of closure from the HTTP status
+		return nil
+	}
+
+	return c.wait(c.getWriteDeadline, func(expired <-chan time.Time) bool {
+		select {
+		case c.outbound <- data:
+			return true
+		case <-c.closed:
+		case <-expired:
+		}
+
+		return false
+	})
+}
+
+// WritePreparedMessage does nothing, as prepared messages are only used for pings
+func (c *longPollConnection) WritePreparedMessage(*websocket.PreparedMessage) error {
+	return nil
+}
+
+func (c *longPollConnection) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.writeDeadline = t
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *longPollConnection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package device
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNewLongPollConnectorUnsupportedManager(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		m      = struct{ Manager }{}
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	assert.Nil(lp)
+	assert.Equal(ErrorUnsupportedManager, err)
+}
+
+func testNewLongPollConnectorDefaults(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	lp, err := NewLongPollConnector(NewManager(nil), 0)
+	require.NoError(err)
+	require.NotNil(lp)
+	assert.Equal(DefaultPollTimeout, lp.pollTimeout)
+}
+
+func testLongPollConnectorMissingSession(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	lp, err := NewLongPollConnector(NewManager(nil), 0)
+	require.NoError(err)
+
+	response := httptest.NewRecorder()
+	lp.ServeHTTP(response, WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "http://localhost.com", nil)))
+	assert.Equal(http.StatusNotFound, response.Code)
+
+	response = httptest.NewRecorder()
+	lp.ServeHTTP(response, httptest.NewRequest("GET", "http://localhost.com", nil))
+	assert.Equal(http.StatusInternalServerError, response.Code)
+
+	response = httptest.NewRecorder()
+	lp.ServeHTTP(response, WithIDRequest(testDeviceIDs[0], httptest.NewRequest("DELETE", "http://localhost.com", nil)))
+	assert.Equal(http.StatusMethodNotAllowed, response.Code)
+}
+
+func testLongPollConnectorSession(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		events  = make(chan *Event, 10)
+		options = &Options{
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived || e.Type == Connect || e.Type == Disconnect {
+						events <- e
+					}
+				},
+			},
+		}
+
+		manager = NewManager(options)
+		id      = testDeviceIDs[0]
+	)
+
+	lp, err := NewLongPollConnector(manager, 50*time.Millisecond)
+	require.NoError(err)
+
+	response := httptest.NewRecorder()
+	d, err := lp.Connect(response, WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil)), http.Header{"X-Test": []string{"value"}})
+	require.NoError(err)
+	require.NotNil(d)
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("value", response.HeaderMap.Get("X-Test"))
+	assert.Equal(Connect, (<-events).Type)
+
+	// long-poll devices are visible through the same registry as websocket devices
+	assert.Equal(1, manager.Len())
+	visited := 0
+	manager.VisitAll(func(Interface) bool {
+		visited++
+		return true
+	})
+
+	assert.Equal(1, visited)
+
+	// a poll with nothing to deliver times out
+	response = httptest.NewRecorder()
+	lp.ServeHTTP(response, WithIDRequest(id, httptest.NewRequest("GET", "http://localhost.com", nil)))
+	assert.Equal(http.StatusNoContent, response.Code)
+
+	// outbound messages are returned on a poll
+	expected := &wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:server.com",
+		Destination: string(id),
+		Payload:     []byte("outbound"),
+	}
+
+	routeErrors := make(chan error, 1)
+	go func() {
+		_, err := manager.Route(&Request{Message: expected})
+		routeErrors <- err
+	}()
+
+	for {
+		response = httptest.NewRecorder()
+		lp.ServeHTTP(response, WithIDRequest(id, httptest.NewRequest("GET", "http://localhost.com", nil)))
+		if response.Code != http.StatusNoContent {
+			break
+		}
+	}
+
+	require.Equal(http.StatusOK, response.Code)
+	assert.Equal(wrp.Msgpack.ContentType(), response.HeaderMap.Get("Content-Type"))
+
+	var actual wrp.Message
+	require.NoError(wrp.NewDecoderBytes(response.Body.Bytes(), wrp.Msgpack).Decode(&actual))
+	assert.Equal(*expected, actual)
+	assert.NoError(<-routeErrors)
+
+	// inbound messages are POSTed
+	inbound := &wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      string(id),
+		Destination: "event:test",
+		Payload:     []byte("inbound"),
+	}
+
+	response = httptest.NewRecorder()
+	lp.ServeHTTP(
+		response,
+		WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", bytes.NewReader(wrp.MustEncode(inbound, wrp.Msgpack)))),
+	)
+
+	assert.Equal(http.StatusAccepted, response.Code)
+	received := <-events
+	assert.Equal(MessageReceived, received.Type)
+	assert.Equal(id, received.Device.ID())
+	assert.Equal(inbound.Payload, received.Message.(*wrp.Message).Payload)
+
+	// disconnection is shared with the manager, and removes the session
+	assert.True(lp.Disconnect(id))
+	assert.Equal(Disconnect, (<-events).Type)
+	assert.Equal(0, manager.Len())
+
+	response = httptest.NewRecorder()
+	lp.ServeHTTP(response, WithIDRequest(id, httptest.NewRequest("GET", "http://localhost.com", nil)))
+	assert.Equal(http.StatusNotFound, response.Code)
+}
+
+func testLongPollConnectorIdle(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		manager = NewManager(&Options{
+			IdlePeriod: 50 * time.Millisecond,
+		})
+	)
+
+	lp, err := NewLongPollConnector(manager, 0)
+	require.NoError(err)
+
+	response := httptest.NewRecorder()
+	_, err = lp.Connect(response, WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	require.NoError(err)
+
+	// a device that neither polls nor posts is eventually disconnected
+	deadline := time.Now().Add(5 * time.Second)
+	for manager.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(0, manager.Len())
+}
+
+func TestLongPollConnector(t *testing.T) {
+	t.Run("NewLongPollConnector", func(t *testing.T) {
+		t.Run("UnsupportedManager", testNewLongPollConnectorUnsupportedManager)
+		t.Run("Defaults", testNewLongPollConnectorDefaults)
+	})
+
+	t.Run("MissingSession", testLongPollConnectorMissingSession)
+	t.Run("Session", testLongPollConnectorSession)
+	t.Run("Idle", testLongPollConnectorIdle)
+}
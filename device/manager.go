@@ -1,12 +1,16 @@
 package device
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/Comcast/webpa-common/clock"
 	"github.com/Comcast/webpa-common/convey"
 	"github.com/Comcast/webpa-common/convey/conveymetric"
 	"github.com/Comcast/webpa-common/secure/handler"
@@ -15,12 +19,28 @@ import (
 	"github.com/Comcast/webpa-common/logging"
 	"github.com/Comcast/webpa-common/wrp"
 	"github.com/Comcast/webpa-common/xhttp"
+	"github.com/Comcast/webpa-common/xmetrics"
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
 	"github.com/gorilla/websocket"
 )
 
 const MaxDevicesHeader = "X-Xmidt-Max-Devices"
 
+const (
+	// PartnerCloseCode is the websocket close code sent to devices disconnected via DisconnectPartner
+	PartnerCloseCode = websocket.CloseGoingAway
+
+	// PartnerCloseText is the close frame text sent to devices disconnected via DisconnectPartner
+	PartnerCloseText = "partner disconnected"
+
+	// drainPollInterval is how often devices are checked for outstanding transactions while draining
+	drainPollInterval = 10 * time.Millisecond
+
+	// disconnectIfAsyncWorkers is the maximum number of goroutines DisconnectIfAsync uses to apply its predicate
+	disconnectIfAsyncWorkers = 16
+)
+
 // Connector is a strategy interface for managing device connections to a server.
 // Implementations are responsible for upgrading websocket connections and providing
 // for explicit disconnection.
@@ -29,10 +49,13 @@ type Connector interface {
 	// management of the device.
 	Connect(http.ResponseWriter, *http.Request, http.Header) (Interface, error)
 
-	// Disconnect disconnects the device associated with the given id.
-	// If the id was found, this method returns true.
+	// Disconnect disconnects the device associated with the given id, including any duplicate sessions.
+	// If the id was found, this method returns true.  Each device is sent a close frame with CloseDisconnected.
 	Disconnect(ID) bool
 
+	// DisconnectWithReason is like Disconnect, except that each device is sent a close frame with the given reason
+	DisconnectWithReason(ID, CloseReason) bool
+
 	// DisconnectIf iterates over all devices known to this manager, applying the
 	// given predicate.  For any devices that result in true, this method disconnects them.
 	// Note that this method may pause connections and disconnections while it is executing.
@@ -45,17 +68,72 @@ type Connector interface {
 	// a deadlock will likely occur.
 	DisconnectIf(func(ID) bool) int
 
+	// DisconnectIfAsync is like DisconnectIf, except that the predicate is applied concurrently and
+	// without holding any lock.  The IDs of connected devices are captured first, the predicate is then
+	// applied to those IDs in parallel, and finally each matching ID is disconnected.  Connections and
+	// disconnections are never paused while the predicate runs, which makes this method appropriate for
+	// expensive predicates, such as those that consult an external service.
+	//
+	// The tradeoff is relaxed consistency.  A device that connects after the IDs are captured is never
+	// considered, and a device that reconnects under a matched ID before the disconnection happens is
+	// disconnected as well.  This method returns the number of devices that were disconnected, including
+	// any duplicate sessions.
+	//
+	// The predicate must be safe for concurrent use.  Unlike with DisconnectIf, it is safe to call methods
+	// on this Manager from within the predicate.
+	DisconnectIfAsync(func(ID) bool) int
+
 	// DisconnectAll disconnects all devices from this instance, and returns the count of
 	// devices disconnected.
 	DisconnectAll() int
+
+	// DisconnectPartner gracefully disconnects every device associated with the given partner,
+	// including duplicate sessions.  Each device is first sent any messages already queued for it, followed by
+	// a close frame with the PartnerCloseCode.  This method then waits up to the grace period for those devices'
+	// queued messages to be written and in-flight transactions to complete, after which any remaining devices
+	// are forcibly closed.  This method blocks for at most the grace period,
+	// and returns the count of devices disconnected.
+	DisconnectPartner(partner string, grace time.Duration) int
 }
 
 // Router handles dispatching messages to devices.
 type Router interface {
 	// Route dispatches a WRP request to exactly one device, identified by the ID
 	// field of the request.  Route is synchronous, and honors the cancellation semantics
-	// of the Request's context.
+	// of the Request's context.  If duplicate sessions are allowed, the session is chosen
+	// by Options.SessionSelection, which defaults to the most recently connected session.
 	Route(*Request) (*Response, error)
+
+	// RouteToSession is like Route, except that the request is sent to the one connection with the given
+	// session ID, as reported by Interface.SessionID.  This suits stateful interactions that must stay with
+	// a single physical connection even when duplicate sessions are allowed.  The request's destination is
+	// not consulted.  If that session is no longer connected, ErrorSessionNotFound is returned.
+	RouteToSession(string, *Request) (*Response, error)
+
+	// SendUrgent writes a message to the device with the given ID ahead of any messages already queued
+	// for that device, which suits rare operational commands such as a reboot during an incident.  This method
+	// blocks until the message has been written or the write has failed.  Urgent messages honor the write timeout
+	// and produce the usual MessageSent or MessageFailed events, but are not subject to flow control.
+	SendUrgent(ID, *wrp.Message) error
+
+	// SendRaw routes an already encoded WRP message, in the given format, to the device with the given ID.  Only the
+	// type, source, destination, and transaction UUID are decoded, for routing and transactions, and the contents are
+	// written to the device exactly as supplied.  Devices expect wrp.Msgpack, so any other format must be one the
+	// device is known to understand.  As the rest of the message is never decoded, its expiry and chain ID are not
+	// honored, and the Message of each resulting Event holds only the decoded fields.  This method waits on a
+	// background context, so a transactional message waits until the device responds or disconnects.
+	SendRaw(ID, []byte, wrp.Format) (*Response, error)
+
+	// Chain sends a sequence of transactional requests to one device, in order, with the given chain ID set on
+	// each message.  A chain never has more than one transaction outstanding:  each request waits for the response
+	// to the one before it, and requests routed separately with the same chain ID wait for the whole sequence.
+	// The sequence stops at the first error, returning the responses received up to that point.
+	Chain(context.Context, string, ...*Request) ([]*Response, error)
+
+	// RouteStream dispatches a WRP request to one device, like Route, for a subscription that produces many
+	// responses sharing the request's transaction UUID.  Each response is delivered on the returned channel,
+	// which is closed when the stream ends.  The returned function cancels the stream, and must always be called.
+	RouteStream(*Request) (<-chan *Response, func(), error)
 }
 
 // Registry is the strategy interface for querying the set of connected devices.  Methods
@@ -64,7 +142,8 @@ type Registry interface {
 	// Len returns the count of devices currently in this registry
 	Len() int
 
-	// Get returns the device associated with the given ID, if any
+	// Get returns the device associated with the given ID, if any.  If duplicate sessions
+	// are allowed, the most recently connected session for the ID is returned.
 	Get(ID) (Interface, bool)
 
 	// VisitAll applies the given visitor function to each device known to this manager.
@@ -80,6 +159,42 @@ type Manager interface {
 	Connector
 	Router
 	Registry
+
+	// Close shuts down this Manager.  New connections are rejected with ErrorManagerClosed, and every
+	// connected device is disconnected.  Once Close has been called, no further events are dispatched
+	// to listeners, including the Disconnect events for the devices closed by this method.  Dispatches
+	// already in progress are allowed to complete, and Close does not return until they have.
+	//
+	// Close is idempotent, and subsequent calls return immediately.  It must not be called from within
+	// a listener, since it would wait on that listener's own dispatch.
+	Close() error
+
+	// Closed returns a channel that is closed when Close is first called.  Listeners that do background
+	// work can select on this channel to know when to stop.
+	Closed() <-chan struct{}
+
+	// Ping writes a ping to the identified device right away, rather than waiting for the next periodic ping,
+	// and returns the time until the device's pong was read.  If no pong arrives within the timeout, this method
+	// returns ErrorPingTimeout.  A nonpositive timeout means DefaultPingTimeout.
+	//
+	// ErrorDeviceNotFound is returned if no such device is connected, and ErrorPingUnsupported is returned for
+	// long-poll devices.
+	Ping(id ID, timeout time.Duration) (time.Duration, error)
+
+	// Stats returns a consistent snapshot of this Manager's device count, capacity, pending transactions, and
+	// queued messages, along with whether it is accepting connections and its uptime.  This method is cheap
+	// enough to call every few seconds, as an admin endpoint might, though it briefly read locks the registry.
+	Stats() ManagerStats
+
+	// Tap copies each raw binary frame read from the identified device to w, in addition to its normal processing,
+	// for packet-capture style debugging.  Each frame is written as its length, a 4-byte big-endian integer,
+	// followed by the frame itself.  Frames are buffered for w, and are dropped if w falls behind, so a tap never
+	// slows the device.  A device has at most one tap, and ErrorDeviceTapped is returned if it already has one.
+	//
+	// The returned function detaches the tap and waits for any frames already buffered to be written, after which
+	// w is no longer used.  The tap is also detached when the device disconnects or when a write to w fails.
+	// ErrorDeviceNotFound is returned if no such device is connected.
+	Tap(id ID, w io.Writer) (func(), error)
 }
 
 // NewManager constructs a Manager from a set of options.  A ConnectionFactory will be
@@ -90,28 +205,92 @@ func NewManager(o *Options) Manager {
 		measures = NewMeasures(o.metricsProvider())
 	)
 
-	return &manager{
+	m := &manager{
 		logger:   logger,
 		errorLog: logging.Error(logger),
 		debugLog: logging.Debug(logger),
 
+		clock:            o.clock(),
+		idNormalizer:     o.idNormalizer(),
+		idExtractor:      o.idExtractor(),
+		propagateTrace:   o.propagateTraceContext(),
+		logSampleRate:    o.logSampleRate(),
+		redactLogSamples: o.redactLogSamples(),
+		sampleSeeds:      &sampleSeeds{next: uint64(o.now()().UnixNano())},
+		now:              o.now(),
+		startedAt:        o.now()(),
 		readDeadline:     NewDeadline(o.idlePeriod(), o.now()),
 		writeDeadline:    NewDeadline(o.writeTimeout(), o.now()),
 		upgrader:         o.upgrader(),
 		conveyTranslator: conveyhttp.NewHeaderTranslator("", nil),
 		devices: newRegistry(registryOptions{
-			Logger:   logger,
-			Limit:    o.maxDevices(),
-			Measures: measures,
+			Logger:              logger,
+			Limit:               o.maxDevices(),
+			PartnerQuotas:       o.partnerQuotas(),
+			DefaultPartnerQuota: o.defaultPartnerQuota(),
+			DuplicatePolicy:     o.duplicatePolicy(),
+			SessionSelection:    o.sessionSelection(),
+			OnEvict:             o.onEvict(),
+			Presence:            o.presenceStore(),
+			Instance:            o.instance(),
+			ResumptionWindow:    o.resumptionWindow(),
+			Clock:               o.clock(),
+			Measures:            measures,
 		}),
 		conveyHWMetric: conveymetric.NewConveyMetric(measures.Models, "hw-model", "model"),
 
+		ipLimiter:         newIPLimiter(o.maxConnectionsPerIP()),
+		forwardedHeader:   o.forwardedHeader(),
+		ipLimitRetryAfter: o.ipLimitRetryAfter(),
+
+		circuitBreakerThreshold: o.circuitBreakerThreshold(),
+		circuitBreakerCooldown:  o.circuitBreakerCooldown(),
+		maxPendingTransactions:  o.maxPendingTransactions(),
+		streamBufferSize:        o.streamBufferSize(),
+		maxInboundRate:          o.maxInboundRate(),
+		inboundRateWindow:       o.inboundRateWindow(),
+		creditFlowControl:       o.creditFlowControl(),
+		maxBatchMessages:        o.maxBatchMessages(),
+		maxBatchBytes:           o.maxBatchBytes(),
+		maxInboundBatchMessages: o.maxInboundBatchMessages(),
+		messageTypePolicy:       o.messageTypePolicy(),
+		passthroughHandler:      o.passthroughHandler(),
+		allowLegacyProtocol:     o.allowLegacyProtocol(),
+		allowJSONFormat:         o.allowJSONFormat(),
+		validateMessages:        o.validateMessages(),
+		sourcePolicy:            o.sourcePolicy(),
+		queryHints:              o.queryHints(),
+		sharedPumps:             o.sharedPumps(),
+		utf8Policy:              o.utf8Policy(),
+
 		deviceMessageQueueSize: o.deviceMessageQueueSize(),
 		pingPeriod:             o.pingPeriod(),
+		pingJitter:             o.pingJitter(),
+		heartbeatPeriod:        o.heartbeatPeriod(),
+		rateWindow:             o.rateWindow(),
+		metricsFlushInterval:   o.metricsFlushInterval(),
+		readThroughput:         gaugeRate{NewRate(o.rateWindow(), o.now()), measures.ReadThroughput},
+		writeThroughput:        gaugeRate{NewRate(o.rateWindow(), o.now()), measures.WriteThroughput},
+
+		listeners:         o.listeners(),
+		dispatchMode:      o.dispatchMode(),
+		eventBufferSize:   o.eventBufferSize(),
+		eventOverflow:     o.eventOverflow(),
+		eventPool:         newManagerEventPool(o),
+		connectAckTimeout: o.connectAckTimeout(),
+		listenerTimeout:   o.listenerTimeout(),
+		measures:          measures,
+		deliveries:        newDeliveryQueue(),
+		outbound:          newOutboundBytes(o.maxOutboundBytes(), measures.OutboundBytes),
+		lifecycle:         newLifecycle(),
+	}
 
-		listeners: o.listeners(),
-		measures:  measures,
+	go m.refreshThroughput(m.clock.NewTicker(m.rateWindow / throughputRefreshes))
+	if interval := o.activitySampleInterval(); interval > 0 {
+		go m.sampleActivity(m.clock.NewTicker(interval))
 	}
+
+	return m
 }
 
 // manager is the internal Manager implementation.
@@ -120,6 +299,15 @@ type manager struct {
 	errorLog log.Logger
 	debugLog log.Logger
 
+	clock            clock.Interface
+	idNormalizer     func(ID) (ID, error)
+	idExtractor      IDExtractor
+	propagateTrace   bool
+	logSampleRate    float64
+	redactLogSamples bool
+	sampleSeeds      *sampleSeeds
+	now              func() time.Time
+	startedAt        time.Time
 	readDeadline     func() time.Time
 	writeDeadline    func() time.Time
 	upgrader         *websocket.Upgrader
@@ -128,24 +316,209 @@ type manager struct {
 	devices        *registry
 	conveyHWMetric conveymetric.Interface
 
+	ipLimiter         *ipLimiter
+	forwardedHeader   string
+	ipLimitRetryAfter time.Duration
+
+	circuitBreakerThreshold int
+	circuitBreakerCooldown  time.Duration
+	maxPendingTransactions  int
+	streamBufferSize        int
+	maxInboundRate          float64
+	inboundRateWindow       time.Duration
+	creditFlowControl       bool
+	maxBatchMessages        int
+	maxBatchBytes           int
+	maxInboundBatchMessages int
+	messageTypePolicy       MessageTypePolicy
+	passthroughHandler      PassthroughHandler
+	allowLegacyProtocol     bool
+	allowJSONFormat         bool
+	validateMessages        bool
+	sourcePolicy            *SourcePolicy
+	queryHints              *QueryHints
+	sharedPumps             bool
+	utf8Policy              UTF8Policy
+
 	deviceMessageQueueSize int
 	pingPeriod             time.Duration
-
-	listeners []Listener
-	measures  Measures
+	pingJitter             float64
+	heartbeatPeriod        time.Duration
+	rateWindow             time.Duration
+	metricsFlushInterval   time.Duration
+
+	// readThroughput and writeThroughput are the aggregate rates across all devices
+	readThroughput  gaugeRate
+	writeThroughput gaugeRate
+
+	listeners       []Listener
+	dispatchMode    DispatchMode
+	eventBufferSize int
+	eventOverflow   OverflowPolicy
+	eventPool       *EventPool
+	measures        Measures
+	deliveries      *deliveryQueue
+	outbound        *outboundBytes
+
+	connectAckTimeout time.Duration
+	listenerTimeout   time.Duration
+	lifecycle         *lifecycle
 }
 
 func (m *manager) Connect(response http.ResponseWriter, request *http.Request, responseHeader http.Header) (Interface, error) {
 	m.debugLog.Log(logging.MessageKey(), "device connect", "url", request.URL)
-	id, ok := GetID(request.Context())
-	if !ok {
+	d, cvy, err := m.newDevice(response, request)
+	if err != nil {
+		return nil, err
+	}
+
+	m.enableResumption(d, request)
+	c, err := m.upgrader.Upgrade(response, request, withResumptionToken(d, withBatchLimit(d, withCreditWindow(d, responseHeader))))
+	if err != nil {
+		if _, ok := err.(websocket.HandshakeError); ok {
+			// the upgrader has already written an error response
+			d.errorLog.Log(logging.MessageKey(), "failed websocket upgrade", logging.ErrorKey(), err)
+		} else {
+			// the connection was hijacked, so no HTTP response can be written
+			d.errorLog.Log(logging.MessageKey(), "failed websocket upgrade after hijacking the connection", logging.ErrorKey(), err)
+		}
+
+		d.requestClose(CloseError)
+		return nil, err
+	}
+
+	d.debugLog.Log(logging.MessageKey(), "websocket upgrade complete", "localAddress", c.LocalAddr().String())
+	// the device is not yet registered, so no other goroutine can observe these changes
+	switch subprotocol := c.Subprotocol(); {
+	case m.passthroughHandler != nil && subprotocol == PassthroughSubprotocol:
+		d.passthrough = true
+		d.debugLog.Log(logging.MessageKey(), "passthrough device connected")
+
+	case m.allowLegacyProtocol && subprotocol == LegacySubprotocol:
+		d.protocol = wrp.ProtocolV1
+
+	case m.allowJSONFormat && subprotocol == JSONSubprotocol:
+		d.format = wrp.JSON
+	}
+
+	// unlike long-poll devices, websocket devices answer pings, so they can be probed on demand
+	d.probes = newProbes()
+
+	pinger, err := NewPinger(c, m.measures.Ping, []byte(d.ID()), m.writeDeadline)
+	if err != nil {
+		d.errorLog.Log(logging.MessageKey(), "unable to create pinger", logging.ErrorKey(), err)
+		d.requestClose(CloseError)
+		c.Close()
+		return nil, err
+	}
+
+	if err := m.register(d, cvy); err != nil {
+		// the HTTP exchange is already over, so the close frame is the only way to inform the device.
+		// registration closes a rejected device with the reason for its rejection.
+		c.WriteControl(
+			websocket.CloseMessage,
+			d.closeReason.frame(),
+			m.writeDeadline(),
+		)
+
+		c.Close()
+		return nil, err
+	}
+
+	setPongHandler(c, m.measures.Pong, m.readDeadline, func(data string) {
+		d.probes.pong(data, m.now())
+	})
+
+	m.startPumps(d, c, pinger)
+	return d, nil
+}
+
+// enableResumption issues a resumption token to a websocket device, if resumption is enabled, and records the
+// token the device presented from a previous session.  Registration uses the presented token to resume that
+// session's pending transactions.
+func (m *manager) enableResumption(d *device, request *http.Request) {
+	if m.devices.resumptionWindow <= 0 {
+		return
+	}
+
+	d.resumeToken = newResumptionToken()
+	if len(d.resumeToken) == 0 {
+		d.errorLog.Log(logging.MessageKey(), "unable to issue a resumption token")
+		return
+	}
+
+	d.resumeFrom = request.Header.Get(ResumptionTokenHeader)
+	d.park = func(reason CloseReason) bool {
+		return m.devices.park(d, reason)
+	}
+}
+
+// writeUpgradeError is the default websocket.Upgrader.Error function.  It writes failed handshakes
+// as JSON errors, preserving the status code chosen by the upgrader.
+func writeUpgradeError(response http.ResponseWriter, request *http.Request, status int, reason error) {
+	response.Header().Set("Sec-Websocket-Version", "13")
+	xhttp.WriteError(response, status, reason)
+}
+
+// extractID establishes the normalized ID of the device making the given request.  Any error returned
+// by this method has already been written to the response.
+func (m *manager) extractID(response http.ResponseWriter, request *http.Request) (ID, error) {
+	id, err := m.idExtractor.ExtractID(request)
+	if err != nil {
+		m.errorLog.Log(logging.MessageKey(), "unable to extract device ID", logging.ErrorKey(), err)
+		xhttp.WriteError(
+			response,
+			idExtractionStatus(err),
+			err,
+		)
+
+		return invalidID, err
+	}
+
+	normalizedID, err := m.idNormalizer(id)
+	if err != nil {
+		m.errorLog.Log(logging.MessageKey(), "unable to normalize device ID", "id", id, logging.ErrorKey(), err)
 		xhttp.WriteError(
 			response,
-			http.StatusInternalServerError,
-			ErrorMissingDeviceNameContext,
+			http.StatusBadRequest,
+			err,
 		)
 
-		return nil, ErrorMissingDeviceNameContext
+		return invalidID, err
+	}
+
+	return normalizedID, nil
+}
+
+// newDevice creates, but does not register, a device for the given connection request.  Any
+// error returned by this method has already been written to the response.  This method is
+// independent of the transport used to communicate with the device.
+func (m *manager) newDevice(response http.ResponseWriter, request *http.Request) (*device, convey.C, error) {
+	if m.lifecycle.isClosed() {
+		xhttp.WriteError(
+			response,
+			http.StatusServiceUnavailable,
+			ErrorManagerClosed,
+		)
+
+		return nil, nil, ErrorManagerClosed
+	}
+
+	request, err := m.queryHints.apply(request)
+	if err != nil {
+		m.errorLog.Log(logging.MessageKey(), "rejecting device with an invalid query hint", logging.ErrorKey(), err)
+		xhttp.WriteError(
+			response,
+			http.StatusBadRequest,
+			err,
+		)
+
+		return nil, nil, err
+	}
+
+	id, err := m.extractID(response, request)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var (
@@ -162,17 +535,36 @@ func (m *manager) Connect(response http.ResponseWriter, request *http.Request, r
 	}
 
 	cvy, cvyErr := m.conveyTranslator.FromHeader(request.Header)
+	partner, _ := cvy.GetString(PartnerConveyKey)
+	ip := sourceIP(request, m.forwardedHeader)
 	d := newDevice(deviceOptions{
-		ID:          id,
-		C:           cvy,
-		Compliance:  convey.GetCompliance(cvyErr),
-		QueueSize:   m.deviceMessageQueueSize,
-		PartnerIDs:  partnerIDs,
-		SatClientID: satClientID,
-		Trust:       trust,
-		Logger:      m.logger,
+		ID:                     id,
+		C:                      cvy,
+		Compliance:             convey.GetCompliance(cvyErr),
+		QueueSize:              m.deviceMessageQueueSize,
+		MaxPendingTransactions: m.maxPendingTransactions,
+		RateWindow:             m.rateWindow,
+		Now:                    m.now,
+		PartnerIDs:             partnerIDs,
+		SatClientID:            satClientID,
+		Partner:                partner,
+		SourceIP:               ip,
+		Trust:                  trust,
+		Logger:                 m.logger,
 	})
 
+	d.breaker = newCircuitBreaker(m.circuitBreakerThreshold, m.circuitBreakerCooldown, m.now, m.measures)
+	d.transactionOutcomes = m.measures.Transactions
+	d.outbound = m.outbound
+	if m.sharedPumps {
+		d.pump = new(sharedPump)
+	}
+
+	if m.dispatchMode == DispatchAsync && len(m.listeners) > 0 {
+		d.events = newEventQueue(m.eventBufferSize, m.eventOverflow, m.measures.DroppedEvents, m.dispatchInline)
+		d.events.pool = m.eventPool
+	}
+
 	if cvyErr == nil {
 		d.infoLog.Log("convey", cvy)
 	} else {
@@ -183,25 +575,96 @@ func (m *manager) Connect(response http.ResponseWriter, request *http.Request, r
 		d.errorLog.Log(logging.MessageKey(), "missing security information")
 	}
 
-	c, err := m.upgrader.Upgrade(response, request, responseHeader)
-	if err != nil {
-		d.errorLog.Log(logging.MessageKey(), "failed websocket upgrade", logging.ErrorKey(), err)
-		return nil, err
+	if _, exists := m.devices.get(id); exists && m.devices.duplicatePolicy == DuplicateReject {
+		// fail fast with a proper HTTP status, although registration will still enforce this policy
+		d.errorLog.Log(logging.MessageKey(), "rejecting duplicate device")
+		xhttp.WriteError(
+			response,
+			http.StatusConflict,
+			ErrorDuplicateDevice,
+		)
+
+		return nil, nil, ErrorDuplicateDevice
 	}
 
-	d.debugLog.Log(logging.MessageKey(), "websocket upgrade complete", "localAddress", c.LocalAddr().String())
+	if m.allowLegacyProtocol {
+		if d.protocol, err = requestProtocolVersion(cvy, request.Header); err != nil {
+			d.errorLog.Log(logging.MessageKey(), "rejecting device with an invalid protocol version", logging.ErrorKey(), err)
+			xhttp.WriteError(
+				response,
+				http.StatusBadRequest,
+				ErrorInvalidProtocolVersion,
+			)
 
-	pinger, err := NewPinger(c, m.measures.Ping, []byte(d.ID()), m.writeDeadline)
-	if err != nil {
-		d.errorLog.Log(logging.MessageKey(), "unable to create pinger", logging.ErrorKey(), err)
-		c.Close()
-		return nil, err
+			return nil, nil, ErrorInvalidProtocolVersion
+		}
+	}
+
+	if m.creditFlowControl {
+		if d.credits, err = newCreditWindow(request); err != nil {
+			d.errorLog.Log(logging.MessageKey(), "rejecting device with an invalid credit window", logging.ErrorKey(), err)
+			xhttp.WriteError(
+				response,
+				http.StatusBadRequest,
+				err,
+			)
+
+			return nil, nil, err
+		}
+	}
+
+	if d.batchLimit, err = newBatchLimit(request, m.maxBatchMessages); err != nil {
+		d.errorLog.Log(logging.MessageKey(), "rejecting device with an invalid batch limit", logging.ErrorKey(), err)
+		xhttp.WriteError(
+			response,
+			http.StatusBadRequest,
+			err,
+		)
+
+		return nil, nil, err
+	}
+
+	if m.messageTypePolicy != nil {
+		d.allowedTypes = newMessageTypeSet(m.messageTypePolicy(d.ID(), cvy))
 	}
 
+	if m.ipLimiter != nil {
+		if !m.ipLimiter.acquire(ip) {
+			d.errorLog.Log(logging.MessageKey(), "rejecting device over the per-IP connection limit", "sourceIP", ip)
+			response.Header().Set("Retry-After", strconv.Itoa(int(m.ipLimitRetryAfter/time.Second)))
+			xhttp.WriteError(
+				response,
+				http.StatusTooManyRequests,
+				ErrorIPLimitReached,
+			)
+
+			return nil, nil, ErrorIPLimitReached
+		}
+
+		d.onClose = func() { m.ipLimiter.release(ip) }
+	}
+
+	return d, cvy, nil
+}
+
+// register adds a newly created device to the registry and dispatches the Connect event.
+func (m *manager) register(d *device, cvy convey.C) error {
 	if err := m.devices.add(d); err != nil {
-		d.errorLog.Log(logging.MessageKey(), "unable to register device", logging.ErrorKey(), err)
-		c.Close()
-		return nil, err
+		if reason, ok := rejectionReason(err); ok {
+			d.errorLog.Log(logging.MessageKey(), "rejecting device over capacity", "reason", reason, "partner", d.partner, "sourceIP", d.sourceIP, logging.ErrorKey(), err)
+			m.measures.Rejected.With(RejectionReasonLabel, reason, PartnerLabel, partnerLabel(d.partner, m.devices.partnerQuotas)).Add(1.0)
+		} else {
+			d.errorLog.Log(logging.MessageKey(), "unable to register device", logging.ErrorKey(), err)
+		}
+
+		return err
+	}
+
+	if m.lifecycle.isClosed() {
+		// this manager was closed while the device was connecting, and Close may have already disconnected it
+		m.devices.removeDevice(d, CloseShutdown)
+		d.requestClose(CloseShutdown)
+		return ErrorManagerClosed
 	}
 
 	event := &Event{
@@ -209,7 +672,7 @@ func (m *manager) Connect(response http.ResponseWriter, request *http.Request, r
 		Device: d,
 	}
 
-	if cvyErr == nil {
+	if d.compliance == convey.Full {
 		bytes, err := json.Marshal(cvy)
 		if err == nil {
 			event.Format = wrp.JSON
@@ -225,19 +688,140 @@ func (m *manager) Connect(response http.ResponseWriter, request *http.Request, r
 	}
 
 	d.conveyClosure = metricClosure
-	m.dispatch(event)
 
-	SetPongHandler(c, m.measures.Pong, m.readDeadline)
-	closeOnce := new(sync.Once)
-	go m.readPump(d, InstrumentReader(c, d.statistics), closeOnce)
-	go m.writePump(d, InstrumentWriter(c, d.statistics), pinger, closeOnce)
+	// the Connect event is dispatched, or queued ahead of every other event, before the pumps start.  This
+	// guarantees that listeners see Connect exactly once and before any MessageReceived for the device.
+	if d.events == nil {
+		m.dispatch(event)
+	} else if delivered := d.events.enqueueFirst(event); m.connectAckTimeout > 0 {
+		m.awaitConnectAck(d, delivered)
+	}
 
-	return d, nil
+	return nil
+}
+
+// awaitConnectAck waits for listeners to receive a device's queued Connect event or for the connect
+// acknowledgement timeout to elapse.  A timeout is logged, but does not fail the connection.
+func (m *manager) awaitConnectAck(d *device, delivered <-chan struct{}) {
+	timer := m.clock.NewTimer(m.connectAckTimeout)
+	defer timer.Stop()
+	select {
+	case <-delivered:
+	case <-timer.C():
+		d.errorLog.Log(logging.MessageKey(), "timed out waiting for listeners to acknowledge the connect event", "timeout", m.connectAckTimeout)
+	}
+}
+
+// startPumps spawns the read and write goroutines for a registered device over the given connection.
+// Once both pumps have exited, the device can produce no further events.  For a device with a sharedPump,
+// the write goroutine is started on demand instead.
+func (m *manager) startPumps(d *device, c Connection, pinger func() error) {
+	var (
+		closeOnce = new(sync.Once)
+		pumps     = new(sync.WaitGroup)
+	)
+
+	pumps.Add(2)
+	m.measures.PumpGoroutines.Add(1.0)
+	go func() {
+		defer m.measures.PumpGoroutines.Add(-1.0)
+		defer pumps.Done()
+
+		batch := newMetricsBatch(m.metricsFlushInterval, m.now)
+		defer batch.flush()
+		m.readPump(d, InstrumentReader(c, d.statistics, d.readRate, batch.rate(m.readThroughput)), closeOnce, batch)
+	}()
+
+	runWritePump := func() {
+		batch := newMetricsBatch(m.metricsFlushInterval, m.now)
+		defer batch.flush()
+		m.writePump(d, InstrumentWriter(c, d.statistics, d.writeRate, batch.rate(m.writeThroughput)), pinger, closeOnce)
+	}
+
+	if d.pump != nil {
+		d.pump.begin(
+			d,
+			m.clock,
+			func() {
+				m.measures.PumpGoroutines.Add(1.0)
+				go func() {
+					defer m.measures.PumpGoroutines.Add(-1.0)
+					runWritePump()
+				}()
+			},
+			pumps.Done,
+			jitteredPeriod(m.pingPeriod, m.pingJitter, m.sampleSeeds.seed()),
+			m.heartbeatPeriod,
+		)
+	} else {
+		m.measures.PumpGoroutines.Add(1.0)
+		go func() {
+			defer m.measures.PumpGoroutines.Add(-1.0)
+			defer pumps.Done()
+			runWritePump()
+		}()
+	}
+
+	if d.events != nil {
+		go func() {
+			pumps.Wait()
+			d.events.close()
+		}()
+	}
 }
 
+// dispatch delivers an event to listeners according to the dispatch mode.  Under DispatchAsync,
+// events are queued on the originating device's event goroutine.
 func (m *manager) dispatch(e *Event) {
+	if d, ok := e.Device.(*device); ok && d.events != nil {
+		d.events.enqueue(e)
+		return
+	}
+
+	m.dispatchInline(e)
+}
+
+// dispatchInline delivers an event to each listener on the calling goroutine
+func (m *manager) dispatchInline(e *Event) {
+	if !m.lifecycle.enter() {
+		return
+	}
+
+	defer m.lifecycle.exit()
 	for _, listener := range m.listeners {
-		listener(e)
+		if m.listenerTimeout > 0 {
+			m.callListener(listener, e)
+		} else {
+			listener(e)
+		}
+	}
+}
+
+// callListener invokes a listener on its own goroutine, abandoning it if it does not return within the
+// listener timeout.  The listener receives a copy of the event, so the caller may reuse the original.
+func (m *manager) callListener(listener Listener, e *Event) {
+	var (
+		copied   = *e
+		returned = make(chan struct{})
+		timer    = m.clock.NewTimer(m.listenerTimeout)
+	)
+
+	defer timer.Stop()
+	go func() {
+		defer close(returned)
+		listener(&copied)
+	}()
+
+	select {
+	case <-returned:
+	case <-timer.C():
+		m.measures.ListenerTimeout.Inc()
+		logging.Warn(m.logger).Log(
+			logging.MessageKey(), "abandoned a listener that did not return in time",
+			"id", copied.Device.ID(),
+			"eventType", copied.Type,
+			"timeout", m.listenerTimeout,
+		)
 	}
 }
 
@@ -249,8 +833,10 @@ func (m *manager) dispatch(e *Event) {
 // dispatches message failed events for any messages that were waiting to be delivered
 // at the time of pump closure.
 func (m *manager) pumpClose(d *device, c io.Closer, pumpError error) {
-	// remove will invoke requestClose()
-	m.devices.remove(d.id)
+	// only this device instance is removed, as it may already have been replaced by a duplicate
+	reason := pumpCloseReason(pumpError)
+	m.devices.removeDevice(d, reason)
+	d.requestClose(reason)
 
 	closeError := c.Close()
 
@@ -258,34 +844,66 @@ func (m *manager) pumpClose(d *device, c io.Closer, pumpError error) {
 		"closeError", closeError, "pumpError", pumpError,
 		"finalStatistics", d.Statistics().String())
 
-	m.dispatch(
-		&Event{
-			Type:   Disconnect,
-			Device: d,
-		},
-	)
+	m.dispatch(disconnectEvent(d))
 	d.conveyClosure()
 }
 
 // readPump is the goroutine which handles the stream of WRP messages from a device.
 // This goroutine exits when any error occurs on the connection.
-func (m *manager) readPump(d *device, r ReadCloser, closeOnce *sync.Once) {
+func (m *manager) readPump(d *device, r ReadCloser, closeOnce *sync.Once, batch *metricsBatch) {
 	defer d.debugLog.Log(logging.MessageKey(), "readPump exiting")
 	d.debugLog.Log(logging.MessageKey(), "readPump starting")
 
 	var (
 		readError error
-		decoder   = wrp.NewDecoder(nil, wrp.Msgpack)
+		decoder   = frameDecoder(d)
+		sampler   = newLogSampler(m.logSampleRate, m.sampleSeeds.seed())
+		flood     = newFloodGuard(m.maxInboundRate, m.inboundRateWindow, m.now)
+
+		requestResponse = batch.adder(m.measures.RequestResponse)
 	)
 
 	// all the read pump has to do is ensure the device and the connection are closed
 	// it is the write pump's responsibility to do further cleanup
-	defer closeOnce.Do(func() { m.pumpClose(d, r, readError) })
+	handoff := false
+	defer func() {
+		if !handoff {
+			closeOnce.Do(func() { m.pumpClose(d, r, readError) })
+		}
+	}()
+
+	defer func() {
+		if ft := d.loadTap(); ft != nil {
+			d.detachTap(ft)
+		}
+	}()
 
 	for {
-		messageType, data, readError := r.ReadMessage()
+		var (
+			messageType int
+			data        []byte
+		)
+
+		messageType, data, readError = r.ReadMessage()
 		if readError != nil {
 			d.errorLog.Log(logging.MessageKey(), "read error", logging.ErrorKey(), readError)
+			if pumpCloseReason(readError) == CloseIdle {
+				// an idle connection can still be written to, so the write pump is left to send the close frame
+				// and then close the connection
+				handoff = true
+				d.requestClose(CloseIdle)
+			}
+
+			return
+		}
+
+		if !flood.allow() {
+			// as with an idle connection, the write pump sends the close frame and then closes the connection
+			d.errorLog.Log(logging.MessageKey(), "disconnecting device that exceeded the maximum inbound rate", "maxInboundRate", m.maxInboundRate)
+			m.measures.FloodDisconnect.Inc()
+			handoff = true
+			m.devices.removeDevice(d, CloseFlood)
+			d.requestClose(CloseFlood)
 			return
 		}
 
@@ -294,106 +912,218 @@ func (m *manager) readPump(d *device, r ReadCloser, closeOnce *sync.Once) {
 			continue
 		}
 
-		var (
-			message = new(wrp.Message)
-			event   = Event{
-				Type:     MessageReceived,
-				Device:   d,
-				Message:  message,
-				Format:   wrp.Msgpack,
-				Contents: data,
-			}
-		)
+		if ft := d.loadTap(); ft != nil {
+			ft.offer(data)
+		}
 
-		decoder.ResetBytes(data)
-		err := decoder.Decode(message)
-		decoder.ResetBytes(nil)
-		if err != nil {
-			d.errorLog.Log(logging.MessageKey(), "skipping malformed WRP message", logging.ErrorKey(), err)
+		m.measures.InboundMessageSize.Observe(float64(len(data)))
+
+		if d.passthrough {
+			passthroughFrame(d, m.passthroughHandler, data)
 			continue
 		}
 
-		if message.Type == wrp.SimpleRequestResponseMessageType {
-			m.measures.RequestResponse.Add(1.0)
+		m.readMessage(d, decoder, sampler, requestResponse, data, false)
+	}
+}
+
+// readMessage processes a single WRP message read from a device, either as its own frame or as an element of a
+// wrp.Batch, in which case batched is set.  Malformed messages are logged and skipped.
+func (m *manager) readMessage(d *device, decoder wrp.Decoder, sampler *logSampler, requestResponse xmetrics.Adder, data []byte, batched bool) {
+	var (
+		message = new(wrp.Message)
+		event   = Event{
+			Type:     MessageReceived,
+			Device:   d,
+			Message:  message,
+			Format:   d.format,
+			Contents: data,
 		}
+	)
 
-		// update any waiting transaction
-		if message.IsTransactionPart() {
-			err := d.transactions.Complete(
-				message.TransactionKey(),
-				&Response{
-					Device:   d,
-					Message:  message,
-					Format:   wrp.Msgpack,
-					Contents: data,
-				},
-			)
+	err := decodeFrame(d, decoder, data, message)
+	if err != nil {
+		m.skipMalformed(d, "skipping malformed WRP message", err)
+		return
+	}
 
-			if err != nil {
-				d.errorLog.Log(logging.MessageKey(), "Error while completing transaction", "transactionKey", message.TransactionKey(), logging.ErrorKey(), err)
-				event.Type = TransactionBroken
-				event.Error = err
-			} else {
-				event.Type = TransactionComplete
-			}
+	if message.Type == wrp.BatchMessageType {
+		m.readBatch(d, decoder, sampler, requestResponse, data, batched)
+		return
+	}
+
+	sanitized, err := m.utf8Policy.apply(message)
+	if err != nil {
+		m.skipMalformed(d, "skipping WRP message with invalid UTF-8", err)
+		return
+	}
+
+	if sampler.sample() {
+		logged := message
+		if m.redactLogSamples {
+			logged = message.Redacted()
+		}
+
+		d.infoLog.Log(logging.MessageKey(), "sampled inbound message", "message", logged)
+	}
+
+	if message.Type == wrp.CreditGrantMessageType && d.credits != nil {
+		var grant wrp.CreditGrant
+		if err := decodeFrame(d, decoder, data, &grant); err != nil {
+			m.skipMalformed(d, "skipping malformed credit grant", err)
+			return
+		}
+
+		// credit grants are part of the connection protocol, so they never reach listeners
+		d.credits.grant(grant.Credits)
+		d.pump.wake()
+		return
+	}
+
+	if message.Type == wrp.DisconnectMessageType {
+		announcement := new(wrp.Disconnect)
+		if err := decodeFrame(d, decoder, data, announcement); err != nil {
+			m.skipMalformed(d, "skipping malformed disconnect", err)
+			return
+		}
+
+		// the announcement is delivered with the Disconnect event rather than dispatched here
+		d.infoLog.Log(logging.MessageKey(), "device-initiated disconnect", "reason", announcement.Reason)
+		d.requestDeviceDisconnect(announcement, data)
+		return
+	}
+
+	if !d.allowedTypes.allows(message.Type) {
+		m.dropDisallowed(d, message.Type)
+		return
+	}
+
+	if d.protocol == wrp.ProtocolV1 || sanitized {
+		// listeners and transactions always receive contents in the current layout, and that match the message
+		if data, err = normalizeFrame(message, d.format); err != nil {
+			d.errorLog.Log(logging.MessageKey(), "skipping WRP message that could not be normalized", logging.ErrorKey(), err)
+			return
 		}
 
-		m.dispatch(&event)
+		event.Contents = data
+	}
+
+	if message.Type == wrp.SimpleRequestResponseMessageType {
+		requestResponse.Add(1.0)
+	}
+
+	// update any waiting transaction, which includes delivery acknowledgements
+	if isAck := message.IsAck(); isAck || message.IsTransactionPart() {
+		err := d.transactions.Complete(
+			message.TransactionKey(),
+			&Response{
+				Device:   d,
+				Message:  message,
+				Format:   d.format,
+				Contents: data,
+				Ack:      isAck,
+			},
+		)
+
+		if err != nil {
+			d.errorLog.Log(logging.MessageKey(), "Error while completing transaction", "transactionKey", message.TransactionKey(), logging.ErrorKey(), err)
+			event.Type = TransactionBroken
+			event.Error = err
+			recordTransaction(m.measures.Transactions, TransactionBrokenOutcome)
+		} else {
+			event.Type = TransactionComplete
+		}
 	}
+
+	m.dispatch(&event)
 }
 
 // writePump is the goroutine which services messages addressed to the device.
 // this goroutine exits when either an explicit shutdown is requested or any
-// error occurs on the connection.
+// error occurs on the connection.  For a device with a sharedPump, this goroutine
+// may also park, leaving the device open, after it lingers without work.
 func (m *manager) writePump(d *device, w WriteCloser, pinger func() error, closeOnce *sync.Once) {
 	defer d.debugLog.Log(logging.MessageKey(), "writePump exiting")
 	d.debugLog.Log(logging.MessageKey(), "writePump starting")
 
+	// this is deferred first, so that a shared pump finishes only after all of the cleanup below
+	parked := false
+	defer func() {
+		if !parked {
+			d.pump.exit()
+		}
+	}()
+
 	var (
 		envelope   *envelope
 		encoder    = wrp.NewEncoder(nil, wrp.Msgpack)
 		writeError error
 
-		pingTicker = time.NewTicker(m.pingPeriod)
+		pingTicker clock.Ticker
+		pings      <-chan time.Time
+
+		// heartbeats is nil, and so never selected, unless heartbeats are enabled
+		heartbeatTicker clock.Ticker
+		heartbeats      <-chan time.Time
+
+		// lingering is nil, and so never selected, unless this pump can park
+		linger    clock.Timer
+		lingering <-chan time.Time
+
+		// probes is nil, and so never selected, for long-poll devices
+		probes = d.probes.queue()
 	)
 
+	if d.pump != nil {
+		// a shared pump's timers outlive any one write pump
+		pings, heartbeats = d.pump.pings, d.pump.heartbeats
+		linger = m.clock.NewTimer(sharedPumpLinger)
+		lingering = linger.C()
+	} else {
+		pingTicker = m.clock.NewTicker(jitteredPeriod(m.pingPeriod, m.pingJitter, m.sampleSeeds.seed()))
+		pings = pingTicker.C()
+		if m.heartbeatPeriod > 0 {
+			heartbeatTicker = m.clock.NewTicker(m.heartbeatPeriod)
+			heartbeats = heartbeatTicker.C()
+		}
+	}
+
 	// cleanup: we not only ensure that the device and connection are closed but also
 	// ensure that any messages that were waiting and/or failed are dispatched to
 	// the configured listener
 	defer func() {
-		pingTicker.Stop()
-		closeOnce.Do(func() { m.pumpClose(d, w, writeError) })
+		if pingTicker != nil {
+			pingTicker.Stop()
+		}
 
-		// notify listener of any message that just now failed
-		// any writeError is passed via this event
-		if envelope != nil {
-			m.dispatch(&Event{
-				Type:     MessageFailed,
-				Device:   d,
-				Message:  envelope.request.Message,
-				Format:   envelope.request.Format,
-				Contents: envelope.request.Contents,
-				Error:    writeError,
-			})
+		if heartbeatTicker != nil {
+			heartbeatTicker.Stop()
+		}
+
+		if linger != nil {
+			linger.Stop()
 		}
 
-		// drain the messages, dispatching them as message failed events.  we never close
-		// the message channel, so just drain until a receive would block.
+		if parked {
+			return
+		}
+
+		closeOnce.Do(func() { m.pumpClose(d, w, writeError) })
+
+		// any message that just now failed was already dispatched by writeQueued or writeCoalesced, so only
+		// the messages still queued remain.  drain the messages, dispatching them as message failed events.  the queues are closed
+		// to senders first, so once a receive would block every message enqueued for this device
+		// has had exactly one MessageSent or MessageFailed event.
 		//
 		// Nil is passed explicitly as the error to indicate that these messages failed due
 		// to the device disconnecting, not due to an actual I/O error.
+		d.closeQueues()
 		for {
 			select {
+			case undeliverable := <-d.urgent:
+				m.failUndeliverable(d, undeliverable, writeError)
 			case undeliverable := <-d.messages:
-				d.errorLog.Log(logging.MessageKey(), "undeliverable message", "deviceMessage", undeliverable)
-				m.dispatch(&Event{
-					Type:     MessageFailed,
-					Device:   d,
-					Message:  undeliverable.request.Message,
-					Format:   undeliverable.request.Format,
-					Contents: undeliverable.request.Contents,
-					Error:    writeError,
-				})
+				m.failUndeliverable(d, undeliverable, writeError)
 			default:
 				return
 			}
@@ -403,65 +1133,401 @@ func (m *manager) writePump(d *device, w WriteCloser, pinger func() error, close
 	for writeError == nil {
 		envelope = nil
 
+		// urgent messages always jump ahead of the normal queue
+		select {
+		case envelope = <-d.urgent:
+			writeError = m.writeQueued(d, w, encoder, envelope)
+			continue
+		default:
+		}
+
+		// under flow control, messages are left queued until the device grants more credits
+		messages := d.messages
+		if !d.credits.ready() {
+			messages = nil
+		}
+
 		select {
 		case <-d.shutdown:
-			d.debugLog.Log(logging.MessageKey(), "explicit shutdown")
+			// the close frame is a courtesy that lets the device adapt its reconnect logic, so failing to write it
+			// is not treated as a write error
+			d.debugLog.Log(logging.MessageKey(), "explicit shutdown", "closeCode", d.closeReason.Code)
+			w.SetWriteDeadline(m.writeDeadline())
+			if closeFrameError := w.WriteMessage(websocket.CloseMessage, d.closeReason.frame()); closeFrameError != nil {
+				d.debugLog.Log(logging.MessageKey(), "unable to send close frame", logging.ErrorKey(), closeFrameError)
+			}
+
 			writeError = w.Close()
 			return
 
-		case envelope = <-d.messages:
-			var frameContents []byte
-			if envelope.request.Format == wrp.Msgpack && len(envelope.request.Contents) > 0 {
-				frameContents = envelope.request.Contents
+		case <-d.credits.replenished():
+			d.debugLog.Log(logging.MessageKey(), "credits granted", "credits", d.credits.remaining())
+
+		case envelope = <-d.urgent:
+			writeError = m.writeQueued(d, w, encoder, envelope)
+
+		case envelope = <-messages:
+			if d.batchLimit > 1 && !d.passthrough {
+				writeError = m.writeCoalesced(d, w, encoder, envelope)
 			} else {
-				// if the request was in a format other than Msgpack, or if the caller did not pass
-				// Contents, then do the encoding here.
-				encoder.ResetBytes(&frameContents)
-				writeError = encoder.Encode(envelope.request.Message)
-				encoder.ResetBytes(nil)
+				writeError = m.writeQueued(d, w, encoder, envelope)
 			}
 
-			if writeError == nil {
-				writeError = w.WriteMessage(websocket.BinaryMessage, frameContents)
+		case <-d.departing:
+			// the device asked to disconnect, so finish writing what is already queued and then close.  under flow
+			// control, messages beyond the device's remaining credits are failed as undeliverable.
+			d.debugLog.Log(logging.MessageKey(), "draining messages before a device-initiated disconnect", "pending", len(d.messages))
+			if writeError = m.drainQueued(d, w, encoder); writeError == nil {
+				writeError = w.Close()
 			}
 
-			event := Event{
-				Device:   d,
-				Message:  envelope.request.Message,
-				Format:   envelope.request.Format,
-				Contents: envelope.request.Contents,
-				Error:    writeError,
+			return
+
+		case frame := <-d.closeFrames:
+			// nothing can be written after a close frame, so the messages already queued are written first.  after
+			// the close frame, the device is only expected to finish any outstanding transactions.
+			d.debugLog.Log(logging.MessageKey(), "draining messages before sending a close frame", "pending", len(d.messages))
+			if writeError = m.drainQueued(d, w, encoder); writeError == nil {
+				d.debugLog.Log(logging.MessageKey(), "sending close frame")
+				writeError = w.WriteMessage(websocket.CloseMessage, frame)
 			}
 
-			if writeError != nil {
-				envelope.complete <- writeError
-				event.Type = MessageFailed
-			} else {
-				event.Type = MessageSent
+		case <-pings:
+			writeError = pinger()
+			d.pump.pinged()
+
+		case data := <-probes:
+			d.debugLog.Log(logging.MessageKey(), "sending on-demand ping")
+			w.SetWriteDeadline(m.writeDeadline())
+			writeError = w.WriteMessage(websocket.PingMessage, data)
+
+		case <-heartbeats:
+			d.pump.heartbeat()
+			m.dispatch(&Event{
+				Type:       Heartbeat,
+				Device:     d,
+				Statistics: d.Statistics().Snapshot(),
+			})
+
+		case <-lingering:
+			if d.pump.park(d) {
+				d.debugLog.Log(logging.MessageKey(), "parking write pump")
+				parked = true
+				return
 			}
 
-			close(envelope.complete)
-			m.dispatch(&event)
+			linger.Reset(sharedPumpLinger)
+		}
+	}
+}
 
-		case <-pingTicker.C:
-			writeError = pinger()
+// drainQueued writes the messages already queued for a device, urgent messages first.  Under flow control, messages
+// beyond the device's remaining credits are left queued.  The returned error is nil unless a write failed.
+func (m *manager) drainQueued(d *device, w WriteCloser, encoder wrp.Encoder) error {
+	for {
+		select {
+		case e := <-d.urgent:
+			if err := m.writeQueued(d, w, encoder, e); err != nil {
+				return err
+			}
+
+			continue
+		default:
+		}
+
+		if !d.credits.ready() {
+			return nil
+		}
+
+		select {
+		case e := <-d.messages:
+			if err := m.writeQueued(d, w, encoder, e); err != nil {
+				return err
+			}
+
+		default:
+			return nil
 		}
 	}
 }
 
+// failUndeliverable dispatches a message left queued when the write pump exited as a failure.  The writeError is
+// nil if the pump exited because the device disconnected, rather than due to an actual I/O error.
+func (m *manager) failUndeliverable(d *device, undeliverable *envelope, writeError error) {
+	d.errorLog.Log(logging.MessageKey(), "undeliverable message", "deviceMessage", undeliverable)
+	d.dequeued(undeliverable)
+	if writeError != nil {
+		m.deliveries.delivered(undeliverable.request, writeError)
+	} else {
+		m.deliveries.delivered(undeliverable.request, ErrorDeviceClosed)
+	}
+
+	m.dispatch(&Event{
+		Type:     MessageFailed,
+		Device:   d,
+		Message:  undeliverable.request.Message,
+		Format:   undeliverable.request.Format,
+		Contents: undeliverable.request.Contents,
+		Error:    writeError,
+	})
+}
+
+// expireEnvelope fails an envelope whose message expired before it could be written
+func (m *manager) expireEnvelope(d *device, e *envelope) {
+	// delivering a message after its expiry can be harmful, e.g. a late command
+	d.errorLog.Log(logging.MessageKey(), "dropping expired message", logging.ErrorKey(), ErrorRequestExpired)
+	d.dequeued(e)
+	m.observeQueueTime(e, QueueExpiredOutcome)
+	e.complete <- ErrorRequestExpired
+	close(e.complete)
+	m.deliveries.delivered(e.request, ErrorRequestExpired)
+	m.dispatch(&Event{
+		Type:     MessageFailed,
+		Device:   d,
+		Message:  e.request.Message,
+		Format:   e.request.Format,
+		Contents: e.request.Contents,
+		Error:    ErrorRequestExpired,
+	})
+}
+
+// writeQueued writes a single envelope taken from a device's queue, dispatching the outcome to listeners.  An
+// expired envelope is failed without being written.  The returned error is nil unless the write itself failed.
+func (m *manager) writeQueued(d *device, w WriteCloser, encoder wrp.Encoder, e *envelope) error {
+	e.serviced = m.now()
+	if e.request.expired(e.serviced) {
+		m.expireEnvelope(d, e)
+		return nil
+	}
+
+	if !e.urgent {
+		d.credits.spend()
+	}
+
+	writeError := writeEnvelope(d, w, encoder, e, m.measures.OutboundMessageSize)
+	d.dequeued(e)
+	event := Event{
+		Device:   d,
+		Message:  e.request.Message,
+		Format:   e.request.Format,
+		Contents: e.request.Contents,
+		Error:    writeError,
+	}
+
+	if writeError != nil {
+		m.observeQueueTime(e, QueueFailedOutcome)
+		e.complete <- writeError
+		event.Type = MessageFailed
+	} else {
+		m.observeQueueTime(e, QueueSentOutcome)
+		event.Type = MessageSent
+	}
+
+	close(e.complete)
+	m.deliveries.delivered(e.request, writeError)
+	m.dispatch(&event)
+	return writeError
+}
+
+// observeQueueTime records how long a serviced envelope waited in its device's queue
+func (m *manager) observeQueueTime(e *envelope, outcome string) {
+	m.measures.QueueTime.With(QueueOutcomeLabel, outcome).Observe(e.serviced.Sub(e.enqueued).Seconds())
+}
+
+// recoveredError converts a value obtained from recover into an error
+func recoveredError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("recovered from panic: %s", err)
+	}
+
+	return fmt.Errorf("recovered from panic: %v", r)
+}
+
+// skipMalformed logs and counts a frame that could not be decoded, classifying the decode error so that
+// each kind of malformed frame can be monitored separately
+func (m *manager) skipMalformed(d *device, message string, err error) {
+	reason := malformedReason(err)
+	m.measures.Malformed.With(MalformedReasonLabel, reason).Add(1.0)
+	d.errorLog.Log(logging.MessageKey(), message, MalformedReasonLabel, reason, logging.ErrorKey(), err)
+}
+
+// decodeFrame decodes a single frame read from a device.  A panic within the decoder, e.g. due to a codec bug
+// triggered by a malformed frame, is converted into an error so that the frame is skipped like any other
+// malformed message.
+func decodeFrame(d *device, decoder wrp.Decoder, data []byte, target interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+			d.errorLog.Log(logging.MessageKey(), "recovered from panic while decoding", "frameLength", len(data), logging.ErrorKey(), err)
+		}
+	}()
+
+	decoder.ResetBytes(data)
+	defer decoder.ResetBytes(nil)
+	return decoder.Decode(target)
+}
+
+// writeEnvelope encodes, if necessary, and writes a single envelope to a device.  The length of the written frame
+// is observed by sizes.  A panic within the encoder or the connection is converted into an error, so that the write
+// pump exits through its normal cleanup.
+func writeEnvelope(d *device, w Writer, encoder wrp.Encoder, e *envelope, sizes metrics.Histogram) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+
+			var messageType wrp.MessageType
+			if e.request.Message != nil {
+				messageType = e.request.Message.MessageType()
+			}
+
+			d.errorLog.Log(logging.MessageKey(), "recovered from panic while writing", "messageType", messageType, logging.ErrorKey(), err)
+		}
+	}()
+
+	var frameContents []byte
+	if d.passthrough {
+		// passthrough frames are written exactly as supplied
+		frameContents = e.request.Contents
+	} else if frameContents, err = envelopeContents(encoder, e); err != nil {
+		// the request was in a format other than Msgpack, or the caller did not pass Contents, and it could not be encoded
+		return
+	}
+
+	sizes.Observe(float64(len(frameContents)))
+	return w.WriteMessage(websocket.BinaryMessage, frameContents)
+}
+
 func (m *manager) Disconnect(id ID) bool {
-	_, ok := m.devices.remove(id)
+	return m.DisconnectWithReason(id, CloseDisconnected)
+}
+
+func (m *manager) DisconnectWithReason(id ID, reason CloseReason) bool {
+	id, err := m.idNormalizer(id)
+	if err != nil {
+		return false
+	}
+
+	_, ok := m.devices.remove(id, reason)
 	return ok
 }
 
-func (m *manager) DisconnectIf(filter func(ID) bool) int {
-	return m.devices.removeIf(func(d *device) bool {
-		return filter(d.id)
+func (m *manager) DisconnectPartner(partner string, grace time.Duration) int {
+	if len(partner) == 0 || m.devices.partnerCount(partner) == 0 {
+		return 0
+	}
+
+	var matched []*device
+	m.devices.visit(func(d *device) bool {
+		if d.partner == partner {
+			matched = append(matched, d)
+		}
+
+		return true
 	})
+
+	for _, d := range matched {
+		d.requestCodedClose(ClosePartner)
+	}
+
+	m.awaitDrain(matched, grace)
+
+	count := 0
+	for _, d := range matched {
+		if m.devices.removeDevice(d, ClosePartner) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// awaitDrain waits up to the grace period for each of the given devices to either close
+// or have neither queued messages nor outstanding transactions.
+func (m *manager) awaitDrain(devices []*device, grace time.Duration) {
+	if grace <= 0 {
+		return
+	}
+
+	var (
+		timer  = m.clock.NewTimer(grace)
+		ticker = m.clock.NewTicker(drainPollInterval)
+	)
+
+	defer timer.Stop()
+	defer ticker.Stop()
+
+	for {
+		drained := true
+		for _, d := range devices {
+			if !d.Closed() && (d.Pending() > 0 || d.transactions.Len() > 0) {
+				drained = false
+				break
+			}
+		}
+
+		if drained {
+			return
+		}
+
+		select {
+		case <-timer.C():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (m *manager) DisconnectIf(filter func(ID) bool) int {
+	return m.devices.removeIf(
+		func(d *device) bool {
+			return filter(d.id)
+		},
+		CloseDisconnected,
+	)
+}
+
+func (m *manager) DisconnectIfAsync(filter func(ID) bool) int {
+	var (
+		ids     = m.devices.ids()
+		matched = make([]bool, len(ids))
+		next    = make(chan int, len(ids))
+		workers = disconnectIfAsyncWorkers
+		wg      sync.WaitGroup
+	)
+
+	for i := range ids {
+		next <- i
+	}
+
+	close(next)
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				matched[i] = filter(ids[i])
+			}
+		}()
+	}
+
+	wg.Wait()
+	count := 0
+	for i, id := range ids {
+		if matched[i] {
+			_, removed := m.devices.removeSessions(id, CloseDisconnected)
+			count += removed
+		}
+	}
+
+	return count
 }
 
 func (m *manager) DisconnectAll() int {
-	return m.devices.removeAll()
+	return m.devices.removeAll(CloseDisconnected)
 }
 
 func (m *manager) Len() int {
@@ -469,21 +1535,183 @@ func (m *manager) Len() int {
 }
 
 func (m *manager) Get(id ID) (Interface, bool) {
+	id, err := m.idNormalizer(id)
+	if err != nil {
+		return nil, false
+	}
+
 	return m.devices.get(id)
 }
 
+func (m *manager) Ping(id ID, timeout time.Duration) (time.Duration, error) {
+	id, err := m.idNormalizer(id)
+	if err != nil {
+		return 0, err
+	}
+
+	d, ok := m.devices.get(id)
+	if !ok {
+		return 0, ErrorDeviceNotFound
+	}
+
+	return d.ping(m.clock, m.now, timeout)
+}
+
 func (m *manager) VisitAll(visitor func(Interface) bool) int {
 	return m.devices.visit(func(d *device) bool {
 		return visitor(d)
 	})
 }
 
+func (m *manager) Presence(id ID) (Presence, bool, error) {
+	id, err := m.idNormalizer(id)
+	if err != nil {
+		return Presence{}, false, err
+	}
+
+	return m.devices.presence.Get(id)
+}
+
 func (m *manager) Route(request *Request) (*Response, error) {
 	if destination, err := request.ID(); err != nil {
 		return nil, err
-	} else if d, ok := m.devices.get(destination); ok {
-		return d.Send(request)
+	} else if destination, err = m.idNormalizer(destination); err != nil {
+		return nil, err
+	} else if d, ok := m.devices.route(destination); ok {
+		return m.routeTo(d, request)
 	} else {
 		return nil, ErrorDeviceNotFound
 	}
 }
+
+func (m *manager) RouteToSession(sessionID string, request *Request) (*Response, error) {
+	d, ok := m.devices.getSession(sessionID)
+	if !ok {
+		return nil, ErrorSessionNotFound
+	}
+
+	return m.routeTo(d, request)
+}
+
+// routeTo sends a request to the device selected for it.  A request that is part of a chain first waits for
+// the chain, which it holds until its transaction completes.
+func (m *manager) routeTo(d *device, request *Request) (*Response, error) {
+	if chainID := chainOf(request); len(chainID) > 0 {
+		release, err := d.chains.acquire(request.Context(), chainID)
+		if err != nil {
+			return nil, err
+		}
+
+		defer release()
+	}
+
+	return m.routeUnchained(d, request)
+}
+
+// routeUnchained sends a request to a device without regard to chains, honoring that device's circuit breaker
+func (m *manager) routeUnchained(d *device, request *Request) (*Response, error) {
+	if message, ok := request.Message.(*wrp.Message); ok && m.validateMessages {
+		if err := message.Valid(); err != nil {
+			return nil, &InvalidMessageError{Err: err}
+		}
+	}
+
+	request, err := m.checkSource(d, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, transactional := request.Transactional(); transactional && d.breaker != nil {
+		if err := d.breaker.allow(); err != nil {
+			return nil, err
+		}
+
+		response, err := m.send(d, request)
+		d.breaker.record(err)
+		return response, err
+	}
+
+	return m.send(d, request)
+}
+
+func (m *manager) SendUrgent(id ID, message *wrp.Message) error {
+	id, err := m.idNormalizer(id)
+	if err != nil {
+		return err
+	}
+
+	d, ok := m.devices.route(id)
+	if !ok {
+		return ErrorDeviceNotFound
+	}
+
+	// encoding up front reports a bad message to the caller without disturbing the write pump
+	request := &Request{Message: message, Format: wrp.Msgpack}
+	if err := wrp.NewEncoderBytes(&request.Contents, wrp.Msgpack).Encode(message); err != nil {
+		return err
+	}
+
+	return d.sendUrgent(request)
+}
+
+func (m *manager) SendRaw(id ID, contents []byte, format wrp.Format) (*Response, error) {
+	if len(contents) == 0 {
+		return nil, ErrorMissingContents
+	}
+
+	id, err := m.idNormalizer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := m.devices.route(id)
+	if !ok {
+		return nil, ErrorDeviceNotFound
+	}
+
+	// only the routing fields are decoded, and the write pump sends the contents as they are
+	header := new(rawHeader)
+	if err := wrp.NewDecoderBytes(contents, format).Decode(header); err != nil {
+		return nil, err
+	}
+
+	return m.routeTo(d, &Request{Message: header, Format: format, Contents: contents})
+}
+
+// send delivers a routed request to the given device
+func (m *manager) send(d *device, request *Request) (*Response, error) {
+	if !m.propagateTrace {
+		return d.Send(request)
+	}
+
+	return m.sendTraced(d, request)
+}
+
+// sendTraced sends a request with the trace context of its context injected into the message,
+// and extracts any trace context from the response.  The caller's request and message are not modified.
+func (m *manager) sendTraced(d *device, request *Request) (*Response, error) {
+	if traceParent, ok := wrp.TraceParentFromContext(request.Context()); ok {
+		if message, ok := request.Message.(*wrp.Message); ok {
+			var (
+				tracedRequest = *request
+				tracedMessage = *message
+			)
+
+			if err := tracedMessage.SetTraceParent(traceParent); err != nil {
+				d.errorLog.Log(logging.MessageKey(), "not propagating invalid trace context", "traceparent", traceParent)
+			} else {
+				// the message has changed, so it must be encoded again
+				tracedRequest.Message = &tracedMessage
+				tracedRequest.Contents = nil
+				request = &tracedRequest
+			}
+		}
+	}
+
+	response, err := d.Send(request)
+	if response != nil && response.Message != nil {
+		response.TraceParent, _ = response.Message.TraceParent()
+	}
+
+	return response, err
+}
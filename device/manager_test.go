@@ -1,22 +1,37 @@
 package device
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/Comcast/webpa-common/clock/clocktest"
 	"github.com/Comcast/webpa-common/convey"
 	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
 
 	"github.com/Comcast/webpa-common/logging"
 	"github.com/Comcast/webpa-common/wrp"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/gorilla/websocket"
 	"github.com/justinas/alice"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -54,6 +69,32 @@ func startWebsocketServer(o *Options) (Manager, *httptest.Server, string) {
 	return manager, server, websocketURL.String()
 }
 
+// registerTestDevice registers a long-poll device with a manager created from the given Options, configured as
+// the manager would configure a connecting device.  The device's pumps are not started, so a test can queue messages or negotiate features first.
+func registerTestDevice(t *testing.T, o *Options) (*manager, *device, *longPollConnection) {
+	var (
+		m = NewManager(o).(*manager)
+		d = newDevice(deviceOptions{ID: testDeviceIDs[0], Logger: m.logger})
+		c = newLongPollConnection(m.now)
+	)
+
+	d.outbound = m.outbound
+	if m.sharedPumps {
+		d.pump = new(sharedPump)
+	}
+
+	require.NoError(t, m.devices.add(d))
+	d.conveyClosure = func() {}
+	return m, d, c
+}
+
+// startTestPumps is like registerTestDevice, but also starts the device's pumps
+func startTestPumps(t *testing.T, o *Options) (*manager, *device, *longPollConnection) {
+	m, d, c := registerTestDevice(t, o)
+	m.startPumps(d, c, func() error { return nil })
+	return m, d, c
+}
+
 func connectTestDevices(t *testing.T, dialer Dialer, connectURL string) map[ID]Connection {
 	devices := make(map[ID]Connection, len(testDeviceIDs))
 
@@ -113,6 +154,242 @@ func testManagerConnectUpgradeError(t *testing.T) {
 	device, actualError := manager.Connect(response, request, responseHeader)
 	assert.Nil(device)
 	assert.Error(actualError)
+	assert.Equal(http.StatusMethodNotAllowed, response.Code)
+	assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+	assert.Contains(response.Body.String(), `"code": 405`)
+}
+
+func testManagerConnectCheckOrigin(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		_, server, connectURL = startWebsocketServer(&Options{
+			CheckOrigin: func(r *http.Request) bool {
+				return r.Header.Get("Origin") == "https://allowed.example.com"
+			},
+		})
+
+		dialer = DefaultDialer()
+	)
+
+	defer server.Close()
+
+	_, response, err := dialer.DialDevice(string(testDeviceIDs[0]), connectURL, http.Header{"Origin": []string{"https://evil.example.com"}})
+	assert.Error(err)
+	require.NotNil(response)
+	assert.Equal(http.StatusForbidden, response.StatusCode)
+	assert.Equal("application/json", response.Header.Get("Content-Type"))
+
+	c, _, err := dialer.DialDevice(string(testDeviceIDs[0]), connectURL, http.Header{"Origin": []string{"https://allowed.example.com"}})
+	require.NoError(err)
+	c.Close()
+}
+
+func testManagerConnectRejectDuplicate(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		options = &Options{
+			Logger:          logging.NewTestLogger(nil, t),
+			DuplicatePolicy: DuplicateReject,
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+		dialer                      = DefaultDialer()
+	)
+
+	defer server.Close()
+
+	initial, _, err := dialer.DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer initial.Close()
+
+	// wait for the initial device to be registered
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	duplicate, response, err := dialer.DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	assert.Nil(duplicate)
+	assert.Error(err)
+	require.NotNil(response)
+	assert.Equal(http.StatusConflict, response.StatusCode)
+	assert.Equal(1, manager.Len())
+}
+
+func testManagerConnectRejectCapacity(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		p       = xmetricstest.NewProvider(nil, Metrics)
+
+		m = NewManager(&Options{
+			Logger:          logging.NewTestLogger(nil, t),
+			MaxDevices:      2,
+			PartnerQuotas:   map[string]int{"noisy": 1},
+			MetricsProvider: p,
+		})
+
+		connect = func(id ID, partner string) (*httptest.ResponseRecorder, Interface, error) {
+			request := WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil))
+			if len(partner) > 0 {
+				request.Header.Set(ConveyHeader, base64.StdEncoding.EncodeToString([]byte(`{"partner-id": "`+partner+`"}`)))
+			}
+
+			response := httptest.NewRecorder()
+			lp, err := NewLongPollConnector(m, 0)
+			require.NoError(err)
+
+			d, err := lp.Connect(response, request, nil)
+			return response, d, err
+		}
+	)
+
+	_, d, err := connect(testDeviceIDs[0], "noisy")
+	require.NoError(err)
+	assert.Equal("192.0.2.1", d.(*device).sourceIP)
+
+	response, _, err := connect(testDeviceIDs[1], "noisy")
+	assert.Equal(ErrorPartnerQuotaReached, err)
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+
+	_, _, err = connect(testDeviceIDs[2], "")
+	require.NoError(err)
+
+	_, _, err = connect(testDeviceIDs[3], "")
+	assert.Equal(errDeviceLimitReached, err)
+	_, _, err = connect(IntToMAC(0xABCDEF), "unlisted")
+	assert.Equal(errDeviceLimitReached, err)
+	_, _, err = connect(IntToMAC(0xABCDEE), "another-unlisted")
+	assert.Equal(errDeviceLimitReached, err)
+
+	p.Assert(t, DeviceRejectedCounter, RejectionReasonLabel, PartnerQuotaReason, PartnerLabel, "noisy")(xmetricstest.Value(1.0))
+	p.Assert(t, DeviceRejectedCounter, RejectionReasonLabel, DeviceLimitReason, PartnerLabel, UnknownPartner)(xmetricstest.Value(1.0))
+	p.Assert(t, DeviceRejectedCounter, RejectionReasonLabel, DeviceLimitReason, PartnerLabel, OtherPartner)(xmetricstest.Value(2.0))
+	assert.Equal(2, m.Len())
+
+	m.DisconnectAll()
+}
+
+func testManagerConnectIDFromCert(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		expectedError = errors.New("expected certificate error")
+
+		m = NewManager(&Options{
+			Logger: logging.NewTestLogger(nil, t),
+			IDFromCert: func(state *tls.ConnectionState) (ID, error) {
+				if len(state.PeerCertificates) == 0 {
+					return ID(""), expectedError
+				}
+
+				return ParseID(state.PeerCertificates[0].Subject.CommonName)
+			},
+		})
+
+		connect = func(request *http.Request) (*httptest.ResponseRecorder, Interface, error) {
+			response := httptest.NewRecorder()
+			lp, err := NewLongPollConnector(m, 0)
+			require.NoError(err)
+
+			d, err := lp.Connect(response, request, nil)
+			return response, d, err
+		}
+
+		withCert = func(request *http.Request, commonName string) *http.Request {
+			request.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: commonName}}},
+			}
+
+			return request
+		}
+	)
+
+	// the ID comes from the certificate when there is none in the context
+	_, d, err := connect(withCert(httptest.NewRequest("POST", "https://localhost.com", nil), "MAC:11:22:33:44:55:66"))
+	require.NoError(err)
+	assert.Equal(ID("mac:112233445566"), d.ID())
+
+	// an ID in the context takes precedence over the certificate
+	_, d, err = connect(withCert(WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "https://localhost.com", nil)), "mac:665544332211"))
+	require.NoError(err)
+	assert.Equal(testDeviceIDs[0], d.ID())
+
+	// an error deriving the ID rejects the connection
+	request := httptest.NewRequest("POST", "https://localhost.com", nil)
+	request.TLS = new(tls.ConnectionState)
+	response, d, err := connect(request)
+	assert.Nil(d)
+	assert.Equal(&CertificateIDError{Err: expectedError}, err)
+	assert.Equal(http.StatusForbidden, response.Code)
+
+	// without TLS or a context ID, there is no identity at all
+	response, d, err = connect(httptest.NewRequest("POST", "http://localhost.com", nil))
+	assert.Nil(d)
+	assert.Equal(ErrorMissingDeviceNameContext, err)
+	assert.Equal(http.StatusInternalServerError, response.Code)
+
+	assert.Equal(2, m.Len())
+	m.DisconnectAll()
+}
+
+func testManagerIDNormalizer(t *testing.T) {
+	var (
+		expectedError = errors.New("expected")
+		options       = &Options{
+			Logger: logging.NewTestLogger(nil, t),
+			IDNormalizer: func(id ID) (ID, error) {
+				if id == ID("mac:000000000000") {
+					return id, expectedError
+				}
+
+				return ID(strings.ToLower(string(id))), nil
+			},
+		}
+
+		testManager = NewManager(options)
+	)
+
+	t.Run("Failure", func(t *testing.T) {
+		var (
+			assert   = assert.New(t)
+			response = httptest.NewRecorder()
+			request  = WithIDRequest(ID("mac:000000000000"), httptest.NewRequest("GET", "http://localhost.com", nil))
+		)
+
+		device, err := testManager.Connect(response, request, nil)
+		assert.Nil(device)
+		assert.Equal(expectedError, err)
+		assert.Equal(http.StatusBadRequest, response.Code)
+		assert.Zero(testManager.Len())
+	})
+
+	t.Run("Lookups", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			m       = testManager.(*manager)
+			d       = newDevice(deviceOptions{ID: ID("mac:aabbccddeeff"), Logger: options.Logger})
+		)
+
+		require.NoError(m.devices.add(d))
+
+		actual, ok := testManager.Get(ID("mac:AABBCCDDEEFF"))
+		assert.True(ok)
+		assert.True(actual == d)
+
+		_, ok = testManager.Get(ID("mac:000000000000"))
+		assert.False(ok)
+
+		_, err := testManager.Route(&Request{Message: &wrp.Message{Destination: "mac:000000000000"}})
+		assert.Equal(expectedError, err)
+
+		assert.False(testManager.Disconnect(ID("mac:000000000000")))
+		assert.True(testManager.Disconnect(ID("mac:AABBCCDDEEFF")))
+		assert.True(d.Closed())
+	})
 }
 
 func testManagerConnectVisit(t *testing.T) {
@@ -161,6 +438,121 @@ func testManagerConnectVisit(t *testing.T) {
 	assert.Equal(len(testDeviceIDs), deviceSet.len())
 }
 
+func testManagerPingClock(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		ticks      = make(chan time.Time, 1)
+		pingTicker = new(clocktest.MockTicker)
+		fakeClock  = newManagerClock(DefaultRateWindow)
+		p          = xmetricstest.NewProvider(nil, Metrics)
+
+		options = &Options{
+			Logger:          logging.NewTestLogger(nil, t),
+			PingPeriod:      time.Hour,
+			PingJitter:      -1,
+			MetricsProvider: p,
+			Now:             time.Now,
+			Clock:           fakeClock,
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+	fakeClock.OnNewTicker(time.Hour, pingTicker).Once()
+	pingTicker.OnC((<-chan time.Time)(ticks))
+	pingTicker.OnStop().Once()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d, ok := manager.Get(testDeviceIDs[0])
+	require.True(ok)
+
+	// the fake ticker, not the real clock, drives pings
+	ticks <- time.Now()
+	for i := 0; i < 100 && d.Statistics().MessagesSent() < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p.Assert(t, PingCounter)(xmetricstest.Value(1.0))
+	assert.NoError(connection.Close())
+	assert.Equal(1, manager.DisconnectAll())
+
+	for manager.Len() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fakeClock.AssertExpectations(t)
+}
+
+func testManagerHeartbeat(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		pings           = make(chan time.Time)
+		heartbeats      = make(chan time.Time, 1)
+		pingTicker      = new(clocktest.MockTicker)
+		heartbeatTicker = new(clocktest.MockTicker)
+		fakeClock       = newManagerClock(DefaultRateWindow)
+		received        = make(chan *Event, 1)
+		stopped         = make(chan struct{})
+
+		m, d, c = registerTestDevice(t, &Options{
+			PingPeriod:      time.Hour,
+			PingJitter:      -1,
+			HeartbeatPeriod: time.Minute,
+			Now:             time.Now,
+			Clock:           fakeClock,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == Heartbeat {
+						received <- e
+					}
+				},
+			},
+		})
+	)
+
+	fakeClock.OnNewTicker(time.Hour, pingTicker).Once()
+	fakeClock.OnNewTicker(time.Minute, heartbeatTicker).Once()
+	pingTicker.OnC((<-chan time.Time)(pings))
+	pingTicker.OnStop().Once()
+	heartbeatTicker.OnC((<-chan time.Time)(heartbeats))
+	heartbeatTicker.OnStop().Once().Run(func(mock.Arguments) { close(stopped) })
+
+	d.Statistics().AddMessagesReceived(3)
+	m.startPumps(d, c, func() error { return nil })
+
+	// a quiet device still produces heartbeats
+	heartbeats <- time.Now()
+	select {
+	case e := <-received:
+		assert.True(d == e.Device)
+		assert.Equal(3, e.Statistics.MessagesReceived)
+		assert.Equal(d.Statistics().ConnectedAt(), e.Statistics.ConnectedAt)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no Heartbeat event was dispatched")
+	}
+
+	// disconnection stops the heartbeat ticker
+	assert.Equal(1, m.DisconnectAll())
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the heartbeat ticker was not stopped")
+	}
+
+	fakeClock.AssertExpectations(t)
+	heartbeatTicker.AssertExpectations(t)
+}
+
 func testManagerDisconnect(t *testing.T) {
 	assert := assert.New(t)
 	connectWait := new(sync.WaitGroup)
@@ -207,6 +599,160 @@ func testManagerDisconnect(t *testing.T) {
 	assert.Equal(len(testDeviceIDs), deviceSet.len())
 }
 
+func testManagerDisconnectPartner(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		options = &Options{
+			Logger: logging.NewTestLogger(nil, t),
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+		dialer                      = DefaultDialer()
+
+		partners = map[ID]string{
+			testDeviceIDs[0]: "acme",
+			testDeviceIDs[1]: "acme",
+			testDeviceIDs[2]: "other",
+		}
+
+		connections = make(map[ID]*websocket.Conn, len(partners))
+	)
+
+	defer server.Close()
+
+	for id, partner := range partners {
+		header := http.Header{
+			"X-Webpa-Convey": {base64.StdEncoding.EncodeToString([]byte(`{"partner-id":"` + partner + `"}`))},
+		}
+
+		connection, _, err := dialer.DialDevice(string(id), connectURL, header)
+		require.NoError(err)
+		defer connection.Close()
+		connections[id] = connection
+	}
+
+	for manager.Len() < len(partners) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Zero(manager.DisconnectPartner("", time.Second))
+	assert.Zero(manager.DisconnectPartner("nosuch", time.Second))
+	assert.Equal(2, manager.DisconnectPartner("acme", 100*time.Millisecond))
+	assert.Equal(1, manager.Len())
+
+	_, stillConnected := manager.Get(testDeviceIDs[2])
+	assert.True(stillConnected)
+
+	for _, id := range []ID{testDeviceIDs[0], testDeviceIDs[1]} {
+		_, _, err := connections[id].ReadMessage()
+		closeError, ok := err.(*websocket.CloseError)
+		require.True(ok, "expected a close frame, got %v", err)
+		assert.Equal(PartnerCloseCode, closeError.Code)
+		assert.Equal(PartnerCloseText, closeError.Text)
+	}
+}
+
+// frameRecordingConnection is a Connection that records the type of each frame written to it
+type frameRecordingConnection struct {
+	Connection
+
+	lock  sync.Mutex
+	types []int
+}
+
+func (frc *frameRecordingConnection) WriteMessage(messageType int, data []byte) error {
+	frc.lock.Lock()
+	frc.types = append(frc.types, messageType)
+	frc.lock.Unlock()
+	return frc.Connection.WriteMessage(messageType, data)
+}
+
+func (frc *frameRecordingConnection) written() []int {
+	frc.lock.Lock()
+	defer frc.lock.Unlock()
+	return append([]int(nil), frc.types...)
+}
+
+func testManagerDisconnectPartnerDrainsQueue(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		m  = NewManager(nil).(*manager)
+		d  = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+		lp = newLongPollConnection(m.now)
+		c  = &frameRecordingConnection{Connection: lp}
+
+		sendErrors = make(chan error, 2)
+	)
+
+	require.NoError(m.devices.add(d))
+	for _, payload := range []string{"first", "second"} {
+		go func(payload string) {
+			_, err := d.Send(&Request{
+				Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(d.ID()), Payload: []byte(payload)},
+			})
+
+			sendErrors <- err
+		}(payload)
+	}
+
+	for d.Pending() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the close frame is requested while messages are queued, so they must be written ahead of it
+	require.True(d.requestCodedClose(ClosePartner))
+	d.conveyClosure = func() {}
+	m.startPumps(d, c, func() error { return nil })
+
+	for i := 0; i < 2; i++ {
+		<-lp.outbound
+		assert.NoError(<-sendErrors)
+	}
+
+	for len(c.written()) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal([]int{websocket.BinaryMessage, websocket.BinaryMessage, websocket.CloseMessage}, c.written())
+	m.DisconnectAll()
+}
+
+func testManagerAwaitDrain(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(nil).(*manager)
+		d       = newDevice(deviceOptions{ID: testDeviceIDs[0], Logger: logging.NewTestLogger(nil, t)})
+	)
+
+	start := time.Now()
+	m.awaitDrain([]*device{d}, time.Hour)
+	assert.True(time.Since(start) < time.Hour)
+
+	_, err := d.transactions.Register("pending")
+	require.NoError(err)
+
+	start = time.Now()
+	m.awaitDrain([]*device{d}, 50*time.Millisecond)
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+	d.transactions.Cancel("pending")
+
+	// queued messages also hold up the drain
+	d.messages <- new(envelope)
+	start = time.Now()
+	m.awaitDrain([]*device{d}, 50*time.Millisecond)
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+
+	// a closed device is considered drained, regardless of transactions
+	d.requestClose(CloseDisconnected)
+	start = time.Now()
+	m.awaitDrain([]*device{d}, time.Hour)
+	assert.True(time.Since(start) < time.Hour)
+}
+
 func testManagerDisconnectIf(t *testing.T) {
 	assert := assert.New(t)
 	connectWait := new(sync.WaitGroup)
@@ -259,6 +805,57 @@ func testManagerDisconnectIf(t *testing.T) {
 	}
 }
 
+func testManagerDisconnectIfAsync(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		m       = NewManager(nil).(*manager)
+		devices = make(map[ID]*device, len(testDeviceIDs))
+		late    = newDevice(deviceOptions{ID: IntToMAC(0xABCDEF012345)})
+	)
+
+	assert.Zero(m.DisconnectIfAsync(func(ID) bool { return true }))
+
+	for _, id := range testDeviceIDs {
+		d := newDevice(deviceOptions{ID: id})
+		require.NoError(m.devices.add(d))
+		devices[id] = d
+	}
+
+	var (
+		lock      sync.Mutex
+		evaluated []ID
+	)
+
+	count := m.DisconnectIfAsync(func(candidate ID) bool {
+		// neither registration nor lookups are blocked while the predicate runs
+		if candidate == testDeviceIDs[0] {
+			assert.NoError(m.devices.add(late))
+		}
+
+		_, ok := m.Get(candidate)
+		assert.True(ok)
+
+		lock.Lock()
+		evaluated = append(evaluated, candidate)
+		lock.Unlock()
+
+		return candidate == testDeviceIDs[0] || candidate == testDeviceIDs[2]
+	})
+
+	assert.Equal(2, count)
+	assert.ElementsMatch(testDeviceIDs, evaluated)
+	assert.True(devices[testDeviceIDs[0]].Closed())
+	assert.False(devices[testDeviceIDs[1]].Closed())
+	assert.True(devices[testDeviceIDs[2]].Closed())
+	assert.False(devices[testDeviceIDs[3]].Closed())
+
+	// a device that connected during the scan is never considered
+	assert.False(late.Closed())
+	assert.Equal(3, m.Len())
+}
+
 func testManagerRouteBadDestination(t *testing.T) {
 	var (
 		assert  = assert.New(t)
@@ -293,41 +890,378 @@ func testManagerRouteDeviceNotFound(t *testing.T) {
 	assert.Equal(ErrorDeviceNotFound, err)
 }
 
-func testManagerConnectIncludesConvey(t *testing.T) {
+func testManagerRouteAck(t *testing.T) {
 	var (
-		assert      = assert.New(t)
-		require     = require.New(t)
-		connectWait = new(sync.WaitGroup)
-		contents    = make(chan []byte, 1)
-
+		assert  = assert.New(t)
+		require = require.New(t)
 		options = &Options{
 			Logger: logging.NewTestLogger(nil, t),
-			Listeners: []Listener{
-				func(event *Event) {
-					if event.Type == Connect {
-						defer connectWait.Done()
-						select {
-						case contents <- event.Contents:
-						default:
-							assert.Fail("The connect listener should not block")
-						}
-					}
-				},
-			},
 		}
 
-		_, server, connectURL = startWebsocketServer(options)
+		manager, server, connectURL = startWebsocketServer(options)
 	)
 
 	defer server.Close()
-	connectWait.Add(1)
 
-	dialer := DefaultDialer()
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
 
-	/*
-		Convey header in base 64:
-			{
-				"hw-serial-number":123456789,
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the simulated device acknowledges the first message it receives
+	go func() {
+		_, data, err := connection.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var received wrp.Message
+		if wrp.NewDecoderBytes(data, wrp.Msgpack).Decode(&received) != nil || !received.RequiresAck() {
+			return
+		}
+
+		connection.WriteMessage(
+			websocket.BinaryMessage,
+			wrp.MustEncode(received.Ack(string(testDeviceIDs[0])), wrp.Msgpack),
+		)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	message := &wrp.Message{
+		Type:            wrp.SimpleEventMessageType,
+		Source:          "dns:server.com",
+		Destination:     string(testDeviceIDs[0]),
+		TransactionUUID: "ack-test",
+		Payload:         []byte("fire and forget"),
+	}
+
+	response, err := manager.Route((&Request{Message: message, RequireAck: true}).WithContext(ctx))
+	require.NoError(err)
+	require.NotNil(response)
+	assert.True(response.Ack)
+	require.NotNil(response.Message)
+	assert.Equal("ack-test", response.Message.TransactionUUID)
+	assert.Empty(response.Message.Payload)
+
+	// the caller's message is not modified
+	assert.Nil(message.RequestDeliveryResponse)
+}
+
+func testManagerRouteNotAck(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		require         = require.New(t)
+		m, _, c, events = startReadingPumps(t, &Options{})
+	)
+
+	defer m.DisconnectAll()
+
+	// an event with a transaction UUID and a delivered status is just an event
+	c.inbound <- wrp.MustEncode(
+		(&wrp.Message{
+			Type:            wrp.SimpleEventMessageType,
+			Source:          string(testDeviceIDs[0]),
+			Destination:     "event:test",
+			TransactionUUID: "not-an-ack",
+		}).SetRequestDeliveryResponse(0),
+		wrp.Msgpack,
+	)
+
+	e := <-events
+	assert.Equal(MessageReceived, e.Type)
+
+	// an empty response is a full response, not an ack
+	responses := make(chan *Response, 1)
+	go func() {
+		response, err := m.Route(&Request{
+			Message: &wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Destination:     string(testDeviceIDs[0]),
+				TransactionUUID: "empty-response",
+			},
+		})
+
+		assert.NoError(err)
+		responses <- response
+	}()
+
+	var request wrp.Message
+	require.NoError(wrp.NewDecoderBytes(<-c.outbound, wrp.Msgpack).Decode(&request))
+	c.inbound <- wrp.MustEncode(request.Response(string(testDeviceIDs[0]), 0), wrp.Msgpack)
+
+	response := <-responses
+	require.NotNil(response)
+	assert.False(response.Ack)
+	assert.Equal("empty-response", response.Message.TransactionUUID)
+}
+
+func testManagerRouteTransactionMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		p       = xmetricstest.NewProvider(nil, Metrics)
+		broken  = make(chan *Event, 1)
+		options = &Options{
+			Logger:          logging.NewTestLogger(nil, t),
+			MetricsProvider: p,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == TransactionBroken {
+						broken <- e
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the simulated device answers the first transaction promptly, and the second only after it has timed out
+	late := make(chan struct{})
+	go func() {
+		for i := 0; i < 2; i++ {
+			_, data, err := connection.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var received wrp.Message
+			if wrp.NewDecoderBytes(data, wrp.Msgpack).Decode(&received) != nil {
+				return
+			}
+
+			if i > 0 {
+				<-late
+			}
+
+			connection.WriteMessage(
+				websocket.BinaryMessage,
+				wrp.MustEncode(
+					&wrp.Message{
+						Type:            wrp.SimpleRequestResponseMessageType,
+						Source:          received.Destination,
+						Destination:     received.Source,
+						TransactionUUID: received.TransactionUUID,
+					},
+					wrp.Msgpack,
+				),
+			)
+		}
+	}()
+
+	route := func(transactionUUID string, timeout time.Duration) (*Response, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		return manager.Route(
+			(&Request{
+				Message: &wrp.Message{
+					Type:            wrp.SimpleRequestResponseMessageType,
+					Source:          "dns:server.com",
+					Destination:     string(testDeviceIDs[0]),
+					TransactionUUID: transactionUUID,
+				},
+			}).WithContext(ctx),
+		)
+	}
+
+	response, err := route("answered", 5*time.Second)
+	require.NoError(err)
+	require.NotNil(response)
+
+	response, err = route("timed-out", 50*time.Millisecond)
+	assert.Nil(response)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	close(late)
+	select {
+	case e := <-broken:
+		assert.Equal("timed-out", e.Message.(*wrp.Message).TransactionUUID)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no TransactionBroken event was dispatched")
+	}
+
+	p.Assert(t, TransactionCounter, TransactionOutcomeLabel, TransactionCompleteOutcome)(xmetricstest.Value(1.0))
+	p.Assert(t, TransactionCounter, TransactionOutcomeLabel, TransactionTimeoutOutcome)(xmetricstest.Value(1.0))
+	p.Assert(t, TransactionCounter, TransactionOutcomeLabel, TransactionBrokenOutcome)(xmetricstest.Value(1.0))
+}
+
+func testManagerRouteOnDelivered(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		options = &Options{
+			Logger: logging.NewTestLogger(nil, t),
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	delivered := make(chan error, 1)
+	response, err := manager.Route(
+		&Request{
+			Message: &wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:server.com",
+				Destination: string(testDeviceIDs[0]),
+				Payload:     []byte("fire and forget"),
+			},
+			OnDelivered: func(err error) { delivered <- err },
+		},
+	)
+
+	assert.Nil(response)
+	require.NoError(err)
+
+	select {
+	case err := <-delivered:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("OnDelivered was not invoked")
+	}
+
+	_, data, err := connection.ReadMessage()
+	require.NoError(err)
+
+	var received wrp.Message
+	require.NoError(wrp.NewDecoderBytes(data, wrp.Msgpack).Decode(&received))
+	assert.Equal("fire and forget", string(received.Payload))
+}
+
+func testManagerRouteTraceContext(t *testing.T) {
+	const (
+		callerTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		deviceTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01"
+	)
+
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		options = &Options{
+			Logger:                logging.NewTestLogger(nil, t),
+			PropagateTraceContext: true,
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+		received                    = make(chan string, 1)
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the simulated device responds with its own span
+	go func() {
+		_, data, err := connection.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var request wrp.Message
+		if wrp.NewDecoderBytes(data, wrp.Msgpack).Decode(&request) != nil {
+			return
+		}
+
+		traceParent, _ := request.TraceParent()
+		received <- traceParent
+
+		response := wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          string(testDeviceIDs[0]),
+			Destination:     request.Source,
+			TransactionUUID: request.TransactionUUID,
+		}
+
+		response.SetTraceParent(deviceTraceParent)
+		connection.WriteMessage(websocket.BinaryMessage, wrp.MustEncode(&response, wrp.Msgpack))
+	}()
+
+	ctx, cancel := context.WithTimeout(wrp.WithTraceParent(context.Background(), callerTraceParent), 5*time.Second)
+	defer cancel()
+
+	message := &wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "dns:server.com",
+		Destination:     string(testDeviceIDs[0]),
+		TransactionUUID: "trace-test",
+	}
+
+	response, err := manager.Route((&Request{Message: message}).WithContext(ctx))
+	require.NoError(err)
+	require.NotNil(response)
+	assert.Equal(callerTraceParent, <-received)
+	assert.Equal(deviceTraceParent, response.TraceParent)
+
+	// the caller's message is not modified
+	assert.Empty(message.Headers)
+}
+
+func testManagerConnectIncludesConvey(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		connectWait = new(sync.WaitGroup)
+		contents    = make(chan []byte, 1)
+
+		options = &Options{
+			Logger: logging.NewTestLogger(nil, t),
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == Connect {
+						defer connectWait.Done()
+						select {
+						case contents <- event.Contents:
+						default:
+							assert.Fail("The connect listener should not block")
+						}
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+	connectWait.Add(1)
+
+	dialer := DefaultDialer()
+
+	/*
+		Convey header in base 64:
+			{
+				"hw-serial-number":123456789,
 				"webpa-protocol":"WebPA-1.6"
 			}
 
@@ -356,10 +1290,451 @@ func testManagerConnectIncludesConvey(t *testing.T) {
 	assert.Equal("WebPA-1.6", convey["webpa-protocol"])
 }
 
+// panickingConnection is a Connection whose writes always panic
+type panickingConnection struct {
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (pc *panickingConnection) ReadMessage() (int, []byte, error) {
+	<-pc.closed
+	return 0, nil, io.EOF
+}
+
+func (pc *panickingConnection) SetReadDeadline(time.Time) error   { return nil }
+func (pc *panickingConnection) SetPongHandler(func(string) error) {}
+
+func (pc *panickingConnection) WriteMessage(int, []byte) error {
+	panic("expected")
+}
+
+func (pc *panickingConnection) WritePreparedMessage(*websocket.PreparedMessage) error { return nil }
+func (pc *panickingConnection) SetWriteDeadline(time.Time) error                      { return nil }
+
+func (pc *panickingConnection) Close() error {
+	pc.closeOnce.Do(func() { close(pc.closed) })
+	return nil
+}
+
+// panickingEncoder is a wrp.Encoder that always panics
+type panickingEncoder struct{}
+
+func (panickingEncoder) Encode(interface{}) error { panic(errors.New("expected")) }
+func (panickingEncoder) Reset(io.Writer)          {}
+func (panickingEncoder) ResetBytes(*[]byte)       {}
+
+// panickingDecoder is a wrp.Decoder that always panics
+type panickingDecoder struct{}
+
+func (panickingDecoder) Decode(interface{}) error { panic("expected") }
+func (panickingDecoder) Reset(io.Reader)          {}
+func (panickingDecoder) ResetBytes([]byte)        {}
+
+func testManagerPumpPanic(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		disconnects = make(chan *Event, 1)
+
+		m = NewManager(&Options{
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == Disconnect {
+						disconnects <- e
+					}
+				},
+			},
+		}).(*manager)
+	)
+
+	d, cvy, err := m.newDevice(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "http://localhost.com", nil)))
+	require.NoError(err)
+	require.NoError(m.register(d, cvy))
+	m.startPumps(d, &panickingConnection{closed: make(chan struct{})}, func() error { return nil })
+
+	response, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "test", Destination: "mac:112233445566"}})
+	assert.Nil(response)
+	assert.Error(err)
+
+	select {
+	case e := <-disconnects:
+		assert.True(d == e.Device)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no Disconnect event was dispatched")
+	}
+
+	assert.True(d.Closed())
+	assert.Zero(m.Len())
+}
+
+func testManagerPumpGoroutines(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		p       = xmetricstest.NewProvider(nil, Metrics)
+		m       = NewManager(&Options{Logger: logging.NewTestLogger(nil, t), MetricsProvider: p})
+		gauge   = p.NewGauge(PumpGoroutinesGauge).(xmetrics.Valuer)
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	for _, id := range testDeviceIDs[:2] {
+		_, err := lp.Connect(httptest.NewRecorder(), WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+		require.NoError(err)
+	}
+
+	assert.Equal(4.0, gauge.Value())
+	assert.Equal(2, m.DisconnectAll())
+
+	for i := 0; i < 100 && gauge.Value() > 0.0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Zero(gauge.Value())
+}
+
+func testManagerMessageSize(t *testing.T) {
+	type quantiler interface {
+		Quantile(float64) float64
+	}
+
+	var (
+		assert   = assert.New(t)
+		p        = xmetricstest.NewProvider(nil, Metrics)
+		received = make(chan struct{}, 1)
+
+		m, d, c = startTestPumps(t, &Options{
+			MetricsProvider: p,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived {
+						received <- struct{}{}
+					}
+				},
+			},
+		})
+
+		inbound  = p.NewHistogram(InboundMessageSizeHistogram, len(DefaultMessageSizeBuckets)).(quantiler)
+		outbound = p.NewHistogram(OutboundMessageSizeHistogram, len(DefaultMessageSizeBuckets)).(quantiler)
+	)
+
+	frame := wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: string(d.ID()), Payload: []byte("inbound")}, wrp.Msgpack)
+	c.inbound <- frame
+	<-received
+	assert.Equal(float64(len(frame)), inbound.Quantile(0.5))
+
+	go d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(d.ID()), Payload: []byte("outbound payload")}})
+	written := <-c.outbound
+	assert.Equal(float64(len(written)), outbound.Quantile(0.5))
+
+	m.DisconnectAll()
+}
+
+func testManagerQueueTime(t *testing.T) {
+	type quantiler interface {
+		Quantile(float64) float64
+	}
+
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		p        = xmetricstest.NewProvider(nil, Metrics)
+		enqueued = time.Unix(1000, 0)
+
+		// the write pump services every envelope 2 seconds after it was queued
+		m = NewManager(&Options{
+			MetricsProvider: p,
+			Now:             func() time.Time { return enqueued.Add(2 * time.Second) },
+		}).(*manager)
+
+		d = newDevice(deviceOptions{ID: testDeviceIDs[0], Now: func() time.Time { return enqueued }})
+		c = newLongPollConnection(m.now)
+
+		queueTime = p.NewHistogram(QueueTimeHistogram, len(DefaultQueueTimeBuckets))
+	)
+
+	require.NoError(m.devices.add(d))
+	d.conveyClosure = func() {}
+	m.startPumps(d, c, func() error { return nil })
+
+	expired := &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(d.ID())}
+	expired.SetExpires(enqueued.Add(time.Second))
+	_, err := d.Send(&Request{Message: expired})
+	assert.Equal(ErrorRequestExpired, err)
+	assert.Equal(2.0, queueTime.With(QueueOutcomeLabel, QueueExpiredOutcome).(quantiler).Quantile(0.5))
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(d.ID())}})
+		sent <- err
+	}()
+
+	<-c.outbound
+	require.NoError(<-sent)
+	assert.Equal(2.0, queueTime.With(QueueOutcomeLabel, QueueSentOutcome).(quantiler).Quantile(0.5))
+
+	m.DisconnectAll()
+}
+
+func testManagerReadError(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		closed  = make(chan []interface{}, 1)
+
+		logger = log.LoggerFunc(func(keyvals ...interface{}) error {
+			for i := 0; i+1 < len(keyvals); i += 2 {
+				if keyvals[i] == logging.MessageKey() && keyvals[i+1] == "Closed device connection" {
+					closed <- keyvals
+				}
+			}
+
+			return nil
+		})
+
+		_, _, c = startTestPumps(t, &Options{Logger: logger})
+	)
+
+	require.NoError(c.Close())
+
+	// the read pump closes the device with the error that ended it
+	select {
+	case keyvals := <-closed:
+		var pumpError interface{}
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			if keyvals[i] == "pumpError" {
+				pumpError = keyvals[i+1]
+			}
+		}
+
+		assert.Equal(ErrorConnectionClosed, pumpError)
+	case <-time.After(5 * time.Second):
+		require.Fail("the device connection was never closed")
+	}
+}
+
+func testManagerMalformedFrames(t *testing.T) {
+	var (
+		require  = require.New(t)
+		p        = xmetricstest.NewProvider(nil, Metrics)
+		received = make(chan *wrp.Message, 1)
+
+		m, _, c = startTestPumps(t, &Options{
+			MetricsProvider: p,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived {
+						received <- e.Message.(*wrp.Message)
+					}
+				},
+			},
+		})
+
+		valid = wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "dns:valid", Destination: "event:test"}, wrp.Msgpack)
+	)
+
+	defer m.DisconnectAll()
+
+	c.inbound <- valid[:len(valid)-3]
+	c.inbound <- wrp.MustEncode(map[string]interface{}{"msg_type": 99, "source": "dns:unknown"}, wrp.Msgpack)
+	c.inbound <- wrp.MustEncode(map[string]interface{}{"msg_type": int(wrp.SimpleEventMessageType), "source": 123}, wrp.Msgpack)
+	c.inbound <- wrp.MustEncode([]string{"not", "a", "message"}, wrp.Msgpack)
+	c.inbound <- valid
+
+	// every malformed frame is skipped, and reading continues
+	select {
+	case message := <-received:
+		require.Equal("dns:valid", message.Source)
+	case <-time.After(5 * time.Second):
+		require.Fail("the valid message was never received")
+	}
+
+	p.Assert(t, MalformedMessageCounter, MalformedReasonLabel, TruncatedReason)(xmetricstest.Value(1.0))
+	p.Assert(t, MalformedMessageCounter, MalformedReasonLabel, UnknownTypeReason)(xmetricstest.Value(1.0))
+	p.Assert(t, MalformedMessageCounter, MalformedReasonLabel, FieldTypeReason)(xmetricstest.Value(2.0))
+}
+
+func TestDecodeFramePanic(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = new(wrp.Message)
+		d       = newDevice(deviceOptions{ID: ID("test"), Logger: logging.NewTestLogger(nil, t)})
+	)
+
+	assert.Error(decodeFrame(d, panickingDecoder{}, []byte("data"), message))
+}
+
+func TestWriteEnvelopePanic(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(deviceOptions{ID: ID("test"), Logger: logging.NewTestLogger(nil, t)})
+	)
+
+	assert.Error(writeEnvelope(d, new(panickingConnection), panickingEncoder{}, &envelope{request: &Request{Message: new(wrp.Message)}}, discard.NewHistogram()))
+}
+
+func testManagerRouteExpired(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		now     = time.Unix(1500000000, 0)
+		failed  = make(chan *Event, 1)
+
+		m = NewManager(&Options{
+			Now: func() time.Time { return now },
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageFailed {
+						failed <- e
+					}
+				},
+			},
+		})
+
+		id = testDeviceIDs[0]
+	)
+
+	lp, err := NewLongPollConnector(m, 10*time.Millisecond)
+	require.NoError(err)
+
+	_, err = lp.Connect(httptest.NewRecorder(), WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	require.NoError(err)
+
+	expired := (&wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(id)}).SetExpires(now)
+	response, err := m.Route(&Request{Message: expired})
+	assert.Nil(response)
+	assert.Equal(ErrorRequestExpired, err)
+
+	select {
+	case e := <-failed:
+		assert.True(expired == e.Message)
+		assert.Equal(ErrorRequestExpired, e.Error)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no MessageFailed event was dispatched")
+	}
+
+	// nothing was written, and the pump continues to service messages that have not expired
+	poll := httptest.NewRecorder()
+	lp.ServeHTTP(poll, WithIDRequest(id, httptest.NewRequest("GET", "http://localhost.com", nil)))
+	assert.Equal(http.StatusNoContent, poll.Code)
+
+	routeErrors := make(chan error, 1)
+	go func() {
+		_, err := m.Route(&Request{
+			Message: (&wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(id)}).SetExpires(now.Add(time.Minute)),
+		})
+
+		routeErrors <- err
+	}()
+
+	for poll.Code == http.StatusNoContent {
+		poll = httptest.NewRecorder()
+		lp.ServeHTTP(poll, WithIDRequest(id, httptest.NewRequest("GET", "http://localhost.com", nil)))
+	}
+
+	assert.Equal(http.StatusOK, poll.Code)
+	assert.NoError(<-routeErrors)
+	assert.Equal(1, m.Len())
+	m.DisconnectAll()
+}
+
+func testManagerRouteToSession(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(&Options{DuplicatePolicy: DuplicateAllowBoth}).(*manager)
+
+		first  = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+		second = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+		c1     = newLongPollConnection(m.now)
+		c2     = newLongPollConnection(m.now)
+	)
+
+	for d, c := range map[*device]*longPollConnection{first: c1, second: c2} {
+		require.NoError(m.devices.add(d))
+		d.conveyClosure = func() {}
+		m.startPumps(d, c, func() error { return nil })
+	}
+
+	// the older session would never be selected by Route
+	routeErrors := make(chan error, 1)
+	go func() {
+		_, err := m.RouteToSession(first.SessionID(), &Request{
+			Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(testDeviceIDs[0]), Payload: []byte("first")},
+		})
+
+		routeErrors <- err
+	}()
+
+	var actual wrp.Message
+	require.NoError(wrp.NewDecoderBytes(<-c1.outbound, wrp.Msgpack).Decode(&actual))
+	assert.Equal("first", string(actual.Payload))
+	assert.NoError(<-routeErrors)
+
+	response, err := m.RouteToSession("nosuchsession", &Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType}})
+	assert.Nil(response)
+	assert.Equal(ErrorSessionNotFound, err)
+
+	require.True(m.devices.removeDevice(first, CloseDisconnected))
+	response, err = m.RouteToSession(first.SessionID(), &Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType}})
+	assert.Nil(response)
+	assert.Equal(ErrorSessionNotFound, err)
+
+	m.DisconnectAll()
+}
+
+func testManagerRouteValidateMessages(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m, d, c = startTestPumps(t, &Options{ValidateMessages: true})
+	)
+
+	for field, message := range map[string]*wrp.Message{
+		"Type":            {Type: wrp.MessageType(99), Destination: string(testDeviceIDs[0])},
+		"TransactionUUID": {Type: wrp.SimpleRequestResponseMessageType, Destination: string(testDeviceIDs[0])},
+	} {
+		response, err := m.Route(&Request{Message: message})
+		assert.Nil(response)
+		require.IsType(new(InvalidMessageError), err)
+		assert.Equal(ErrorInvalidMessage, err.(*InvalidMessageError).Cause())
+		assert.Equal(field, err.(*InvalidMessageError).Err.(*wrp.FieldError).Field)
+		assert.Contains(err.Error(), field)
+
+		response, err = m.RouteToSession(d.SessionID(), &Request{Message: message})
+		assert.Nil(response)
+		assert.IsType(new(InvalidMessageError), err)
+	}
+
+	// nothing invalid was enqueued
+	assert.Zero(d.Pending())
+
+	routeErrors := make(chan error, 1)
+	go func() {
+		_, err := m.Route(&Request{
+			Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(testDeviceIDs[0]), Payload: []byte("valid")},
+		})
+
+		routeErrors <- err
+	}()
+
+	var actual wrp.Message
+	require.NoError(wrp.NewDecoderBytes(<-c.outbound, wrp.Msgpack).Decode(&actual))
+	assert.Equal("valid", string(actual.Payload))
+	assert.NoError(<-routeErrors)
+
+	m.DisconnectAll()
+}
+
 func TestManager(t *testing.T) {
 	t.Run("Connect", func(t *testing.T) {
 		t.Run("MissingDeviceContext", testManagerConnectMissingDeviceContext)
 		t.Run("UpgradeError", testManagerConnectUpgradeError)
+		t.Run("CheckOrigin", testManagerConnectCheckOrigin)
+		t.Run("RejectDuplicate", testManagerConnectRejectDuplicate)
+		t.Run("RejectCapacity", testManagerConnectRejectCapacity)
+		t.Run("IDFromCert", testManagerConnectIDFromCert)
 		t.Run("Visit", testManagerConnectVisit)
 		t.Run("IncludesConvey", testManagerConnectIncludesConvey)
 	})
@@ -367,10 +1742,31 @@ func TestManager(t *testing.T) {
 	t.Run("Route", func(t *testing.T) {
 		t.Run("BadDestination", testManagerRouteBadDestination)
 		t.Run("DeviceNotFound", testManagerRouteDeviceNotFound)
+		t.Run("Ack", testManagerRouteAck)
+		t.Run("NotAck", testManagerRouteNotAck)
+		t.Run("TransactionMetrics", testManagerRouteTransactionMetrics)
+		t.Run("OnDelivered", testManagerRouteOnDelivered)
+		t.Run("TraceContext", testManagerRouteTraceContext)
+		t.Run("Expired", testManagerRouteExpired)
+		t.Run("ToSession", testManagerRouteToSession)
+		t.Run("ValidateMessages", testManagerRouteValidateMessages)
 	})
 
+	t.Run("PingClock", testManagerPingClock)
+	t.Run("Heartbeat", testManagerHeartbeat)
+	t.Run("IDNormalizer", testManagerIDNormalizer)
 	t.Run("Disconnect", testManagerDisconnect)
 	t.Run("DisconnectIf", testManagerDisconnectIf)
+	t.Run("DisconnectIfAsync", testManagerDisconnectIfAsync)
+	t.Run("DisconnectPartner", testManagerDisconnectPartner)
+	t.Run("DisconnectPartnerDrainsQueue", testManagerDisconnectPartnerDrainsQueue)
+	t.Run("AwaitDrain", testManagerAwaitDrain)
+	t.Run("PumpPanic", testManagerPumpPanic)
+	t.Run("PumpGoroutines", testManagerPumpGoroutines)
+	t.Run("MessageSize", testManagerMessageSize)
+	t.Run("QueueTime", testManagerQueueTime)
+	t.Run("ReadError", testManagerReadError)
+	t.Run("MalformedFrames", testManagerMalformedFrames)
 }
 
 func TestGaugeCardinality(t *testing.T) {
@@ -393,3 +1789,303 @@ func TestGaugeCardinality(t *testing.T) {
 		m.(*manager).measures.Models.With("neat", "bad").Add(-1)
 	})
 }
+
+// benchmarkManagerConnectBuffers holds b.N connections open at once, so that the reported bytes per
+// operation approximate the memory cost of each connection for the given buffer sizes.
+func benchmarkManagerConnectBuffers(b *testing.B, readBufferSize, writeBufferSize int) {
+	var (
+		manager, server, connectURL = startWebsocketServer(&Options{
+			ReadBufferSize:  readBufferSize,
+			WriteBufferSize: writeBufferSize,
+		})
+
+		dialer      = DefaultDialer()
+		connections = make([]*websocket.Conn, 0, b.N)
+	)
+
+	defer server.Close()
+	defer func() {
+		for _, c := range connections {
+			c.Close()
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, _, err := dialer.DialDevice(string(IntToMAC(uint64(i))), connectURL, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		connections = append(connections, c)
+	}
+
+	for manager.Len() < b.N {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.StopTimer()
+}
+
+func BenchmarkManagerConnectBuffers(b *testing.B) {
+	for _, size := range []int{0, 256, 1024, 4096, 16384} {
+		size := size
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			benchmarkManagerConnectBuffers(b, size, size)
+		})
+	}
+}
+
+func TestManagerDisconnectRacesSend(t *testing.T) {
+	const senders = 8
+
+	for iteration := 0; iteration < 20; iteration++ {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+
+			lock     sync.Mutex
+			terminal = make(map[string][]EventType)
+
+			m = NewManager(&Options{
+				Logger: logging.NewTestLogger(nil, t),
+				Listeners: []Listener{
+					func(e *Event) {
+						if e.Type == MessageSent || e.Type == MessageFailed {
+							lock.Lock()
+							payload := string(e.Message.(*wrp.Message).Payload)
+							terminal[payload] = append(terminal[payload], e.Type)
+							lock.Unlock()
+						}
+					},
+				},
+			}).(*manager)
+
+			d         = newDevice(deviceOptions{ID: testDeviceIDs[0], QueueSize: 2})
+			c         = newLongPollConnection(m.now)
+			closeOnce = new(sync.Once)
+			writeDone = make(chan struct{})
+
+			start      = make(chan struct{})
+			sendErrors = make([]error, senders)
+			sends      = new(sync.WaitGroup)
+		)
+
+		d.conveyClosure = func() {}
+		require.NoError(m.devices.add(d))
+
+		// the write pump is run directly, so that the test knows when its final drain is complete
+		go m.readPump(d, c, closeOnce, nil)
+		go func() {
+			defer close(writeDone)
+			m.writePump(d, c, func() error { return nil }, closeOnce)
+		}()
+
+		sends.Add(senders)
+		for i := 0; i < senders; i++ {
+			go func(i int) {
+				defer sends.Done()
+				<-start
+				_, sendErrors[i] = d.Send(&Request{
+					Message: &wrp.Message{
+						Type:        wrp.SimpleEventMessageType,
+						Destination: string(d.ID()),
+						Payload:     []byte(strconv.Itoa(i)),
+					},
+				})
+			}(i)
+		}
+
+		// nothing reads from the connection until the device is disconnected, so the queue fills and
+		// the remaining senders are blocked when the disconnect happens
+		close(start)
+		for d.Pending() < cap(d.messages) {
+			time.Sleep(time.Millisecond)
+		}
+
+		assert.True(m.Disconnect(d.ID()))
+		go func() {
+			for {
+				select {
+				case <-c.outbound:
+				case <-writeDone:
+					return
+				}
+			}
+		}()
+
+		sends.Wait()
+		<-writeDone
+
+		// nothing may be left behind the final drain, and every message gets at most one terminal event
+		assert.Zero(len(d.messages))
+		assert.Equal(ErrorDeviceClosed, d.enqueue(context.Background(), d.messages, new(envelope)))
+
+		lock.Lock()
+		for i, err := range sendErrors {
+			events := terminal[strconv.Itoa(i)]
+			assert.True(len(events) < 2, "message %d had more than one terminal event: %v", i, events)
+			if err == nil {
+				assert.Equal([]EventType{MessageSent}, events)
+			}
+		}
+
+		lock.Unlock()
+	}
+}
+
+func testManagerWriteFailureTerminalEvents(t *testing.T, batchLimit int) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		lock     sync.Mutex
+		terminal = make(map[string][]EventType)
+
+		m = NewManager(&Options{
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageSent || e.Type == MessageFailed {
+						lock.Lock()
+						payload := string(e.Message.(*wrp.Message).Payload)
+						terminal[payload] = append(terminal[payload], e.Type)
+						lock.Unlock()
+					}
+				},
+			},
+		}).(*manager)
+
+		d             = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+		expectedError = errors.New("expected write error")
+		c             = failingWriteConnection{newLongPollConnection(m.now), expectedError}
+		closeOnce     = new(sync.Once)
+		writeDone     = make(chan struct{})
+		sendErrors    = make(chan error, 2)
+	)
+
+	d.batchLimit = batchLimit
+	d.conveyClosure = func() {}
+	require.NoError(m.devices.add(d))
+
+	// queue the messages before the pumps start, so that a batching device writes them together
+	for _, payload := range []string{"first", "second"} {
+		go func(payload string) {
+			_, err := d.Send(&Request{
+				Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(d.ID()), Payload: []byte(payload)},
+			})
+
+			sendErrors <- err
+		}(payload)
+	}
+
+	for d.Pending() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	go m.readPump(d, c, closeOnce, nil)
+	go func() {
+		defer close(writeDone)
+		m.writePump(d, c, func() error { return nil }, closeOnce)
+	}()
+
+	<-writeDone
+	assert.Error(<-sendErrors)
+	assert.Error(<-sendErrors)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, payload := range []string{"first", "second"} {
+		assert.Equal([]EventType{MessageFailed}, terminal[payload], "message %s", payload)
+	}
+}
+
+func TestManagerWriteFailureTerminalEvents(t *testing.T) {
+	t.Run("Single", func(t *testing.T) { testManagerWriteFailureTerminalEvents(t, 0) })
+	t.Run("Batched", func(t *testing.T) { testManagerWriteFailureTerminalEvents(t, 4) })
+}
+
+// testSendRawPumps starts the pumps for a single device, returning a function that sends raw contents to it
+func testSendRawPumps(t *testing.T) (*manager, *longPollConnection, func([]byte, wrp.Format) <-chan error) {
+	var (
+		m, d, c = startTestPumps(t, nil)
+	)
+
+	return m, c, func(contents []byte, format wrp.Format) <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, err := m.SendRaw(d.ID(), contents, format)
+			result <- err
+		}()
+
+		return result
+	}
+}
+
+func testManagerSendRawMsgpack(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		m, c, sendRaw = testSendRawPumps(t)
+		contents      []byte
+	)
+
+	// a field unknown to wrp.Message would be dropped if the frame were encoded again
+	require.NoError(wrp.NewEncoderBytes(&contents, wrp.Msgpack).Encode(map[string]interface{}{
+		"msg_type":      int64(wrp.SimpleEventMessageType),
+		"dest":          string(testDeviceIDs[0]),
+		"payload":       []byte("raw"),
+		"unknown_field": "preserved",
+	}))
+
+	result := sendRaw(contents, wrp.Msgpack)
+	assert.Equal(contents, <-c.outbound)
+	assert.NoError(<-result)
+
+	m.DisconnectAll()
+}
+
+func testManagerSendRawOtherFormat(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		m, c, sendRaw = testSendRawPumps(t)
+		contents      = wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(testDeviceIDs[0]), Payload: []byte("raw")}, wrp.JSON)
+	)
+
+	// contents in any format are written as supplied, never transcoded
+	result := sendRaw(contents, wrp.JSON)
+	assert.Equal(contents, <-c.outbound)
+	assert.NoError(<-result)
+
+	m.DisconnectAll()
+}
+
+func testManagerSendRawErrors(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		m      = NewManager(nil)
+		frame  = wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType}, wrp.Msgpack)
+	)
+
+	response, err := m.SendRaw(testDeviceIDs[0], nil, wrp.Msgpack)
+	assert.Nil(response)
+	assert.Equal(ErrorMissingContents, err)
+
+	response, err = m.SendRaw(testDeviceIDs[0], frame, wrp.Msgpack)
+	assert.Nil(response)
+	assert.Equal(ErrorDeviceNotFound, err)
+
+	m, _, _ = testSendRawPumps(t)
+	response, err = m.SendRaw(testDeviceIDs[0], []byte("this is not JSON"), wrp.JSON)
+	assert.Nil(response)
+	assert.Error(err)
+
+	m.DisconnectAll()
+}
+
+func TestManagerSendRaw(t *testing.T) {
+	t.Run("Msgpack", testManagerSendRawMsgpack)
+	t.Run("OtherFormat", testManagerSendRawOtherFormat)
+	t.Run("Errors", testManagerSendRawErrors)
+}
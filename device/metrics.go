@@ -1,6 +1,9 @@
 package device
 
 import (
+	"context"
+
+	"github.com/Comcast/webpa-common/wrp"
 	"github.com/Comcast/webpa-common/xmetrics"
 	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/provider"
@@ -15,9 +18,182 @@ const (
 	ConnectCounter            = "connect_count"
 	DisconnectCounter         = "disconnect_count"
 	DeviceLimitReachedCounter = "device_limit_reached_count"
+	DeviceRejectedCounter     = "device_rejected_count"
+	TransactionCounter        = "transaction_count"
 	ModelGauge                = "hardware_model"
+	ReadThroughputGauge       = "read_bytes_per_second"
+	WriteThroughputGauge      = "write_bytes_per_second"
+	DroppedEventCounter       = "dropped_event_count"
+	CircuitOpenedCounter      = "circuit_opened_count"
+	CircuitRejectedCounter    = "circuit_rejected_count"
+	PumpGoroutinesGauge       = "pump_goroutines"
+	OutboundBytesGauge        = "outbound_queued_bytes"
+	MalformedMessageCounter   = "malformed_message_count"
+	FloodDisconnectCounter    = "flood_disconnect_count"
+	ListenerTimeoutCounter    = "listener_timeout_count"
+	DisallowedMessageCounter  = "disallowed_message_count"
+
+	InboundMessageSizeHistogram  = "inbound_message_size_bytes"
+	OutboundMessageSizeHistogram = "outbound_message_size_bytes"
+	LastActivityHistogram        = "device_last_activity_seconds"
+	QueueTimeHistogram           = "device_queue_time_seconds"
+)
+
+const (
+	// RejectionReasonLabel and PartnerLabel are the labels of the DeviceRejectedCounter
+	RejectionReasonLabel = "reason"
+	PartnerLabel         = "partner"
+
+	// DeviceLimitReason and PartnerQuotaReason are the values of RejectionReasonLabel, for devices refused
+	// because of MaxDevices or because of their partner's quota, respectively
+	DeviceLimitReason  = "device_limit"
+	PartnerQuotaReason = "partner_quota"
+
+	// UnknownPartner is the PartnerLabel value for devices whose convey data named no partner
+	UnknownPartner = "unknown"
+
+	// OtherPartner is the PartnerLabel value for devices whose partner is not configured in Options.PartnerQuotas.
+	// Partners are supplied by devices, so only configured partners are labeled by name, which bounds the number
+	// of series a device can create.
+	OtherPartner = "other"
+)
+
+// MessageTypeLabel and PartnerLabel are the labels of the DisallowedMessageCounter.  The MessageTypeLabel value is
+// the wrp.MessageType's FriendlyName, e.g. "ServiceRegistration".
+const (
+	MessageTypeLabel = "message_type"
+
+	// UnknownMessageType is the MessageTypeLabel value for messages whose type is not a wrp.MessageType constant
+	UnknownMessageType = "unknown"
+)
+
+// QueueOutcomeLabel is the single label of the QueueTimeHistogram, recording what became of each message once the
+// write pump took it from the device's queue
+const (
+	QueueOutcomeLabel = "outcome"
+
+	QueueSentOutcome    = "sent"
+	QueueFailedOutcome  = "failed"
+	QueueExpiredOutcome = "expired"
+)
+
+// MalformedReasonLabel is the single label of the MalformedMessageCounter.  A spike in one reason usually points
+// at a single cause, e.g. truncated frames from a firmware release with a framing bug.
+const (
+	MalformedReasonLabel = "reason"
+
+	// TruncatedReason, UnknownTypeReason, and FieldTypeReason correspond to wrp.ErrTruncated, wrp.ErrUnknownType,
+	// and wrp.ErrFieldType, respectively
+	TruncatedReason   = "truncated"
+	UnknownTypeReason = "unknown_type"
+	FieldTypeReason   = "field_type"
+
+	// OversizedBatchReason is used for a wrp.Batch holding more than Options.MaxInboundBatchMessages
+	OversizedBatchReason = "oversized_batch"
+
+	// InvalidUTF8Reason is used for a message rejected by UTF8Reject
+	InvalidUTF8Reason = "invalid_utf8"
+
+	// OtherMalformedReason is used for any other decode failure, such as a panic within the codec
+	OtherMalformedReason = "other"
+)
+
+// TransactionOutcomeLabel is the single label of the TransactionCounter.  Its values are stable, so dashboards
+// may rely on them.  Every transaction registered by a device's Send ends in exactly one of the complete,
+// timeout, cancelled, or closed outcomes, so a fleet's success ratio is:
+//
+//	sum(rate(transaction_count{outcome="complete"}[5m])) / sum(rate(transaction_count{outcome!="broken"}[5m]))
+//
+// The broken outcome is counted separately, once for each response a device sends that matches no pending
+// transaction, which is usually a response that arrived after its transaction timed out.
+const (
+	TransactionOutcomeLabel = "outcome"
+
+	TransactionCompleteOutcome  = "complete"
+	TransactionTimeoutOutcome   = "timeout"
+	TransactionCancelledOutcome = "cancelled"
+	TransactionClosedOutcome    = "closed"
+	TransactionBrokenOutcome    = "broken"
 )
 
+// transactionOutcome maps the result of waiting on a transaction onto its TransactionOutcomeLabel value
+func transactionOutcome(err error) string {
+	switch err {
+	case nil:
+		return TransactionCompleteOutcome
+	case context.DeadlineExceeded:
+		return TransactionTimeoutOutcome
+	case ErrorDeviceClosed:
+		return TransactionClosedOutcome
+	default:
+		return TransactionCancelledOutcome
+	}
+}
+
+// recordTransaction counts a single transaction outcome.  A nil counter is ignored.
+func recordTransaction(c metrics.Counter, outcome string) {
+	if c != nil {
+		c.With(TransactionOutcomeLabel, outcome).Add(1.0)
+	}
+}
+
+// rejectionReason maps a registry error onto the RejectionReasonLabel value for a capacity rejection.  If the
+// error is not a capacity rejection, this function returns false.
+func rejectionReason(err error) (string, bool) {
+	switch err {
+	case errDeviceLimitReached:
+		return DeviceLimitReason, true
+	case ErrorPartnerQuotaReached:
+		return PartnerQuotaReason, true
+	default:
+		return "", false
+	}
+}
+
+// malformedReason maps an error from decoding a device's frame onto its MalformedReasonLabel value
+func malformedReason(err error) string {
+	if err == wrp.ErrBatchTooLarge {
+		return OversizedBatchReason
+	} else if _, ok := err.(invalidUTF8Error); ok {
+		return InvalidUTF8Reason
+	}
+
+	switch wrp.DecodeErrorKind(err) {
+	case wrp.ErrTruncated:
+		return TruncatedReason
+	case wrp.ErrUnknownType:
+		return UnknownTypeReason
+	case wrp.ErrFieldType:
+		return FieldTypeReason
+	default:
+		return OtherMalformedReason
+	}
+}
+
+// partnerLabel returns the PartnerLabel value for a device's partner, given the configured partner quotas
+func partnerLabel(partner string, quotas map[string]int) string {
+	if len(partner) == 0 {
+		return UnknownPartner
+	}
+
+	if _, ok := quotas[partner]; ok {
+		return partner
+	}
+
+	return OtherPartner
+}
+
+// DefaultMessageSizeBuckets are the default upper bounds, in bytes, of the message size histograms.
+// Other boundaries can be configured by defining either histogram in the xmetrics Options, which
+// override the metrics defined by this package.
+var DefaultMessageSizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+// DefaultLastActivityBuckets are the default upper bounds, in seconds, of the LastActivityHistogram
+var DefaultLastActivityBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+// DefaultQueueTimeBuckets are the default upper bounds, in seconds, of the QueueTimeHistogram
+var DefaultQueueTimeBuckets = []float64{0.001, 0.005, 0.025, 0.1, 0.5, 1, 5, 15, 60}
+
 // Metrics is the device module function that adds default device metrics
 func Metrics() []xmetrics.Metric {
 	return []xmetrics.Metric{
@@ -53,11 +229,99 @@ func Metrics() []xmetrics.Metric {
 			Name: DeviceLimitReachedCounter,
 			Type: "counter",
 		},
+		{
+			Name:       DeviceRejectedCounter,
+			Type:       "counter",
+			Help:       "The number of devices refused at connect time because a capacity limit was reached",
+			LabelNames: []string{RejectionReasonLabel, PartnerLabel},
+		},
+		{
+			Name:       TransactionCounter,
+			Type:       "counter",
+			Help:       "The number of device transactions, labeled with how each one ended",
+			LabelNames: []string{TransactionOutcomeLabel},
+		},
 		{
 			Name:       ModelGauge,
 			Type:       "gauge",
 			LabelNames: []string{"model"},
 		},
+		{
+			Name: ReadThroughputGauge,
+			Type: "gauge",
+		},
+		{
+			Name: WriteThroughputGauge,
+			Type: "gauge",
+		},
+		{
+			Name: DroppedEventCounter,
+			Type: "counter",
+		},
+		{
+			Name: CircuitOpenedCounter,
+			Type: "counter",
+		},
+		{
+			Name: CircuitRejectedCounter,
+			Type: "counter",
+		},
+		{
+			Name: PumpGoroutinesGauge,
+			Type: "gauge",
+		},
+		{
+			Name: OutboundBytesGauge,
+			Type: "gauge",
+			Help: "The total bytes of the messages queued for writing across all devices",
+		},
+		{
+			Name:       MalformedMessageCounter,
+			Type:       "counter",
+			Help:       "The number of frames read from devices that could not be decoded or were rejected, labeled with why",
+			LabelNames: []string{MalformedReasonLabel},
+		},
+		{
+			Name: FloodDisconnectCounter,
+			Type: "counter",
+			Help: "The number of devices disconnected for exceeding the maximum inbound message rate",
+		},
+		{
+			Name: ListenerTimeoutCounter,
+			Type: "counter",
+			Help: "The number of times a listener did not return from an event within the listener timeout",
+		},
+		{
+			Name:       DisallowedMessageCounter,
+			Type:       "counter",
+			Help:       "The number of messages dropped because the sending device is not allowed to send that type",
+			LabelNames: []string{MessageTypeLabel, PartnerLabel},
+		},
+		{
+			Name:    InboundMessageSizeHistogram,
+			Type:    xmetrics.HistogramType,
+			Help:    "The size in bytes of each frame read from a device",
+			Buckets: DefaultMessageSizeBuckets,
+		},
+		{
+			Name:    OutboundMessageSizeHistogram,
+			Type:    xmetrics.HistogramType,
+			Help:    "The size in bytes of each frame written to a device",
+			Buckets: DefaultMessageSizeBuckets,
+		},
+		{
+			Name:    LastActivityHistogram,
+			Type:    xmetrics.HistogramType,
+			Help:    "The time in seconds since each connected device last sent or received a message, sampled periodically",
+			Buckets: DefaultLastActivityBuckets,
+		},
+		{
+			Name:       QueueTimeHistogram,
+			Type:       xmetrics.HistogramType,
+			Help:       "The time in seconds each message waited to be taken from a device's queue, labeled with its outcome",
+			Buckets:    DefaultQueueTimeBuckets,
+			LabelNames: []string{QueueOutcomeLabel},
+		},
 	}
 }
 
@@ -72,6 +336,56 @@ type Measures struct {
 	Connect         xmetrics.Incrementer
 	Disconnect      xmetrics.Adder
 	Models          metrics.Gauge
+	ReadThroughput  xmetrics.Setter
+	WriteThroughput xmetrics.Setter
+	DroppedEvents   xmetrics.Incrementer
+	CircuitOpened   xmetrics.Incrementer
+	CircuitRejected xmetrics.Incrementer
+
+	// Rejected counts the devices refused because of MaxDevices or a partner quota, labeled with the
+	// RejectionReasonLabel and the PartnerLabel
+	Rejected metrics.Counter
+
+	// Transactions counts device transactions by outcome, labeled with the TransactionOutcomeLabel
+	Transactions metrics.Counter
+
+	// Malformed counts the frames read from devices that could not be decoded, labeled with the
+	// MalformedReasonLabel
+	Malformed metrics.Counter
+
+	// FloodDisconnect counts the devices disconnected with CloseFlood for sustaining an inbound frame rate
+	// above Options.MaxInboundRate
+	FloodDisconnect xmetrics.Incrementer
+
+	// ListenerTimeout counts the listener calls abandoned for not returning within Options.ListenerTimeout
+	ListenerTimeout xmetrics.Incrementer
+
+	// Disallowed counts the inbound messages dropped by a MessageTypePolicy, labeled with the MessageTypeLabel
+	// and the PartnerLabel
+	Disallowed metrics.Counter
+
+	// PumpGoroutines tracks the read and write pump goroutines that are currently running.  In a healthy
+	// process this is twice the Device gauge, so any drift indicates pumps that failed to exit.
+	PumpGoroutines metrics.Gauge
+
+	// OutboundBytes is the total size of the messages queued for writing across all devices, as bounded
+	// by Options.MaxOutboundBytes
+	OutboundBytes metrics.Gauge
+
+	// InboundMessageSize and OutboundMessageSize observe the length of each binary frame read from or
+	// written to a device.  A batch is observed once, as a single frame.
+	InboundMessageSize  metrics.Histogram
+	OutboundMessageSize metrics.Histogram
+
+	// LastActivity observes, once per Options.ActivitySampleInterval, the time in seconds since each connected
+	// device last sent or received a message.  Every device is observed in every sample, so the increase over
+	// one interval is the distribution of the connected population.
+	LastActivity metrics.Histogram
+
+	// QueueTime observes the time in seconds from when each message was queued for a device until the write pump
+	// took it from the queue, labeled with the QueueOutcomeLabel.  Devices that are slow to drain their queues
+	// show up as a long tail.
+	QueueTime metrics.Histogram
 }
 
 // NewMeasures constructs a Measures given a go-kit metrics Provider
@@ -86,5 +400,23 @@ func NewMeasures(p provider.Provider) Measures {
 		Connect:         xmetrics.NewIncrementer(p.NewCounter(ConnectCounter)),
 		Disconnect:      p.NewCounter(DisconnectCounter),
 		Models:          p.NewGauge(ModelGauge),
+		ReadThroughput:  p.NewGauge(ReadThroughputGauge),
+		WriteThroughput: p.NewGauge(WriteThroughputGauge),
+		DroppedEvents:   xmetrics.NewIncrementer(p.NewCounter(DroppedEventCounter)),
+		CircuitOpened:   xmetrics.NewIncrementer(p.NewCounter(CircuitOpenedCounter)),
+		CircuitRejected: xmetrics.NewIncrementer(p.NewCounter(CircuitRejectedCounter)),
+		PumpGoroutines:  p.NewGauge(PumpGoroutinesGauge),
+		OutboundBytes:   p.NewGauge(OutboundBytesGauge),
+		Rejected:        p.NewCounter(DeviceRejectedCounter),
+		Transactions:    p.NewCounter(TransactionCounter),
+		Malformed:       p.NewCounter(MalformedMessageCounter),
+		FloodDisconnect: xmetrics.NewIncrementer(p.NewCounter(FloodDisconnectCounter)),
+		ListenerTimeout: xmetrics.NewIncrementer(p.NewCounter(ListenerTimeoutCounter)),
+		Disallowed:      p.NewCounter(DisallowedMessageCounter),
+
+		InboundMessageSize:  p.NewHistogram(InboundMessageSizeHistogram, len(DefaultMessageSizeBuckets)),
+		OutboundMessageSize: p.NewHistogram(OutboundMessageSizeHistogram, len(DefaultMessageSizeBuckets)),
+		LastActivity:        p.NewHistogram(LastActivityHistogram, len(DefaultLastActivityBuckets)),
+		QueueTime:           p.NewHistogram(QueueTimeHistogram, len(DefaultQueueTimeBuckets)),
 	}
 }
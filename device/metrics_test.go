@@ -1,9 +1,14 @@
 package device
 
 import (
+	"context"
+	"errors"
+	"io"
 	"testing"
 
+	"github.com/Comcast/webpa-common/wrp"
 	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
 	"github.com/go-kit/kit/metrics/provider"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,16 +23,78 @@ func TestMetrics(t *testing.T) {
 	require.NoError(err)
 	require.NotNil(r)
 
-	for _, gaugeName := range []string{DeviceCounter} {
+	for _, gaugeName := range []string{DeviceCounter, PumpGoroutinesGauge} {
 		gauge := r.NewGauge(gaugeName)
 		gauge.Add(1.0)
 		gauge.Add(-1.0)
 	}
 
-	for _, counterName := range []string{RequestResponseCounter, PingCounter, PongCounter, ConnectCounter, DisconnectCounter} {
+	for _, histogramName := range []string{InboundMessageSizeHistogram, OutboundMessageSizeHistogram} {
+		histogram := r.NewHistogram(histogramName, len(DefaultMessageSizeBuckets))
+		histogram.Observe(512.0)
+	}
+
+	r.NewHistogram(LastActivityHistogram, len(DefaultLastActivityBuckets)).Observe(42.0)
+	r.NewHistogram(QueueTimeHistogram, len(DefaultQueueTimeBuckets)).With(QueueOutcomeLabel, QueueSentOutcome).Observe(0.25)
+
+	for _, counterName := range []string{RequestResponseCounter, PingCounter, PongCounter, ConnectCounter, DisconnectCounter, DroppedEventCounter, CircuitOpenedCounter, CircuitRejectedCounter, FloodDisconnectCounter, ListenerTimeoutCounter} {
 		counter := r.NewCounter(counterName)
 		counter.Add(1.0)
 	}
+
+	r.NewCounter(DeviceRejectedCounter).With(RejectionReasonLabel, DeviceLimitReason, PartnerLabel, UnknownPartner).Add(1.0)
+	r.NewCounter(TransactionCounter).With(TransactionOutcomeLabel, TransactionCompleteOutcome).Add(1.0)
+	r.NewCounter(MalformedMessageCounter).With(MalformedReasonLabel, TruncatedReason).Add(1.0)
+}
+
+func TestTransactionOutcome(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(TransactionCompleteOutcome, transactionOutcome(nil))
+	assert.Equal(TransactionTimeoutOutcome, transactionOutcome(context.DeadlineExceeded))
+	assert.Equal(TransactionCancelledOutcome, transactionOutcome(context.Canceled))
+	assert.Equal(TransactionCancelledOutcome, transactionOutcome(ErrorTransactionCancelled))
+	assert.Equal(TransactionClosedOutcome, transactionOutcome(ErrorDeviceClosed))
+
+	// a nil counter is ignored
+	recordTransaction(nil, TransactionCompleteOutcome)
+
+	p := xmetricstest.NewProvider(nil, Metrics)
+	recordTransaction(p.NewCounter(TransactionCounter), TransactionBrokenOutcome)
+	p.Assert(t, TransactionCounter, TransactionOutcomeLabel, TransactionBrokenOutcome)(xmetricstest.Value(1.0))
+}
+
+func TestRejectionReason(t *testing.T) {
+	assert := assert.New(t)
+
+	reason, ok := rejectionReason(errDeviceLimitReached)
+	assert.Equal(DeviceLimitReason, reason)
+	assert.True(ok)
+
+	reason, ok = rejectionReason(ErrorPartnerQuotaReached)
+	assert.Equal(PartnerQuotaReason, reason)
+	assert.True(ok)
+
+	reason, ok = rejectionReason(ErrorDuplicateDevice)
+	assert.Empty(reason)
+	assert.False(ok)
+
+	assert.Equal(UnknownPartner, partnerLabel("", nil))
+	assert.Equal(UnknownPartner, partnerLabel("", map[string]int{"comcast": 1}))
+	assert.Equal("comcast", partnerLabel("comcast", map[string]int{"comcast": 1}))
+	assert.Equal(OtherPartner, partnerLabel("comcast", nil))
+	assert.Equal(OtherPartner, partnerLabel("attacker-1234", map[string]int{"comcast": 1}))
+}
+
+func TestMalformedReason(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(TruncatedReason, malformedReason(&wrp.DecodeError{Kind: wrp.ErrTruncated, Err: io.ErrUnexpectedEOF}))
+	assert.Equal(TruncatedReason, malformedReason(io.EOF))
+	assert.Equal(UnknownTypeReason, malformedReason(&wrp.DecodeError{Kind: wrp.ErrUnknownType, Err: errors.New("expected")}))
+	assert.Equal(FieldTypeReason, malformedReason(&wrp.DecodeError{Kind: wrp.ErrFieldType, Err: errors.New("expected")}))
+	assert.Equal(OversizedBatchReason, malformedReason(wrp.ErrBatchTooLarge))
+	assert.Equal(OtherMalformedReason, malformedReason(errors.New("expected")))
 }
 
 func TestNewMeasures(t *testing.T) {
@@ -42,4 +109,18 @@ func TestNewMeasures(t *testing.T) {
 	assert.NotNil(m.Pong)
 	assert.NotNil(m.Connect)
 	assert.NotNil(m.Disconnect)
+	assert.NotNil(m.DroppedEvents)
+	assert.NotNil(m.CircuitOpened)
+	assert.NotNil(m.CircuitRejected)
+	assert.NotNil(m.PumpGoroutines)
+	assert.NotNil(m.Rejected)
+	assert.NotNil(m.Transactions)
+	assert.NotNil(m.Malformed)
+	assert.NotNil(m.FloodDisconnect)
+	assert.NotNil(m.ListenerTimeout)
+	assert.NotNil(m.Disallowed)
+	assert.NotNil(m.InboundMessageSize)
+	assert.NotNil(m.OutboundMessageSize)
+	assert.NotNil(m.LastActivity)
+	assert.NotNil(m.QueueTime)
 }
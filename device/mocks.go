@@ -2,6 +2,7 @@ package device
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/Comcast/webpa-common/convey"
 	"github.com/stretchr/testify/mock"
@@ -23,14 +24,26 @@ func (m *MockConnector) Disconnect(id ID) bool {
 	return m.Called(id).Bool(0)
 }
 
+func (m *MockConnector) DisconnectWithReason(id ID, reason CloseReason) bool {
+	return m.Called(id, reason).Bool(0)
+}
+
 func (m *MockConnector) DisconnectIf(predicate func(ID) bool) int {
 	return m.Called(predicate).Int(0)
 }
 
+func (m *MockConnector) DisconnectIfAsync(predicate func(ID) bool) int {
+	return m.Called(predicate).Int(0)
+}
+
 func (m *MockConnector) DisconnectAll() int {
 	return m.Called().Int(0)
 }
 
+func (m *MockConnector) DisconnectPartner(partner string, grace time.Duration) int {
+	return m.Called(partner, grace).Int(0)
+}
+
 type MockRegistry struct {
 	mock.Mock
 }
@@ -68,10 +81,24 @@ func (m *MockDevice) ID() ID {
 	return m.Called().Get(0).(ID)
 }
 
+func (m *MockDevice) SessionID() string {
+	return m.Called().String(0)
+}
+
 func (m *MockDevice) Pending() int {
 	return m.Called().Int(0)
 }
 
+func (m *MockDevice) PendingTransactions() int {
+	return m.Called().Int(0)
+}
+
+func (m *MockDevice) CircuitState() CircuitState {
+	arguments := m.Called()
+	first, _ := arguments.Get(0).(CircuitState)
+	return first
+}
+
 func (m *MockDevice) Close() error {
 	return m.Called().Error(0)
 }
@@ -87,6 +114,18 @@ func (m *MockDevice) Statistics() Statistics {
 	return first
 }
 
+func (m *MockDevice) ReadRate() float64 {
+	arguments := m.Called()
+	first, _ := arguments.Get(0).(float64)
+	return first
+}
+
+func (m *MockDevice) WriteRate() float64 {
+	arguments := m.Called()
+	first, _ := arguments.Get(0).(float64)
+	return first
+}
+
 func (m *MockDevice) Convey() convey.Interface {
 	arguments := m.Called()
 	first, _ := arguments.Get(0).(convey.Interface)
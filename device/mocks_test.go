@@ -1,12 +1,14 @@
 package device
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/Comcast/webpa-common/wrp"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -167,6 +169,35 @@ func (m *mockRouter) Route(request *Request) (*Response, error) {
 	return first, arguments.Error(1)
 }
 
+func (m *mockRouter) RouteToSession(sessionID string, request *Request) (*Response, error) {
+	arguments := m.Called(sessionID, request)
+	first, _ := arguments.Get(0).(*Response)
+	return first, arguments.Error(1)
+}
+
+func (m *mockRouter) SendUrgent(id ID, message *wrp.Message) error {
+	return m.Called(id, message).Error(0)
+}
+
+func (m *mockRouter) SendRaw(id ID, contents []byte, format wrp.Format) (*Response, error) {
+	arguments := m.Called(id, contents, format)
+	first, _ := arguments.Get(0).(*Response)
+	return first, arguments.Error(1)
+}
+
+func (m *mockRouter) Chain(ctx context.Context, chainID string, requests ...*Request) ([]*Response, error) {
+	arguments := m.Called(ctx, chainID, requests)
+	first, _ := arguments.Get(0).([]*Response)
+	return first, arguments.Error(1)
+}
+
+func (m *mockRouter) RouteStream(request *Request) (<-chan *Response, func(), error) {
+	arguments := m.Called(request)
+	first, _ := arguments.Get(0).(<-chan *Response)
+	second, _ := arguments.Get(1).(func())
+	return first, second, arguments.Error(2)
+}
+
 func TestMockConnector(t *testing.T) {
 	var (
 		assert = assert.New(t)
@@ -192,11 +223,14 @@ func TestMockConnector(t *testing.T) {
 	c.On("Connect", response, request, header).Return(expectedDevice, expectedConnectError).Once()
 	c.On("Disconnect", id1).Return(true).Once()
 	c.On("Disconnect", id2).Return(false).Once()
+	c.On("DisconnectWithReason", id1, CloseDrained).Return(true).Once()
 	c.On("DisconnectIf", mock.MatchedBy(func(func(ID) bool) bool { return true })).Return(5).
 		Run(func(arguments mock.Arguments) {
 			arguments.Get(0).(func(ID) bool)(id1)
 		}).Once()
+	c.On("DisconnectIfAsync", mock.MatchedBy(func(func(ID) bool) bool { return true })).Return(7).Once()
 	c.On("DisconnectAll").Return(12).Once()
+	c.On("DisconnectPartner", "partner", time.Second).Return(3).Once()
 
 	actualDevice, actualConnectError := c.Connect(response, request, header)
 	assert.Equal(expectedDevice, actualDevice)
@@ -204,11 +238,14 @@ func TestMockConnector(t *testing.T) {
 
 	assert.True(c.Disconnect(id1))
 	assert.False(c.Disconnect(id2))
+	assert.True(c.DisconnectWithReason(id1, CloseDrained))
 
 	assert.Equal(5, c.DisconnectIf(predicate))
 	assert.True(predicateCalled)
 
+	assert.Equal(7, c.DisconnectIfAsync(predicate))
 	assert.Equal(12, c.DisconnectAll())
+	assert.Equal(3, c.DisconnectPartner("partner", time.Second))
 
 	c.AssertExpectations(t)
 }
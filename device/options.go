@@ -1,8 +1,11 @@
 package device
 
 import (
+	"crypto/tls"
+	"net/http"
 	"time"
 
+	"github.com/Comcast/webpa-common/clock"
 	"github.com/Comcast/webpa-common/logging"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics/provider"
@@ -17,6 +20,10 @@ const (
 	// ConveyHeader is the name of the optional HTTP header which contains the encoded convey JSON.
 	ConveyHeader = "X-Webpa-Convey"
 
+	// PartnerConveyKey is the convey key whose value identifies the partner a device is counted
+	// against for quota purposes.
+	PartnerConveyKey = "partner-id"
+
 	DefaultIdlePeriod     time.Duration = 135 * time.Second
 	DefaultRequestTimeout time.Duration = 30 * time.Second
 	DefaultWriteTimeout   time.Duration = 60 * time.Second
@@ -30,13 +37,142 @@ const (
 // Options represent the available configuration options for components
 // within this package
 type Options struct {
-	// Upgrader is the gorilla websocket.Upgrader injected into these options.
+	// Upgrader is the gorilla websocket.Upgrader injected into these options.  If Upgrader.Error is unset,
+	// failed handshakes are answered with a JSON error body carrying the status gorilla selected, e.g.
+	// 405 for a method other than GET, 400 for missing upgrade headers, or 403 for a disallowed Origin.
 	Upgrader websocket.Upgrader
 
+	// CheckOrigin, if set, overrides Upgrader.CheckOrigin to control the cross-origin policy for device
+	// connections.  If neither is set, gorilla's default policy applies:  any Origin header must match
+	// the request's Host.
+	CheckOrigin func(*http.Request) bool
+
+	// ReadBufferSize and WriteBufferSize, when positive, override the corresponding Upgrader fields.
+	// These buffers are allocated for every connection, so they dominate the per-device memory cost:
+	//
+	//   memory per connection ~= ReadBufferSize + WriteBufferSize (+ a few hundred bytes of frame overhead)
+	//
+	// When a size is 0, gorilla reuses the HTTP server's 4096-byte buffer for that direction.  At 100,000
+	// connections, the defaults therefore cost roughly 100,000 * (4096 + 4096) bytes, or about 800MB, while
+	// 1024-byte buffers cost about 200MB.  Frames larger than a buffer are still supported, at the cost of
+	// more system calls, so workloads of many small frames benefit from smaller buffers.
+	//
+	// The pinned gorilla websocket version does not support a shared WriteBufferPool.
+	ReadBufferSize  int
+	WriteBufferSize int
+
 	// MaxDevices is the maximum number of devices allowed to connect to any one Manager.
 	// If unset (i.e. zero), math.MaxUint32 is used as the maximum.
 	MaxDevices int
 
+	// PartnerQuotas is the per-partner maximum number of devices allowed to connect to any one Manager.
+	// The partner for a device is taken from the PartnerConveyKey value in its convey data.  Partners
+	// not present in this map use DefaultPartnerQuota.  A nonpositive quota means the partner is only
+	// bounded by MaxDevices.
+	PartnerQuotas map[string]int
+
+	// DefaultPartnerQuota is the quota applied to any partner not explicitly present in PartnerQuotas.
+	// If unset (i.e. zero), such partners are only bounded by MaxDevices.
+	DefaultPartnerQuota int
+
+	// MaxConnectionsPerIP is the maximum number of devices allowed to connect to any one Manager from the
+	// same source IP.  Connections over this limit are refused with a 429 and a Retry-After.  Connections
+	// whose source IP cannot be determined share a single limit.  If unset (i.e. zero), there is no per-IP limit.
+	MaxConnectionsPerIP int
+
+	// ForwardedHeader is the optional header, such as X-Forwarded-For, used to determine a connection's
+	// source IP when this Manager sits behind a proxy.  The first address in the header is used.  If unset,
+	// or if a request lacks this header, the request's RemoteAddr is used instead.
+	ForwardedHeader string
+
+	// IPLimitRetryAfter is the Retry-After hint for connections refused due to MaxConnectionsPerIP.
+	// If unset, DefaultIPLimitRetryAfter is used.
+	IPLimitRetryAfter time.Duration
+
+	// CircuitBreakerThreshold is the number of consecutive transaction timeouts after which Route fails fast
+	// for a device with ErrorDeviceCircuitOpen, rather than waiting out the timeout for each request.  The device
+	// stays connected.  If unset (i.e. zero), no circuit breaker is used.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerCooldown is how long a device's circuit stays open before a single probe transaction
+	// is allowed through.  If unset, DefaultCircuitBreakerCooldown is used.
+	CircuitBreakerCooldown time.Duration
+
+	// MaxPendingTransactions bounds the number of transactions awaiting a response from any one device.  Once a
+	// device has this many pending transactions, further transactional requests fail with ErrorTooManyTransactions
+	// rather than being registered, which bounds the memory a device that never responds can consume.  Requests
+	// that are not transactions are unaffected.  Interface.PendingTransactions reports each device's current count,
+	// which is useful for tuning this limit.  If unset (i.e. zero), there is no limit.
+	MaxPendingTransactions int
+
+	// MaxInboundRate is the highest rate, in frames per second, that a websocket device may sustain sending to the
+	// server.  A device that exceeds this rate for the whole InboundRateWindow is disconnected with CloseFlood, which
+	// protects the server from a compromised or buggy device.  Every frame counts, whatever its type.  If unset
+	// (i.e. zero), inbound rates are not limited.
+	MaxInboundRate float64
+
+	// InboundRateWindow is how long a device may exceed MaxInboundRate before it is disconnected, which allows for
+	// bursts.  If unset, DefaultInboundRateWindow is used.
+	InboundRateWindow time.Duration
+
+	// StreamBufferSize is the number of responses buffered for each stream opened with Router.RouteStream.  A stream
+	// whose consumer falls this far behind is ended rather than buffering without bound.  If unset,
+	// DefaultStreamBufferSize is used.
+	StreamBufferSize int
+
+	// ResumptionWindow enables session resumption for websocket devices.  When positive, each connect response
+	// carries a ResumptionTokenHeader, and a session lost to a connection error keeps its pending transactions
+	// open for this long.  A device that reconnects within the window, presenting its last token in the same
+	// header, has those transactions re-associated with the new connection instead of failed.  Sessions closed
+	// deliberately by the server, e.g. via Disconnect or at shutdown, are never resumed.  If unset (i.e. zero),
+	// resumption is disabled.
+	ResumptionWindow time.Duration
+
+	// CreditFlowControl allows devices to opt in to credit-based flow control by sending CreditWindowHeader when
+	// they connect.  For such devices, messages are only written while the device has credits remaining, and each
+	// wrp.CreditGrant the device sends replenishes its credits.  While a device has no credits, messages wait in its
+	// message queue, which is bounded by DeviceMessageQueueSize.  If unset, CreditWindowHeader is ignored.
+	CreditFlowControl bool
+
+	// MaxBatchMessages allows devices to opt in to receiving several messages in a single wrp.Batch frame by sending
+	// BatchLimitHeader when they connect, and is the most messages any batch may hold.  When several messages are
+	// already queued for such a device, the write pump coalesces them, which saves per-frame overhead for bursts of
+	// small messages.  The tradeoffs are that the device must unpack each batch, and that a failed write fails
+	// every message in the batch.  Messages are never delayed waiting for a batch to fill, and transactions,
+	// urgent messages, and passthrough devices are always written one message per frame.  If less than 2,
+	// BatchLimitHeader is ignored and messages are never batched.
+	MaxBatchMessages int
+
+	// MaxBatchBytes bounds the total size of the encoded messages in a single batch.  A message larger than this
+	// is still written, but alone.  If not supplied, DefaultMaxBatchBytes is used.
+	MaxBatchBytes int
+
+	// MaxInboundBatchMessages bounds the number of messages a device may pack into a single wrp.Batch frame.  Each
+	// message in an inbound batch is processed exactly as though it had arrived in its own frame, and a batch that
+	// holds more messages than this is skipped in its entirety as malformed.  Devices may send batches regardless of
+	// MaxBatchMessages.  If not supplied, DefaultMaxInboundBatchMessages is used.
+	MaxInboundBatchMessages int
+
+	// MessageTypePolicy restricts the types of WRP messages each device may send, which limits what a compromised
+	// device can do.  If not supplied, devices may send messages of any type.
+	MessageTypePolicy MessageTypePolicy
+
+	// MaxOutboundBytes caps the total bytes of the messages queued for writing across all devices, which bounds
+	// memory independently of DeviceMessageQueueSize.  A message is counted from the time it is queued until it is
+	// written or fails, by the length of its Contents or, if it has none, its payload.  A send that would exceed the
+	// cap fails with ErrorMemoryPressure.  The OutboundBytesGauge reports the current total regardless of this
+	// setting.  If unset, there is no cap.
+	MaxOutboundBytes int64
+
+	// DuplicatePolicy determines what happens when a device connects with the same ID as a device
+	// that is already connected.  See the DuplicatePolicy constants for the tradeoffs of each mode.
+	// If unset, DuplicateReplace is used.
+	DuplicatePolicy DuplicatePolicy
+
+	// SessionSelection determines which duplicate session Route delivers to when DuplicatePolicy is
+	// DuplicateAllowBoth.  If unset, SelectFirst is used.
+	SessionSelection SessionSelection
+
 	// DeviceMessageQueueSize is the capacity of the channel which stores messages waiting
 	// to be transmitted to a device.  If not supplied, DefaultDeviceMessageQueueSize is used.
 	DeviceMessageQueueSize int
@@ -44,10 +180,39 @@ type Options struct {
 	// PingPeriod is the time between pings sent to each device
 	PingPeriod time.Duration
 
+	// PingJitter is the fraction, between 0 and 1, of PingPeriod by which each device's ping interval may be
+	// randomly shortened, so that devices which connect together do not ping in lockstep.  If not supplied,
+	// DefaultPingJitter is used.  A negative value disables jitter.
+	PingJitter float64
+
+	// HeartbeatPeriod is the time between Heartbeat events dispatched for each device, which lets listeners
+	// tell a quiet device apart from one that is no longer tracked.  If not supplied, no Heartbeat events are
+	// dispatched.
+	HeartbeatPeriod time.Duration
+
 	// IdlePeriod is the length of time a device connection is allowed to be idle,
 	// with no traffic coming from the device.  If not supplied, DefaultIdlePeriod is used.
 	IdlePeriod time.Duration
 
+	// RateWindow is the time window over which the per-device and aggregate byte rates are averaged.  The aggregate
+	// throughput gauges are refreshed ten times per window, so they decay while nothing is transferred.
+	// If not supplied, DefaultRateWindow is used.
+	RateWindow time.Duration
+
+	// MetricsFlushInterval enables batching of the metrics that every pump updates per frame, namely the aggregate
+	// throughput rates and the request/response counter.  When positive, each pump buffers its own deltas and adds
+	// them to the shared metrics at most once per interval, and again when the pump exits.  This greatly reduces
+	// contention at high message rates, at the cost of staleness:  a shared metric lags by up to an interval's
+	// worth of each pump's deltas, and a pump that goes quiet holds its buffered deltas until its next frame or
+	// until it exits.  If unset, metrics are updated immediately.
+	MetricsFlushInterval time.Duration
+
+	// ActivitySampleInterval is how often a Manager observes, for each connected device, the time since the device
+	// last sent or received a message.  The observations feed the LastActivityHistogram, which reveals devices that
+	// are connected but silent, such as half-open connections the idle period has yet to reap.  If unset, no
+	// sampling is done.
+	ActivitySampleInterval time.Duration
+
 	// RequestTimeout is the timeout for all inbound HTTP requests
 	RequestTimeout time.Duration
 
@@ -58,6 +223,38 @@ type Options struct {
 	// Listeners contains the event sinks for managers created using these options
 	Listeners []Listener
 
+	// DispatchMode selects how events are delivered to Listeners.  If not supplied, DispatchInline is used.
+	DispatchMode DispatchMode
+
+	// EventBufferSize is the capacity of each device's event buffer when DispatchMode is DispatchAsync.
+	// If not supplied, DefaultEventBufferSize is used.
+	EventBufferSize int
+
+	// EventOverflow determines what happens when a device's event buffer is full when DispatchMode
+	// is DispatchAsync.  If not supplied, OverflowBlock is used.
+	EventOverflow OverflowPolicy
+
+	// PoolEvents causes each event queued when DispatchMode is DispatchAsync to be copied into an Event from an
+	// EventPool, which is returned to the pool once Listeners have received it.  This isolates queued events from
+	// the code that produced them and reduces allocations, but Listeners must never retain the events they are
+	// given.  See EventPool for the ownership rules.  This option has no effect under DispatchInline.
+	PoolEvents bool
+
+	// ConnectAckTimeout is the maximum time Connect waits for Listeners to receive a device's Connect event
+	// when DispatchMode is DispatchAsync.  If positive, Connect does not return until the event has been
+	// dispatched or this timeout elapses, which adds the latency of every Listener to each connection but
+	// guarantees that Listeners have observed the device before it is used.  If not supplied, Connect returns
+	// as soon as the event is queued.  Inline dispatch is always synchronous, so this has no effect there.
+	ConnectAckTimeout time.Duration
+
+	// ListenerTimeout is the maximum time each Listener may take to return from an event.  A Listener that
+	// exceeds it is abandoned:  a warning is logged, the ListenerTimeoutCounter is incremented, and dispatch moves
+	// on to the next Listener, so a stuck Listener cannot wedge the pump that dispatched the event.  The abandoned
+	// call keeps running on its own goroutine, which leaks for good if the Listener never returns.  Each call
+	// receives a copy of the event, but anything the event refers to may be reused once the Listener is abandoned.
+	// If not supplied, Listeners are called directly with no timeout.
+	ListenerTimeout time.Duration
+
 	// Logger is the output sink for log messages.  If not supplied, log output
 	// is sent to a NOP logger.
 	Logger log.Logger
@@ -65,14 +262,138 @@ type Options struct {
 	// MetricsProvider is the go-kit factory for metrics
 	MetricsProvider provider.Provider
 
-	// Now is the closure used to determine the current time.  If not set, time.Now is used.
+	// Now is the closure used to determine the current time.  If not set, the Clock is used.
 	Now func() time.Time
+
+	// OnEvict is an optional callback invoked whenever a device is evicted from a Manager by a duplicate,
+	// or refused because of a duplicate policy, the device limit, or a partner quota.  It is invoked
+	// synchronously exactly once per eviction, but never while the registry is locked.
+	OnEvict func(Interface, EvictReason)
+
+	// IDNormalizer canonicalizes device IDs.  It is applied to the ID of each connecting device before
+	// registration, and to the IDs used for lookups, routing, and disconnection, so that IDs formatted
+	// inconsistently by different authentication layers still match.  A normalization error rejects
+	// a connection.  If not supplied, IDs are used as is.
+	IDNormalizer func(ID) (ID, error)
+
+	// IDFromCert derives a device's ID from its TLS connection state, typically from the CN or a SAN of the
+	// client certificate presented under mutual TLS.  It is consulted only for connect requests that arrive
+	// over TLS and have no ID in their context, so an ID established by GetID always takes precedence.  An
+	// error from this function rejects the connection.  If not supplied, the ID must be in the request context.
+	// This field is ignored when IDExtractor is set; use IDFromCertificate to compose the same behavior.
+	IDFromCert func(*tls.ConnectionState) (ID, error)
+
+	// IDExtractor establishes the ID of each device from its connect request, and of each long-poll request
+	// for an existing session.  The extracted ID is then subject to IDNormalizer.  If not supplied, the ID is
+	// taken from the request context as established by GetID, falling back to IDFromCert when that is set.
+	IDExtractor IDExtractor
+
+	// PresenceStore is the backend to which device presence is written, typically an external store shared
+	// across instances.  If not supplied, NewMemoryPresenceStore is used.
+	PresenceStore PresenceStore
+
+	// Instance identifies this process within each Presence written to the PresenceStore, such as a hostname.
+	Instance string
+
+	// PropagateTraceContext enables W3C trace context propagation through Route.  When set, a traceparent
+	// stored in a Request's context via wrp.WithTraceParent is injected into the outgoing *wrp.Message,
+	// and any traceparent in the device's response is made available as Response.TraceParent.
+	PropagateTraceContext bool
+
+	// LogSampleRate is the fraction, between 0 and 1, of inbound messages that are logged at the info level once
+	// decoded.  This offers representative samples of device traffic without the cost of logging every frame.
+	// If unset (i.e. zero), no messages are sampled.
+	LogSampleRate float64
+
+	// RedactLogSamples causes sampled messages to be logged via wrp.Message.Redacted, which omits payloads and
+	// the values of headers and metadata.
+	RedactLogSamples bool
+
+	// PassthroughHandler enables passthrough devices, which exchange raw binary frames instead of WRP messages
+	// over the same websocket endpoint.  When set, PassthroughSubprotocol is offered in addition to any
+	// Upgrader.Subprotocols, and each frame read from a device that selects it is passed to this handler
+	// rather than being decoded and dispatched to listeners.  Requests sent to such devices write their
+	// Contents verbatim.  If unset, all devices speak WRP.
+	PassthroughHandler PassthroughHandler
+
+	// AllowLegacyProtocol permits devices to send messages in the legacy wrp.ProtocolV1 layout, either by requesting
+	// LegacySubprotocol, by setting ProtocolVersionConveyKey in their convey data, or by sending ProtocolVersionHeader
+	// when they connect.  Such messages are normalized
+	// to the current layout as they are read, so listeners and transactions are unaffected.  Outbound messages
+	// always use the current layout.  If unset, all devices are assumed to use wrp.ProtocolV2.
+	AllowLegacyProtocol bool
+
+	// AllowJSONFormat permits websocket devices to send messages encoded as wrp.JSON by requesting JSONSubprotocol.
+	// The Format of each Event and Response produced from such a device's messages is wrp.JSON, matching their
+	// Contents.  Outbound messages are always encoded as wrp.Msgpack.  If unset, all devices are assumed to send
+	// wrp.Msgpack.
+	AllowJSONFormat bool
+
+	// ValidateMessages causes Route and RouteToSession to check each request's *wrp.Message with wrp.Message.Valid
+	// before anything is enqueued.  A message which fails is rejected with an *InvalidMessageError.  If unset, messages
+	// are sent as given, which permits experimental message types.
+	ValidateMessages bool
+
+	// SourcePolicy validates the Source locator of each message sent by Route, RouteToSession, and RouteStream,
+	// rejecting or rewriting those that are invalid.  If unset, sources are not checked.
+	SourcePolicy *SourcePolicy
+
+	// QueryHints allows devices to supply connect headers, such as ConveyHeader or BatchLimitHeader, as query
+	// parameters of the connect URL instead.  This suits constrained devices that cannot set custom headers.
+	// If unset, query parameters are ignored.
+	QueryHints *QueryHints
+
+	// SharedPumps reduces the goroutines used by each device connection from two to one, which suits large fleets of
+	// devices that rarely send or receive messages.  Only the read pump runs for the life of a connection, while the
+	// write pump is started whenever there is something to write and exits after lingering for a second without work.
+	// Pings and heartbeats are timer-driven.  Starting a write pump adds a small delay to delivery, so this mode is a
+	// poor fit for busy devices.  If unset, each connection has dedicated read and write pumps.
+	SharedPumps bool
+
+	// UTF8Policy determines what happens to inbound messages with string fields that are not valid UTF-8.  If not
+	// supplied, UTF8Ignore is used.
+	UTF8Policy UTF8Policy
+
+	// Clock is the source of time and tickers used by managers, such as for device pings.
+	// If not set, clock.System() is used.  Tests may inject a fake clock here.
+	Clock clock.Interface
 }
 
 func (o *Options) upgrader() *websocket.Upgrader {
 	upgrader := new(websocket.Upgrader)
 	if o != nil {
 		*upgrader = o.Upgrader
+		if o.ReadBufferSize > 0 {
+			upgrader.ReadBufferSize = o.ReadBufferSize
+		}
+
+		if o.WriteBufferSize > 0 {
+			upgrader.WriteBufferSize = o.WriteBufferSize
+		}
+
+		if o.CheckOrigin != nil {
+			upgrader.CheckOrigin = o.CheckOrigin
+		}
+
+		if o.PassthroughHandler != nil || o.AllowLegacyProtocol || o.AllowJSONFormat {
+			// copy the subprotocols so that the injected Upgrader is never modified
+			upgrader.Subprotocols = append(make([]string, 0, len(o.Upgrader.Subprotocols)+3), o.Upgrader.Subprotocols...)
+			if o.PassthroughHandler != nil {
+				upgrader.Subprotocols = append(upgrader.Subprotocols, PassthroughSubprotocol)
+			}
+
+			if o.AllowLegacyProtocol {
+				upgrader.Subprotocols = append(upgrader.Subprotocols, LegacySubprotocol)
+			}
+
+			if o.AllowJSONFormat {
+				upgrader.Subprotocols = append(upgrader.Subprotocols, JSONSubprotocol)
+			}
+		}
+	}
+
+	if upgrader.Error == nil {
+		upgrader.Error = writeUpgradeError
 	}
 
 	return upgrader
@@ -94,6 +415,110 @@ func (o *Options) maxDevices() int {
 	return 0
 }
 
+func (o *Options) partnerQuotas() map[string]int {
+	if o != nil {
+		return o.PartnerQuotas
+	}
+
+	return nil
+}
+
+func (o *Options) defaultPartnerQuota() int {
+	if o != nil && o.DefaultPartnerQuota > 0 {
+		return o.DefaultPartnerQuota
+	}
+
+	return 0
+}
+
+func (o *Options) maxConnectionsPerIP() int {
+	if o != nil && o.MaxConnectionsPerIP > 0 {
+		return o.MaxConnectionsPerIP
+	}
+
+	return 0
+}
+
+func (o *Options) forwardedHeader() string {
+	if o != nil {
+		return o.ForwardedHeader
+	}
+
+	return ""
+}
+
+func (o *Options) ipLimitRetryAfter() time.Duration {
+	if o != nil && o.IPLimitRetryAfter > 0 {
+		return o.IPLimitRetryAfter
+	}
+
+	return DefaultIPLimitRetryAfter
+}
+
+func (o *Options) circuitBreakerThreshold() int {
+	if o != nil && o.CircuitBreakerThreshold > 0 {
+		return o.CircuitBreakerThreshold
+	}
+
+	return 0
+}
+
+func (o *Options) circuitBreakerCooldown() time.Duration {
+	if o != nil && o.CircuitBreakerCooldown > 0 {
+		return o.CircuitBreakerCooldown
+	}
+
+	return DefaultCircuitBreakerCooldown
+}
+
+func (o *Options) duplicatePolicy() DuplicatePolicy {
+	if o != nil && len(o.DuplicatePolicy) > 0 {
+		return o.DuplicatePolicy
+	}
+
+	return DuplicateReplace
+}
+
+func (o *Options) sessionSelection() SessionSelection {
+	if o != nil && len(o.SessionSelection) > 0 {
+		return o.SessionSelection
+	}
+
+	return SelectFirst
+}
+
+func (o *Options) rateWindow() time.Duration {
+	if o != nil && o.RateWindow > 0 {
+		return o.RateWindow
+	}
+
+	return DefaultRateWindow
+}
+
+func (o *Options) metricsFlushInterval() time.Duration {
+	if o != nil && o.MetricsFlushInterval > 0 {
+		return o.MetricsFlushInterval
+	}
+
+	return 0
+}
+
+func (o *Options) maxOutboundBytes() int64 {
+	if o != nil && o.MaxOutboundBytes > 0 {
+		return o.MaxOutboundBytes
+	}
+
+	return 0
+}
+
+func (o *Options) activitySampleInterval() time.Duration {
+	if o != nil && o.ActivitySampleInterval > 0 {
+		return o.ActivitySampleInterval
+	}
+
+	return 0
+}
+
 func (o *Options) idlePeriod() time.Duration {
 	if o != nil && o.IdlePeriod > 0 {
 		return o.IdlePeriod
@@ -110,6 +535,26 @@ func (o *Options) pingPeriod() time.Duration {
 	return DefaultPingPeriod
 }
 
+func (o *Options) pingJitter() float64 {
+	if o != nil && o.PingJitter != 0 {
+		if o.PingJitter < 0 {
+			return 0.0
+		}
+
+		return o.PingJitter
+	}
+
+	return DefaultPingJitter
+}
+
+func (o *Options) heartbeatPeriod() time.Duration {
+	if o != nil && o.HeartbeatPeriod > 0 {
+		return o.HeartbeatPeriod
+	}
+
+	return 0
+}
+
 func (o *Options) requestTimeout() time.Duration {
 	if o != nil && o.RequestTimeout > 0 {
 		return o.RequestTimeout
@@ -142,6 +587,54 @@ func (o *Options) listeners() []Listener {
 	return nil
 }
 
+func (o *Options) dispatchMode() DispatchMode {
+	if o != nil && len(o.DispatchMode) > 0 {
+		return o.DispatchMode
+	}
+
+	return DispatchInline
+}
+
+func (o *Options) eventBufferSize() int {
+	if o != nil && o.EventBufferSize > 0 {
+		return o.EventBufferSize
+	}
+
+	return DefaultEventBufferSize
+}
+
+func (o *Options) poolEvents() bool {
+	if o != nil {
+		return o.PoolEvents
+	}
+
+	return false
+}
+
+func (o *Options) eventOverflow() OverflowPolicy {
+	if o != nil && len(o.EventOverflow) > 0 {
+		return o.EventOverflow
+	}
+
+	return OverflowBlock
+}
+
+func (o *Options) connectAckTimeout() time.Duration {
+	if o != nil && o.ConnectAckTimeout > 0 {
+		return o.ConnectAckTimeout
+	}
+
+	return 0
+}
+
+func (o *Options) listenerTimeout() time.Duration {
+	if o != nil && o.ListenerTimeout > 0 {
+		return o.ListenerTimeout
+	}
+
+	return 0
+}
+
 func (o *Options) metricsProvider() provider.Provider {
 	if o != nil && o.MetricsProvider != nil {
 		return o.MetricsProvider
@@ -155,5 +648,241 @@ func (o *Options) now() func() time.Time {
 		return o.Now
 	}
 
+	if o != nil && o.Clock != nil {
+		return o.Clock.Now
+	}
+
 	return time.Now
 }
+
+func (o *Options) onEvict() func(Interface, EvictReason) {
+	if o != nil && o.OnEvict != nil {
+		return o.OnEvict
+	}
+
+	return func(Interface, EvictReason) {}
+}
+
+func identityIDNormalizer(id ID) (ID, error) {
+	return id, nil
+}
+
+func (o *Options) idNormalizer() func(ID) (ID, error) {
+	if o != nil && o.IDNormalizer != nil {
+		return o.IDNormalizer
+	}
+
+	return identityIDNormalizer
+}
+
+func (o *Options) idFromCert() func(*tls.ConnectionState) (ID, error) {
+	if o != nil {
+		return o.IDFromCert
+	}
+
+	return nil
+}
+
+func (o *Options) idExtractor() IDExtractor {
+	if o != nil && o.IDExtractor != nil {
+		return o.IDExtractor
+	}
+
+	if idFromCert := o.idFromCert(); idFromCert != nil {
+		return FirstID(IDFromRequest(IDFromContext), IDFromCertificate(idFromCert))
+	}
+
+	return IDFromRequest(IDFromContext)
+}
+
+func (o *Options) presenceStore() PresenceStore {
+	if o != nil && o.PresenceStore != nil {
+		return o.PresenceStore
+	}
+
+	return NewMemoryPresenceStore()
+}
+
+func (o *Options) instance() string {
+	if o != nil {
+		return o.Instance
+	}
+
+	return ""
+}
+
+func (o *Options) propagateTraceContext() bool {
+	if o != nil {
+		return o.PropagateTraceContext
+	}
+
+	return false
+}
+
+func (o *Options) maxPendingTransactions() int {
+	if o != nil && o.MaxPendingTransactions > 0 {
+		return o.MaxPendingTransactions
+	}
+
+	return 0
+}
+
+func (o *Options) maxInboundRate() float64 {
+	if o != nil && o.MaxInboundRate > 0 {
+		return o.MaxInboundRate
+	}
+
+	return 0.0
+}
+
+func (o *Options) inboundRateWindow() time.Duration {
+	if o != nil && o.InboundRateWindow > 0 {
+		return o.InboundRateWindow
+	}
+
+	return DefaultInboundRateWindow
+}
+
+func (o *Options) streamBufferSize() int {
+	if o != nil && o.StreamBufferSize > 0 {
+		return o.StreamBufferSize
+	}
+
+	return DefaultStreamBufferSize
+}
+
+func (o *Options) resumptionWindow() time.Duration {
+	if o != nil && o.ResumptionWindow > 0 {
+		return o.ResumptionWindow
+	}
+
+	return 0
+}
+
+func (o *Options) maxBatchMessages() int {
+	if o != nil && o.MaxBatchMessages > 1 {
+		return o.MaxBatchMessages
+	}
+
+	return 0
+}
+
+func (o *Options) maxBatchBytes() int {
+	if o != nil && o.MaxBatchBytes > 0 {
+		return o.MaxBatchBytes
+	}
+
+	return DefaultMaxBatchBytes
+}
+
+func (o *Options) messageTypePolicy() MessageTypePolicy {
+	if o != nil {
+		return o.MessageTypePolicy
+	}
+
+	return nil
+}
+
+func (o *Options) maxInboundBatchMessages() int {
+	if o != nil && o.MaxInboundBatchMessages > 0 {
+		return o.MaxInboundBatchMessages
+	}
+
+	return DefaultMaxInboundBatchMessages
+}
+
+func (o *Options) creditFlowControl() bool {
+	if o != nil {
+		return o.CreditFlowControl
+	}
+
+	return false
+}
+
+func (o *Options) logSampleRate() float64 {
+	if o != nil && o.LogSampleRate > 0 {
+		return o.LogSampleRate
+	}
+
+	return 0.0
+}
+
+func (o *Options) redactLogSamples() bool {
+	if o != nil {
+		return o.RedactLogSamples
+	}
+
+	return false
+}
+
+func (o *Options) passthroughHandler() PassthroughHandler {
+	if o != nil {
+		return o.PassthroughHandler
+	}
+
+	return nil
+}
+
+func (o *Options) allowLegacyProtocol() bool {
+	if o != nil {
+		return o.AllowLegacyProtocol
+	}
+
+	return false
+}
+
+func (o *Options) allowJSONFormat() bool {
+	if o != nil {
+		return o.AllowJSONFormat
+	}
+
+	return false
+}
+
+func (o *Options) queryHints() *QueryHints {
+	if o != nil {
+		return o.QueryHints
+	}
+
+	return nil
+}
+
+func (o *Options) sharedPumps() bool {
+	if o != nil {
+		return o.SharedPumps
+	}
+
+	return false
+}
+
+func (o *Options) validateMessages() bool {
+	if o != nil {
+		return o.ValidateMessages
+	}
+
+	return false
+}
+
+func (o *Options) sourcePolicy() *SourcePolicy {
+	if o != nil {
+		return o.SourcePolicy.canonical()
+	}
+
+	return nil
+}
+
+func (o *Options) utf8Policy() UTF8Policy {
+	if o != nil && len(o.UTF8Policy) > 0 {
+		return o.UTF8Policy
+	}
+
+	return UTF8Ignore
+}
+
+func (o *Options) clock() clock.Interface {
+	if o != nil && o.Clock != nil {
+		return o.Clock
+	}
+
+	return clock.System()
+}
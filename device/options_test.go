@@ -1,10 +1,16 @@
 package device
 
 import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/Comcast/webpa-common/clock"
+	"github.com/Comcast/webpa-common/clock/clocktest"
 	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
 	"github.com/go-kit/kit/metrics/provider"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
@@ -18,13 +24,68 @@ func TestOptionsDefault(t *testing.T) {
 
 		assert.Equal(DefaultDeviceMessageQueueSize, o.deviceMessageQueueSize())
 		assert.NotNil(o.upgrader())
+		assert.NotNil(o.upgrader().Error)
+		assert.Nil(o.upgrader().CheckOrigin)
 		assert.Equal(0, o.maxDevices())
+		assert.Empty(o.partnerQuotas())
+		assert.Equal(0, o.defaultPartnerQuota())
+		assert.Equal(0, o.maxConnectionsPerIP())
+		assert.Empty(o.forwardedHeader())
+		assert.Equal(DefaultIPLimitRetryAfter, o.ipLimitRetryAfter())
+		assert.Equal(0, o.circuitBreakerThreshold())
+		assert.Equal(DefaultCircuitBreakerCooldown, o.circuitBreakerCooldown())
+		assert.False(o.creditFlowControl())
+		assert.Zero(o.maxBatchMessages())
+		assert.Zero(o.maxPendingTransactions())
+		assert.Zero(o.resumptionWindow())
+		assert.Equal(DefaultStreamBufferSize, o.streamBufferSize())
+		assert.Zero(o.maxInboundRate())
+		assert.Equal(DefaultInboundRateWindow, o.inboundRateWindow())
+		assert.Equal(DefaultMaxBatchBytes, o.maxBatchBytes())
+		assert.Equal(DefaultMaxInboundBatchMessages, o.maxInboundBatchMessages())
+		assert.Nil(o.messageTypePolicy())
+		assert.Zero(o.maxOutboundBytes())
+		assert.Equal(DuplicateReplace, o.duplicatePolicy())
+		assert.Equal(SelectFirst, o.sessionSelection())
 		assert.Equal(DefaultIdlePeriod, o.idlePeriod())
+		assert.Equal(DefaultRateWindow, o.rateWindow())
+		assert.Zero(o.metricsFlushInterval())
+		assert.Zero(o.activitySampleInterval())
 		assert.Equal(DefaultPingPeriod, o.pingPeriod())
+		assert.Equal(DefaultPingJitter, o.pingJitter())
+		assert.Zero(o.heartbeatPeriod())
 		assert.Equal(DefaultWriteTimeout, o.writeTimeout())
 		assert.NotNil(o.logger())
 		assert.Empty(o.listeners())
+		assert.Equal(DispatchInline, o.dispatchMode())
+		assert.Equal(DefaultEventBufferSize, o.eventBufferSize())
+		assert.Equal(OverflowBlock, o.eventOverflow())
+		assert.False(o.poolEvents())
+		assert.Zero(o.connectAckTimeout())
+		assert.Zero(o.listenerTimeout())
 		assert.Equal(provider.NewDiscardProvider(), o.metricsProvider())
+		assert.Equal(clock.System(), o.clock())
+		assert.NotNil(o.onEvict())
+		assert.False(o.propagateTraceContext())
+		assert.Zero(o.logSampleRate())
+		assert.False(o.redactLogSamples())
+		assert.Nil(o.passthroughHandler())
+		assert.False(o.allowLegacyProtocol())
+		assert.False(o.allowJSONFormat())
+		assert.False(o.validateMessages())
+		assert.Nil(o.sourcePolicy())
+		assert.Equal(UTF8Ignore, o.utf8Policy())
+		assert.Nil(o.queryHints())
+		assert.False(o.sharedPumps())
+		assert.Nil(o.idFromCert())
+		assert.NotNil(o.idExtractor())
+		assert.NotNil(o.presenceStore())
+		assert.Empty(o.instance())
+
+		normalized, err := o.idNormalizer()(ID("mac:112233445566"))
+		assert.Equal(ID("mac:112233445566"), normalized)
+		assert.NoError(err)
+		assert.NotNil(o.now())
 	}
 }
 
@@ -33,6 +94,7 @@ func TestOptions(t *testing.T) {
 		assert                  = assert.New(t)
 		expectedLogger          = logging.DefaultLogger()
 		expectedMetricsProvider = provider.NewPrometheusProvider("test", "test")
+		expectedPresenceStore   = NewMemoryPresenceStore()
 
 		o = Options{
 			Upgrader: websocket.Upgrader{
@@ -41,18 +103,58 @@ func TestOptions(t *testing.T) {
 				WriteBufferSize:  DefaultWriteBufferSize + 926,
 				Subprotocols:     []string{"foobar"},
 			},
-			MaxDevices:             20000,
-			DeviceMessageQueueSize: DefaultDeviceMessageQueueSize + 287342,
-			IdlePeriod:             DefaultIdlePeriod + 3472*time.Minute,
-			PingPeriod:             DefaultPingPeriod + 384*time.Millisecond,
-			WriteTimeout:           DefaultWriteTimeout + 327193*time.Second,
-			Logger:                 expectedLogger,
-			Listeners:              []Listener{func(*Event) {}},
-			MetricsProvider:        expectedMetricsProvider,
+			MaxDevices:              20000,
+			PartnerQuotas:           map[string]int{"comcast": 1000},
+			DefaultPartnerQuota:     50,
+			MaxConnectionsPerIP:     5,
+			ForwardedHeader:         "X-Forwarded-For",
+			IPLimitRetryAfter:       17 * time.Second,
+			CircuitBreakerThreshold: 3,
+			CircuitBreakerCooldown:  19 * time.Second,
+			CreditFlowControl:       true,
+			MaxBatchMessages:        16,
+			MaxBatchBytes:           4096,
+			MaxInboundBatchMessages: 25,
+			MessageTypePolicy:       AllowMessageTypes(wrp.SimpleEventMessageType),
+			MaxOutboundBytes:        1 << 20,
+			MaxPendingTransactions:  50,
+			ResumptionWindow:        45 * time.Second,
+			StreamBufferSize:        64,
+			MaxInboundRate:          250.0,
+			InboundRateWindow:       30 * time.Second,
+			DuplicatePolicy:         DuplicateAllowBoth,
+			SessionSelection:        SelectRoundRobin,
+			DeviceMessageQueueSize:  DefaultDeviceMessageQueueSize + 287342,
+			IdlePeriod:              DefaultIdlePeriod + 3472*time.Minute,
+			RateWindow:              DefaultRateWindow + 17*time.Second,
+			MetricsFlushInterval:    250 * time.Millisecond,
+			ActivitySampleInterval:  30 * time.Second,
+			PingPeriod:              DefaultPingPeriod + 384*time.Millisecond,
+			PingJitter:              0.2,
+			HeartbeatPeriod:         5 * time.Minute,
+			WriteTimeout:            DefaultWriteTimeout + 327193*time.Second,
+			Logger:                  expectedLogger,
+			Listeners:               []Listener{func(*Event) {}},
+			DispatchMode:            DispatchAsync,
+			EventBufferSize:         DefaultEventBufferSize + 17,
+			EventOverflow:           OverflowDropOldest,
+			PoolEvents:              true,
+			ConnectAckTimeout:       3 * time.Second,
+			ListenerTimeout:         250 * time.Millisecond,
+			PropagateTraceContext:   true,
+			LogSampleRate:           0.25,
+			RedactLogSamples:        true,
+			PresenceStore:           expectedPresenceStore,
+			Instance:                "instance-1",
+			MetricsProvider:         expectedMetricsProvider,
 		}
 	)
 
 	assert.Equal(o.DeviceMessageQueueSize, o.deviceMessageQueueSize())
+
+	upgrader := o.upgrader()
+	assert.NotNil(upgrader.Error)
+	upgrader.Error = nil
 	assert.Equal(
 		websocket.Upgrader{
 			HandshakeTimeout: 12377123 * time.Second,
@@ -60,14 +162,132 @@ func TestOptions(t *testing.T) {
 			WriteBufferSize:  DefaultWriteBufferSize + 926,
 			Subprotocols:     []string{"foobar"},
 		},
-		*o.upgrader(),
+		*upgrader,
 	)
 
 	assert.Equal(20000, o.maxDevices())
+
+	o.ReadBufferSize = 1024
+	o.WriteBufferSize = 2048
+	assert.Equal(1024, o.upgrader().ReadBufferSize)
+	assert.Equal(2048, o.upgrader().WriteBufferSize)
+	assert.Equal([]string{"foobar"}, o.upgrader().Subprotocols)
+
+	o.PassthroughHandler = func(Interface, []byte) {}
+	assert.NotNil(o.passthroughHandler())
+	assert.Equal([]string{"foobar", PassthroughSubprotocol}, o.upgrader().Subprotocols)
+	assert.Equal([]string{"foobar"}, o.Upgrader.Subprotocols)
+
+	o.AllowLegacyProtocol = true
+	assert.True(o.allowLegacyProtocol())
+
+	o.AllowJSONFormat = true
+	assert.True(o.allowJSONFormat())
+
+	o.ValidateMessages = true
+	assert.True(o.validateMessages())
+
+	o.SourcePolicy = &SourcePolicy{Strict: true, Gateway: "MAC:11:22:33:44:55:66/service"}
+	assert.Equal(&SourcePolicy{Strict: true, Gateway: "mac:112233445566/service"}, o.sourcePolicy())
+	assert.Equal("MAC:11:22:33:44:55:66/service", o.SourcePolicy.Gateway)
+
+	o.SourcePolicy = &SourcePolicy{Gateway: "invalid"}
+	assert.Equal(&SourcePolicy{}, o.sourcePolicy())
+
+	o.UTF8Policy = UTF8Sanitize
+	assert.Equal(UTF8Sanitize, o.utf8Policy())
+
+	hints := &QueryHints{Precedence: QueryPrecedence}
+	o.QueryHints = hints
+	assert.True(hints == o.queryHints())
+
+	o.SharedPumps = true
+	assert.True(o.sharedPumps())
+
+	o.IDFromCert = func(*tls.ConnectionState) (ID, error) { return ID("mac:112233445566"), nil }
+	certID, err := o.idFromCert()(new(tls.ConnectionState))
+	assert.Equal(ID("mac:112233445566"), certID)
+	assert.NoError(err)
+
+	certRequest := httptest.NewRequest("GET", "https://localhost.com", nil)
+	certID, err = o.idExtractor().ExtractID(certRequest)
+	assert.Equal(ID("mac:112233445566"), certID)
+	assert.NoError(err)
+
+	o.IDExtractor = IDFromRequest(IDFromHeader)
+	_, err = o.idExtractor().ExtractID(certRequest)
+	assert.Equal(ErrorMissingDeviceNameHeader, err)
+
+	assert.Equal([]string{"foobar", PassthroughSubprotocol, LegacySubprotocol, JSONSubprotocol}, o.upgrader().Subprotocols)
+
+	o.PassthroughHandler = nil
+	assert.Equal([]string{"foobar", LegacySubprotocol, JSONSubprotocol}, o.upgrader().Subprotocols)
+	assert.Equal([]string{"foobar"}, o.Upgrader.Subprotocols)
+
+	checkOriginCalled := false
+	o.CheckOrigin = func(*http.Request) bool {
+		checkOriginCalled = true
+		return true
+	}
+
+	assert.True(o.upgrader().CheckOrigin(nil))
+	assert.True(checkOriginCalled)
+	assert.Equal(map[string]int{"comcast": 1000}, o.partnerQuotas())
+	assert.Equal(50, o.defaultPartnerQuota())
+	assert.Equal(5, o.maxConnectionsPerIP())
+	assert.Equal("X-Forwarded-For", o.forwardedHeader())
+	assert.Equal(17*time.Second, o.ipLimitRetryAfter())
+	assert.Equal(3, o.circuitBreakerThreshold())
+	assert.Equal(19*time.Second, o.circuitBreakerCooldown())
+	assert.True(o.creditFlowControl())
+	assert.Equal(16, o.maxBatchMessages())
+	assert.Equal(4096, o.maxBatchBytes())
+	assert.Equal(25, o.maxInboundBatchMessages())
+	assert.Equal([]wrp.MessageType{wrp.SimpleEventMessageType}, o.messageTypePolicy()(testDeviceIDs[0], nil))
+	assert.Equal(int64(1<<20), o.maxOutboundBytes())
+	assert.Equal(50, o.maxPendingTransactions())
+	assert.Equal(45*time.Second, o.resumptionWindow())
+	assert.Equal(64, o.streamBufferSize())
+	assert.Equal(250.0, o.maxInboundRate())
+	assert.Equal(30*time.Second, o.inboundRateWindow())
+	assert.Equal(DuplicateAllowBoth, o.duplicatePolicy())
+	assert.Equal(SelectRoundRobin, o.sessionSelection())
 	assert.Equal(o.IdlePeriod, o.idlePeriod())
+	assert.Equal(o.RateWindow, o.rateWindow())
+	assert.Equal(o.MetricsFlushInterval, o.metricsFlushInterval())
+	assert.Equal(30*time.Second, o.activitySampleInterval())
 	assert.Equal(o.PingPeriod, o.pingPeriod())
+	assert.Equal(0.2, o.pingJitter())
+	assert.Zero((&Options{PingJitter: -0.5}).pingJitter())
+	assert.Equal(5*time.Minute, o.heartbeatPeriod())
 	assert.Equal(o.WriteTimeout, o.writeTimeout())
 	assert.Equal(expectedLogger, o.logger())
 	assert.Equal(o.Listeners, o.listeners())
+	assert.Equal(DispatchAsync, o.dispatchMode())
+	assert.Equal(o.EventBufferSize, o.eventBufferSize())
+	assert.Equal(OverflowDropOldest, o.eventOverflow())
+	assert.True(o.poolEvents())
+	assert.Equal(3*time.Second, o.connectAckTimeout())
+	assert.Equal(250*time.Millisecond, o.listenerTimeout())
+	assert.True(o.propagateTraceContext())
+	assert.Equal(0.25, o.logSampleRate())
+	assert.True(o.redactLogSamples())
+	assert.True(expectedPresenceStore == o.presenceStore())
+	assert.Equal("instance-1", o.instance())
 	assert.Equal(expectedMetricsProvider, o.metricsProvider())
 }
+
+func TestOptionsClock(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		expectedNow  = time.Now().Add(-time.Hour)
+		fakeClock    = new(clocktest.Mock)
+		o            = Options{Clock: fakeClock}
+		withExplicit = Options{Clock: fakeClock, Now: func() time.Time { return expectedNow.Add(time.Minute) }}
+	)
+
+	fakeClock.OnNow(expectedNow)
+	assert.Equal(fakeClock, o.clock())
+	assert.Equal(expectedNow, o.now()())
+	assert.Equal(expectedNow.Add(time.Minute), withExplicit.now()())
+}
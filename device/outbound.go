@@ -0,0 +1,75 @@
+package device
+
+import (
+	"sync/atomic"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/go-kit/kit/metrics"
+)
+
+// outboundBytes accounts for the bytes of the messages queued for writing across all of a manager's devices.
+// Bytes are reserved when a message is enqueued and released exactly once, when the message is written, fails,
+// expires, or is drained as undeliverable.  A nil *outboundBytes does no accounting.
+type outboundBytes struct {
+	// max is the ceiling on reserved bytes, which is unbounded if nonpositive
+	max int64
+
+	current int64
+	gauge   metrics.Gauge
+}
+
+func newOutboundBytes(max int64, gauge metrics.Gauge) *outboundBytes {
+	return &outboundBytes{max: max, gauge: gauge}
+}
+
+// reserve accounts for n more queued bytes, returning ErrorMemoryPressure if that would exceed the ceiling
+func (ob *outboundBytes) reserve(n int64) error {
+	if ob == nil || n <= 0 {
+		return nil
+	}
+
+	for {
+		current := atomic.LoadInt64(&ob.current)
+		if ob.max > 0 && current+n > ob.max {
+			return ErrorMemoryPressure
+		}
+
+		if atomic.CompareAndSwapInt64(&ob.current, current, current+n) {
+			ob.gauge.Add(float64(n))
+			return nil
+		}
+	}
+}
+
+// release gives back bytes previously reserved
+func (ob *outboundBytes) release(n int64) {
+	if ob == nil || n <= 0 {
+		return
+	}
+
+	atomic.AddInt64(&ob.current, -n)
+	ob.gauge.Add(float64(-n))
+}
+
+// queued returns the number of bytes currently reserved
+func (ob *outboundBytes) queued() int64 {
+	if ob == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&ob.current)
+}
+
+// requestSize is the number of bytes a queued request is accounted as.  This is the length of its Contents, if
+// supplied, and otherwise the length of its payload, which dominates the size of any message large enough to matter.
+func requestSize(request *Request) int64 {
+	if len(request.Contents) > 0 {
+		return int64(len(request.Contents))
+	}
+
+	if message, ok := request.Message.(*wrp.Message); ok {
+		return int64(len(message.Payload))
+	}
+
+	return 0
+}
@@ -0,0 +1,144 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+func testOutboundBytesNil(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		ob     *outboundBytes
+	)
+
+	assert.NoError(ob.reserve(100))
+	ob.release(100)
+	assert.Zero(ob.queued())
+}
+
+func testOutboundBytesCeiling(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		gauge  = generic.NewGauge("test")
+		ob     = newOutboundBytes(100, gauge)
+	)
+
+	assert.NoError(ob.reserve(60))
+	assert.NoError(ob.reserve(40))
+	assert.Equal(int64(100), ob.queued())
+	assert.Equal(100.0, gauge.Value())
+
+	// a rejected reservation leaves the total untouched
+	assert.Equal(ErrorMemoryPressure, ob.reserve(1))
+	assert.Equal(int64(100), ob.queued())
+
+	// empty messages are never rejected
+	assert.NoError(ob.reserve(0))
+
+	ob.release(60)
+	assert.NoError(ob.reserve(1))
+	assert.Equal(int64(41), ob.queued())
+	assert.Equal(41.0, gauge.Value())
+}
+
+func testOutboundBytesUnbounded(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		gauge  = generic.NewGauge("test")
+		ob     = newOutboundBytes(0, gauge)
+	)
+
+	assert.NoError(ob.reserve(1 << 40))
+	assert.Equal(int64(1<<40), ob.queued())
+	ob.release(1 << 40)
+	assert.Zero(ob.queued())
+	assert.Zero(gauge.Value())
+}
+
+func TestOutboundBytes(t *testing.T) {
+	t.Run("Nil", testOutboundBytesNil)
+	t.Run("Ceiling", testOutboundBytesCeiling)
+	t.Run("Unbounded", testOutboundBytesUnbounded)
+}
+
+func TestRequestSize(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(int64(3), requestSize(&Request{Contents: []byte("abc"), Message: &wrp.Message{Payload: []byte("ignored")}}))
+	assert.Equal(int64(5), requestSize(&Request{Message: &wrp.Message{Payload: []byte("hello")}}))
+	assert.Zero(requestSize(&Request{Message: &wrp.SimpleEvent{Payload: []byte("hello")}}))
+}
+
+func testManagerOutboundBytesWritten(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		m, d, c = registerTestDevice(t, &Options{MaxOutboundBytes: 12})
+	)
+
+	sendErrors := queueMessages(d, "first", "second")
+	assert.Equal(int64(11), m.outbound.queued())
+
+	// a message that would push the total past the ceiling is rejected without being queued
+	_, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Payload: []byte("third")}})
+	assert.Equal(ErrorMemoryPressure, err)
+	assert.Equal(2, d.Pending())
+
+	m.startPumps(d, c, func() error { return nil })
+	for i := 0; i < 2; i++ {
+		<-c.outbound
+		assert.NoError(<-sendErrors)
+	}
+
+	assert.Zero(m.outbound.queued())
+	m.DisconnectAll()
+}
+
+func testManagerOutboundBytesFailed(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		expectedError = errors.New("expected write error")
+
+		m, d, _ = registerTestDevice(t, &Options{MaxOutboundBytes: 100})
+	)
+
+	sendErrors := queueMessages(d, "first", "second", "third")
+	assert.Equal(int64(16), m.outbound.queued())
+
+	// the first message fails to write, and the rest are drained as undeliverable
+	m.startPumps(d, failingWriteConnection{newLongPollConnection(m.now), expectedError}, func() error { return nil })
+	for i := 0; i < 3; i++ {
+		<-sendErrors
+	}
+
+	for m.outbound.queued() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testManagerOutboundBytesExpired(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		m, d, _ = startTestPumps(t, nil)
+	)
+
+	message := (&wrp.Message{Type: wrp.SimpleEventMessageType, Payload: []byte("late")}).SetExpires(time.Now().Add(-time.Minute))
+	_, err := d.Send(&Request{Message: message})
+	assert.Equal(ErrorRequestExpired, err)
+	assert.Zero(m.outbound.queued())
+
+	m.DisconnectAll()
+}
+
+func TestManagerOutboundBytes(t *testing.T) {
+	t.Run("Written", testManagerOutboundBytesWritten)
+	t.Run("Failed", testManagerOutboundBytesFailed)
+	t.Run("Expired", testManagerOutboundBytesExpired)
+}
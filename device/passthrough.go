@@ -0,0 +1,39 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+)
+
+// PassthroughSubprotocol is the websocket subprotocol a device requests at connect time to exchange raw binary
+// frames rather than WRP messages.  The subprotocol is only offered when Options.PassthroughHandler is set.
+const PassthroughSubprotocol = "passthrough"
+
+// PassthroughHandler receives each binary frame read from a passthrough device, exactly as the device sent it.
+// A handler is invoked on the device's read goroutine, so no further frames are read from that device until
+// it returns.  The frame is not reused after the handler returns.
+type PassthroughHandler func(Interface, []byte)
+
+// sendPassthrough queues the raw frame in request.Contents for a passthrough device.  Raw frames carry no
+// WRP semantics, so the request is never treated as a transaction and Format is ignored.  The request's
+// Message, if any, is only used for routing.
+func (d *device) sendPassthrough(request *Request) error {
+	if len(request.Contents) == 0 {
+		return ErrorMissingPassthroughContents
+	} else if request.RequireAck {
+		return ErrorAckNotSupported
+	}
+
+	return d.sendRequest(request)
+}
+
+// passthroughFrame hands a frame read from a passthrough device to the configured handler, converting
+// any panic into a log entry so that a faulty handler cannot take down the read pump.
+func passthroughFrame(d *device, h PassthroughHandler, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.errorLog.Log(logging.MessageKey(), "recovered from panic in passthrough handler", "frameLength", len(data), logging.ErrorKey(), recoveredError(r))
+		}
+	}()
+
+	h(d, data)
+}
@@ -0,0 +1,178 @@
+package device
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPassthroughRaw(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		frames   = make(chan []byte, 1)
+		received = make(chan *Event, 1)
+
+		options = &Options{
+			PassthroughHandler: func(d Interface, frame []byte) {
+				assert.Equal(testDeviceIDs[0], d.ID())
+				frames <- frame
+			},
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived {
+						received <- e
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(
+		string(testDeviceIDs[0]),
+		connectURL,
+		http.Header{"Sec-Websocket-Protocol": []string{PassthroughSubprotocol}},
+	)
+
+	require.NoError(err)
+	defer connection.Close()
+	assert.Equal(PassthroughSubprotocol, connection.Subprotocol())
+
+	// frames from the device reach the handler untouched, even if they are not valid WRP
+	require.NoError(connection.WriteMessage(websocket.BinaryMessage, []byte("legacy inbound")))
+	select {
+	case frame := <-frames:
+		assert.Equal("legacy inbound", string(frame))
+	case <-time.After(5 * time.Second):
+		assert.Fail("the passthrough handler was not invoked")
+	}
+
+	// raw frames are written verbatim, and are never treated as transactions
+	response, err := manager.Route(&Request{
+		Message: &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Destination:     string(testDeviceIDs[0]),
+			TransactionUUID: "ignored",
+		},
+		Format:   wrp.JSON,
+		Contents: []byte("legacy outbound"),
+	})
+
+	assert.Nil(response)
+	assert.NoError(err)
+
+	messageType, data, err := connection.ReadMessage()
+	require.NoError(err)
+	assert.Equal(websocket.BinaryMessage, messageType)
+	assert.Equal("legacy outbound", string(data))
+
+	d, ok := manager.Get(testDeviceIDs[0])
+	require.True(ok)
+
+	response, err = d.Send(&Request{Message: new(wrp.Message)})
+	assert.Nil(response)
+	assert.Equal(ErrorMissingPassthroughContents, err)
+
+	response, err = d.Send(&Request{Contents: []byte("ack"), RequireAck: true})
+	assert.Nil(response)
+	assert.Equal(ErrorAckNotSupported, err)
+
+	select {
+	case e := <-received:
+		assert.Fail("passthrough frames should not be dispatched", "%v", e)
+	default:
+	}
+
+	assert.Equal(1, manager.DisconnectAll())
+}
+
+func testPassthroughWRPDefault(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		frames  = make(chan []byte, 1)
+
+		options = &Options{
+			PassthroughHandler: func(_ Interface, frame []byte) { frames <- frame },
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	// a device that does not request the subprotocol speaks WRP as usual
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+	assert.Empty(connection.Subprotocol())
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = manager.Route(&Request{
+		Message: &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Destination: string(testDeviceIDs[0]),
+			Payload:     []byte("wrp"),
+		},
+	})
+
+	require.NoError(err)
+
+	_, data, err := connection.ReadMessage()
+	require.NoError(err)
+
+	var actual wrp.Message
+	require.NoError(wrp.NewDecoderBytes(data, wrp.Msgpack).Decode(&actual))
+	assert.Equal("wrp", string(actual.Payload))
+	assert.Empty(frames)
+
+	assert.Equal(1, manager.DisconnectAll())
+}
+
+func testPassthroughDisabled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(new(Options))
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(
+		string(testDeviceIDs[0]),
+		connectURL,
+		http.Header{"Sec-Websocket-Protocol": []string{PassthroughSubprotocol}},
+	)
+
+	require.NoError(err)
+	defer connection.Close()
+	assert.Empty(connection.Subprotocol())
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d, ok := manager.Get(testDeviceIDs[0])
+	require.True(ok)
+	assert.False(d.(*device).passthrough)
+	assert.Equal(1, manager.DisconnectAll())
+}
+
+func TestPassthrough(t *testing.T) {
+	t.Run("Raw", testPassthroughRaw)
+	t.Run("WRPDefault", testPassthroughWRPDefault)
+	t.Run("Disabled", testPassthroughDisabled)
+}
@@ -0,0 +1,27 @@
+package device
+
+import "time"
+
+// DefaultPingJitter is the default fraction of the ping period by which each device's ping interval is shortened
+const DefaultPingJitter = 0.1
+
+// jitteredPeriod computes a device's ping interval from the configured period.  The result is the period
+// shortened by a pseudorandom amount, derived from seed, of less than fraction of the period.  Jitter only
+// ever shortens the interval, so devices are never pinged less often than configured, and devices that
+// connect together do not ping together.  A nonpositive fraction disables jitter.
+func jitteredPeriod(period time.Duration, fraction float64, seed uint64) time.Duration {
+	if !(fraction > 0) {
+		return period
+	}
+
+	if fraction > 1 {
+		fraction = 1
+	}
+
+	window := uint64(fraction * float64(period))
+	if window == 0 {
+		return period
+	}
+
+	return period - time.Duration(seed%window)
+}
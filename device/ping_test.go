@@ -0,0 +1,85 @@
+package device
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/clock/clocktest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestJitteredPeriod(t *testing.T) {
+	for _, record := range []struct {
+		fraction float64
+		seed     uint64
+		expected time.Duration
+	}{
+		{0.0, 12345, time.Minute},
+		{-1.0, 12345, time.Minute},
+		{0.1, 0, time.Minute},
+		{0.1, uint64(time.Second), time.Minute - time.Second},
+		{0.1, uint64(6*time.Second) + 17, time.Minute - 17},
+		{1.0, uint64(time.Minute) - 1, 1},
+		{5.0, uint64(time.Minute) + 3, time.Minute - 3},
+		{1e-12, 12345, time.Minute},
+	} {
+		t.Run(strconv.FormatFloat(record.fraction, 'g', -1, 64), func(t *testing.T) {
+			assert.Equal(t, record.expected, jitteredPeriod(time.Minute, record.fraction, record.seed))
+		})
+	}
+}
+
+func TestJitteredPeriodSpread(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		seeds   = &sampleSeeds{next: 1}
+		periods = make(map[time.Duration]bool)
+	)
+
+	for i := 0; i < 100; i++ {
+		period := jitteredPeriod(time.Minute, 0.1, seeds.seed())
+		assert.True(period > time.Minute-6*time.Second)
+		assert.True(period <= time.Minute)
+		periods[period] = true
+	}
+
+	// devices do not share a ping interval
+	assert.True(len(periods) > 90)
+}
+
+func TestManagerPingJitter(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		pingTicker = new(clocktest.MockTicker)
+		fakeClock  = newManagerClock(DefaultRateWindow)
+		stopped    = make(chan struct{})
+
+		m, d, c = registerTestDevice(t, &Options{
+			PingPeriod: time.Hour,
+			Now:        time.Now,
+			Clock:      fakeClock,
+		})
+	)
+
+	// the write pump's ticker uses the jittered period, within DefaultPingJitter of PingPeriod
+	fakeClock.On("NewTicker", mock.MatchedBy(func(period time.Duration) bool {
+		return period > time.Hour-6*time.Minute && period <= time.Hour
+	})).Return(pingTicker).Once()
+
+	pingTicker.OnC((<-chan time.Time)(make(chan time.Time)))
+	pingTicker.OnStop().Once().Run(func(mock.Arguments) { close(stopped) })
+
+	m.startPumps(d, c, func() error { return nil })
+
+	assert.Equal(1, m.DisconnectAll())
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the ping ticker was not stopped")
+	}
+
+	fakeClock.AssertExpectations(t)
+	pingTicker.AssertExpectations(t)
+}
@@ -0,0 +1,109 @@
+package device
+
+import (
+	"sync"
+	"time"
+)
+
+// Presence is the routing hint recorded for a connected device.  It describes where a device is connected,
+// but not the connection itself, which always remains local to the process that owns it.
+type Presence struct {
+	// ID is the device's identifier
+	ID ID
+
+	// Instance identifies the process that holds the device's connection, as given by Options.Instance
+	Instance string
+
+	// Partner is the tenant the device belongs to, which may be empty
+	Partner string
+
+	// ConnectedAt is when the device's current connection was established
+	ConnectedAt time.Time
+}
+
+// PresenceStore is a pluggable backend for device presence.  A Manager keeps its local registry as the source
+// of truth for connections, and writes through to its PresenceStore so that an external, shared store can answer
+// cross-instance lookups such as which instance a device is connected to.
+//
+// A PresenceStore holds at most one Presence per device ID.  When duplicate sessions are allowed, the presence
+// reflects the session currently selected for routing.  Implementations must be safe for concurrent use.
+type PresenceStore interface {
+	// Add records the given presence, replacing any existing presence with the same ID
+	Add(Presence) error
+
+	// Remove deletes any presence with the given ID.  Removing an absent ID is not an error.
+	Remove(ID) error
+
+	// Get returns the presence for the given ID, if one exists
+	Get(ID) (Presence, bool, error)
+
+	// Visit applies the given function to each presence, stopping when the function returns false.
+	// The count of presences visited is returned.
+	Visit(func(Presence) bool) (int, error)
+
+	// Count returns the number of presences in this store
+	Count() (int, error)
+}
+
+// PresenceLocator is implemented by Managers to expose device presence lookups
+type PresenceLocator interface {
+	// Presence returns the presence hint for the given device ID from the Manager's PresenceStore
+	Presence(ID) (Presence, bool, error)
+}
+
+// memoryPresenceStore is the default, process-local PresenceStore
+type memoryPresenceStore struct {
+	lock      sync.RWMutex
+	presences map[ID]Presence
+}
+
+// NewMemoryPresenceStore returns an in-memory PresenceStore.  This is the default used by Managers, and
+// is suitable for a single instance or for tests.
+func NewMemoryPresenceStore() PresenceStore {
+	return &memoryPresenceStore{
+		presences: make(map[ID]Presence),
+	}
+}
+
+func (mps *memoryPresenceStore) Add(p Presence) error {
+	mps.lock.Lock()
+	mps.presences[p.ID] = p
+	mps.lock.Unlock()
+	return nil
+}
+
+func (mps *memoryPresenceStore) Remove(id ID) error {
+	mps.lock.Lock()
+	delete(mps.presences, id)
+	mps.lock.Unlock()
+	return nil
+}
+
+func (mps *memoryPresenceStore) Get(id ID) (Presence, bool, error) {
+	mps.lock.RLock()
+	p, ok := mps.presences[id]
+	mps.lock.RUnlock()
+	return p, ok, nil
+}
+
+func (mps *memoryPresenceStore) Visit(f func(Presence) bool) (int, error) {
+	defer mps.lock.RUnlock()
+	mps.lock.RLock()
+
+	visited := 0
+	for _, p := range mps.presences {
+		visited++
+		if !f(p) {
+			break
+		}
+	}
+
+	return visited, nil
+}
+
+func (mps *memoryPresenceStore) Count() (int, error) {
+	mps.lock.RLock()
+	c := len(mps.presences)
+	mps.lock.RUnlock()
+	return c, nil
+}
@@ -0,0 +1,201 @@
+package device
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ PresenceLocator = (*manager)(nil)
+
+// failingPresenceStore is a PresenceStore whose writes always fail
+type failingPresenceStore struct {
+	PresenceStore
+}
+
+func (fps failingPresenceStore) Add(Presence) error {
+	return errors.New("expected")
+}
+
+func TestMemoryPresenceStore(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		store   = NewMemoryPresenceStore()
+		now     = time.Now()
+	)
+
+	count, err := store.Count()
+	require.NoError(err)
+	assert.Zero(count)
+
+	_, ok, err := store.Get(ID("nosuch"))
+	assert.False(ok)
+	assert.NoError(err)
+	assert.NoError(store.Remove(ID("nosuch")))
+
+	require.NoError(store.Add(Presence{ID: ID("a"), Instance: "one", ConnectedAt: now}))
+	require.NoError(store.Add(Presence{ID: ID("b"), Instance: "one", ConnectedAt: now}))
+	require.NoError(store.Add(Presence{ID: ID("a"), Instance: "two", ConnectedAt: now}))
+
+	count, err = store.Count()
+	require.NoError(err)
+	assert.Equal(2, count)
+
+	p, ok, err := store.Get(ID("a"))
+	require.NoError(err)
+	assert.True(ok)
+	assert.Equal(Presence{ID: ID("a"), Instance: "two", ConnectedAt: now}, p)
+
+	visited, err := store.Visit(func(Presence) bool { return true })
+	assert.NoError(err)
+	assert.Equal(2, visited)
+
+	visited, err = store.Visit(func(Presence) bool { return false })
+	assert.NoError(err)
+	assert.Equal(1, visited)
+
+	require.NoError(store.Remove(ID("a")))
+	_, ok, err = store.Get(ID("a"))
+	assert.False(ok)
+	assert.NoError(err)
+}
+
+func testRegistryPresenceAllowBoth(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		logger  = logging.NewTestLogger(nil, t)
+		store   = NewMemoryPresenceStore()
+
+		r = newRegistry(registryOptions{
+			Logger:          logger,
+			DuplicatePolicy: DuplicateAllowBoth,
+			Presence:        store,
+			Instance:        "instance-1",
+			Measures:        NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+		})
+
+		first  = newDevice(deviceOptions{ID: ID("test"), Partner: "acme", Logger: logger})
+		second = newDevice(deviceOptions{ID: ID("test"), Partner: "acme", Logger: logger, ConnectedAt: time.Now().Add(time.Minute)})
+		other  = newDevice(deviceOptions{ID: ID("other"), Logger: logger})
+	)
+
+	require.NoError(r.add(first))
+	require.NoError(r.add(second))
+	require.NoError(r.add(other))
+
+	p, ok, err := store.Get(ID("test"))
+	require.NoError(err)
+	require.True(ok)
+	assert.Equal(
+		Presence{ID: ID("test"), Instance: "instance-1", Partner: "acme", ConnectedAt: second.Statistics().ConnectedAt()},
+		p,
+	)
+
+	// the remaining session is still present
+	assert.True(r.removeDevice(second, CloseDisconnected))
+	p, ok, err = store.Get(ID("test"))
+	require.NoError(err)
+	require.True(ok)
+	assert.Equal(first.Statistics().ConnectedAt(), p.ConnectedAt)
+
+	assert.True(r.removeDevice(first, CloseDisconnected))
+	_, ok, err = store.Get(ID("test"))
+	require.NoError(err)
+	assert.False(ok)
+
+	assert.Equal(1, r.removeAll(CloseDisconnected))
+	count, err := store.Count()
+	require.NoError(err)
+	assert.Zero(count)
+}
+
+func testRegistryPresenceRemove(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		logger  = logging.NewTestLogger(nil, t)
+		store   = NewMemoryPresenceStore()
+
+		r = newRegistry(registryOptions{
+			Logger:   logger,
+			Presence: store,
+			Measures: NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+		})
+	)
+
+	for _, id := range []ID{"a", "b", "c"} {
+		require.NoError(r.add(newDevice(deviceOptions{ID: id, Logger: logger})))
+	}
+
+	_, ok := r.remove(ID("a"), CloseDisconnected)
+	assert.True(ok)
+	assert.Equal(1, r.removeIf(func(d *device) bool { return d.ID() == ID("b") }, CloseDisconnected))
+
+	count, err := store.Count()
+	require.NoError(err)
+	assert.Equal(1, count)
+
+	_, ok, err = store.Get(ID("c"))
+	require.NoError(err)
+	assert.True(ok)
+}
+
+func testRegistryPresenceError(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		logger = logging.NewTestLogger(nil, t)
+
+		r = newRegistry(registryOptions{
+			Logger:   logger,
+			Presence: failingPresenceStore{NewMemoryPresenceStore()},
+			Measures: NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+		})
+	)
+
+	// presence is only a hint, so a failing store never prevents a connection
+	assert.NoError(r.add(newDevice(deviceOptions{ID: ID("test"), Logger: logger})))
+	assert.Equal(1, r.len())
+}
+
+func TestRegistryPresence(t *testing.T) {
+	t.Run("AllowBoth", testRegistryPresenceAllowBoth)
+	t.Run("Remove", testRegistryPresenceRemove)
+	t.Run("Error", testRegistryPresenceError)
+}
+
+func TestManagerPresence(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		store   = NewMemoryPresenceStore()
+
+		m = NewManager(&Options{
+			PresenceStore: store,
+			Instance:      "instance-1",
+		})
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	_, err = lp.Connect(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	require.NoError(err)
+
+	p, ok, err := m.(PresenceLocator).Presence(testDeviceIDs[0])
+	require.NoError(err)
+	assert.True(ok)
+	assert.Equal("instance-1", p.Instance)
+
+	assert.True(m.Disconnect(testDeviceIDs[0]))
+	_, ok, err = m.(PresenceLocator).Presence(testDeviceIDs[0])
+	require.NoError(err)
+	assert.False(ok)
+}
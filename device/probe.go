@@ -0,0 +1,124 @@
+package device
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Comcast/webpa-common/clock"
+)
+
+// DefaultPingTimeout is used by Manager.Ping when the supplied timeout is nonpositive
+const DefaultPingTimeout = 10 * time.Second
+
+// probeQueueSize is the number of on-demand pings that may wait for a device's write pump
+const probeQueueSize = 4
+
+// probePrefix starts the application data of each on-demand ping.  Periodic pings carry the device ID, so their
+// pongs never match a probe.
+const probePrefix = "probe:"
+
+// probes correlates the on-demand pings written to a websocket device with the pongs the device returns.  Each ping
+// carries unique application data, which RFC 6455 requires the device to echo in its pong.
+//
+// A nil *probes is valid, and belongs to a long-poll device, which cannot be pinged.
+type probes struct {
+	frames chan []byte
+
+	lock    sync.Mutex
+	next    uint64
+	pending map[string]chan time.Time
+}
+
+func newProbes() *probes {
+	return &probes{
+		frames:  make(chan []byte, probeQueueSize),
+		pending: make(map[string]chan time.Time),
+	}
+}
+
+// queue returns the channel of pings for the write pump to send, which is nil for a nil *probes
+func (p *probes) queue() chan []byte {
+	if p == nil {
+		return nil
+	}
+
+	return p.frames
+}
+
+// start registers a new probe, returning the ping data to write and a channel that receives the time its pong
+// was read.  The probe must be removed via cancel once the caller is done waiting.
+func (p *probes) start() (string, <-chan time.Time) {
+	pong := make(chan time.Time, 1)
+
+	p.lock.Lock()
+	p.next++
+	data := probePrefix + strconv.FormatUint(p.next, 10)
+	p.pending[data] = pong
+	p.lock.Unlock()
+
+	return data, pong
+}
+
+// cancel removes a probe, whether or not it was answered
+func (p *probes) cancel(data string) {
+	p.lock.Lock()
+	delete(p.pending, data)
+	p.lock.Unlock()
+}
+
+// pong completes the probe whose ping carried the given data.  This method is called from the read pump for every
+// pong, so pongs that answer periodic pings, or probes that were already cancelled, are simply ignored.
+func (p *probes) pong(data string, at time.Time) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	pong, ok := p.pending[data]
+	delete(p.pending, data)
+	p.lock.Unlock()
+
+	if ok {
+		pong <- at
+	}
+}
+
+// ping writes an on-demand ping to this device and waits for the matching pong, returning the round-trip time.  The
+// time spent waiting for the write pump is included, as that is part of how responsive the device appears.
+func (d *device) ping(c clock.Interface, now func() time.Time, timeout time.Duration) (time.Duration, error) {
+	if d.probes == nil {
+		return 0, ErrorPingUnsupported
+	} else if d.Closed() {
+		return 0, ErrorDeviceClosed
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultPingTimeout
+	}
+
+	timer := c.NewTimer(timeout)
+	defer timer.Stop()
+
+	data, pong := d.probes.start()
+	defer d.probes.cancel(data)
+
+	sent := now()
+	select {
+	case d.probes.frames <- []byte(data):
+		d.pump.wake()
+	case <-d.shutdown:
+		return 0, ErrorDeviceClosed
+	case <-timer.C():
+		return 0, ErrorPingTimeout
+	}
+
+	select {
+	case received := <-pong:
+		return received.Sub(sent), nil
+	case <-d.shutdown:
+		return 0, ErrorDeviceClosed
+	case <-timer.C():
+		return 0, ErrorPingTimeout
+	}
+}
@@ -0,0 +1,153 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testProbesNil(t *testing.T) {
+	var p *probes
+	assert.Nil(t, p.queue())
+	assert.NotPanics(t, func() { p.pong(probePrefix+"1", time.Now()) })
+}
+
+func testProbesPong(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		p        = newProbes()
+		received = time.Now()
+	)
+
+	first, firstPong := p.start()
+	second, secondPong := p.start()
+	require.NotEqual(first, second)
+
+	// pongs to periodic pings and unknown probes are ignored
+	p.pong(string(testDeviceIDs[0]), received)
+	p.pong(probePrefix+"999", received)
+
+	p.pong(second, received)
+	assert.Equal(received, <-secondPong)
+	assert.Len(firstPong, 0)
+
+	// a cancelled probe is never completed
+	p.cancel(first)
+	p.pong(first, received)
+	assert.Len(firstPong, 0)
+	assert.Empty(p.pending)
+}
+
+func TestProbes(t *testing.T) {
+	t.Run("Nil", testProbesNil)
+	t.Run("Pong", testProbesPong)
+}
+
+func testManagerPingSuccess(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{})
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+
+	// the default ping handler answers each ping while the device reads
+	go func() {
+		for {
+			if _, _, err := connection.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		rtt, err := manager.Ping(testDeviceIDs[0], 5*time.Second)
+		require.NoError(err)
+		assert.True(rtt > 0)
+	}
+
+	_, err = manager.Ping(testDeviceIDs[1], time.Second)
+	assert.Equal(ErrorDeviceNotFound, err)
+}
+
+func testManagerPingTimeout(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{})
+		pinged                      = make(chan struct{}, 1)
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+
+	// the device receives the ping but never answers it
+	connection.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+
+		return nil
+	})
+
+	go func() {
+		for {
+			if _, _, err := connection.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for manager.Len() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = manager.Ping(testDeviceIDs[0], 100*time.Millisecond)
+	assert.Equal(ErrorPingTimeout, err)
+	<-pinged
+
+	require.True(manager.Disconnect(testDeviceIDs[0]))
+	_, err = manager.Ping(testDeviceIDs[0], time.Second)
+	assert.Equal(ErrorDeviceNotFound, err)
+}
+
+func testManagerPingUnsupported(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		m      = NewManager(&Options{}).(*manager)
+		d      = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+	)
+
+	assert.NoError(m.devices.add(d))
+	_, err := m.Ping(testDeviceIDs[0], time.Second)
+	assert.Equal(ErrorPingUnsupported, err)
+
+	d.probes = newProbes()
+	d.requestClose(CloseDisconnected)
+	_, err = m.Ping(testDeviceIDs[0], time.Second)
+	assert.Equal(ErrorDeviceClosed, err)
+}
+
+func TestManagerPing(t *testing.T) {
+	t.Run("Success", testManagerPingSuccess)
+	t.Run("Timeout", testManagerPingTimeout)
+	t.Run("Unsupported", testManagerPingUnsupported)
+}
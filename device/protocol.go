@@ -0,0 +1,51 @@
+package device
+
+import (
+	"net/http"
+
+	"github.com/Comcast/webpa-common/convey"
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+const (
+	// LegacySubprotocol is the websocket subprotocol a device requests to send messages in the wrp.ProtocolV1
+	// layout.  It is only offered when Options.AllowLegacyProtocol is set.
+	LegacySubprotocol = "wrp.v1"
+
+	// JSONSubprotocol is the websocket subprotocol a device requests to send messages encoded as wrp.JSON rather
+	// than wrp.Msgpack.  It is only offered when Options.AllowJSONFormat is set.
+	JSONSubprotocol = "wrp.json"
+
+	// ProtocolVersionConveyKey is the convey key whose value, such as "v1", selects the layout of a device's
+	// messages when Options.AllowLegacyProtocol is set.  This allows devices on any transport, including
+	// long-poll, to negotiate a protocol version.
+	ProtocolVersionConveyKey = "wrp-version"
+)
+
+// requestProtocolVersion returns the protocol version requested by a device at connect time.  The
+// ProtocolVersionConveyKey in the convey data takes precedence over the ProtocolVersionHeader.  If
+// neither is present, wrp.ProtocolV2 is selected.
+func requestProtocolVersion(cvy convey.C, header http.Header) (wrp.ProtocolVersion, error) {
+	if value, _ := cvy.GetString(ProtocolVersionConveyKey); len(value) > 0 {
+		return wrp.ParseProtocolVersion(value)
+	}
+
+	return wrp.ParseProtocolVersion(header.Get(ProtocolVersionHeader))
+}
+
+// frameDecoder returns the decoder for a device's inbound frames.  The legacy layout is positional msgpack, so a
+// device's protocol version only applies to msgpack frames.  Decode failures are classified so that skipped
+// frames can be counted by reason, and frames with an unknown msg_type are skipped like any other malformed frame.
+func frameDecoder(d *device) wrp.Decoder {
+	if d.format != wrp.Msgpack {
+		return wrp.NewDecoder(nil, d.format, wrp.WithDecodeErrors())
+	}
+
+	return wrp.NewVersionedDecoder(nil, d.protocol, wrp.WithDecodeErrors())
+}
+
+// normalizeFrame produces the contents of a decoded message in the current layout, using the given format
+func normalizeFrame(message *wrp.Message, format wrp.Format) (contents []byte, err error) {
+	err = wrp.NewEncoderBytes(&contents, format).Encode(message)
+	return
+}
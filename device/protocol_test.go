@@ -0,0 +1,343 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyFixture is a wrp.ProtocolV1 request:  [3, dest, source, transaction_uuid, content_type, payload,
+// nil, nil, nil, headers, metadata]
+const legacyFixture = "9b03b76d61633a3131323233333434353536362f636f6e666967b6646e733a6c65676163792e6578616d706c652e636f6da4" +
+	"31323334aa746578742f706c61696ec40568656c6c6fc0c0c091ab582d4c65676163793a203181aa2f626f6f742d74696d65aa" +
+	"31353030303030303030"
+
+// assertLegacyFixture verifies that a received event holds the normalized legacyFixture
+func assertLegacyFixture(t *testing.T, e *Event) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	require.IsType(new(wrp.Message), e.Message)
+	message := e.Message.(*wrp.Message)
+	assert.Equal(wrp.SimpleRequestResponseMessageType, message.Type)
+	assert.Equal("mac:112233445566/config", message.Destination)
+	assert.Equal("dns:legacy.example.com", message.Source)
+	assert.Equal("hello", string(message.Payload))
+
+	// the event contents are in the current layout
+	var fromContents wrp.Message
+	assert.Equal(wrp.Msgpack, e.Format)
+	require.NoError(wrp.NewDecoderBytes(e.Contents, wrp.Msgpack).Decode(&fromContents))
+	assert.Equal(*message, fromContents)
+}
+
+func conveyWithVersion(version string) string {
+	return base64.StdEncoding.EncodeToString([]byte(`{"wrp-version": "` + version + `"}`))
+}
+
+func testLegacyProtocolConvey(t *testing.T) {
+	var (
+		require  = require.New(t)
+		received = make(chan *Event, 1)
+
+		m = NewManager(&Options{
+			AllowLegacyProtocol: true,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == TransactionBroken {
+						received <- e
+					}
+				},
+			},
+		})
+
+		id      = testDeviceIDs[0]
+		request = WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil))
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(ConveyHeader, conveyWithVersion("v1"))
+	d, err := lp.Connect(httptest.NewRecorder(), request, nil)
+	require.NoError(err)
+	require.Equal(wrp.ProtocolV1, d.(*device).protocol)
+
+	fixture, err := hex.DecodeString(legacyFixture)
+	require.NoError(err)
+
+	response := httptest.NewRecorder()
+	lp.ServeHTTP(response, WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", bytes.NewReader(fixture))))
+	require.Equal(http.StatusAccepted, response.Code)
+
+	select {
+	case e := <-received:
+		assertLegacyFixture(t, e)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the legacy message was not received")
+	}
+
+	m.DisconnectAll()
+}
+
+func testLegacyProtocolSubprotocol(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan *Event, 1)
+
+		options = &Options{
+			AllowLegacyProtocol: true,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == TransactionBroken {
+						received <- e
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(
+		string(testDeviceIDs[0]),
+		connectURL,
+		http.Header{"Sec-Websocket-Protocol": []string{LegacySubprotocol}},
+	)
+
+	require.NoError(err)
+	defer connection.Close()
+	assert.Equal(LegacySubprotocol, connection.Subprotocol())
+
+	fixture, err := hex.DecodeString(legacyFixture)
+	require.NoError(err)
+	require.NoError(connection.WriteMessage(websocket.BinaryMessage, fixture))
+
+	select {
+	case e := <-received:
+		assertLegacyFixture(t, e)
+	case <-time.After(5 * time.Second):
+		assert.Fail("the legacy message was not received")
+	}
+
+	assert.Equal(1, manager.DisconnectAll())
+}
+
+func testLegacyProtocolInvalid(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(&Options{AllowLegacyProtocol: true})
+		request = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil))
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(ConveyHeader, conveyWithVersion("v99"))
+	response := httptest.NewRecorder()
+	d, err := lp.Connect(response, request, nil)
+	assert.Nil(d)
+	assert.Equal(ErrorInvalidProtocolVersion, err)
+	assert.Equal(http.StatusBadRequest, response.Code)
+	assert.Zero(m.Len())
+}
+
+func testLegacyProtocolHeader(t *testing.T) {
+	var (
+		require = require.New(t)
+		m       = NewManager(&Options{AllowLegacyProtocol: true})
+		request = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil))
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(ProtocolVersionHeader, "v1")
+	d, err := lp.Connect(httptest.NewRecorder(), request, nil)
+	require.NoError(err)
+	require.Equal(wrp.ProtocolV1, d.(*device).protocol)
+	m.DisconnectAll()
+
+	// convey data takes precedence over the header
+	request = WithIDRequest(testDeviceIDs[1], httptest.NewRequest("POST", "http://localhost.com", nil))
+	request.Header.Set(ProtocolVersionHeader, "v1")
+	request.Header.Set(ConveyHeader, conveyWithVersion("v2"))
+	d, err = lp.Connect(httptest.NewRecorder(), request, nil)
+	require.NoError(err)
+	require.Equal(wrp.ProtocolV2, d.(*device).protocol)
+	m.DisconnectAll()
+}
+
+func testLegacyProtocolDisallowed(t *testing.T) {
+	var (
+		require = require.New(t)
+		m       = NewManager(nil)
+		request = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil))
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	request.Header.Set(ConveyHeader, conveyWithVersion("v1"))
+	d, err := lp.Connect(httptest.NewRecorder(), request, nil)
+	require.NoError(err)
+	assert.NotEqual(t, wrp.ProtocolV1, d.(*device).protocol)
+
+	m.DisconnectAll()
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	t.Run("Convey", testLegacyProtocolConvey)
+	t.Run("Subprotocol", testLegacyProtocolSubprotocol)
+	t.Run("Invalid", testLegacyProtocolInvalid)
+	t.Run("Header", testLegacyProtocolHeader)
+	t.Run("Disallowed", testLegacyProtocolDisallowed)
+}
+
+func testJSONFormatResponse(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan *Event, 1)
+
+		manager, server, connectURL = startWebsocketServer(&Options{
+			AllowJSONFormat: true,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived {
+						received <- e
+					}
+				},
+			},
+		})
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(
+		string(testDeviceIDs[0]),
+		connectURL,
+		http.Header{"Sec-Websocket-Protocol": []string{JSONSubprotocol}},
+	)
+
+	require.NoError(err)
+	defer connection.Close()
+	assert.Equal(JSONSubprotocol, connection.Subprotocol())
+	awaitLen(manager, 1)
+
+	type routeResult struct {
+		response *Response
+		err      error
+	}
+
+	routed := make(chan routeResult, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		response, err := manager.Route((&Request{
+			Message: &wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:server.com",
+				Destination:     string(testDeviceIDs[0]),
+				TransactionUUID: "json-test",
+			},
+		}).WithContext(ctx))
+
+		routed <- routeResult{response, err}
+	}()
+
+	// outbound messages are still msgpack
+	_, frame, err := connection.ReadMessage()
+	require.NoError(err)
+	var request wrp.Message
+	require.NoError(wrp.NewDecoderBytes(frame, wrp.Msgpack).Decode(&request))
+	assert.Equal("json-test", request.TransactionUUID)
+
+	require.NoError(connection.WriteMessage(websocket.BinaryMessage, wrp.MustEncode(
+		&wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          string(testDeviceIDs[0]),
+			Destination:     request.Source,
+			TransactionUUID: request.TransactionUUID,
+			Payload:         []byte("response"),
+		},
+		wrp.JSON,
+	)))
+
+	result := <-routed
+	require.NoError(result.err)
+	require.NotNil(result.response)
+
+	var fromContents wrp.Message
+	assert.Equal(wrp.JSON, result.response.Format)
+	require.NoError(wrp.NewDecoderBytes(result.response.Contents, wrp.JSON).Decode(&fromContents))
+	assert.Equal(*result.response.Message, fromContents)
+	assert.Equal("response", string(fromContents.Payload))
+
+	require.NoError(connection.WriteMessage(websocket.BinaryMessage, wrp.MustEncode(
+		&wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      string(testDeviceIDs[0]),
+			Destination: "event:test",
+		},
+		wrp.JSON,
+	)))
+
+	select {
+	case e := <-received:
+		var eventContents wrp.Message
+		assert.Equal(wrp.JSON, e.Format)
+		require.NoError(wrp.NewDecoderBytes(e.Contents, wrp.JSON).Decode(&eventContents))
+		assert.Equal(*e.Message.(*wrp.Message), eventContents)
+	case <-time.After(5 * time.Second):
+		assert.Fail("the JSON event was not received")
+	}
+}
+
+func testJSONFormatDisallowed(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{})
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(
+		string(testDeviceIDs[0]),
+		connectURL,
+		http.Header{"Sec-Websocket-Protocol": []string{JSONSubprotocol}},
+	)
+
+	require.NoError(err)
+	defer connection.Close()
+	assert.Empty(connection.Subprotocol())
+	awaitLen(manager, 1)
+
+	d, ok := manager.Get(testDeviceIDs[0])
+	require.True(ok)
+	assert.Equal(wrp.Msgpack, d.(*device).format)
+}
+
+func TestJSONFormat(t *testing.T) {
+	t.Run("Response", testJSONFormatResponse)
+	t.Run("Disallowed", testJSONFormatDisallowed)
+}
@@ -0,0 +1,110 @@
+package device
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Comcast/webpa-common/clock"
+	"github.com/Comcast/webpa-common/xmetrics"
+)
+
+// DefaultRateWindow is the default time window over which throughput rates are averaged
+const DefaultRateWindow time.Duration = time.Minute
+
+// Rate is a throughput calculator.  Implementations are safe for concurrent use.
+type Rate interface {
+	// Add records n units, typically bytes, as having been transferred at the current time
+	Add(n int)
+
+	// Value returns the current rate in units per second
+	Value() float64
+}
+
+// NewRate creates an exponentially-weighted moving average Rate.  Each unit added contributes to
+// the rate with a weight that decays exponentially over the given window, so a steady throughput
+// converges on its true per-second value.  If window is nonpositive, DefaultRateWindow is used.
+// If now is nil, time.Now is used.
+func NewRate(window time.Duration, now func() time.Time) Rate {
+	if window <= 0 {
+		window = DefaultRateWindow
+	}
+
+	if now == nil {
+		now = time.Now
+	}
+
+	return &ewma{
+		window: window.Seconds(),
+		now:    now,
+		last:   now(),
+	}
+}
+
+// ewma is the internal Rate implementation
+type ewma struct {
+	lock   sync.Mutex
+	window float64
+	now    func() time.Time
+	last   time.Time
+	value  float64
+}
+
+// decay brings the rate up to the current time.  This method must be called under the lock.
+func (e *ewma) decay() {
+	current := e.now()
+	if elapsed := current.Sub(e.last).Seconds(); elapsed > 0 {
+		e.value *= math.Exp(-elapsed / e.window)
+		e.last = current
+	}
+}
+
+func (e *ewma) Add(n int) {
+	e.lock.Lock()
+	e.decay()
+	e.value += float64(n) / e.window
+	e.lock.Unlock()
+}
+
+func (e *ewma) Value() float64 {
+	e.lock.Lock()
+	e.decay()
+	v := e.value
+	e.lock.Unlock()
+
+	return v
+}
+
+// throughputRefreshes is the number of times per RateWindow that a Manager refreshes its throughput gauges
+const throughputRefreshes = 10
+
+// gaugeRate is a Rate decorator that exposes the current value of a Rate as a gauge
+// each time units are added or the gauge is refreshed
+type gaugeRate struct {
+	Rate
+	gauge xmetrics.Setter
+}
+
+func (gr gaugeRate) Add(n int) {
+	gr.Rate.Add(n)
+	gr.refresh()
+}
+
+// refresh sets the gauge to the current value of the Rate, which lets the gauge decay while nothing is added
+func (gr gaugeRate) refresh() {
+	gr.gauge.Set(gr.Rate.Value())
+}
+
+// refreshThroughput refreshes the aggregate throughput gauges on every tick, and exits once this manager is closed
+func (m *manager) refreshThroughput(ticker clock.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.lifecycle.closed:
+			return
+		case <-ticker.C():
+			m.readThroughput.refresh()
+			m.writeThroughput.refresh()
+		}
+	}
+}
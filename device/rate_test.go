@@ -0,0 +1,135 @@
+package device
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/clock/clocktest"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newManagerClock creates a fake clock that expects the ticker every Manager creates to refresh its throughput
+// gauges for the given RateWindow.  That ticker never ticks.
+func newManagerClock(rateWindow time.Duration) *clocktest.Mock {
+	var (
+		fakeClock = new(clocktest.Mock)
+		ticker    = new(clocktest.MockTicker)
+	)
+
+	fakeClock.OnNewTicker(rateWindow/throughputRefreshes, ticker).Once()
+	ticker.OnC((<-chan time.Time)(nil))
+	ticker.OnStop()
+	return fakeClock
+}
+
+func testNewRateDefaults(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		rate    = NewRate(-1, nil)
+	)
+
+	require.NotNil(rate)
+	assert.Equal(DefaultRateWindow.Seconds(), rate.(*ewma).window)
+	assert.Zero(rate.Value())
+}
+
+func testNewRateSteady(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		current = time.Now()
+		rate    = NewRate(10*time.Second, func() time.Time { return current })
+	)
+
+	// 1000 bytes every second for a long time should converge on 1000 bytes/second
+	for i := 0; i < 1000; i++ {
+		current = current.Add(time.Second)
+		rate.Add(1000)
+	}
+
+	assert.InEpsilon(1000.0, rate.Value(), 0.1)
+
+	// with no traffic, the rate decays by a factor of e for each window
+	before := rate.Value()
+	current = current.Add(10 * time.Second)
+	assert.InEpsilon(before/math.E, rate.Value(), 0.0001)
+
+	current = current.Add(time.Hour)
+	assert.InDelta(0.0, rate.Value(), 0.0001)
+}
+
+func testGaugeRate(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		current = time.Now()
+		p       = xmetricstest.NewProvider(nil, Metrics)
+		rate    = gaugeRate{
+			Rate:  NewRate(time.Second, func() time.Time { return current }),
+			gauge: p.NewGauge(ReadThroughputGauge),
+		}
+	)
+
+	rate.Add(100)
+	assert.Equal(100.0, rate.Value())
+	p.Assert(t, ReadThroughputGauge)(xmetricstest.Value(100.0))
+}
+
+func testManagerRefreshThroughput(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		current   = time.Now()
+		p         = xmetricstest.NewProvider(nil, Metrics)
+		fakeClock = new(clocktest.Mock)
+		ticker    = new(clocktest.MockTicker)
+		ticks     = make(chan time.Time)
+		stopped   = make(chan struct{})
+	)
+
+	fakeClock.OnNewTicker(time.Second/throughputRefreshes, ticker).Once()
+	ticker.OnC((<-chan time.Time)(ticks))
+	ticker.OnStop().Once().Run(func(mock.Arguments) { close(stopped) })
+
+	m := NewManager(&Options{
+		RateWindow:      time.Second,
+		MetricsProvider: p,
+		Now:             func() time.Time { return current },
+		Clock:           fakeClock,
+	}).(*manager)
+
+	m.readThroughput.Add(100)
+	m.writeThroughput.Add(50)
+	p.Assert(t, ReadThroughputGauge)(xmetricstest.Value(100.0))
+	p.Assert(t, WriteThroughputGauge)(xmetricstest.Value(50.0))
+
+	// with no traffic, the gauges decay on each refresh
+	current = current.Add(time.Hour)
+	ticks <- current
+	m.Close()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the throughput refresher did not stop its ticker")
+	}
+
+	p.Assert(t, ReadThroughputGauge)(xmetricstest.Value(0.0))
+	p.Assert(t, WriteThroughputGauge)(xmetricstest.Value(0.0))
+	fakeClock.AssertExpectations(t)
+	ticker.AssertExpectations(t)
+}
+
+func TestNewRate(t *testing.T) {
+	t.Run("Defaults", testNewRateDefaults)
+	t.Run("Steady", testNewRateSteady)
+}
+
+func TestGaugeRate(t *testing.T) {
+	testGaugeRate(t)
+}
+
+func TestManagerRefreshThroughput(t *testing.T) {
+	testManagerRefreshThroughput(t)
+}
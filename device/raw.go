@@ -0,0 +1,46 @@
+package device
+
+import "github.com/Comcast/webpa-common/wrp"
+
+// rawHeader is the wrp.Routable of a request made by SendRaw.  It holds only the fields that routing and
+// transactions need, so decoding a frame into it skips every other field, including the payload.  A request
+// holding a rawHeader is always written to its device exactly as its Contents were supplied.
+type rawHeader struct {
+	Type            wrp.MessageType `wrp:"msg_type"`
+	Source          string          `wrp:"source,omitempty"`
+	Destination     string          `wrp:"dest,omitempty"`
+	TransactionUUID string          `wrp:"transaction_uuid,omitempty"`
+}
+
+var _ wrp.Routable = (*rawHeader)(nil)
+
+func (rh *rawHeader) MessageType() wrp.MessageType {
+	return rh.Type
+}
+
+func (rh *rawHeader) To() string {
+	return rh.Destination
+}
+
+func (rh *rawHeader) From() string {
+	return rh.Source
+}
+
+func (rh *rawHeader) IsTransactionPart() bool {
+	return rh.Type.SupportsTransaction() && len(rh.TransactionUUID) > 0
+}
+
+func (rh *rawHeader) TransactionKey() string {
+	return rh.TransactionUUID
+}
+
+// Response produces a rawHeader addressed back to this header's source.  A rawHeader has no field for the
+// requestDeliveryResponse, so it is ignored.
+func (rh *rawHeader) Response(newSource string, requestDeliveryResponse int64) wrp.Routable {
+	return &rawHeader{
+		Type:            rh.Type,
+		Source:          newSource,
+		Destination:     rh.Source,
+		TransactionUUID: rh.TransactionUUID,
+	}
+}
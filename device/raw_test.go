@@ -0,0 +1,58 @@
+package device
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawHeader(t *testing.T) {
+	for _, format := range []wrp.Format{wrp.Msgpack, wrp.JSON} {
+		t.Run(fmt.Sprintf("Decode%s", format), func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				header  rawHeader
+
+				contents = wrp.MustEncode(&wrp.Message{
+					Type:            wrp.SimpleRequestResponseMessageType,
+					Source:          "dns:relay.example.com",
+					Destination:     string(testDeviceIDs[0]),
+					TransactionUUID: "123",
+					Metadata:        map[string]string{"ignored": "true"},
+					Payload:         []byte("ignored"),
+				}, format)
+			)
+
+			require.NoError(wrp.NewDecoderBytes(contents, format).Decode(&header))
+			assert.Equal(
+				rawHeader{
+					Type:            wrp.SimpleRequestResponseMessageType,
+					Source:          "dns:relay.example.com",
+					Destination:     string(testDeviceIDs[0]),
+					TransactionUUID: "123",
+				},
+				header,
+			)
+
+			assert.Equal(wrp.SimpleRequestResponseMessageType, header.MessageType())
+			assert.Equal("dns:relay.example.com", header.From())
+			assert.Equal(string(testDeviceIDs[0]), header.To())
+			assert.True(header.IsTransactionPart())
+			assert.Equal("123", header.TransactionKey())
+
+			response := header.Response("dns:device.example.com", 1)
+			assert.Equal("dns:device.example.com", response.From())
+			assert.Equal("dns:relay.example.com", response.To())
+			assert.Equal("123", response.TransactionKey())
+		})
+	}
+
+	t.Run("Event", func(t *testing.T) {
+		header := rawHeader{Type: wrp.SimpleEventMessageType, TransactionUUID: "123"}
+		assert.False(t, header.IsTransactionPart())
+	})
+}
@@ -2,29 +2,120 @@ package device
 
 import (
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/Comcast/webpa-common/clock"
+	"github.com/Comcast/webpa-common/logging"
 	"github.com/Comcast/webpa-common/xmetrics"
 	"github.com/go-kit/kit/log"
 )
 
 var errDeviceLimitReached = errors.New("Device limit reached")
 
+// DuplicatePolicy describes how a registry handles a device connecting with the same ID
+// as a device that is already connected.
+type DuplicatePolicy string
+
+const (
+	// DuplicateReplace disconnects the existing device in favor of the new connection.  This is
+	// the default, and it favors devices that reconnect before their old connection has been detected
+	// as dead.  The tradeoff is that two devices fraudulently sharing an ID will continually knock each other off.
+	DuplicateReplace DuplicatePolicy = "replace"
+
+	// DuplicateReject keeps the existing device and refuses the new connection.  This suits short-lived
+	// test harnesses, but a device whose old connection is half-open cannot reconnect until the idle
+	// period disconnects the stale connection.
+	DuplicateReject DuplicatePolicy = "reject"
+
+	// DuplicateAllowBoth keeps every connection, each as its own session.  Lookups by ID always return
+	// the most recently connected session, falling back to the next most recent when that session
+	// disconnects.  Routing uses the configured SessionSelection, which by default also selects the most
+	// recent session.  Stale connections consume capacity until they time out.
+	DuplicateAllowBoth DuplicatePolicy = "allow-both"
+)
+
+// EvictReason describes why a device was evicted from, or refused entry to, a registry
+type EvictReason int
+
+const (
+	// EvictReplaced indicates that a device was disconnected in favor of a duplicate with the same ID
+	EvictReplaced EvictReason = iota
+
+	// EvictDuplicateRejected indicates that a new device was refused because a device with the same ID
+	// was already connected
+	EvictDuplicateRejected
+
+	// EvictLimitReached indicates that a new device was refused because the maximum number of devices was reached
+	EvictLimitReached
+
+	// EvictPartnerQuotaReached indicates that a new device was refused because its partner's quota was reached
+	EvictPartnerQuotaReached
+
+	InvalidEvictReasonString = "!!INVALID EVICT REASON!!"
+)
+
+func (er EvictReason) String() string {
+	switch er {
+	case EvictReplaced:
+		return "Replaced"
+	case EvictDuplicateRejected:
+		return "DuplicateRejected"
+	case EvictLimitReached:
+		return "LimitReached"
+	case EvictPartnerQuotaReached:
+		return "PartnerQuotaReached"
+	default:
+		return InvalidEvictReasonString
+	}
+}
+
 type registryOptions struct {
-	Logger          log.Logger
-	Limit           int
-	InitialCapacity int
-	Measures        Measures
+	Logger              log.Logger
+	Limit               int
+	PartnerQuotas       map[string]int
+	DefaultPartnerQuota int
+	DuplicatePolicy     DuplicatePolicy
+	SessionSelection    SessionSelection
+	OnEvict             func(Interface, EvictReason)
+	InitialCapacity     int
+	Presence            PresenceStore
+	Instance            string
+	ResumptionWindow    time.Duration
+	Clock               clock.Interface
+	Measures            Measures
 }
 
 // registry is the internal lookup map for devices.  it is bounded by an optional maximum number
 // of connected devices.
 type registry struct {
-	logger          log.Logger
-	lock            sync.RWMutex
-	limit           int
-	initialCapacity int
-	data            map[ID]*device
+	logger              log.Logger
+	lock                sync.RWMutex
+	limit               int
+	partnerQuotas       map[string]int
+	defaultPartnerQuota int
+	duplicatePolicy     DuplicatePolicy
+	selectSession       sessionSelector
+	onEvict             func(Interface, EvictReason)
+	initialCapacity     int
+	size                int
+	data                map[ID]*device
+	sessions            map[ID][]*device
+	partnerCounts       map[string]int
+
+	// bySession indexes every registered device, including duplicate sessions, by its session ID
+	bySession map[string]*device
+
+	// parked holds, by resumption token, the sessions awaiting resumption.  Each expires after resumptionWindow.
+	parked           map[string]*parkedSession
+	resumptionWindow time.Duration
+	clock            clock.Interface
+
+	// presenceLock serializes presence updates, so that the store converges on the registry's state
+	presenceLock sync.Mutex
+	presence     PresenceStore
+	instance     string
 
 	count        xmetrics.Setter
 	limitReached xmetrics.Incrementer
@@ -38,86 +129,421 @@ func newRegistry(o registryOptions) *registry {
 		o.InitialCapacity = 10
 	}
 
+	if o.OnEvict == nil {
+		o.OnEvict = func(Interface, EvictReason) {}
+	}
+
+	if o.Logger == nil {
+		o.Logger = logging.DefaultLogger()
+	}
+
+	if o.Clock == nil {
+		o.Clock = clock.System()
+	}
+
+	partnerQuotas := make(map[string]int, len(o.PartnerQuotas))
+	for partner, quota := range o.PartnerQuotas {
+		partnerQuotas[partner] = quota
+	}
+
 	return &registry{
-		logger:          o.Logger,
-		initialCapacity: o.InitialCapacity,
-		data:            make(map[ID]*device, o.InitialCapacity),
-		limit:           o.Limit,
-		count:           o.Measures.Device,
-		limitReached:    o.Measures.LimitReached,
-		connect:         o.Measures.Connect,
-		disconnect:      o.Measures.Disconnect,
-		duplicates:      o.Measures.Duplicates,
+		logger:              o.Logger,
+		initialCapacity:     o.InitialCapacity,
+		data:                make(map[ID]*device, o.InitialCapacity),
+		sessions:            make(map[ID][]*device),
+		partnerCounts:       make(map[string]int),
+		bySession:           make(map[string]*device, o.InitialCapacity),
+		parked:              make(map[string]*parkedSession),
+		resumptionWindow:    o.ResumptionWindow,
+		clock:               o.Clock,
+		limit:               o.Limit,
+		partnerQuotas:       partnerQuotas,
+		defaultPartnerQuota: o.DefaultPartnerQuota,
+		duplicatePolicy:     o.DuplicatePolicy,
+		selectSession:       newSessionSelector(o.SessionSelection),
+		onEvict:             o.OnEvict,
+		presence:            o.Presence,
+		instance:            o.Instance,
+		count:               o.Measures.Device,
+		limitReached:        o.Measures.LimitReached,
+		connect:             o.Measures.Connect,
+		disconnect:          o.Measures.Disconnect,
+		duplicates:          o.Measures.Duplicates,
+	}
+}
+
+// partnerQuota returns the maximum number of devices allowed for the given partner.
+// A nonpositive value means the partner is unbounded.
+func (r *registry) partnerQuota(partner string) int {
+	if quota, ok := r.partnerQuotas[partner]; ok {
+		return quota
 	}
+
+	return r.defaultPartnerQuota
 }
 
-// len returns the size of this registry
+// incrementPartner bumps the device count for a partner.  This method must be invoked under the write lock.
+func (r *registry) incrementPartner(partner string) {
+	r.partnerCounts[partner]++
+}
+
+// decrementPartner lowers the device count for a partner, cleaning up the entry when it reaches zero.
+// This method must be invoked under the write lock.
+func (r *registry) decrementPartner(partner string) {
+	if count := r.partnerCounts[partner]; count > 1 {
+		r.partnerCounts[partner] = count - 1
+	} else {
+		delete(r.partnerCounts, partner)
+	}
+}
+
+// partnerCount returns the number of devices currently registered for the given partner
+func (r *registry) partnerCount(partner string) int {
+	r.lock.RLock()
+	c := r.partnerCounts[partner]
+	r.lock.RUnlock()
+
+	return c
+}
+
+// len returns the size of this registry, including any duplicate sessions
 func (r *registry) len() int {
 	r.lock.RLock()
-	l := len(r.data)
+	l := r.size
 	r.lock.RUnlock()
 
 	return l
 }
 
 // add uses a factory function to create a new device atomically with modifying
-// the registry
+// the registry.  How an existing device with the same ID is handled depends on
+// the duplicate policy of this registry.
 func (r *registry) add(newDevice *device) error {
 	id := newDevice.ID()
 	r.lock.Lock()
 
 	existing := r.data[id]
-	if existing == nil && r.limit > 0 && (len(r.data)+1) > r.limit {
+	if existing != nil && r.duplicatePolicy == DuplicateReject {
+		r.lock.Unlock()
+		r.duplicates.Inc()
+		r.disconnect.Add(1.0)
+		newDevice.requestClose(CloseDuplicateRejected)
+		r.onEvict(newDevice, EvictDuplicateRejected)
+		return ErrorDuplicateDevice
+	}
+
+	// replace indicates whether newDevice will take the place of an existing device
+	replace := existing != nil && r.duplicatePolicy != DuplicateAllowBoth
+	if !replace && r.limit > 0 && (r.size+1) > r.limit {
 		// adding this would result in exceeding the limit
 		r.lock.Unlock()
 		r.limitReached.Inc()
 		r.disconnect.Add(1.0)
-		newDevice.requestClose()
+		newDevice.requestClose(CloseOverCapacity)
+		r.onEvict(newDevice, EvictLimitReached)
 		return errDeviceLimitReached
 	}
 
-	// this will either leave the count the same or add 1 to it ...
+	partner := newDevice.partner
+	if !replace || existing.partner != partner {
+		// a replacement with the same partner never changes that partner's count
+		if quota := r.partnerQuota(partner); quota > 0 && (r.partnerCounts[partner]+1) > quota {
+			r.lock.Unlock()
+			r.limitReached.Inc()
+			r.disconnect.Add(1.0)
+			newDevice.requestClose(CloseOverCapacity)
+			r.onEvict(newDevice, EvictPartnerQuotaReached)
+			return ErrorPartnerQuotaReached
+		}
+
+		if replace {
+			r.decrementPartner(existing.partner)
+		}
+
+		r.incrementPartner(partner)
+	}
+
+	if !replace {
+		r.size++
+		if existing != nil {
+			// the existing device stays connected, but is no longer selected for routing
+			r.sessions[id] = append(r.sessions[id], existing)
+		}
+	}
+
+	if replace {
+		delete(r.bySession, existing.sessionID)
+		r.resume(newDevice, existing)
+	} else {
+		r.resume(newDevice, nil)
+	}
+
 	r.data[id] = newDevice
-	r.count.Set(float64(len(r.data)))
+	r.bySession[newDevice.sessionID] = newDevice
+	r.count.Set(float64(r.size))
 	r.lock.Unlock()
+	r.syncPresence(id)
 
 	if existing != nil {
-		r.disconnect.Add(1.0)
 		r.duplicates.Inc()
 		newDevice.Statistics().AddDuplications(existing.Statistics().Duplications() + 1)
-		existing.requestClose()
+
+		if replace {
+			r.disconnect.Add(1.0)
+			existing.requestClose(CloseReplaced)
+			r.onEvict(existing, EvictReplaced)
+		}
 	}
 
 	r.connect.Inc()
 	return nil
 }
 
-func (r *registry) remove(id ID) (*device, bool) {
+// resume re-associates the pending transactions of the session whose token newDevice presented.  That session is
+// either parked or, when a device reconnects before its previous connection is noticed to be broken, the replaced
+// device.  A token is only honored for the device ID it was issued to.  This method must be invoked under the
+// write lock, before newDevice is visible to any other goroutine.
+func (r *registry) resume(newDevice, replaced *device) bool {
+	token := newDevice.resumeFrom
+	if len(token) == 0 {
+		return false
+	}
+
+	if ps, ok := r.parked[token]; ok && ps.id == newDevice.ID() {
+		delete(r.parked, token)
+		ps.expiry.Stop()
+		newDevice.transactions = ps.transactions
+		return true
+	}
+
+	if replaced != nil && replaced.resumeToken == token && !replaced.Closed() {
+		replaced.resumed = true
+		newDevice.transactions = replaced.transactions
+		return true
+	}
+
+	return false
+}
+
+// park is invoked as a resumable device closes.  It returns true if the device's pending transactions must be
+// left open, either because a newer session has already resumed them or because they are now held until the
+// device resumes or the resumption window elapses.  A session closed for a reason that is not resumable is never
+// parked, nor is a session with nothing pending.
+func (r *registry) park(d *device, reason CloseReason) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if d.resumed {
+		return true
+	} else if r.resumptionWindow <= 0 || !reason.resumable() || d.transactions.Len() == 0 {
+		return false
+	}
+
+	ps := &parkedSession{
+		id:           d.ID(),
+		transactions: d.transactions,
+	}
+
+	token := d.resumeToken
+	r.parked[token] = ps
+	ps.expiry = clock.AfterFunc(r.clock, r.resumptionWindow, func() { r.expire(token, ps) })
+	return true
+}
+
+// expire fails the transactions of a parked session that was not resumed in time
+func (r *registry) expire(token string, ps *parkedSession) {
+	r.lock.Lock()
+	expired := r.parked[token] == ps
+	if expired {
+		delete(r.parked, token)
+	}
+
+	r.lock.Unlock()
+
+	if expired {
+		ps.transactions.Close()
+	}
+}
+
+// expireAll fails the transactions of every parked session, returning the number of sessions expired
+func (r *registry) expireAll() int {
+	r.lock.Lock()
+	parked := r.parked
+	r.parked = make(map[string]*parkedSession)
+	r.lock.Unlock()
+
+	for _, ps := range parked {
+		ps.expiry.Stop()
+		ps.transactions.Close()
+	}
+
+	return len(parked)
+}
+
+// unlink removes a specific device instance from this registry, returning false if that instance
+// is not present.  If the device is the one currently selected for its ID, the most recently connected
+// duplicate session, if any, takes its place.  This method must be invoked under the write lock.
+func (r *registry) unlink(d *device) bool {
+	id := d.ID()
+	sessions := r.sessions[id]
+	if r.data[id] == d {
+		if last := len(sessions) - 1; last >= 0 {
+			r.data[id] = sessions[last]
+			sessions = sessions[:last]
+		} else {
+			delete(r.data, id)
+		}
+	} else {
+		i := 0
+		for ; i < len(sessions) && sessions[i] != d; i++ {
+		}
+
+		if i == len(sessions) {
+			return false
+		}
+
+		sessions = append(sessions[:i], sessions[i+1:]...)
+	}
+
+	if len(sessions) > 0 {
+		r.sessions[id] = sessions
+	} else {
+		delete(r.sessions, id)
+	}
+
+	delete(r.bySession, d.sessionID)
+	r.size--
+	r.decrementPartner(d.partner)
+	return true
+}
+
+// syncPresence writes the current state of the given ID through to the presence store, if one is configured.
+// The state is read within the presence lock, so concurrent updates for the same ID cannot leave the store
+// disagreeing with the registry.  This method must not be invoked under the registry lock.
+func (r *registry) syncPresence(id ID) {
+	if r.presence == nil {
+		return
+	}
+
+	r.presenceLock.Lock()
+	defer r.presenceLock.Unlock()
+
+	r.lock.RLock()
+	d, ok := r.data[id]
+	r.lock.RUnlock()
+
+	var err error
+	if ok {
+		err = r.presence.Add(Presence{
+			ID:          id,
+			Instance:    r.instance,
+			Partner:     d.partner,
+			ConnectedAt: d.Statistics().ConnectedAt(),
+		})
+	} else {
+		err = r.presence.Remove(id)
+	}
+
+	if err != nil {
+		logging.Error(r.logger).Log(logging.MessageKey(), "unable to update device presence", "id", id, logging.ErrorKey(), err)
+	}
+}
+
+// remove disconnects every device registered under the given ID, sending each the given close reason.
+// The device selected for that ID, if any, is returned.
+func (r *registry) remove(id ID, reason CloseReason) (*device, bool) {
+	existing, count := r.removeSessions(id, reason)
+	return existing, count > 0
+}
+
+// removeSessions is like remove, but returns the number of devices disconnected, which includes
+// any duplicate sessions.
+func (r *registry) removeSessions(id ID, reason CloseReason) (*device, int) {
 	r.lock.Lock()
 	existing, ok := r.data[id]
+	var removed []*device
 	if ok {
-		delete(r.data, id)
+		removed = append(removed, r.sessions[id]...)
+		removed = append(removed, existing)
+		for _, d := range removed {
+			r.unlink(d)
+		}
 	}
 
-	r.count.Set(float64(len(r.data)))
+	r.count.Set(float64(r.size))
 	r.lock.Unlock()
 
-	if existing != nil {
+	if len(removed) > 0 {
+		r.syncPresence(id)
+		r.disconnect.Add(float64(len(removed)))
+		for _, d := range removed {
+			d.requestClose(reason)
+		}
+	}
+
+	return existing, len(removed)
+}
+
+// ids returns a snapshot of the IDs that currently have at least one registered device.  The read
+// lock is held only long enough to copy the keys.
+func (r *registry) ids() []ID {
+	defer r.lock.RUnlock()
+	r.lock.RLock()
+
+	ids := make([]ID, 0, len(r.data))
+	for id := range r.data {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// snapshot returns every registered device, including duplicate sessions.  Like ids, the read lock is held
+// only long enough to copy the devices, so callers may do as much work as they like with the result.
+func (r *registry) snapshot() []*device {
+	defer r.lock.RUnlock()
+	r.lock.RLock()
+
+	devices := make([]*device, 0, r.size)
+	for id, d := range r.data {
+		devices = append(devices, d)
+		devices = append(devices, r.sessions[id]...)
+	}
+
+	return devices
+}
+
+// removeDevice disconnects a specific device instance, leaving any other sessions
+// with the same ID intact.  This method returns false if the instance was not registered.
+func (r *registry) removeDevice(d *device, reason CloseReason) bool {
+	r.lock.Lock()
+	ok := r.unlink(d)
+	r.count.Set(float64(r.size))
+	r.lock.Unlock()
+
+	if ok {
+		r.syncPresence(d.ID())
 		r.disconnect.Add(1.0)
-		existing.requestClose()
+		d.requestClose(reason)
 	}
 
-	return existing, ok
+	return ok
 }
 
-func (r *registry) removeIf(f func(d *device) bool) int {
+func (r *registry) removeIf(f func(d *device) bool, reason CloseReason) int {
 	// first, gather up all the devices that match the predicate
 	matched := make([]*device, 0, 100)
 	r.lock.RLock()
-	for _, d := range r.data {
+	for id, d := range r.data {
 		if f(d) {
 			matched = append(matched, d)
 		}
+
+		for _, session := range r.sessions[id] {
+			if f(session) {
+				matched = append(matched, session)
+			}
+		}
 	}
 
 	r.lock.RUnlock()
@@ -133,17 +559,17 @@ func (r *registry) removeIf(f func(d *device) bool) int {
 		r.lock.Lock()
 
 		// allow for barging
-		_, ok := r.data[d.ID()]
+		ok := r.unlink(d)
 		if ok {
-			delete(r.data, d.ID())
-			r.count.Set(float64(len(r.data)))
+			r.count.Set(float64(r.size))
 		}
 
 		r.lock.Unlock()
 
 		if ok {
 			count++
-			d.requestClose()
+			r.syncPresence(d.ID())
+			d.requestClose(reason)
 		}
 	}
 
@@ -154,16 +580,24 @@ func (r *registry) removeIf(f func(d *device) bool) int {
 	return count
 }
 
-func (r *registry) removeAll() int {
+func (r *registry) removeAll(reason CloseReason) int {
 	r.lock.Lock()
-	original := r.data
+	original, originalSessions := r.data, r.sessions
+	count := r.size
 	r.data = make(map[ID]*device, r.initialCapacity)
+	r.sessions = make(map[ID][]*device)
+	r.partnerCounts = make(map[string]int)
+	r.bySession = make(map[string]*device, r.initialCapacity)
+	r.size = 0
 	r.count.Set(0.0)
 	r.lock.Unlock()
 
-	count := len(original)
-	for _, d := range original {
-		d.requestClose()
+	for id, d := range original {
+		r.syncPresence(id)
+		d.requestClose(reason)
+		for _, session := range originalSessions[id] {
+			session.requestClose(reason)
+		}
 	}
 
 	r.disconnect.Add(float64(count))
@@ -175,16 +609,112 @@ func (r *registry) visit(f func(d *device) bool) int {
 	r.lock.RLock()
 
 	visited := 0
-	for _, d := range r.data {
+	for id, d := range r.data {
 		visited++
 		if !f(d) {
-			break
+			return visited
+		}
+
+		for _, session := range r.sessions[id] {
+			visited++
+			if !f(session) {
+				return visited
+			}
 		}
 	}
 
 	return visited
 }
 
+// verify checks the internal consistency of this registry, returning an error describing the first
+// inconsistency found.  Every registered device, including duplicate sessions, must be non-nil, must be
+// stored under its own ID, and must appear exactly once.  Every ID with duplicate sessions must also have a
+// selected device, the size and per-partner counts must agree with the devices actually stored, and every device
+// must be indexed by its session ID.
+//
+// This method is intended for tests and debugging.  It holds the read lock for the entire check.
+func (r *registry) verify() error {
+	defer r.lock.RUnlock()
+	r.lock.RLock()
+
+	var (
+		seen          = make(map[*device]bool, r.size)
+		partnerCounts = make(map[string]int, len(r.partnerCounts))
+	)
+
+	check := func(id ID, d *device) error {
+		switch {
+		case d == nil:
+			return fmt.Errorf("Nil device stored under id %s", id)
+		case d.ID() != id:
+			return fmt.Errorf("Device %s is stored under id %s", d.ID(), id)
+		case seen[d]:
+			return fmt.Errorf("Device %s is stored more than once", id)
+		}
+
+		seen[d] = true
+		partnerCounts[d.partner]++
+		return nil
+	}
+
+	for id, d := range r.data {
+		if err := check(id, d); err != nil {
+			return err
+		}
+	}
+
+	for id, sessions := range r.sessions {
+		if _, ok := r.data[id]; !ok {
+			return fmt.Errorf("Duplicate sessions for id %s are not reachable by that id", id)
+		}
+
+		if len(sessions) == 0 {
+			return fmt.Errorf("Empty session list stored under id %s", id)
+		}
+
+		for _, d := range sessions {
+			if err := check(id, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(seen) != r.size {
+		return fmt.Errorf("Registry size is %d, but %d devices are stored", r.size, len(seen))
+	}
+
+	if len(partnerCounts) != len(r.partnerCounts) {
+		return fmt.Errorf("Registry tracks %d partners, but devices belong to %d partners", len(r.partnerCounts), len(partnerCounts))
+	}
+
+	for partner, count := range partnerCounts {
+		if r.partnerCounts[partner] != count {
+			return fmt.Errorf("Partner %q has a count of %d, but %d devices are stored", partner, r.partnerCounts[partner], count)
+		}
+	}
+
+	if len(r.bySession) != len(seen) {
+		return fmt.Errorf("Registry indexes %d sessions, but %d devices are stored", len(r.bySession), len(seen))
+	}
+
+	for sessionID, d := range r.bySession {
+		if d == nil || d.sessionID != sessionID || !seen[d] {
+			return fmt.Errorf("Session %s is not indexed to a registered device", sessionID)
+		}
+	}
+
+	return nil
+}
+
+// getSession returns the device with the given session ID, which may be any of the duplicate sessions for an ID
+func (r *registry) getSession(sessionID string) (*device, bool) {
+	r.lock.RLock()
+	existing, ok := r.bySession[sessionID]
+	r.lock.RUnlock()
+
+	return existing, ok
+}
+
 func (r *registry) get(id ID) (*device, bool) {
 	r.lock.RLock()
 	existing, ok := r.data[id]
@@ -192,3 +722,35 @@ func (r *registry) get(id ID) (*device, bool) {
 
 	return existing, ok
 }
+
+// route returns the session that should receive a request for the given ID.  When the ID has duplicate
+// sessions, the open sessions are offered to this registry's sessionSelector.  If every session is closing,
+// the most recently connected session is returned so that the caller observes the closed device.
+func (r *registry) route(id ID) (*device, bool) {
+	defer r.lock.RUnlock()
+	r.lock.RLock()
+
+	existing, ok := r.data[id]
+	sessions := r.sessions[id]
+	if !ok || len(sessions) == 0 {
+		return existing, ok
+	}
+
+	open := make([]*device, 0, len(sessions)+1)
+	if !existing.Closed() {
+		open = append(open, existing)
+	}
+
+	// sessions are stored from least to most recently connected
+	for i := len(sessions) - 1; i >= 0; i-- {
+		if !sessions[i].Closed() {
+			open = append(open, sessions[i])
+		}
+	}
+
+	if len(open) == 0 {
+		return existing, true
+	}
+
+	return r.selectSession(open), true
+}
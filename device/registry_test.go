@@ -132,6 +132,305 @@ func testRegistryAdd(t *testing.T) {
 	})
 }
 
+func testRegistryPartnerQuota(t *testing.T) {
+	t.Run("Add", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			logger  = logging.NewTestLogger(nil, t)
+
+			p = xmetricstest.NewProvider(nil, Metrics)
+			r = newRegistry(registryOptions{
+				Logger:              logger,
+				Limit:               10,
+				PartnerQuotas:       map[string]int{"noisy": 1, "unbounded": 0},
+				DefaultPartnerQuota: 2,
+				Measures:            NewMeasures(p),
+			})
+		)
+
+		require.NotNil(r)
+
+		noisy := newDevice(deviceOptions{ID: ID("noisy1"), Partner: "noisy", Logger: logger})
+		require.NoError(r.add(noisy))
+		assert.Equal(1, r.partnerCount("noisy"))
+
+		cantAdd := newDevice(deviceOptions{ID: ID("noisy2"), Partner: "noisy", Logger: logger})
+		assert.Equal(ErrorPartnerQuotaReached, r.add(cantAdd))
+		assert.True(cantAdd.Closed())
+		assert.False(noisy.Closed())
+		assert.Equal(1, r.partnerCount("noisy"))
+		assert.Equal(1, r.len())
+
+		for i := 0; i < 2; i++ {
+			require.NoError(r.add(newDevice(deviceOptions{ID: ID("other" + strconv.Itoa(i)), Partner: "other", Logger: logger})))
+		}
+
+		assert.Equal(ErrorPartnerQuotaReached, r.add(newDevice(deviceOptions{ID: ID("other2"), Partner: "other", Logger: logger})))
+		assert.Equal(2, r.partnerCount("other"))
+
+		for i := 0; i < 5; i++ {
+			require.NoError(r.add(newDevice(deviceOptions{ID: ID("unbounded" + strconv.Itoa(i)), Partner: "unbounded", Logger: logger})))
+		}
+
+		assert.Equal(5, r.partnerCount("unbounded"))
+		assert.Equal(8, r.len())
+		p.Assert(t, DeviceCounter)(xmetricstest.Value(8.0))
+		p.Assert(t, ConnectCounter)(xmetricstest.Value(8.0))
+		p.Assert(t, DisconnectCounter)(xmetricstest.Value(2.0))
+		p.Assert(t, DeviceLimitReachedCounter)(xmetricstest.Value(2.0))
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			logger  = logging.NewTestLogger(nil, t)
+
+			p = xmetricstest.NewProvider(nil, Metrics)
+			r = newRegistry(registryOptions{
+				Logger:              logger,
+				DefaultPartnerQuota: 1,
+				Measures:            NewMeasures(p),
+			})
+		)
+
+		require.NotNil(r)
+
+		initial := newDevice(deviceOptions{ID: ID("test"), Partner: "a", Logger: logger})
+		require.NoError(r.add(initial))
+
+		sameP := newDevice(deviceOptions{ID: ID("test"), Partner: "a", Logger: logger})
+		require.NoError(r.add(sameP))
+		assert.True(initial.Closed())
+		assert.Equal(1, r.partnerCount("a"))
+
+		otherP := newDevice(deviceOptions{ID: ID("test"), Partner: "b", Logger: logger})
+		require.NoError(r.add(otherP))
+		assert.True(sameP.Closed())
+		assert.Equal(0, r.partnerCount("a"))
+		assert.Equal(1, r.partnerCount("b"))
+
+		blocker := newDevice(deviceOptions{ID: ID("blocker"), Partner: "a", Logger: logger})
+		require.NoError(r.add(blocker))
+
+		// switching back to a full partner must not evict the existing device
+		rejected := newDevice(deviceOptions{ID: ID("test"), Partner: "a", Logger: logger})
+		assert.Equal(ErrorPartnerQuotaReached, r.add(rejected))
+		assert.True(rejected.Closed())
+		assert.False(otherP.Closed())
+		assert.Equal(1, r.partnerCount("a"))
+		assert.Equal(1, r.partnerCount("b"))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			logger  = logging.NewTestLogger(nil, t)
+
+			p = xmetricstest.NewProvider(nil, Metrics)
+			r = newRegistry(registryOptions{
+				Logger:              logger,
+				DefaultPartnerQuota: 3,
+				Measures:            NewMeasures(p),
+			})
+		)
+
+		require.NotNil(r)
+		for i := 0; i < 3; i++ {
+			require.NoError(r.add(newDevice(deviceOptions{ID: ID(strconv.Itoa(i)), Partner: "a", Logger: logger})))
+		}
+
+		assert.Equal(3, r.partnerCount("a"))
+
+		_, ok := r.remove(ID("0"), CloseDisconnected)
+		assert.True(ok)
+		assert.Equal(2, r.partnerCount("a"))
+
+		assert.Equal(1, r.removeIf(func(d *device) bool { return d.ID() == ID("1") }, CloseDisconnected))
+		assert.Equal(1, r.partnerCount("a"))
+
+		assert.Equal(1, r.removeAll(CloseDisconnected))
+		assert.Equal(0, r.partnerCount("a"))
+
+		for i := 0; i < 3; i++ {
+			require.NoError(r.add(newDevice(deviceOptions{ID: ID(strconv.Itoa(i)), Partner: "a", Logger: logger})))
+		}
+	})
+}
+
+func testRegistryDuplicatePolicy(t *testing.T) {
+	t.Run("Reject", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			logger  = logging.NewTestLogger(nil, t)
+
+			p = xmetricstest.NewProvider(nil, Metrics)
+			r = newRegistry(registryOptions{
+				Logger:          logger,
+				DuplicatePolicy: DuplicateReject,
+				Measures:        NewMeasures(p),
+			})
+		)
+
+		require.NotNil(r)
+
+		initial := newDevice(deviceOptions{ID: ID("test"), Logger: logger})
+		require.NoError(r.add(initial))
+
+		duplicate := newDevice(deviceOptions{ID: ID("test"), Logger: logger})
+		assert.Equal(ErrorDuplicateDevice, r.add(duplicate))
+		assert.False(initial.Closed())
+		assert.True(duplicate.Closed())
+
+		existing, ok := r.get(ID("test"))
+		assert.True(existing == initial)
+		assert.True(ok)
+		assert.Equal(1, r.len())
+		p.Assert(t, DeviceCounter)(xmetricstest.Value(1.0))
+		p.Assert(t, ConnectCounter)(xmetricstest.Value(1.0))
+		p.Assert(t, DisconnectCounter)(xmetricstest.Value(1.0))
+		p.Assert(t, DuplicatesCounter)(xmetricstest.Value(1.0))
+	})
+
+	t.Run("AllowBoth", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			logger  = logging.NewTestLogger(nil, t)
+
+			p = xmetricstest.NewProvider(nil, Metrics)
+			r = newRegistry(registryOptions{
+				Logger:          logger,
+				Limit:           3,
+				DuplicatePolicy: DuplicateAllowBoth,
+				Measures:        NewMeasures(p),
+			})
+
+			first  = newDevice(deviceOptions{ID: ID("test"), Logger: logger})
+			second = newDevice(deviceOptions{ID: ID("test"), Logger: logger})
+			third  = newDevice(deviceOptions{ID: ID("test"), Logger: logger})
+		)
+
+		require.NotNil(r)
+		require.NoError(r.add(first))
+		require.NoError(r.add(second))
+		require.NoError(r.add(third))
+		assert.Equal(errDeviceLimitReached, r.add(newDevice(deviceOptions{ID: ID("test"), Logger: logger})))
+
+		assert.False(first.Closed())
+		assert.False(second.Closed())
+		assert.False(third.Closed())
+		assert.Equal(3, r.len())
+		assert.Equal(3, r.visit(func(*device) bool { return true }))
+		p.Assert(t, DeviceCounter)(xmetricstest.Value(3.0))
+		p.Assert(t, DuplicatesCounter)(xmetricstest.Value(2.0))
+
+		// the most recent session is always selected
+		existing, ok := r.get(ID("test"))
+		assert.True(existing == third)
+		assert.True(ok)
+
+		assert.True(r.removeDevice(third, CloseDisconnected))
+		assert.False(r.removeDevice(third, CloseDisconnected))
+		existing, _ = r.get(ID("test"))
+		assert.True(existing == second)
+
+		assert.True(r.removeDevice(first, CloseDisconnected))
+		existing, _ = r.get(ID("test"))
+		assert.True(existing == second)
+		assert.Equal(1, r.len())
+
+		fourth := newDevice(deviceOptions{ID: ID("test"), Logger: logger})
+		require.NoError(r.add(fourth))
+		existing, ok = r.remove(ID("test"), CloseDisconnected)
+		assert.True(existing == fourth)
+		assert.True(ok)
+		assert.True(fourth.Closed())
+		assert.True(second.Closed())
+		assert.Equal(0, r.len())
+		p.Assert(t, DeviceCounter)(xmetricstest.Value(0.0))
+		p.Assert(t, DisconnectCounter)(xmetricstest.Value(5.0))
+	})
+}
+
+func testRegistryOnEvict(t *testing.T) {
+	type eviction struct {
+		device Interface
+		reason EvictReason
+	}
+
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		logger  = logging.NewTestLogger(nil, t)
+
+		evictions []eviction
+		r         *registry
+	)
+
+	r = newRegistry(registryOptions{
+		Logger:              logger,
+		Limit:               2,
+		DefaultPartnerQuota: 1,
+		Measures:            NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+		OnEvict: func(d Interface, reason EvictReason) {
+			// the registry must not be locked during this callback
+			r.lock.Lock()
+			r.lock.Unlock()
+			evictions = append(evictions, eviction{d, reason})
+		},
+	})
+
+	initial := newDevice(deviceOptions{ID: ID("1"), Partner: "a", Logger: logger})
+	require.NoError(r.add(initial))
+	assert.Empty(evictions)
+
+	duplicate := newDevice(deviceOptions{ID: ID("1"), Partner: "a", Logger: logger})
+	require.NoError(r.add(duplicate))
+	require.Len(evictions, 1)
+	assert.True(evictions[0].device == initial)
+	assert.Equal(EvictReplaced, evictions[0].reason)
+
+	overQuota := newDevice(deviceOptions{ID: ID("2"), Partner: "a", Logger: logger})
+	assert.Error(r.add(overQuota))
+	require.Len(evictions, 2)
+	assert.True(evictions[1].device == overQuota)
+	assert.Equal(EvictPartnerQuotaReached, evictions[1].reason)
+
+	require.NoError(r.add(newDevice(deviceOptions{ID: ID("3"), Partner: "b", Logger: logger})))
+	overLimit := newDevice(deviceOptions{ID: ID("4"), Partner: "c", Logger: logger})
+	assert.Error(r.add(overLimit))
+	require.Len(evictions, 3)
+	assert.True(evictions[2].device == overLimit)
+	assert.Equal(EvictLimitReached, evictions[2].reason)
+
+	// explicit removals are not evictions
+	r.remove(ID("1"), CloseDisconnected)
+	r.removeAll(CloseDisconnected)
+	assert.Len(evictions, 3)
+
+	r.duplicatePolicy = DuplicateReject
+	require.NoError(r.add(newDevice(deviceOptions{ID: ID("5"), Logger: logger})))
+	rejected := newDevice(deviceOptions{ID: ID("5"), Logger: logger})
+	assert.Error(r.add(rejected))
+	require.Len(evictions, 4)
+	assert.True(evictions[3].device == rejected)
+	assert.Equal(EvictDuplicateRejected, evictions[3].reason)
+}
+
+func TestEvictReason(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("Replaced", EvictReplaced.String())
+	assert.Equal("DuplicateRejected", EvictDuplicateRejected.String())
+	assert.Equal("LimitReached", EvictLimitReached.String())
+	assert.Equal("PartnerQuotaReached", EvictPartnerQuotaReached.String())
+	assert.Equal(InvalidEvictReasonString, EvictReason(-1).String())
+}
+
 func testRegistryRemoveAndGet(t *testing.T) {
 	var (
 		assert  = assert.New(t)
@@ -179,7 +478,7 @@ func testRegistryRemoveAndGet(t *testing.T) {
 	p.Assert(t, DeviceLimitReachedCounter)(xmetricstest.Value(0.0))
 	p.Assert(t, DuplicatesCounter)(xmetricstest.Value(0.0))
 
-	existing, ok = r.remove(ID("nosuch"))
+	existing, ok = r.remove(ID("nosuch"), CloseDisconnected)
 	assert.Nil(existing)
 	assert.False(ok)
 	assert.False(initial.Closed())
@@ -189,7 +488,7 @@ func testRegistryRemoveAndGet(t *testing.T) {
 	p.Assert(t, DeviceLimitReachedCounter)(xmetricstest.Value(0.0))
 	p.Assert(t, DuplicatesCounter)(xmetricstest.Value(0.0))
 
-	existing, ok = r.remove(ID("test"))
+	existing, ok = r.remove(ID("test"), CloseDisconnected)
 	assert.True(existing == initial)
 	assert.True(ok)
 	assert.True(initial.Closed())
@@ -239,9 +538,12 @@ func testRegistryRemoveIf(t *testing.T) {
 
 	assert.Equal(
 		0,
-		r.removeIf(func(*device) bool {
-			return false
-		}),
+		r.removeIf(
+			func(*device) bool {
+				return false
+			},
+			CloseDisconnected,
+		),
 	)
 
 	assert.False(initial.Closed())
@@ -253,9 +555,12 @@ func testRegistryRemoveIf(t *testing.T) {
 
 	assert.Equal(
 		1,
-		r.removeIf(func(*device) bool {
-			return true
-		}),
+		r.removeIf(
+			func(*device) bool {
+				return true
+			},
+			CloseDisconnected,
+		),
 	)
 
 	assert.True(initial.Closed())
@@ -290,7 +595,7 @@ func testRegistryRemoveAll(t *testing.T) {
 		require.NoError(r.add(d))
 	}
 
-	r.removeAll()
+	r.removeAll(CloseDisconnected)
 	p.Assert(t, DeviceCounter)(xmetricstest.Value(0.0))
 	p.Assert(t, ConnectCounter)(xmetricstest.Value(3.0))
 	p.Assert(t, DisconnectCounter)(xmetricstest.Value(3.0))
@@ -350,10 +655,130 @@ func testRegistryVisit(t *testing.T) {
 	p.Assert(t, DuplicatesCounter)(xmetricstest.Value(0.0))
 }
 
+func testRegistryVerify(t *testing.T) {
+	t.Run("Operations", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			r       = newRegistry(registryOptions{
+				DuplicatePolicy: DuplicateAllowBoth,
+				Measures:        NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+			})
+
+			devices []*device
+		)
+
+		require.NoError(r.verify())
+		for i := 0; i < 10; i++ {
+			d := newDevice(deviceOptions{ID: ID(strconv.Itoa(i % 4))})
+			d.partner = strconv.Itoa(i % 3)
+			devices = append(devices, d)
+			require.NoError(r.add(d))
+			require.NoError(r.verify())
+		}
+
+		assert.True(r.removeDevice(devices[0], CloseDisconnected))
+		assert.NoError(r.verify())
+
+		assert.True(r.removeDevice(devices[9], CloseDisconnected))
+		assert.NoError(r.verify())
+
+		_, ok := r.remove(ID("2"), CloseDisconnected)
+		assert.True(ok)
+		assert.NoError(r.verify())
+
+		assert.Equal(2, r.removeIf(func(d *device) bool { return d.ID() == ID("3") }, CloseDisconnected))
+		assert.NoError(r.verify())
+
+		assert.Equal(4, r.len())
+		assert.Equal(4, r.removeAll(CloseDisconnected))
+		assert.NoError(r.verify())
+	})
+
+	t.Run("Inconsistent", func(t *testing.T) {
+		for name, corrupt := range map[string]func(*registry, *device){
+			"Nil":          func(r *registry, d *device) { r.data[d.ID()] = nil },
+			"WrongID":      func(r *registry, d *device) { r.data[ID("other")] = d; delete(r.data, d.ID()) },
+			"Twice":        func(r *registry, d *device) { r.sessions[d.ID()] = []*device{d} },
+			"Unreachable":  func(r *registry, d *device) { delete(r.data, d.ID()) },
+			"Orphaned":     func(r *registry, d *device) { r.sessions[ID("other")] = []*device{d} },
+			"EmptySession": func(r *registry, d *device) { r.sessions[d.ID()] = []*device{} },
+			"Size":         func(r *registry, d *device) { r.size++ },
+			"Partner":      func(r *registry, d *device) { r.partnerCounts[d.partner]++ },
+			"NewPartner":   func(r *registry, d *device) { r.partnerCounts["other"] = 1 },
+			"Unindexed":    func(r *registry, d *device) { delete(r.bySession, d.sessionID) },
+			"StaleSession": func(r *registry, d *device) { r.bySession["stale"] = d; delete(r.bySession, d.sessionID) },
+		} {
+			t.Run(name, func(t *testing.T) {
+				var (
+					require = require.New(t)
+					r       = newRegistry(registryOptions{
+						Measures: NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+					})
+
+					d = newDevice(deviceOptions{ID: ID("test")})
+				)
+
+				require.NoError(r.add(d))
+				require.NoError(r.verify())
+				corrupt(r, d)
+				require.Error(r.verify())
+			})
+		}
+	})
+}
+
+func testRegistryGetSession(t *testing.T) {
+	for _, policy := range []DuplicatePolicy{DuplicateAllowBoth, DuplicateReplace} {
+		t.Run(string(policy), func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				r       = newRegistry(registryOptions{
+					DuplicatePolicy: policy,
+					Measures:        NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+				})
+
+				first  = newDevice(deviceOptions{ID: ID("test")})
+				second = newDevice(deviceOptions{ID: ID("test")})
+			)
+
+			assert.NotEqual(first.SessionID(), second.SessionID())
+			require.NoError(r.add(first))
+			require.NoError(r.add(second))
+			require.NoError(r.verify())
+
+			// a replaced device is no longer reachable by its session
+			existing, ok := r.getSession(first.SessionID())
+			assert.Equal(policy == DuplicateAllowBoth, ok)
+			assert.Equal(policy == DuplicateAllowBoth, existing == first)
+
+			existing, ok = r.getSession(second.SessionID())
+			assert.True(ok)
+			assert.True(existing == second)
+
+			assert.True(r.removeDevice(second, CloseDisconnected))
+			_, ok = r.getSession(second.SessionID())
+			assert.False(ok)
+			require.NoError(r.verify())
+
+			r.removeAll(CloseDisconnected)
+			_, ok = r.getSession(first.SessionID())
+			assert.False(ok)
+			require.NoError(r.verify())
+		})
+	}
+}
+
 func TestRegistry(t *testing.T) {
 	t.Run("Add", testRegistryAdd)
+	t.Run("PartnerQuota", testRegistryPartnerQuota)
+	t.Run("DuplicatePolicy", testRegistryDuplicatePolicy)
+	t.Run("OnEvict", testRegistryOnEvict)
 	t.Run("RemoveAndGet", testRegistryRemoveAndGet)
 	t.Run("RemoveIf", testRegistryRemoveIf)
 	t.Run("RemoveAll", testRegistryRemoveAll)
 	t.Run("Visit", testRegistryVisit)
+	t.Run("Verify", testRegistryVerify)
+	t.Run("GetSession", testRegistryGetSession)
 }
@@ -0,0 +1,53 @@
+package device
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/Comcast/webpa-common/clock"
+)
+
+// ResumptionTokenHeader is the HTTP header that carries session resumption tokens.  When Options.ResumptionWindow
+// is set, a Manager issues a fresh token in this header of each websocket connect response.  A device that loses
+// its connection presents its most recent token in this header when it reconnects, so that transactions still
+// pending from the previous session can complete over the new connection.
+const ResumptionTokenHeader = "X-Xmidt-Resumption-Token"
+
+// resumptionTokenSize is the number of random bytes in a resumption token
+const resumptionTokenSize = 16
+
+// newResumptionToken generates an unguessable token for a single session.  Since a token grants access to a
+// session's transactions, an empty string is returned if no secure random source is available, which leaves the
+// session without resumption.
+func newResumptionToken() string {
+	var token [resumptionTokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(token[:])
+}
+
+// withResumptionToken returns a response header that issues the device's resumption token.  The given header
+// is not modified.
+func withResumptionToken(d *device, responseHeader http.Header) http.Header {
+	if len(d.resumeToken) == 0 {
+		return responseHeader
+	}
+
+	header := make(http.Header, len(responseHeader)+1)
+	for name, values := range responseHeader {
+		header[name] = values
+	}
+
+	header.Set(ResumptionTokenHeader, d.resumeToken)
+	return header
+}
+
+// parkedSession holds the pending transactions of a closed session that its device may yet resume
+type parkedSession struct {
+	id           ID
+	transactions *Transactions
+	expiry       clock.Timer
+}
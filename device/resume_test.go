@@ -0,0 +1,335 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/clock/clocktest"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseReasonResumable(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, reason := range []CloseReason{CloseError, CloseIdle, CloseReplaced} {
+		assert.True(reason.resumable(), reason.Text)
+	}
+
+	for _, reason := range []CloseReason{CloseDisconnected, CloseDuplicateRejected, CloseOverCapacity, CloseDrained, CloseShutdown, CloseFlood, ClosePartner} {
+		assert.False(reason.resumable(), reason.Text)
+	}
+}
+
+func TestWithResumptionToken(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		original = http.Header{"X-Test": []string{"value"}}
+		d        = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+	)
+
+	assert.Equal(original, withResumptionToken(d, original))
+
+	d.resumeToken = newResumptionToken()
+	assert.Len(d.resumeToken, 2*resumptionTokenSize)
+	assert.NotEqual(d.resumeToken, newResumptionToken())
+
+	header := withResumptionToken(d, original)
+	assert.Equal(d.resumeToken, header.Get(ResumptionTokenHeader))
+	assert.Equal("value", header.Get("X-Test"))
+	assert.Empty(original.Get(ResumptionTokenHeader))
+}
+
+// newResumptionRegistry creates a registry that parks resumable sessions for the given window
+func newResumptionRegistry(window time.Duration) *registry {
+	return newRegistry(registryOptions{
+		ResumptionWindow: window,
+		Measures:         NewMeasures(provider.NewDiscardProvider()),
+	})
+}
+
+// newResumableDevice creates a device that parks in the given registry, as the manager arranges for websocket devices
+func newResumableDevice(r *registry, id ID, token, resumeFrom string) *device {
+	d := newDevice(deviceOptions{ID: id})
+	d.resumeToken = token
+	d.resumeFrom = resumeFrom
+	d.park = func(reason CloseReason) bool {
+		return r.park(d, reason)
+	}
+
+	return d
+}
+
+func testRegistryResumeParked(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		r       = newResumptionRegistry(time.Minute)
+		first   = newResumableDevice(r, testDeviceIDs[0], "first", "")
+	)
+
+	require.NoError(r.add(first))
+	result, err := first.transactions.Register("pending")
+	require.NoError(err)
+
+	assert.True(r.removeDevice(first, CloseError))
+	assert.True(first.Closed())
+	assert.Len(r.parked, 1)
+
+	// a token is only honored for the device it was issued to
+	impostor := newResumableDevice(r, testDeviceIDs[1], "impostor", "first")
+	require.NoError(r.add(impostor))
+	assert.False(impostor.transactions == first.transactions)
+	assert.Len(r.parked, 1)
+
+	second := newResumableDevice(r, testDeviceIDs[0], "second", "first")
+	require.NoError(r.add(second))
+	assert.True(second.transactions == first.transactions)
+	assert.Empty(r.parked)
+
+	require.NoError(second.transactions.Complete("pending", new(Response)))
+	assert.NotNil(<-result)
+}
+
+func testRegistryResumeReplaced(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		r       = newResumptionRegistry(time.Minute)
+		first   = newResumableDevice(r, testDeviceIDs[0], "first", "")
+	)
+
+	require.NoError(r.add(first))
+	result, err := first.transactions.Register("pending")
+	require.NoError(err)
+
+	// the device reconnects before its previous connection is noticed to be broken
+	second := newResumableDevice(r, testDeviceIDs[0], "second", "first")
+	require.NoError(r.add(second))
+	assert.True(first.Closed())
+	assert.True(second.transactions == first.transactions)
+	assert.Empty(r.parked)
+	assert.Len(result, 0)
+
+	require.NoError(second.transactions.Complete("pending", new(Response)))
+	assert.NotNil(<-result)
+}
+
+func testRegistryResumeNotResumable(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		r       = newResumptionRegistry(time.Minute)
+		first   = newResumableDevice(r, testDeviceIDs[0], "first", "")
+		idle    = newResumableDevice(r, testDeviceIDs[1], "idle", "")
+	)
+
+	require.NoError(r.add(first))
+	result, err := first.transactions.Register("pending")
+	require.NoError(err)
+
+	// a deliberate disconnection fails pending transactions immediately
+	assert.True(r.removeDevice(first, CloseDisconnected))
+	assert.Nil(<-result)
+	assert.Empty(r.parked)
+
+	// a session with nothing pending has nothing to resume
+	require.NoError(r.add(idle))
+	assert.True(r.removeDevice(idle, CloseError))
+	assert.Empty(r.parked)
+
+	second := newResumableDevice(r, testDeviceIDs[0], "second", "first")
+	require.NoError(r.add(second))
+	assert.False(second.transactions == first.transactions)
+}
+
+func testRegistryResumeExpired(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		expiry    = make(chan time.Time, 1)
+		timer     = new(clocktest.MockTimer)
+		fakeClock = new(clocktest.Mock)
+
+		r = newRegistry(registryOptions{
+			ResumptionWindow: time.Minute,
+			Clock:            fakeClock,
+			Measures:         NewMeasures(provider.NewDiscardProvider()),
+		})
+
+		first = newResumableDevice(r, testDeviceIDs[0], "first", "")
+	)
+
+	fakeClock.OnNewTimer(time.Minute, timer).Once()
+	timer.OnC((<-chan time.Time)(expiry))
+
+	require.NoError(r.add(first))
+	result, err := first.transactions.Register("pending")
+	require.NoError(err)
+
+	assert.True(r.removeDevice(first, CloseIdle))
+
+	// the fake clock, not the real one, expires the parked session
+	expiry <- time.Now()
+	assert.Nil(<-result)
+	fakeClock.AssertExpectations(t)
+
+	r.lock.RLock()
+	assert.Empty(r.parked)
+	r.lock.RUnlock()
+
+	second := newResumableDevice(r, testDeviceIDs[0], "second", "first")
+	require.NoError(r.add(second))
+	assert.False(second.transactions == first.transactions)
+}
+
+func testRegistryResumeExpireAll(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		r       = newResumptionRegistry(time.Hour)
+		first   = newResumableDevice(r, testDeviceIDs[0], "first", "")
+	)
+
+	require.NoError(r.add(first))
+	result, err := first.transactions.Register("pending")
+	require.NoError(err)
+
+	assert.True(r.removeDevice(first, CloseError))
+	assert.Equal(1, r.expireAll())
+	assert.Nil(<-result)
+	assert.Empty(r.parked)
+	assert.Zero(r.expireAll())
+}
+
+func TestRegistryResume(t *testing.T) {
+	t.Run("Parked", testRegistryResumeParked)
+	t.Run("Replaced", testRegistryResumeReplaced)
+	t.Run("NotResumable", testRegistryResumeNotResumable)
+	t.Run("Expired", testRegistryResumeExpired)
+	t.Run("ExpireAll", testRegistryResumeExpireAll)
+}
+
+// routeResumable routes a transaction to the first test device, reporting the outcome on the returned channel
+func routeResumable(manager Manager) <-chan error {
+	routed := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := manager.Route((&Request{
+			Message: &wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:server.com",
+				Destination:     string(testDeviceIDs[0]),
+				TransactionUUID: "resume-test",
+			},
+		}).WithContext(ctx))
+
+		routed <- err
+	}()
+
+	return routed
+}
+
+// awaitLen waits for a manager to have the given number of devices
+func awaitLen(manager Manager, expected int) {
+	for manager.Len() != expected {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testManagerResumeSession(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{ResumptionWindow: 5 * time.Second})
+	)
+
+	defer server.Close()
+
+	first, response, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	token := response.Header.Get(ResumptionTokenHeader)
+	require.NotEmpty(token)
+	awaitLen(manager, 1)
+
+	routed := routeResumable(manager)
+	_, _, err = first.ReadMessage()
+	require.NoError(err)
+
+	// the link drops after the request was delivered but before the device could respond
+	first.Close()
+	awaitLen(manager, 0)
+
+	second, response, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, http.Header{ResumptionTokenHeader: []string{token}})
+	require.NoError(err)
+	defer second.Close()
+	assert.NotEmpty(response.Header.Get(ResumptionTokenHeader))
+	assert.NotEqual(token, response.Header.Get(ResumptionTokenHeader))
+	awaitLen(manager, 1)
+
+	require.NoError(second.WriteMessage(websocket.BinaryMessage, wrp.MustEncode(
+		&wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          string(testDeviceIDs[0]),
+			Destination:     "dns:server.com",
+			TransactionUUID: "resume-test",
+		},
+		wrp.Msgpack,
+	)))
+
+	assert.NoError(<-routed)
+}
+
+func testManagerResumeExpired(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{ResumptionWindow: 50 * time.Millisecond})
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	awaitLen(manager, 1)
+
+	routed := routeResumable(manager)
+	_, _, err = connection.ReadMessage()
+	require.NoError(err)
+
+	connection.Close()
+	assert.Equal(ErrorDeviceClosed, <-routed)
+}
+
+func testManagerResumeDisabled(t *testing.T) {
+	var (
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{})
+	)
+
+	defer server.Close()
+
+	connection, response, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+	awaitLen(manager, 1)
+
+	assert.Empty(t, response.Header.Get(ResumptionTokenHeader))
+}
+
+func TestManagerResume(t *testing.T) {
+	t.Run("Session", testManagerResumeSession)
+	t.Run("Expired", testManagerResumeExpired)
+	t.Run("Disabled", testManagerResumeDisabled)
+}
@@ -0,0 +1,64 @@
+package device
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// logSampler decides which inbound messages are logged when Options.LogSampleRate is set.  Each read pump owns
+// its own logSampler, which uses a small xorshift generator rather than math/rand:  the global math/rand source
+// is guarded by a mutex shared by every pump, while a rand.Rand per device would cost several kilobytes of state.
+type logSampler struct {
+	threshold uint64
+	always    bool
+	state     uint64
+}
+
+// newLogSampler produces a logSampler for the given rate.  A nonpositive rate disables sampling, in which case
+// this function returns nil.  The seed must be nonzero.
+func newLogSampler(rate float64, seed uint64) *logSampler {
+	if !(rate > 0) {
+		return nil
+	}
+
+	if rate >= 1 {
+		return &logSampler{always: true}
+	}
+
+	return &logSampler{
+		threshold: uint64(rate * math.MaxUint64),
+		state:     seed,
+	}
+}
+
+// sample tests if the next message should be logged.  This method is not safe for concurrent use.
+func (ls *logSampler) sample() bool {
+	if ls == nil {
+		return false
+	} else if ls.always {
+		return true
+	}
+
+	ls.state ^= ls.state << 13
+	ls.state ^= ls.state >> 7
+	ls.state ^= ls.state << 17
+	return ls.state < ls.threshold
+}
+
+// sampleSeeds produces distinct, nonzero seeds for each read pump's logSampler and each write pump's ping jitter
+type sampleSeeds struct {
+	next uint64
+}
+
+// seed returns the next seed, using the splitmix64 finalizer so that consecutive seeds are uncorrelated
+func (ss *sampleSeeds) seed() uint64 {
+	z := atomic.AddUint64(&ss.next, 0x9e3779b97f4a7c15)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	if z == 0 {
+		return 1
+	}
+
+	return z
+}
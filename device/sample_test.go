@@ -0,0 +1,134 @@
+package device
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLogSamplerDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, rate := range []float64{0.0, -1.0} {
+		ls := newLogSampler(rate, 1)
+		assert.Nil(ls)
+		assert.False(ls.sample())
+	}
+}
+
+func testLogSamplerAlways(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, rate := range []float64{1.0, 2.0} {
+		ls := newLogSampler(rate, 1)
+		for i := 0; i < 100; i++ {
+			assert.True(ls.sample())
+		}
+	}
+}
+
+func testLogSamplerRate(t *testing.T) {
+	const samples = 100000
+
+	var (
+		assert  = assert.New(t)
+		ls      = newLogSampler(0.1, new(sampleSeeds).seed())
+		sampled = 0
+	)
+
+	for i := 0; i < samples; i++ {
+		if ls.sample() {
+			sampled++
+		}
+	}
+
+	assert.InDelta(0.1, float64(sampled)/samples, 0.01)
+}
+
+func testLogSamplerSeeds(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		ss     = new(sampleSeeds)
+		seen   = make(map[uint64]bool)
+	)
+
+	for i := 0; i < 1000; i++ {
+		seed := ss.seed()
+		assert.NotZero(seed)
+		assert.False(seen[seed])
+		seen[seed] = true
+	}
+}
+
+func TestLogSampler(t *testing.T) {
+	t.Run("Disabled", testLogSamplerDisabled)
+	t.Run("Always", testLogSamplerAlways)
+	t.Run("Rate", testLogSamplerRate)
+	t.Run("Seeds", testLogSamplerSeeds)
+}
+
+func TestManagerLogSample(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		sampled = make(chan interface{}, 10)
+
+		logger = log.LoggerFunc(func(keyvals ...interface{}) error {
+			for i := 0; i+1 < len(keyvals); i += 2 {
+				if keyvals[i] == logging.MessageKey() && keyvals[i+1] == "sampled inbound message" {
+					sampled <- keyvals[len(keyvals)-1]
+				}
+			}
+
+			return nil
+		})
+
+		m = NewManager(&Options{
+			Logger:           logger,
+			LogSampleRate:    1.0,
+			RedactLogSamples: true,
+		})
+
+		id      = testDeviceIDs[0]
+		inbound = &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      string(id),
+			Destination: "event:test",
+			Payload:     []byte("secret"),
+		}
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	_, err = lp.Connect(httptest.NewRecorder(), WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	require.NoError(err)
+
+	response := httptest.NewRecorder()
+	lp.ServeHTTP(
+		response,
+		WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", bytes.NewReader(wrp.MustEncode(inbound, wrp.Msgpack)))),
+	)
+
+	require.Equal(http.StatusAccepted, response.Code)
+
+	select {
+	case logged := <-sampled:
+		message, ok := logged.(*wrp.Message)
+		require.True(ok)
+		assert.Equal(inbound.Destination, message.Destination)
+		assert.Empty(message.Payload)
+	case <-time.After(5 * time.Second):
+		assert.Fail("the inbound message was not sampled")
+	}
+
+	m.DisconnectAll()
+}
@@ -0,0 +1,63 @@
+package device
+
+import "sync/atomic"
+
+// SessionSelection determines which session Route delivers to when a device ID has duplicate sessions,
+// which only occurs under DuplicateAllowBoth.  Sessions that are shutting down are never selected
+// while another session is open.
+type SessionSelection string
+
+const (
+	// SelectFirst always routes to the most recently connected session, which is the first match for an ID.
+	// This is the default, and it is the same session returned by Registry.Get.
+	SelectFirst SessionSelection = "first"
+
+	// SelectRoundRobin rotates requests across an ID's open sessions.  The rotation is shared by all IDs,
+	// so the balance for any one ID is approximate when many IDs are routed to concurrently.
+	SelectRoundRobin SessionSelection = "round-robin"
+
+	// SelectLeastTransactions routes to the open session with the fewest transactions awaiting a response,
+	// preferring the most recently connected session in the event of a tie.
+	SelectLeastTransactions SessionSelection = "least-transactions"
+)
+
+// sessionSelector chooses a device from a nonempty slice of open sessions, ordered from most to least
+// recently connected.  Implementations must be safe for concurrent use.
+type sessionSelector func([]*device) *device
+
+func selectFirst(sessions []*device) *device {
+	return sessions[0]
+}
+
+// roundRobinSelector produces a sessionSelector that rotates through sessions using a shared counter
+func roundRobinSelector() sessionSelector {
+	var next uint64
+	return func(sessions []*device) *device {
+		n := atomic.AddUint64(&next, 1) - 1
+		return sessions[n%uint64(len(sessions))]
+	}
+}
+
+func selectLeastTransactions(sessions []*device) *device {
+	selected, least := sessions[0], sessions[0].transactions.Len()
+	for _, d := range sessions[1:] {
+		if pending := d.transactions.Len(); pending < least {
+			selected, least = d, pending
+		}
+	}
+
+	return selected
+}
+
+// newSessionSelector returns the sessionSelector for the given strategy.  Unrecognized strategies
+// behave as SelectFirst.
+func newSessionSelector(s SessionSelection) sessionSelector {
+	switch s {
+	case SelectRoundRobin:
+		return roundRobinSelector()
+	case SelectLeastTransactions:
+		return selectLeastTransactions
+	default:
+		return selectFirst
+	}
+}
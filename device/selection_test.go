@@ -0,0 +1,147 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSelectionRegistry creates an allow-both registry holding count sessions for the same ID.  The
+// sessions are returned from most to least recently connected.
+func newSelectionRegistry(t *testing.T, s SessionSelection, count int) (*registry, []*device) {
+	var (
+		require = require.New(t)
+		logger  = logging.NewTestLogger(nil, t)
+		now     = time.Now()
+
+		r = newRegistry(registryOptions{
+			Logger:           logger,
+			DuplicatePolicy:  DuplicateAllowBoth,
+			SessionSelection: s,
+			Measures:         NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+		})
+
+		sessions = make([]*device, count)
+	)
+
+	for i := count - 1; i >= 0; i-- {
+		sessions[i] = newDevice(deviceOptions{ID: ID("test"), Logger: logger, ConnectedAt: now.Add(time.Duration(count-i) * time.Second)})
+		require.NoError(r.add(sessions[i]))
+	}
+
+	return r, sessions
+}
+
+func testRegistryRouteSingle(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		r, _   = newSelectionRegistry(t, SelectRoundRobin, 0)
+		d      = newDevice(deviceOptions{ID: ID("single")})
+	)
+
+	_, ok := r.route(ID("single"))
+	assert.False(ok)
+
+	assert.NoError(r.add(d))
+	for i := 0; i < 3; i++ {
+		selected, ok := r.route(ID("single"))
+		assert.True(ok)
+		assert.True(d == selected)
+	}
+}
+
+func testRegistryRouteFirst(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		r, sessions = newSelectionRegistry(t, "", 3)
+	)
+
+	for i := 0; i < 3; i++ {
+		selected, ok := r.route(ID("test"))
+		assert.True(ok)
+		assert.True(sessions[0] == selected)
+	}
+
+	// a closing session is skipped
+	sessions[0].requestClose(CloseDisconnected)
+	selected, ok := r.route(ID("test"))
+	assert.True(ok)
+	assert.True(sessions[1] == selected)
+}
+
+func testRegistryRouteRoundRobin(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		r, sessions = newSelectionRegistry(t, SelectRoundRobin, 3)
+		counts      = make(map[*device]int)
+	)
+
+	for i := 0; i < 30; i++ {
+		selected, ok := r.route(ID("test"))
+		assert.True(ok)
+		counts[selected]++
+	}
+
+	for _, d := range sessions {
+		assert.Equal(10, counts[d])
+	}
+
+	sessions[1].requestClose(CloseDisconnected)
+	for i := 0; i < 10; i++ {
+		selected, _ := r.route(ID("test"))
+		assert.False(sessions[1] == selected)
+	}
+}
+
+func testRegistryRouteLeastTransactions(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		r, sessions = newSelectionRegistry(t, SelectLeastTransactions, 3)
+	)
+
+	// ties favor the most recent session
+	selected, _ := r.route(ID("test"))
+	assert.True(sessions[0] == selected)
+
+	_, err := sessions[0].transactions.Register("a")
+	require.NoError(err)
+	_, err = sessions[0].transactions.Register("b")
+	require.NoError(err)
+	_, err = sessions[1].transactions.Register("c")
+	require.NoError(err)
+
+	selected, _ = r.route(ID("test"))
+	assert.True(sessions[2] == selected)
+
+	sessions[2].requestClose(CloseDisconnected)
+	selected, _ = r.route(ID("test"))
+	assert.True(sessions[1] == selected)
+}
+
+func testRegistryRouteAllClosed(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		r, sessions = newSelectionRegistry(t, SelectRoundRobin, 2)
+	)
+
+	for _, d := range sessions {
+		d.requestClose(CloseDisconnected)
+	}
+
+	selected, ok := r.route(ID("test"))
+	assert.True(ok)
+	assert.True(sessions[0] == selected)
+}
+
+func TestRegistryRoute(t *testing.T) {
+	t.Run("Single", testRegistryRouteSingle)
+	t.Run("First", testRegistryRouteFirst)
+	t.Run("RoundRobin", testRegistryRouteRoundRobin)
+	t.Run("LeastTransactions", testRegistryRouteLeastTransactions)
+	t.Run("AllClosed", testRegistryRouteAllClosed)
+}
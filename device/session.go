@@ -0,0 +1,33 @@
+package device
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// sessionPrefix distinguishes the session IDs generated by this process from those of other instances
+	sessionPrefix = newSessionPrefix()
+
+	// sessionCounter is the sequence of session IDs generated by this process
+	sessionCounter uint64
+)
+
+func newSessionPrefix() string {
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		// fall back to the clock, which is still unique enough to tell instances apart
+		binary.BigEndian.PutUint64(seed[:], uint64(time.Now().UnixNano()))
+	}
+
+	return strconv.FormatUint(binary.BigEndian.Uint64(seed[:]), 36)
+}
+
+// newSessionID produces an identifier for a single device connection.  Session IDs are never reused
+// within a process, even when a device reconnects with the same ID.
+func newSessionID() string {
+	return sessionPrefix + "-" + strconv.FormatUint(atomic.AddUint64(&sessionCounter, 1), 36)
+}
@@ -0,0 +1,24 @@
+package device
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSessionID(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		seen   = make(map[string]bool)
+	)
+
+	for i := 0; i < 1000; i++ {
+		sessionID := newSessionID()
+		assert.True(strings.HasPrefix(sessionID, sessionPrefix+"-"))
+		assert.False(seen[sessionID])
+		seen[sessionID] = true
+	}
+
+	assert.NotEqual(newSessionPrefix(), sessionPrefix)
+}
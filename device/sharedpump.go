@@ -0,0 +1,140 @@
+package device
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Comcast/webpa-common/clock"
+)
+
+// sharedPumpLinger is how long an on-demand write pump waits without work before it parks
+const sharedPumpLinger = time.Second
+
+const (
+	pumpNotStarted int32 = iota
+	pumpParked
+	pumpRunning
+	pumpExited
+)
+
+// sharedPump implements Options.SharedPumps for a single device.  The device's read pump is the only goroutine
+// that persists for the life of its connection.  A write pump is started on demand, whenever there is something
+// to write, and parks, exiting its goroutine, once it has lingered without work.  Pings and heartbeats are driven
+// by clock.AfterFunc timers, which consume no goroutine while waiting on the system clock.
+//
+// Anything that gives the write pump work must call wake afterwards.  At most one write pump runs at a time, and
+// a parking pump rechecks for work, so no wake is ever lost.  A nil *sharedPump does nothing, as dedicated write
+// pumps never park.
+type sharedPump struct {
+	state int32
+
+	// start spawns a write pump goroutine, and done is called once a write pump has exited for good.  Both are
+	// set before the state leaves pumpNotStarted.
+	start func()
+	done  func()
+
+	pings           chan time.Time
+	pingPeriod      time.Duration
+	pingTimer       clock.Timer
+	heartbeats      chan time.Time
+	heartbeatPeriod time.Duration
+	heartbeatTimer  clock.Timer
+}
+
+// begin arms this pump's timers on the given clock and allows write pumps to be started, starting one at once if
+// the device already has work
+func (sp *sharedPump) begin(d *device, c clock.Interface, start, done func(), pingPeriod, heartbeatPeriod time.Duration) {
+	sp.start, sp.done = start, done
+	sp.pingPeriod, sp.pings = pingPeriod, make(chan time.Time, 1)
+	sp.pingTimer = clock.AfterFunc(c, pingPeriod, sp.signal(c, sp.pings))
+	if heartbeatPeriod > 0 {
+		sp.heartbeatPeriod, sp.heartbeats = heartbeatPeriod, make(chan time.Time, 1)
+		sp.heartbeatTimer = clock.AfterFunc(c, heartbeatPeriod, sp.signal(c, sp.heartbeats))
+	}
+
+	atomic.StoreInt32(&sp.state, pumpParked)
+	if sp.pending(d) {
+		sp.wake()
+	}
+}
+
+// signal returns a timer function that delivers a tick to the write pump, waking it if necessary
+func (sp *sharedPump) signal(c clock.Interface, ticks chan time.Time) func() {
+	return func() {
+		select {
+		case ticks <- c.Now():
+		default:
+		}
+
+		sp.wake()
+	}
+}
+
+// wake ensures that a write pump is running, starting one if this pump is parked
+func (sp *sharedPump) wake() {
+	if sp != nil && atomic.CompareAndSwapInt32(&sp.state, pumpParked, pumpRunning) {
+		sp.start()
+	}
+}
+
+// park is called by a running write pump that has lingered without work.  If this method returns true, the
+// write pump must exit.  Otherwise, work arrived while parking and the write pump must keep running.
+func (sp *sharedPump) park(d *device) bool {
+	atomic.StoreInt32(&sp.state, pumpParked)
+	if !sp.pending(d) {
+		return true
+	}
+
+	// a wake may have already started another write pump for this work
+	return !atomic.CompareAndSwapInt32(&sp.state, pumpParked, pumpRunning)
+}
+
+// pending tests if the write pump has anything to react to
+func (sp *sharedPump) pending(d *device) bool {
+	if len(d.urgent) > 0 || len(d.closeFrames) > 0 || len(d.probes.queue()) > 0 || len(sp.pings) > 0 || len(sp.heartbeats) > 0 {
+		return true
+	}
+
+	if len(d.messages) > 0 && d.credits.ready() {
+		return true
+	}
+
+	select {
+	case <-d.shutdown:
+		return true
+	case <-d.departing:
+		return true
+	default:
+		return false
+	}
+}
+
+// pinged rearms the ping timer after the write pump consumes a tick
+func (sp *sharedPump) pinged() {
+	if sp != nil {
+		sp.pingTimer.Reset(sp.pingPeriod)
+	}
+}
+
+// heartbeat rearms the heartbeat timer after the write pump consumes a tick
+func (sp *sharedPump) heartbeat() {
+	if sp != nil {
+		sp.heartbeatTimer.Reset(sp.heartbeatPeriod)
+	}
+}
+
+// exit is called by a write pump that is exiting for good, once its cleanup is complete.  After this method
+// is called, wake does nothing.
+func (sp *sharedPump) exit() {
+	if sp == nil {
+		return
+	}
+
+	atomic.StoreInt32(&sp.state, pumpExited)
+	sp.pingTimer.Stop()
+	if sp.heartbeatTimer != nil {
+		sp.heartbeatTimer.Stop()
+	}
+
+	sp.done()
+}
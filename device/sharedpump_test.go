@@ -0,0 +1,251 @@
+package device
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/clock"
+	"github.com/Comcast/webpa-common/clock/clocktest"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSharedPumpNil(t *testing.T) {
+	var sp *sharedPump
+	assert.NotPanics(t, func() {
+		sp.wake()
+		sp.pinged()
+		sp.heartbeat()
+		sp.exit()
+	})
+}
+
+func testSharedPumpLifecycle(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+		sp     = new(sharedPump)
+
+		starts int
+		done   bool
+	)
+
+	// nothing is started before begin
+	sp.wake()
+
+	sp.begin(d, clock.System(), func() { starts++ }, func() { done = true }, time.Hour, 0)
+	assert.Zero(starts, "no write pump should start without work")
+	assert.Equal(pumpParked, atomic.LoadInt32(&sp.state))
+
+	sp.wake()
+	sp.wake()
+	assert.Equal(1, starts, "only one write pump should run at a time")
+	assert.True(sp.park(d))
+	assert.Equal(pumpParked, atomic.LoadInt32(&sp.state))
+
+	// work that arrives while parking keeps the write pump running
+	sp.wake()
+	d.messages <- &envelope{request: new(Request)}
+	assert.False(sp.park(d))
+	assert.Equal(pumpRunning, atomic.LoadInt32(&sp.state))
+
+	sp.exit()
+	assert.True(done)
+	sp.wake()
+	assert.Equal(2, starts)
+	assert.Equal(pumpExited, atomic.LoadInt32(&sp.state))
+}
+
+func testSharedPumpBeginPending(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+		sp     = new(sharedPump)
+		starts int
+	)
+
+	d.requestClose(CloseDisconnected)
+	sp.begin(d, clock.System(), func() { starts++ }, func() {}, time.Hour, time.Hour)
+	assert.Equal(1, starts, "a write pump should start for work that arrived before begin")
+	sp.exit()
+}
+
+func testSharedPumpClock(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(deviceOptions{ID: testDeviceIDs[0]})
+		sp     = new(sharedPump)
+		starts = make(chan struct{}, 1)
+
+		pings     = make(chan time.Time, 1)
+		pingTimer = new(clocktest.MockTimer)
+		fakeClock = new(clocktest.Mock)
+		now       = time.Now()
+	)
+
+	fakeClock.OnNewTimer(time.Hour, pingTimer).Once()
+	fakeClock.OnNow(now)
+	pingTimer.OnC((<-chan time.Time)(pings))
+	pingTimer.OnStop(false).Once()
+
+	sp.begin(d, fakeClock, func() { starts <- struct{}{} }, func() {}, time.Hour, 0)
+
+	// the fake clock, not the real one, drives pings
+	pings <- now
+	select {
+	case <-starts:
+	case <-time.After(5 * time.Second):
+		assert.Fail("a ping tick did not start a write pump")
+	}
+
+	assert.Equal(now, <-sp.pings)
+	sp.exit()
+	fakeClock.AssertExpectations(t)
+	pingTimer.AssertExpectations(t)
+}
+
+func TestSharedPump(t *testing.T) {
+	t.Run("Nil", testSharedPumpNil)
+	t.Run("Lifecycle", testSharedPumpLifecycle)
+	t.Run("BeginPending", testSharedPumpBeginPending)
+	t.Run("Clock", testSharedPumpClock)
+}
+
+// awaitPumpState waits for a device's shared pump to reach the given state
+func awaitPumpState(t *testing.T, d *device, state int32) {
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&d.pump.state) != state {
+		require.True(t, time.Now().Before(deadline), "the shared pump never reached state %d", state)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testManagerSharedPumpsWrite(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		m, d, c = registerTestDevice(t, &Options{SharedPumps: true})
+	)
+
+	sendErrors := queueMessages(d, "first", "second")
+	m.startPumps(d, c, func() error { return nil })
+	for _, expected := range []string{"first", "second"} {
+		assert.Equal([]string{expected}, readPayloads(t, <-c.outbound))
+		assert.NoError(<-sendErrors)
+	}
+
+	// once the write pump parks, the next send starts another
+	awaitPumpState(t, d, pumpParked)
+	sendError := make(chan error, 1)
+	go func() {
+		_, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Payload: []byte("third")}})
+		sendError <- err
+	}()
+
+	assert.Equal([]string{"third"}, readPayloads(t, <-c.outbound))
+	assert.NoError(<-sendError)
+
+	m.DisconnectAll()
+	awaitPumpState(t, d, pumpExited)
+}
+
+func testManagerSharedPumpsPing(t *testing.T) {
+	var (
+		require = require.New(t)
+
+		m, d, c = registerTestDevice(t, &Options{SharedPumps: true, PingPeriod: 20 * time.Millisecond})
+		pings   = make(chan struct{}, 10)
+	)
+
+	m.startPumps(d, c, func() error {
+		select {
+		case pings <- struct{}{}:
+		default:
+		}
+
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pings:
+		case <-time.After(5 * time.Second):
+			require.Fail("no ping was written")
+		}
+	}
+
+	m.DisconnectAll()
+	awaitPumpState(t, d, pumpExited)
+}
+
+func testManagerSharedPumpsParkedClose(t *testing.T) {
+	var (
+		require = require.New(t)
+
+		m, d, _ = startTestPumps(t, &Options{SharedPumps: true})
+	)
+
+	awaitPumpState(t, d, pumpParked)
+
+	// closing a device with no running write pump starts one to clean up
+	require.Equal(1, m.DisconnectAll())
+	awaitPumpState(t, d, pumpExited)
+	require.True(d.Closed())
+}
+
+func TestManagerSharedPumps(t *testing.T) {
+	t.Run("Write", testManagerSharedPumpsWrite)
+	t.Run("Ping", testManagerSharedPumpsPing)
+	t.Run("ParkedClose", testManagerSharedPumpsParkedClose)
+}
+
+// idleBenchmarkDevices is the number of idle devices connected by BenchmarkIdlePumps
+const idleBenchmarkDevices = 100000
+
+// benchmarkIdlePumps connects idle long-poll devices and logs the goroutines and memory they consume
+func benchmarkIdlePumps(b *testing.B, shared bool) {
+	for i := 0; i < b.N; i++ {
+		m := NewManager(&Options{Logger: log.NewNopLogger(), SharedPumps: shared, MaxDevices: idleBenchmarkDevices}).(*manager)
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		goroutines := runtime.NumGoroutine()
+
+		for j := 0; j < idleBenchmarkDevices; j++ {
+			d := newDevice(deviceOptions{ID: ID(fmt.Sprintf("mac:%012x", j)), Logger: log.NewNopLogger()})
+			if shared {
+				d.pump = new(sharedPump)
+			}
+
+			d.conveyClosure = func() {}
+			if err := m.devices.add(d); err != nil {
+				b.Fatal(err)
+			}
+
+			m.startPumps(d, newLongPollConnection(m.now), func() error { return nil })
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.Logf(
+			"%d idle devices: %d goroutines, %d KiB of stack, %d KiB of heap",
+			idleBenchmarkDevices,
+			runtime.NumGoroutine()-goroutines,
+			(after.StackInuse-before.StackInuse)/1024,
+			(after.HeapInuse-before.HeapInuse)/1024,
+		)
+
+		m.DisconnectAll()
+	}
+}
+
+func BenchmarkIdlePumps(b *testing.B) {
+	b.Run("Dedicated", func(b *testing.B) { benchmarkIdlePumps(b, false) })
+	b.Run("Shared", func(b *testing.B) { benchmarkIdlePumps(b, true) })
+}
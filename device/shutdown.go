@@ -0,0 +1,88 @@
+package device
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Comcast/webpa-common/logging"
+)
+
+// lifecycle tracks whether a Manager has been closed, along with the number of event dispatches in progress
+type lifecycle struct {
+	// inFlight is first to guarantee 64-bit alignment for atomic access
+	inFlight int64
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *lifecycle) isClosed() bool {
+	select {
+	case <-l.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// enter marks the start of a dispatch.  If this lifecycle is closed, this method returns false and
+// the dispatch must not proceed.  Otherwise, exit must be called when the dispatch is done.
+func (l *lifecycle) enter() bool {
+	if l.isClosed() {
+		return false
+	}
+
+	atomic.AddInt64(&l.inFlight, 1)
+	if l.isClosed() {
+		// barging with close, which may already be waiting on in-flight dispatches
+		l.exit()
+		return false
+	}
+
+	return true
+}
+
+func (l *lifecycle) exit() {
+	atomic.AddInt64(&l.inFlight, -1)
+}
+
+// close closes this lifecycle, returning true if this was the first call
+func (l *lifecycle) close() (first bool) {
+	l.once.Do(func() {
+		close(l.closed)
+		first = true
+	})
+
+	return
+}
+
+// awaitIdle blocks until no dispatches are in progress, polling at the given interval
+func (l *lifecycle) awaitIdle(interval time.Duration) {
+	for atomic.LoadInt64(&l.inFlight) > 0 {
+		time.Sleep(interval)
+	}
+}
+
+func (m *manager) Close() error {
+	if !m.lifecycle.close() {
+		return nil
+	}
+
+	count := m.devices.removeAll(CloseShutdown)
+	expired := m.devices.expireAll()
+	logging.Info(m.logger).Log(logging.MessageKey(), "manager closed", "disconnected", count, "expiredSessions", expired)
+	m.lifecycle.awaitIdle(drainPollInterval)
+	m.deliveries.close()
+	return nil
+}
+
+func (m *manager) Closed() <-chan struct{} {
+	return m.lifecycle.closed
+}
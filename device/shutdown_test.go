@@ -0,0 +1,181 @@
+package device
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLifecycleEnter(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		l      = newLifecycle()
+	)
+
+	assert.False(l.isClosed())
+	assert.True(l.enter())
+	assert.Equal(int64(1), atomic.LoadInt64(&l.inFlight))
+
+	assert.True(l.close())
+	assert.False(l.close())
+	assert.True(l.isClosed())
+	assert.False(l.enter())
+	assert.Equal(int64(1), atomic.LoadInt64(&l.inFlight))
+
+	idle := make(chan struct{})
+	go func() {
+		defer close(idle)
+		l.awaitIdle(time.Millisecond)
+	}()
+
+	select {
+	case <-idle:
+		assert.Fail("awaitIdle should block while a dispatch is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.exit()
+	select {
+	case <-idle:
+	case <-time.After(5 * time.Second):
+		assert.Fail("awaitIdle did not return once all dispatches exited")
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	t.Run("Enter", testLifecycleEnter)
+}
+
+func connectLongPollDevice(t *testing.T, lp *LongPollConnector, id ID) (Interface, *httptest.ResponseRecorder, error) {
+	response := httptest.NewRecorder()
+	d, err := lp.Connect(response, WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	return d, response, err
+}
+
+func testManagerCloseRejectsConnect(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(nil)
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	d, _, err := connectLongPollDevice(t, lp, testDeviceIDs[0])
+	require.NoError(err)
+
+	select {
+	case <-m.Closed():
+		assert.Fail("Closed should not be signaled before Close")
+	default:
+	}
+
+	assert.NoError(m.Close())
+	assert.True(d.Closed())
+	assert.Zero(m.Len())
+
+	select {
+	case <-m.Closed():
+	default:
+		assert.Fail("Closed should be signaled after Close")
+	}
+
+	actual, response, err := connectLongPollDevice(t, lp, testDeviceIDs[1])
+	assert.Nil(actual)
+	assert.Equal(ErrorManagerClosed, err)
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.Zero(m.Len())
+
+	// subsequent calls are no-ops
+	assert.NoError(m.Close())
+}
+
+func testManagerCloseInFlightDispatch(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		dispatching = make(chan struct{})
+		release     = make(chan struct{})
+		events      = make(chan EventType, 10)
+
+		m Manager
+	)
+
+	m = NewManager(&Options{
+		Listeners: []Listener{
+			func(e *Event) {
+				events <- e.Type
+				if e.Type == Connect {
+					close(dispatching)
+					<-release
+
+					// a listener can observe that the manager was closed while it was running
+					select {
+					case <-m.Closed():
+					default:
+						assert.Fail("Closed should be signaled while Close waits on this listener")
+					}
+				}
+			},
+		},
+	})
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	connected := make(chan Interface, 1)
+	go func() {
+		d, _, _ := connectLongPollDevice(t, lp, testDeviceIDs[0])
+		connected <- d
+	}()
+
+	<-dispatching
+	closed := make(chan error, 1)
+	go func() {
+		closed <- m.Close()
+	}()
+
+	for {
+		select {
+		case <-m.Closed():
+		case <-time.After(time.Millisecond):
+			continue
+		}
+
+		break
+	}
+
+	select {
+	case <-closed:
+		assert.Fail("Close should wait on in-flight dispatches")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-closed:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		require.Fail("Close did not return once the in-flight dispatch completed")
+	}
+
+	// no events, not even Disconnect, are dispatched after Close
+	d := <-connected
+	require.NotNil(d)
+	assert.True(d.Closed())
+	assert.Equal(Connect, <-events)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(events)
+}
+
+func TestManagerClose(t *testing.T) {
+	t.Run("RejectsConnect", testManagerCloseRejectsConnect)
+	t.Run("InFlightDispatch", testManagerCloseInFlightDispatch)
+}
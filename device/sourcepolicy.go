@@ -0,0 +1,73 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// SourcePolicy validates the Source of each *wrp.Message sent to a device by Route, RouteToSession, or
+// RouteStream, using wrp.ParseLocator.  Messages with a valid Source are sent as given.  Requests that
+// carry some other wrp.Routable, such as those built by SendRaw, are not checked.
+type SourcePolicy struct {
+	// Strict rejects a message whose Source is not a valid locator with an *InvalidSourceError before anything
+	// is enqueued.  If unset, such a message is logged and sent, with its Source rewritten to Gateway if set.
+	Strict bool
+
+	// Gateway is the locator of this gateway, e.g. "dns:talaria.example.com", which replaces an invalid Source
+	// when Strict is unset.  It is used in its canonical form, and is ignored if it is not itself a valid locator.
+	Gateway string
+}
+
+// canonical returns a copy of this policy with its Gateway in canonical form.  A nil *SourcePolicy
+// returns nil.
+func (sp *SourcePolicy) canonical() *SourcePolicy {
+	if sp == nil {
+		return nil
+	}
+
+	clone := *sp
+	if len(clone.Gateway) > 0 {
+		if l, err := wrp.ParseLocator(clone.Gateway); err == nil {
+			clone.Gateway = l.String()
+		} else {
+			clone.Gateway = ""
+		}
+	}
+
+	return &clone
+}
+
+// checkSource applies the manager's SourcePolicy to a request.  The returned request is either the original or,
+// if the Source was rewritten, a shallow copy holding a copy of the message.  The caller's request and message
+// are never modified.
+func (m *manager) checkSource(d *device, request *Request) (*Request, error) {
+	message, ok := request.Message.(*wrp.Message)
+	if !ok || m.sourcePolicy == nil {
+		return request, nil
+	}
+
+	_, err := wrp.ParseLocator(message.Source)
+	if err == nil {
+		return request, nil
+	}
+
+	if m.sourcePolicy.Strict {
+		return nil, &InvalidSourceError{Source: message.Source, Err: err}
+	}
+
+	d.errorLog.Log(logging.MessageKey(), "sending WRP message with an invalid source", "source", message.Source, logging.ErrorKey(), err)
+	if len(m.sourcePolicy.Gateway) == 0 {
+		return request, nil
+	}
+
+	var (
+		rewrittenRequest = *request
+		rewrittenMessage = *message
+	)
+
+	// the message has changed, so it must be encoded again
+	rewrittenMessage.Source = m.sourcePolicy.Gateway
+	rewrittenRequest.Message = &rewrittenMessage
+	rewrittenRequest.Contents = nil
+	return &rewrittenRequest, nil
+}
@@ -0,0 +1,128 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routeSource routes an event with the given source, returning the source the device received
+func routeSource(t *testing.T, m *manager, c *longPollConnection, message *wrp.Message) string {
+	routeErrors := make(chan error, 1)
+	go func() {
+		_, err := m.Route(&Request{Message: message})
+		routeErrors <- err
+	}()
+
+	var actual wrp.Message
+	require.NoError(t, wrp.NewDecoderBytes(<-c.outbound, wrp.Msgpack).Decode(&actual))
+	require.NoError(t, <-routeErrors)
+	return actual.Source
+}
+
+func testSourcePolicyStrict(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m, d, c = startTestPumps(t, &Options{SourcePolicy: &SourcePolicy{Strict: true, Gateway: "dns:gateway.example.com"}})
+	)
+
+	defer m.DisconnectAll()
+
+	for source, cause := range map[string]error{
+		"":                 wrp.ErrInvalidLocator,
+		"nonsense":         wrp.ErrInvalidLocator,
+		"mac:123":          wrp.ErrInvalidLocator,
+		"ftp:example.com":  wrp.ErrUnsupportedScheme,
+		"dns:bad host.com": wrp.ErrInvalidLocator,
+	} {
+		message := &wrp.Message{Type: wrp.SimpleEventMessageType, Source: source, Destination: string(testDeviceIDs[0])}
+		response, err := m.Route(&Request{Message: message})
+		assert.Nil(response)
+		require.IsType(new(InvalidSourceError), err)
+		assert.Equal(ErrorInvalidSource, err.(*InvalidSourceError).Cause())
+		assert.Equal(source, err.(*InvalidSourceError).Source)
+		assert.Equal(cause, err.(*InvalidSourceError).Err)
+		assert.Contains(err.Error(), cause.Error())
+
+		response, err = m.RouteToSession(d.SessionID(), &Request{Message: message})
+		assert.Nil(response)
+		assert.IsType(new(InvalidSourceError), err)
+
+		responses, cancel, err := m.RouteStream(&Request{Message: message})
+		assert.Nil(responses)
+		assert.Nil(cancel)
+		assert.IsType(new(InvalidSourceError), err)
+	}
+
+	// nothing invalid was enqueued
+	assert.Zero(d.Pending())
+
+	// valid sources are sent as given
+	assert.Equal(
+		"MAC:11:22:33:44:55:66/service",
+		routeSource(t, m, c, &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "MAC:11:22:33:44:55:66/service", Destination: string(testDeviceIDs[0])}),
+	)
+}
+
+func testSourcePolicyGateway(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		m, _, c = startTestPumps(t, &Options{SourcePolicy: &SourcePolicy{Gateway: "DNS:gateway.example.com/service"}})
+
+		message = &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "not a locator",
+			Destination: string(testDeviceIDs[0]),
+			Payload:     []byte("rewritten"),
+		}
+	)
+
+	defer m.DisconnectAll()
+
+	assert.Equal("dns:gateway.example.com/service", routeSource(t, m, c, message))
+
+	// the caller's message is not modified
+	assert.Equal("not a locator", message.Source)
+
+	assert.Equal(
+		"dns:device.example.com",
+		routeSource(t, m, c, &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "dns:device.example.com", Destination: string(testDeviceIDs[0])}),
+	)
+}
+
+func testSourcePolicyLenient(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		m, _, c = startTestPumps(t, &Options{SourcePolicy: &SourcePolicy{}})
+	)
+
+	defer m.DisconnectAll()
+
+	assert.Equal(
+		"not a locator",
+		routeSource(t, m, c, &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "not a locator", Destination: string(testDeviceIDs[0])}),
+	)
+}
+
+func testSourcePolicyUnset(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		m, _, c = startTestPumps(t, nil)
+	)
+
+	defer m.DisconnectAll()
+
+	assert.Empty(
+		routeSource(t, m, c, &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(testDeviceIDs[0])}),
+	)
+}
+
+func TestSourcePolicy(t *testing.T) {
+	t.Run("Strict", testSourcePolicyStrict)
+	t.Run("Gateway", testSourcePolicyGateway)
+	t.Run("Lenient", testSourcePolicyLenient)
+	t.Run("Unset", testSourcePolicyUnset)
+}
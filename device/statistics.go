@@ -48,6 +48,26 @@ type Statistics interface {
 
 	// UpTime computes the duration for which the device has been connected
 	UpTime() time.Duration
+
+	// LastActivity returns the time a message was last sent to or received from the device, which is
+	// the connection time until the first message is exchanged
+	LastActivity() time.Time
+
+	// Snapshot returns a consistent, point-in-time copy of these statistics
+	Snapshot() StatisticsSnapshot
+}
+
+// StatisticsSnapshot is a point-in-time copy of a device's Statistics.  Unlike Statistics, a snapshot
+// never changes and may be retained indefinitely.
+type StatisticsSnapshot struct {
+	BytesReceived    int
+	BytesSent        int
+	MessagesReceived int
+	MessagesSent     int
+	Duplications     int
+	ConnectedAt      time.Time
+	UpTime           time.Duration
+	LastActivity     time.Time
 }
 
 // NewStatistics creates a Statistics instance with the given connection time
@@ -62,6 +82,7 @@ func NewStatistics(now func() time.Time, connectedAt time.Time) Statistics {
 		now:                  now,
 		connectedAt:          connectedAt,
 		formattedConnectedAt: connectedAt.Format(time.RFC3339Nano),
+		lastActivity:         connectedAt,
 	}
 }
 
@@ -74,6 +95,7 @@ type statistics struct {
 	messagesReceived int
 	messagesSent     int
 	duplications     int
+	lastActivity     time.Time
 
 	now                  func() time.Time
 	connectedAt          time.Time
@@ -119,6 +141,7 @@ func (s *statistics) MessagesReceived() int {
 func (s *statistics) AddMessagesReceived(delta int) {
 	s.lock.Lock()
 	s.messagesReceived += delta
+	s.lastActivity = s.now().UTC()
 	s.lock.Unlock()
 }
 
@@ -133,6 +156,7 @@ func (s *statistics) MessagesSent() int {
 func (s *statistics) AddMessagesSent(delta int) {
 	s.lock.Lock()
 	s.messagesSent += delta
+	s.lastActivity = s.now().UTC()
 	s.lock.Unlock()
 }
 
@@ -158,6 +182,31 @@ func (s *statistics) UpTime() time.Duration {
 	return s.now().Sub(s.connectedAt)
 }
 
+func (s *statistics) LastActivity() time.Time {
+	s.lock.RLock()
+	var result = s.lastActivity
+	s.lock.RUnlock()
+
+	return result
+}
+
+func (s *statistics) Snapshot() StatisticsSnapshot {
+	s.lock.RLock()
+	snapshot := StatisticsSnapshot{
+		BytesReceived:    s.bytesReceived,
+		BytesSent:        s.bytesSent,
+		MessagesReceived: s.messagesReceived,
+		MessagesSent:     s.messagesSent,
+		Duplications:     s.duplications,
+		ConnectedAt:      s.connectedAt,
+		UpTime:           s.UpTime(),
+		LastActivity:     s.lastActivity,
+	}
+
+	s.lock.RUnlock()
+	return snapshot
+}
+
 func (s *statistics) String() string {
 	if data, err := s.MarshalJSON(); err == nil {
 		return string(data)
@@ -78,6 +78,7 @@ func testStatisticsInitialStateCustomNow(t *testing.T) {
 	assert.Zero(statistics.Duplications())
 	assert.Equal(expectedConnectedAt.UTC(), statistics.ConnectedAt())
 	assert.Equal(expectedUpTime, statistics.UpTime())
+	assert.Equal(expectedConnectedAt.UTC(), statistics.LastActivity())
 
 	data, err := statistics.MarshalJSON()
 	require.NotEmpty(data)
@@ -93,6 +94,47 @@ func testStatisticsInitialStateCustomNow(t *testing.T) {
 	)
 }
 
+func testStatisticsSnapshot(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		connectedAt = time.Now()
+		upTime      = 17 * time.Minute
+
+		statistics = NewStatistics(
+			func() time.Time {
+				return connectedAt.Add(upTime)
+			},
+			connectedAt,
+		)
+	)
+
+	statistics.AddBytesReceived(1)
+	statistics.AddBytesSent(2)
+	statistics.AddMessagesReceived(3)
+	statistics.AddMessagesSent(4)
+	statistics.AddDuplications(5)
+
+	snapshot := statistics.Snapshot()
+	assert.Equal(
+		StatisticsSnapshot{
+			BytesReceived:    1,
+			BytesSent:        2,
+			MessagesReceived: 3,
+			MessagesSent:     4,
+			Duplications:     5,
+			ConnectedAt:      connectedAt.UTC(),
+			UpTime:           upTime,
+			LastActivity:     connectedAt.Add(upTime).UTC(),
+		},
+		snapshot,
+	)
+
+	// a snapshot is unaffected by later updates
+	statistics.AddMessagesSent(1)
+	assert.Equal(4, snapshot.MessagesSent)
+	assert.Equal(5, statistics.MessagesSent())
+}
+
 func testStatisticsConcurrency(t *testing.T) {
 	var (
 		assert              = assert.New(t)
@@ -165,5 +207,6 @@ func TestStatistics(t *testing.T) {
 		t.Run("CustomNow", testStatisticsInitialStateCustomNow)
 	})
 
+	t.Run("Snapshot", testStatisticsSnapshot)
 	t.Run("Concurrency", testStatisticsConcurrency)
 }
@@ -0,0 +1,71 @@
+package device
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ManagerStats is a point-in-time summary of a Manager, as returned by Manager.Stats.  The device count and the
+// pending and queued totals are taken together, so they are consistent with each other.
+type ManagerStats struct {
+	// Devices is the count of connected devices, including any duplicate sessions
+	Devices int `json:"devices"`
+
+	// Capacity is the maximum number of devices, as configured by Options.MaxDevices.  Zero means unlimited.
+	Capacity int `json:"capacity"`
+
+	// Accepting indicates whether the Manager accepts new connections, which is true until it is closed
+	Accepting bool `json:"accepting"`
+
+	// PendingTransactions is the total number of transactions awaiting a response across all devices
+	PendingTransactions int `json:"pendingTransactions"`
+
+	// QueuedMessages is the total number of messages waiting to be written across all devices
+	QueuedMessages int `json:"queuedMessages"`
+
+	// Uptime is how long ago the Manager was created.  It is marshaled to JSON as a string, e.g. "1h2m3s".
+	Uptime time.Duration `json:"uptime"`
+}
+
+// MarshalJSON writes the Uptime in the same human-readable form as device statistics
+func (ms ManagerStats) MarshalJSON() ([]byte, error) {
+	type plain ManagerStats
+	return json.Marshal(struct {
+		plain
+		Uptime string `json:"uptime"`
+	}{plain(ms), ms.Uptime.String()})
+}
+
+// stats totals the devices in this registry, along with their pending transactions and queued messages.  The
+// registry is read locked throughout, so no device is counted that is not also totaled, or vice versa.
+func (r *registry) stats() (devices, pendingTransactions, queuedMessages int) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	devices = r.size
+	total := func(d *device) {
+		pendingTransactions += d.PendingTransactions()
+		queuedMessages += d.Pending()
+	}
+
+	for id, d := range r.data {
+		total(d)
+		for _, session := range r.sessions[id] {
+			total(session)
+		}
+	}
+
+	return
+}
+
+func (m *manager) Stats() ManagerStats {
+	devices, pendingTransactions, queuedMessages := m.devices.stats()
+	return ManagerStats{
+		Devices:             devices,
+		Capacity:            m.devices.limit,
+		Accepting:           !m.lifecycle.isClosed(),
+		PendingTransactions: pendingTransactions,
+		QueuedMessages:      queuedMessages,
+		Uptime:              m.now().Sub(m.startedAt),
+	}
+}
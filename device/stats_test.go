@@ -0,0 +1,71 @@
+package device
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerStats(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		now     = time.Now()
+		current = func() time.Time { return now }
+
+		m = NewManager(&Options{
+			MaxDevices:      10,
+			DuplicatePolicy: DuplicateAllowBoth,
+			Now:             current,
+		}).(*manager)
+
+		first     = newDevice(deviceOptions{ID: testDeviceIDs[0], Now: current})
+		duplicate = newDevice(deviceOptions{ID: testDeviceIDs[0], Now: current})
+		second    = newDevice(deviceOptions{ID: testDeviceIDs[1], Now: current})
+	)
+
+	assert.Equal(ManagerStats{Capacity: 10, Accepting: true}, m.Stats())
+
+	for _, d := range []*device{first, duplicate, second} {
+		require.NoError(m.devices.add(d))
+	}
+
+	// without pumps, messages stay queued and transactions stay pending
+	_, err := first.transactions.Register("first")
+	require.NoError(err)
+	_, err = duplicate.transactions.Register("duplicate")
+	require.NoError(err)
+	first.messages <- new(envelope)
+	second.messages <- new(envelope)
+	second.messages <- new(envelope)
+
+	now = now.Add(90 * time.Second)
+	stats := m.Stats()
+	assert.Equal(
+		ManagerStats{
+			Devices:             3,
+			Capacity:            10,
+			Accepting:           true,
+			PendingTransactions: 2,
+			QueuedMessages:      3,
+			Uptime:              90 * time.Second,
+		},
+		stats,
+	)
+
+	data, err := json.Marshal(stats)
+	require.NoError(err)
+	assert.JSONEq(
+		`{"devices": 3, "capacity": 10, "accepting": true, "pendingTransactions": 2, "queuedMessages": 3, "uptime": "1m30s"}`,
+		string(data),
+	)
+
+	require.NoError(m.Close())
+	stats = m.Stats()
+	assert.False(stats.Accepting)
+	assert.Zero(stats.Devices)
+	assert.Zero(stats.PendingTransactions)
+}
@@ -0,0 +1,101 @@
+package device
+
+import (
+	"sync"
+
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// stream sends a request whose transaction accepts any number of responses, which are delivered on the returned
+// channel.  The returned cancel function ends the stream, and may be called any number of times.
+func (d *device) stream(request *Request, size int) (<-chan *Response, func(), error) {
+	if d.Closed() {
+		return nil, nil, ErrorDeviceClosed
+	} else if _, _, departing := d.departed(); departing {
+		return nil, nil, ErrorDeviceDisconnecting
+	} else if d.passthrough {
+		return nil, nil, ErrorStreamUnsupported
+	}
+
+	request, err := request.prepareAck()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transactionKey, transactional := request.Transactional()
+	if !transactional {
+		return nil, nil, ErrorInvalidTransactionKey
+	}
+
+	responses, err := d.transactions.RegisterStream(transactionKey, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := d.sendRequest(request); err != nil {
+		d.transactions.Cancel(transactionKey)
+		return nil, nil, err
+	}
+
+	var (
+		cancelled  = make(chan struct{})
+		cancelOnce sync.Once
+		shutdown   = d.shutdown
+	)
+
+	if len(d.resumeToken) > 0 {
+		// as with awaitResponse, a resumable session's stream continues over the resumed connection
+		shutdown = nil
+	}
+
+	go func() {
+		select {
+		case <-cancelled:
+		case <-request.Context().Done():
+		case <-shutdown:
+		}
+
+		d.transactions.Cancel(transactionKey)
+	}()
+
+	return responses, func() { cancelOnce.Do(func() { close(cancelled) }) }, nil
+}
+
+// RouteStream sends a request to the device it is destined for, as Route does, except that the request's
+// transaction is a subscription.  Every transactional message the device sends with the request's transaction
+// UUID is delivered on the returned channel, in order, until the stream ends.  The request must be transactional.
+//
+// A stream ends when the returned cancel function is called, when the request's context is done, or when the
+// device disconnects, unless the device resumes its session.  A stream also ends if the caller falls so far behind
+// that a response arrives while Options.StreamBufferSize responses are already buffered, since the device cannot be
+// made to wait.  In every case the channel is closed once any buffered responses have been received.  Callers must
+// always call the cancel function, typically via defer, to release the stream.
+//
+// Streams are not subject to chains or to the device's circuit breaker.
+func (m *manager) RouteStream(request *Request) (<-chan *Response, func(), error) {
+	destination, err := request.ID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if destination, err = m.idNormalizer(destination); err != nil {
+		return nil, nil, err
+	}
+
+	d, ok := m.devices.route(destination)
+	if !ok {
+		return nil, nil, ErrorDeviceNotFound
+	}
+
+	if message, ok := request.Message.(*wrp.Message); ok && m.validateMessages {
+		if err := message.Valid(); err != nil {
+			return nil, nil, &InvalidMessageError{Err: err}
+		}
+	}
+
+	if request, err = m.checkSource(d, request); err != nil {
+		return nil, nil, err
+	}
+
+	return d.stream(request, m.streamBufferSize)
+}
@@ -0,0 +1,191 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStreamRequest creates a subscription request for the first test device
+func newStreamRequest() *Request {
+	return &Request{
+		Message: &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "dns:server.com",
+			Destination:     string(testDeviceIDs[0]),
+			TransactionUUID: "stream-test",
+		},
+	}
+}
+
+// writeStreamResponse simulates the device sending one response of a stream
+func writeStreamResponse(connection *websocket.Conn, payload string) error {
+	return connection.WriteMessage(websocket.BinaryMessage, wrp.MustEncode(
+		&wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          string(testDeviceIDs[0]),
+			Destination:     "dns:server.com",
+			TransactionUUID: "stream-test",
+			Payload:         []byte(payload),
+		},
+		wrp.Msgpack,
+	))
+}
+
+// awaitClosed receives from a stream until it is closed, returning the number of responses that were drained
+func awaitClosed(t *testing.T, responses <-chan *Response) int {
+	drained := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, open := <-responses:
+			if !open {
+				return drained
+			}
+
+			drained++
+		case <-timeout:
+			assert.Fail(t, "the stream was never closed")
+			return drained
+		}
+	}
+}
+
+func testManagerRouteStreamResponses(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{})
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+	awaitLen(manager, 1)
+
+	responses, cancel, err := manager.RouteStream(newStreamRequest())
+	require.NoError(err)
+	require.NotNil(responses)
+	defer cancel()
+
+	_, _, err = connection.ReadMessage()
+	require.NoError(err)
+
+	for _, payload := range []string{"first", "second", "third"} {
+		require.NoError(writeStreamResponse(connection, payload))
+		response := <-responses
+		require.NotNil(response)
+		assert.Equal(payload, string(response.Message.Payload))
+	}
+
+	d, ok := manager.Get(testDeviceIDs[0])
+	require.True(ok)
+	assert.Equal(1, d.PendingTransactions())
+
+	cancel()
+	cancel()
+	assert.Zero(awaitClosed(t, responses))
+	assert.Zero(d.PendingTransactions())
+}
+
+func testManagerRouteStreamEnds(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{StreamBufferSize: 1})
+	)
+
+	defer server.Close()
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+	awaitLen(manager, 1)
+
+	// the request's context ends the stream
+	ctx, cancelContext := context.WithCancel(context.Background())
+	responses, cancel, err := manager.RouteStream(newStreamRequest().WithContext(ctx))
+	require.NoError(err)
+	defer cancel()
+
+	_, _, err = connection.ReadMessage()
+	require.NoError(err)
+	cancelContext()
+	assert.Zero(awaitClosed(t, responses))
+
+	// a consumer that falls behind the buffer loses the stream, but not what was buffered
+	responses, cancel, err = manager.RouteStream(newStreamRequest())
+	require.NoError(err)
+	defer cancel()
+
+	_, _, err = connection.ReadMessage()
+	require.NoError(err)
+	require.NoError(writeStreamResponse(connection, "buffered"))
+	require.NoError(writeStreamResponse(connection, "overflow"))
+
+	d, ok := manager.Get(testDeviceIDs[0])
+	require.True(ok)
+	for d.PendingTransactions() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(1, awaitClosed(t, responses))
+
+	// a disconnection ends the stream
+	responses, cancel, err = manager.RouteStream(newStreamRequest())
+	require.NoError(err)
+	defer cancel()
+
+	_, _, err = connection.ReadMessage()
+	require.NoError(err)
+	require.True(manager.Disconnect(testDeviceIDs[0]))
+	assert.Zero(awaitClosed(t, responses))
+}
+
+func testManagerRouteStreamErrors(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		manager, server, connectURL = startWebsocketServer(&Options{})
+	)
+
+	defer server.Close()
+
+	_, _, err := manager.RouteStream(newStreamRequest())
+	assert.Equal(ErrorDeviceNotFound, err)
+
+	connection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer connection.Close()
+	awaitLen(manager, 1)
+
+	// a stream needs a transaction UUID to match responses against
+	event := &Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: string(testDeviceIDs[0])}}
+	responses, cancel, err := manager.RouteStream(event)
+	assert.Nil(responses)
+	assert.Nil(cancel)
+	assert.Equal(ErrorInvalidTransactionKey, err)
+
+	_, cancel, err = manager.RouteStream(newStreamRequest())
+	require.NoError(err)
+	defer cancel()
+
+	_, _, err = manager.RouteStream(newStreamRequest())
+	assert.Equal(ErrorTransactionAlreadyRegistered, err)
+}
+
+func TestManagerRouteStream(t *testing.T) {
+	t.Run("Responses", testManagerRouteStreamResponses)
+	t.Run("Ends", testManagerRouteStreamEnds)
+	t.Run("Errors", testManagerRouteStreamErrors)
+}
@@ -0,0 +1,273 @@
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/xmetrics"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// MaxStreamFrameSize is the largest frame payload, in bytes, accepted over a Stream.  Larger frames
+	// are treated as a protocol error, and the device is disconnected.
+	MaxStreamFrameSize = 16 * 1024 * 1024
+
+	// streamFrameHeaderSize is the size of a frame's message type and payload length
+	streamFrameHeaderSize = 5
+
+	// streamFrameInitialBufferSize is the most that is allocated for a frame's payload before any of it is read
+	streamFrameInitialBufferSize = 4096
+)
+
+// Stream is a bidirectional, reliable byte stream that carries a single device's WRP traffic, such as a
+// net.Conn.
+type Stream interface {
+	io.ReadWriteCloser
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// StreamUpgrader establishes a Stream for a connecting device.  An upgrader takes over the HTTP exchange,
+// so it is responsible for writing any error to the response.  The given header holds the response headers
+// negotiated for the device, e.g. CreditWindowHeader, which the upgrader must include in a successful response.
+type StreamUpgrader func(http.ResponseWriter, *http.Request, http.Header) (Stream, error)
+
+// StreamConnector is a Connector which services devices over a generic Stream rather than a websocket, such
+// as a TCP or TLS connection hijacked from the HTTP exchange.
+//
+// Since a Stream has no message boundaries, each message is framed as a single byte holding the websocket
+// message type, followed by the payload length as a 4-byte big-endian integer, followed by the payload.
+// Binary frames carry WRP messages exactly as over a websocket, and ping, pong, and close frames have the
+// same semantics.  This lets a device reuse its websocket message handling over any Stream.
+//
+// Devices connected through this type are registered with the same Manager used to create it, so routing,
+// visiting, disconnection, and events behave identically regardless of transport.
+type StreamConnector struct {
+	manager *manager
+	upgrade StreamUpgrader
+}
+
+// NewStreamConnector creates a StreamConnector that shares devices with the given Manager, which must
+// have been created with NewManager.  The upgrader is required.
+func NewStreamConnector(m Manager, upgrade StreamUpgrader) (*StreamConnector, error) {
+	mgr, ok := m.(*manager)
+	if !ok {
+		return nil, ErrorUnsupportedManager
+	}
+
+	if upgrade == nil {
+		panic("An upgrader is required")
+	}
+
+	return &StreamConnector{
+		manager: mgr,
+		upgrade: upgrade,
+	}, nil
+}
+
+// Connect upgrades the request to a Stream and registers the device identified by the request.  The
+// response header, along with any headers negotiated for the device, is passed to the upgrader.
+func (sc *StreamConnector) Connect(response http.ResponseWriter, request *http.Request, responseHeader http.Header) (Interface, error) {
+	m := sc.manager
+	m.debugLog.Log(logging.MessageKey(), "stream device connect", "url", request.URL)
+	d, cvy, err := m.newDevice(response, request)
+	if err != nil {
+		return nil, err
+	}
+
+	m.enableResumption(d, request)
+	s, err := sc.upgrade(response, request, withResumptionToken(d, withBatchLimit(d, withCreditWindow(d, responseHeader))))
+	if err != nil {
+		d.errorLog.Log(logging.MessageKey(), "failed to establish stream", logging.ErrorKey(), err)
+		d.requestClose(CloseError)
+		return nil, err
+	}
+
+	c := newStreamConnection(s)
+	c.SetReadDeadline(m.readDeadline())
+
+	// stream devices answer pings, so they can be probed on demand
+	d.probes = newProbes()
+
+	if err := m.register(d, cvy); err != nil {
+		c.WriteMessage(websocket.CloseMessage, d.closeReason.frame())
+		c.Close()
+		return nil, err
+	}
+
+	setPongHandler(c, m.measures.Pong, m.readDeadline, func(data string) {
+		d.probes.pong(data, m.now())
+	})
+
+	m.startPumps(d, c, newStreamPinger(c, m.measures.Ping, []byte(d.ID()), m.writeDeadline))
+	return d, nil
+}
+
+func (sc *StreamConnector) Disconnect(id ID) bool {
+	return sc.manager.Disconnect(id)
+}
+
+func (sc *StreamConnector) DisconnectWithReason(id ID, reason CloseReason) bool {
+	return sc.manager.DisconnectWithReason(id, reason)
+}
+
+func (sc *StreamConnector) DisconnectIf(filter func(ID) bool) int {
+	return sc.manager.DisconnectIf(filter)
+}
+
+func (sc *StreamConnector) DisconnectIfAsync(filter func(ID) bool) int {
+	return sc.manager.DisconnectIfAsync(filter)
+}
+
+func (sc *StreamConnector) DisconnectAll() int {
+	return sc.manager.DisconnectAll()
+}
+
+func (sc *StreamConnector) DisconnectPartner(partner string, grace time.Duration) int {
+	return sc.manager.DisconnectPartner(partner, grace)
+}
+
+// newStreamPinger is the analog of NewPinger for stream connections, which cannot write prepared messages
+func newStreamPinger(w Writer, pings xmetrics.Incrementer, data []byte, deadline func() time.Time) func() error {
+	return func() error {
+		if err := w.SetWriteDeadline(deadline()); err != nil {
+			return err
+		}
+
+		if err := w.WriteMessage(websocket.PingMessage, data); err != nil {
+			return err
+		}
+
+		pings.Inc()
+		return nil
+	}
+}
+
+// streamConnection is the Connection implementation which frames messages over a Stream.  Reads are only
+// ever done by a device's read pump, but writes are guarded since pongs are written while reading.
+type streamConnection struct {
+	stream      Stream
+	pongHandler func(string) error
+
+	writeLock sync.Mutex
+	header    [streamFrameHeaderSize]byte
+}
+
+func newStreamConnection(s Stream) *streamConnection {
+	return &streamConnection{
+		stream: s,
+	}
+}
+
+func (c *streamConnection) readFrame() (int, []byte, error) {
+	var header [streamFrameHeaderSize]byte
+	if _, err := io.ReadFull(c.stream, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > MaxStreamFrameSize {
+		return 0, nil, ErrorFrameTooLarge
+	}
+
+	// the payload buffer grows as bytes actually arrive, so a header alone cannot force a large allocation
+	initialSize := length
+	if initialSize > streamFrameInitialBufferSize {
+		initialSize = streamFrameInitialBufferSize
+	}
+
+	data := bytes.NewBuffer(make([]byte, 0, initialSize))
+	if _, err := io.CopyN(data, c.stream, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return 0, nil, err
+	}
+
+	return int(header[0]), data.Bytes(), nil
+}
+
+// ReadMessage returns the next data frame, handling any control frames that precede it.  As with a
+// websocket, a close frame is returned as a *websocket.CloseError.
+func (c *streamConnection) ReadMessage() (int, []byte, error) {
+	for {
+		messageType, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch messageType {
+		case websocket.PingMessage:
+			if err := c.WriteMessage(websocket.PongMessage, data); err != nil {
+				return 0, nil, err
+			}
+
+		case websocket.PongMessage:
+			if c.pongHandler != nil {
+				if err := c.pongHandler(string(data)); err != nil {
+					return 0, nil, err
+				}
+			}
+
+		case websocket.CloseMessage:
+			closeError := &websocket.CloseError{Code: websocket.CloseNoStatusReceived}
+			if len(data) >= 2 {
+				closeError.Code = int(binary.BigEndian.Uint16(data))
+				closeError.Text = string(data[2:])
+			}
+
+			return 0, nil, closeError
+
+		default:
+			return messageType, data, nil
+		}
+	}
+}
+
+func (c *streamConnection) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+// SetPongHandler must be called before reading begins
+func (c *streamConnection) SetPongHandler(h func(string) error) {
+	c.pongHandler = h
+}
+
+func (c *streamConnection) WriteMessage(messageType int, data []byte) error {
+	if len(data) > MaxStreamFrameSize {
+		return ErrorFrameTooLarge
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.header[0] = byte(messageType)
+	binary.BigEndian.PutUint32(c.header[1:], uint32(len(data)))
+	if _, err := c.stream.Write(c.header[:]); err != nil {
+		return err
+	}
+
+	_, err := c.stream.Write(data)
+	return err
+}
+
+// WritePreparedMessage always returns ErrorPreparedMessageUnsupported, as a prepared message's payload cannot
+// be framed.  Stream connections use newStreamPinger rather than NewPinger for this reason.
+func (c *streamConnection) WritePreparedMessage(*websocket.PreparedMessage) error {
+	return ErrorPreparedMessageUnsupported
+}
+
+func (c *streamConnection) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
+}
+
+func (c *streamConnection) Close() error {
+	return c.stream.Close()
+}
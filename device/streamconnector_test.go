@@ -0,0 +1,313 @@
+package device
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeUpgrader returns a StreamUpgrader that hands the server end of a net.Pipe to the connector, along with
+// a streamConnection over the client end which acts as the device
+func pipeUpgrader() (StreamUpgrader, *streamConnection) {
+	server, client := net.Pipe()
+	return func(http.ResponseWriter, *http.Request, http.Header) (Stream, error) {
+		return server, nil
+	}, newStreamConnection(client)
+}
+
+func testNewStreamConnectorUnsupportedManager(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		upgrader, _ = pipeUpgrader()
+	)
+
+	sc, err := NewStreamConnector(struct{ Manager }{}, upgrader)
+	assert.Nil(sc)
+	assert.Equal(ErrorUnsupportedManager, err)
+}
+
+func testNewStreamConnectorMissingUpgrader(t *testing.T) {
+	assert := assert.New(t)
+	assert.Panics(func() {
+		NewStreamConnector(NewManager(nil), nil)
+	})
+}
+
+func testStreamConnectionFraming(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		require        = require.New(t)
+		server, client = net.Pipe()
+		sc             = newStreamConnection(server)
+		cc             = newStreamConnection(client)
+		pongs          = make(chan string, 1)
+	)
+
+	defer sc.Close()
+	defer cc.Close()
+
+	cc.SetPongHandler(func(data string) error {
+		pongs <- data
+		return nil
+	})
+
+	go func() {
+		sc.WriteMessage(websocket.BinaryMessage, []byte("first"))
+		sc.WriteMessage(websocket.PingMessage, []byte("ping"))
+		sc.WriteMessage(websocket.PongMessage, []byte("pong"))
+		sc.WriteMessage(websocket.BinaryMessage, nil)
+		sc.WriteMessage(websocket.CloseMessage, CloseFlood.frame())
+	}()
+
+	messageType, data, err := cc.ReadMessage()
+	require.NoError(err)
+	assert.Equal(websocket.BinaryMessage, messageType)
+	assert.Equal([]byte("first"), data)
+
+	// the ping is answered while the server is blocked writing, so read the pong concurrently
+	serverPongs := make(chan []byte, 1)
+	go func() {
+		messageType, data, _ := sc.readFrame()
+		if messageType == websocket.PongMessage {
+			serverPongs <- data
+		}
+
+		close(serverPongs)
+	}()
+
+	messageType, data, err = cc.ReadMessage()
+	require.NoError(err)
+	assert.Equal(websocket.BinaryMessage, messageType)
+	assert.Empty(data)
+	assert.Equal([]byte("ping"), <-serverPongs)
+	assert.Equal("pong", <-pongs)
+
+	_, _, err = cc.ReadMessage()
+	require.IsType(&websocket.CloseError{}, err)
+	assert.Equal(CloseCodeFlood, err.(*websocket.CloseError).Code)
+	assert.Equal(CloseFlood.Text, err.(*websocket.CloseError).Text)
+}
+
+func testStreamConnectionFrameTooLarge(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		server, client = net.Pipe()
+		sc             = newStreamConnection(server)
+	)
+
+	defer sc.Close()
+	defer client.Close()
+
+	assert.Equal(ErrorFrameTooLarge, sc.WriteMessage(websocket.BinaryMessage, make([]byte, MaxStreamFrameSize+1)))
+
+	go client.Write([]byte{websocket.BinaryMessage, 0xFF, 0xFF, 0xFF, 0xFF})
+	_, _, err := sc.ReadMessage()
+	assert.Equal(ErrorFrameTooLarge, err)
+}
+
+func testStreamConnectionPreparedMessage(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		require        = require.New(t)
+		server, client = net.Pipe()
+		sc             = newStreamConnection(server)
+	)
+
+	defer sc.Close()
+	defer client.Close()
+
+	pm, err := websocket.NewPreparedMessage(websocket.PingMessage, []byte("ping"))
+	require.NoError(err)
+	assert.Equal(ErrorPreparedMessageUnsupported, sc.WritePreparedMessage(pm))
+
+	// a pinger built for websockets reports the failure rather than a successful ping
+	pinger, err := NewPinger(sc, nil, []byte("ping"), func() time.Time { return time.Time{} })
+	require.NoError(err)
+	assert.Equal(ErrorPreparedMessageUnsupported, pinger())
+}
+
+func testStreamConnectionTruncatedFrame(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		server, client = net.Pipe()
+		sc             = newStreamConnection(server)
+	)
+
+	defer sc.Close()
+
+	// a header announcing the largest frame, followed by only a few bytes of payload
+	go func() {
+		client.Write([]byte{websocket.BinaryMessage, 0x01, 0x00, 0x00, 0x00})
+		client.Write([]byte("short"))
+		client.Close()
+	}()
+
+	_, _, err := sc.ReadMessage()
+	assert.Equal(io.ErrUnexpectedEOF, err)
+}
+
+func testStreamConnectorUpgradeError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		require       = require.New(t)
+		expectedError = errors.New("expected")
+		m             = NewManager(&Options{MaxConnectionsPerIP: 1}).(*manager)
+		request       = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "/", nil))
+	)
+
+	sc, err := NewStreamConnector(m, func(http.ResponseWriter, *http.Request, http.Header) (Stream, error) {
+		return nil, expectedError
+	})
+
+	require.NoError(err)
+	d, err := sc.Connect(httptest.NewRecorder(), request, nil)
+	assert.Nil(d)
+	assert.Equal(expectedError, err)
+	assert.Zero(m.Len())
+
+	// the failed device does not count against its IP
+	assert.Zero(m.ipLimiter.count(sourceIP(request, m.forwardedHeader)))
+}
+
+func testStreamConnectorNegotiatedHeaders(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		upgrader, device = pipeUpgrader()
+		negotiated       = make(chan http.Header, 1)
+
+		m       = NewManager(&Options{CreditFlowControl: true})
+		request = WithIDRequest(testDeviceIDs[0], httptest.NewRequest("GET", "/", nil))
+	)
+
+	defer device.Close()
+
+	sc, err := NewStreamConnector(m, func(response http.ResponseWriter, request *http.Request, header http.Header) (Stream, error) {
+		negotiated <- header
+		return upgrader(response, request, header)
+	})
+
+	require.NoError(err)
+	request.Header.Set(CreditWindowHeader, "3")
+	d, err := sc.Connect(httptest.NewRecorder(), request, http.Header{"X-Custom": {"value"}})
+	require.NoError(err)
+	require.NotNil(d)
+
+	header := <-negotiated
+	assert.Equal("3", header.Get(CreditWindowHeader))
+	assert.Equal("value", header.Get("X-Custom"))
+	m.DisconnectAll()
+}
+
+func testStreamConnectorConnect(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		id               = testDeviceIDs[0]
+		events           = make(chan *Event, 10)
+		upgrader, device = pipeUpgrader()
+
+		m = NewManager(&Options{
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == TransactionComplete || e.Type == Disconnect {
+						events <- e
+					}
+				},
+			},
+		})
+	)
+
+	defer device.Close()
+
+	sc, err := NewStreamConnector(m, upgrader)
+	require.NoError(err)
+	require.NotNil(sc)
+
+	d, err := sc.Connect(httptest.NewRecorder(), WithIDRequest(id, httptest.NewRequest("GET", "/", nil)), nil)
+	require.NoError(err)
+	require.NotNil(d)
+	assert.Equal(id, d.ID())
+	assert.Equal(1, m.Len())
+
+	// a transactional request is routed to the device, which responds over the same stream
+	request := &wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "test",
+		Destination:     string(id),
+		TransactionUUID: "stream-transaction",
+		Payload:         []byte("request"),
+	}
+
+	responses := make(chan *Response, 1)
+	go func() {
+		response, err := m.Route(&Request{Message: request})
+		assert.NoError(err)
+		responses <- response
+	}()
+
+	messageType, data, err := device.ReadMessage()
+	require.NoError(err)
+	assert.Equal(websocket.BinaryMessage, messageType)
+
+	var actual wrp.Message
+	require.NoError(wrp.NewDecoder(bytes.NewReader(data), wrp.Msgpack).Decode(&actual))
+	assert.Equal(*request, actual)
+
+	require.NoError(device.WriteMessage(
+		websocket.BinaryMessage,
+		wrp.MustEncode(
+			&wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          string(id),
+				Destination:     "test",
+				TransactionUUID: "stream-transaction",
+				Payload:         []byte("response"),
+			},
+			wrp.Msgpack,
+		),
+	))
+
+	response := <-responses
+	require.NotNil(response)
+	assert.Equal([]byte("response"), response.Message.Payload)
+
+	received := <-events
+	assert.Equal(TransactionComplete, received.Type)
+	assert.Equal(id, received.Device.ID())
+
+	// disconnection is shared with the manager, and sends a close frame over the stream
+	assert.True(sc.Disconnect(id))
+	_, _, err = device.ReadMessage()
+	require.IsType(&websocket.CloseError{}, err)
+
+	disconnect := <-events
+	assert.Equal(Disconnect, disconnect.Type)
+	assert.Equal(id, disconnect.Device.ID())
+	assert.Zero(m.Len())
+}
+
+func TestStreamConnector(t *testing.T) {
+	t.Run("NewStreamConnector", func(t *testing.T) {
+		t.Run("UnsupportedManager", testNewStreamConnectorUnsupportedManager)
+		t.Run("MissingUpgrader", testNewStreamConnectorMissingUpgrader)
+	})
+
+	t.Run("Framing", testStreamConnectionFraming)
+	t.Run("FrameTooLarge", testStreamConnectionFrameTooLarge)
+	t.Run("PreparedMessage", testStreamConnectionPreparedMessage)
+	t.Run("TruncatedFrame", testStreamConnectionTruncatedFrame)
+	t.Run("UpgradeError", testStreamConnectorUpgradeError)
+	t.Run("NegotiatedHeaders", testStreamConnectorNegotiatedHeaders)
+	t.Run("Connect", testStreamConnectorConnect)
+}
@@ -0,0 +1,130 @@
+package device
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// DefaultTapBufferSize is the number of frames a tap buffers for its writer.  Frames read while the buffer is
+// full are dropped, so a slow writer never stalls the device's read pump.
+const DefaultTapBufferSize = 100
+
+// frameTap copies a single device's raw inbound frames to an io.Writer, for packet-capture style debugging.  Each
+// frame is written as its length, a 4-byte big-endian integer, followed by the frame itself.
+type frameTap struct {
+	frames chan []byte
+	closed chan struct{}
+	done   chan struct{}
+
+	closeOnce sync.Once
+}
+
+func newFrameTap(w io.Writer) *frameTap {
+	ft := &frameTap{
+		frames: make(chan []byte, DefaultTapBufferSize),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go ft.run(w)
+	return ft
+}
+
+// run writes buffered frames until the tap is closed or a write fails.  Frames already buffered when the tap is
+// closed are still written.
+func (ft *frameTap) run(w io.Writer) {
+	defer close(ft.done)
+
+	var length [4]byte
+	write := func(frame []byte) bool {
+		binary.BigEndian.PutUint32(length[:], uint32(len(frame)))
+		if _, err := w.Write(length[:]); err != nil {
+			return false
+		}
+
+		_, err := w.Write(frame)
+		return err == nil
+	}
+
+	for {
+		select {
+		case frame := <-ft.frames:
+			if !write(frame) {
+				return
+			}
+
+		case <-ft.closed:
+			for {
+				select {
+				case frame := <-ft.frames:
+					if !write(frame) {
+						return
+					}
+
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// offer buffers a frame for writing, dropping it if the buffer is full.  Frames are never modified after being
+// read, so they are not copied.
+func (ft *frameTap) offer(frame []byte) {
+	select {
+	case ft.frames <- frame:
+	default:
+	}
+}
+
+// close stops the writer goroutine without waiting for it.  This method is idempotent.
+func (ft *frameTap) close() {
+	ft.closeOnce.Do(func() { close(ft.closed) })
+}
+
+// loadTap returns the device's current tap, or nil if it has none.  This is the only cost of taps to a device
+// that has none.
+func (d *device) loadTap() *frameTap {
+	return (*frameTap)(atomic.LoadPointer(&d.tap))
+}
+
+// attachTap attaches the given tap, returning false if the device already has one
+func (d *device) attachTap(ft *frameTap) bool {
+	return atomic.CompareAndSwapPointer(&d.tap, nil, unsafe.Pointer(ft))
+}
+
+// detachTap detaches and closes the given tap, if it is still attached
+func (d *device) detachTap(ft *frameTap) {
+	atomic.CompareAndSwapPointer(&d.tap, unsafe.Pointer(ft), nil)
+	ft.close()
+}
+
+func (m *manager) Tap(id ID, w io.Writer) (func(), error) {
+	id, err := m.idNormalizer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := m.devices.get(id)
+	if !ok {
+		return nil, ErrorDeviceNotFound
+	}
+
+	ft := newFrameTap(w)
+	if !d.attachTap(ft) {
+		ft.close()
+		return nil, ErrorDeviceTapped
+	}
+
+	var stopOnce sync.Once
+	return func() {
+		stopOnce.Do(func() {
+			d.detachTap(ft)
+			<-ft.done
+		})
+	}, nil
+}
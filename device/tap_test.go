@@ -0,0 +1,197 @@
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tapBuffer is a concurrency-safe writer for taps, which blocks each write until release is closed, if set
+type tapBuffer struct {
+	lock    sync.Mutex
+	buffer  bytes.Buffer
+	release chan struct{}
+	err     error
+}
+
+func (tb *tapBuffer) Write(p []byte) (int, error) {
+	if tb.release != nil {
+		<-tb.release
+	}
+
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if tb.err != nil {
+		return 0, tb.err
+	}
+
+	return tb.buffer.Write(p)
+}
+
+// frames parses the length-prefixed frames written so far
+func (tb *tapBuffer) frames(t *testing.T) (frames [][]byte) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	data := tb.buffer.Bytes()
+	for len(data) > 0 {
+		require.True(t, len(data) >= 4)
+		length := int(binary.BigEndian.Uint32(data))
+		require.True(t, len(data) >= 4+length)
+		frames = append(frames, data[4:4+length])
+		data = data[4+length:]
+	}
+
+	return
+}
+
+// drainEvents waits for the given number of events, which shows that the read pump was never stalled
+func drainEvents(events <-chan *Event, count int) {
+	for i := 0; i < count; i++ {
+		<-events
+	}
+}
+
+func testTapFrames(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		require         = require.New(t)
+		m, d, c, events = startReadingPumps(t, &Options{})
+		w               = new(tapBuffer)
+		first           = inboundEvent("event:first")
+		second          = inboundBatch(inboundEvent("event:second"), inboundEvent("event:third"))
+	)
+
+	defer m.DisconnectAll()
+
+	stop, err := m.Tap(d.ID(), w)
+	require.NoError(err)
+	require.NotNil(stop)
+
+	// normal processing continues while tapped, and raw frames are written, including batches
+	c.inbound <- first
+	c.inbound <- second
+	drainEvents(events, 3)
+
+	stop()
+	assert.Nil(d.loadTap())
+	assert.Equal([][]byte{first, second}, w.frames(t))
+
+	// once stopped, frames are no longer written
+	c.inbound <- inboundEvent("event:untapped")
+	drainEvents(events, 1)
+	assert.Len(w.frames(t), 2)
+
+	// stop is idempotent, and a new tap may be attached
+	stop()
+	stop, err = m.Tap(d.ID(), w)
+	require.NoError(err)
+	stop()
+}
+
+func testTapErrors(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		m, d, _, _ = startReadingPumps(t, &Options{})
+	)
+
+	defer m.DisconnectAll()
+
+	stop, err := m.Tap(testDeviceIDs[1], new(tapBuffer))
+	assert.Nil(stop)
+	assert.Equal(ErrorDeviceNotFound, err)
+
+	stop, err = m.Tap(ID("this is not a device name"), new(tapBuffer))
+	assert.Nil(stop)
+	assert.Error(err)
+
+	stop, err = m.Tap(d.ID(), new(tapBuffer))
+	require.NoError(err)
+	defer stop()
+
+	second, err := m.Tap(d.ID(), new(tapBuffer))
+	assert.Nil(second)
+	assert.Equal(ErrorDeviceTapped, err)
+}
+
+func testTapSlowWriter(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		require         = require.New(t)
+		m, d, c, events = startReadingPumps(t, &Options{})
+		w               = &tapBuffer{release: make(chan struct{})}
+		frameCount      = 3 * DefaultTapBufferSize
+	)
+
+	defer m.DisconnectAll()
+
+	stop, err := m.Tap(d.ID(), w)
+	require.NoError(err)
+
+	// a writer that has stopped entirely never stalls the read pump, and frames beyond the buffer are dropped
+	for i := 0; i < frameCount; i++ {
+		c.inbound <- inboundEvent("event:slow")
+		drainEvents(events, 1)
+	}
+
+	close(w.release)
+	stop()
+
+	tapped := len(w.frames(t))
+	assert.True(tapped > 0)
+	assert.True(tapped < frameCount)
+}
+
+func testTapWriteError(t *testing.T) {
+	var (
+		assert          = assert.New(t)
+		require         = require.New(t)
+		m, d, c, events = startReadingPumps(t, &Options{})
+		w               = &tapBuffer{err: errors.New("expected")}
+	)
+
+	defer m.DisconnectAll()
+
+	stop, err := m.Tap(d.ID(), w)
+	require.NoError(err)
+
+	c.inbound <- inboundEvent("event:failed")
+	drainEvents(events, 1)
+
+	// the writer goroutine exits on the first failure, so stop returns right away
+	stop()
+	assert.Empty(w.frames(t))
+}
+
+func testTapDisconnect(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		m, d, _, _ = startReadingPumps(t, &Options{})
+	)
+
+	stop, err := m.Tap(d.ID(), new(tapBuffer))
+	require.NoError(err)
+	ft := d.loadTap()
+	require.NotNil(ft)
+
+	// the read pump detaches and closes the tap as it exits
+	m.DisconnectAll()
+	<-ft.done
+	assert.Nil(d.loadTap())
+	stop()
+}
+
+func TestTap(t *testing.T) {
+	t.Run("Frames", testTapFrames)
+	t.Run("Errors", testTapErrors)
+	t.Run("SlowWriter", testTapSlowWriter)
+	t.Run("WriteError", testTapWriteError)
+	t.Run("Disconnect", testTapDisconnect)
+}
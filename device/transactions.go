@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/Comcast/webpa-common/wrp"
 	"github.com/Comcast/webpa-common/xhttp"
@@ -25,6 +26,27 @@ type Request struct {
 	// then Routing will be encoded prior to sending to devices.
 	Contents []byte
 
+	// RequireAck requests a lightweight delivery acknowledgement from the device, following the
+	// wrp acknowledgement convention.  When set, Message must be a *wrp.Message with a TransactionUUID,
+	// and a copy of that message with its RequestDeliveryResponse set to wrp.AckRequested is sent.  Because the
+	// message is altered, Contents are ignored and the copy is always encoded prior to sending.
+	//
+	// Send and Route will wait for the acknowledgement as with any other transaction.
+	RequireAck bool
+
+	// OnDelivered is an optional callback invoked with the outcome of physically writing this request's frame
+	// to the device.  The error is nil when the frame was written, and non-nil when the write failed or the
+	// device disconnected before the frame could be written.  This is independent of transaction completion,
+	// so senders of events can confirm the write without requiring a response.
+	//
+	// Callbacks never run on a device's write goroutine, so a slow callback cannot stall sends.  All callbacks
+	// for a Manager run sequentially on a single goroutine, in the order the writes occurred.  Consequently,
+	// callbacks for a given device observe that device's writes in order.  No ordering is guaranteed relative
+	// to listener events or to Send returning.  If Send returns an error before the request is queued for
+	// writing, this callback is never invoked.  Callbacks must not block:  one that does delays every later
+	// callback, and the callbacks waiting behind it accumulate in memory without bound.
+	OnDelivered func(error)
+
 	// ctx is the API context for this request, which can be nil.  Normally, it's best to
 	// set this to context.Background() if no cancellation semantics are desired.
 	ctx context.Context
@@ -33,14 +55,53 @@ type Request struct {
 // Transactional tests if Message is Routable and, if so, returns the transactional information
 // from the request.  This method returns a tuple containing the transaction key (if any) combined with
 // wheither this request represents part of a transaction.
+//
+// A request that requires an acknowledgement is always transactional if it has a transaction key.
 func (r *Request) Transactional() (string, bool) {
 	if routable, ok := r.Message.(wrp.Routable); ok {
-		return routable.TransactionKey(), routable.IsTransactionPart()
+		key := routable.TransactionKey()
+		return key, routable.IsTransactionPart() || (r.RequireAck && len(key) > 0)
 	}
 
 	return "", false
 }
 
+// prepareAck applies the acknowledgement convention to this request's message, if RequireAck is set.  The
+// returned request is either this request or, if an acknowledgement is required, a shallow copy holding a copy
+// of the message.  This request and its message are never modified.
+func (r *Request) prepareAck() (*Request, error) {
+	if !r.RequireAck {
+		return r, nil
+	}
+
+	message, ok := r.Message.(*wrp.Message)
+	if !ok {
+		return nil, ErrorAckNotSupported
+	}
+
+	if len(message.TransactionUUID) == 0 {
+		return nil, ErrorInvalidTransactionKey
+	}
+
+	var (
+		ackRequest = *r
+		ackMessage = *message
+	)
+
+	// the message has changed, so it must be encoded again
+	ackMessage.SetRequestDeliveryResponse(wrp.AckRequested)
+	ackRequest.Message = &ackMessage
+	ackRequest.Contents = nil
+	return &ackRequest, nil
+}
+
+// expired tests if this request's message carries an expiry that has passed as of the given time.  Only a
+// *wrp.Message can carry an expiry, so requests with only Contents never expire.
+func (r *Request) expired(now time.Time) bool {
+	message, ok := r.Message.(*wrp.Message)
+	return ok && message.Expired(now)
+}
+
 // Context returns the context.Context object associated with this Request.
 // This method never returns nil.  If no context is associated with this Request,
 // this method returns context.Background().
@@ -111,6 +172,13 @@ type Response struct {
 
 	// Contents is the encoded form of Message, formatted in Format
 	Contents []byte
+
+	// Ack indicates that this response is a delivery acknowledgement rather than a full response
+	Ack bool
+
+	// TraceParent is the W3C traceparent returned by the device, if any.  This field is only populated
+	// by Route when trace context propagation is enabled.
+	TraceParent string
 }
 
 // EncodeResponse writes out a device transaction Response to an http Response.
@@ -147,17 +215,35 @@ func EncodeResponse(output http.ResponseWriter, response *Response, format wrp.F
 	return
 }
 
+// DefaultStreamBufferSize is the default number of responses buffered for a stream registered with
+// Transactions.RegisterStream
+const DefaultStreamBufferSize = 16
+
 // Transactions represents a set of pending transactions.  Instances are safe for
 // concurrent access.
 type Transactions struct {
 	lock    sync.RWMutex
 	closed  bool
+	max     int
 	pending map[string]chan *Response
+
+	// streams is the set of pending transaction keys that accept any number of responses
+	streams map[string]bool
 }
 
+// NewTransactions creates an unbounded set of pending transactions
 func NewTransactions() *Transactions {
+	return NewBoundedTransactions(0)
+}
+
+// NewBoundedTransactions creates a set of pending transactions that holds at most max transactions
+// at a time.  Register fails with ErrorTooManyTransactions once that many are pending.  A nonpositive
+// max means the set is unbounded.
+func NewBoundedTransactions(max int) *Transactions {
 	return &Transactions{
+		max:     max,
 		pending: make(map[string]chan *Response),
+		streams: make(map[string]bool),
 	}
 }
 
@@ -186,11 +272,41 @@ func (t *Transactions) Keys() []string {
 	return keys
 }
 
+// SampleKeys returns at most max pending transaction keys, in no particular order.  Unlike Keys,
+// the amount of work done under the lock is bounded by max rather than by the number of pending transactions.
+// A nonpositive max results in an empty slice.
+func (t *Transactions) SampleKeys(max int) []string {
+	if max <= 0 {
+		return []string{}
+	}
+
+	defer t.lock.RUnlock()
+	t.lock.RLock()
+
+	if len(t.pending) < max {
+		max = len(t.pending)
+	}
+
+	keys := make([]string, 0, max)
+	for key := range t.pending {
+		if len(keys) >= max {
+			break
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
 // Complete dispatches the given response to the appropriate channel returned from Register
 // and removes the transaction from the internal pending set.  This method is intended for
 // goroutines that are servicing queues of messages, e.g. the read pump of a Manager.  Such goroutines
 // use this method to indicate that a transaction is complete.
 //
+// A stream registered with RegisterStream remains pending after each response.  If the stream's buffer is full,
+// the stream is ended, i.e. removed and its channel closed, and this method returns ErrorStreamOverflow.
+//
 // If this method is passed a nil response, it panics.
 func (t *Transactions) Complete(transactionKey string, response *Response) error {
 	if len(transactionKey) == 0 {
@@ -202,12 +318,24 @@ func (t *Transactions) Complete(transactionKey string, response *Response) error
 	defer t.lock.Unlock()
 	t.lock.Lock()
 	result, ok := t.pending[transactionKey]
-	delete(t.pending, transactionKey)
-
 	if !ok {
 		return ErrorNoSuchTransactionKey
 	}
 
+	if t.streams[transactionKey] {
+		select {
+		case result <- response:
+			return nil
+		default:
+			// ending the stream bounds the memory a slow consumer can hold, and the closed channel tells it why
+			delete(t.pending, transactionKey)
+			delete(t.streams, transactionKey)
+			close(result)
+			return ErrorStreamOverflow
+		}
+	}
+
+	delete(t.pending, transactionKey)
 	result <- response
 	close(result)
 	return nil
@@ -228,6 +356,7 @@ func (t *Transactions) Cancel(transactionKey string) {
 
 	result, ok := t.pending[transactionKey]
 	delete(t.pending, transactionKey)
+	delete(t.streams, transactionKey)
 
 	if ok {
 		close(result)
@@ -249,6 +378,8 @@ func (t *Transactions) Close() error {
 		close(responses)
 	}
 
+	t.streams = make(map[string]bool)
+
 	return nil
 }
 
@@ -259,11 +390,28 @@ func (t *Transactions) Close() error {
 // This method returns an error if either transactionKey is the empty string or if a transaction
 // with this key has already been registered.  The latter is a more serious problem, since it indicates
 // that higher-level code has generated duplicate transaction identifiers.  For safety, a Transactions
-// instance expressly does not allow that case.
+// instance expressly does not allow that case.  A bounded instance also returns ErrorTooManyTransactions
+// when it is full.
 //
 // The returned channel will either receive a non-nil response from some code calling Complete, or will
 // see a channel closure (nil Response) from some code calling Cancel.
 func (t *Transactions) Register(transactionKey string) (<-chan *Response, error) {
+	return t.register(transactionKey, 1, false)
+}
+
+// RegisterStream is like Register, except that the transaction accepts any number of responses, as with a
+// subscription.  Each call to Complete delivers a response on the returned channel, which buffers at most size
+// responses.  A nonpositive size means DefaultStreamBufferSize.  The channel is closed when the stream is
+// cancelled, when this Transactions is closed, or when a response arrives while the buffer is full.
+func (t *Transactions) RegisterStream(transactionKey string, size int) (<-chan *Response, error) {
+	if size < 1 {
+		size = DefaultStreamBufferSize
+	}
+
+	return t.register(transactionKey, size, true)
+}
+
+func (t *Transactions) register(transactionKey string, size int, stream bool) (<-chan *Response, error) {
 	if len(transactionKey) == 0 {
 		return nil, ErrorInvalidTransactionKey
 	}
@@ -278,7 +426,15 @@ func (t *Transactions) Register(transactionKey string) (<-chan *Response, error)
 		return nil, ErrorTransactionAlreadyRegistered
 	}
 
-	result := make(chan *Response, 1)
+	if t.max > 0 && len(t.pending) >= t.max {
+		return nil, ErrorTooManyTransactions
+	}
+
+	result := make(chan *Response, size)
 	t.pending[transactionKey] = result
+	if stream {
+		t.streams[transactionKey] = true
+	}
+
 	return result, nil
 }
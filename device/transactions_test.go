@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/Comcast/webpa-common/wrp"
 	"github.com/stretchr/testify/assert"
@@ -59,9 +60,105 @@ func testRequestID(t *testing.T) {
 	assert.Error(err)
 }
 
+func testRequestRequireAck(t *testing.T) {
+	t.Run("Transactional", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			request = &Request{
+				Message: &wrp.Message{
+					Type:            wrp.SimpleEventMessageType,
+					TransactionUUID: "DEADBEEF",
+				},
+			}
+		)
+
+		key, transactional := request.Transactional()
+		assert.Equal("DEADBEEF", key)
+		assert.False(transactional)
+
+		request.RequireAck = true
+		key, transactional = request.Transactional()
+		assert.Equal("DEADBEEF", key)
+		assert.True(transactional)
+	})
+
+	t.Run("Prepare", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			message = &wrp.Message{
+				Type:            wrp.SimpleEventMessageType,
+				TransactionUUID: "DEADBEEF",
+			}
+
+			request = &Request{
+				Message:    message,
+				Format:     wrp.Msgpack,
+				Contents:   []byte("stale contents"),
+				RequireAck: true,
+			}
+		)
+
+		prepared, err := request.prepareAck()
+		assert.NoError(err)
+		require.NotNil(t, prepared)
+		assert.True(prepared.Message.(*wrp.Message).RequiresAck())
+		assert.Empty(prepared.Contents)
+		assert.True(prepared.RequireAck)
+
+		// the caller's request and message are not modified
+		assert.False(message.RequiresAck())
+		assert.Nil(message.RequestDeliveryResponse)
+		assert.Equal([]byte("stale contents"), request.Contents)
+		assert.True(request.Message == message)
+
+		// a request that does not require an ack is returned as is
+		request.RequireAck = false
+		prepared, err = request.prepareAck()
+		assert.NoError(err)
+		assert.True(prepared == request)
+	})
+
+	t.Run("NotAMessage", func(t *testing.T) {
+		request := &Request{
+			Message:    &wrp.SimpleEvent{},
+			RequireAck: true,
+		}
+
+		prepared, err := request.prepareAck()
+		assert.Nil(t, prepared)
+		assert.Equal(t, ErrorAckNotSupported, err)
+	})
+
+	t.Run("NoTransactionKey", func(t *testing.T) {
+		request := &Request{
+			Message:    &wrp.Message{Type: wrp.SimpleEventMessageType},
+			RequireAck: true,
+		}
+
+		prepared, err := request.prepareAck()
+		assert.Nil(t, prepared)
+		assert.Equal(t, ErrorInvalidTransactionKey, err)
+	})
+}
+
+func testRequestExpired(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		now    = time.Now()
+	)
+
+	assert.False((&Request{Contents: []byte("contents")}).expired(now))
+	assert.False((&Request{Message: &wrp.SimpleEvent{}}).expired(now))
+	assert.False((&Request{Message: new(wrp.Message)}).expired(now))
+	assert.False((&Request{Message: new(wrp.Message).SetExpires(now.Add(time.Hour))}).expired(now))
+	assert.True((&Request{Message: new(wrp.Message).SetExpires(now.Add(-time.Hour))}).expired(now))
+}
+
 func TestRequest(t *testing.T) {
 	t.Run("Context", testRequestContext)
+	t.Run("Expired", testRequestExpired)
 	t.Run("ID", testRequestID)
+	t.Run("RequireAck", testRequestRequireAck)
 }
 
 func testDecodeRequest(t *testing.T, message wrp.Routable, format wrp.Format) {
@@ -287,6 +384,114 @@ func testTransactionsCancellation(t *testing.T) {
 	<-finished
 }
 
+func testTransactionsSampleKeys(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		transactions = NewTransactions()
+	)
+
+	assert.Empty(transactions.SampleKeys(10))
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := transactions.Register(key)
+		require.NoError(err)
+	}
+
+	assert.Empty(transactions.SampleKeys(0))
+	assert.Empty(transactions.SampleKeys(-1))
+	assert.Len(transactions.SampleKeys(2), 2)
+	assert.ElementsMatch([]string{"a", "b", "c"}, transactions.SampleKeys(10))
+}
+
+func testTransactionsRegisterBounded(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		transactions = NewBoundedTransactions(2)
+	)
+
+	for _, transactionKey := range []string{"first", "second"} {
+		output, err := transactions.Register(transactionKey)
+		require.NoError(err)
+		require.NotNil(output)
+	}
+
+	output, err := transactions.Register("third")
+	assert.Nil(output)
+	assert.Equal(ErrorTooManyTransactions, err)
+	assert.Equal(2, transactions.Len())
+
+	// completing or cancelling a transaction makes room for another
+	transactions.Cancel("first")
+	output, err = transactions.Register("third")
+	assert.NotNil(output)
+	assert.NoError(err)
+}
+
+func testTransactionsStream(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		transactions = NewTransactions()
+		first        = new(Response)
+		second       = new(Response)
+	)
+
+	output, err := transactions.RegisterStream("stream", 2)
+	require.NoError(err)
+	assert.Equal(2, cap(output))
+
+	// a stream remains pending after each response
+	require.NoError(transactions.Complete("stream", first))
+	require.NoError(transactions.Complete("stream", second))
+	assert.Equal(1, transactions.Len())
+	assert.True(first == <-output)
+	assert.True(second == <-output)
+
+	transactions.Cancel("stream")
+	_, open := <-output
+	assert.False(open)
+	assert.Zero(transactions.Len())
+	assert.Equal(ErrorNoSuchTransactionKey, transactions.Complete("stream", first))
+
+	// once cancelled, the key may be registered again as an ordinary transaction
+	single, err := transactions.Register("stream")
+	require.NoError(err)
+	require.NoError(transactions.Complete("stream", first))
+	assert.True(first == <-single)
+	assert.Zero(transactions.Len())
+
+	defaulted, err := transactions.RegisterStream("defaulted", 0)
+	require.NoError(err)
+	assert.Equal(DefaultStreamBufferSize, cap(defaulted))
+
+	transactions.Close()
+	_, open = <-defaulted
+	assert.False(open)
+}
+
+func testTransactionsStreamOverflow(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		transactions = NewTransactions()
+		buffered     = new(Response)
+	)
+
+	output, err := transactions.RegisterStream("stream", 1)
+	require.NoError(err)
+
+	require.NoError(transactions.Complete("stream", buffered))
+	assert.Equal(ErrorStreamOverflow, transactions.Complete("stream", new(Response)))
+	assert.Zero(transactions.Len())
+
+	// the consumer still receives what was buffered before the stream ended
+	assert.True(buffered == <-output)
+	_, open := <-output
+	assert.False(open)
+}
+
 func TestTransactions(t *testing.T) {
 	t.Run("InitialState", testTransactionsInitialState)
 
@@ -299,8 +504,12 @@ func TestTransactions(t *testing.T) {
 	t.Run("Register", func(t *testing.T) {
 		t.Run("EmptyTransactionKey", testTransactionsRegisterEmptyTransactionKey)
 		t.Run("DuplicateTransactionKey", testTransactionsRegisterDuplicateTransactionKey)
+		t.Run("Bounded", testTransactionsRegisterBounded)
 	})
 
 	t.Run("Lifecycle", testTransactionsLifecycle)
 	t.Run("Cancellation", testTransactionsCancellation)
+	t.Run("SampleKeys", testTransactionsSampleKeys)
+	t.Run("Stream", testTransactionsStream)
+	t.Run("StreamOverflow", testTransactionsStreamOverflow)
 }
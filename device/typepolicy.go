@@ -0,0 +1,59 @@
+package device
+
+import (
+	"github.com/Comcast/webpa-common/convey"
+	"github.com/Comcast/webpa-common/logging"
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// MessageTypePolicy determines the types of WRP messages a device is allowed to send.  It is invoked once for each
+// device as it connects, with the device's convey data, so the allowlist may depend on the class of device.  A nil
+// or empty result allows every type.
+//
+// Messages of any other type are dropped before they reach listeners or complete any transaction, and each drop
+// is counted by the DisallowedMessageCounter.  Credit grants and disconnect announcements are part of the
+// connection protocol, so they are always accepted.  A wrp.Batch is always unpacked, and each of its messages is
+// checked individually.
+type MessageTypePolicy func(ID, convey.C) []wrp.MessageType
+
+// AllowMessageTypes returns a MessageTypePolicy that allows the same message types for every device
+func AllowMessageTypes(types ...wrp.MessageType) MessageTypePolicy {
+	return func(ID, convey.C) []wrp.MessageType {
+		return types
+	}
+}
+
+// messageTypeSet is the allowlist of inbound message types for a single device.  A nil set allows every type.
+type messageTypeSet map[wrp.MessageType]bool
+
+func newMessageTypeSet(types []wrp.MessageType) messageTypeSet {
+	if len(types) == 0 {
+		return nil
+	}
+
+	s := make(messageTypeSet, len(types))
+	for _, t := range types {
+		s[t] = true
+	}
+
+	return s
+}
+
+func (s messageTypeSet) allows(t wrp.MessageType) bool {
+	return s == nil || s[t]
+}
+
+// messageTypeLabel returns the MessageTypeLabel value for a message type
+func messageTypeLabel(t wrp.MessageType) string {
+	if name := t.FriendlyName(); len(name) > 0 {
+		return name
+	}
+
+	return UnknownMessageType
+}
+
+// dropDisallowed records an inbound message that the device's MessageTypePolicy does not allow
+func (m *manager) dropDisallowed(d *device, t wrp.MessageType) {
+	m.measures.Disallowed.With(MessageTypeLabel, messageTypeLabel(t), PartnerLabel, partnerLabel(d.partner, m.devices.partnerQuotas)).Add(1.0)
+	d.errorLog.Log(logging.MessageKey(), "dropping WRP message of a disallowed type", "messageType", t)
+}
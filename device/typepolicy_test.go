@@ -0,0 +1,113 @@
+package device
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Comcast/webpa-common/convey"
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageTypeSet(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, types := range [][]wrp.MessageType{nil, {}} {
+		s := newMessageTypeSet(types)
+		assert.Nil(s)
+		assert.True(s.allows(wrp.ServiceRegistrationMessageType))
+		assert.True(s.allows(wrp.MessageType(0)))
+	}
+
+	s := newMessageTypeSet([]wrp.MessageType{wrp.SimpleEventMessageType, wrp.ServiceAliveMessageType})
+	assert.True(s.allows(wrp.SimpleEventMessageType))
+	assert.True(s.allows(wrp.ServiceAliveMessageType))
+	assert.False(s.allows(wrp.ServiceRegistrationMessageType))
+	assert.False(s.allows(wrp.MessageType(0)))
+}
+
+func TestMessageTypeLabel(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("ServiceRegistration", messageTypeLabel(wrp.ServiceRegistrationMessageType))
+	assert.Equal(UnknownMessageType, messageTypeLabel(wrp.MessageType(0)))
+}
+
+func TestManagerMessageTypePolicy(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		p         = xmetricstest.NewProvider(nil, Metrics)
+		telemetry = testDeviceIDs[0]
+		received  = make(chan *Event, 10)
+
+		m = NewManager(&Options{
+			MetricsProvider: p,
+			MessageTypePolicy: func(id ID, _ convey.C) []wrp.MessageType {
+				if id == telemetry {
+					return []wrp.MessageType{wrp.SimpleEventMessageType}
+				}
+
+				return nil
+			},
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived {
+						received <- e
+					}
+				},
+			},
+		})
+
+		registration = func(id ID) *wrp.ServiceRegistration {
+			return &wrp.ServiceRegistration{ServiceName: string(id), URL: "http://compromised.example.com"}
+		}
+
+		event = func(id ID) *wrp.SimpleEvent {
+			return &wrp.SimpleEvent{Source: string(id), Destination: "event:policy"}
+		}
+	)
+
+	defer m.DisconnectAll()
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	post := func(id ID, message interface{}) {
+		response := httptest.NewRecorder()
+		lp.ServeHTTP(
+			response,
+			WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", bytes.NewReader(wrp.MustEncode(message, wrp.Msgpack)))),
+		)
+
+		require.Equal(http.StatusAccepted, response.Code)
+	}
+
+	for _, id := range []ID{telemetry, testDeviceIDs[1]} {
+		_, err := lp.Connect(httptest.NewRecorder(), WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+		require.NoError(err)
+	}
+
+	// disallowed messages are dropped, whether or not they are batched
+	post(telemetry, registration(telemetry))
+	post(telemetry, &wrp.Batch{Messages: [][]byte{
+		wrp.MustEncode(registration(telemetry), wrp.Msgpack),
+		wrp.MustEncode(event(telemetry), wrp.Msgpack),
+	}})
+
+	e := <-received
+	assert.Equal(telemetry, e.Device.ID())
+	assert.Equal(wrp.SimpleEventMessageType, e.Message.MessageType())
+
+	// the policy applies per device
+	post(testDeviceIDs[1], registration(testDeviceIDs[1]))
+	e = <-received
+	assert.Equal(testDeviceIDs[1], e.Device.ID())
+	assert.Equal(wrp.ServiceRegistrationMessageType, e.Message.MessageType())
+
+	p.Assert(t, DisallowedMessageCounter, MessageTypeLabel, "ServiceRegistration", PartnerLabel, UnknownPartner)(xmetricstest.Value(2.0))
+	assert.Empty(received)
+}
@@ -0,0 +1,30 @@
+package device
+
+import "context"
+
+// urgentQueueSize is the number of urgent messages that may wait for a device's write pump.  Urgent
+// messages are expected to be rare, so this is deliberately small.
+const urgentQueueSize = 4
+
+// sendUrgent enqueues a request ahead of this device's normal queue, then waits for the write pump to
+// report the outcome of writing it
+func (d *device) sendUrgent(request *Request) error {
+	if d.Closed() {
+		return ErrorDeviceClosed
+	} else if _, _, departing := d.departed(); departing {
+		return ErrorDeviceDisconnecting
+	}
+
+	// urgent messages are never abandoned because of a context, only because the device closed
+	complete := make(chan error, 1)
+	if err := d.enqueue(context.Background(), d.urgent, &envelope{request: request, complete: complete, urgent: true}); err != nil {
+		return err
+	}
+
+	select {
+	case <-d.shutdown:
+		return ErrorDeviceClosed
+	case err := <-complete:
+		return err
+	}
+}
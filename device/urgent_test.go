@@ -0,0 +1,166 @@
+package device
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWriteConnection is a Connection whose writes always fail
+type failingWriteConnection struct {
+	Connection
+	err error
+}
+
+func (fwc failingWriteConnection) WriteMessage(int, []byte) error {
+	return fwc.err
+}
+
+func testManagerSendUrgentJumpsQueue(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		m, d, c = registerTestDevice(t, nil)
+	)
+
+	// queue messages before the pumps start, so that the urgent message has something to jump ahead of
+	sendErrors := make(chan error, 3)
+	for _, payload := range []string{"first", "second"} {
+		go func(payload string) {
+			_, err := d.Send(&Request{
+				Message: &wrp.Message{
+					Type:        wrp.SimpleEventMessageType,
+					Destination: string(d.ID()),
+					Payload:     []byte(payload),
+				},
+			})
+
+			sendErrors <- err
+		}(payload)
+	}
+
+	for d.Pending() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() {
+		sendErrors <- m.SendUrgent(d.ID(), &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Destination: string(d.ID()),
+			Payload:     []byte("reboot"),
+		})
+	}()
+
+	for len(d.urgent) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	m.startPumps(d, c, func() error { return nil })
+
+	var payloads []string
+	for len(payloads) < 3 {
+		var actual wrp.Message
+		require.NoError(wrp.NewDecoderBytes(<-c.outbound, wrp.Msgpack).Decode(&actual))
+		payloads = append(payloads, string(actual.Payload))
+	}
+
+	assert.Equal("reboot", payloads[0])
+	assert.ElementsMatch([]string{"first", "second"}, payloads[1:])
+	for i := 0; i < 3; i++ {
+		assert.NoError(<-sendErrors)
+	}
+
+	m.DisconnectAll()
+}
+
+func testManagerSendUrgentIgnoresCredits(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		m, d, c = registerTestDevice(t, nil)
+	)
+
+	// a device with no remaining credits still receives urgent messages
+	d.credits = &creditWindow{granted: make(chan struct{}, 1)}
+	m.startPumps(d, c, func() error { return nil })
+
+	sendError := make(chan error, 1)
+	go func() {
+		sendError <- m.SendUrgent(d.ID(), &wrp.Message{Type: wrp.SimpleEventMessageType, Payload: []byte("reboot")})
+	}()
+
+	var actual wrp.Message
+	require.NoError(wrp.NewDecoderBytes(<-c.outbound, wrp.Msgpack).Decode(&actual))
+	assert.Equal("reboot", string(actual.Payload))
+	assert.NoError(<-sendError)
+	assert.Zero(d.credits.remaining())
+
+	m.DisconnectAll()
+}
+
+func testManagerSendUrgentWriteFailure(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		expectedError = errors.New("expected write error")
+		failed        = make(chan *Event, 1)
+
+		m, d, _ = registerTestDevice(t, &Options{
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageFailed {
+						select {
+						case failed <- e:
+						default:
+						}
+					}
+				},
+			},
+		})
+	)
+
+	m.startPumps(d, failingWriteConnection{newLongPollConnection(m.now), expectedError}, func() error { return nil })
+
+	assert.Equal(expectedError, m.SendUrgent(d.ID(), &wrp.Message{Type: wrp.SimpleEventMessageType}))
+	select {
+	case e := <-failed:
+		assert.Equal(expectedError, e.Error)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no MessageFailed event was dispatched")
+	}
+}
+
+func testManagerSendUrgentErrors(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(nil)
+	)
+
+	assert.Equal(ErrorDeviceNotFound, m.SendUrgent(testDeviceIDs[0], &wrp.Message{Type: wrp.SimpleEventMessageType}))
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	d, err := lp.Connect(httptest.NewRecorder(), WithIDRequest(testDeviceIDs[0], httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+	require.NoError(err)
+
+	d.(*device).requestClose(CloseDisconnected)
+	assert.Equal(ErrorDeviceClosed, d.(*device).sendUrgent(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType}}))
+
+	m.DisconnectAll()
+}
+
+func TestManagerSendUrgent(t *testing.T) {
+	t.Run("JumpsQueue", testManagerSendUrgentJumpsQueue)
+	t.Run("IgnoresCredits", testManagerSendUrgentIgnoresCredits)
+	t.Run("WriteFailure", testManagerSendUrgentWriteFailure)
+	t.Run("Errors", testManagerSendUrgentErrors)
+}
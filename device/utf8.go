@@ -0,0 +1,43 @@
+package device
+
+import "github.com/Comcast/webpa-common/wrp"
+
+// UTF8Policy determines how a Manager treats inbound WRP messages whose string fields are not valid UTF-8.  Such
+// strings are harmless on the wire, but cause JSON encoders downstream, including those used for logging, to fail
+// or to corrupt their output.
+type UTF8Policy string
+
+const (
+	// UTF8Ignore passes messages through without examining their strings.  This is the default.
+	UTF8Ignore UTF8Policy = "ignore"
+
+	// UTF8Reject discards a message with any invalid string, exactly as though its frame were malformed.  Such
+	// messages are counted by the MalformedMessageCounter with the InvalidUTF8Reason.
+	UTF8Reject UTF8Policy = "reject"
+
+	// UTF8Sanitize replaces each invalid sequence with the Unicode replacement character.  The message's
+	// contents are reencoded, so listeners only ever see the sanitized message.
+	UTF8Sanitize UTF8Policy = "sanitize"
+)
+
+// invalidUTF8Error is returned by UTF8Reject, so that a rejected message is counted under InvalidUTF8Reason
+type invalidUTF8Error struct {
+	error
+}
+
+// apply enforces this policy on a decoded message.  The returned bool is true if the message was changed
+// and must be reencoded.  An error means the message must be discarded.
+func (p UTF8Policy) apply(message *wrp.Message) (bool, error) {
+	switch p {
+	case UTF8Reject:
+		if err := message.ValidateUTF8(); err != nil {
+			return false, invalidUTF8Error{err}
+		}
+
+		return false, nil
+	case UTF8Sanitize:
+		return message.SanitizeUTF8(), nil
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,129 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xmetrics/xmetricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testInvalidUTF8 = "dns:\xff\xfeexample.com"
+
+func TestUTF8PolicyApply(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = wrp.Message{Source: testInvalidUTF8}
+	)
+
+	sanitized, err := UTF8Ignore.apply(&message)
+	assert.False(sanitized)
+	assert.NoError(err)
+	assert.Equal(testInvalidUTF8, message.Source)
+
+	sanitized, err = UTF8Reject.apply(&message)
+	assert.False(sanitized)
+	require.IsType(t, invalidUTF8Error{}, err)
+	assert.IsType(new(wrp.FieldError), err.(invalidUTF8Error).error)
+	assert.Equal(InvalidUTF8Reason, malformedReason(err))
+	assert.Equal(testInvalidUTF8, message.Source)
+
+	sanitized, err = UTF8Sanitize.apply(&message)
+	assert.True(sanitized)
+	assert.NoError(err)
+	assert.NoError(message.ValidateUTF8())
+
+	// a valid message never needs reencoding
+	sanitized, err = UTF8Sanitize.apply(&message)
+	assert.False(sanitized)
+	assert.NoError(err)
+}
+
+// readWithUTF8Policy sends a message with an invalid Source followed by a valid one to a device's read pump,
+// returning the MessageReceived events dispatched once the valid message has been read and the manager's metrics
+func readWithUTF8Policy(t *testing.T, policy UTF8Policy) ([]*Event, xmetricstest.Provider) {
+	// the pumps outlive the test, so neither the manager nor the device logs through it
+	var (
+		received = make(chan *Event, 2)
+		p        = xmetricstest.NewProvider(nil, Metrics)
+		m, _, c  = startTestPumps(t, &Options{
+			UTF8Policy:      policy,
+			MetricsProvider: p,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == MessageReceived {
+						received <- e
+					}
+				},
+			},
+		})
+	)
+
+	defer m.DisconnectAll()
+
+	c.inbound <- wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: testInvalidUTF8, Destination: "event:test"}, wrp.Msgpack)
+	c.inbound <- wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "dns:valid", Destination: "event:test"}, wrp.Msgpack)
+
+	var events []*Event
+	for {
+		select {
+		case e := <-received:
+			events = append(events, e)
+			if e.Message.(*wrp.Message).Source == "dns:valid" {
+				return events, p
+			}
+
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "the valid message was never received")
+			return events, p
+		}
+	}
+}
+
+func testManagerUTF8PolicyIgnore(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		events, _ = readWithUTF8Policy(t, UTF8Ignore)
+	)
+
+	require.Len(events, 2)
+	assert.Equal(testInvalidUTF8, events[0].Message.(*wrp.Message).Source)
+}
+
+func testManagerUTF8PolicyReject(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		events, p = readWithUTF8Policy(t, UTF8Reject)
+	)
+
+	// the invalid message is skipped as though it were malformed, and reading continues
+	require.Len(events, 1)
+	assert.Equal("dns:valid", events[0].Message.(*wrp.Message).Source)
+	p.Assert(t, MalformedMessageCounter, MalformedReasonLabel, InvalidUTF8Reason)(xmetricstest.Value(1.0))
+}
+
+func testManagerUTF8PolicySanitize(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		events, _   = readWithUTF8Policy(t, UTF8Sanitize)
+		fromContent wrp.Message
+	)
+
+	require.Len(events, 2)
+	assert.Equal("dns:�example.com", events[0].Message.(*wrp.Message).Source)
+
+	// the contents are reencoded, so they agree with the sanitized message
+	require.NoError(wrp.NewDecoderBytes(events[0].Contents, wrp.Msgpack).Decode(&fromContent))
+	assert.Equal("dns:�example.com", fromContent.Source)
+}
+
+func TestManagerUTF8Policy(t *testing.T) {
+	t.Run("Ignore", testManagerUTF8PolicyIgnore)
+	t.Run("Reject", testManagerUTF8PolicyReject)
+	t.Run("Sanitize", testManagerUTF8PolicySanitize)
+}
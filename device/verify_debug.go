@@ -0,0 +1,18 @@
+//go:build debug
+// +build debug
+
+package device
+
+// VerifyManager checks the internal consistency of a Manager created by NewManager, e.g. that every connected
+// device is reachable by its ID and that the device count agrees with the devices actually connected.
+// A Manager of any other type is never considered inconsistent.
+//
+// This function is only available when built with the debug tag, e.g. go test -tags debug.  It is intended
+// for use in tests, particularly tests of other packages that drive a Manager through connects and disconnects.
+func VerifyManager(m Manager) error {
+	if dm, ok := m.(*manager); ok {
+		return dm.devices.verify()
+	}
+
+	return nil
+}
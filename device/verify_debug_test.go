@@ -0,0 +1,38 @@
+//go:build debug
+// +build debug
+
+package device
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyManager(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(nil)
+	)
+
+	lp, err := NewLongPollConnector(m, 0)
+	require.NoError(err)
+
+	assert.NoError(VerifyManager(m))
+	for _, id := range testDeviceIDs[:3] {
+		_, err := lp.Connect(httptest.NewRecorder(), WithIDRequest(id, httptest.NewRequest("POST", "http://localhost.com", nil)), nil)
+		require.NoError(err)
+		assert.NoError(VerifyManager(m))
+	}
+
+	m.Disconnect(testDeviceIDs[1])
+	assert.NoError(VerifyManager(m))
+
+	m.DisconnectAll()
+	assert.NoError(VerifyManager(m))
+
+	assert.NoError(VerifyManager(struct{ Manager }{}))
+}
@@ -0,0 +1,51 @@
+package wrp
+
+import "github.com/ugorji/go/codec"
+
+var (
+	// canonicalJSONHandle is jsonHandle with map keys written in sorted order
+	canonicalJSONHandle = codec.JsonHandle{
+		BasicHandle: codec.BasicHandle{
+			TypeInfos:     codec.NewTypeInfos([]string{"wrp"}),
+			EncodeOptions: codec.EncodeOptions{Canonical: true},
+		},
+		IntegerAsString: 'L',
+	}
+
+	// canonicalMsgpackHandle is msgpackHandle with map keys written in sorted order
+	canonicalMsgpackHandle = codec.MsgpackHandle{
+		WriteExt:    true,
+		RawToString: true,
+		BasicHandle: codec.BasicHandle{
+			TypeInfos:     codec.NewTypeInfos([]string{"wrp"}),
+			EncodeOptions: codec.EncodeOptions{Canonical: true},
+		},
+	}
+)
+
+// WithCanonicalOutput makes an Encoder produce canonical output, where map entries such as Metadata are
+// written in sorted key order.  Encoding the same message always produces identical bytes, which golden-file
+// tests and message signatures rely on.  Struct fields, including Headers, are always written in a fixed order.
+//
+// Sorting keys costs time on every encode, so this option is off by default and is not intended for the
+// hot path.  Canonical output decodes exactly as normal output does.
+func WithCanonicalOutput() EncoderOption {
+	return func(ed *encoderDecorator) {
+		ed.canonical = true
+	}
+}
+
+// encodeHandle is like handle, but returns the canonical configuration for this format if requested.
+// This method panics if the format is not a valid value.
+func (f Format) encodeHandle(canonical bool) codec.Handle {
+	if canonical {
+		switch f {
+		case Msgpack:
+			return &canonicalMsgpackHandle
+		case JSON:
+			return &canonicalJSONHandle
+		}
+	}
+
+	return f.handle()
+}
@@ -0,0 +1,66 @@
+package wrp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCanonicalOutput(t *testing.T, f Format) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		original = &Message{
+			Type:            SimpleRequestResponseMessageType,
+			Source:          "dns:source.com",
+			Destination:     "mac:112233445566",
+			TransactionUUID: "canonical",
+			Headers:         []string{"X-Second: 2", "X-First: 1"},
+			Metadata:        make(map[string]string),
+			Payload:         []byte("payload"),
+		}
+
+		golden []byte
+	)
+
+	// enough keys that map iteration order varies between encodes
+	for i := 0; i < 32; i++ {
+		original.Metadata[fmt.Sprintf("/key-%02d", i)] = fmt.Sprintf("value-%d", i)
+	}
+
+	require.NoError(NewEncoderBytes(&golden, f, WithCanonicalOutput()).Encode(original))
+	for i := 0; i < 100; i++ {
+		var output []byte
+		require.NoError(NewEncoderBytes(&output, f, WithCanonicalOutput()).Encode(original))
+		require.Equal(golden, output)
+	}
+
+	// keys are in sorted order
+	text, last := string(golden), -1
+	for i := 0; i < 32; i++ {
+		position := strings.Index(text, fmt.Sprintf("/key-%02d", i))
+		require.True(position > last)
+		last = position
+	}
+
+	// canonical output decodes like any other
+	var decoded Message
+	require.NoError(NewDecoderBytes(golden, f).Decode(&decoded))
+	assert.Equal(*original, decoded)
+
+	var normal []byte
+	require.NoError(NewEncoderBytes(&normal, f).Encode(original))
+	assert.Len(normal, len(golden))
+}
+
+func TestWithCanonicalOutput(t *testing.T) {
+	for _, f := range AllFormats() {
+		t.Run(f.String(), func(t *testing.T) {
+			testCanonicalOutput(t, f)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package wrp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// PayloadEncodingKey is the Metadata key which marks a payload as compressed.  The value of this
+	// key is the compression algorithm, which is currently always GzipPayloadEncoding.
+	PayloadEncodingKey = "/wrp/payload-encoding"
+
+	// GzipPayloadEncoding is the PayloadEncodingKey value for gzip-compressed payloads
+	GzipPayloadEncoding = "gzip"
+
+	// DefaultCompressionThreshold is the payload size, in bytes, above which payloads are compressed
+	// when WithPayloadCompression is given a nonpositive threshold
+	DefaultCompressionThreshold = 1024
+
+	// DefaultMaxDecompressedSize is the largest decompressed payload, in bytes, when WithPayloadDecompression
+	// is given a nonpositive limit
+	DefaultMaxDecompressedSize = 1024 * 1024
+)
+
+// ErrDecompressedPayloadTooLarge indicates that a compressed payload expanded beyond the limit given to
+// WithPayloadDecompression.  It is reported as the Err of a *DecodeError.
+var ErrDecompressedPayloadTooLarge = errors.New("The decompressed WRP payload exceeds the maximum size")
+
+// EncoderOption configures optional behavior of the Encoders created by this package
+type EncoderOption func(*encoderDecorator)
+
+// WithPayloadCompression enables transparent payload compression.  When a *Message, *SimpleRequestResponse,
+// or *SimpleEvent with a Payload larger than threshold bytes is encoded, the Payload is gzipped and the
+// PayloadEncodingKey is added to the encoded Metadata.  The value passed to Encode is never modified, and the
+// ContentType is preserved.  A payload whose compressed form is not smaller is sent uncompressed.
+//
+// Because the marker travels within the message itself, compression survives store-and-forward and is
+// independent of any transport-level compression, such as websocket permessage-deflate.  Decoders only
+// decompress marked payloads when created with WithPayloadDecompression.
+//
+// If threshold is nonpositive, DefaultCompressionThreshold is used.
+func WithPayloadCompression(threshold int) EncoderOption {
+	if threshold < 1 {
+		threshold = DefaultCompressionThreshold
+	}
+
+	return func(ed *encoderDecorator) {
+		ed.compressionThreshold = threshold
+	}
+}
+
+// DecoderOption configures optional behavior of the Decoders created by this package
+type DecoderOption func(*decoderDecorator)
+
+// WithPayloadDecompression enables transparent decompression of payloads marked by WithPayloadCompression.  The
+// PayloadEncodingKey is removed from the decoded Metadata.  A payload that would decompress to more than maxSize
+// bytes fails the decode with ErrDecompressedPayloadTooLarge, which guards against gzip bombs.  Without this option,
+// marked payloads are decoded as is, still compressed and still marked.
+//
+// If maxSize is nonpositive, DefaultMaxDecompressedSize is used.
+func WithPayloadDecompression(maxSize int) DecoderOption {
+	if maxSize < 1 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+
+	return func(dd *decoderDecorator) {
+		dd.maxDecompressedSize = maxSize
+	}
+}
+
+// payloadFields returns pointers to the payload and metadata of the given value, if it is
+// one of the message types that carries a payload.
+func payloadFields(value interface{}) (*[]byte, *map[string]string, bool) {
+	switch m := value.(type) {
+	case *Message:
+		return &m.Payload, &m.Metadata, true
+	case *SimpleRequestResponse:
+		return &m.Payload, &m.Metadata, true
+	case *SimpleEvent:
+		return &m.Payload, &m.Metadata, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// shallowCopy returns a copy of the given message value, so that the payload and metadata
+// may be replaced without affecting the caller's instance.
+func shallowCopy(value interface{}) interface{} {
+	switch m := value.(type) {
+	case *Message:
+		clone := *m
+		return &clone
+	case *SimpleRequestResponse:
+		clone := *m
+		return &clone
+	case *SimpleEvent:
+		clone := *m
+		return &clone
+	default:
+		return value
+	}
+}
+
+// compressPayload returns the value to encode in place of the given value.  If the value's payload
+// does not warrant compression, the value itself is returned.
+func compressPayload(value interface{}, threshold int) (interface{}, error) {
+	payload, _, ok := payloadFields(value)
+	if !ok || len(*payload) <= threshold {
+		return value, nil
+	}
+
+	var (
+		output bytes.Buffer
+		writer = gzip.NewWriter(&output)
+	)
+
+	if _, err := writer.Write(*payload); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	if output.Len() >= len(*payload) {
+		// incompressible payloads are sent as is
+		return value, nil
+	}
+
+	clone := shallowCopy(value)
+	clonePayload, cloneMetadata, _ := payloadFields(clone)
+	metadata := make(map[string]string, len(*cloneMetadata)+1)
+	for k, v := range *cloneMetadata {
+		metadata[k] = v
+	}
+
+	metadata[PayloadEncodingKey] = GzipPayloadEncoding
+	*clonePayload = output.Bytes()
+	*cloneMetadata = metadata
+	return clone, nil
+}
+
+// decompressPayload reverses compressPayload on a decoded value, returning true if the value's
+// payload had been compressed.  The PayloadEncodingKey is removed from the metadata.  A payload that
+// decompresses to more than maxSize bytes is rejected with ErrDecompressedPayloadTooLarge.
+func decompressPayload(value interface{}, maxSize int) (bool, error) {
+	payload, metadata, ok := payloadFields(value)
+	if !ok || (*metadata)[PayloadEncodingKey] != GzipPayloadEncoding {
+		return false, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(*payload))
+	if err != nil {
+		return false, err
+	}
+
+	// reading one byte past the limit detects an oversized payload without expanding all of it
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return false, err
+	}
+
+	if len(decompressed) > maxSize {
+		return false, ErrDecompressedPayloadTooLarge
+	}
+
+	delete(*metadata, PayloadEncodingKey)
+	if len(*metadata) == 0 {
+		*metadata = nil
+	}
+
+	*payload = decompressed
+	return true, nil
+}
+
+// WasCompressed tests if the most recent Decode call on the given Decoder decompressed a payload.
+// This function returns false for Decoders not created by this package.
+func WasCompressed(d Decoder) bool {
+	if dd, ok := d.(*decoderDecorator); ok {
+		return dd.wasCompressed
+	}
+
+	return false
+}
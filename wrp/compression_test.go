@@ -0,0 +1,183 @@
+package wrp
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPayloadCompressionRoundTrip(t *testing.T, f Format, payload []byte, expectCompressed bool) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		original = &Message{
+			Type:        SimpleEventMessageType,
+			Source:      "dns:source.com",
+			Destination: "mac:112233445566",
+			ContentType: "application/octet-stream",
+			Metadata:    map[string]string{"/key": "value"},
+			Payload:     payload,
+		}
+
+		originalPayload = append([]byte(nil), payload...)
+
+		compressed   []byte
+		uncompressed []byte
+	)
+
+	require.NoError(NewEncoderBytes(&compressed, f, WithPayloadCompression(512)).Encode(original))
+	require.NoError(NewEncoderBytes(&uncompressed, f).Encode(original))
+
+	// the caller's message must not be altered
+	assert.Equal(originalPayload, original.Payload)
+	assert.Equal(map[string]string{"/key": "value"}, original.Metadata)
+
+	if expectCompressed {
+		assert.True(len(compressed) < len(uncompressed))
+	} else {
+		assert.Equal(uncompressed, compressed)
+	}
+
+	var (
+		decoded Message
+		decoder = NewDecoderBytes(compressed, f, WithPayloadDecompression(0))
+	)
+
+	require.NoError(decoder.Decode(&decoded))
+	assert.Equal(expectCompressed, WasCompressed(decoder))
+	assert.Equal(*original, decoded)
+
+	// without the option, a compressed payload is decoded as is
+	decoded = Message{}
+	decoder = NewDecoderBytes(compressed, f)
+	require.NoError(decoder.Decode(&decoded))
+	assert.False(WasCompressed(decoder))
+	if expectCompressed {
+		assert.Equal(GzipPayloadEncoding, decoded.Metadata[PayloadEncodingKey])
+		assert.NotEqual(original.Payload, decoded.Payload)
+	} else {
+		assert.Equal(*original, decoded)
+	}
+}
+
+func testPayloadCompressionSimpleTypes(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		payload = bytes.Repeat([]byte("simple "), 500)
+
+		output []byte
+	)
+
+	require.NoError(NewEncoderBytes(&output, Msgpack, WithPayloadCompression(0)).Encode(&SimpleEvent{
+		Source:      "dns:source.com",
+		Destination: "event:test",
+		ContentType: "text/plain",
+		Payload:     payload,
+	}))
+
+	var (
+		decoded SimpleEvent
+		decoder = NewDecoderBytes(output, Msgpack, WithPayloadDecompression(0))
+	)
+
+	require.NoError(decoder.Decode(&decoded))
+	assert.True(WasCompressed(decoder))
+	assert.Equal(payload, decoded.Payload)
+	assert.Equal("text/plain", decoded.ContentType)
+	assert.Empty(decoded.Metadata)
+
+	// a subsequent decode resets the signal
+	decoder.ResetBytes(MustEncode(&SimpleEvent{Source: "dns:source.com", Destination: "event:test"}, Msgpack))
+	require.NoError(decoder.Decode(&decoded))
+	assert.False(WasCompressed(decoder))
+}
+
+func testPayloadCompressionBadPayload(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		decoded Message
+	)
+
+	data := MustEncode(
+		&Message{
+			Type:     SimpleEventMessageType,
+			Metadata: map[string]string{PayloadEncodingKey: GzipPayloadEncoding},
+			Payload:  []byte("this is not gzip"),
+		},
+		Msgpack,
+	)
+
+	err := NewDecoderBytes(data, Msgpack, WithPayloadDecompression(0)).Decode(&decoded)
+	assert.Error(err)
+	assert.Nil(DecodeErrorKind(err))
+
+	err = NewDecoderBytes(data, Msgpack, WithPayloadDecompression(0), WithDecodeErrors()).Decode(&decoded)
+	assert.IsType(new(DecodeError), err)
+}
+
+func testPayloadCompressionTooLarge(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		payload = bytes.Repeat([]byte{0}, 10000)
+
+		output []byte
+	)
+
+	require.NoError(NewEncoderBytes(&output, Msgpack, WithPayloadCompression(0)).Encode(&Message{
+		Type:    SimpleEventMessageType,
+		Payload: payload,
+	}))
+
+	var decoded Message
+	err := NewDecoderBytes(output, Msgpack, WithPayloadDecompression(len(payload)-1)).Decode(&decoded)
+	assert.Equal(ErrDecompressedPayloadTooLarge, err)
+
+	decoded = Message{}
+	err = NewDecoderBytes(output, Msgpack, WithPayloadDecompression(len(payload)-1), WithDecodeErrors()).Decode(&decoded)
+	require.IsType(new(DecodeError), err)
+	assert.Equal(ErrDecompressedPayloadTooLarge, err.(*DecodeError).Err)
+
+	decoded = Message{}
+	require.NoError(NewDecoderBytes(output, Msgpack, WithPayloadDecompression(len(payload))).Decode(&decoded))
+	assert.Equal(payload, decoded.Payload)
+}
+
+func TestPayloadCompression(t *testing.T) {
+	var (
+		random         = rand.New(rand.NewSource(734))
+		incompressible = make([]byte, 4096)
+		compressible   = bytes.Repeat([]byte("firmware chunk "), 300)
+	)
+
+	random.Read(incompressible)
+
+	for _, f := range AllFormats() {
+		t.Run(f.String(), func(t *testing.T) {
+			t.Run("Compressible", func(t *testing.T) {
+				testPayloadCompressionRoundTrip(t, f, compressible, true)
+			})
+
+			t.Run("Incompressible", func(t *testing.T) {
+				testPayloadCompressionRoundTrip(t, f, incompressible, false)
+			})
+
+			t.Run("BelowThreshold", func(t *testing.T) {
+				testPayloadCompressionRoundTrip(t, f, compressible[:100], false)
+			})
+		})
+	}
+
+	t.Run("SimpleTypes", testPayloadCompressionSimpleTypes)
+	t.Run("BadPayload", testPayloadCompressionBadPayload)
+	t.Run("TooLarge", testPayloadCompressionTooLarge)
+}
+
+func TestWasCompressedForeignDecoder(t *testing.T) {
+	assert.False(t, WasCompressed(nil))
+}
@@ -0,0 +1,89 @@
+package wrp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The kinds of DecodeError.  These let callers distinguish why a message could not be decoded, e.g. to count
+// truncated frames separately from frames sent with an unsupported message type.
+var (
+	// ErrTruncated indicates that the encoded message ended before a complete value was read
+	ErrTruncated = errors.New("Truncated WRP message")
+
+	// ErrUnknownType indicates that a Message decoded cleanly but its msg_type was not a known MessageType
+	ErrUnknownType = errors.New("Unknown WRP message type")
+
+	// ErrFieldType indicates that the encoded message was not a map or that one of its fields had the wrong type,
+	// e.g. a string where an integer was expected
+	ErrFieldType = errors.New("WRP field has the wrong type")
+)
+
+// DecodeError is returned by the Decoders in this package, when created with WithDecodeErrors, when a message
+// cannot be decoded
+type DecodeError struct {
+	// Kind is one of ErrTruncated, ErrUnknownType, or ErrFieldType
+	Kind error
+
+	// Err is the underlying error reported by the codec
+	Err error
+}
+
+func (de *DecodeError) Error() string {
+	return de.Kind.Error() + ": " + de.Err.Error()
+}
+
+// WithDecodeErrors makes a Decoder report each failure as a *DecodeError, so that callers can tell why a message
+// could not be decoded.  It also rejects a Message whose msg_type is not a known MessageType with an ErrUnknownType
+// DecodeError.  Without this option, Decoders return the codec's errors as is and accept any msg_type, which leaves
+// room for callers that intentionally exchange experimental message types.
+func WithDecodeErrors() DecoderOption {
+	return func(dd *decoderDecorator) {
+		dd.decodeErrors = true
+	}
+}
+
+// newDecodeError classifies an error returned by a ugorji Decoder.  The codec does not type its errors, but it
+// reports the end of its input with the io errors, and every other failure is a mismatch between the encoded
+// value and the target.
+//
+// A bare io.EOF, which a stream Decoder returns when no more messages remain, is returned as is so that read loops
+// can still detect the end of their input.
+func newDecodeError(err error) error {
+	switch err {
+	case nil, io.EOF:
+		return err
+	case io.ErrUnexpectedEOF:
+		return &DecodeError{Kind: ErrTruncated, Err: err}
+	default:
+		return &DecodeError{Kind: ErrFieldType, Err: err}
+	}
+}
+
+// checkMessageType returns an ErrUnknownType DecodeError if the given value is a Message with a msg_type that is
+// not a known MessageType.  A Message without a msg_type is left to the caller, as partial messages are decoded
+// in several places.  The other message structs are not checked, as they may be decoded from any type of message.
+func checkMessageType(value interface{}) error {
+	if m, ok := value.(*Message); ok && m.Type != 0 && !m.Type.IsValid() {
+		return &DecodeError{Kind: ErrUnknownType, Err: fmt.Errorf("Invalid message type: %d", m.Type)}
+	}
+
+	return nil
+}
+
+// DecodeErrorKind returns the kind of error returned by a Decoder, one of ErrTruncated, ErrUnknownType, or
+// ErrFieldType.  An io.EOF is reported as ErrTruncated, as it is only returned when no part of a message could be
+// read.  For any other error, including nil, this function returns nil.
+func DecodeErrorKind(err error) error {
+	switch e := err.(type) {
+	case *DecodeError:
+		return e.Kind
+	default:
+		if err == io.EOF {
+			return ErrTruncated
+		}
+
+		return nil
+	}
+}
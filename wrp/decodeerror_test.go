@@ -0,0 +1,136 @@
+package wrp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeKind decodes the given value into a Message with WithDecodeErrors, returning the kind of any DecodeError
+func decodeKind(t *testing.T, encoded []byte, f Format) error {
+	err := NewDecoderBytes(encoded, f, WithDecodeErrors()).Decode(new(Message))
+	require.Error(t, err)
+	if _, ok := err.(*DecodeError); ok {
+		assert.Contains(t, err.Error(), DecodeErrorKind(err).Error())
+	}
+
+	return DecodeErrorKind(err)
+}
+
+func testDecodeErrorTruncated(t *testing.T) {
+	encoded := MustEncode(&Message{Type: SimpleEventMessageType, Source: "dns:talaria.example.com", Payload: []byte("event")}, Msgpack)
+	for _, length := range []int{0, 1, 5, len(encoded) - 1} {
+		assert.Equal(t, ErrTruncated, decodeKind(t, encoded[:length], Msgpack), "length %d", length)
+	}
+
+	assert.Equal(t, ErrTruncated, decodeKind(t, []byte(`{"msg_type": 4, "source": "dns:`), JSON))
+}
+
+func testDecodeErrorUnknownType(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(ErrUnknownType, decodeKind(t, MustEncode(map[string]interface{}{"msg_type": 99}, Msgpack), Msgpack))
+	assert.Equal(ErrUnknownType, decodeKind(t, []byte(`{"msg_type": 2}`), JSON))
+
+	// only the generic Message is checked
+	var event SimpleEvent
+	assert.NoError(NewDecoderBytes(MustEncode(map[string]interface{}{"msg_type": 99}, Msgpack), Msgpack, WithDecodeErrors()).Decode(&event))
+
+	// a message without a type is left to the caller
+	assert.NoError(NewDecoderBytes(MustEncode(map[string]interface{}{"source": "dns:talaria.example.com"}, Msgpack), Msgpack, WithDecodeErrors()).Decode(new(Message)))
+}
+
+func testDecodeErrorFieldType(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, value := range []interface{}{
+		map[string]interface{}{"msg_type": "SimpleEvent"},
+		map[string]interface{}{"msg_type": int(SimpleEventMessageType), "source": 123},
+		map[string]interface{}{"msg_type": int(SimpleEventMessageType), "headers": 7},
+		"not a message",
+	} {
+		assert.Equal(ErrFieldType, decodeKind(t, MustEncode(value, Msgpack), Msgpack), "%v", value)
+	}
+}
+
+func testDecodeErrorLegacy(t *testing.T) {
+	assert := assert.New(t)
+
+	encoded := MustEncode([]interface{}{int(SimpleEventMessageType), "dns:talaria.example.com"}, Msgpack)
+	err := NewVersionedDecoderBytes(encoded[:len(encoded)-1], ProtocolV1, WithDecodeErrors()).Decode(new(Message))
+	assert.Equal(ErrTruncated, DecodeErrorKind(err))
+
+	tooMany := make([]interface{}, len(V1FieldOrder)+1)
+	err = NewVersionedDecoderBytes(MustEncode(tooMany, Msgpack), ProtocolV1, WithDecodeErrors()).Decode(new(Message))
+	assert.Equal(ErrFieldType, DecodeErrorKind(err))
+
+	err = NewVersionedDecoderBytes(MustEncode([]interface{}{99}, Msgpack), ProtocolV1, WithDecodeErrors()).Decode(new(Message))
+	assert.Equal(ErrUnknownType, DecodeErrorKind(err))
+}
+
+func testDecodeErrorDefault(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message Message
+	)
+
+	// without WithDecodeErrors, any msg_type is accepted
+	assert.NoError(NewDecoderBytes(MustEncode(map[string]interface{}{"msg_type": 99}, Msgpack), Msgpack).Decode(&message))
+	assert.Equal(MessageType(99), message.Type)
+
+	message = Message{}
+	assert.NoError(NewVersionedDecoderBytes(MustEncode([]interface{}{99}, Msgpack), ProtocolV1).Decode(&message))
+	assert.Equal(MessageType(99), message.Type)
+
+	// ... and the codec's errors are returned as is
+	encoded := MustEncode(&Message{Type: SimpleEventMessageType, Source: "dns:talaria.example.com"}, Msgpack)
+	assert.Equal(io.ErrUnexpectedEOF, NewDecoderBytes(encoded[:len(encoded)-1], Msgpack).Decode(new(Message)))
+
+	err := NewDecoderBytes(MustEncode("not a message", Msgpack), Msgpack).Decode(new(Message))
+	assert.Error(err)
+	assert.Nil(DecodeErrorKind(err))
+
+	tooMany := make([]interface{}, len(V1FieldOrder)+1)
+	err = NewVersionedDecoderBytes(MustEncode(tooMany, Msgpack), ProtocolV1).Decode(new(Message))
+	assert.Error(err)
+	assert.Nil(DecodeErrorKind(err))
+}
+
+func testDecodeErrorStream(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		input   bytes.Buffer
+	)
+
+	input.Write(MustEncode(&Message{Type: SimpleEventMessageType, Source: "dns:talaria.example.com"}, Msgpack))
+	decoder := NewDecoder(&input, Msgpack)
+	require.NoError(decoder.Decode(new(Message)))
+
+	// the end of a stream is still reported as io.EOF
+	assert.Equal(io.EOF, decoder.Decode(new(Message)))
+}
+
+func testDecodeErrorKind(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(DecodeErrorKind(nil))
+	assert.Nil(DecodeErrorKind(errors.New("expected")))
+	assert.Equal(ErrTruncated, DecodeErrorKind(io.EOF))
+	assert.Equal(ErrFieldType, DecodeErrorKind(&DecodeError{Kind: ErrFieldType, Err: errors.New("expected")}))
+	assert.Equal("WRP field has the wrong type: expected", (&DecodeError{Kind: ErrFieldType, Err: errors.New("expected")}).Error())
+}
+
+func TestDecodeError(t *testing.T) {
+	t.Run("Truncated", testDecodeErrorTruncated)
+	t.Run("UnknownType", testDecodeErrorUnknownType)
+	t.Run("FieldType", testDecodeErrorFieldType)
+	t.Run("Legacy", testDecodeErrorLegacy)
+	t.Run("Default", testDecodeErrorDefault)
+	t.Run("Stream", testDecodeErrorStream)
+	t.Run("Kind", testDecodeErrorKind)
+}
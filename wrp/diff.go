@@ -0,0 +1,61 @@
+package wrp
+
+import "reflect"
+
+// DiffMessages reports the fields that differ between two messages, as a map of Go field name onto the
+// pair {a's value, b's value}.  Pointer fields, such as Status, are reported by the values they point to,
+// or nil if unset.  Slice and map fields are compared deeply, and an empty slice or map is considered
+// equal to a nil one since neither appears on the wire.  A nil message is treated as the zero Message.
+//
+// This function is purely diagnostic, and neither message is modified.  An empty map means the messages
+// are equivalent.
+func DiffMessages(a, b *Message) map[string][2]interface{} {
+	if a == nil {
+		a = new(Message)
+	}
+
+	if b == nil {
+		b = new(Message)
+	}
+
+	var (
+		diff = make(map[string][2]interface{})
+		av   = reflect.ValueOf(a).Elem()
+		bv   = reflect.ValueOf(b).Elem()
+		t    = av.Type()
+	)
+
+	for i := 0; i < t.NumField(); i++ {
+		af, bf := av.Field(i), bv.Field(i)
+		if !equalFields(af, bf) {
+			diff[t.Field(i).Name] = [2]interface{}{fieldValue(af), fieldValue(bf)}
+		}
+	}
+
+	return diff
+}
+
+// equalFields compares two values of the same Message field
+func equalFields(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Slice, reflect.Map:
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	}
+
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// fieldValue returns the value reported by DiffMessages for a Message field
+func fieldValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+
+		return v.Elem().Interface()
+	}
+
+	return v.Interface()
+}
@@ -0,0 +1,52 @@
+package wrp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffMessages(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		original = Message{
+			Type:        SimpleRequestResponseMessageType,
+			Source:      "dns:talaria.comcast.net",
+			Destination: "mac:112233445566",
+			Headers:     []string{"X-Test: true"},
+			Metadata:    map[string]string{"/boot-time": "1234"},
+			Spans:       [][]string{{"first", "1", "2"}},
+			Payload:     []byte("payload"),
+		}
+
+		altered = original
+	)
+
+	assert.Empty(DiffMessages(&original, &altered))
+	assert.Empty(DiffMessages(nil, nil))
+	assert.Empty(DiffMessages(nil, &Message{Headers: []string{}, Metadata: map[string]string{}}))
+
+	altered.Destination = "mac:665544332211"
+	altered.Metadata = map[string]string{"/boot-time": "5678"}
+	altered.Spans = [][]string{{"first", "1", "2"}, {"second", "3", "4"}}
+	altered.SetStatus(200)
+
+	assert.Equal(
+		map[string][2]interface{}{
+			"Destination": {"mac:112233445566", "mac:665544332211"},
+			"Metadata":    {map[string]string{"/boot-time": "1234"}, map[string]string{"/boot-time": "5678"}},
+			"Spans":       {[][]string{{"first", "1", "2"}}, [][]string{{"first", "1", "2"}, {"second", "3", "4"}}},
+			"Status":      {nil, int64(200)},
+		},
+		DiffMessages(&original, &altered),
+	)
+
+	// neither message is modified
+	assert.Nil(original.Status)
+	assert.Equal("mac:112233445566", original.Destination)
+
+	diff := DiffMessages(&original, nil)
+	assert.Len(diff, 7)
+	assert.Equal([2]interface{}{[]byte("payload"), []byte(nil)}, diff["Payload"])
+}
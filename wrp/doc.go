@@ -55,7 +55,7 @@ Some common uses of this package include:
 	// transcoding, using pools:
 	var (
 		decoderPool = NewDecoderPool(100, JSON)
-		encoderPool = NewEncoderPool(100, Msgpack)
+		encoderPool = NewEncoderPool(100, 0, Msgpack)
 	)
 
 	func jsonToMsgpackUsingPools(source io.Reader) ([]byte, error) {
@@ -68,6 +68,10 @@ Some common uses of this package include:
 		defer decoderPool.Put(decoder)
 		defer encoderPool.Put(encoder)
 
+		// pooled encoders and decoders must be reset before each use
+		decoder.Reset(source)
+		encoder.Reset(&buffer)
+
 		// TranscodeMessage returns a *Message as its first value, which contains
 		// the generic WRP message data
 		if _, err := TranscodeMessage(encoder, decoder); err != nil {
@@ -0,0 +1,196 @@
+package wrp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"sort"
+)
+
+// Equal tests if two messages are semantically the same, comparing every field.  Pointer fields, such as Status,
+// are compared by the values they point to.  As with DiffMessages, an empty slice or map is equal to a nil one,
+// since neither appears on the wire.  Two nil messages are equal, but a nil message never equals a non-nil one.
+func (msg *Message) Equal(other *Message) bool {
+	if msg == nil || other == nil {
+		return msg == other
+	}
+
+	return msg.Type == other.Type &&
+		msg.Source == other.Source &&
+		msg.Destination == other.Destination &&
+		msg.TransactionUUID == other.TransactionUUID &&
+		msg.ContentType == other.ContentType &&
+		msg.Accept == other.Accept &&
+		equalInt64s(msg.Status, other.Status) &&
+		equalInt64s(msg.RequestDeliveryResponse, other.RequestDeliveryResponse) &&
+		equalStrings(msg.Headers, other.Headers) &&
+		equalMetadata(msg.Metadata, other.Metadata) &&
+		equalSpans(msg.Spans, other.Spans) &&
+		equalBools(msg.IncludeSpans, other.IncludeSpans) &&
+		msg.Path == other.Path &&
+		bytes.Equal(msg.Payload, other.Payload) &&
+		msg.ServiceName == other.ServiceName &&
+		msg.URL == other.URL &&
+		equalStrings(msg.PartnerIDs, other.PartnerIDs) &&
+		equalInt64s(msg.Expires, other.Expires) &&
+		msg.ChainID == other.ChainID
+}
+
+func equalInt64s(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+func equalBools(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalMetadata(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, av := range a {
+		if bv, ok := b[k]; !ok || av != bv {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalSpans(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !equalStrings(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Hash returns a 64-bit FNV-1a hash of this message's contents, suitable as a key for deduplicating messages.
+// Messages that are Equal always have the same hash, and the hash of a given message is stable across processes
+// and releases, so it may be stored.  Metadata is hashed in key order, so map iteration order has no effect.
+//
+// This hash is NOT cryptographic.  Distinct messages can collide, and collisions can be constructed deliberately,
+// so never use this hash where a malicious sender could benefit from a collision.
+func (msg *Message) Hash() uint64 {
+	h := messageHasher{Hash64: fnv.New64a()}
+	if msg == nil {
+		return h.Sum64()
+	}
+
+	h.writeInt64(int64(msg.Type))
+	h.writeString(msg.Source)
+	h.writeString(msg.Destination)
+	h.writeString(msg.TransactionUUID)
+	h.writeString(msg.ContentType)
+	h.writeString(msg.Accept)
+	h.writeOptionalInt64(msg.Status)
+	h.writeOptionalInt64(msg.RequestDeliveryResponse)
+	h.writeStrings(msg.Headers)
+
+	keys := make([]string, 0, len(msg.Metadata))
+	for k := range msg.Metadata {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	h.writeLength(len(keys))
+	for _, k := range keys {
+		h.writeString(k)
+		h.writeString(msg.Metadata[k])
+	}
+
+	h.writeLength(len(msg.Spans))
+	for _, span := range msg.Spans {
+		h.writeStrings(span)
+	}
+
+	if msg.IncludeSpans == nil {
+		h.writeLength(0)
+	} else if *msg.IncludeSpans {
+		h.writeLength(2)
+	} else {
+		h.writeLength(1)
+	}
+
+	h.writeString(msg.Path)
+	h.writeBytes(msg.Payload)
+	h.writeString(msg.ServiceName)
+	h.writeString(msg.URL)
+	h.writeStrings(msg.PartnerIDs)
+	h.writeOptionalInt64(msg.Expires)
+	h.writeString(msg.ChainID)
+
+	return h.Sum64()
+}
+
+// messageHasher writes message fields unambiguously, prefixing each variable-length value with its length so
+// that, for example, the Source "ab" with the Destination "c" hashes differently than "a" with "bc"
+type messageHasher struct {
+	hash.Hash64
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (mh *messageHasher) writeLength(n int) {
+	mh.Write(mh.scratch[:binary.PutUvarint(mh.scratch[:], uint64(n))])
+}
+
+func (mh *messageHasher) writeInt64(v int64) {
+	mh.Write(mh.scratch[:binary.PutVarint(mh.scratch[:], v)])
+}
+
+func (mh *messageHasher) writeOptionalInt64(v *int64) {
+	if v == nil {
+		mh.writeLength(0)
+		return
+	}
+
+	mh.writeLength(1)
+	mh.writeInt64(*v)
+}
+
+func (mh *messageHasher) writeString(v string) {
+	mh.writeLength(len(v))
+	mh.Write([]byte(v))
+}
+
+func (mh *messageHasher) writeBytes(v []byte) {
+	mh.writeLength(len(v))
+	mh.Write(v)
+}
+
+func (mh *messageHasher) writeStrings(v []string) {
+	mh.writeLength(len(v))
+	for _, s := range v {
+		mh.writeString(s)
+	}
+}
@@ -0,0 +1,149 @@
+package wrp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newEqualTestMessage creates a message with every field set, using freshly allocated pointers, slices, and maps
+func newEqualTestMessage() *Message {
+	var (
+		status       int64 = 200
+		rdr          int64 = 1
+		expires      int64 = 1234567890
+		includeSpans       = true
+	)
+
+	return &Message{
+		Type:                    SimpleRequestResponseMessageType,
+		Source:                  "dns:talaria.example.com",
+		Destination:             "mac:112233445566/config",
+		TransactionUUID:         "1234",
+		ContentType:             "application/json",
+		Accept:                  "application/json",
+		Status:                  &status,
+		RequestDeliveryResponse: &rdr,
+		Headers:                 []string{"X-Test: 1", "X-Test: 2"},
+		Metadata:                map[string]string{"/boot-time": "1", "/hw-model": "model"},
+		Spans:                   [][]string{{"parent", "name", "1", "2", "0"}},
+		IncludeSpans:            &includeSpans,
+		Path:                    "/config",
+		Payload:                 []byte(`{"key": "value"}`),
+		ServiceName:             "config",
+		URL:                     "http://config.example.com",
+		PartnerIDs:              []string{"comcast"},
+		Expires:                 &expires,
+		ChainID:                 "chain",
+	}
+}
+
+func testMessageEqualSame(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		a      = newEqualTestMessage()
+		b      = newEqualTestMessage()
+	)
+
+	assert.True(a.Equal(b))
+	assert.True(b.Equal(a))
+	assert.True(a.Equal(a))
+	assert.Equal(a.Hash(), b.Hash())
+
+	// empty slices and maps are equivalent to nil ones
+	empty := &Message{Type: SimpleEventMessageType, Headers: []string{}, Metadata: map[string]string{}, Spans: [][]string{}, Payload: []byte{}}
+	unset := &Message{Type: SimpleEventMessageType}
+	assert.True(empty.Equal(unset))
+	assert.Equal(empty.Hash(), unset.Hash())
+
+	var nilMessage *Message
+	assert.True(nilMessage.Equal(nil))
+	assert.False(nilMessage.Equal(unset))
+	assert.False(unset.Equal(nil))
+	assert.Equal(nilMessage.Hash(), nilMessage.Hash())
+}
+
+func testMessageEqualDifferent(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		changes = map[string]func(*Message){
+			"Type":                    func(m *Message) { m.Type = SimpleEventMessageType },
+			"Source":                  func(m *Message) { m.Source = "dns:other.example.com" },
+			"Destination":             func(m *Message) { m.Destination = "mac:112233445566" },
+			"TransactionUUID":         func(m *Message) { m.TransactionUUID = "5678" },
+			"ContentType":             func(m *Message) { m.ContentType = "text/plain" },
+			"Accept":                  func(m *Message) { m.Accept = "text/plain" },
+			"Status":                  func(m *Message) { m.SetStatus(500) },
+			"StatusUnset":             func(m *Message) { m.Status = nil },
+			"RequestDeliveryResponse": func(m *Message) { m.SetRequestDeliveryResponse(2) },
+			"Headers":                 func(m *Message) { m.Headers[1] = "X-Test: 3" },
+			"HeadersLength":           func(m *Message) { m.Headers = m.Headers[:1] },
+			"Metadata":                func(m *Message) { m.Metadata["/hw-model"] = "other" },
+			"MetadataKey":             func(m *Message) { delete(m.Metadata, "/hw-model"); m.Metadata["/fw-name"] = "model" },
+			"Spans":                   func(m *Message) { m.Spans[0][1] = "other" },
+			"SpansLength":             func(m *Message) { m.Spans = append(m.Spans, []string{"child"}) },
+			"IncludeSpans":            func(m *Message) { m.SetIncludeSpans(false) },
+			"IncludeSpansUnset":       func(m *Message) { m.IncludeSpans = nil },
+			"Path":                    func(m *Message) { m.Path = "/other" },
+			"Payload":                 func(m *Message) { m.Payload[0] = '[' },
+			"ServiceName":             func(m *Message) { m.ServiceName = "other" },
+			"URL":                     func(m *Message) { m.URL = "http://other.example.com" },
+			"PartnerIDs":              func(m *Message) { m.PartnerIDs = nil },
+			"Expires":                 func(m *Message) { m.Expires = nil },
+			"ChainID":                 func(m *Message) { m.ChainID = "other" },
+		}
+
+		original = newEqualTestMessage()
+		hashes   = map[uint64]string{original.Hash(): "original"}
+	)
+
+	// a field added to Message must also be added to Equal and Hash
+	messageType := reflect.TypeOf(Message{})
+	for i := 0; i < messageType.NumField(); i++ {
+		assert.Contains(changes, messageType.Field(i).Name)
+	}
+
+	for name, change := range changes {
+		changed := newEqualTestMessage()
+		change(changed)
+
+		assert.False(original.Equal(changed), name)
+		assert.False(changed.Equal(original), name)
+
+		hash := changed.Hash()
+		assert.NotContains(hashes, hash, "%s collides with %s", name, hashes[hash])
+		hashes[hash] = name
+	}
+}
+
+func testMessageHashUnambiguous(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		a      = &Message{Type: SimpleEventMessageType, Source: "ab", Destination: "c"}
+		b      = &Message{Type: SimpleEventMessageType, Source: "a", Destination: "bc"}
+		c      = &Message{Type: SimpleEventMessageType, Headers: []string{"a", "b"}}
+		d      = &Message{Type: SimpleEventMessageType, Headers: []string{"ab"}}
+	)
+
+	assert.NotEqual(a.Hash(), b.Hash())
+	assert.NotEqual(c.Hash(), d.Hash())
+}
+
+func testMessageHashStable(t *testing.T) {
+	assert := assert.New(t)
+
+	// the hash may be stored as a dedup key, so it must not change between releases
+	for i := 0; i < 10; i++ {
+		assert.Equal(uint64(0x90b8d217aa9879b0), newEqualTestMessage().Hash())
+	}
+
+	assert.Equal(uint64(0xcbf29ce484222325), (*Message)(nil).Hash(), "a nil message hashes as empty input")
+}
+
+func TestMessageEqual(t *testing.T) {
+	t.Run("Same", testMessageEqualSame)
+	t.Run("Different", testMessageEqualDifferent)
+	t.Run("HashUnambiguous", testMessageHashUnambiguous)
+	t.Run("HashStable", testMessageHashStable)
+}
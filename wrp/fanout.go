@@ -0,0 +1,39 @@
+package wrp
+
+import "strconv"
+
+// FanOut produces a copy of a SimpleEvent message for each of the given destinations, passing each copy to visit
+// in order.  Copies are produced one at a time, so a large number of destinations never requires a large slice of
+// messages.  Each copy is a distinct *Message that visit may retain, e.g. for asynchronous delivery.
+//
+// All copies share the base message's Payload, Headers, Metadata, and Spans, so the payload is encoded once by
+// the caller and never copied.  Neither visit nor anything it hands a copy to should modify these fields.  If the
+// base message has a TransactionUUID, each copy is given a distinct one of the form TransactionUUID-N, where N
+// is the index of its destination.
+//
+// The base message must be a SimpleEventMessageType, or ErrInvalidMsgType is returned.  Each destination is
+// checked with ParseLocator before its copy is produced, and the first invalid destination or the first error
+// returned by visit stops the fan-out and is returned.  The base message itself is never modified.
+func FanOut(base *Message, destinations []string, visit func(*Message) error) error {
+	if base.Type != SimpleEventMessageType {
+		return ErrInvalidMsgType
+	}
+
+	for i, destination := range destinations {
+		if _, err := ParseLocator(destination); err != nil {
+			return err
+		}
+
+		fanned := *base
+		fanned.Destination = destination
+		if len(base.TransactionUUID) > 0 {
+			fanned.TransactionUUID = base.TransactionUUID + "-" + strconv.Itoa(i)
+		}
+
+		if err := visit(&fanned); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
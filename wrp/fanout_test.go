@@ -0,0 +1,116 @@
+package wrp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFanOutSuccess(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		base = Message{
+			Type:            SimpleEventMessageType,
+			Source:          "dns:talaria.example.com",
+			TransactionUUID: "1234",
+			ContentType:     "application/json",
+			Headers:         []string{"X-Test: value"},
+			Payload:         []byte(`{"status":"online"}`),
+		}
+
+		original     = base
+		destinations = []string{"dns:listener1.example.com/events", "dns:listener2.example.com/events", "event:device-status"}
+		fanned       []*Message
+	)
+
+	require.NoError(FanOut(&base, destinations, func(m *Message) error {
+		fanned = append(fanned, m)
+		return nil
+	}))
+
+	require.Len(fanned, len(destinations))
+	seen := make(map[string]bool, len(fanned))
+	for i, m := range fanned {
+		assert.Equal(destinations[i], m.Destination)
+		assert.Equal(base.Source, m.Source)
+		assert.Equal(base.ContentType, m.ContentType)
+		assert.Equal(base.Headers, m.Headers)
+
+		// the payload is shared, never copied
+		assert.True(&base.Payload[0] == &m.Payload[0])
+
+		assert.False(seen[m.TransactionUUID], "duplicate transaction %s", m.TransactionUUID)
+		seen[m.TransactionUUID] = true
+		assert.NotEqual(base.TransactionUUID, m.TransactionUUID)
+	}
+
+	assert.Equal(original, base)
+}
+
+func testFanOutNoTransaction(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		base   = Message{Type: SimpleEventMessageType, Payload: []byte("event")}
+		count  int
+	)
+
+	assert.NoError(FanOut(&base, []string{"dns:one.example.com", "dns:two.example.com"}, func(m *Message) error {
+		assert.Empty(m.TransactionUUID)
+		count++
+		return nil
+	}))
+
+	assert.Equal(2, count)
+	assert.NoError(FanOut(&base, nil, func(*Message) error {
+		assert.Fail("visit should not be called without destinations")
+		return nil
+	}))
+}
+
+func testFanOutErrors(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected")
+		visited       []string
+		visit         = func(m *Message) error {
+			visited = append(visited, m.Destination)
+			return nil
+		}
+	)
+
+	assert.Equal(
+		ErrInvalidMsgType,
+		FanOut(&Message{Type: SimpleRequestResponseMessageType}, []string{"dns:one.example.com"}, visit),
+	)
+
+	assert.Empty(visited)
+
+	base := &Message{Type: SimpleEventMessageType}
+	assert.Equal(
+		ErrInvalidLocator,
+		FanOut(base, []string{"dns:one.example.com", "nonsense", "dns:two.example.com"}, visit),
+	)
+
+	assert.Equal([]string{"dns:one.example.com"}, visited)
+
+	visited = nil
+	assert.Equal(
+		expectedError,
+		FanOut(base, []string{"dns:one.example.com", "dns:two.example.com"}, func(m *Message) error {
+			visited = append(visited, m.Destination)
+			return expectedError
+		}),
+	)
+
+	assert.Equal([]string{"dns:one.example.com"}, visited)
+}
+
+func TestFanOut(t *testing.T) {
+	t.Run("Success", testFanOutSuccess)
+	t.Run("NoTransaction", testFanOutNoTransaction)
+	t.Run("Errors", testFanOutErrors)
+}
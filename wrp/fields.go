@@ -0,0 +1,45 @@
+package wrp
+
+import (
+	"reflect"
+	"strings"
+)
+
+// wireTag is the struct tag used by both the JSON and Msgpack handles for field names
+const wireTag = "wrp"
+
+// messageFieldNames is computed once from the Message struct tags
+var messageFieldNames = fieldNames(reflect.TypeOf(Message{}))
+
+// fieldNames produces the mapping of Go field names onto wire names for the given struct type.
+// Fields without a wire tag, or with a tag of "-", are skipped.
+func fieldNames(t reflect.Type) map[string]string {
+	names := make(map[string]string, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get(wireTag)
+		if len(tag) == 0 || tag == "-" {
+			continue
+		}
+
+		if comma := strings.IndexByte(tag, ','); comma >= 0 {
+			tag = tag[:comma]
+		}
+
+		names[field.Name] = tag
+	}
+
+	return names
+}
+
+// FieldNames returns the authoritative mapping of Message field names onto the names used on the wire.
+// Every supported Format uses the same names, as both are driven by the same struct tags.  The returned
+// map is a copy and may be freely modified by callers.
+func FieldNames() map[string]string {
+	names := make(map[string]string, len(messageFieldNames))
+	for k, v := range messageFieldNames {
+		names[k] = v
+	}
+
+	return names
+}
@@ -0,0 +1,96 @@
+package wrp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// goldenFieldNames is the WRP schema.  Any change to the Message struct must be reflected here.
+var goldenFieldNames = map[string]string{
+	"Type":                    "msg_type",
+	"Source":                  "source",
+	"Destination":             "dest",
+	"TransactionUUID":         "transaction_uuid",
+	"ContentType":             "content_type",
+	"Accept":                  "accept",
+	"Status":                  "status",
+	"RequestDeliveryResponse": "rdr",
+	"Headers":                 "headers",
+	"Metadata":                "metadata",
+	"Spans":                   "spans",
+	"IncludeSpans":            "include_spans",
+	"Path":                    "path",
+	"Payload":                 "payload",
+	"ServiceName":             "service_name",
+	"URL":                     "url",
+	"PartnerIDs":              "partner_ids",
+	"Expires":                 "expires",
+	"ChainID":                 "chain_id",
+}
+
+func TestFieldNames(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		actual  = FieldNames()
+	)
+
+	assert.Equal(goldenFieldNames, actual)
+
+	// modifying the returned map must not affect subsequent calls
+	actual["Type"] = "modified"
+	assert.Equal("msg_type", FieldNames()["Type"])
+
+	messageType := reflect.TypeOf(Message{})
+	require.Equal(messageType.NumField(), len(goldenFieldNames), "every Message field must have a wire tag")
+	for i := 0; i < messageType.NumField(); i++ {
+		field := messageType.Field(i)
+		assert.NotEmpty(field.Tag.Get(wireTag), "field %s has no wire tag", field.Name)
+	}
+}
+
+func TestFieldNamesOnTheWire(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		status       int64 = 200
+		rdr          int64 = 1
+		includeSpans       = true
+		expires      int64 = 1900000000
+
+		message = Message{
+			Type:                    SimpleRequestResponseMessageType,
+			Source:                  "source",
+			Destination:             "dest",
+			TransactionUUID:         "uuid",
+			ContentType:             "text/plain",
+			Accept:                  "text/plain",
+			Status:                  &status,
+			RequestDeliveryResponse: &rdr,
+			Headers:                 []string{"header"},
+			Metadata:                map[string]string{"key": "value"},
+			Spans:                   [][]string{{"name", "1", "2"}},
+			IncludeSpans:            &includeSpans,
+			Path:                    "/path",
+			Payload:                 []byte("payload"),
+			ServiceName:             "service",
+			URL:                     "http://example.com",
+			PartnerIDs:              []string{"partner"},
+			Expires:                 &expires,
+			ChainID:                 "chain",
+		}
+
+		encoded map[string]interface{}
+	)
+
+	require.NoError(json.Unmarshal(MustEncode(&message, JSON), &encoded))
+	assert.Len(encoded, len(goldenFieldNames))
+	for _, wireName := range FieldNames() {
+		assert.Contains(encoded, wireName)
+	}
+}
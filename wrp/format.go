@@ -87,6 +87,41 @@ func FormatFromContentType(contentType string, fallback ...Format) (Format, erro
 	return Format(-1), fmt.Errorf("Invalid WRP content type: %s", contentType)
 }
 
+// ParseFormat converts a format name, e.g. "msgpack" or "json", into a Format.  Names are matched
+// case-insensitively and surrounding whitespace is ignored, so the output of String is also accepted.
+func ParseFormat(value string) (Format, error) {
+	value = strings.TrimSpace(value)
+	for _, f := range AllFormats() {
+		if strings.EqualFold(value, f.String()) {
+			return f, nil
+		}
+	}
+
+	return Format(-1), fmt.Errorf("Invalid WRP format: %s", value)
+}
+
+// MarshalText implements encoding.TextMarshaler, producing the lowercase name of this format.
+// An error is returned if this format is not a valid value.
+func (f Format) MarshalText() ([]byte, error) {
+	if f < Msgpack || f >= lastFormat {
+		return nil, fmt.Errorf("Invalid format constant: %d", f)
+	}
+
+	return []byte(strings.ToLower(f.String())), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler using ParseFormat.  This allows a Format
+// to be bound directly from configuration.
+func (f *Format) UnmarshalText(text []byte) error {
+	parsed, err := ParseFormat(string(text))
+	if err != nil {
+		return err
+	}
+
+	*f = parsed
+	return nil
+}
+
 // handle looks up the appropriate codec.Handle for this format constant.
 // This method panics if the format is not a valid value.
 func (f Format) handle() codec.Handle {
@@ -117,6 +152,8 @@ type Encoder interface {
 // encoderDecorator wraps a ugorji Encoder and implements the wrp.Encoder interface.
 type encoderDecorator struct {
 	*codec.Encoder
+	compressionThreshold int
+	canonical            bool
 }
 
 // Encode checks to see if value implements EncoderTo and if it does, uses the
@@ -129,6 +166,13 @@ func (ed *encoderDecorator) Encode(value interface{}) error {
 		}
 	}
 
+	if ed.compressionThreshold > 0 {
+		var err error
+		if value, err = compressPayload(value, ed.compressionThreshold); err != nil {
+			return err
+		}
+	}
+
 	return ed.Encoder.Encode(value)
 }
 
@@ -139,32 +183,98 @@ type Decoder interface {
 	ResetBytes([]byte)
 }
 
+// decoderDecorator wraps a ugorji Decoder and implements the wrp.Decoder interface.
+type decoderDecorator struct {
+	*codec.Decoder
+	maxDecompressedSize int
+	decodeErrors        bool
+	wasCompressed       bool
+}
+
+// Decode decodes into the given value.  If this decoder was created with WithPayloadDecompression,
+// any payload that was compressed by an Encoder configured with WithPayloadCompression is transparently
+// decompressed.  If this decoder was created with WithDecodeErrors, failures are reported as a *DecodeError.
+func (dd *decoderDecorator) Decode(value interface{}) error {
+	dd.wasCompressed = false
+	if err := dd.Decoder.Decode(value); err != nil {
+		return dd.decodeError(err)
+	}
+
+	if dd.decodeErrors {
+		if err := checkMessageType(value); err != nil {
+			return err
+		}
+	}
+
+	if dd.maxDecompressedSize > 0 {
+		var err error
+		if dd.wasCompressed, err = decompressPayload(value, dd.maxDecompressedSize); err != nil {
+			return dd.decodeError(err)
+		}
+	}
+
+	return nil
+}
+
+// decodeError classifies err if this decoder was created with WithDecodeErrors, and otherwise returns it as is
+func (dd *decoderDecorator) decodeError(err error) error {
+	if dd.decodeErrors {
+		return newDecodeError(err)
+	}
+
+	return err
+}
+
 // NewEncoder produces a ugorji Encoder using the appropriate WRP configuration
-// for the given format
-func NewEncoder(output io.Writer, f Format) Encoder {
-	return &encoderDecorator{
-		codec.NewEncoder(output, f.handle()),
+// for the given format, with any optional behavior applied
+func NewEncoder(output io.Writer, f Format, options ...EncoderOption) Encoder {
+	ed := new(encoderDecorator)
+	for _, o := range options {
+		o(ed)
 	}
+
+	ed.Encoder = codec.NewEncoder(output, f.encodeHandle(ed.canonical))
+	return ed
 }
 
 // NewEncoderBytes produces a ugorji Encoder using the appropriate WRP configuration
-// for the given format
-func NewEncoderBytes(output *[]byte, f Format) Encoder {
-	return &encoderDecorator{
-		codec.NewEncoderBytes(output, f.handle()),
+// for the given format, with any optional behavior applied
+func NewEncoderBytes(output *[]byte, f Format, options ...EncoderOption) Encoder {
+	ed := new(encoderDecorator)
+	for _, o := range options {
+		o(ed)
 	}
+
+	ed.Encoder = codec.NewEncoderBytes(output, f.encodeHandle(ed.canonical))
+	return ed
 }
 
 // NewDecoder produces a ugorji Decoder using the appropriate WRP configuration
-// for the given format
-func NewDecoder(input io.Reader, f Format) Decoder {
-	return codec.NewDecoder(input, f.handle())
+// for the given format, with any optional behavior applied
+func NewDecoder(input io.Reader, f Format, options ...DecoderOption) Decoder {
+	dd := &decoderDecorator{
+		Decoder: codec.NewDecoder(input, f.handle()),
+	}
+
+	for _, o := range options {
+		o(dd)
+	}
+
+	return dd
 }
 
 // NewDecoderBytes produces a ugorji Decoder using the appropriate WRP configuration
-// for the given format
-func NewDecoderBytes(input []byte, f Format) Decoder {
-	return codec.NewDecoderBytes(input, f.handle())
+// for the given format, with any optional behavior applied
+func NewDecoderBytes(input []byte, f Format, options ...DecoderOption) Decoder {
+	dd := &decoderDecorator{
+		Decoder: codec.NewDecoderBytes(input, f.handle()),
+	}
+
+	for _, o := range options {
+		o(dd)
+	}
+
+	return dd
 }
 
 // TranscodeMessage converts a WRP message of any type from one format into another,
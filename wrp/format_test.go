@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"testing"
@@ -224,8 +225,74 @@ func testFormatContentType(t *testing.T) {
 	assert.Equal("application/octet-stream", Format(999).ContentType())
 }
 
+func testFormatText(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	for _, f := range AllFormats() {
+		text, err := f.MarshalText()
+		require.NoError(err)
+
+		var actual Format
+		require.NoError(actual.UnmarshalText(text))
+		assert.Equal(f, actual)
+	}
+
+	_, err := Format(-1).MarshalText()
+	assert.Error(err)
+	_, err = lastFormat.MarshalText()
+	assert.Error(err)
+
+	actual := JSON
+	assert.Error(actual.UnmarshalText([]byte("xml")))
+	assert.Equal(JSON, actual)
+
+	// a round trip through encoding/json exercises the text interfaces
+	var config struct {
+		Format Format `json:"format"`
+	}
+
+	require.NoError(json.Unmarshal([]byte(`{"format": "JSON"}`), &config))
+	assert.Equal(JSON, config.Format)
+	data, err := json.Marshal(config)
+	require.NoError(err)
+	assert.JSONEq(`{"format": "json"}`, string(data))
+}
+
+func TestParseFormat(t *testing.T) {
+	testData := []struct {
+		value    string
+		expected Format
+		valid    bool
+	}{
+		{"msgpack", Msgpack, true},
+		{"json", JSON, true},
+		{"MsgPack", Msgpack, true},
+		{"JSON", JSON, true},
+		{" json ", JSON, true},
+		{Msgpack.String(), Msgpack, true},
+		{"", Format(-1), false},
+		{"xml", Format(-1), false},
+		{"lastFormat", Format(-1), false},
+		{"application/json", Format(-1), false},
+	}
+
+	for _, record := range testData {
+		actual, err := ParseFormat(record.value)
+		assert.Equal(t, record.expected, actual, record.value)
+		if record.valid {
+			assert.NoError(t, err, record.value)
+		} else {
+			assert.Error(t, err, record.value)
+		}
+	}
+}
+
 func TestFormat(t *testing.T) {
 	t.Run("String", testFormatString)
+	t.Run("Text", testFormatText)
 	t.Run("Handle", testFormatHandle)
 	t.Run("ContentType", testFormatContentType)
 }
@@ -0,0 +1,153 @@
+package wrp
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+// ProtocolVersion identifies the layout of the msgpack frames a device uses for WRP messages
+type ProtocolVersion int
+
+const (
+	// ProtocolV1 is the legacy layout, in which each message is a msgpack array of field values whose positions
+	// are given by V1FieldOrder.  Trailing fields may be omitted, and nil elements denote unset fields.
+	ProtocolV1 ProtocolVersion = 1
+
+	// ProtocolV2 is the current layout, in which each message is a msgpack map keyed by wire field names.
+	// This is the default.
+	ProtocolV2 ProtocolVersion = 2
+)
+
+// V1FieldOrder is the position of each field, by wire name, within a ProtocolV1 message.  This differs from the
+// order of the Message struct in that the destination precedes the source and the payload follows the content type.
+// Fields added after ProtocolV2 was introduced, such as expires, have no position.
+var V1FieldOrder = []string{
+	"msg_type",
+	"dest",
+	"source",
+	"transaction_uuid",
+	"content_type",
+	"payload",
+	"accept",
+	"status",
+	"rdr",
+	"headers",
+	"metadata",
+	"spans",
+	"include_spans",
+	"path",
+	"service_name",
+	"url",
+	"partner_ids",
+}
+
+// ParseProtocolVersion converts text such as "1" or "v1" into a ProtocolVersion.  The empty string
+// is ProtocolV2.
+func ParseProtocolVersion(value string) (ProtocolVersion, error) {
+	if len(value) == 0 {
+		return ProtocolV2, nil
+	}
+
+	v, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(value), "v"))
+	if err != nil || (ProtocolVersion(v) != ProtocolV1 && ProtocolVersion(v) != ProtocolV2) {
+		return ProtocolVersion(-1), fmt.Errorf("Invalid protocol version: %s", value)
+	}
+
+	return ProtocolVersion(v), nil
+}
+
+func (v ProtocolVersion) String() string {
+	return "v" + strconv.Itoa(int(v))
+}
+
+// NewVersionedDecoder produces a Decoder for msgpack frames in the given protocol version.  Decoders for
+// ProtocolV1 normalize each message into the current layout, so any value that NewDecoder would accept,
+// such as a *Message, may be passed to Decode.  Any version other than ProtocolV1 uses the current layout.
+// The options apply to the normalized messages exactly as they would for NewDecoder.
+func NewVersionedDecoder(input io.Reader, v ProtocolVersion, options ...DecoderOption) Decoder {
+	if v != ProtocolV1 {
+		return NewDecoder(input, Msgpack, options...)
+	}
+
+	return newLegacyDecoder(codec.NewDecoder(input, &msgpackHandle), options)
+}
+
+// NewVersionedDecoderBytes is like NewVersionedDecoder, but decodes from a byte slice
+func NewVersionedDecoderBytes(input []byte, v ProtocolVersion, options ...DecoderOption) Decoder {
+	if v != ProtocolV1 {
+		return NewDecoderBytes(input, Msgpack, options...)
+	}
+
+	return newLegacyDecoder(codec.NewDecoderBytes(input, &msgpackHandle), options)
+}
+
+// legacyDecoder decodes positional messages by rekeying each element with its wire name, then decoding the
+// result exactly as a current message would be.  This trades speed for the guarantee that legacy messages
+// are interpreted identically to current ones.
+type legacyDecoder struct {
+	positional   *codec.Decoder
+	fields       []string
+	options      []DecoderOption
+	decodeErrors bool
+	normalized   []byte
+}
+
+func newLegacyDecoder(positional *codec.Decoder, options []DecoderOption) *legacyDecoder {
+	var settings decoderDecorator
+	for _, o := range options {
+		o(&settings)
+	}
+
+	return &legacyDecoder{
+		positional:   positional,
+		fields:       V1FieldOrder,
+		options:      options,
+		decodeErrors: settings.decodeErrors,
+	}
+}
+
+func (ld *legacyDecoder) Decode(value interface{}) error {
+	var elements []interface{}
+	if err := ld.positional.Decode(&elements); err != nil {
+		if ld.decodeErrors {
+			return newDecodeError(err)
+		}
+
+		return err
+	}
+
+	if len(elements) > len(ld.fields) {
+		err := fmt.Errorf("A legacy message cannot have more than %d fields, but had %d", len(ld.fields), len(elements))
+		if ld.decodeErrors {
+			return &DecodeError{Kind: ErrFieldType, Err: err}
+		}
+
+		return err
+	}
+
+	keyed := make(map[string]interface{}, len(elements))
+	for i, e := range elements {
+		if e != nil {
+			keyed[ld.fields[i]] = e
+		}
+	}
+
+	ld.normalized = ld.normalized[:0]
+	if err := codec.NewEncoderBytes(&ld.normalized, &msgpackHandle).Encode(keyed); err != nil {
+		return err
+	}
+
+	return NewDecoderBytes(ld.normalized, Msgpack, ld.options...).Decode(value)
+}
+
+func (ld *legacyDecoder) Reset(input io.Reader) {
+	ld.positional.Reset(input)
+}
+
+func (ld *legacyDecoder) ResetBytes(input []byte) {
+	ld.positional.ResetBytes(input)
+}
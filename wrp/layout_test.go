@@ -0,0 +1,142 @@
+package wrp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	// v1Fixture is a legacy, positional request:  [3, dest, source, transaction_uuid, content_type, payload,
+	// nil, nil, nil, headers, metadata]
+	v1Fixture = "9b03b76d61633a3131323233333434353536362f636f6e666967b6646e733a6c65676163792e6578616d706c652e636f6da4" +
+		"31323334aa746578742f706c61696ec40568656c6c6fc0c0c091ab582d4c65676163793a203181aa2f626f6f742d74696d65aa" +
+		"31353030303030303030"
+
+	// v2Fixture is the same request in the current layout
+	v2Fixture = "88a86d73675f7479706503a6736f75726365b6646e733a6c65676163792e6578616d706c652e636f6da464657374b76d6163" +
+		"3a3131323233333434353536362f636f6e666967b07472616e73616374696f6e5f75756964a431323334ac636f6e74656e745f" +
+		"74797065aa746578742f706c61696ea76865616465727391ab582d4c65676163793a2031a86d6574616461746181aa2f626f6f" +
+		"742d74696d65aa31353030303030303030a77061796c6f6164c40568656c6c6f"
+)
+
+var layoutFixtureMessage = Message{
+	Type:            SimpleRequestResponseMessageType,
+	Source:          "dns:legacy.example.com",
+	Destination:     "mac:112233445566/config",
+	TransactionUUID: "1234",
+	ContentType:     "text/plain",
+	Payload:         []byte("hello"),
+	Headers:         []string{"X-Legacy: 1"},
+	Metadata:        map[string]string{"/boot-time": "1500000000"},
+}
+
+func mustDecodeHex(t *testing.T, value string) []byte {
+	data, err := hex.DecodeString(value)
+	require.NoError(t, err)
+	return data
+}
+
+func testVersionedDecoderFixtures(t *testing.T) {
+	for _, record := range []struct {
+		version ProtocolVersion
+		fixture string
+	}{
+		{ProtocolV1, v1Fixture},
+		{ProtocolV2, v2Fixture},
+		{ProtocolVersion(0), v2Fixture},
+	} {
+		t.Run(record.version.String(), func(t *testing.T) {
+			var (
+				assert = assert.New(t)
+				data   = mustDecodeHex(t, record.fixture)
+
+				fromBytes  Message
+				fromReader Message
+			)
+
+			assert.NoError(NewVersionedDecoderBytes(data, record.version).Decode(&fromBytes))
+			assert.Equal(layoutFixtureMessage, fromBytes)
+
+			assert.NoError(NewVersionedDecoder(bytes.NewReader(data), record.version).Decode(&fromReader))
+			assert.Equal(layoutFixtureMessage, fromReader)
+		})
+	}
+}
+
+func testVersionedDecoderReset(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		decoder = NewVersionedDecoder(nil, ProtocolV1)
+		data    = mustDecodeHex(t, v1Fixture)
+	)
+
+	for i := 0; i < 2; i++ {
+		var actual Message
+		decoder.ResetBytes(data)
+		assert.NoError(decoder.Decode(&actual))
+		assert.Equal(layoutFixtureMessage, actual)
+	}
+
+	var actual Message
+	decoder.Reset(bytes.NewReader(data))
+	assert.NoError(decoder.Decode(&actual))
+	assert.Equal(layoutFixtureMessage, actual)
+}
+
+func testVersionedDecoderMismatch(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		actual Message
+	)
+
+	// a current message is not an array, so it cannot be decoded as a legacy message
+	assert.Error(NewVersionedDecoderBytes(mustDecodeHex(t, v2Fixture), ProtocolV1).Decode(&actual))
+}
+
+func testVersionedDecoderTooManyFields(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		data   []byte
+		actual Message
+	)
+
+	elements := make([]interface{}, len(V1FieldOrder)+1)
+	elements[0] = int64(SimpleEventMessageType)
+	assert.NoError(codec.NewEncoderBytes(&data, &msgpackHandle).Encode(elements))
+	assert.Error(NewVersionedDecoderBytes(data, ProtocolV1).Decode(&actual))
+}
+
+func TestVersionedDecoder(t *testing.T) {
+	t.Run("Fixtures", testVersionedDecoderFixtures)
+	t.Run("Reset", testVersionedDecoderReset)
+	t.Run("Mismatch", testVersionedDecoderMismatch)
+	t.Run("TooManyFields", testVersionedDecoderTooManyFields)
+}
+
+func TestParseProtocolVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	for value, expected := range map[string]ProtocolVersion{
+		"":   ProtocolV2,
+		"1":  ProtocolV1,
+		"v1": ProtocolV1,
+		"V1": ProtocolV1,
+		"2":  ProtocolV2,
+		"v2": ProtocolV2,
+	} {
+		actual, err := ParseProtocolVersion(value)
+		assert.Equal(expected, actual, value)
+		assert.NoError(err, value)
+	}
+
+	for _, invalid := range []string{"0", "v3", "garbage", "v"} {
+		actual, err := ParseProtocolVersion(invalid)
+		assert.Equal(ProtocolVersion(-1), actual, invalid)
+		assert.Error(err, invalid)
+	}
+}
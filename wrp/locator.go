@@ -0,0 +1,158 @@
+package wrp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+const (
+	MacScheme    = "mac"
+	UUIDScheme   = "uuid"
+	DNSScheme    = "dns"
+	SerialScheme = "serial"
+	EventScheme  = "event"
+
+	macLength = 12
+)
+
+var (
+	// ErrInvalidLocator indicates that a locator did not follow the scheme:authority/service/ignored grammar,
+	// or that its authority was not valid for its scheme
+	ErrInvalidLocator = errors.New("Invalid WRP locator")
+
+	// ErrUnsupportedScheme indicates that a locator's scheme is not one of the supported schemes
+	ErrUnsupportedScheme = errors.New("Unsupported WRP locator scheme")
+)
+
+// Locator is the parsed form of the WRP Source and Destination fields, which follow
+// the grammar scheme:authority[/service[/ignored]].
+type Locator struct {
+	// Scheme is the lowercased scheme, e.g. "mac" or "dns"
+	Scheme string
+
+	// Authority identifies the entity within the scheme, such as a device MAC address or a hostname
+	Authority string
+
+	// Service is the optional service name following the authority
+	Service string
+
+	// Ignored is everything after the service, if present, without a leading slash.  This portion
+	// of a locator is passed through as is.
+	Ignored string
+}
+
+// String returns the textual form of this locator
+func (l Locator) String() string {
+	var output bytes.Buffer
+	output.WriteString(l.Scheme)
+	output.WriteByte(':')
+	output.WriteString(l.Authority)
+
+	if len(l.Service) > 0 || len(l.Ignored) > 0 {
+		output.WriteByte('/')
+		output.WriteString(l.Service)
+	}
+
+	if len(l.Ignored) > 0 {
+		output.WriteByte('/')
+		output.WriteString(l.Ignored)
+	}
+
+	return output.String()
+}
+
+// validators holds the per-scheme authority checks.  A validator returns the canonical form of the
+// authority, or false if the authority is not valid for the scheme.
+var validators = map[string]func(string) (string, bool){
+	MacScheme:    validateMac,
+	UUIDScheme:   validateNonBlank,
+	DNSScheme:    validateDNS,
+	SerialScheme: validateNonBlank,
+	EventScheme:  validateNonBlank,
+}
+
+func validateMac(authority string) (string, bool) {
+	canonical := make([]byte, 0, macLength)
+	for i := 0; i < len(authority); i++ {
+		c := authority[i]
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f':
+			canonical = append(canonical, c)
+		case c >= 'A' && c <= 'F':
+			canonical = append(canonical, c+('a'-'A'))
+		case strings.IndexByte(":-.,", c) >= 0:
+			// delimiters are allowed, but dropped from the canonical form
+		default:
+			return "", false
+		}
+	}
+
+	return string(canonical), len(canonical) == macLength
+}
+
+func validateDNS(authority string) (string, bool) {
+	host := authority
+	if colon := strings.LastIndexByte(authority, ':'); colon >= 0 {
+		port := authority[colon+1:]
+		if len(port) == 0 || strings.Trim(port, "0123456789") != "" {
+			return "", false
+		}
+
+		host = authority[:colon]
+	}
+
+	if len(host) == 0 || host[0] == '.' || host[0] == '-' {
+		return "", false
+	}
+
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-') {
+			return "", false
+		}
+	}
+
+	return authority, true
+}
+
+func validateNonBlank(authority string) (string, bool) {
+	return authority, len(strings.TrimSpace(authority)) == len(authority) && len(authority) > 0
+}
+
+// ParseLocator parses a WRP Source or Destination value.  The scheme is matched case-insensitively
+// and must be one of mac, uuid, dns, serial, or event.  Mac authorities are canonicalized to 12 lowercase
+// hexadecimal digits without delimiters.
+func ParseLocator(value string) (Locator, error) {
+	colon := strings.IndexByte(value, ':')
+	if colon < 1 {
+		return Locator{}, ErrInvalidLocator
+	}
+
+	var (
+		l         = Locator{Scheme: strings.ToLower(value[:colon])}
+		remaining = value[colon+1:]
+	)
+
+	validator, ok := validators[l.Scheme]
+	if !ok {
+		return Locator{}, ErrUnsupportedScheme
+	}
+
+	if slash := strings.IndexByte(remaining, '/'); slash >= 0 {
+		l.Authority, remaining = remaining[:slash], remaining[slash+1:]
+		if slash = strings.IndexByte(remaining, '/'); slash >= 0 {
+			l.Service, l.Ignored = remaining[:slash], remaining[slash+1:]
+		} else {
+			l.Service = remaining
+		}
+	} else {
+		l.Authority = remaining
+	}
+
+	if l.Authority, ok = validator(l.Authority); !ok {
+		return Locator{}, ErrInvalidLocator
+	}
+
+	return l, nil
+}
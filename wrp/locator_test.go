@@ -0,0 +1,73 @@
+package wrp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testParseLocatorValid(t *testing.T) {
+	testData := []struct {
+		value    string
+		expected Locator
+		str      string
+	}{
+		{"mac:112233445566", Locator{Scheme: "mac", Authority: "112233445566"}, "mac:112233445566"},
+		{"MAC:11:22:33:AA:BB:CC/config", Locator{Scheme: "mac", Authority: "112233aabbcc", Service: "config"}, "mac:112233aabbcc/config"},
+		{"mac:11-22-33-44-55-66/service/ignored/stuff", Locator{"mac", "112233445566", "service", "ignored/stuff"}, "mac:112233445566/service/ignored/stuff"},
+		{"dns:talaria.example.com", Locator{Scheme: "dns", Authority: "talaria.example.com"}, "dns:talaria.example.com"},
+		{"dns:localhost:8080/api", Locator{Scheme: "dns", Authority: "localhost:8080", Service: "api"}, "dns:localhost:8080/api"},
+		{"event:device-status/mac:112233445566/online", Locator{"event", "device-status", "mac:112233445566", "online"}, "event:device-status/mac:112233445566/online"},
+		{"serial:ABC123", Locator{Scheme: "serial", Authority: "ABC123"}, "serial:ABC123"},
+		{"uuid:1111-22-333333", Locator{Scheme: "uuid", Authority: "1111-22-333333"}, "uuid:1111-22-333333"},
+		{"dns:host.com//ignored", Locator{Scheme: "dns", Authority: "host.com", Ignored: "ignored"}, "dns:host.com//ignored"},
+	}
+
+	for _, record := range testData {
+		t.Run(record.value, func(t *testing.T) {
+			assert := assert.New(t)
+			actual, err := ParseLocator(record.value)
+			assert.NoError(err)
+			assert.Equal(record.expected, actual)
+			assert.Equal(record.str, actual.String())
+		})
+	}
+}
+
+func testParseLocatorInvalid(t *testing.T) {
+	testData := []struct {
+		value    string
+		expected error
+	}{
+		{"", ErrInvalidLocator},
+		{"nocolon", ErrInvalidLocator},
+		{":112233445566", ErrInvalidLocator},
+		{"ftp:somewhere", ErrUnsupportedScheme},
+		{"mac:", ErrInvalidLocator},
+		{"mac:1122334455", ErrInvalidLocator},
+		{"mac:1122334455667788", ErrInvalidLocator},
+		{"mac:11223344556g", ErrInvalidLocator},
+		{"dns:", ErrInvalidLocator},
+		{"dns:bad_host.com", ErrInvalidLocator},
+		{"dns:host.com:", ErrInvalidLocator},
+		{"dns:host.com:port", ErrInvalidLocator},
+		{"dns:.host.com", ErrInvalidLocator},
+		{"event:", ErrInvalidLocator},
+		{"event: padded", ErrInvalidLocator},
+		{"serial:/service", ErrInvalidLocator},
+	}
+
+	for _, record := range testData {
+		t.Run(record.value, func(t *testing.T) {
+			assert := assert.New(t)
+			actual, err := ParseLocator(record.value)
+			assert.Equal(record.expected, err)
+			assert.Equal(Locator{}, actual)
+		})
+	}
+}
+
+func TestParseLocator(t *testing.T) {
+	t.Run("Valid", testParseLocatorValid)
+	t.Run("Invalid", testParseLocatorInvalid)
+}
@@ -2,6 +2,39 @@ package wrp
 
 //go:generate codecgen -st "wrp" -o messages_codec.go messages.go
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrEmptyLocator indicates that a source or destination locator was required but not supplied
+	ErrEmptyLocator = errors.New("WRP locators cannot be empty")
+
+	// ErrBatchTooLarge indicates that a Batch holds more messages than the decoding side allows
+	ErrBatchTooLarge = errors.New("WRP batch holds too many messages")
+)
+
+// The delivery acknowledgement convention:  a sender requests a lightweight acknowledgement by setting
+// a TransactionUUID and setting RequestDeliveryResponse to AckRequested.  The receiver confirms delivery
+// with a SimpleRequestResponse of the same TransactionUUID, with no payload and with the AckKey metadata
+// set to AckDelivered.  Any message type may request an acknowledgement, including those that do not
+// otherwise support transactions.  Since acknowledgements are marked explicitly, an ordinary response
+// without a payload is never mistaken for one.
+const (
+	// AckRequested is the RequestDeliveryResponse value that asks the receiver for a delivery acknowledgement
+	AckRequested int64 = 1
+
+	// AckKey is the Metadata key which marks a delivery acknowledgement
+	AckKey = "/wrp/ack"
+
+	// AckDelivered is the AckKey value carried by a delivery acknowledgement
+	AckDelivered = "delivered"
+)
+
 // Typed is implemented by any WRP type which is associated with a MessageType.  All
 // message types implement this interface.
 type Typed interface {
@@ -80,6 +113,8 @@ type Message struct {
 	ServiceName             string            `wrp:"service_name,omitempty"`
 	URL                     string            `wrp:"url,omitempty"`
 	PartnerIDs              []string          `wrp:"partner_ids,omitempty"`
+	Expires                 *int64            `wrp:"expires,omitempty"`
+	ChainID                 string            `wrp:"chain_id,omitempty"`
 }
 
 func (msg *Message) MessageType() MessageType {
@@ -130,6 +165,129 @@ func (msg *Message) SetIncludeSpans(value bool) *Message {
 	return msg
 }
 
+// SetExpires simplifies setting the optional Expires field, which is the absolute time after which this message
+// must not be delivered.  The expiry is stored as seconds since the epoch, so any fraction of a second is truncated.
+func (msg *Message) SetExpires(t time.Time) *Message {
+	value := t.Unix()
+	msg.Expires = &value
+	return msg
+}
+
+// ExpiresAt returns the absolute expiry of this message, if it has one.  A nonpositive Expires is treated as unset.
+func (msg *Message) ExpiresAt() (time.Time, bool) {
+	if msg.Expires == nil || *msg.Expires <= 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(*msg.Expires, 0), true
+}
+
+// Expired tests if this message has an expiry that is at or before the given time
+func (msg *Message) Expired(now time.Time) bool {
+	expires, ok := msg.ExpiresAt()
+	return ok && !now.Before(expires)
+}
+
+// IsChainPart tests if this message belongs to a chain, which is an ordered sequence of transactions
+// between the same two endpoints.  Only messages that are part of a transaction can be chained.
+func (msg *Message) IsChainPart() bool {
+	return len(msg.ChainID) > 0 && msg.IsTransactionPart()
+}
+
+// RequiresAck tests if this message requests a delivery acknowledgement from its receiver
+func (msg *Message) RequiresAck() bool {
+	return len(msg.TransactionUUID) > 0 &&
+		msg.RequestDeliveryResponse != nil &&
+		*msg.RequestDeliveryResponse == AckRequested
+}
+
+// IsAck tests if this message is a delivery acknowledgement.  Only a message whose type supports
+// transactions can be an acknowledgement.
+func (msg *Message) IsAck() bool {
+	return msg.IsTransactionPart() &&
+		msg.Metadata[AckKey] == AckDelivered &&
+		len(msg.Payload) == 0
+}
+
+// Ack produces the delivery acknowledgement for this message.  The acknowledgement is a SimpleRequestResponse
+// sent from newSource to this message's Source, and carries only the TransactionUUID of this message.
+func (msg *Message) Ack(newSource string) *Message {
+	return &Message{
+		Type:            SimpleRequestResponseMessageType,
+		Source:          newSource,
+		Destination:     msg.Source,
+		TransactionUUID: msg.TransactionUUID,
+		Metadata:        map[string]string{AckKey: AckDelivered},
+	}
+}
+
+// AppendSpan adds a span to this message in the standard WRP form of {"name", "start_time", "duration"}.
+// The start time is expressed as milliseconds since the epoch, and the duration is in milliseconds.
+func (msg *Message) AppendSpan(name string, start time.Time, duration time.Duration) *Message {
+	msg.Spans = append(
+		msg.Spans,
+		[]string{
+			name,
+			strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10),
+			strconv.FormatInt(int64(duration/time.Millisecond), 10),
+		},
+	)
+
+	return msg
+}
+
+// Forward produces a shallow clone of this message suitable for relaying to another hop.  The clone's
+// Source and Destination are set to newSource and newDest, respectively, and everything else, e.g. TransactionUUID
+// and Headers, is preserved.  A span named "forward:" + newDest is appended to the clone to record the hop, but
+// the clone's spans never share storage with this message.
+//
+// Both newSource and newDest must be nonempty, or ErrEmptyLocator is returned.
+func (msg *Message) Forward(newSource, newDest string) (*Message, error) {
+	if len(newSource) == 0 || len(newDest) == 0 {
+		return nil, ErrEmptyLocator
+	}
+
+	forwarded := *msg
+	forwarded.Source = newSource
+	forwarded.Destination = newDest
+	forwarded.Spans = make([][]string, len(msg.Spans), len(msg.Spans)+1)
+	copy(forwarded.Spans, msg.Spans)
+	forwarded.AppendSpan("forward:"+newDest, time.Now(), 0)
+
+	return &forwarded, nil
+}
+
+// RedactedValue replaces sensitive values in messages produced by Redacted
+const RedactedValue = "<redacted>"
+
+// Redacted produces a shallow clone of this message that is safe to log.  The clone has no Payload, and the
+// values of its Headers and Metadata are replaced with RedactedValue, although their names are preserved.
+// Routing information, such as Source, Destination, and TransactionUUID, is left intact.
+func (msg *Message) Redacted() *Message {
+	redacted := *msg
+	redacted.Payload = nil
+
+	if len(msg.Headers) > 0 {
+		redacted.Headers = make([]string, len(msg.Headers))
+		for i, h := range msg.Headers {
+			if colon := strings.IndexByte(h, ':'); colon >= 0 {
+				redacted.Headers[i] = h[:colon+1] + RedactedValue
+			} else {
+				redacted.Headers[i] = RedactedValue
+			}
+		}
+	}
+
+	if len(msg.Metadata) > 0 {
+		redacted.Metadata = make(map[string]string, len(msg.Metadata))
+		for k := range msg.Metadata {
+			redacted.Metadata[k] = RedactedValue
+		}
+	}
+
+	return &redacted
+}
+
 // SimpleRequestResponse represents a WRP message of type SimpleRequestResponseMessageType.
 //
 // https://github.com/Comcast/wrp-c/wiki/Web-Routing-Protocol#simple-request-response-definition
@@ -357,3 +515,86 @@ func (msg *ServiceAlive) BeforeEncode() error {
 	msg.Type = ServiceAliveMessageType
 	return nil
 }
+
+// CreditGrant represents a WRP message of type CreditGrantMessageType.  A device that negotiated credit-based
+// flow control when it connected sends this message to allow the server to write Credits more messages to it.
+type CreditGrant struct {
+	// Type is exposed principally for encoding.  This field *must* be set to CreditGrantMessageType,
+	// and is automatically set by the BeforeEncode method.
+	Type    MessageType `wrp:"msg_type"`
+	Credits int64       `wrp:"credits"`
+}
+
+func (msg *CreditGrant) BeforeEncode() error {
+	msg.Type = CreditGrantMessageType
+	return nil
+}
+
+const (
+	// DisconnectReasonShutdown is the Disconnect reason for a device that is shutting down or rebooting
+	DisconnectReasonShutdown = "shutdown"
+
+	// DisconnectReasonSleep is the Disconnect reason for a device entering a planned sleep, e.g. to save battery
+	DisconnectReasonSleep = "sleep"
+)
+
+// Disconnect represents a WRP message of type DisconnectMessageType.  A device sends this message to announce
+// that it intends to disconnect, so that the server can finish writing any queued messages and close the
+// connection gracefully rather than treating the disconnection as a failure.  Reason is free-form, though
+// devices should use one of the DisconnectReason constants where applicable.
+type Disconnect struct {
+	// Type is exposed principally for encoding.  This field *must* be set to DisconnectMessageType,
+	// and is automatically set by the BeforeEncode method.
+	Type   MessageType `wrp:"msg_type"`
+	Reason string      `wrp:"reason,omitempty"`
+}
+
+func (msg *Disconnect) MessageType() MessageType {
+	return msg.Type
+}
+
+func (msg *Disconnect) BeforeEncode() error {
+	msg.Type = DisconnectMessageType
+	return nil
+}
+
+// Batch represents a WRP message of type BatchMessageType, which carries several messages to a device in a
+// single frame.  Each element of Messages is a complete encoded message, in the same format as the batch itself,
+// and elements are in the order the messages were sent.  A device unpacks a batch by decoding each element as
+// though it had arrived in its own frame.  Batches are only sent to devices that have agreed to receive them.
+type Batch struct {
+	// Type is exposed principally for encoding.  This field *must* be set to BatchMessageType,
+	// and is automatically set by the BeforeEncode method.
+	Type     MessageType `wrp:"msg_type"`
+	Messages [][]byte    `wrp:"messages"`
+}
+
+func (msg *Batch) MessageType() MessageType {
+	return msg.Type
+}
+
+func (msg *Batch) BeforeEncode() error {
+	msg.Type = BatchMessageType
+	return nil
+}
+
+// DecodeBatch decodes a Batch with the given Decoder, which must already be reset to the batch frame, and returns
+// the contained messages in order.  Each returned element is a complete encoded message which can be decoded as
+// though it had arrived in its own frame.  If maxMessages is positive and the batch holds more messages than that,
+// ErrBatchTooLarge is returned.  A frame that decodes but is not a Batch is reported as an ErrUnknownType DecodeError.
+func DecodeBatch(decoder Decoder, maxMessages int) ([][]byte, error) {
+	var batch Batch
+	if err := decoder.Decode(&batch); err != nil {
+		return nil, err
+	}
+
+	if batch.Type != BatchMessageType {
+		return nil, &DecodeError{Kind: ErrUnknownType, Err: fmt.Errorf("Not a batch: %s", batch.Type)}
+	}
+
+	if maxMessages > 0 && len(batch.Messages) > maxMessages {
+		return nil, ErrBatchTooLarge
+	}
+
+	return batch.Messages, nil
+}
@@ -60,7 +60,7 @@ func (x *Message) CodecEncodeSelf(e *codec1978.Encoder) {
 		} else {
 			yysep2 := !z.EncBinary()
 			yy2arr2 := z.EncBasicHandle().StructToArray
-			var yyq2 [17]bool
+			var yyq2 [19]bool
 			_ = yyq2
 			_, _ = yysep2, yy2arr2
 			const yyr2 bool = false
@@ -80,8 +80,10 @@ func (x *Message) CodecEncodeSelf(e *codec1978.Encoder) {
 			yyq2[14] = x.ServiceName != ""
 			yyq2[15] = x.URL != ""
 			yyq2[16] = len(x.PartnerIDs) != 0
+			yyq2[17] = x.Expires != nil
+			yyq2[18] = x.ChainID != ""
 			if yyr2 || yy2arr2 {
-				r.WriteArrayStart(17)
+				r.WriteArrayStart(19)
 			} else {
 				var yynn2 = 1
 				for _, b := range yyq2 {
@@ -628,6 +630,81 @@ func (x *Message) CodecEncodeSelf(e *codec1978.Encoder) {
 					}
 				}
 			}
+			var yyn60 bool
+			if x.Expires == nil {
+				yyn60 = true
+				goto LABEL60
+			}
+		LABEL60:
+			if yyr2 || yy2arr2 {
+				if yyn60 {
+					r.WriteArrayElem()
+					r.EncodeNil()
+				} else {
+					r.WriteArrayElem()
+					if yyq2[17] {
+						if x.Expires == nil {
+							r.EncodeNil()
+						} else {
+							yy61 := *x.Expires
+							yym62 := z.EncBinary()
+							_ = yym62
+							if false {
+							} else {
+								r.EncodeInt(int64(yy61))
+							}
+						}
+					} else {
+						r.EncodeNil()
+					}
+				}
+			} else {
+				if yyq2[17] {
+					r.WriteMapElemKey()
+					r.EncodeString(codecSelferC_UTF8306, string("expires"))
+					r.WriteMapElemValue()
+					if yyn60 {
+						r.EncodeNil()
+					} else {
+						if x.Expires == nil {
+							r.EncodeNil()
+						} else {
+							yy63 := *x.Expires
+							yym64 := z.EncBinary()
+							_ = yym64
+							if false {
+							} else {
+								r.EncodeInt(int64(yy63))
+							}
+						}
+					}
+				}
+			}
+			if yyr2 || yy2arr2 {
+				r.WriteArrayElem()
+				if yyq2[18] {
+					yym66 := z.EncBinary()
+					_ = yym66
+					if false {
+					} else {
+						r.EncodeString(codecSelferC_UTF8306, string(x.ChainID))
+					}
+				} else {
+					r.EncodeString(codecSelferC_UTF8306, "")
+				}
+			} else {
+				if yyq2[18] {
+					r.WriteMapElemKey()
+					r.EncodeString(codecSelferC_UTF8306, string("chain_id"))
+					r.WriteMapElemValue()
+					yym67 := z.EncBinary()
+					_ = yym67
+					if false {
+					} else {
+						r.EncodeString(codecSelferC_UTF8306, string(x.ChainID))
+					}
+				}
+			}
 			if yyr2 || yy2arr2 {
 				r.WriteArrayEnd()
 			} else {
@@ -915,6 +992,37 @@ func (x *Message) codecDecodeSelfFromMap(l int, d *codec1978.Decoder) {
 					z.F.DecSliceStringX(yyv36, d)
 				}
 			}
+		case "expires":
+			if x.Expires == nil {
+				x.Expires = new(int64)
+			}
+			if r.TryDecodeAsNil() {
+				if x.Expires != nil {
+					x.Expires = nil
+				}
+			} else {
+				if x.Expires == nil {
+					x.Expires = new(int64)
+				}
+				yym39 := z.DecBinary()
+				_ = yym39
+				if false {
+				} else {
+					*((*int64)(x.Expires)) = int64(r.DecodeInt(64))
+				}
+			}
+		case "chain_id":
+			if r.TryDecodeAsNil() {
+				x.ChainID = ""
+			} else {
+				yyv40 := &x.ChainID
+				yym41 := z.DecBinary()
+				_ = yym41
+				if false {
+				} else {
+					*((*string)(yyv40)) = r.DecodeString()
+				}
+			}
 		default:
 			z.DecStructFieldNotFound(-1, yys3)
 		} // end switch yys3
@@ -1325,6 +1433,57 @@ func (x *Message) codecDecodeSelfFromArray(l int, d *codec1978.Decoder) {
 			z.F.DecSliceStringX(yyv71, d)
 		}
 	}
+	if x.Expires == nil {
+		x.Expires = new(int64)
+	}
+	yyj38++
+	if yyhl38 {
+		yyb38 = yyj38 > l
+	} else {
+		yyb38 = r.CheckBreak()
+	}
+	if yyb38 {
+		r.ReadArrayEnd()
+		return
+	}
+	r.ReadArrayElem()
+	if r.TryDecodeAsNil() {
+		if x.Expires != nil {
+			x.Expires = nil
+		}
+	} else {
+		if x.Expires == nil {
+			x.Expires = new(int64)
+		}
+		yym73 := z.DecBinary()
+		_ = yym73
+		if false {
+		} else {
+			*((*int64)(x.Expires)) = int64(r.DecodeInt(64))
+		}
+	}
+	yyj38++
+	if yyhl38 {
+		yyb38 = yyj38 > l
+	} else {
+		yyb38 = r.CheckBreak()
+	}
+	if yyb38 {
+		r.ReadArrayEnd()
+		return
+	}
+	r.ReadArrayElem()
+	if r.TryDecodeAsNil() {
+		x.ChainID = ""
+	} else {
+		yyv74 := &x.ChainID
+		yym75 := z.DecBinary()
+		_ = yym75
+		if false {
+		} else {
+			*((*string)(yyv74)) = r.DecodeString()
+		}
+	}
 	for {
 		yyj38++
 		if yyhl38 {
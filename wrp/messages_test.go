@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
 )
 
 var (
@@ -104,15 +106,312 @@ func testMessageEncode(t *testing.T, f Format, original Message) {
 	assert.Equal(original, decoded)
 }
 
+// reflectedMessage has the same fields and tags as Message, but none of its generated codec methods, so it is
+// always encoded and decoded by reflection
+type reflectedMessage Message
+
+// testMessageCodecFields verifies that the generated codec for Message agrees with reflection on the given message,
+// in both directions.  It guards the parts of messages_codec.go that were not produced by codecgen.
+func testMessageCodecFields(t *testing.T, f Format, original Message) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		generated []byte
+		reflected []byte
+	)
+
+	require.NoError(codec.NewEncoderBytes(&generated, f.handle()).Encode(&original))
+	require.NoError(codec.NewEncoderBytes(&reflected, f.handle()).Encode((*reflectedMessage)(&original)))
+
+	var fromGenerated reflectedMessage
+	require.NoError(codec.NewDecoderBytes(generated, f.handle()).Decode(&fromGenerated))
+	assert.Equal(original, Message(fromGenerated))
+
+	var fromReflected Message
+	require.NoError(codec.NewDecoderBytes(reflected, f.handle()).Decode(&fromReflected))
+	assert.Equal(original, fromReflected)
+}
+
+func testMessageCodec(t *testing.T) {
+	var (
+		expires int64 = 1900000000
+		zero    int64
+
+		messages = []Message{
+			{Type: SimpleEventMessageType},
+			{Type: SimpleEventMessageType, Expires: &expires},
+			{Type: SimpleEventMessageType, Expires: &zero},
+			{Type: SimpleRequestResponseMessageType, TransactionUUID: "123", ChainID: "config-sync"},
+			{Type: SimpleRequestResponseMessageType, TransactionUUID: "123", Expires: &expires, ChainID: "config-sync", Payload: []byte("payload")},
+		}
+	)
+
+	for _, f := range allFormats {
+		t.Run(f.String(), func(t *testing.T) {
+			for _, message := range messages {
+				testMessageCodecFields(t, f, message)
+			}
+		})
+	}
+
+	// the wire names are those of the struct tags
+	var output []byte
+	require.NoError(t, NewEncoderBytes(&output, JSON).Encode(&messages[4]))
+	assert.Contains(t, string(output), `"expires":1900000000`)
+	assert.Contains(t, string(output), `"chain_id":"config-sync"`)
+}
+
+func testMessageAppendSpan(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message Message
+		start   = time.Unix(1234, 567*int64(time.Millisecond))
+	)
+
+	assert.True(&message == message.AppendSpan("test", start, 2500*time.Millisecond))
+	assert.Equal([][]string{{"test", "1234567", "2500"}}, message.Spans)
+}
+
+func testMessageForward(t *testing.T) {
+	t.Run("EmptyLocator", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			message = Message{Source: "mac:112233445566", Destination: "event:device-status"}
+		)
+
+		for _, locators := range [][2]string{{"", "dest"}, {"source", ""}, {"", ""}} {
+			forwarded, err := message.Forward(locators[0], locators[1])
+			assert.Nil(forwarded)
+			assert.Equal(ErrEmptyLocator, err)
+		}
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		var (
+			assert   = assert.New(t)
+			require  = require.New(t)
+			original = Message{
+				Type:            SimpleRequestResponseMessageType,
+				Source:          "dns:original.source.com",
+				Destination:     "mac:112233445566",
+				TransactionUUID: "DEADBEEF",
+				Headers:         []string{"Header1", "Header2"},
+				Spans:           make([][]string, 1, 10),
+				Payload:         []byte("payload"),
+			}
+		)
+
+		original.Spans[0] = []string{"existing", "1", "2"}
+
+		forwarded, err := original.Forward("dns:relay.com", "dns:next.hop.com")
+		require.NoError(err)
+		require.NotNil(forwarded)
+
+		assert.Equal("dns:relay.com", forwarded.Source)
+		assert.Equal("dns:next.hop.com", forwarded.Destination)
+		assert.Equal(original.Type, forwarded.Type)
+		assert.Equal(original.TransactionUUID, forwarded.TransactionUUID)
+		assert.Equal(original.Headers, forwarded.Headers)
+		assert.Equal(original.Payload, forwarded.Payload)
+
+		require.Len(forwarded.Spans, 2)
+		assert.Equal([]string{"existing", "1", "2"}, forwarded.Spans[0])
+		assert.Equal("forward:dns:next.hop.com", forwarded.Spans[1][0])
+
+		// the original must be untouched
+		assert.Equal("dns:original.source.com", original.Source)
+		assert.Equal("mac:112233445566", original.Destination)
+		assert.Len(original.Spans, 1)
+		assert.Len(original.Spans[:2][1], 0)
+	})
+}
+
+func testMessageRedacted(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		original = Message{
+			Type:            SimpleRequestResponseMessageType,
+			Source:          "dns:original.source.com",
+			Destination:     "mac:112233445566",
+			TransactionUUID: "DEADBEEF",
+			Headers:         []string{"Authorization: secret", "bare"},
+			Metadata:        map[string]string{"/account": "12345"},
+			Payload:         []byte("payload"),
+			PartnerIDs:      []string{"comcast"},
+		}
+
+		redacted = original.Redacted()
+	)
+
+	assert.Equal(original.Type, redacted.Type)
+	assert.Equal(original.Source, redacted.Source)
+	assert.Equal(original.Destination, redacted.Destination)
+	assert.Equal(original.TransactionUUID, redacted.TransactionUUID)
+	assert.Equal(original.PartnerIDs, redacted.PartnerIDs)
+	assert.Empty(redacted.Payload)
+	assert.Equal([]string{"Authorization:" + RedactedValue, RedactedValue}, redacted.Headers)
+	assert.Equal(map[string]string{"/account": RedactedValue}, redacted.Metadata)
+
+	// the original must be untouched
+	assert.Equal([]byte("payload"), original.Payload)
+	assert.Equal([]string{"Authorization: secret", "bare"}, original.Headers)
+	assert.Equal("12345", original.Metadata["/account"])
+
+	empty := new(Message).Redacted()
+	assert.Nil(empty.Headers)
+	assert.Nil(empty.Metadata)
+}
+
+func testMessageAck(t *testing.T) {
+	for _, f := range allFormats {
+		t.Run(f.String(), func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				request = (&Message{
+					Type:            SimpleEventMessageType,
+					Source:          "dns:server.com",
+					Destination:     "mac:112233445566",
+					TransactionUUID: "DEADBEEF",
+					Payload:         []byte("an event"),
+				}).SetRequestDeliveryResponse(AckRequested)
+
+				decodedRequest Message
+				decodedAck     Message
+			)
+
+			require.NoError(NewDecoderBytes(MustEncode(request, f), f).Decode(&decodedRequest))
+			assert.True(decodedRequest.RequiresAck())
+			assert.False(decodedRequest.IsAck())
+
+			ack := decodedRequest.Ack("mac:112233445566")
+			require.NotNil(ack)
+			assert.Equal(SimpleRequestResponseMessageType, ack.Type)
+			assert.Equal("mac:112233445566", ack.Source)
+			assert.Equal("dns:server.com", ack.Destination)
+			assert.Equal("DEADBEEF", ack.TransactionUUID)
+			assert.Empty(ack.Payload)
+
+			require.NoError(NewDecoderBytes(MustEncode(ack, f), f).Decode(&decodedAck))
+			assert.True(decodedAck.IsAck())
+			assert.False(decodedAck.RequiresAck())
+		})
+	}
+
+	var (
+		assert = assert.New(t)
+		noKey  = (&Message{}).SetRequestDeliveryResponse(AckRequested)
+		marked = &Message{
+			Type:            SimpleRequestResponseMessageType,
+			TransactionUUID: "1",
+			Metadata:        map[string]string{AckKey: AckDelivered},
+		}
+	)
+
+	assert.False(noKey.RequiresAck())
+	assert.False(new(Message).IsAck())
+	assert.True(marked.IsAck())
+
+	// an ordinary empty response is delivered, but is not an ack
+	empty := (&Message{Type: SimpleRequestResponseMessageType, TransactionUUID: "1"}).SetRequestDeliveryResponse(0)
+	assert.False(empty.IsAck())
+
+	// nor is an event, whose type does not support transactions
+	event := *marked
+	event.Type = SimpleEventMessageType
+	assert.False(event.IsAck())
+
+	marked.Payload = []byte("a full response is not an ack")
+	assert.False(marked.IsAck())
+}
+
+func testMessageMetadata(t *testing.T) {
+	testData := []struct {
+		metadata map[string]string
+		expected map[string]string
+	}{
+		{nil, nil},
+		// metadata is omitted when empty, so an empty map decodes as nil
+		{map[string]string{}, nil},
+		{map[string]string{"/boot-time": "1234", "/empty": "", "/unicode": "caf\u00e9"}, map[string]string{"/boot-time": "1234", "/empty": "", "/unicode": "caf\u00e9"}},
+	}
+
+	for _, f := range allFormats {
+		for _, record := range testData {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				encoded []byte
+				decoded Message
+			)
+
+			require.NoError(NewEncoderBytes(&encoded, f).Encode(&Message{Type: SimpleEventMessageType, Metadata: record.metadata}))
+			require.NoError(NewDecoderBytes(encoded, f).Decode(&decoded))
+			assert.Equal(record.expected, decoded.Metadata, "format: %s", f)
+		}
+	}
+}
+
+func testMessageExpires(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		now     = time.Unix(1500000000, 0)
+		message Message
+	)
+
+	_, ok := message.ExpiresAt()
+	assert.False(ok)
+	assert.False(message.Expired(now))
+
+	zero := int64(0)
+	message.Expires = &zero
+	_, ok = message.ExpiresAt()
+	assert.False(ok)
+	assert.False(message.Expired(now))
+
+	assert.True(&message == message.SetExpires(now.Add(time.Minute+500*time.Millisecond)))
+	assert.Equal(now.Unix()+60, *message.Expires)
+
+	expires, ok := message.ExpiresAt()
+	assert.True(ok)
+	assert.Equal(now.Add(time.Minute), expires)
+
+	assert.False(message.Expired(now))
+	assert.False(message.Expired(now.Add(59 * time.Second)))
+	assert.True(message.Expired(now.Add(time.Minute)))
+	assert.True(message.Expired(now.Add(time.Hour)))
+}
+
+func testMessageIsChainPart(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False((&Message{Type: SimpleRequestResponseMessageType, TransactionUUID: "1234"}).IsChainPart())
+	assert.True((&Message{Type: SimpleRequestResponseMessageType, TransactionUUID: "1234", ChainID: "chain"}).IsChainPart())
+
+	// only transactions can be chained
+	assert.False((&Message{Type: SimpleRequestResponseMessageType, ChainID: "chain"}).IsChainPart())
+	assert.False((&Message{Type: SimpleEventMessageType, TransactionUUID: "1234", ChainID: "chain"}).IsChainPart())
+}
+
 func TestMessage(t *testing.T) {
 	t.Run("SetStatus", testMessageSetStatus)
+	t.Run("Metadata", testMessageMetadata)
+	t.Run("Ack", testMessageAck)
+	t.Run("AppendSpan", testMessageAppendSpan)
+	t.Run("Forward", testMessageForward)
+	t.Run("Redacted", testMessageRedacted)
 	t.Run("SetRequestDeliveryResponse", testMessageSetRequestDeliveryResponse)
 	t.Run("SetIncludeSpans", testMessageSetIncludeSpans)
+	t.Run("Expires", testMessageExpires)
+	t.Run("IsChainPart", testMessageIsChainPart)
+	t.Run("Codec", testMessageCodec)
 
 	var (
 		expectedStatus                  int64 = 3471
 		expectedRequestDeliveryResponse int64 = 34
 		expectedIncludeSpans            bool  = true
+		expectedExpires                 int64 = 1900000000
 
 		messages = []Message{
 			{},
@@ -130,6 +429,8 @@ func TestMessage(t *testing.T) {
 				Status:                  &expectedStatus,
 				RequestDeliveryResponse: &expectedRequestDeliveryResponse,
 				IncludeSpans:            &expectedIncludeSpans,
+				Expires:                 &expectedExpires,
+				ChainID:                 "config-sync",
 			},
 			{
 				Type:            SimpleRequestResponseMessageType,
@@ -596,3 +897,151 @@ func TestServiceAlive(t *testing.T) {
 		})
 	}
 }
+
+func testCreditGrantEncode(t *testing.T, f Format) {
+	var (
+		assert   = assert.New(t)
+		original = CreditGrant{Credits: 25}
+
+		decoded CreditGrant
+		generic Message
+
+		buffer  bytes.Buffer
+		encoder = NewEncoder(&buffer, f)
+	)
+
+	assert.NoError(encoder.Encode(&original))
+	assert.True(buffer.Len() > 0)
+	assert.Equal(CreditGrantMessageType, original.Type)
+
+	assert.NoError(NewDecoderBytes(buffer.Bytes(), f).Decode(&decoded))
+	assert.Equal(original, decoded)
+
+	// a credit grant must be recognizable when decoded as a generic message
+	assert.NoError(NewDecoderBytes(buffer.Bytes(), f).Decode(&generic))
+	assert.Equal(CreditGrantMessageType, generic.Type)
+}
+
+func TestCreditGrant(t *testing.T) {
+	for _, format := range allFormats {
+		t.Run(fmt.Sprintf("Encode%s", format), func(t *testing.T) {
+			testCreditGrantEncode(t, format)
+		})
+	}
+}
+
+func testDisconnectEncode(t *testing.T, f Format) {
+	var (
+		assert   = assert.New(t)
+		original = Disconnect{Reason: DisconnectReasonSleep}
+
+		decoded Disconnect
+		generic Message
+
+		buffer  bytes.Buffer
+		encoder = NewEncoder(&buffer, f)
+	)
+
+	assert.NoError(encoder.Encode(&original))
+	assert.True(buffer.Len() > 0)
+	assert.Equal(DisconnectMessageType, original.Type)
+	assert.Equal(DisconnectMessageType, original.MessageType())
+
+	assert.NoError(NewDecoderBytes(buffer.Bytes(), f).Decode(&decoded))
+	assert.Equal(original, decoded)
+
+	assert.NoError(NewDecoderBytes(buffer.Bytes(), f).Decode(&generic))
+	assert.Equal(DisconnectMessageType, generic.Type)
+}
+
+func TestDisconnect(t *testing.T) {
+	for _, format := range allFormats {
+		t.Run(fmt.Sprintf("Encode%s", format), func(t *testing.T) {
+			testDisconnectEncode(t, format)
+		})
+	}
+}
+
+func testBatchEncode(t *testing.T, f Format) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		original = Batch{
+			Messages: [][]byte{
+				MustEncode(&SimpleEvent{Destination: "mac:112233445566/first"}, f),
+				MustEncode(&SimpleEvent{Destination: "mac:112233445566/second"}, f),
+			},
+		}
+
+		decoded Batch
+		generic Message
+
+		buffer  bytes.Buffer
+		encoder = NewEncoder(&buffer, f)
+	)
+
+	assert.NoError(encoder.Encode(&original))
+	assert.True(buffer.Len() > 0)
+	assert.Equal(BatchMessageType, original.Type)
+	assert.Equal(BatchMessageType, original.MessageType())
+
+	require.NoError(NewDecoderBytes(buffer.Bytes(), f).Decode(&decoded))
+	assert.Equal(original, decoded)
+
+	assert.NoError(NewDecoderBytes(buffer.Bytes(), f).Decode(&generic))
+	assert.Equal(BatchMessageType, generic.Type)
+
+	// each element decodes exactly as a standalone frame
+	for i, expected := range []string{"mac:112233445566/first", "mac:112233445566/second"} {
+		var element Message
+		require.NoError(NewDecoderBytes(decoded.Messages[i], f).Decode(&element))
+		assert.Equal(SimpleEventMessageType, element.Type)
+		assert.Equal(expected, element.Destination)
+	}
+}
+
+func testDecodeBatch(t *testing.T, f Format) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		messages = [][]byte{
+			MustEncode(&SimpleEvent{Destination: "mac:112233445566/first"}, f),
+			MustEncode(&SimpleEvent{Destination: "mac:112233445566/second"}, f),
+			MustEncode(&SimpleEvent{Destination: "mac:112233445566/third"}, f),
+		}
+
+		frame = MustEncode(&Batch{Messages: messages}, f)
+	)
+
+	decoded, err := DecodeBatch(NewDecoderBytes(frame, f), 0)
+	require.NoError(err)
+	assert.Equal(messages, decoded)
+
+	decoded, err = DecodeBatch(NewDecoderBytes(frame, f), 3)
+	require.NoError(err)
+	assert.Equal(messages, decoded)
+
+	decoded, err = DecodeBatch(NewDecoderBytes(frame, f), 2)
+	assert.Nil(decoded)
+	assert.Equal(ErrBatchTooLarge, err)
+
+	decoded, err = DecodeBatch(NewDecoderBytes(messages[0], f), 0)
+	assert.Nil(decoded)
+	assert.Equal(ErrUnknownType, DecodeErrorKind(err))
+
+	decoded, err = DecodeBatch(NewDecoderBytes(frame[:len(frame)/2], f), 0)
+	assert.Nil(decoded)
+	assert.Error(err)
+}
+
+func TestBatch(t *testing.T) {
+	for _, format := range allFormats {
+		t.Run(fmt.Sprintf("Encode%s", format), func(t *testing.T) {
+			testBatchEncode(t, format)
+		})
+
+		t.Run(fmt.Sprintf("Decode%s", format), func(t *testing.T) {
+			testDecodeBatch(t, format)
+		})
+	}
+}
@@ -19,6 +19,9 @@ const (
 	DeleteMessageType
 	ServiceRegistrationMessageType
 	ServiceAliveMessageType
+	CreditGrantMessageType
+	DisconnectMessageType
+	BatchMessageType
 	lastMessageType
 )
 
@@ -33,11 +36,22 @@ func (mt MessageType) SupportsTransaction() bool {
 		return false
 	case ServiceAliveMessageType:
 		return false
+	case CreditGrantMessageType:
+		return false
+	case DisconnectMessageType:
+		return false
+	case BatchMessageType:
+		return false
 	default:
 		return true
 	}
 }
 
+// IsValid tests if this type is one of the enumerated MessageType constants
+func (mt MessageType) IsValid() bool {
+	return mt >= SimpleRequestResponseMessageType && mt < lastMessageType
+}
+
 // FriendlyName is just the String version of this type minus the "MessageType" suffix.
 // This is used in most textual representations, such as HTTP headers.
 func (mt MessageType) FriendlyName() string {
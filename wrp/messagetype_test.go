@@ -19,6 +19,9 @@ func TestMessageTypeString(t *testing.T) {
 			DeleteMessageType,
 			ServiceRegistrationMessageType,
 			ServiceAliveMessageType,
+			CreditGrantMessageType,
+			DisconnectMessageType,
+			BatchMessageType,
 			MessageType(-1),
 		}
 
@@ -48,6 +51,9 @@ func TestMessageTypeSupportsTransaction(t *testing.T) {
 			DeleteMessageType:                true,
 			ServiceRegistrationMessageType:   false,
 			ServiceAliveMessageType:          false,
+			CreditGrantMessageType:           false,
+			DisconnectMessageType:            false,
+			BatchMessageType:                 false,
 		}
 	)
 
@@ -96,3 +102,15 @@ func TestStringToMessageType(t *testing.T) {
 		}
 	})
 }
+
+func TestMessageTypeIsValid(t *testing.T) {
+	assert := assert.New(t)
+	for v := SimpleRequestResponseMessageType; v < lastMessageType; v++ {
+		assert.True(v.IsValid(), v.String())
+	}
+
+	assert.False(MessageType(-1).IsValid())
+	assert.False(MessageType(0).IsValid())
+	assert.False(MessageType(2).IsValid())
+	assert.False(lastMessageType.IsValid())
+}
@@ -0,0 +1,78 @@
+package wrp
+
+import (
+	"io"
+
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	_ Encoder = (*MockEncoder)(nil)
+	_ Decoder = (*MockDecoder)(nil)
+)
+
+// MockEncoder is a stretchr mock for the Encoder interface.  It is mainly useful for testing how code
+// reacts to encoding failures.  For testing with actual encoded bytes, use an Encoder created by NewEncoder.
+type MockEncoder struct {
+	mock.Mock
+}
+
+// OnEncode sets an expectation for a call to Encode, with any value, that returns the given error
+func (me *MockEncoder) OnEncode(err error) *mock.Call {
+	return me.On("Encode", mock.Anything).Return(err)
+}
+
+// OnReset sets an expectation for a call to Reset with any io.Writer, including nil
+func (me *MockEncoder) OnReset() *mock.Call {
+	return me.On("Reset", mock.Anything)
+}
+
+// OnResetBytes sets an expectation for a call to ResetBytes with any byte slice pointer, including nil
+func (me *MockEncoder) OnResetBytes() *mock.Call {
+	return me.On("ResetBytes", mock.Anything)
+}
+
+func (me *MockEncoder) Encode(value interface{}) error {
+	return me.Called(value).Error(0)
+}
+
+func (me *MockEncoder) Reset(output io.Writer) {
+	me.Called(output)
+}
+
+func (me *MockEncoder) ResetBytes(output *[]byte) {
+	me.Called(output)
+}
+
+// MockDecoder is a stretchr mock for the Decoder interface.  It is mainly useful for testing how code
+// reacts to decoding failures.  For testing with actual encoded bytes, use a Decoder created by NewDecoder.
+type MockDecoder struct {
+	mock.Mock
+}
+
+// OnDecode sets an expectation for a call to Decode, with any target, that returns the given error
+func (md *MockDecoder) OnDecode(err error) *mock.Call {
+	return md.On("Decode", mock.Anything).Return(err)
+}
+
+// OnReset sets an expectation for a call to Reset with any io.Reader, including nil
+func (md *MockDecoder) OnReset() *mock.Call {
+	return md.On("Reset", mock.Anything)
+}
+
+// OnResetBytes sets an expectation for a call to ResetBytes with any byte slice, including nil
+func (md *MockDecoder) OnResetBytes() *mock.Call {
+	return md.On("ResetBytes", mock.Anything)
+}
+
+func (md *MockDecoder) Decode(target interface{}) error {
+	return md.Called(target).Error(0)
+}
+
+func (md *MockDecoder) Reset(input io.Reader) {
+	md.Called(input)
+}
+
+func (md *MockDecoder) ResetBytes(input []byte) {
+	md.Called(input)
+}
@@ -0,0 +1,80 @@
+package wrp
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// PayloadContentTypeError is returned by UnmarshalPayload when a message's ContentType cannot be
+// decoded into the supplied value, either because the content type is unsupported or because the
+// value has an incompatible type.
+type PayloadContentTypeError struct {
+	// ContentType is the message's ContentType
+	ContentType string
+
+	// Target is the value that was passed to UnmarshalPayload
+	Target interface{}
+}
+
+func (e *PayloadContentTypeError) Error() string {
+	return fmt.Sprintf("Cannot unmarshal a payload of content type %q into %T", e.ContentType, e.Target)
+}
+
+// UnmarshalPayload interprets this message's Payload according to its ContentType:
+//
+//   - application/json, or any type with a +json suffix, is unmarshaled into v with encoding/json
+//   - text/* may be unmarshaled into a *string or *[]byte
+//   - application/octet-stream, or no ContentType at all, may be unmarshaled into a *[]byte
+//
+// Media type parameters, such as charset, are ignored.  A *[]byte never shares storage with the Payload.
+// If the Payload is empty, v is left untouched and nil is returned.  Any other combination of content
+// type and v results in a *PayloadContentTypeError.
+func (msg *Message) UnmarshalPayload(v interface{}) error {
+	if len(msg.Payload) == 0 {
+		return nil
+	}
+
+	mediaType := "application/octet-stream"
+	if len(msg.ContentType) > 0 {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(msg.ContentType); err != nil {
+			return &PayloadContentTypeError{ContentType: msg.ContentType, Target: v}
+		}
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return json.Unmarshal(msg.Payload, v)
+
+	case strings.HasPrefix(mediaType, "text/"):
+		if s, ok := v.(*string); ok {
+			*s = string(msg.Payload)
+			return nil
+		}
+
+		fallthrough
+
+	case mediaType == "application/octet-stream":
+		if b, ok := v.(*[]byte); ok {
+			*b = append([]byte(nil), msg.Payload...)
+			return nil
+		}
+	}
+
+	return &PayloadContentTypeError{ContentType: msg.ContentType, Target: v}
+}
+
+// SetPayloadJSON marshals v with encoding/json, then sets the result as this message's Payload along with
+// a ContentType of application/json.  If v cannot be marshaled, this message is left unchanged.
+func (msg *Message) SetPayloadJSON(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	msg.Payload = payload
+	msg.ContentType = JSON.ContentType()
+	return nil
+}
@@ -0,0 +1,143 @@
+package wrp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type payloadFixture struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func testUnmarshalPayloadJSON(t *testing.T) {
+	for _, contentType := range []string{"application/json", "application/json; charset=utf-8", "application/vnd.device+json"} {
+		t.Run(contentType, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				message = Message{ContentType: contentType, Payload: []byte(`{"name": "test", "count": 12}`)}
+				actual  payloadFixture
+			)
+
+			assert.NoError(message.UnmarshalPayload(&actual))
+			assert.Equal(payloadFixture{Name: "test", Count: 12}, actual)
+		})
+	}
+}
+
+func testUnmarshalPayloadInvalidJSON(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = Message{ContentType: "application/json", Payload: []byte(`{"name":`)}
+		actual  payloadFixture
+	)
+
+	err := message.UnmarshalPayload(&actual)
+	assert.Error(err)
+	assert.IsType(new(json.SyntaxError), err)
+}
+
+func testUnmarshalPayloadText(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = Message{ContentType: "text/plain; charset=utf-8", Payload: []byte("hello")}
+
+		s string
+		b []byte
+	)
+
+	assert.NoError(message.UnmarshalPayload(&s))
+	assert.Equal("hello", s)
+
+	assert.NoError(message.UnmarshalPayload(&b))
+	assert.Equal("hello", string(b))
+	b[0] = 'j'
+	assert.Equal("hello", string(message.Payload))
+}
+
+func testUnmarshalPayloadBinary(t *testing.T) {
+	for _, contentType := range []string{"application/octet-stream", ""} {
+		t.Run(contentType, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				message = Message{ContentType: contentType, Payload: []byte{0x01, 0x02}}
+
+				b []byte
+				s string
+			)
+
+			assert.NoError(message.UnmarshalPayload(&b))
+			assert.Equal([]byte{0x01, 0x02}, b)
+
+			err := message.UnmarshalPayload(&s)
+			assert.IsType(new(PayloadContentTypeError), err)
+			assert.Empty(s)
+		})
+	}
+}
+
+func testUnmarshalPayloadUnsupported(t *testing.T) {
+	for _, contentType := range []string{"application/msgpack", "image/png", "this is not a media type;"} {
+		t.Run(contentType, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				message = Message{ContentType: contentType, Payload: []byte("data")}
+				actual  payloadFixture
+			)
+
+			err := message.UnmarshalPayload(&actual)
+			require.IsType(new(PayloadContentTypeError), err)
+			assert.Equal(contentType, err.(*PayloadContentTypeError).ContentType)
+			assert.True(&actual == err.(*PayloadContentTypeError).Target)
+			assert.Contains(err.Error(), "payloadFixture")
+		})
+	}
+}
+
+func testUnmarshalPayloadEmpty(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, message := range []Message{
+		{},
+		{ContentType: "application/json"},
+		{ContentType: "image/png", Payload: []byte{}},
+	} {
+		actual := payloadFixture{Name: "unchanged"}
+		assert.NoError(message.UnmarshalPayload(&actual))
+		assert.Equal(payloadFixture{Name: "unchanged"}, actual)
+	}
+}
+
+func TestUnmarshalPayload(t *testing.T) {
+	t.Run("JSON", testUnmarshalPayloadJSON)
+	t.Run("InvalidJSON", testUnmarshalPayloadInvalidJSON)
+	t.Run("Text", testUnmarshalPayloadText)
+	t.Run("Binary", testUnmarshalPayloadBinary)
+	t.Run("Unsupported", testUnmarshalPayloadUnsupported)
+	t.Run("Empty", testUnmarshalPayloadEmpty)
+}
+
+func TestSetPayloadJSON(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		message = Message{Type: SimpleEventMessageType, ContentType: "text/plain", Payload: []byte("original")}
+	)
+
+	require.NoError(message.SetPayloadJSON(payloadFixture{Name: "test", Count: 3}))
+	assert.Equal("application/json", message.ContentType)
+	assert.JSONEq(`{"name": "test", "count": 3}`, string(message.Payload))
+
+	var actual payloadFixture
+	require.NoError(message.UnmarshalPayload(&actual))
+	assert.Equal(payloadFixture{Name: "test", Count: 3}, actual)
+
+	// an unmarshalable value leaves the message untouched
+	assert.Error(message.SetPayloadJSON(make(chan int)))
+	assert.Equal("application/json", message.ContentType)
+	assert.JSONEq(`{"name": "test", "count": 3}`, string(message.Payload))
+}
@@ -0,0 +1,506 @@
+package wrp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultPoolSize is the number of encoders or decoders held by a pool when no size is supplied
+	DefaultPoolSize = 100
+
+	// DefaultInitialBufferSize is the initial capacity of byte slices produced by EncoderPool.EncodeBytes
+	// and of buffers produced by EncoderPool.EncodeBuffer when no initial buffer size is supplied
+	DefaultInitialBufferSize = 200
+)
+
+// PoolStats is a snapshot of a pool's usage, which is useful for choosing a pool size.  A high
+// ratio of Misses to Hits indicates the pool is too small, while an Idle count that never drops
+// indicates the pool is larger than needed.
+type PoolStats struct {
+	// Hits is the number of Gets that were served from the pool
+	Hits uint64
+
+	// Misses is the number of Gets that found the pool empty and had to create a new object
+	Misses uint64
+
+	// Idle is the number of objects currently held by the pool
+	Idle int
+}
+
+// EncoderPool represents a pool of Encoder objects that can be used to encode WRP messages.
+// Unlike a sync.Pool, this pool holds on to its pooled encoders across garbage collections.
+// Encoders obtained from this pool should be returned via Put when no longer needed.
+type EncoderPool struct {
+	// hits and misses are first to guarantee 64-bit alignment for atomic access
+	hits   uint64
+	misses uint64
+
+	lock              sync.Mutex
+	pool              []Encoder
+	size              int
+	initialBufferSize int
+	factory           func() Encoder
+
+	// lazy indicates that encoders are only created on demand, never up front
+	lazy bool
+
+	// contentType is the MIME type of the pool's format, which is empty if the pool was created from a factory
+	contentType string
+
+	// buffers holds the *pooledBuffer values used by EncodeBuffer.  Unlike encoders, these may be
+	// reclaimed by the garbage collector.
+	buffers sync.Pool
+}
+
+// pooledBuffer is an EncodeBuffer buffer along with the cleanup function that recycles it.  The cleanup
+// function is created once per buffer, so recycling a buffer allocates nothing.
+type pooledBuffer struct {
+	bytes.Buffer
+	release func()
+}
+
+// NewEncoderPool returns an EncoderPool whose encoders are created with NewEncoder for the given format.
+// This function delegates to NewEncoderPoolFunc.
+func NewEncoderPool(poolSize, initialBufferSize int, f Format) *EncoderPool {
+	ep := NewEncoderPoolFunc(
+		poolSize,
+		initialBufferSize,
+		func() Encoder {
+			return NewEncoder(nil, f)
+		},
+	)
+
+	ep.contentType = f.ContentType()
+	return ep
+}
+
+// NewEncoderPoolFunc returns an EncoderPool which creates encoders with the given factory.  This allows
+// pooled encoders to have any configuration.  If poolSize is nonpositive, DefaultPoolSize is used.
+// If initialBufferSize is nonpositive, DefaultInitialBufferSize is used.
+//
+// The returned pool is filled with encoders up front.
+func NewEncoderPoolFunc(poolSize, initialBufferSize int, factory func() Encoder) *EncoderPool {
+	return newEncoderPool(poolSize, initialBufferSize, factory, false)
+}
+
+// NewLazyEncoderPool is like NewEncoderPool, except that the returned pool is lazy.
+// This function delegates to NewLazyEncoderPoolFunc.
+func NewLazyEncoderPool(poolSize, initialBufferSize int, f Format) *EncoderPool {
+	ep := NewLazyEncoderPoolFunc(
+		poolSize,
+		initialBufferSize,
+		func() Encoder {
+			return NewEncoder(nil, f)
+		},
+	)
+
+	ep.contentType = f.ContentType()
+	return ep
+}
+
+// NewLazyEncoderPoolFunc is like NewEncoderPoolFunc, except that the returned pool is lazy:  no encoders
+// are created up front, and none are held until they are returned with Put, so a pool that is never used
+// costs almost nothing.  The tradeoff is cold-start latency, since each Get creates a new encoder until the
+// pool has filled through use.  Eager pools are the better choice for hot paths, while lazy pools suit
+// services that create many pools, e.g. per format or per tenant, only some of which see traffic.
+func NewLazyEncoderPoolFunc(poolSize, initialBufferSize int, factory func() Encoder) *EncoderPool {
+	return newEncoderPool(poolSize, initialBufferSize, factory, true)
+}
+
+func newEncoderPool(poolSize, initialBufferSize int, factory func() Encoder, lazy bool) *EncoderPool {
+	if poolSize < 1 {
+		poolSize = DefaultPoolSize
+	}
+
+	if initialBufferSize < 1 {
+		initialBufferSize = DefaultInitialBufferSize
+	}
+
+	ep := &EncoderPool{
+		initialBufferSize: initialBufferSize,
+		factory:           factory,
+		lazy:              lazy,
+	}
+
+	ep.buffers.New = func() interface{} {
+		pb := new(pooledBuffer)
+		pb.Grow(ep.initialBufferSize)
+		pb.release = func() {
+			pb.Reset()
+			ep.buffers.Put(pb)
+		}
+
+		return pb
+	}
+
+	if !lazy {
+		ep.pool = make([]Encoder, 0, poolSize)
+	}
+
+	ep.Resize(poolSize)
+	return ep
+}
+
+// Size returns the maximum number of encoders this pool will hold
+func (ep *EncoderPool) Size() int {
+	ep.lock.Lock()
+	s := ep.size
+	ep.lock.Unlock()
+
+	return s
+}
+
+// Resize changes the maximum number of encoders this pool will hold.  Growing an eager pool fills it
+// with new encoders, while growing a lazy pool only raises its limit.  Shrinking the pool discards any
+// excess encoders.  Nonpositive sizes are ignored.
+func (ep *EncoderPool) Resize(newSize int) {
+	if newSize < 1 {
+		return
+	}
+
+	ep.lock.Lock()
+	if newSize < len(ep.pool) {
+		for i := newSize; i < len(ep.pool); i++ {
+			ep.pool[i] = nil
+		}
+
+		ep.pool = ep.pool[:newSize]
+	}
+
+	ep.size = newSize
+	if !ep.lazy {
+		ep.fill()
+	}
+
+	ep.lock.Unlock()
+}
+
+// Warm fills this pool with new encoders up to its size, regardless of whether it is lazy.  This allows
+// a lazy pool to be pre-warmed once it is known to be needed.
+func (ep *EncoderPool) Warm() {
+	ep.lock.Lock()
+	ep.fill()
+	ep.lock.Unlock()
+}
+
+// fill creates encoders until this pool is full.  The lock must be held.
+func (ep *EncoderPool) fill() {
+	for len(ep.pool) < ep.size {
+		ep.pool = append(ep.pool, ep.factory())
+	}
+}
+
+// Stats returns a snapshot of this pool's usage.  Hits and Misses are counted from the time
+// this pool was created.
+func (ep *EncoderPool) Stats() PoolStats {
+	ep.lock.Lock()
+	idle := len(ep.pool)
+	ep.lock.Unlock()
+
+	return PoolStats{
+		Hits:   atomic.LoadUint64(&ep.hits),
+		Misses: atomic.LoadUint64(&ep.misses),
+		Idle:   idle,
+	}
+}
+
+// Get obtains an Encoder from this pool, creating a new one if the pool is empty.  The returned
+// Encoder must be reset to an output before use.
+func (ep *EncoderPool) Get() Encoder {
+	ep.lock.Lock()
+	if last := len(ep.pool) - 1; last >= 0 {
+		e := ep.pool[last]
+		ep.pool[last] = nil
+		ep.pool = ep.pool[:last]
+		ep.lock.Unlock()
+		atomic.AddUint64(&ep.hits, 1)
+		return e
+	}
+
+	ep.lock.Unlock()
+	atomic.AddUint64(&ep.misses, 1)
+	return ep.factory()
+}
+
+// Put returns an Encoder to this pool.  If the pool is full, the Encoder is discarded and
+// this method returns false.  The Encoder is first reset, so that the pool does not retain its output.
+func (ep *EncoderPool) Put(e Encoder) bool {
+	e.ResetBytes(nil)
+
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	if len(ep.pool) < ep.size {
+		ep.pool = append(ep.pool, e)
+		return true
+	}
+
+	return false
+}
+
+// Encode uses a pooled Encoder to write the given value to output
+func (ep *EncoderPool) Encode(output io.Writer, value interface{}) error {
+	e := ep.Get()
+	defer ep.Put(e)
+
+	e.Reset(output)
+	return e.Encode(value)
+}
+
+// EncodeBytes uses a pooled Encoder to produce a byte slice containing the encoded value.  The slice
+// is initially allocated with this pool's initial buffer size.
+func (ep *EncoderPool) EncodeBytes(value interface{}) ([]byte, error) {
+	var (
+		e      = ep.Get()
+		output = make([]byte, 0, ep.initialBufferSize)
+	)
+
+	defer ep.Put(e)
+	e.ResetBytes(&output)
+	err := e.Encode(value)
+	return output, err
+}
+
+// EncodeBuffer is like EncodeBytes, except that the value is encoded into a buffer recycled through an internal
+// sync.Pool, which avoids allocating output for each call.  This suits high-throughput loops that write each encoded
+// value to a sink and then discard it.  New buffers are allocated with this pool's initial buffer size, and buffers
+// keep whatever capacity they grow to.
+//
+// The returned cleanup function recycles the buffer, and must be called exactly once after the caller is finished
+// with it.  Neither the buffer nor any slice obtained from it, such as from Bytes, may be used or retained after
+// cleanup is called, as the memory will be reused by later calls.  If encoding fails, the buffer is recycled
+// immediately and this method returns a nil buffer and cleanup function.
+func (ep *EncoderPool) EncodeBuffer(value interface{}) (*bytes.Buffer, func(), error) {
+	var (
+		e  = ep.Get()
+		pb = ep.buffers.Get().(*pooledBuffer)
+	)
+
+	defer ep.Put(e)
+	e.Reset(&pb.Buffer)
+	if err := e.Encode(value); err != nil {
+		pb.release()
+		return nil, nil, err
+	}
+
+	return &pb.Buffer, pb.release, nil
+}
+
+// EncodeToResponse streams the encoded value to an HTTP response with the given status code.  The response has
+// no Content-Length, since that is not known until encoding completes, so HTTP/1.1 responses are chunked.  The
+// status code is written before encoding begins, so an encoding error can only be reported by abandoning the
+// response.  Use EncodeToResponseBuffered when the length matters or errors must be reported to the client.
+//
+// For a pool created with NewEncoderPool or NewLazyEncoderPool, the Content-Type is set to the format's MIME type.
+// Otherwise, the caller is responsible for the Content-Type.
+func (ep *EncoderPool) EncodeToResponse(response http.ResponseWriter, status int, value interface{}) error {
+	ep.setContentType(response)
+	response.WriteHeader(status)
+	return ep.Encode(response, value)
+}
+
+// EncodeToResponseBuffered is like EncodeToResponse, except that the value is encoded to a buffer first so that
+// the response carries a Content-Length.  This trades a copy of the encoded value for a known length, which lets
+// clients report progress on large responses.  If encoding fails, nothing is written to the response and the
+// caller is free to write an error instead.
+func (ep *EncoderPool) EncodeToResponseBuffered(response http.ResponseWriter, status int, value interface{}) error {
+	output, err := ep.EncodeBytes(value)
+	if err != nil {
+		return err
+	}
+
+	ep.setContentType(response)
+	response.Header().Set("Content-Length", strconv.Itoa(len(output)))
+	response.WriteHeader(status)
+	_, err = response.Write(output)
+	return err
+}
+
+func (ep *EncoderPool) setContentType(response http.ResponseWriter) {
+	if len(ep.contentType) > 0 {
+		response.Header().Set("Content-Type", ep.contentType)
+	}
+}
+
+// DecoderPool represents a pool of Decoder objects that can be used to decode WRP messages.
+// Unlike a sync.Pool, this pool holds on to its pooled decoders across garbage collections.
+// Decoders obtained from this pool should be returned via Put when no longer needed.
+type DecoderPool struct {
+	// hits and misses are first to guarantee 64-bit alignment for atomic access
+	hits   uint64
+	misses uint64
+
+	lock    sync.Mutex
+	pool    []Decoder
+	size    int
+	factory func() Decoder
+
+	// lazy indicates that decoders are only created on demand, never up front
+	lazy bool
+}
+
+// NewDecoderPool returns a DecoderPool whose decoders are created with NewDecoder for the given format.
+// This function delegates to NewDecoderPoolFunc.
+func NewDecoderPool(poolSize int, f Format) *DecoderPool {
+	return NewDecoderPoolFunc(
+		poolSize,
+		func() Decoder {
+			return NewDecoder(nil, f)
+		},
+	)
+}
+
+// NewDecoderPoolFunc returns a DecoderPool which creates decoders with the given factory.  This allows
+// pooled decoders to have any configuration.  If poolSize is nonpositive, DefaultPoolSize is used.
+//
+// The returned pool is filled with decoders up front.
+func NewDecoderPoolFunc(poolSize int, factory func() Decoder) *DecoderPool {
+	return newDecoderPool(poolSize, factory, false)
+}
+
+// NewLazyDecoderPool is like NewDecoderPool, except that the returned pool is lazy.
+// This function delegates to NewLazyDecoderPoolFunc.
+func NewLazyDecoderPool(poolSize int, f Format) *DecoderPool {
+	return NewLazyDecoderPoolFunc(
+		poolSize,
+		func() Decoder {
+			return NewDecoder(nil, f)
+		},
+	)
+}
+
+// NewLazyDecoderPoolFunc is like NewDecoderPoolFunc, except that the returned pool is lazy.  As with
+// NewLazyEncoderPoolFunc, decoders are only created on demand, which trades cold-start latency for
+// a pool that costs almost nothing until it is used.
+func NewLazyDecoderPoolFunc(poolSize int, factory func() Decoder) *DecoderPool {
+	return newDecoderPool(poolSize, factory, true)
+}
+
+func newDecoderPool(poolSize int, factory func() Decoder, lazy bool) *DecoderPool {
+	if poolSize < 1 {
+		poolSize = DefaultPoolSize
+	}
+
+	dp := &DecoderPool{
+		factory: factory,
+		lazy:    lazy,
+	}
+
+	if !lazy {
+		dp.pool = make([]Decoder, 0, poolSize)
+	}
+
+	dp.Resize(poolSize)
+	return dp
+}
+
+// Size returns the maximum number of decoders this pool will hold
+func (dp *DecoderPool) Size() int {
+	dp.lock.Lock()
+	s := dp.size
+	dp.lock.Unlock()
+
+	return s
+}
+
+// Resize changes the maximum number of decoders this pool will hold.  Growing an eager pool fills it
+// with new decoders, while growing a lazy pool only raises its limit.  Shrinking the pool discards any
+// excess decoders.  Nonpositive sizes are ignored.
+func (dp *DecoderPool) Resize(newSize int) {
+	if newSize < 1 {
+		return
+	}
+
+	dp.lock.Lock()
+	if newSize < len(dp.pool) {
+		for i := newSize; i < len(dp.pool); i++ {
+			dp.pool[i] = nil
+		}
+
+		dp.pool = dp.pool[:newSize]
+	}
+
+	dp.size = newSize
+	if !dp.lazy {
+		dp.fill()
+	}
+
+	dp.lock.Unlock()
+}
+
+// Warm fills this pool with new decoders up to its size, regardless of whether it is lazy.  This allows
+// a lazy pool to be pre-warmed once it is known to be needed.
+func (dp *DecoderPool) Warm() {
+	dp.lock.Lock()
+	dp.fill()
+	dp.lock.Unlock()
+}
+
+// fill creates decoders until this pool is full.  The lock must be held.
+func (dp *DecoderPool) fill() {
+	for len(dp.pool) < dp.size {
+		dp.pool = append(dp.pool, dp.factory())
+	}
+}
+
+// Stats returns a snapshot of this pool's usage.  Hits and Misses are counted from the time
+// this pool was created.
+func (dp *DecoderPool) Stats() PoolStats {
+	dp.lock.Lock()
+	idle := len(dp.pool)
+	dp.lock.Unlock()
+
+	return PoolStats{
+		Hits:   atomic.LoadUint64(&dp.hits),
+		Misses: atomic.LoadUint64(&dp.misses),
+		Idle:   idle,
+	}
+}
+
+// Get obtains a Decoder from this pool, creating a new one if the pool is empty.  The returned
+// Decoder must be reset to an input before use.
+func (dp *DecoderPool) Get() Decoder {
+	dp.lock.Lock()
+	if last := len(dp.pool) - 1; last >= 0 {
+		d := dp.pool[last]
+		dp.pool[last] = nil
+		dp.pool = dp.pool[:last]
+		dp.lock.Unlock()
+		atomic.AddUint64(&dp.hits, 1)
+		return d
+	}
+
+	dp.lock.Unlock()
+	atomic.AddUint64(&dp.misses, 1)
+	return dp.factory()
+}
+
+// Put returns a Decoder to this pool.  If the pool is full, the Decoder is discarded and
+// this method returns false.  The Decoder is first reset, so that the pool does not retain its input.
+func (dp *DecoderPool) Put(d Decoder) bool {
+	d.ResetBytes(nil)
+
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+
+	if len(dp.pool) < dp.size {
+		dp.pool = append(dp.pool, d)
+		return true
+	}
+
+	return false
+}
+
+// Decode uses a pooled Decoder to unmarshal the given bytes into target
+func (dp *DecoderPool) Decode(target interface{}, input []byte) error {
+	d := dp.Get()
+	defer dp.Put(d)
+
+	d.ResetBytes(input)
+	return d.Decode(target)
+}
@@ -0,0 +1,476 @@
+package wrp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var poolTestMessage = Message{
+	Type:            SimpleRequestResponseMessageType,
+	Source:          "dns:somewhere.comcast.net",
+	Destination:     "mac:112233445566",
+	TransactionUUID: "DEADBEEF",
+	Payload:         []byte("pooled payload"),
+}
+
+func testEncoderPoolDefaults(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		pool   = NewEncoderPool(-1, -1, Msgpack)
+	)
+
+	assert.Equal(DefaultPoolSize, pool.Size())
+	assert.Equal(DefaultInitialBufferSize, pool.initialBufferSize)
+	assert.Len(pool.pool, DefaultPoolSize)
+}
+
+func testEncoderPoolGetPut(t *testing.T, pool *EncoderPool, expectedFactoryCalls *int, factoryCalls *int) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	require.Equal(3, pool.Size())
+	assert.Equal(*expectedFactoryCalls, *factoryCalls)
+
+	encoders := []Encoder{pool.Get(), pool.Get(), pool.Get()}
+	assert.Equal(*expectedFactoryCalls, *factoryCalls)
+
+	// the pool is empty, so the factory is used
+	extra := pool.Get()
+	assert.NotNil(extra)
+	assert.Equal(*expectedFactoryCalls+1, *factoryCalls)
+
+	for _, e := range encoders {
+		assert.True(pool.Put(e))
+	}
+
+	assert.False(pool.Put(extra))
+
+	pool.Resize(1)
+	assert.Equal(1, pool.Size())
+	assert.Len(pool.pool, 1)
+
+	pool.Resize(0)
+	assert.Equal(1, pool.Size())
+
+	pool.Resize(5)
+	assert.Equal(5, pool.Size())
+	assert.Len(pool.pool, 5)
+	assert.Equal(*expectedFactoryCalls+5, *factoryCalls)
+}
+
+func testEncoderPoolEncode(t *testing.T, f Format) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		pool     = NewEncoderPool(1, 10, f)
+		expected = MustEncode(&poolTestMessage, f)
+		output   bytes.Buffer
+	)
+
+	require.NoError(pool.Encode(&output, &poolTestMessage))
+	assert.Equal(expected, output.Bytes())
+
+	actual, err := pool.EncodeBytes(&poolTestMessage)
+	require.NoError(err)
+	assert.Equal(expected, actual)
+	assert.Equal(1, len(pool.pool))
+}
+
+func testEncoderPoolEncodeBuffer(t *testing.T, f Format) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		pool     = NewEncoderPool(1, 10, f)
+		expected = MustEncode(&poolTestMessage, f)
+	)
+
+	buffer, cleanup, err := pool.EncodeBuffer(&poolTestMessage)
+	require.NoError(err)
+	require.NotNil(buffer)
+	require.NotNil(cleanup)
+	assert.Equal(expected, buffer.Bytes())
+	cleanup()
+
+	// a recycled buffer holds only the next value
+	other := poolTestMessage
+	other.Payload = []byte("a different payload")
+	buffer, cleanup, err = pool.EncodeBuffer(&other)
+	require.NoError(err)
+	assert.Equal(MustEncode(&other, f), buffer.Bytes())
+	cleanup()
+
+	assert.Equal(1, len(pool.pool))
+}
+
+func testEncoderPoolStats(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		pool   = NewEncoderPool(2, 0, Msgpack)
+	)
+
+	assert.Equal(PoolStats{Idle: 2}, pool.Stats())
+
+	encoders := []Encoder{pool.Get(), pool.Get(), pool.Get()}
+	assert.Equal(PoolStats{Hits: 2, Misses: 1}, pool.Stats())
+
+	for _, e := range encoders {
+		pool.Put(e)
+	}
+
+	assert.Equal(PoolStats{Hits: 2, Misses: 1, Idle: 2}, pool.Stats())
+
+	_, err := pool.EncodeBytes(&poolTestMessage)
+	assert.NoError(err)
+	assert.Equal(PoolStats{Hits: 3, Misses: 1, Idle: 2}, pool.Stats())
+}
+
+func testEncoderPoolEncodeError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected encode error")
+		encoder       = new(MockEncoder)
+		pool          = NewEncoderPoolFunc(1, 0, func() Encoder { return encoder })
+	)
+
+	// each encoder is reset before it is returned to the pool, so the pool retains no output
+	encoder.On("ResetBytes", (*[]byte)(nil)).Times(3)
+	encoder.OnResetBytes().Once()
+	encoder.OnReset().Twice()
+	encoder.OnEncode(expectedError).Times(3)
+
+	actual, err := pool.EncodeBytes(&poolTestMessage)
+	assert.Empty(actual)
+	assert.Equal(expectedError, err)
+
+	var output bytes.Buffer
+	assert.Equal(expectedError, pool.Encode(&output, &poolTestMessage))
+	assert.Zero(output.Len())
+
+	buffer, cleanup, err := pool.EncodeBuffer(&poolTestMessage)
+	assert.Nil(buffer)
+	assert.Nil(cleanup)
+	assert.Equal(expectedError, err)
+
+	// a failed encoder is still returned to the pool
+	assert.Equal(PoolStats{Hits: 3, Idle: 1}, pool.Stats())
+	encoder.AssertExpectations(t)
+}
+
+func testEncoderPoolLazy(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		factoryCalls = 0
+		pool         = NewLazyEncoderPoolFunc(2, 0, func() Encoder {
+			factoryCalls++
+			return NewEncoder(nil, Msgpack)
+		})
+	)
+
+	// an unused lazy pool holds nothing
+	assert.Equal(2, pool.Size())
+	assert.Zero(factoryCalls)
+	assert.Equal(PoolStats{}, pool.Stats())
+
+	encoders := []Encoder{pool.Get(), pool.Get(), pool.Get()}
+	assert.Equal(3, factoryCalls)
+	assert.Equal(PoolStats{Misses: 3}, pool.Stats())
+
+	// Put still caps the pool at its size
+	assert.True(pool.Put(encoders[0]))
+	assert.True(pool.Put(encoders[1]))
+	assert.False(pool.Put(encoders[2]))
+	assert.Equal(PoolStats{Misses: 3, Idle: 2}, pool.Stats())
+
+	// growing a lazy pool does not fill it, but warming does
+	pool.Resize(4)
+	assert.Len(pool.pool, 2)
+	assert.Equal(3, factoryCalls)
+
+	pool.Warm()
+	assert.Len(pool.pool, 4)
+	assert.Equal(5, factoryCalls)
+
+	actual, err := NewLazyEncoderPool(1, 0, JSON).EncodeBytes(&poolTestMessage)
+	require.NoError(err)
+	assert.Equal(MustEncode(&poolTestMessage, JSON), actual)
+}
+
+func testEncoderPoolEncodeToResponse(t *testing.T, f Format) {
+	var (
+		assert   = assert.New(t)
+		pool     = NewEncoderPool(1, 10, f)
+		expected = MustEncode(&poolTestMessage, f)
+	)
+
+	streamed := httptest.NewRecorder()
+	assert.NoError(pool.EncodeToResponse(streamed, http.StatusAccepted, &poolTestMessage))
+	assert.Equal(http.StatusAccepted, streamed.Code)
+	assert.Equal(f.ContentType(), streamed.Header().Get("Content-Type"))
+	assert.Empty(streamed.Header().Get("Content-Length"))
+	assert.Equal(expected, streamed.Body.Bytes())
+
+	buffered := httptest.NewRecorder()
+	assert.NoError(pool.EncodeToResponseBuffered(buffered, http.StatusOK, &poolTestMessage))
+	assert.Equal(http.StatusOK, buffered.Code)
+	assert.Equal(f.ContentType(), buffered.Header().Get("Content-Type"))
+	assert.Equal(strconv.Itoa(len(expected)), buffered.Header().Get("Content-Length"))
+	assert.Equal(expected, buffered.Body.Bytes())
+}
+
+func testEncoderPoolEncodeToResponseError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected encode error")
+		encoder       = new(MockEncoder)
+		pool          = NewEncoderPoolFunc(1, 0, func() Encoder { return encoder })
+	)
+
+	encoder.On("ResetBytes", (*[]byte)(nil)).Twice()
+	encoder.OnResetBytes().Once()
+	encoder.OnReset().Once()
+	encoder.OnEncode(expectedError).Twice()
+
+	// a buffered response is left untouched, so the caller can write an error instead
+	buffered := httptest.NewRecorder()
+	assert.Equal(expectedError, pool.EncodeToResponseBuffered(buffered, http.StatusOK, &poolTestMessage))
+	assert.Empty(buffered.Header())
+	assert.False(buffered.Flushed)
+	assert.Zero(buffered.Body.Len())
+
+	// a factory pool does not know its format, so the Content-Type is left to the caller
+	streamed := httptest.NewRecorder()
+	assert.Equal(expectedError, pool.EncodeToResponse(streamed, http.StatusOK, &poolTestMessage))
+	assert.Empty(streamed.Header().Get("Content-Type"))
+
+	encoder.AssertExpectations(t)
+}
+
+func TestEncoderPool(t *testing.T) {
+	t.Run("Defaults", testEncoderPoolDefaults)
+	t.Run("Lazy", testEncoderPoolLazy)
+	t.Run("Stats", testEncoderPoolStats)
+	t.Run("EncodeError", testEncoderPoolEncodeError)
+	t.Run("EncodeToResponseError", testEncoderPoolEncodeToResponseError)
+
+	t.Run("GetPut", func(t *testing.T) {
+		t.Run("NewEncoderPool", func(t *testing.T) {
+			var (
+				factoryCalls         = 0
+				expectedFactoryCalls = 0
+				pool                 = NewEncoderPool(3, 0, Msgpack)
+			)
+
+			// wrap the factory so that calls can be counted
+			factory := pool.factory
+			pool.factory = func() Encoder {
+				factoryCalls++
+				return factory()
+			}
+
+			testEncoderPoolGetPut(t, pool, &expectedFactoryCalls, &factoryCalls)
+		})
+
+		t.Run("NewEncoderPoolFunc", func(t *testing.T) {
+			var (
+				factoryCalls         = 0
+				expectedFactoryCalls = 3
+				pool                 = NewEncoderPoolFunc(3, 0, func() Encoder {
+					factoryCalls++
+					return NewEncoder(nil, JSON)
+				})
+			)
+
+			testEncoderPoolGetPut(t, pool, &expectedFactoryCalls, &factoryCalls)
+		})
+	})
+
+	for _, f := range allFormats {
+		t.Run(fmt.Sprintf("Encode%s", f), func(t *testing.T) {
+			testEncoderPoolEncode(t, f)
+		})
+
+		t.Run(fmt.Sprintf("EncodeBuffer%s", f), func(t *testing.T) {
+			testEncoderPoolEncodeBuffer(t, f)
+		})
+
+		t.Run(fmt.Sprintf("EncodeToResponse%s", f), func(t *testing.T) {
+			testEncoderPoolEncodeToResponse(t, f)
+		})
+	}
+}
+
+func BenchmarkEncoderPool(b *testing.B) {
+	for _, f := range allFormats {
+		pool := NewEncoderPool(1, 0, f)
+
+		b.Run(fmt.Sprintf("EncodeBytes%s", f), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := pool.EncodeBytes(&poolTestMessage); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("EncodeBuffer%s", f), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, cleanup, err := pool.EncodeBuffer(&poolTestMessage)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				cleanup()
+			}
+		})
+	}
+}
+
+func testDecoderPoolDefaults(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		pool   = NewDecoderPool(-1, JSON)
+	)
+
+	assert.Equal(DefaultPoolSize, pool.Size())
+	assert.Len(pool.pool, DefaultPoolSize)
+}
+
+func testDecoderPoolGetPut(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		factoryCalls = 0
+		pool         = NewDecoderPoolFunc(2, func() Decoder {
+			factoryCalls++
+			return NewDecoder(nil, Msgpack)
+		})
+	)
+
+	assert.Equal(2, pool.Size())
+	assert.Equal(2, factoryCalls)
+
+	decoders := []Decoder{pool.Get(), pool.Get(), pool.Get()}
+	assert.Equal(3, factoryCalls)
+
+	assert.True(pool.Put(decoders[0]))
+	assert.True(pool.Put(decoders[1]))
+	assert.False(pool.Put(decoders[2]))
+
+	pool.Resize(1)
+	assert.Equal(1, pool.Size())
+	assert.Len(pool.pool, 1)
+
+	pool.Resize(3)
+	assert.Equal(3, pool.Size())
+	assert.Len(pool.pool, 3)
+	assert.Equal(5, factoryCalls)
+}
+
+func testDecoderPoolDecode(t *testing.T, f Format) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		pool    = NewDecoderPool(1, f)
+		actual  Message
+	)
+
+	require.NoError(pool.Decode(&actual, MustEncode(&poolTestMessage, f)))
+	assert.Equal(poolTestMessage, actual)
+	assert.Len(pool.pool, 1)
+}
+
+func testDecoderPoolStats(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		pool   = NewDecoderPool(1, Msgpack)
+		actual Message
+	)
+
+	assert.Equal(PoolStats{Idle: 1}, pool.Stats())
+
+	first, second := pool.Get(), pool.Get()
+	assert.Equal(PoolStats{Hits: 1, Misses: 1}, pool.Stats())
+
+	pool.Put(first)
+	pool.Put(second)
+	assert.Equal(PoolStats{Hits: 1, Misses: 1, Idle: 1}, pool.Stats())
+
+	assert.NoError(pool.Decode(&actual, MustEncode(&poolTestMessage, Msgpack)))
+	assert.Equal(PoolStats{Hits: 2, Misses: 1, Idle: 1}, pool.Stats())
+}
+
+func testDecoderPoolDecodeError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected decode error")
+		decoder       = new(MockDecoder)
+		pool          = NewDecoderPoolFunc(1, func() Decoder { return decoder })
+		input         = []byte("input")
+		actual        Message
+	)
+
+	decoder.On("ResetBytes", input).Once()
+	decoder.On("Decode", &actual).Return(expectedError).Once()
+
+	// the decoder is reset before it is returned to the pool, so the pool retains no input
+	decoder.On("ResetBytes", []byte(nil)).Once()
+
+	assert.Equal(expectedError, pool.Decode(&actual, input))
+	assert.Equal(PoolStats{Hits: 1, Idle: 1}, pool.Stats())
+	decoder.AssertExpectations(t)
+}
+
+func testDecoderPoolLazy(t *testing.T) {
+	var (
+		assert       = assert.New(t)
+		require      = require.New(t)
+		factoryCalls = 0
+		pool         = NewLazyDecoderPoolFunc(1, func() Decoder {
+			factoryCalls++
+			return NewDecoder(nil, Msgpack)
+		})
+		actual Message
+	)
+
+	assert.Equal(1, pool.Size())
+	assert.Zero(factoryCalls)
+
+	first, second := pool.Get(), pool.Get()
+	assert.Equal(2, factoryCalls)
+	assert.True(pool.Put(first))
+	assert.False(pool.Put(second))
+
+	pool.Resize(3)
+	assert.Len(pool.pool, 1)
+	pool.Warm()
+	assert.Len(pool.pool, 3)
+	assert.Equal(4, factoryCalls)
+
+	require.NoError(NewLazyDecoderPool(1, JSON).Decode(&actual, MustEncode(&poolTestMessage, JSON)))
+	assert.Equal(poolTestMessage, actual)
+}
+
+func TestDecoderPool(t *testing.T) {
+	t.Run("Defaults", testDecoderPoolDefaults)
+	t.Run("Lazy", testDecoderPoolLazy)
+	t.Run("GetPut", testDecoderPoolGetPut)
+	t.Run("Stats", testDecoderPoolStats)
+	t.Run("DecodeError", testDecoderPoolDecodeError)
+
+	for _, f := range allFormats {
+		t.Run(fmt.Sprintf("Decode%s", f), func(t *testing.T) {
+			testDecoderPoolDecode(t, f)
+		})
+	}
+}
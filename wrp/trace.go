@@ -0,0 +1,118 @@
+package wrp
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// TraceParentHeader is the name of the W3C trace context header carried within a Message's Headers.
+// The header is stored as a single "traceparent:<value>" entry.
+const TraceParentHeader = "traceparent"
+
+var ErrInvalidTraceParent = errors.New("Invalid W3C traceparent")
+
+// isLowerHex tests that a string consists solely of lowercase hexadecimal digits
+func isLowerHex(v string) bool {
+	for _, c := range v {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidTraceParent tests if the given value is a well-formed W3C traceparent, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".  Versions after 00 are allowed
+// to append further fields, per the W3C specification.
+func ValidTraceParent(v string) bool {
+	fields := strings.Split(v, "-")
+	if len(fields) < 4 {
+		return false
+	}
+
+	var (
+		version  = fields[0]
+		traceID  = fields[1]
+		parentID = fields[2]
+		flags    = fields[3]
+	)
+
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" || (version == "00" && len(fields) != 4) {
+		return false
+	}
+
+	if len(traceID) != 32 || !isLowerHex(traceID) || traceID == strings.Repeat("0", 32) {
+		return false
+	}
+
+	if len(parentID) != 16 || !isLowerHex(parentID) || parentID == strings.Repeat("0", 16) {
+		return false
+	}
+
+	return len(flags) == 2 && isLowerHex(flags)
+}
+
+// traceParentIndex returns the index within Headers of the traceparent entry along with its value,
+// or -1 if there is no such entry.  Header names are matched case-insensitively.
+func (msg *Message) traceParentIndex() (int, string) {
+	for i, h := range msg.Headers {
+		colon := strings.IndexByte(h, ':')
+		if colon < 0 {
+			continue
+		}
+
+		if strings.EqualFold(strings.TrimSpace(h[:colon]), TraceParentHeader) {
+			return i, strings.TrimSpace(h[colon+1:])
+		}
+	}
+
+	return -1, ""
+}
+
+// TraceParent returns the W3C traceparent carried in this message's Headers.  If there is no
+// traceparent, or if the traceparent is malformed, this method returns false.
+func (msg *Message) TraceParent() (string, bool) {
+	if i, v := msg.traceParentIndex(); i >= 0 && ValidTraceParent(v) {
+		return v, true
+	}
+
+	return "", false
+}
+
+// SetTraceParent stores the given W3C traceparent in this message's Headers, replacing any existing
+// traceparent.  ErrInvalidTraceParent is returned if the value is malformed, in which case this
+// message is unchanged.
+func (msg *Message) SetTraceParent(v string) error {
+	if !ValidTraceParent(v) {
+		return ErrInvalidTraceParent
+	}
+
+	header := TraceParentHeader + ":" + v
+	if i, _ := msg.traceParentIndex(); i >= 0 {
+		// copy, as the Headers slice may be shared with other messages
+		headers := make([]string, len(msg.Headers))
+		copy(headers, msg.Headers)
+		headers[i] = header
+		msg.Headers = headers
+	} else {
+		msg.Headers = append(msg.Headers[:len(msg.Headers):len(msg.Headers)], header)
+	}
+
+	return nil
+}
+
+type traceParentKey struct{}
+
+// WithTraceParent returns a context carrying the given W3C traceparent, which is typically the
+// span of the caller that is about to send a message.
+func WithTraceParent(ctx context.Context, v string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, v)
+}
+
+// TraceParentFromContext returns the W3C traceparent stored in the context by WithTraceParent
+func TraceParentFromContext(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(traceParentKey{}).(string)
+	return v, ok && len(v) > 0
+}
@@ -0,0 +1,82 @@
+package wrp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestValidTraceParent(t *testing.T) {
+	testData := []struct {
+		value    string
+		expected bool
+	}{
+		{testTraceParent, true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", false},
+	}
+
+	for _, record := range testData {
+		assert.Equal(t, record.expected, ValidTraceParent(record.value), record.value)
+	}
+}
+
+func TestMessageTraceParent(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		message Message
+	)
+
+	_, ok := message.TraceParent()
+	assert.False(ok)
+
+	assert.Equal(ErrInvalidTraceParent, message.SetTraceParent("invalid"))
+	assert.Empty(message.Headers)
+
+	require.NoError(message.SetTraceParent(testTraceParent))
+	assert.Equal([]string{"traceparent:" + testTraceParent}, message.Headers)
+
+	actual, ok := message.TraceParent()
+	assert.True(ok)
+	assert.Equal(testTraceParent, actual)
+
+	// replacement must not disturb other headers or any shared slice
+	shared := []string{"X-Other: value", "TraceParent: " + testTraceParent}
+	message.Headers = shared
+	replacement := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	require.NoError(message.SetTraceParent(replacement))
+	assert.Equal([]string{"X-Other: value", "traceparent:" + replacement}, message.Headers)
+	assert.Equal("TraceParent: "+testTraceParent, shared[1])
+
+	actual, ok = message.TraceParent()
+	assert.True(ok)
+	assert.Equal(replacement, actual)
+
+	message.Headers = []string{"traceparent: garbage"}
+	_, ok = message.TraceParent()
+	assert.False(ok)
+}
+
+func TestTraceParentContext(t *testing.T) {
+	assert := assert.New(t)
+
+	_, ok := TraceParentFromContext(context.Background())
+	assert.False(ok)
+
+	actual, ok := TraceParentFromContext(WithTraceParent(context.Background(), testTraceParent))
+	assert.True(ok)
+	assert.Equal(testTraceParent, actual)
+}
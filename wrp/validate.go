@@ -0,0 +1,165 @@
+package wrp
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// FieldError indicates that a single field of a WRP message failed validation
+type FieldError struct {
+	// Field is the name of the offending Message field, e.g. "Type"
+	Field string
+
+	// Reason describes what is wrong with the field
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// Valid checks that this message is fit to send.  The type must be one of the MessageType constants,
+// the message must have a destination, and a SimpleRequestResponseMessageType message must carry a
+// TransactionUUID so that its response can be correlated.  The first problem found is returned as
+// a *FieldError.
+func (msg *Message) Valid() error {
+	if !msg.Type.IsValid() {
+		return &FieldError{Field: "Type", Reason: fmt.Sprintf("unsupported message type %d", msg.Type)}
+	}
+
+	if len(msg.Destination) == 0 {
+		return &FieldError{Field: "Destination", Reason: "missing destination"}
+	}
+
+	if msg.Type == SimpleRequestResponseMessageType && len(msg.TransactionUUID) == 0 {
+		return &FieldError{Field: "TransactionUUID", Reason: "missing transaction UUID for " + msg.Type.FriendlyName()}
+	}
+
+	return nil
+}
+
+// forEachString invokes visit for each string this message holds, along with the name of its field.  The
+// visitor may replace the value it is given.  Iteration stops as soon as the visitor returns false.
+func (msg *Message) forEachString(visit func(field string, value *string) bool) {
+	for _, f := range []struct {
+		field string
+		value *string
+	}{
+		{"Source", &msg.Source},
+		{"Destination", &msg.Destination},
+		{"TransactionUUID", &msg.TransactionUUID},
+		{"ContentType", &msg.ContentType},
+		{"Accept", &msg.Accept},
+		{"Path", &msg.Path},
+		{"ServiceName", &msg.ServiceName},
+		{"URL", &msg.URL},
+		{"ChainID", &msg.ChainID},
+	} {
+		if !visit(f.field, f.value) {
+			return
+		}
+	}
+
+	for i := range msg.Headers {
+		if !visit("Headers", &msg.Headers[i]) {
+			return
+		}
+	}
+
+	for i := range msg.PartnerIDs {
+		if !visit("PartnerIDs", &msg.PartnerIDs[i]) {
+			return
+		}
+	}
+
+	for i := range msg.Spans {
+		for j := range msg.Spans[i] {
+			if !visit("Spans", &msg.Spans[i][j]) {
+				return
+			}
+		}
+	}
+
+	// keys are strings too, so replacements are applied once iteration over the map is done
+	var replaced map[string][2]string
+	for key, value := range msg.Metadata {
+		k, v := key, value
+		if !visit("Metadata", &k) || !visit("Metadata", &v) {
+			return
+		}
+
+		if k != key || v != value {
+			if replaced == nil {
+				replaced = make(map[string][2]string)
+			}
+
+			replaced[key] = [2]string{k, v}
+		}
+	}
+
+	for key, replacement := range replaced {
+		delete(msg.Metadata, key)
+		msg.Metadata[replacement[0]] = replacement[1]
+	}
+}
+
+// ValidateUTF8 checks that every string in this message, including the elements of Headers, PartnerIDs, Spans,
+// and the keys and values of Metadata, is valid UTF-8.  The Payload is not examined, as it is opaque.  The first
+// invalid field found is returned as a *FieldError.
+func (msg *Message) ValidateUTF8() (err error) {
+	msg.forEachString(func(field string, value *string) bool {
+		if !utf8.ValidString(*value) {
+			err = &FieldError{Field: field, Reason: fmt.Sprintf("invalid UTF-8: %q", *value)}
+			return false
+		}
+
+		return true
+	})
+
+	return
+}
+
+// SanitizeUTF8 replaces each invalid UTF-8 sequence in the strings examined by ValidateUTF8 with the Unicode
+// replacement character.  This method returns true if any field was changed.
+func (msg *Message) SanitizeUTF8() (changed bool) {
+	if msg.ValidateUTF8() == nil {
+		return false
+	}
+
+	msg.forEachString(func(_ string, value *string) bool {
+		if !utf8.ValidString(*value) {
+			*value = sanitizeUTF8(*value)
+			changed = true
+		}
+
+		return true
+	})
+
+	return
+}
+
+// sanitizeUTF8 replaces each run of invalid bytes in v with a single utf8.RuneError
+func sanitizeUTF8(v string) string {
+	var (
+		sanitized = make([]byte, 0, len(v))
+		invalid   = false
+	)
+
+	for i := 0; i < len(v); {
+		r, size := utf8.DecodeRuneInString(v[i:])
+		if r == utf8.RuneError && size == 1 {
+			if !invalid {
+				sanitized = append(sanitized, string(utf8.RuneError)...)
+			}
+
+			invalid = true
+		} else {
+			sanitized = append(sanitized, v[i:i+size]...)
+			invalid = false
+		}
+
+		i += size
+	}
+
+	return string(sanitized)
+}
@@ -0,0 +1,103 @@
+package wrp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageValid(t *testing.T) {
+	for _, record := range []struct {
+		name          string
+		message       Message
+		expectedField string
+	}{
+		{"Event", Message{Type: SimpleEventMessageType, Destination: "event:device-status"}, ""},
+		{"Request", Message{Type: SimpleRequestResponseMessageType, Destination: "mac:112233445566", TransactionUUID: "1234"}, ""},
+		{"Retrieve", Message{Type: RetrieveMessageType, Destination: "mac:112233445566"}, ""},
+		{"ZeroType", Message{Destination: "mac:112233445566"}, "Type"},
+		{"UnknownType", Message{Type: lastMessageType, Destination: "mac:112233445566"}, "Type"},
+		{"MissingDestination", Message{Type: SimpleEventMessageType}, "Destination"},
+		{"MissingTransactionUUID", Message{Type: SimpleRequestResponseMessageType, Destination: "mac:112233445566"}, "TransactionUUID"},
+	} {
+		t.Run(record.name, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				err     = record.message.Valid()
+			)
+
+			if len(record.expectedField) == 0 {
+				assert.NoError(err)
+				return
+			}
+
+			require.IsType(new(FieldError), err)
+			assert.Equal(record.expectedField, err.(*FieldError).Field)
+			assert.NotEmpty(err.(*FieldError).Reason)
+			assert.Contains(err.Error(), record.expectedField)
+		})
+	}
+}
+
+func TestMessageValidateUTF8(t *testing.T) {
+	const invalid = "bad\xff\xfebytes"
+
+	for _, record := range []struct {
+		name          string
+		message       Message
+		expectedField string
+	}{
+		{"Valid", Message{Source: "dns:héllo.example.com", ContentType: "text/plain", Metadata: map[string]string{"/ключ": "值"}}, ""},
+		{"Source", Message{Source: invalid}, "Source"},
+		{"ContentType", Message{ContentType: invalid}, "ContentType"},
+		{"Headers", Message{Headers: []string{"ok", invalid}}, "Headers"},
+		{"PartnerIDs", Message{PartnerIDs: []string{invalid}}, "PartnerIDs"},
+		{"Spans", Message{Spans: [][]string{{"span", invalid}}}, "Spans"},
+		{"MetadataKey", Message{Metadata: map[string]string{invalid: "value"}}, "Metadata"},
+		{"MetadataValue", Message{Metadata: map[string]string{"key": invalid}}, "Metadata"},
+		{"Payload", Message{Payload: []byte(invalid)}, ""},
+	} {
+		t.Run(record.name, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				err     = record.message.ValidateUTF8()
+			)
+
+			if len(record.expectedField) == 0 {
+				assert.NoError(err)
+				assert.False(record.message.SanitizeUTF8())
+				return
+			}
+
+			require.IsType(new(FieldError), err)
+			assert.Equal(record.expectedField, err.(*FieldError).Field)
+			assert.NotEmpty(err.Error())
+
+			assert.True(record.message.SanitizeUTF8())
+			assert.NoError(record.message.ValidateUTF8())
+		})
+	}
+}
+
+func TestMessageSanitizeUTF8(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		message = Message{
+			Source:   "dns:\xffexample.com",
+			Headers:  []string{"ok", "a\xff\xfeb"},
+			Metadata: map[string]string{"\xffkey": "value", "valid": "v\xc3"},
+			Payload:  []byte("\xff"),
+		}
+	)
+
+	assert.True(message.SanitizeUTF8())
+	assert.Equal("dns:�example.com", message.Source)
+
+	// each run of invalid bytes becomes a single replacement character
+	assert.Equal([]string{"ok", "a�b"}, message.Headers)
+	assert.Equal(map[string]string{"�key": "value", "valid": "v�"}, message.Metadata)
+	assert.Equal([]byte("\xff"), message.Payload)
+}
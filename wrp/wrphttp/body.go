@@ -0,0 +1,92 @@
+package wrphttp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+// ErrEmptyBody is returned by DecodeRequestBody when the HTTP request has no entity
+var ErrEmptyBody = errors.New("The HTTP request body did not contain a WRP message")
+
+// ContentTypeError indicates that an HTTP Content-Type did not map to a WRP format
+type ContentTypeError struct {
+	// ContentType is the offending value, which is empty if the header was missing
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	if len(e.ContentType) == 0 {
+		return "Missing WRP content type"
+	}
+
+	return fmt.Sprintf("Unsupported WRP content type: %q", e.ContentType)
+}
+
+var (
+	bodyDecoders = make(map[wrp.Format]*wrp.DecoderPool)
+	bodyEncoders = make(map[wrp.Format]*wrp.EncoderPool)
+)
+
+func init() {
+	for _, f := range wrp.AllFormats() {
+		bodyDecoders[f] = wrp.NewDecoderPool(wrp.DefaultPoolSize, f)
+		bodyEncoders[f] = wrp.NewEncoderPool(wrp.DefaultPoolSize, wrp.DefaultInitialBufferSize, f)
+	}
+}
+
+// DecodeRequestBody reads a single WRP message from an HTTP request body.  The format is selected from
+// the request's Content-Type, which must be present and must map to a WRP format, or a *ContentTypeError
+// is returned.  An empty body results in ErrEmptyBody.  Decoding uses pooled decoders, so this function is
+// appropriate for busy endpoints.
+func DecodeRequestBody(r *http.Request) (*wrp.Message, wrp.Format, error) {
+	contentType := r.Header.Get("Content-Type")
+	format, err := wrp.FormatFromContentType(contentType)
+	if err != nil {
+		return nil, format, &ContentTypeError{ContentType: contentType}
+	}
+
+	if r.Body == nil {
+		return nil, format, ErrEmptyBody
+	}
+
+	contents, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, format, err
+	}
+
+	if len(contents) == 0 {
+		return nil, format, ErrEmptyBody
+	}
+
+	message := new(wrp.Message)
+	if err := bodyDecoders[format].Decode(message, contents); err != nil {
+		return nil, format, err
+	}
+
+	return message, format, nil
+}
+
+// EncodeResponseBody writes a WRP message as an HTTP response body in the given format, setting the
+// Content-Type and Content-Length headers accordingly.  The message is fully encoded before anything is
+// written, so an encoding failure leaves the response untouched and the caller free to report an error.
+func EncodeResponseBody(response http.ResponseWriter, m *wrp.Message, f wrp.Format) error {
+	encoders, ok := bodyEncoders[f]
+	if !ok {
+		return fmt.Errorf("Invalid WRP format: %s", f)
+	}
+
+	contents, err := encoders.EncodeBytes(m)
+	if err != nil {
+		return err
+	}
+
+	response.Header().Set("Content-Type", f.ContentType())
+	response.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+	_, err = response.Write(contents)
+	return err
+}
@@ -0,0 +1,165 @@
+package wrphttp
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/Comcast/webpa-common/xhttp/xhttptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testBodyMessage = wrp.Message{
+	Type:            wrp.SimpleRequestResponseMessageType,
+	Source:          "mac:112233445566",
+	Destination:     "event:device-status",
+	TransactionUUID: "1234",
+	ContentType:     "text/plain",
+	Payload:         []byte("hello, world"),
+}
+
+func testDecodeRequestBodySuccess(t *testing.T) {
+	for _, f := range wrp.AllFormats() {
+		for _, contentType := range []string{f.ContentType(), f.ContentType() + "; charset=utf-8"} {
+			t.Run(contentType, func(t *testing.T) {
+				var (
+					assert  = assert.New(t)
+					require = require.New(t)
+					body    []byte
+				)
+
+				require.NoError(wrp.NewEncoderBytes(&body, f).Encode(&testBodyMessage))
+				request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+				request.Header.Set("Content-Type", contentType)
+
+				message, format, err := DecodeRequestBody(request)
+				require.NoError(err)
+				assert.Equal(f, format)
+				assert.Equal(testBodyMessage, *message)
+			})
+		}
+	}
+}
+
+func testDecodeRequestBodyContentType(t *testing.T) {
+	for _, contentType := range []string{"", "text/plain", "application/octet-stream"} {
+		t.Run(contentType, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				request = httptest.NewRequest("POST", "/", bytes.NewReader([]byte("data")))
+			)
+
+			if len(contentType) > 0 {
+				request.Header.Set("Content-Type", contentType)
+			}
+
+			message, _, err := DecodeRequestBody(request)
+			assert.Nil(message)
+			require.IsType(new(ContentTypeError), err)
+			assert.Equal(contentType, err.(*ContentTypeError).ContentType)
+			assert.NotEmpty(err.Error())
+		})
+	}
+}
+
+func testDecodeRequestBodyEmpty(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = httptest.NewRequest("POST", "/", nil)
+	)
+
+	request.Header.Set("Content-Type", wrp.Msgpack.ContentType())
+	message, format, err := DecodeRequestBody(request)
+	assert.Nil(message)
+	assert.Equal(wrp.Msgpack, format)
+	assert.Equal(ErrEmptyBody, err)
+
+	request.Body = nil
+	message, _, err = DecodeRequestBody(request)
+	assert.Nil(message)
+	assert.Equal(ErrEmptyBody, err)
+}
+
+func testDecodeRequestBodyInvalid(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		request = httptest.NewRequest("POST", "/", bytes.NewReader([]byte("this is not JSON")))
+	)
+
+	request.Header.Set("Content-Type", wrp.JSON.ContentType())
+	message, format, err := DecodeRequestBody(request)
+	assert.Nil(message)
+	assert.Equal(wrp.JSON, format)
+	assert.Error(err)
+}
+
+func testDecodeRequestBodyReadError(t *testing.T) {
+	var (
+		assert        = assert.New(t)
+		expectedError = errors.New("expected read error")
+		body          = new(xhttptest.MockBody)
+		request       = httptest.NewRequest("POST", "/", nil)
+	)
+
+	body.OnReadError(expectedError).Once()
+	request.Body = body
+	request.Header.Set("Content-Type", wrp.Msgpack.ContentType())
+
+	message, _, err := DecodeRequestBody(request)
+	assert.Nil(message)
+	assert.Equal(expectedError, err)
+	body.AssertExpectations(t)
+}
+
+func TestDecodeRequestBody(t *testing.T) {
+	t.Run("Success", testDecodeRequestBodySuccess)
+	t.Run("ContentType", testDecodeRequestBodyContentType)
+	t.Run("Empty", testDecodeRequestBodyEmpty)
+	t.Run("Invalid", testDecodeRequestBodyInvalid)
+	t.Run("ReadError", testDecodeRequestBodyReadError)
+}
+
+func testEncodeResponseBodySuccess(t *testing.T) {
+	for _, f := range wrp.AllFormats() {
+		t.Run(f.String(), func(t *testing.T) {
+			var (
+				assert   = assert.New(t)
+				require  = require.New(t)
+				response = httptest.NewRecorder()
+			)
+
+			require.NoError(EncodeResponseBody(response, &testBodyMessage, f))
+			assert.Equal(f.ContentType(), response.HeaderMap.Get("Content-Type"))
+			assert.Equal(strconv.Itoa(response.Body.Len()), response.HeaderMap.Get("Content-Length"))
+
+			// the response body round-trips through DecodeRequestBody
+			request := httptest.NewRequest("POST", "/", response.Body)
+			request.Header.Set("Content-Type", response.HeaderMap.Get("Content-Type"))
+			message, format, err := DecodeRequestBody(request)
+			require.NoError(err)
+			assert.Equal(f, format)
+			assert.Equal(testBodyMessage, *message)
+		})
+	}
+}
+
+func testEncodeResponseBodyInvalidFormat(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		response = httptest.NewRecorder()
+	)
+
+	assert.Error(EncodeResponseBody(response, &testBodyMessage, wrp.Format(999)))
+	assert.Empty(response.HeaderMap)
+	assert.Zero(response.Body.Len())
+}
+
+func TestEncodeResponseBody(t *testing.T) {
+	t.Run("Success", testEncodeResponseBodySuccess)
+	t.Run("InvalidFormat", testEncodeResponseBodyInvalidFormat)
+}
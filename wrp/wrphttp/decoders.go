@@ -64,6 +64,24 @@ func DecodeRequestHeaders(ctx context.Context, original *http.Request) (*Entity,
 	return entity, err
 }
 
+// DecodeRequestHeadersWith produces a Decoder like DecodeRequestHeaders, except that the given
+// HeaderTranslation is used to set the fields of the WRP message.
+func DecodeRequestHeadersWith(ht HeaderTranslation) Decoder {
+	return func(ctx context.Context, original *http.Request) (*Entity, error) {
+		entity := &Entity{
+			Format: wrp.Msgpack,
+		}
+
+		err := ht.SetMessage(original.Header, &entity.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = ReadPayload(original.Header, original.Body, &entity.Message)
+		return entity, err
+	}
+}
+
 // MessageFunc is a strategy for post-processing a WRP message, adding things to the
 // context or performing other processing on the message itself.
 type MessageFunc func(context.Context, *wrp.Message) context.Context
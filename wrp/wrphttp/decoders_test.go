@@ -150,3 +150,25 @@ func TestDecodeRequestHeaders(t *testing.T) {
 	t.Run("Success", testDecodeRequestHeadersSuccess)
 	t.Run("Invalid", testDecodeRequestHeadersInvalid)
 }
+
+func TestDecodeRequestHeadersWith(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		decoder = DecodeRequestHeadersWith(HeaderTranslation{AcceptFromHTTP: true, DefaultAccept: "application/msgpack"})
+		request = httptest.NewRequest("POST", "/", nil)
+	)
+
+	request.Header.Set(MessageTypeHeader, "event")
+	request.Header.Set("Accept", "application/json")
+	entity, err := decoder(context.Background(), request)
+	require.NoError(err)
+	require.NotNil(entity)
+	assert.Equal("application/json", entity.Message.Accept)
+
+	request = httptest.NewRequest("POST", "/", nil)
+	request.Header.Set(MessageTypeHeader, "askdjfa;skdjfasdf")
+	entity, err = decoder(context.Background(), request)
+	assert.Nil(entity)
+	assert.Error(err)
+}
@@ -1,12 +1,15 @@
 package wrphttp
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Comcast/webpa-common/wrp"
 )
@@ -22,12 +25,77 @@ const (
 	SourceHeader                  = "X-Xmidt-Source"
 	DestinationHeader             = "X-Webpa-Device-Name"
 	AcceptHeader                  = "X-Xmidt-Accept"
+	MetadataHeader                = "X-Xmidt-Metadata"
+	ExpiresHeader                 = "X-Xmidt-Expires"
+	TTLHeader                     = "X-Xmidt-Ttl"
+	ChainIDHeader                 = "X-Xmidt-Chain-Id"
+)
+
+const (
+	// UnknownHeaderPrefix is the prefix of the headers that HeaderTranslation.PreserveUnknown captures.  Any header
+	// with this prefix that does not carry a WRP field known to this package is considered unknown.
+	UnknownHeaderPrefix = "X-Xmidt-"
+)
+
+const (
+	// DefaultIntHeaderBase is the numeric base used for integer headers, such as StatusHeader,
+	// when no other base is specified.
+	DefaultIntHeaderBase = 10
 )
 
 var (
 	errMissingMessageTypeHeader = fmt.Errorf("Missing %s header", MessageTypeHeader)
+
+	// ErrTooManyHeaderValues is returned by HeaderTranslation.SetMessage when a request carries more span and
+	// captured header values than HeaderTranslation.MaxHeaderValues allows
+	ErrTooManyHeaderValues = errors.New("Too many WRP header values")
+
+	// ErrHeaderValuesTooLarge is returned by HeaderTranslation.SetMessage when the span and captured header
+	// values of a request total more bytes than HeaderTranslation.MaxHeaderBytes allows
+	ErrHeaderValuesTooLarge = errors.New("WRP header values too large")
 )
 
+// IntHeaderError is returned when an integer header, such as StatusHeader or RequestDeliveryResponseHeader,
+// is present but cannot be parsed.  The Header field allows callers to tell which header was bad.
+type IntHeaderError struct {
+	// Header is the name of the offending header
+	Header string
+
+	// Value is the raw value of the offending header
+	Value string
+
+	// Err is the underlying parse error
+	Err error
+}
+
+func (e *IntHeaderError) Error() string {
+	return fmt.Sprintf("Invalid %s header %q: %s", e.Header, e.Value, e.Err)
+}
+
+// ParseIntHeader tolerantly parses an integer header value.  Surrounding whitespace and a single pair of
+// surrounding double quotes are ignored.  The base has the same semantics as strconv.ParseInt, so a base
+// of 0 infers the base from the value's prefix, e.g. "0x" for hexadecimal.  For an explicit base of 16,
+// an optional "0x" or "0X" prefix is also accepted.
+func ParseIntHeader(value string, base int) (int64, error) {
+	value = strings.TrimSpace(value)
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+
+	if base == 16 {
+		negative := strings.HasPrefix(value, "-")
+		digits := strings.TrimPrefix(value, "-")
+		if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+			value = digits[2:]
+			if negative {
+				value = "-" + value
+			}
+		}
+	}
+
+	return strconv.ParseInt(value, base, 64)
+}
+
 // getMessageType extracts the wrp.MessageType from header.  This is a required field.
 //
 // This function panics if the message type header is missing or invalid.
@@ -46,21 +114,44 @@ func getMessageType(h http.Header) wrp.MessageType {
 }
 
 // getIntHeader returns the header as a int64, or returns nil if the header is absent.
-// This function panics if the header is present but not a valid integer.
-func getIntHeader(h http.Header, n string) *int64 {
+// This function panics with an *IntHeaderError if the header is present but not a valid integer.
+func getIntHeader(h http.Header, n string, base int) *int64 {
 	value := h.Get(n)
 	if len(value) == 0 {
 		return nil
 	}
 
-	i, err := strconv.ParseInt(value, 10, 64)
+	i, err := ParseIntHeader(value, base)
 	if err != nil {
-		panic(err)
+		panic(&IntHeaderError{Header: n, Value: value, Err: err})
 	}
 
 	return &i
 }
 
+// getExpires returns the absolute expiry, in seconds since the epoch, described by either ExpiresHeader or
+// TTLHeader.  ExpiresHeader holds either an integer or an HTTP date, while TTLHeader holds an integer
+// number of seconds from now.  When both are present, ExpiresHeader takes precedence.  This function
+// panics with an *IntHeaderError if either header is present but malformed.
+func getExpires(h http.Header, base int, now func() time.Time) *int64 {
+	if value := h.Get(ExpiresHeader); len(value) > 0 {
+		if t, err := http.ParseTime(value); err == nil {
+			expires := t.Unix()
+			return &expires
+		}
+
+		return getIntHeader(h, ExpiresHeader, base)
+	}
+
+	ttl := getIntHeader(h, TTLHeader, base)
+	if ttl == nil {
+		return nil
+	}
+
+	expires := now().Add(time.Duration(*ttl) * time.Second).Unix()
+	return &expires
+}
+
 func getBoolHeader(h http.Header, n string) *bool {
 	value := h.Get(n)
 	if len(value) == 0 {
@@ -94,6 +185,29 @@ func getSpans(h http.Header) [][]string {
 	return spans
 }
 
+// getMetadata extracts the WRP metadata from the repeated MetadataHeader, each value of
+// which is of the form key=value.  If there are no such headers, this function returns nil.
+//
+// This function panics if any value is not of the form key=value.
+func getMetadata(h http.Header) map[string]string {
+	values := h[MetadataHeader]
+	if len(values) == 0 {
+		return nil
+	}
+
+	metadata := make(map[string]string, len(values))
+	for _, value := range values {
+		fields := strings.SplitN(value, "=", 2)
+		if len(fields) != 2 || len(strings.TrimSpace(fields[0])) == 0 {
+			panic(fmt.Errorf("Invalid %s header: %s", MetadataHeader, value))
+		}
+
+		metadata[strings.TrimSpace(fields[0])] = strings.TrimSpace(fields[1])
+	}
+
+	return metadata
+}
+
 func readPayload(h http.Header, p io.Reader) ([]byte, string) {
 	if p == nil {
 		return nil, ""
@@ -136,6 +250,7 @@ func NewMessageFromHeaders(h http.Header, p io.Reader) (message *wrp.Message, er
 	err = SetMessageFromHeaders(h, message)
 	if err != nil {
 		message = nil
+		return
 	}
 
 	message.Payload = payload
@@ -144,8 +259,14 @@ func NewMessageFromHeaders(h http.Header, p io.Reader) (message *wrp.Message, er
 }
 
 // SetMessageFromHeaders transfers header fields onto the given WRP message.  The payload is not
-// handled by this method.
-func SetMessageFromHeaders(h http.Header, m *wrp.Message) (err error) {
+// handled by this method.  Integer headers are parsed using DefaultIntHeaderBase.
+func SetMessageFromHeaders(h http.Header, m *wrp.Message) error {
+	return SetMessageFromHeadersBase(h, m, DefaultIntHeaderBase)
+}
+
+// SetMessageFromHeadersBase is like SetMessageFromHeaders, except that integer headers are parsed
+// with ParseIntHeader using the given base.  A bad integer header results in an *IntHeaderError.
+func SetMessageFromHeadersBase(h http.Header, m *wrp.Message, base int) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			switch v := r.(type) {
@@ -161,17 +282,194 @@ func SetMessageFromHeaders(h http.Header, m *wrp.Message) (err error) {
 	m.Source = h.Get(SourceHeader)
 	m.Destination = h.Get(DestinationHeader)
 	m.TransactionUUID = h.Get(TransactionUuidHeader)
-	m.Status = getIntHeader(h, StatusHeader)
-	m.RequestDeliveryResponse = getIntHeader(h, RequestDeliveryResponseHeader)
+	m.Status = getIntHeader(h, StatusHeader, base)
+	m.RequestDeliveryResponse = getIntHeader(h, RequestDeliveryResponseHeader, base)
 	m.IncludeSpans = getBoolHeader(h, IncludeSpansHeader)
 	m.Spans = getSpans(h)
+	m.Metadata = getMetadata(h)
 	m.ContentType = h.Get("Content-Type")
 	m.Accept = h.Get(AcceptHeader)
 	m.Path = h.Get(PathHeader)
+	m.Expires = getExpires(h, base, time.Now)
+	m.ChainID = h.Get(ChainIDHeader)
 
 	return
 }
 
+// HeaderTranslation configures how HTTP headers are translated into WRP message fields.  The zero value
+// translates headers exactly as SetMessageFromHeaders does.
+type HeaderTranslation struct {
+	// IntBase is the numeric base used to parse integer headers.  If unset, DefaultIntHeaderBase is used.
+	IntBase int
+
+	// AcceptFromHTTP indicates whether the standard HTTP Accept header is used as the message's Accept when
+	// AcceptHeader is absent.  A wildcard Accept of "*/*" is ignored, since it expresses no preference.
+	AcceptFromHTTP bool
+
+	// DefaultAccept is the fallback Accept for messages when no accept header applies, e.g. wrp.JSON.ContentType().
+	// If unset, such messages are left with an empty Accept.
+	DefaultAccept string
+
+	// PreserveUnknown indicates whether unknown headers, i.e. headers with UnknownHeaderPrefix that carry no WRP field
+	// known to this package, are captured by SetMessage and emitted again by AddHeaders.  This lets a proxy forward
+	// fields introduced by newer protocol versions.  Each captured value is appended to the message's Headers as
+	// "Name: value", where Name is the canonical header name including UnknownHeaderPrefix.  Entries of that form
+	// are the capture namespace, so they round trip through any number of hops.
+	PreserveUnknown bool
+
+	// MaxHeaderValues bounds the number of values collected into a message's Spans and, when PreserveUnknown is
+	// set, its Headers.  A request exceeding this bound is rejected with ErrTooManyHeaderValues before anything
+	// is collected.  If nonpositive, the number of values is unbounded.
+	MaxHeaderValues int
+
+	// MaxHeaderBytes bounds the total length of the values collected into a message's Spans and, when
+	// PreserveUnknown is set, its Headers.  Captured headers count the length of their names, too.  A request
+	// exceeding this bound is rejected with ErrHeaderValuesTooLarge before anything is collected.  If nonpositive,
+	// the total length is unbounded.
+	MaxHeaderBytes int
+}
+
+func (ht HeaderTranslation) intBase() int {
+	if ht.IntBase > 0 {
+		return ht.IntBase
+	}
+
+	return DefaultIntHeaderBase
+}
+
+// checkLimits verifies that the values SetMessage would collect from h are within this translation's bounds
+func (ht HeaderTranslation) checkLimits(h http.Header) error {
+	if ht.MaxHeaderValues <= 0 && ht.MaxHeaderBytes <= 0 {
+		return nil
+	}
+
+	var count, size int
+	collect := func(name string, values []string) error {
+		count += len(values)
+		if ht.MaxHeaderValues > 0 && count > ht.MaxHeaderValues {
+			return ErrTooManyHeaderValues
+		}
+
+		for _, value := range values {
+			size += len(name) + len(value)
+		}
+
+		if ht.MaxHeaderBytes > 0 && size > ht.MaxHeaderBytes {
+			return ErrHeaderValuesTooLarge
+		}
+
+		return nil
+	}
+
+	if err := collect("", h[SpanHeader]); err != nil {
+		return err
+	}
+
+	if ht.PreserveUnknown {
+		for name, values := range h {
+			if isUnknownHeader(http.CanonicalHeaderKey(name)) {
+				if err := collect(name, values); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// accept determines the Accept to use for a message whose AcceptHeader was absent
+func (ht HeaderTranslation) accept(h http.Header) string {
+	if ht.AcceptFromHTTP {
+		if value := strings.TrimSpace(h.Get("Accept")); len(value) > 0 && value != "*/*" {
+			return value
+		}
+	}
+
+	return ht.DefaultAccept
+}
+
+// SetMessage transfers header fields onto the given WRP message according to this translation.
+// As with SetMessageFromHeaders, the payload is not handled by this method.  A request whose values exceed
+// MaxHeaderValues or MaxHeaderBytes is rejected, leaving m unmodified.
+func (ht HeaderTranslation) SetMessage(h http.Header, m *wrp.Message) error {
+	if err := ht.checkLimits(h); err != nil {
+		return err
+	}
+
+	if err := SetMessageFromHeadersBase(h, m, ht.intBase()); err != nil {
+		return err
+	}
+
+	if len(m.Accept) == 0 {
+		m.Accept = ht.accept(h)
+	}
+
+	if ht.PreserveUnknown {
+		m.Headers = append(m.Headers, unknownHeaders(h)...)
+	}
+
+	return nil
+}
+
+// AddHeaders adds the HTTP header representation of a WRP message according to this translation.  This
+// method behaves like AddMessageHeaders, additionally emitting any unknown headers captured by SetMessage
+// when PreserveUnknown is set.
+func (ht HeaderTranslation) AddHeaders(h http.Header, m *wrp.Message) {
+	AddMessageHeaders(h, m)
+	if !ht.PreserveUnknown {
+		return
+	}
+
+	for _, entry := range m.Headers {
+		if name, value, ok := parseUnknownHeader(entry); ok {
+			h.Add(name, value)
+		}
+	}
+}
+
+// isUnknownHeader tests if the given canonical header name is in the capture namespace of PreserveUnknown
+func isUnknownHeader(name string) bool {
+	return strings.HasPrefix(name, UnknownHeaderPrefix) && !knownMessageHeaders[name]
+}
+
+// unknownHeaders returns the capture entries for the unknown headers in h.  Names are sorted, and each
+// header's values are kept in order, so that the result is deterministic.
+func unknownHeaders(h http.Header) []string {
+	var names []string
+	for name := range h {
+		if isUnknownHeader(http.CanonicalHeaderKey(name)) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	var entries []string
+	for _, name := range names {
+		for _, value := range h[name] {
+			entries = append(entries, http.CanonicalHeaderKey(name)+": "+value)
+		}
+	}
+
+	return entries
+}
+
+// parseUnknownHeader splits a capture entry of the form "Name: value".  The last return is false if the
+// entry is not of that form or does not name an unknown header.
+func parseUnknownHeader(entry string) (string, string, bool) {
+	i := strings.IndexByte(entry, ':')
+	if i < 0 {
+		return "", "", false
+	}
+
+	name := http.CanonicalHeaderKey(strings.TrimSpace(entry[:i]))
+	if !isUnknownHeader(name) {
+		return "", "", false
+	}
+
+	return name, strings.TrimSpace(entry[i+1:]), true
+}
+
 // AddMessageHeaders adds the HTTP header representation of a given WRP message.
 // This function does not handle the payload, to allow further headers to be written by
 // calling code.
@@ -206,6 +504,19 @@ func AddMessageHeaders(h http.Header, m *wrp.Message) {
 		h.Add(SpanHeader, strings.Join(s, ","))
 	}
 
+	if len(m.Metadata) > 0 {
+		// sort the keys so that the header order is deterministic
+		keys := make([]string, 0, len(m.Metadata))
+		for k := range m.Metadata {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+		for _, k := range keys {
+			h.Add(MetadataHeader, k+"="+m.Metadata[k])
+		}
+	}
+
 	if len(m.Accept) > 0 {
 		h.Set(AcceptHeader, m.Accept)
 	}
@@ -213,6 +524,57 @@ func AddMessageHeaders(h http.Header, m *wrp.Message) {
 	if len(m.Path) > 0 {
 		h.Set(PathHeader, m.Path)
 	}
+
+	if m.Expires != nil {
+		h.Set(ExpiresHeader, strconv.FormatInt(*m.Expires, 10))
+	}
+
+	if len(m.ChainID) > 0 {
+		h.Set(ChainIDHeader, m.ChainID)
+	}
+}
+
+// messageHeaders are all the headers that carry WRP message fields
+var messageHeaders = []string{
+	MessageTypeHeader,
+	TransactionUuidHeader,
+	StatusHeader,
+	RequestDeliveryResponseHeader,
+	IncludeSpansHeader,
+	SpanHeader,
+	PathHeader,
+	SourceHeader,
+	DestinationHeader,
+	AcceptHeader,
+	MetadataHeader,
+	ExpiresHeader,
+	TTLHeader,
+	ChainIDHeader,
+}
+
+// knownMessageHeaders is the set of canonical names in messageHeaders
+var knownMessageHeaders = make(map[string]bool, len(messageHeaders))
+
+func init() {
+	for _, name := range messageHeaders {
+		knownMessageHeaders[http.CanonicalHeaderKey(name)] = true
+	}
+}
+
+// SetHeaders writes the HTTP header representation of a WRP message onto an existing request, which is
+// useful when forwarding a message to another service.  Every header that carries a WRP field is removed
+// first, so values left over from a previous hop are replaced rather than duplicated.  Headers unrelated
+// to WRP are preserved.  As with AddMessageHeaders, the payload is not handled by this function.
+func SetHeaders(m *wrp.Message, r *http.Request) {
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+
+	for _, name := range messageHeaders {
+		r.Header.Del(name)
+	}
+
+	AddMessageHeaders(r.Header, m)
 }
 
 // ReadPayload extracts the payload from a reader, setting the appropriate
@@ -3,11 +3,14 @@ package wrphttp
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Comcast/webpa-common/wrp"
 	"github.com/stretchr/testify/assert"
@@ -60,8 +63,9 @@ func testNewMessageFromHeadersSuccess(t *testing.T) {
 						"foo, bar, moo",
 						"goo, gar, hoo",
 					},
-					AcceptHeader: []string{"application/json"},
-					PathHeader:   []string{"/foo/bar"},
+					AcceptHeader:   []string{"application/json"},
+					PathHeader:     []string{"/foo/bar"},
+					MetadataHeader: []string{"/boot-time=1234", "empty=", "equals=a=b"},
 				},
 				payload: nil,
 				expected: wrp.Message{
@@ -76,8 +80,9 @@ func testNewMessageFromHeadersSuccess(t *testing.T) {
 						{"foo", "bar", "moo"},
 						{"goo", "gar", "hoo"},
 					},
-					Accept: "application/json",
-					Path:   "/foo/bar",
+					Accept:   "application/json",
+					Path:     "/foo/bar",
+					Metadata: map[string]string{"/boot-time": "1234", "empty": "", "equals": "a=b"},
 				},
 			},
 			{
@@ -147,7 +152,14 @@ func testNewMessageFromHeadersBadIntHeader(t *testing.T, headerName string) {
 	)
 
 	assert.Nil(message)
-	assert.Error(err)
+	require.Error(t, err)
+
+	intHeaderError, ok := err.(*IntHeaderError)
+	require.True(t, ok)
+	assert.Equal(headerName, intHeaderError.Header)
+	assert.Equal("this is not a valid integer", intHeaderError.Value)
+	assert.Error(intHeaderError.Err)
+	assert.Contains(err.Error(), headerName)
 }
 
 func testNewMessageFromHeadersBadBoolHeader(t *testing.T, headerName string) {
@@ -180,6 +192,23 @@ func testNewMessageFromHeadersBadSpanHeader(t *testing.T) {
 	assert.Error(err)
 }
 
+func testNewMessageFromHeadersBadMetadataHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, value := range []string{"no equals sign", "=value"} {
+		message, err := NewMessageFromHeaders(
+			http.Header{
+				MessageTypeHeader: []string{wrp.SimpleEventMessageType.FriendlyName()},
+				MetadataHeader:    []string{value},
+			},
+			nil,
+		)
+
+		assert.Nil(message)
+		assert.Error(err)
+	}
+}
+
 func testNewMessageFromHeadersBadPayload(t *testing.T) {
 	var (
 		assert = assert.New(t)
@@ -208,6 +237,8 @@ func TestNewMessageFromHeaders(t *testing.T) {
 	t.Run("BadIntHeader", func(t *testing.T) {
 		testNewMessageFromHeadersBadIntHeader(t, StatusHeader)
 		testNewMessageFromHeadersBadIntHeader(t, RequestDeliveryResponseHeader)
+		testNewMessageFromHeadersBadIntHeader(t, ExpiresHeader)
+		testNewMessageFromHeadersBadIntHeader(t, TTLHeader)
 	})
 
 	t.Run("BadBoolHeader", func(t *testing.T) {
@@ -215,9 +246,287 @@ func TestNewMessageFromHeaders(t *testing.T) {
 	})
 
 	t.Run("BadSpanHeader", testNewMessageFromHeadersBadSpanHeader)
+	t.Run("BadMetadataHeader", testNewMessageFromHeadersBadMetadataHeader)
 	t.Run("BadPayload", testNewMessageFromHeadersBadPayload)
 }
 
+func TestParseIntHeader(t *testing.T) {
+	testData := []struct {
+		value    string
+		base     int
+		expected int64
+		invalid  bool
+	}{
+		{value: "123", base: 10, expected: 123},
+		{value: "007", base: 10, expected: 7},
+		{value: "-42", base: 10, expected: -42},
+		{value: "  200\t", base: 10, expected: 200},
+		{value: `"404"`, base: 10, expected: 404},
+		{value: ` " 503 " `, base: 10, expected: 503},
+		{value: "0x1F", base: 16, expected: 31},
+		{value: "-0X1f", base: 16, expected: -31},
+		{value: "ff", base: 16, expected: 255},
+		{value: "0x1F", base: 0, expected: 31},
+		{value: "0x1F", base: 10, invalid: true},
+		{value: "not a number", base: 10, invalid: true},
+		{value: `""`, base: 10, invalid: true},
+		{value: "12abc", base: 10, invalid: true},
+	}
+
+	for _, record := range testData {
+		t.Run(fmt.Sprintf("%q/%d", record.value, record.base), func(t *testing.T) {
+			assert := assert.New(t)
+			actual, err := ParseIntHeader(record.value, record.base)
+			if record.invalid {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+				assert.Equal(record.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSetMessageFromHeadersBase(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		message wrp.Message
+	)
+
+	require.NoError(SetMessageFromHeadersBase(
+		http.Header{
+			MessageTypeHeader:             []string{wrp.SimpleRequestResponseMessageType.FriendlyName()},
+			StatusHeader:                  []string{"0xC8"},
+			RequestDeliveryResponseHeader: []string{`"1"`},
+		},
+		&message,
+		16,
+	))
+
+	require.NotNil(message.Status)
+	assert.Equal(int64(200), *message.Status)
+	require.NotNil(message.RequestDeliveryResponse)
+	assert.Equal(int64(1), *message.RequestDeliveryResponse)
+
+	err := SetMessageFromHeadersBase(
+		http.Header{
+			MessageTypeHeader:             []string{wrp.SimpleRequestResponseMessageType.FriendlyName()},
+			StatusHeader:                  []string{"0xC8"},
+			RequestDeliveryResponseHeader: []string{"bad"},
+		},
+		&message,
+		10,
+	)
+
+	require.Error(err)
+	intHeaderError, ok := err.(*IntHeaderError)
+	require.True(ok)
+	assert.Equal(StatusHeader, intHeaderError.Header)
+}
+
+func TestHeaderTranslation(t *testing.T) {
+	testData := []struct {
+		translation HeaderTranslation
+		header      http.Header
+		expected    string
+	}{
+		{HeaderTranslation{}, http.Header{"Accept": []string{"application/json"}}, ""},
+		{HeaderTranslation{DefaultAccept: "application/msgpack"}, http.Header{}, "application/msgpack"},
+		{HeaderTranslation{DefaultAccept: "application/msgpack"}, http.Header{AcceptHeader: []string{"text/plain"}}, "text/plain"},
+		{HeaderTranslation{AcceptFromHTTP: true}, http.Header{"Accept": []string{"application/json"}}, "application/json"},
+		{HeaderTranslation{AcceptFromHTTP: true}, http.Header{"Accept": []string{"*/*"}}, ""},
+		{HeaderTranslation{AcceptFromHTTP: true, DefaultAccept: "application/msgpack"}, http.Header{"Accept": []string{"*/*"}}, "application/msgpack"},
+		{HeaderTranslation{AcceptFromHTTP: true, DefaultAccept: "application/msgpack"}, http.Header{"Accept": []string{"application/json"}}, "application/json"},
+		{
+			HeaderTranslation{AcceptFromHTTP: true, DefaultAccept: "application/msgpack"},
+			http.Header{"Accept": []string{"application/json"}, AcceptHeader: []string{"text/plain"}},
+			"text/plain",
+		},
+	}
+
+	for i, record := range testData {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				message wrp.Message
+			)
+
+			record.header.Set(MessageTypeHeader, wrp.SimpleEventMessageType.FriendlyName())
+			record.header.Set(StatusHeader, "200")
+			require.NoError(record.translation.SetMessage(record.header, &message))
+			assert.Equal(record.expected, message.Accept)
+			require.NotNil(message.Status)
+			assert.Equal(int64(200), *message.Status)
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		var message wrp.Message
+		assert.Error(t, HeaderTranslation{DefaultAccept: "application/json"}.SetMessage(http.Header{}, &message))
+		assert.Empty(t, message.Accept)
+	})
+}
+
+func testHeaderTranslationPreserveUnknownRoundTrip(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		translation = HeaderTranslation{PreserveUnknown: true}
+		message     wrp.Message
+
+		header = http.Header{
+			MessageTypeHeader:  []string{wrp.SimpleEventMessageType.FriendlyName()},
+			SourceHeader:       []string{"dns:gateway"},
+			"X-Xmidt-Priority": []string{"high"},
+			"X-Xmidt-Hop":      []string{"one", "two"},
+			"X-Unrelated":      []string{"ignored"},
+		}
+	)
+
+	require.NoError(translation.SetMessage(header, &message))
+	assert.Equal("dns:gateway", message.Source)
+	assert.Equal([]string{"X-Xmidt-Hop: one", "X-Xmidt-Hop: two", "X-Xmidt-Priority: high"}, message.Headers)
+
+	emitted := make(http.Header)
+	translation.AddHeaders(emitted, &message)
+	assert.Equal([]string{"one", "two"}, emitted["X-Xmidt-Hop"])
+	assert.Equal([]string{"high"}, emitted["X-Xmidt-Priority"])
+	assert.Equal([]string{"dns:gateway"}, emitted[SourceHeader])
+	assert.Empty(emitted.Get("X-Unrelated"))
+
+	// a second hop reproduces the same message
+	var forwarded wrp.Message
+	require.NoError(translation.SetMessage(emitted, &forwarded))
+	assert.Equal(message, forwarded)
+}
+
+func testHeaderTranslationPreserveUnknownDisabled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		message wrp.Message
+
+		header = http.Header{
+			MessageTypeHeader:  []string{wrp.SimpleEventMessageType.FriendlyName()},
+			"X-Xmidt-Priority": []string{"high"},
+		}
+	)
+
+	require.NoError(HeaderTranslation{}.SetMessage(header, &message))
+	assert.Empty(message.Headers)
+
+	// captured entries in a message are not emitted unless the translation preserves unknown headers
+	message.Headers = []string{"X-Xmidt-Priority: high"}
+	emitted := make(http.Header)
+	HeaderTranslation{}.AddHeaders(emitted, &message)
+	assert.Empty(emitted.Get("X-Xmidt-Priority"))
+}
+
+func testHeaderTranslationPreserveUnknownEntries(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		emitted = make(http.Header)
+		message = wrp.Message{
+			Type: wrp.SimpleEventMessageType,
+			Headers: []string{
+				"x-xmidt-priority:  high ",
+				"X-Xmidt-Source: spoofed",
+				"Content-Type: text/plain",
+				"not a header",
+			},
+		}
+	)
+
+	// only well-formed entries in the capture namespace are emitted, so known fields cannot be overridden
+	HeaderTranslation{PreserveUnknown: true}.AddHeaders(emitted, &message)
+	assert.Equal([]string{"high"}, emitted["X-Xmidt-Priority"])
+	assert.Empty(emitted.Get(SourceHeader))
+	assert.Empty(emitted.Get("Content-Type"))
+}
+
+func testHeaderTranslationLimitsWithin(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		translation = HeaderTranslation{PreserveUnknown: true, MaxHeaderValues: 3, MaxHeaderBytes: 100}
+		header      = http.Header{
+			MessageTypeHeader: []string{wrp.SimpleEventMessageType.FriendlyName()},
+			SpanHeader:        []string{"foo, bar, baz", "a, b, c"},
+			"X-Xmidt-Future":  []string{"value"},
+		}
+
+		message wrp.Message
+	)
+
+	require.NoError(translation.SetMessage(header, &message))
+	assert.Len(message.Spans, 2)
+	assert.Equal([]string{"X-Xmidt-Future: value"}, message.Headers)
+}
+
+func testHeaderTranslationLimitsExceeded(t *testing.T) {
+	manySpans := make([]string, 1000)
+	for i := range manySpans {
+		manySpans[i] = "foo, bar, baz"
+	}
+
+	testData := []struct {
+		translation HeaderTranslation
+		header      http.Header
+		expected    error
+	}{
+		{HeaderTranslation{MaxHeaderValues: 10}, http.Header{SpanHeader: manySpans}, ErrTooManyHeaderValues},
+		{HeaderTranslation{MaxHeaderBytes: 1024}, http.Header{SpanHeader: manySpans}, ErrHeaderValuesTooLarge},
+		{
+			HeaderTranslation{PreserveUnknown: true, MaxHeaderValues: 2},
+			http.Header{SpanHeader: []string{"foo, bar, baz"}, "X-Xmidt-Future": []string{"1", "2"}},
+			ErrTooManyHeaderValues,
+		},
+		{
+			HeaderTranslation{PreserveUnknown: true, MaxHeaderBytes: 20},
+			http.Header{"X-Xmidt-Future": []string{strings.Repeat("x", 10)}},
+			ErrHeaderValuesTooLarge,
+		},
+	}
+
+	for i, record := range testData {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				message wrp.Message
+			)
+
+			record.header.Set(MessageTypeHeader, wrp.SimpleEventMessageType.FriendlyName())
+			assert.Equal(record.expected, record.translation.SetMessage(record.header, &message))
+			assert.Empty(message.Spans)
+			assert.Empty(message.Headers)
+			assert.Equal(wrp.MessageType(0), message.Type)
+		})
+	}
+
+	// unknown headers are not collected, and so not counted, unless they are preserved
+	var message wrp.Message
+	assert.NoError(t, HeaderTranslation{MaxHeaderValues: 1}.SetMessage(
+		http.Header{
+			MessageTypeHeader: []string{wrp.SimpleEventMessageType.FriendlyName()},
+			"X-Xmidt-Future":  []string{"1", "2"},
+		},
+		&message,
+	))
+}
+
+func TestHeaderTranslationLimits(t *testing.T) {
+	t.Run("Within", testHeaderTranslationLimitsWithin)
+	t.Run("Exceeded", testHeaderTranslationLimitsExceeded)
+}
+
+func TestHeaderTranslationPreserveUnknown(t *testing.T) {
+	t.Run("RoundTrip", testHeaderTranslationPreserveUnknownRoundTrip)
+	t.Run("Disabled", testHeaderTranslationPreserveUnknownDisabled)
+	t.Run("Entries", testHeaderTranslationPreserveUnknownEntries)
+}
+
 func TestAddMessageHeaders(t *testing.T) {
 	var (
 		assert = assert.New(t)
@@ -250,6 +559,7 @@ func TestAddMessageHeaders(t *testing.T) {
 					Spans:                   [][]string{{"foo", "bar", "graar"}},
 					Accept:                  "application/json",
 					Path:                    "/foo/bar",
+					Metadata:                map[string]string{"b": "2", "a": "1"},
 				},
 				expected: http.Header{
 					MessageTypeHeader:             []string{wrp.SimpleRequestResponseMessageType.FriendlyName()},
@@ -262,6 +572,16 @@ func TestAddMessageHeaders(t *testing.T) {
 					SpanHeader:                    []string{"foo,bar,graar"},
 					AcceptHeader:                  []string{"application/json"},
 					PathHeader:                    []string{"/foo/bar"},
+					MetadataHeader:                []string{"a=1", "b=2"},
+				},
+			},
+			{
+				message: wrp.Message{
+					Type:     wrp.SimpleEventMessageType,
+					Metadata: map[string]string{},
+				},
+				expected: http.Header{
+					MessageTypeHeader: []string{wrp.SimpleEventMessageType.FriendlyName()},
 				},
 			},
 		}
@@ -275,6 +595,137 @@ func TestAddMessageHeaders(t *testing.T) {
 	}
 }
 
+func TestSetHeaders(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		request = httptest.NewRequest("POST", "/forward", nil)
+
+		status  int64 = 200
+		message       = wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			TransactionUUID: "current",
+			Source:          "dns:source.example.com",
+			Destination:     "mac:112233445566",
+			Status:          &status,
+			Spans:           [][]string{{"current", "1", "2"}},
+			Metadata:        map[string]string{"fresh": "yes"},
+		}
+	)
+
+	// stale values from a previous hop, along with headers unrelated to WRP
+	request.Header.Set(MessageTypeHeader, wrp.SimpleEventMessageType.FriendlyName())
+	request.Header.Set(TransactionUuidHeader, "stale")
+	request.Header.Add(SpanHeader, "stale,3,4")
+	request.Header.Add(SpanHeader, "older,5,6")
+	request.Header.Add(MetadataHeader, "stale=true")
+	request.Header.Set(PathHeader, "/stale")
+	request.Header.Set(TTLHeader, "30")
+	request.Header.Set("Authorization", "Bearer token")
+	request.Header.Set("X-Custom", "preserved")
+
+	SetHeaders(&message, request)
+
+	expected := make(http.Header)
+	AddMessageHeaders(expected, &message)
+	expected.Set("Authorization", "Bearer token")
+	expected.Set("X-Custom", "preserved")
+	assert.Equal(expected, request.Header)
+
+	// the headers round-trip to the same message
+	actual := new(wrp.Message)
+	require.NoError(SetMessageFromHeaders(request.Header, actual))
+	assert.Equal(message, *actual)
+
+	// a request without a header map is also supported
+	bare := &http.Request{}
+	SetHeaders(&message, bare)
+	assert.Equal(message.TransactionUUID, bare.Header.Get(TransactionUuidHeader))
+}
+
+func TestMessageHeadersExpires(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		expires = time.Unix(1900000000, 0)
+	)
+
+	header := make(http.Header)
+	AddMessageHeaders(header, &wrp.Message{Type: wrp.SimpleEventMessageType})
+	assert.Empty(header.Get(ExpiresHeader))
+
+	// round trip
+	AddMessageHeaders(header, (&wrp.Message{Type: wrp.SimpleEventMessageType}).SetExpires(expires))
+	assert.Equal("1900000000", header.Get(ExpiresHeader))
+
+	actual, err := NewMessageFromHeaders(header, nil)
+	require.NoError(err)
+	require.NotNil(actual.Expires)
+	assert.Equal(expires.Unix(), *actual.Expires)
+
+	// an HTTP date
+	header.Set(ExpiresHeader, expires.UTC().Format(http.TimeFormat))
+	actual, err = NewMessageFromHeaders(header, nil)
+	require.NoError(err)
+	require.NotNil(actual.Expires)
+	assert.Equal(expires.Unix(), *actual.Expires)
+
+	// an absolute expiry takes precedence over a TTL
+	header.Set(TTLHeader, "30")
+	actual, err = NewMessageFromHeaders(header, nil)
+	require.NoError(err)
+	assert.Equal(expires.Unix(), *actual.Expires)
+
+	// a TTL is relative to the time of translation
+	header.Del(ExpiresHeader)
+	before := time.Now()
+	actual, err = NewMessageFromHeaders(header, nil)
+	after := time.Now()
+	require.NoError(err)
+	require.NotNil(actual.Expires)
+	assert.True(*actual.Expires >= before.Add(30*time.Second).Unix())
+	assert.True(*actual.Expires <= after.Add(30*time.Second).Unix())
+}
+
+func TestMessageHeadersChainID(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		header  = make(http.Header)
+	)
+
+	AddMessageHeaders(header, &wrp.Message{Type: wrp.SimpleRequestResponseMessageType})
+	assert.Empty(header.Get(ChainIDHeader))
+
+	AddMessageHeaders(header, &wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "1234", ChainID: "config-sync"})
+	assert.Equal("config-sync", header.Get(ChainIDHeader))
+
+	actual, err := NewMessageFromHeaders(header, nil)
+	require.NoError(err)
+	assert.Equal("config-sync", actual.ChainID)
+}
+
+func TestMessageHeadersMetadataRoundTrip(t *testing.T) {
+	testData := []map[string]string{
+		nil,
+		{"/boot-time": "1234"},
+		{"/boot-time": "1234", "/trust": "", "equation": "x=y"},
+	}
+
+	for _, metadata := range testData {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			header  = make(http.Header)
+		)
+
+		AddMessageHeaders(header, &wrp.Message{Type: wrp.SimpleEventMessageType, Metadata: metadata})
+		actual, err := NewMessageFromHeaders(header, nil)
+		require.NoError(err)
+		assert.Equal(metadata, actual.Metadata)
+	}
+}
+
 func testWritePayloadEmptyPayload(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,149 @@
+package wrphttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/Comcast/webpa-common/wrp"
+)
+
+const (
+	// MessagePartName is the form name of the multipart part carrying the encoded WRP message.  This part
+	// must come first, and its Content-Type selects the WRP format.
+	MessagePartName = "wrp"
+
+	// PayloadPartName is the form name of the multipart part carrying the message's payload.  This part must
+	// follow the message part, and its contents are the raw payload bytes.
+	PayloadPartName = "payload"
+
+	// MaxMessagePartSize is the largest message part, in bytes, accepted by DecodeMultipartRequest.  The message
+	// part is only the WRP envelope, as the payload travels in its own part, so it is never large.
+	MaxMessagePartSize = 64 * 1024
+)
+
+var (
+	// ErrMissingMessagePart is returned by DecodeMultipartRequest when the first part is not the MessagePartName part
+	ErrMissingMessagePart = errors.New("The multipart request did not begin with a WRP message part")
+
+	// ErrMissingPayloadPart is returned by DecodeMultipartRequest when no PayloadPartName part follows the message
+	ErrMissingPayloadPart = errors.New("The multipart request did not contain a payload part")
+
+	// ErrMessagePartTooLarge is returned by DecodeMultipartRequest when the message part exceeds MaxMessagePartSize
+	ErrMessagePartTooLarge = errors.New("The multipart WRP message part is too large")
+
+	// ErrPayloadTooLarge is returned by MultipartRequest.BindPayload when the payload exceeds the given limit
+	ErrPayloadTooLarge = errors.New("The multipart payload is too large")
+)
+
+// MultipartRequest is a WRP message decoded from a multipart HTTP request, whose payload is carried in its own part
+// rather than in the encoded message.  The payload is streamed from the request body, so it is never held in memory
+// unless BindPayload is used.
+type MultipartRequest struct {
+	// Message is the decoded WRP message.  Its Payload is empty unless BindPayload has been called.
+	Message *wrp.Message
+
+	// Format is the WRP format of the message part
+	Format wrp.Format
+
+	payload *multipart.Part
+}
+
+// Payload returns a reader over the payload part, which reads directly from the HTTP request body.  The payload
+// can be read only once, and only while the request body is open.
+func (mr *MultipartRequest) Payload() io.Reader {
+	return mr.payload
+}
+
+// BindPayload reads the payload part into Message.Payload.  At most limit bytes are read, and ErrPayloadTooLarge
+// is returned if the payload is larger, which protects servers from buffering huge uploads.  A nonpositive limit
+// reads the entire payload.  Large payloads, such as firmware images, should instead be streamed with Payload.
+func (mr *MultipartRequest) BindPayload(limit int64) error {
+	var (
+		contents []byte
+		err      error
+	)
+
+	if limit > 0 {
+		contents, err = ioutil.ReadAll(io.LimitReader(mr.payload, limit+1))
+		if err == nil && int64(len(contents)) > limit {
+			return ErrPayloadTooLarge
+		}
+	} else {
+		contents, err = ioutil.ReadAll(mr.payload)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	mr.Message.Payload = contents
+	return nil
+}
+
+// DecodeMultipartRequest parses a multipart HTTP request, such as a multipart/form-data upload, that carries a WRP
+// message in its MessagePartName part and the message's payload in a subsequent PayloadPartName part.  Other parts
+// between the two are skipped.  The message part must use a WRP Content-Type, or a *ContentTypeError is returned.
+// If the message has no ContentType, the payload part's Content-Type is used.
+//
+// This function reads only as far as the start of the payload, and the message part may be at most MaxMessagePartSize
+// bytes or ErrMessagePartTooLarge is returned.  The returned MultipartRequest streams the payload
+// from the request body, so this function is appropriate for uploads much too large to hold in memory.  Use
+// DecodeRequestBody for requests whose body is a single encoded message.
+func DecodeMultipartRequest(r *http.Request) (*MultipartRequest, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, &ContentTypeError{ContentType: r.Header.Get("Content-Type")}
+	}
+
+	part, err := reader.NextPart()
+	if err == io.EOF || (err == nil && part.FormName() != MessagePartName) {
+		return nil, ErrMissingMessagePart
+	} else if err != nil {
+		return nil, err
+	}
+
+	contentType := part.Header.Get("Content-Type")
+	format, err := wrp.FormatFromContentType(contentType)
+	if err != nil {
+		return nil, &ContentTypeError{ContentType: contentType}
+	}
+
+	var encoded bytes.Buffer
+	if _, err := encoded.ReadFrom(io.LimitReader(part, MaxMessagePartSize+1)); err != nil {
+		return nil, err
+	} else if encoded.Len() > MaxMessagePartSize {
+		return nil, ErrMessagePartTooLarge
+	}
+
+	message := new(wrp.Message)
+	if err := bodyDecoders[format].Decode(message, encoded.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err = reader.NextPart()
+		if err == io.EOF {
+			return nil, ErrMissingPayloadPart
+		} else if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == PayloadPartName {
+			break
+		}
+	}
+
+	if len(message.ContentType) == 0 {
+		message.ContentType = part.Header.Get("Content-Type")
+	}
+
+	return &MultipartRequest{
+		Message: message,
+		Format:  format,
+		payload: part,
+	}, nil
+}
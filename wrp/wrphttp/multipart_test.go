@@ -0,0 +1,180 @@
+package wrphttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/Comcast/webpa-common/wrp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// multipartPart describes a single part written by newMultipartRequest
+type multipartPart struct {
+	name        string
+	contentType string
+	contents    []byte
+}
+
+func newMultipartRequest(t *testing.T, parts ...multipartPart) *http.Request {
+	var (
+		require = require.New(t)
+		body    bytes.Buffer
+		writer  = multipart.NewWriter(&body)
+	)
+
+	for _, p := range parts {
+		header := textproto.MIMEHeader{"Content-Disposition": []string{`form-data; name="` + p.name + `"`}}
+		if len(p.contentType) > 0 {
+			header.Set("Content-Type", p.contentType)
+		}
+
+		w, err := writer.CreatePart(header)
+		require.NoError(err)
+		_, err = w.Write(p.contents)
+		require.NoError(err)
+	}
+
+	require.NoError(writer.Close())
+	request := httptest.NewRequest("POST", "/upload", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func testDecodeMultipartRequestSuccess(t *testing.T) {
+	for _, f := range wrp.AllFormats() {
+		t.Run(f.String(), func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				message = wrp.Message{Type: wrp.SimpleEventMessageType, Source: "dns:uploader", Destination: "mac:112233445566"}
+				payload = bytes.Repeat([]byte("firmware"), 1024)
+
+				request = newMultipartRequest(t,
+					multipartPart{MessagePartName, f.ContentType(), wrp.MustEncode(&message, f)},
+					multipartPart{"ignored", "text/plain", []byte("ignored")},
+					multipartPart{PayloadPartName, "application/octet-stream", payload},
+				)
+			)
+
+			mr, err := DecodeMultipartRequest(request)
+			require.NoError(err)
+			require.NotNil(mr)
+			assert.Equal(f, mr.Format)
+			assert.Equal("application/octet-stream", mr.Message.ContentType)
+			assert.Empty(mr.Message.Payload)
+
+			streamed, err := ioutil.ReadAll(mr.Payload())
+			require.NoError(err)
+			assert.Equal(payload, streamed)
+		})
+	}
+}
+
+func testDecodeMultipartRequestBindPayload(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		message = wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566", ContentType: "text/plain"}
+		encoded = wrp.MustEncode(&message, wrp.Msgpack)
+	)
+
+	mr, err := DecodeMultipartRequest(newMultipartRequest(t,
+		multipartPart{MessagePartName, wrp.Msgpack.ContentType(), encoded},
+		multipartPart{PayloadPartName, "application/octet-stream", []byte("small")},
+	))
+
+	require.NoError(err)
+	require.NoError(mr.BindPayload(5))
+	assert.Equal([]byte("small"), mr.Message.Payload)
+
+	// the message's own content type takes precedence over the payload part's
+	assert.Equal("text/plain", mr.Message.ContentType)
+
+	mr, err = DecodeMultipartRequest(newMultipartRequest(t,
+		multipartPart{MessagePartName, wrp.Msgpack.ContentType(), encoded},
+		multipartPart{PayloadPartName, "", []byte("too large")},
+	))
+
+	require.NoError(err)
+	assert.Equal(ErrPayloadTooLarge, mr.BindPayload(5))
+	assert.Empty(mr.Message.Payload)
+
+	mr, err = DecodeMultipartRequest(newMultipartRequest(t,
+		multipartPart{MessagePartName, wrp.Msgpack.ContentType(), encoded},
+		multipartPart{PayloadPartName, "", []byte("unlimited")},
+	))
+
+	require.NoError(err)
+	require.NoError(mr.BindPayload(0))
+	assert.Equal([]byte("unlimited"), mr.Message.Payload)
+}
+
+func testDecodeMultipartRequestErrors(t *testing.T) {
+	var (
+		event   = wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType}, wrp.Msgpack)
+		payload = multipartPart{PayloadPartName, "", []byte("payload")}
+	)
+
+	testData := []struct {
+		name          string
+		request       *http.Request
+		expectedError error
+	}{
+		{"NoParts", newMultipartRequest(t), ErrMissingMessagePart},
+		{"PayloadFirst", newMultipartRequest(t, payload, multipartPart{MessagePartName, wrp.Msgpack.ContentType(), event}), ErrMissingMessagePart},
+		{"NoPayload", newMultipartRequest(t, multipartPart{MessagePartName, wrp.Msgpack.ContentType(), event}), ErrMissingPayloadPart},
+		{"MessageTooLarge", newMultipartRequest(t, multipartPart{MessagePartName, wrp.Msgpack.ContentType(), make([]byte, MaxMessagePartSize+1)}, payload), ErrMessagePartTooLarge},
+	}
+
+	for _, record := range testData {
+		t.Run(record.name, func(t *testing.T) {
+			mr, err := DecodeMultipartRequest(record.request)
+			assert.Nil(t, mr)
+			assert.Equal(t, record.expectedError, err)
+		})
+	}
+
+	t.Run("NotMultipart", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+			request = httptest.NewRequest("POST", "/upload", bytes.NewReader(event))
+		)
+
+		request.Header.Set("Content-Type", wrp.Msgpack.ContentType())
+		mr, err := DecodeMultipartRequest(request)
+		assert.Nil(mr)
+		require.IsType(new(ContentTypeError), err)
+		assert.Equal(wrp.Msgpack.ContentType(), err.(*ContentTypeError).ContentType)
+	})
+
+	t.Run("MessageContentType", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+		)
+
+		mr, err := DecodeMultipartRequest(newMultipartRequest(t, multipartPart{MessagePartName, "text/plain", event}, payload))
+		assert.Nil(mr)
+		require.IsType(new(ContentTypeError), err)
+		assert.Equal("text/plain", err.(*ContentTypeError).ContentType)
+	})
+
+	t.Run("InvalidMessage", func(t *testing.T) {
+		mr, err := DecodeMultipartRequest(newMultipartRequest(t, multipartPart{MessagePartName, wrp.JSON.ContentType(), []byte("this is not JSON")}, payload))
+		assert.Nil(t, mr)
+		assert.Error(t, err)
+	})
+}
+
+func TestDecodeMultipartRequest(t *testing.T) {
+	t.Run("Success", testDecodeMultipartRequestSuccess)
+	t.Run("BindPayload", testDecodeMultipartRequestBindPayload)
+	t.Run("Errors", testDecodeMultipartRequestErrors)
+}
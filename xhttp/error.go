@@ -2,9 +2,20 @@ package xhttp
 
 import (
 	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
+// MsgpackContentType is the media type of msgpack error bodies written by WriteNegotiatedError
+const MsgpackContentType = "application/msgpack"
+
+var errorMsgpackHandle codec.MsgpackHandle
+
 // Error is an HTTP-specific carrier of error information.  In addition to implementing error,
 // this type also implements go-kit's StatusCoder and Headerer.  The json.Marshaler interface
 // is implemented so that the default go-kit error encoder will always emit a JSON message.
@@ -12,14 +23,30 @@ type Error struct {
 	Code   int
 	Header http.Header
 	Text   string
+
+	// RetryAfter, if positive, is how long clients should wait before retrying, as with a 429 or 503.  It is
+	// conveyed both as a Retry-After header and as retryAfterSeconds in the body, rounded up to whole seconds.
+	RetryAfter time.Duration
 }
 
 func (e *Error) StatusCode() int {
 	return e.Code
 }
 
+// Headers returns the Header of this error along with the Retry-After header, if RetryAfter is set.
+// The Header field itself is never modified.
 func (e *Error) Headers() http.Header {
-	return e.Header
+	if e.RetryAfter <= 0 {
+		return e.Header
+	}
+
+	header := make(http.Header, len(e.Header)+1)
+	for name, values := range e.Header {
+		header[name] = values
+	}
+
+	header.Set("Retry-After", strconv.Itoa(retryAfterSeconds(e.RetryAfter)))
+	return header
 }
 
 func (e *Error) Error() string {
@@ -27,9 +54,27 @@ func (e *Error) Error() string {
 }
 
 func (e *Error) MarshalJSON() ([]byte, error) {
+	if e.RetryAfter > 0 {
+		return []byte(fmt.Sprintf(`{"code": %d, "text": "%s", "retryAfterSeconds": %d}`, e.Code, e.Text, retryAfterSeconds(e.RetryAfter))), nil
+	}
+
 	return []byte(fmt.Sprintf(`{"code": %d, "text": "%s"}`, e.Code, e.Text)), nil
 }
 
+// retryAfterSeconds converts a retry interval into whole seconds, rounding up so that clients never retry early
+func retryAfterSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// retryAfter returns the RetryAfter of an *Error value, or zero for any other value
+func retryAfter(value interface{}) time.Duration {
+	if e, ok := value.(*Error); ok && e.RetryAfter > 0 {
+		return e.RetryAfter
+	}
+
+	return 0
+}
+
 // WriteErrorf provides printf-style functionality for writing out the results of some operation.
 // The response status code is set to code, and a JSON message of the form {"code": %d, "message": "%s"} is
 // written as the response body.  fmt.Sprintf is used to turn the format and parameters into a single string
@@ -51,8 +96,35 @@ func WriteErrorf(response http.ResponseWriter, code int, format string, paramete
 
 // WriteError provides print-style functionality for writing a JSON message as a response.  No format parameters
 // are used.  The value parameter is subjected to the default stringizing rules of the fmt package.
+//
+// As a special case, a *MultiError value is written using its structured JSON form, with the given code.
+// An *Error value with a RetryAfter also sets the Retry-After header and adds retryAfterSeconds to the message.
 func WriteError(response http.ResponseWriter, code int, value interface{}) (int, error) {
 	response.Header().Set("Content-Type", "application/json")
+	if multiError, ok := value.(*MultiError); ok {
+		body, err := multiError.marshalJSON(code)
+		if err != nil {
+			return 0, err
+		}
+
+		response.WriteHeader(code)
+		return response.Write(body)
+	}
+
+	if d := retryAfter(value); d > 0 {
+		seconds := retryAfterSeconds(d)
+		response.Header().Set("Retry-After", strconv.Itoa(seconds))
+		response.WriteHeader(code)
+
+		return fmt.Fprintf(
+			response,
+			`{"code": %d, "message": "%s", "retryAfterSeconds": %d}`,
+			code,
+			value,
+			seconds,
+		)
+	}
+
 	response.WriteHeader(code)
 
 	return fmt.Fprintf(
@@ -62,3 +134,86 @@ func WriteError(response http.ResponseWriter, code int, value interface{}) (int,
 		value,
 	)
 }
+
+// WriteNegotiatedError is like WriteError, but honors the request's Accept header.  When the client prefers
+// msgpack, via MsgpackContentType or application/x-msgpack, the body is a msgpack map with the same
+// structure as the JSON message written by WriteError.  Otherwise, including when the Accept header is absent
+// or */*, this function behaves exactly like WriteError.
+func WriteNegotiatedError(response http.ResponseWriter, request *http.Request, code int, value interface{}) (int, error) {
+	if !prefersMsgpack(request.Header.Get("Accept")) {
+		return WriteError(response, code, value)
+	}
+
+	var body interface{}
+	if multiError, ok := value.(*MultiError); ok {
+		errors := multiError.Errors
+		if errors == nil {
+			errors = []FieldError{}
+		}
+
+		body = struct {
+			Code   int          `codec:"code"`
+			Errors []FieldError `codec:"errors"`
+		}{code, errors}
+	} else if d := retryAfter(value); d > 0 {
+		seconds := retryAfterSeconds(d)
+		response.Header().Set("Retry-After", strconv.Itoa(seconds))
+		body = struct {
+			Code              int    `codec:"code"`
+			Message           string `codec:"message"`
+			RetryAfterSeconds int    `codec:"retryAfterSeconds"`
+		}{code, fmt.Sprintf("%s", value), seconds}
+	} else {
+		body = struct {
+			Code    int    `codec:"code"`
+			Message string `codec:"message"`
+		}{code, fmt.Sprintf("%s", value)}
+	}
+
+	var output []byte
+	if err := codec.NewEncoderBytes(&output, &errorMsgpackHandle).Encode(body); err != nil {
+		return 0, err
+	}
+
+	response.Header().Set("Content-Type", MsgpackContentType)
+	response.WriteHeader(code)
+	return response.Write(output)
+}
+
+// prefersMsgpack examines an Accept header to determine if msgpack is preferred over JSON.  The media range
+// with the highest quality wins, with ties going to the first range listed.  Wildcards select JSON.
+func prefersMsgpack(accept string) bool {
+	var (
+		msgpack     bool
+		bestQuality = 0.0
+	)
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		var isMsgpack bool
+		switch mediaType {
+		case MsgpackContentType, "application/x-msgpack":
+			isMsgpack = true
+		case "application/json", "application/*", "*/*":
+		default:
+			continue
+		}
+
+		if quality > bestQuality {
+			msgpack, bestQuality = isMsgpack, quality
+		}
+	}
+
+	return msgpack
+}
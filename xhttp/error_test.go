@@ -6,10 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	gokithttp "github.com/go-kit/kit/transport/http"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
 )
 
 func testErrorState(t *testing.T) {
@@ -46,9 +48,33 @@ func testErrorDefaultEncoding(t *testing.T) {
 	)
 }
 
+func testErrorRetryAfter(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		header    = http.Header{"Foo": []string{"Bar"}}
+		httpError = &Error{Code: 429, Header: header, Text: "slow down", RetryAfter: 1500 * time.Millisecond}
+		response  = httptest.NewRecorder()
+	)
+
+	// partial seconds round up, and the Header field is left untouched
+	assert.Equal(http.Header{"Foo": []string{"Bar"}, "Retry-After": []string{"2"}}, httpError.Headers())
+	assert.Equal(http.Header{"Foo": []string{"Bar"}}, header)
+
+	json, err := httpError.MarshalJSON()
+	assert.NoError(err)
+	assert.JSONEq(`{"code": 429, "text": "slow down", "retryAfterSeconds": 2}`, string(json))
+
+	gokithttp.DefaultErrorEncoder(context.Background(), httpError, response)
+	assert.Equal(429, response.Code)
+	assert.Equal("2", response.HeaderMap.Get("Retry-After"))
+	assert.Equal("Bar", response.HeaderMap.Get("Foo"))
+	assert.JSONEq(`{"code": 429, "text": "slow down", "retryAfterSeconds": 2}`, response.Body.String())
+}
+
 func TestError(t *testing.T) {
 	t.Run("State", testErrorState)
 	t.Run("DefaultEncoding", testErrorDefaultEncoding)
+	t.Run("RetryAfter", testErrorRetryAfter)
 }
 
 func TestWriteErrorf(t *testing.T) {
@@ -117,6 +143,16 @@ func TestWriteError(t *testing.T) {
 				"",
 				`{"code": 567, "message": ""}`,
 			},
+			{
+				http.StatusServiceUnavailable,
+				&Error{Text: "no retry"},
+				`{"code": 503, "message": "no retry"}`,
+			},
+			{
+				http.StatusTooManyRequests,
+				&Error{Text: "slow down", RetryAfter: time.Minute},
+				`{"code": 429, "message": "slow down", "retryAfterSeconds": 60}`,
+			},
 		}
 	)
 
@@ -133,9 +169,120 @@ func TestWriteError(t *testing.T) {
 		assert.Equal(record.code, response.Code)
 		assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
 
+		if httpError, ok := record.value.(*Error); ok && httpError.RetryAfter > 0 {
+			assert.Equal("60", response.HeaderMap.Get("Retry-After"))
+		} else {
+			assert.Empty(response.HeaderMap.Get("Retry-After"))
+		}
+
 		actualJSON, err := ioutil.ReadAll(response.Body)
 		require.NoError(err)
 
 		assert.JSONEq(record.expectedJSON, string(actualJSON))
 	}
 }
+
+func testWriteNegotiatedErrorJSON(t *testing.T) {
+	for _, accept := range []string{"", "*/*", "application/json", "text/html", "application/msgpack;q=0.5, application/json", "garbage;;"} {
+		t.Run(accept, func(t *testing.T) {
+			var (
+				assert   = assert.New(t)
+				require  = require.New(t)
+				request  = httptest.NewRequest("GET", "/", nil)
+				response = httptest.NewRecorder()
+			)
+
+			if len(accept) > 0 {
+				request.Header.Set("Accept", accept)
+			}
+
+			count, err := WriteNegotiatedError(response, request, 503, "expected message")
+			assert.True(count > 0)
+			assert.NoError(err)
+			assert.Equal(503, response.Code)
+			assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+
+			actualJSON, err := ioutil.ReadAll(response.Body)
+			require.NoError(err)
+			assert.JSONEq(`{"code": 503, "message": "expected message"}`, string(actualJSON))
+		})
+	}
+}
+
+func testWriteNegotiatedErrorMsgpack(t *testing.T) {
+	for _, accept := range []string{"application/msgpack", "application/x-msgpack", "application/json;q=0.2, application/msgpack", "*/*;q=0.1, application/msgpack"} {
+		t.Run(accept, func(t *testing.T) {
+			var (
+				assert   = assert.New(t)
+				require  = require.New(t)
+				request  = httptest.NewRequest("GET", "/", nil)
+				response = httptest.NewRecorder()
+				actual   map[string]interface{}
+			)
+
+			request.Header.Set("Accept", accept)
+			count, err := WriteNegotiatedError(response, request, 503, "expected message")
+			assert.True(count > 0)
+			assert.NoError(err)
+			assert.Equal(503, response.Code)
+			assert.Equal(MsgpackContentType, response.HeaderMap.Get("Content-Type"))
+
+			require.NoError(codec.NewDecoderBytes(response.Body.Bytes(), new(codec.MsgpackHandle)).Decode(&actual))
+			assert.EqualValues(503, actual["code"])
+			assert.Equal("expected message", string(actual["message"].([]byte)))
+		})
+	}
+}
+
+func testWriteNegotiatedErrorMultiError(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		require    = require.New(t)
+		request    = httptest.NewRequest("GET", "/", nil)
+		response   = httptest.NewRecorder()
+		multiError = new(MultiError)
+
+		actual struct {
+			Code   int          `codec:"code"`
+			Errors []FieldError `codec:"errors"`
+		}
+	)
+
+	multiError.Add("name", "is required")
+	request.Header.Set("Accept", MsgpackContentType)
+
+	_, err := WriteNegotiatedError(response, request, 400, multiError)
+	require.NoError(err)
+	assert.Equal(400, response.Code)
+
+	require.NoError(codec.NewDecoderBytes(response.Body.Bytes(), new(codec.MsgpackHandle)).Decode(&actual))
+	assert.Equal(400, actual.Code)
+	assert.Equal([]FieldError{{Field: "name", Message: "is required"}}, actual.Errors)
+}
+
+func testWriteNegotiatedErrorRetryAfter(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		request  = httptest.NewRequest("GET", "/", nil)
+		response = httptest.NewRecorder()
+		actual   map[string]interface{}
+	)
+
+	request.Header.Set("Accept", MsgpackContentType)
+	_, err := WriteNegotiatedError(response, request, 429, &Error{Text: "slow down", RetryAfter: 30 * time.Second})
+	require.NoError(err)
+	assert.Equal(429, response.Code)
+	assert.Equal("30", response.HeaderMap.Get("Retry-After"))
+
+	require.NoError(codec.NewDecoderBytes(response.Body.Bytes(), new(codec.MsgpackHandle)).Decode(&actual))
+	assert.EqualValues(30, actual["retryAfterSeconds"])
+	assert.Equal("slow down", string(actual["message"].([]byte)))
+}
+
+func TestWriteNegotiatedError(t *testing.T) {
+	t.Run("JSON", testWriteNegotiatedErrorJSON)
+	t.Run("Msgpack", testWriteNegotiatedErrorMsgpack)
+	t.Run("MultiError", testWriteNegotiatedErrorMultiError)
+	t.Run("RetryAfter", testWriteNegotiatedErrorRetryAfter)
+}
@@ -0,0 +1,80 @@
+package xhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// FieldError describes a problem with a single field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// MultiError is an HTTP-specific carrier of several field errors, typically gathered while validating a request.
+// Like Error, this type implements go-kit's StatusCoder and Headerer along with json.Marshaler, so the default
+// go-kit error encoder emits a JSON message of the form {"code": 400, "errors": [{"field": "...", "message": "..."}]}.
+//
+// The zero value is ready to use.  If Code is unset, http.StatusBadRequest is used.
+type MultiError struct {
+	Code   int
+	Header http.Header
+	Errors []FieldError
+}
+
+// Add appends a field error to this instance
+func (e *MultiError) Add(field, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: message})
+}
+
+// HasErrors tests if any field errors have been added.  Validation code typically returns this
+// instance only when this method returns true.
+func (e *MultiError) HasErrors() bool {
+	return len(e.Errors) > 0
+}
+
+func (e *MultiError) StatusCode() int {
+	if e.Code > 0 {
+		return e.Code
+	}
+
+	return http.StatusBadRequest
+}
+
+func (e *MultiError) Headers() http.Header {
+	return e.Header
+}
+
+// Error returns each field error, separated by semicolons
+func (e *MultiError) Error() string {
+	var output bytes.Buffer
+	for i, fe := range e.Errors {
+		if i > 0 {
+			output.WriteString("; ")
+		}
+
+		output.WriteString(fe.Field)
+		output.WriteString(": ")
+		output.WriteString(fe.Message)
+	}
+
+	return output.String()
+}
+
+// marshalJSON produces the JSON representation of this instance using the given status code
+func (e *MultiError) marshalJSON(code int) ([]byte, error) {
+	errors := e.Errors
+	if errors == nil {
+		errors = []FieldError{}
+	}
+
+	return json.Marshal(struct {
+		Code   int          `json:"code"`
+		Errors []FieldError `json:"errors"`
+	}{code, errors})
+}
+
+func (e *MultiError) MarshalJSON() ([]byte, error) {
+	return e.marshalJSON(e.StatusCode())
+}
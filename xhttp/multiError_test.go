@@ -0,0 +1,92 @@
+package xhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gokithttp "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func testMultiErrorEmpty(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		multiError = new(MultiError)
+	)
+
+	assert.False(multiError.HasErrors())
+	assert.Equal(http.StatusBadRequest, multiError.StatusCode())
+	assert.Nil(multiError.Headers())
+	assert.Empty(multiError.Error())
+
+	json, err := multiError.MarshalJSON()
+	assert.NoError(err)
+	assert.JSONEq(`{"code": 400, "errors": []}`, string(json))
+}
+
+func testMultiErrorState(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		multiError = &MultiError{Code: 422, Header: http.Header{"Foo": []string{"Bar"}}}
+	)
+
+	multiError.Add("source", "missing source")
+	multiError.Add("path", `bad "path"`)
+
+	assert.True(multiError.HasErrors())
+	assert.Equal(422, multiError.StatusCode())
+	assert.Equal(http.Header{"Foo": []string{"Bar"}}, multiError.Headers())
+	assert.Equal(`source: missing source; path: bad "path"`, multiError.Error())
+
+	json, err := multiError.MarshalJSON()
+	assert.NoError(err)
+	assert.JSONEq(
+		`{"code": 422, "errors": [{"field": "source", "message": "missing source"}, {"field": "path", "message": "bad \"path\""}]}`,
+		string(json),
+	)
+}
+
+func testMultiErrorDefaultEncoding(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		multiError = &MultiError{Header: http.Header{"Foo": []string{"Bar"}}}
+		response   = httptest.NewRecorder()
+	)
+
+	multiError.Add("status", "not a number")
+	gokithttp.DefaultErrorEncoder(context.Background(), multiError, response)
+	assert.Equal(http.StatusBadRequest, response.Code)
+	assert.Equal("Bar", response.HeaderMap.Get("Foo"))
+	assert.JSONEq(
+		`{"code": 400, "errors": [{"field": "status", "message": "not a number"}]}`,
+		response.Body.String(),
+	)
+}
+
+func testMultiErrorWriteError(t *testing.T) {
+	var (
+		assert     = assert.New(t)
+		multiError = new(MultiError)
+		response   = httptest.NewRecorder()
+	)
+
+	multiError.Add("path", "missing path")
+	count, err := WriteError(response, http.StatusUnprocessableEntity, multiError)
+	assert.NoError(err)
+	assert.Equal(response.Body.Len(), count)
+	assert.Equal(http.StatusUnprocessableEntity, response.Code)
+	assert.Equal("application/json", response.HeaderMap.Get("Content-Type"))
+	assert.JSONEq(
+		`{"code": 422, "errors": [{"field": "path", "message": "missing path"}]}`,
+		response.Body.String(),
+	)
+}
+
+func TestMultiError(t *testing.T) {
+	t.Run("Empty", testMultiErrorEmpty)
+	t.Run("State", testMultiErrorState)
+	t.Run("DefaultEncoding", testMultiErrorDefaultEncoding)
+	t.Run("WriteError", testMultiErrorWriteError)
+}